@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+type benchOptions struct {
+	url          string
+	model        string
+	apiKey       string
+	concurrency  int
+	requests     int
+	promptTokens int
+	maxTokens    int
+	cold         bool
+}
+
+type benchResult struct {
+	err          error
+	latency      time.Duration
+	ttft         time.Duration
+	outputTokens int
+}
+
+type benchReport struct {
+	requests    int
+	errors      int
+	wallTime    time.Duration
+	latencies   []time.Duration
+	ttfts       []time.Duration
+	tokens      int
+	coldTTFT    time.Duration
+	hasColdTTFT bool
+}
+
+// runBench implements `llmsnap bench`. It drives streaming chat completion
+// requests through a running llmsnap and reports latency and throughput.
+func runBench(args []string, out io.Writer) error {
+	opts := benchOptions{}
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	fs.SetOutput(out)
+	fs.StringVar(&opts.url, "url", "http://localhost:8080", "base URL of llmsnap")
+	fs.StringVar(&opts.model, "model", "", "model to benchmark (required)")
+	fs.StringVar(&opts.apiKey, "api-key", "", "API key sent as a Bearer token")
+	fs.IntVar(&opts.concurrency, "concurrency", 1, "number of concurrent requests")
+	fs.IntVar(&opts.requests, "requests", 10, "total number of requests to send")
+	fs.IntVar(&opts.promptTokens, "prompt-tokens", 128, "approximate number of prompt tokens per request")
+	fs.IntVar(&opts.maxTokens, "max-tokens", 128, "max_tokens to generate per request")
+	fs.BoolVar(&opts.cold, "cold", false, "unload the model first and measure the time to load it")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if opts.model == "" {
+		return errors.New("--model is required")
+	}
+	if opts.concurrency < 1 || opts.requests < 1 || opts.promptTokens < 1 || opts.maxTokens < 1 {
+		return errors.New("--concurrency, --requests, --prompt-tokens and --max-tokens must be greater than 0")
+	}
+	opts.url = strings.TrimSuffix(opts.url, "/")
+
+	client := &http.Client{}
+	report := benchReport{requests: opts.requests}
+
+	if opts.cold {
+		if err := benchUnload(client, opts); err != nil {
+			return fmt.Errorf("unable to unload %s: %w", opts.model, err)
+		}
+		result := benchRequest(client, opts)
+		if result.err != nil {
+			return fmt.Errorf("cold start request failed: %w", result.err)
+		}
+		report.coldTTFT = result.ttft
+		report.hasColdTTFT = true
+	}
+
+	jobs := make(chan struct{})
+	results := make(chan benchResult, opts.requests)
+	var wg sync.WaitGroup
+
+	start := time.Now()
+	for i := 0; i < opts.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range jobs {
+				results <- benchRequest(client, opts)
+			}
+		}()
+	}
+	for i := 0; i < opts.requests; i++ {
+		jobs <- struct{}{}
+	}
+	close(jobs)
+	wg.Wait()
+	close(results)
+	report.wallTime = time.Since(start)
+
+	for result := range results {
+		if result.err != nil {
+			report.errors++
+			fmt.Fprintf(out, "request error: %v\n", result.err)
+			continue
+		}
+		report.latencies = append(report.latencies, result.latency)
+		report.ttfts = append(report.ttfts, result.ttft)
+		report.tokens += result.outputTokens
+	}
+
+	report.write(out)
+	return nil
+}
+
+// benchUnload unloads only the model under test, other models on the server
+// keep running
+func benchUnload(client *http.Client, opts benchOptions) error {
+	req, err := http.NewRequest(http.MethodPost, opts.url+"/api/models/unload/"+url.PathEscape(opts.model), nil)
+	if err != nil {
+		return err
+	}
+	if opts.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+opts.apiKey)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// benchRequest sends a single streaming chat completion and measures it
+func benchRequest(client *http.Client, opts benchOptions) benchResult {
+	payload, err := json.Marshal(map[string]any{
+		"model":      opts.model,
+		"max_tokens": opts.maxTokens,
+		"stream":     true,
+		"stream_options": map[string]any{
+			"include_usage": true,
+		},
+		"messages": []map[string]string{
+			{"role": "user", "content": strings.Repeat("hello ", opts.promptTokens)},
+		},
+	})
+	if err != nil {
+		return benchResult{err: err}
+	}
+
+	req, err := http.NewRequest(http.MethodPost, opts.url+"/v1/chat/completions", bytes.NewReader(payload))
+	if err != nil {
+		return benchResult{err: err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if opts.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+opts.apiKey)
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return benchResult{err: err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return benchResult{err: fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))}
+	}
+
+	result := benchResult{}
+	chunks := 0
+	usageTokens := 0
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, found := strings.CutPrefix(scanner.Text(), "data:")
+		if !found {
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			break
+		}
+
+		if tokens := gjson.Get(data, "usage.completion_tokens"); tokens.Exists() {
+			usageTokens = int(tokens.Int())
+		}
+
+		delta := gjson.Get(data, "choices.0.delta")
+		if delta.Get("content").String() == "" && delta.Get("reasoning_content").String() == "" {
+			continue
+		}
+		if chunks == 0 {
+			result.ttft = time.Since(start)
+		}
+		chunks++
+	}
+	if err := scanner.Err(); err != nil {
+		return benchResult{err: err}
+	}
+
+	result.latency = time.Since(start)
+	result.outputTokens = chunks
+	if usageTokens > 0 {
+		result.outputTokens = usageTokens
+	}
+	return result
+}
+
+// percentile returns the p-th percentile (0-100) of sorted durations
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(float64(len(sorted)-1) * p / 100)
+	return sorted[idx]
+}
+
+func (r benchReport) write(out io.Writer) {
+	slices.Sort(r.latencies)
+	slices.Sort(r.ttfts)
+
+	fmt.Fprintf(out, "requests:       %d (%d errors)\n", r.requests, r.errors)
+	fmt.Fprintf(out, "wall time:      %v\n", r.wallTime.Round(time.Millisecond))
+	fmt.Fprintf(out, "latency:        p50 %v  p90 %v  p99 %v\n",
+		percentile(r.latencies, 50).Round(time.Millisecond),
+		percentile(r.latencies, 90).Round(time.Millisecond),
+		percentile(r.latencies, 99).Round(time.Millisecond))
+	fmt.Fprintf(out, "time to first:  p50 %v  p90 %v  p99 %v\n",
+		percentile(r.ttfts, 50).Round(time.Millisecond),
+		percentile(r.ttfts, 90).Round(time.Millisecond),
+		percentile(r.ttfts, 99).Round(time.Millisecond))
+
+	if r.wallTime > 0 {
+		fmt.Fprintf(out, "output tokens:  %d (%.2f tok/s)\n", r.tokens, float64(r.tokens)/r.wallTime.Seconds())
+	}
+
+	if r.hasColdTTFT {
+		overhead := max(r.coldTTFT-percentile(r.ttfts, 50), 0)
+		fmt.Fprintf(out, "swap overhead:  %v (cold time to first token %v)\n",
+			overhead.Round(time.Millisecond), r.coldTTFT.Round(time.Millisecond))
+	}
+}
+
+func benchMain(args []string) {
+	if err := runBench(args, os.Stdout); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Printf("Error: %v\n", err)
+		}
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBench_Percentile(t *testing.T) {
+	sorted := []time.Duration{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	assert.Equal(t, time.Duration(5), percentile(sorted, 50))
+	assert.Equal(t, time.Duration(9), percentile(sorted, 90))
+	assert.Equal(t, time.Duration(10), percentile(sorted, 100))
+	assert.Equal(t, time.Duration(0), percentile(nil, 50))
+}
+
+func TestBench_Run(t *testing.T) {
+	var unloads, completions atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/models/unload/m":
+			unloads.Add(1)
+		case "/v1/chat/completions":
+			completions.Add(1)
+			w.Header().Set("Content-Type", "text/event-stream")
+			for i := 0; i < 3; i++ {
+				fmt.Fprintf(w, "data: {\"choices\":[{\"delta\":{\"content\":\"tok\"}}]}\n\n")
+			}
+			fmt.Fprintf(w, "data: {\"choices\":[],\"usage\":{\"completion_tokens\":3}}\n\n")
+			fmt.Fprintf(w, "data: [DONE]\n\n")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	// nothing is unloaded unless asked for
+	out := &bytes.Buffer{}
+	err := runBench([]string{"--url", srv.URL, "--model", "m", "--concurrency", "2", "--requests", "4"}, out)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(0), unloads.Load())
+	assert.Equal(t, int32(4), completions.Load())
+	assert.Contains(t, out.String(), "requests:       4 (0 errors)")
+	assert.Contains(t, out.String(), "output tokens:  12")
+	assert.NotContains(t, out.String(), "swap overhead:")
+
+	// --cold unloads only the model under test
+	out.Reset()
+	completions.Store(0)
+	err = runBench([]string{"--url", srv.URL, "--model", "m", "--requests", "4", "--cold"}, out)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), unloads.Load())
+	assert.Equal(t, int32(5), completions.Load(), "cold start request plus 4 benchmark requests")
+	assert.Contains(t, out.String(), "swap overhead:")
+}
+
+func TestBench_RequiresModel(t *testing.T) {
+	err := runBench([]string{}, &bytes.Buffer{})
+	assert.ErrorContains(t, err, "--model is required")
+}
@@ -102,9 +102,15 @@ func main() {
 				time.Sleep(wait)
 			}
 
+			// echo the request ID like servers that trace requests do
+			if id := c.GetHeader("X-Request-ID"); id != "" {
+				c.Header("X-Request-ID", id)
+			}
+
 			c.JSON(http.StatusOK, gin.H{
 				"responseMessage":  *responseMessage,
 				"h_content_length": c.Request.Header.Get("Content-Length"),
+				"h_request_id":     c.GetHeader("X-Request-ID"),
 				"request_body":     string(bodyBytes),
 				"usage": gin.H{
 					"completion_tokens": 10,
@@ -0,0 +1,153 @@
+//go:build wasip1
+
+// wasm-filter is an example filter for the wasmFilters of a model. It uses
+// the body calls of the proxy-wasm ABI that llmsnap implements, build it
+// with:
+//
+//	GOOS=wasip1 GOARCH=wasm go build -buildmode=c-shared -o filter.wasm ./cmd/wasm-filter
+//
+// Its configuration is JSON: strip lists the top level keys removed from
+// requests, maxTokens rejects requests asking for more tokens and
+// fingerprint is written to the system_fingerprint of responses.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"unsafe"
+)
+
+const (
+	bufferRequestBody         = 0
+	bufferResponseBody        = 1
+	bufferPluginConfiguration = 7
+
+	actionContinue = 0
+	statusOK       = 0
+)
+
+type filterConfig struct {
+	Strip       []string `json:"strip"`
+	MaxTokens   float64  `json:"maxTokens"`
+	Fingerprint string   `json:"fingerprint"`
+}
+
+var config filterConfig
+
+// allocations keeps the memory handed to the host until the call is done
+var allocations [][]byte
+
+//go:wasmimport env proxy_log
+func proxyLog(level uint32, message unsafe.Pointer, size uint32) uint32
+
+//go:wasmimport env proxy_get_buffer_bytes
+func proxyGetBufferBytes(bufferType, start, maxSize uint32, data, size unsafe.Pointer) uint32
+
+//go:wasmimport env proxy_set_buffer_bytes
+func proxySetBufferBytes(bufferType, start, maxSize uint32, data unsafe.Pointer, size uint32) uint32
+
+//go:wasmimport env proxy_send_local_response
+func proxySendLocalResponse(status uint32, details unsafe.Pointer, detailsSize uint32, body unsafe.Pointer, bodySize uint32, headers unsafe.Pointer, headersSize uint32, grpcStatus int32) uint32
+
+func main() {}
+
+//go:wasmexport proxy_on_memory_allocate
+func onMemoryAllocate(size uint32) unsafe.Pointer {
+	buf := make([]byte, max(size, 1))
+	allocations = append(allocations, buf)
+	return unsafe.Pointer(&buf[0])
+}
+
+//go:wasmexport proxy_on_context_create
+func onContextCreate(contextID, rootContextID uint32) {}
+
+//go:wasmexport proxy_on_done
+func onDone(contextID uint32) uint32 {
+	allocations = nil
+	return 1
+}
+
+//go:wasmexport proxy_on_configure
+func onConfigure(rootContextID, size uint32) uint32 {
+	data, ok := getBuffer(bufferPluginConfiguration, size)
+	if !ok || len(data) == 0 {
+		return 1
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		logf("invalid configuration: %v", err)
+		return 0
+	}
+	return 1
+}
+
+//go:wasmexport proxy_on_request_body
+func onRequestBody(contextID, size, endOfStream uint32) uint32 {
+	body, ok := getObject(bufferRequestBody, size)
+	if !ok {
+		return actionContinue
+	}
+
+	if maxTokens, ok := body["max_tokens"].(float64); ok && config.MaxTokens > 0 && maxTokens > config.MaxTokens {
+		message := []byte(fmt.Sprintf(`{"error":"max_tokens must be at most %v"}`, config.MaxTokens))
+		proxySendLocalResponse(400, nil, 0, unsafe.Pointer(&message[0]), uint32(len(message)), nil, 0, -1)
+		return actionContinue
+	}
+
+	for _, key := range config.Strip {
+		delete(body, key)
+	}
+	setObject(bufferRequestBody, size, body)
+	return actionContinue
+}
+
+//go:wasmexport proxy_on_response_body
+func onResponseBody(contextID, size, endOfStream uint32) uint32 {
+	if config.Fingerprint == "" {
+		return actionContinue
+	}
+	body, ok := getObject(bufferResponseBody, size)
+	if !ok {
+		return actionContinue
+	}
+	body["system_fingerprint"] = config.Fingerprint
+	setObject(bufferResponseBody, size, body)
+	return actionContinue
+}
+
+func getBuffer(bufferType, size uint32) ([]byte, bool) {
+	var data unsafe.Pointer
+	var length uint32
+	if proxyGetBufferBytes(bufferType, 0, size, unsafe.Pointer(&data), unsafe.Pointer(&length)) != statusOK {
+		return nil, false
+	}
+	if length == 0 {
+		return nil, true
+	}
+	return unsafe.Slice((*byte)(data), length), true
+}
+
+func getObject(bufferType, size uint32) (map[string]any, bool) {
+	data, ok := getBuffer(bufferType, size)
+	if !ok {
+		return nil, false
+	}
+	var body map[string]any
+	if err := json.Unmarshal(data, &body); err != nil {
+		return nil, false
+	}
+	return body, true
+}
+
+func setObject(bufferType, size uint32, body map[string]any) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		logf("unable to encode body: %v", err)
+		return
+	}
+	proxySetBufferBytes(bufferType, 0, size, unsafe.Pointer(&data[0]), uint32(len(data)))
+}
+
+func logf(format string, args ...any) {
+	message := []byte(fmt.Sprintf(format, args...))
+	proxyLog(3, unsafe.Pointer(&message[0]), uint32(len(message)))
+}
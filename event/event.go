@@ -100,6 +100,10 @@ func Subscribe[T Event](broker *Dispatcher, handler func(T)) context.CancelFunc
 
 // SubscribeTo subscribes to an event with the specified event type.
 func SubscribeTo[T Event](broker *Dispatcher, eventType uint32, handler func(T)) context.CancelFunc {
+	return subscribeTo(broker, eventType, handler, Options[T]{})
+}
+
+func subscribeTo[T Event](broker *Dispatcher, eventType uint32, handler func(T), opts Options[T]) context.CancelFunc {
 	if broker.isClosed() {
 		panic(errClosed)
 	}
@@ -110,7 +114,7 @@ func SubscribeTo[T Event](broker *Dispatcher, eventType uint32, handler func(T))
 	// Check if group already exists
 	if existing := broker.findGroup(eventType); existing != nil {
 		grp := groupOf[T](eventType, existing)
-		sub := grp.Add(handler)
+		sub := grp.Add(handler, opts)
 		return func() {
 			grp.Del(sub)
 		}
@@ -118,7 +122,7 @@ func SubscribeTo[T Event](broker *Dispatcher, eventType uint32, handler func(T))
 
 	// Create new group
 	grp := &group[T]{cond: sync.NewCond(new(sync.Mutex)), maxQueue: broker.maxQueue}
-	sub := grp.Add(handler)
+	sub := grp.Add(handler, opts)
 
 	// Copy-on-write: insert new entry in sorted position
 	old := broker.subs.Load()
@@ -181,8 +185,18 @@ func groupOf[T Event](eventType uint32, subs any) *group[T] {
 
 // consumer represents a consumer with a message queue
 type consumer[T Event] struct {
-	queue []T  // Current work queue
-	stop  bool // Stop signal
+	queue        []T            // Current work queue
+	stop         bool           // Stop signal
+	filter       func(T) bool   // Optional filter, nil accepts everything
+	maxQueue     int            // Maximum queue size
+	overflow     OverflowPolicy // What to do when the queue is full
+	onDisconnect func()         // Called when disconnected for being slow
+	dropped      int            // Number of events dropped
+}
+
+// accepts returns whether the event passes the consumer's filter
+func (s *consumer[T]) accepts(ev T) bool {
+	return s.filter == nil || s.filter(ev)
 }
 
 // Listen listens to the event queue and processes events
@@ -223,8 +237,18 @@ func (s *consumer[T]) Listen(c *sync.Cond, fn func(T)) {
 type group[T Event] struct {
 	cond     *sync.Cond
 	subs     []*consumer[T]
-	maxQueue int // Maximum queue size per consumer
-	maxLen   int // Current maximum queue length across all consumers
+	maxQueue int // Default maximum queue size per consumer
+}
+
+// blocked returns whether a consumer that applies backpressure has a full
+// queue and wants the event
+func (s *group[T]) blocked(ev T) bool {
+	for _, sub := range s.subs {
+		if sub.overflow == OverflowBlock && len(sub.queue) >= sub.maxQueue && sub.accepts(ev) {
+			return true
+		}
+	}
+	return false
 }
 
 // Broadcast sends an event to all consumers
@@ -232,43 +256,53 @@ func (s *group[T]) Broadcast(ev T) {
 	s.cond.L.Lock()
 	defer s.cond.L.Unlock()
 
-	// Calculate current maximum queue length
-	s.maxLen = 0
-	for _, sub := range s.subs {
-		if len(sub.queue) > s.maxLen {
-			s.maxLen = len(sub.queue)
-		}
-	}
-
 	// Backpressure: wait if queues are full
-	for s.maxLen >= s.maxQueue {
+	for s.blocked(ev) {
 		s.cond.Wait()
+	}
+
+	// Add event to all queues, slow consumers that do not apply
+	// backpressure lose the event or their subscription
+	for i := 0; i < len(s.subs); {
+		sub := s.subs[i]
+		if !sub.accepts(ev) {
+			i++
+			continue
+		}
 
-		// Recalculate after wakeup
-		s.maxLen = 0
-		for _, sub := range s.subs {
-			if len(sub.queue) > s.maxLen {
-				s.maxLen = len(sub.queue)
+		if len(sub.queue) >= sub.maxQueue {
+			switch sub.overflow {
+			case OverflowDrop:
+				sub.dropped++
+				i++
+				continue
+			case OverflowDisconnect:
+				sub.stop = true
+				s.subs = append(s.subs[:i], s.subs[i+1:]...)
+				if sub.onDisconnect != nil {
+					go sub.onDisconnect()
+				}
+				continue
 			}
 		}
-	}
 
-	// Add event to all queues and track new maximum
-	newMax := 0
-	for _, sub := range s.subs {
 		sub.queue = append(sub.queue, ev)
-		if len(sub.queue) > newMax {
-			newMax = len(sub.queue)
-		}
+		i++
 	}
-	s.maxLen = newMax
 	s.cond.Broadcast() // Wake consumers
 }
 
 // Add adds a subscriber to the list
-func (s *group[T]) Add(handler func(T)) *consumer[T] {
+func (s *group[T]) Add(handler func(T), opts Options[T]) *consumer[T] {
 	sub := &consumer[T]{
-		queue: make([]T, 0, 64),
+		queue:        make([]T, 0, 64),
+		filter:       opts.Filter,
+		maxQueue:     s.maxQueue,
+		overflow:     opts.Overflow,
+		onDisconnect: opts.OnDisconnect,
+	}
+	if opts.MaxQueue > 0 {
+		sub.maxQueue = opts.MaxQueue
 	}
 
 	// Add the consumer to the list of active consumers
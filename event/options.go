@@ -0,0 +1,50 @@
+package event
+
+import (
+	"context"
+)
+
+// OverflowPolicy decides what happens when a subscriber's queue is full
+type OverflowPolicy int
+
+const (
+	// OverflowBlock makes publishers wait until the subscriber catches up
+	OverflowBlock OverflowPolicy = iota
+
+	// OverflowDrop drops new events for the slow subscriber only
+	OverflowDrop
+
+	// OverflowDisconnect unsubscribes the slow subscriber
+	OverflowDisconnect
+)
+
+// Options configures a single subscription
+type Options[T Event] struct {
+	// Filter is called by the publisher and only events it returns true
+	// for are queued. It must be fast and must not block.
+	Filter func(T) bool
+
+	// MaxQueue is the queue size of this subscriber, 0 uses the
+	// dispatcher's queue size
+	MaxQueue int
+
+	// Overflow is what happens when the queue is full
+	Overflow OverflowPolicy
+
+	// OnDisconnect is called once when the subscriber is unsubscribed
+	// because of OverflowDisconnect
+	OnDisconnect func()
+}
+
+// SubscribeWithOptions subscribes to an event like Subscribe() with per
+// subscriber filtering and slow subscriber protection.
+func SubscribeWithOptions[T Event](broker *Dispatcher, handler func(T), opts Options[T]) context.CancelFunc {
+	var event T
+	return subscribeTo(broker, event.Type(), handler, opts)
+}
+
+// OnWithOptions works like SubscribeWithOptions() but uses the default
+// dispatcher instead.
+func OnWithOptions[T Event](handler func(T), opts Options[T]) context.CancelFunc {
+	return SubscribeWithOptions(Default, handler, opts)
+}
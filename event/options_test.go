@@ -0,0 +1,103 @@
+package event
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubscribeWithOptions_Filter(t *testing.T) {
+	d := NewDispatcher()
+
+	var sum int64
+	done := make(chan struct{})
+	defer SubscribeWithOptions(d, func(ev MyEvent1) {
+		if atomic.AddInt64(&sum, int64(ev.Number)) == 6 {
+			close(done)
+		}
+	}, Options[MyEvent1]{
+		Filter: func(ev MyEvent1) bool { return ev.Number%2 == 0 },
+	})()
+
+	for i := 1; i <= 4; i++ {
+		Publish(d, MyEvent1{Number: i})
+	}
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for events")
+	}
+	assert.Equal(t, int64(6), atomic.LoadInt64(&sum))
+}
+
+func TestSubscribeWithOptions_OverflowDrop(t *testing.T) {
+	d := NewDispatcher()
+
+	// a subscriber that is stuck in its handler
+	stall := make(chan struct{})
+	var slowCount int64
+	defer SubscribeWithOptions(d, func(ev MyEvent1) {
+		<-stall
+		atomic.AddInt64(&slowCount, 1)
+	}, Options[MyEvent1]{MaxQueue: 2, Overflow: OverflowDrop})()
+
+	var fastCount int64
+	defer Subscribe(d, func(ev MyEvent1) {
+		atomic.AddInt64(&fastCount, 1)
+	})()
+
+	published := make(chan struct{})
+	go func() {
+		for i := 0; i < 100; i++ {
+			Publish(d, MyEvent1{Number: i})
+		}
+		close(published)
+	}()
+
+	select {
+	case <-published:
+	case <-time.After(time.Second):
+		t.Fatal("publisher was blocked by a slow subscriber")
+	}
+
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt64(&fastCount) == 100
+	}, time.Second, 5*time.Millisecond)
+
+	close(stall)
+	assert.Eventually(t, func() bool {
+		count := atomic.LoadInt64(&slowCount)
+		return count > 0 && count < 100
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestSubscribeWithOptions_OverflowDisconnect(t *testing.T) {
+	d := NewDispatcher()
+
+	stall := make(chan struct{})
+	defer close(stall)
+
+	disconnected := make(chan struct{})
+	defer SubscribeWithOptions(d, func(ev MyEvent1) {
+		<-stall
+	}, Options[MyEvent1]{
+		MaxQueue:     2,
+		Overflow:     OverflowDisconnect,
+		OnDisconnect: func() { close(disconnected) },
+	})()
+	assert.Equal(t, 1, d.count(TypeEvent1))
+
+	for i := 0; i < 10; i++ {
+		Publish(d, MyEvent1{Number: i})
+	}
+
+	select {
+	case <-disconnected:
+	case <-time.After(time.Second):
+		t.Fatal("slow subscriber was not disconnected")
+	}
+	assert.Equal(t, 0, d.count(TypeEvent1))
+}
@@ -5,6 +5,7 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -17,6 +18,7 @@ import (
 	"github.com/napmany/llmsnap/event"
 	"github.com/napmany/llmsnap/proxy"
 	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/napmany/llmsnap/server"
 )
 
 var (
@@ -26,6 +28,20 @@ var (
 )
 
 func main() {
+	// subcommands
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		benchMain(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		replayMain(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		validateMain(os.Args[2:])
+		return
+	}
+
 	// Define a command-line flag for the port
 	configPath := flag.String("config", "config.yaml", "config file name")
 	listenStr := flag.String("listen", "", "listen ip/port")
@@ -33,6 +49,7 @@ func main() {
 	keyFile := flag.String("tls-key-file", "", "TLS key file")
 	showVersion := flag.Bool("version", false, "show version of build")
 	watchConfig := flag.Bool("watch-config", false, "Automatically reload config file on change")
+	chaosMode := flag.Bool("chaos", false, "inject the faults set in the chaos config, for testing clients")
 
 	flag.Parse() // Parse the command-line flags
 
@@ -79,48 +96,48 @@ func main() {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
+	// Listen on the sockets systemd passed, or on listenStr
+	listeners, err := systemdListeners()
+	if err != nil {
+		fmt.Printf("Error using systemd sockets: %v\n", err)
+		os.Exit(1)
+	}
+	if len(listeners) > 0 {
+		*listenStr = listeners[0].Addr().String()
+	} else {
+		listener, err := net.Listen("tcp", *listenStr)
+		if err != nil {
+			fmt.Printf("Error listening on %s: %v\n", *listenStr, err)
+			os.Exit(1)
+		}
+		listeners = append(listeners, listener)
+	}
+
 	// Create server with initial handler
 	srv := &http.Server{
 		Addr: *listenStr,
 	}
 
-	// Support for watching config and reloading when it changes
-	reloadProxyManager := func() {
-		if currentPM, ok := srv.Handler.(*proxy.ProxyManager); ok {
-			conf, err = config.LoadConfig(*configPath)
-			if err != nil {
-				fmt.Printf("Warning, unable to reload configuration: %v\n", err)
-				return
-			}
-
-			fmt.Println("Configuration Changed")
-			currentPM.Shutdown()
-			newPM := proxy.New(conf)
-			newPM.SetVersion(date, commit, version)
-			srv.Handler = newPM
-			fmt.Println("Configuration Reloaded")
-
-			// wait a few seconds and tell any UI to reload
-			time.AfterFunc(3*time.Second, func() {
-				event.Emit(proxy.ConfigFileChangedEvent{
-					ReloadingState: proxy.ReloadingStateEnd,
-				})
-			})
-		} else {
-			conf, err = config.LoadConfig(*configPath)
-			if err != nil {
-				fmt.Printf("Error, unable to load configuration: %v\n", err)
-				os.Exit(1)
-			}
-			newPM := proxy.New(conf)
-			newPM.SetVersion(date, commit, version)
-			srv.Handler = newPM
+	// Support for watching config and reloading when it changes, on SIGHUP
+	// and on POST /api/config/reload. Models that did not change keep running.
+	llmsnap := server.New(conf,
+		server.WithConfigFile(*configPath),
+		server.WithVersion(date, commit, version),
+		server.WithChaos(*chaosMode),
+		server.WithMDNS(*listenStr, useTLS),
+	)
+	srv.Handler = llmsnap
+	reloadConfig := func() {
+		sdNotify(sdReloading())
+		defer sdNotify("READY=1")
+		if err := llmsnap.ReloadFile(); err != nil {
+			fmt.Printf("Warning, unable to reload configuration: %v\n", err)
+			return
 		}
+		fmt.Println("Configuration Reloaded")
 	}
 
-	// load the initial proxy manager
-	reloadProxyManager()
-	debouncedReload := debounce(time.Second, reloadProxyManager)
+	debouncedReload := debounce(time.Second, reloadConfig)
 	if *watchConfig {
 		defer event.On(func(e proxy.ConfigFileChangedEvent) {
 			if e.ReloadingState == proxy.ReloadingStateStart {
@@ -170,18 +187,25 @@ func main() {
 		}()
 	}
 
+	// reload on SIGHUP
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+	go func() {
+		for range hupChan {
+			fmt.Println("Received SIGHUP, reloading configuration")
+			reloadConfig()
+		}
+	}()
+
 	// shutdown on signal
 	go func() {
 		sig := <-sigChan
 		fmt.Printf("Received signal %v, shutting down...\n", sig)
+		sdNotify("STOPPING=1")
 		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
 		defer cancel()
 
-		if pm, ok := srv.Handler.(*proxy.ProxyManager); ok {
-			pm.Shutdown()
-		} else {
-			fmt.Println("srv.Handler is not of type *proxy.ProxyManager")
-		}
+		llmsnap.Shutdown()
 
 		if err := srv.Shutdown(ctx); err != nil {
 			fmt.Printf("Server shutdown error: %v\n", err)
@@ -190,19 +214,29 @@ func main() {
 	}()
 
 	// Start server
-	go func() {
-		var err error
-		if useTLS {
-			fmt.Printf("llmsnap listening with TLS on https://%s\n", *listenStr)
-			err = srv.ListenAndServeTLS(*certFile, *keyFile)
-		} else {
-			fmt.Printf("llmsnap listening on http://%s\n", *listenStr)
-			err = srv.ListenAndServe()
-		}
-		if err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Fatal server error: %v\n", err)
-		}
-	}()
+	for _, listener := range listeners {
+		go func() {
+			var err error
+			if useTLS {
+				fmt.Printf("llmsnap listening with TLS on https://%s\n", listener.Addr())
+				err = srv.ServeTLS(listener, *certFile, *keyFile)
+			} else {
+				fmt.Printf("llmsnap listening on http://%s\n", listener.Addr())
+				err = srv.Serve(listener)
+			}
+			if err != nil && err != http.ErrServerClosed {
+				log.Fatalf("Fatal server error: %v\n", err)
+			}
+		}()
+	}
+
+	// tell systemd llmsnap is up when it runs as a Type=notify service
+	if err := sdNotify("READY=1"); err != nil {
+		fmt.Printf("Warning, systemd notification failed: %v\n", err)
+	}
+	if interval := sdWatchdogInterval(); interval > 0 {
+		go sdWatchdog(interval, exitChan)
+	}
 
 	// Wait for exit signal
 	<-exitChan
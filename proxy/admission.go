@@ -0,0 +1,318 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/napmany/llmsnap/proxy/config"
+)
+
+// retry hint in seconds sent with 503 responses when a model is not admitted
+const admissionRetryAfter = 30
+
+// holdsVRAM reports if a process in this state is using GPU memory
+func holdsVRAM(state ProcessState) bool {
+	switch state {
+	case StateReady, StateStarting, StateWaking:
+		return true
+	default:
+		return false
+	}
+}
+
+// evictedBySwap returns the processes holding VRAM that are stopped or put
+// to sleep when modelID is swapped in: the instances of the members of its
+// swap group it does not require, with a weight budget only the ones idled
+// to make room for it, and the ones of other groups when its group is
+// exclusive
+func (pm *ProxyManager) evictedBySwap(modelID string) []*Process {
+	processGroup := pm.findGroupByModelName(modelID)
+	if processGroup == nil {
+		return nil
+	}
+
+	var evicted []*Process
+	if processGroup.swap {
+		var members []*Process
+		if processGroup.weightBudget > 0 {
+			if !processGroup.resident(modelID) {
+				members = processGroup.roomFor(modelID)
+			}
+		} else {
+			keep := append([]string{modelID}, pm.config.Requirements(modelID)...)
+			for memberID, process := range processGroup.processes {
+				if !slices.Contains(keep, memberID) {
+					members = append(members, process)
+				}
+			}
+		}
+		for _, member := range members {
+			for _, instance := range processGroup.instancesOf(member.ID) {
+				if holdsVRAM(instance.CurrentState()) {
+					evicted = append(evicted, instance)
+				}
+			}
+		}
+	}
+
+	if processGroup.exclusive {
+		for groupID, otherGroup := range pm.processGroups {
+			if groupID == processGroup.id || otherGroup.persistent {
+				continue
+			}
+			for _, process := range otherGroup.instances() {
+				if holdsVRAM(process.CurrentState()) {
+					evicted = append(evicted, process)
+				}
+			}
+		}
+	}
+
+	return evicted
+}
+
+// vramFreedBySwap returns how many MiB would be released on the GPUs modelID
+// can use by the processes that are stopped or put to sleep when it is
+// swapped in. Only models with a vramEstimate are counted.
+func (pm *ProxyManager) vramFreedBySwap(modelID string, gpus []GPUInfo) int {
+	devices, _ := gpuLimits(pm.config.Models[modelID])
+	freed := 0
+	for _, process := range pm.evictedBySwap(modelID) {
+		freed += pm.releasedVRAM(process, devices, gpus)
+	}
+	return freed
+}
+
+// releasedVRAM returns how many MiB of the vramEstimate of process are on
+// devices, all of it when devices is empty. The estimate is spread evenly
+// over the devices the process was placed on, a process that is not placed
+// or limited to devices is spread over every GPU.
+func (pm *ProxyManager) releasedVRAM(process *Process, devices []string, gpus []GPUInfo) int {
+	estimate := process.config.VRAMEstimateMiB()
+	if len(devices) == 0 || estimate == 0 {
+		return estimate
+	}
+
+	held := pm.gpus.assignedTo(process.ID)
+	if len(held) == 0 {
+		held, _ = gpuLimits(process.config)
+	}
+	if len(held) == 0 {
+		for _, gpu := range gpus {
+			held = append(held, strconv.Itoa(gpu.Index))
+		}
+	}
+
+	shared := 0
+	for _, device := range held {
+		if slices.Contains(devices, device) {
+			shared++
+		}
+	}
+	if shared == 0 {
+		return 0
+	}
+	return estimate * shared / len(held)
+}
+
+// evictableByLRU returns the ready models without inflight requests that
+// could be idled to make room for modelID, least recently used first. Models
+// the swap evicts anyway, the ones modelID requires, the ones of persistent
+// groups and the ones without a vramEstimate are left out.
+func (pm *ProxyManager) evictableByLRU(modelID string) []*Process {
+	skip := append([]string{modelID}, pm.config.Requirements(modelID)...)
+	for _, process := range pm.evictedBySwap(modelID) {
+		skip = append(skip, process.ID)
+	}
+
+	var evictable []*Process
+	for _, processGroup := range pm.processGroups {
+		if processGroup.persistent {
+			continue
+		}
+		for memberID, process := range processGroup.processes {
+			if slices.Contains(skip, memberID) || process.config.VRAMEstimateMiB() == 0 {
+				continue
+			}
+			if process.CurrentState() == StateReady && process.inFlightRequestsCount.Load() == 0 {
+				evictable = append(evictable, process)
+			}
+		}
+	}
+	sort.Slice(evictable, func(i, j int) bool {
+		return evictable[i].getLastRequestHandled().Before(evictable[j].getLastRequestHandled())
+	})
+	return evictable
+}
+
+// evictForVRAM idles the least recently used models until missing MiB are
+// released on the GPUs modelID can use, models on other GPUs are left
+// alone. Nothing is idled and false is returned when they can not release
+// enough.
+func (pm *ProxyManager) evictForVRAM(modelID string, missing int, gpus []GPUInfo) bool {
+	devices, _ := gpuLimits(pm.config.Models[modelID])
+
+	var evicted []*Process
+	for _, process := range pm.evictableByLRU(modelID) {
+		if missing <= 0 {
+			break
+		}
+		// idling a member idles all of its instances
+		released := 0
+		for _, instance := range pm.findGroupByModelName(process.ID).instancesOf(process.ID) {
+			if holdsVRAM(instance.CurrentState()) {
+				released += pm.releasedVRAM(instance, devices, gpus)
+			}
+		}
+		if released == 0 {
+			continue
+		}
+		evicted = append(evicted, process)
+		missing -= released
+	}
+	if missing > 0 {
+		return false
+	}
+
+	var wg sync.WaitGroup
+	for _, process := range evicted {
+		pm.proxyLogger.Infof("<%s> idling least recently used model %s to make room in VRAM", modelID, process.ID)
+		processGroup := pm.findGroupByModelName(process.ID)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			processGroup.EvictProcess(process.ID)
+		}()
+	}
+	wg.Wait()
+	return true
+}
+
+// checkVRAMAdmission returns an error when modelID needs to be loaded but its
+// vramEstimate does not fit in the free GPU memory, even after the evictions
+// the swap would make. When idling the least recently used models of other
+// groups makes it fit they are idled before it is admitted. When GPU readings
+// are not available the model is always admitted.
+func (pm *ProxyManager) checkVRAMAdmission(modelID string) error {
+	required := pm.config.Models[modelID].VRAMEstimateMiB()
+	if required == 0 || pm.readGPUs == nil {
+		return nil
+	}
+
+	if processGroup := pm.findGroupByModelName(modelID); processGroup != nil {
+		if process, ok := processGroup.GetMember(modelID); ok && holdsVRAM(process.CurrentState()) {
+			return nil
+		}
+	}
+
+	gpus, err := pm.readGPUs()
+	if err != nil || len(gpus) == 0 {
+		pm.proxyLogger.Debugf("<%s> skipping VRAM admission check, no GPU readings: %v", modelID, err)
+		return nil
+	}
+
+	free := usableVRAM(pm.config.Models[modelID], gpus)
+	freed := pm.vramFreedBySwap(modelID, gpus)
+	if missing := required - free - freed; missing > 0 && !pm.evictForVRAM(modelID, missing, gpus) {
+		return fmt.Errorf("model %s needs %d MiB of VRAM but only %d MiB is free on the GPUs it can use and swapping would release %d MiB, try again later",
+			modelID, required, free, freed)
+	}
+	return nil
+}
+
+// usableVRAM returns the free MiB of the GPUs a model can be loaded on: the
+// devices of its placement or its CUDA_VISIBLE_DEVICES, otherwise any GPU.
+// It is the free memory of the emptiest of them, or of as many as its
+// placement spreads it over.
+func usableVRAM(modelConfig config.ModelConfig, gpus []GPUInfo) int {
+	devices, count := gpuLimits(modelConfig)
+
+	var free []int
+	for _, gpu := range gpus {
+		if len(devices) == 0 || slices.Contains(devices, strconv.Itoa(gpu.Index)) {
+			free = append(free, gpu.MemoryFree)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(free)))
+
+	usable := 0
+	for _, mib := range free[:min(count, len(free))] {
+		usable += mib
+	}
+	return usable
+}
+
+// gpuLimits returns the devices a model is limited to by its placement or
+// its CUDA_VISIBLE_DEVICES, none when it can use any GPU, and over how many
+// of them it is spread
+func gpuLimits(modelConfig config.ModelConfig) (devices []string, count int) {
+	count = modelConfig.Placement.GPUCount()
+	devices = modelConfig.Placement.Devices
+	if len(devices) == 0 && !modelConfig.UsesGPUMacro() {
+		for _, env := range modelConfig.Env {
+			if visible, found := strings.CutPrefix(env, "CUDA_VISIBLE_DEVICES="); found && visible != "" {
+				devices = strings.Split(visible, ",")
+				count = len(devices)
+			}
+		}
+	}
+	return devices, count
+}
+
+// rejectUnadmitted sends a 503 with Retry-After and returns true when
+// modelID can not be admitted, see checkDrain, checkBatteryAdmission,
+// checkThermalAdmission and checkVRAMAdmission. Quarantined models are
+// refused without Retry-After, they wait for an operator.
+func (pm *ProxyManager) rejectUnadmitted(c *gin.Context, modelID string) bool {
+	if err := pm.checkDrain(); err != nil {
+		c.Header("Retry-After", strconv.Itoa(admissionRetryAfter))
+		pm.sendErrorResponse(c, http.StatusServiceUnavailable, err.Error())
+		return true
+	}
+
+	if err := pm.checkQuarantine(modelID); err != nil {
+		pm.proxyLogger.Warnf("<%s> refusing request: %v", modelID, err)
+		pm.sendErrorResponse(c, http.StatusServiceUnavailable, err.Error())
+		return true
+	}
+
+	err := pm.checkResourceAdmission(modelID)
+	if err == nil {
+		return false
+	}
+
+	pm.proxyLogger.Warnf("<%s> refusing request: %v", modelID, err)
+	c.Header("Retry-After", strconv.Itoa(admissionRetryAfter))
+	pm.sendErrorResponse(c, http.StatusServiceUnavailable, err.Error())
+	return true
+}
+
+// checkAdmission returns why modelID can not be admitted, nil when it can.
+// It checks what rejectUnadmitted checks without sending a response.
+func (pm *ProxyManager) checkAdmission(modelID string) error {
+	if err := pm.checkDrain(); err != nil {
+		return err
+	}
+	if err := pm.checkQuarantine(modelID); err != nil {
+		return err
+	}
+	return pm.checkResourceAdmission(modelID)
+}
+
+// checkResourceAdmission checks the battery, the GPU temperature and power
+// draw and the free VRAM for modelID
+func (pm *ProxyManager) checkResourceAdmission(modelID string) error {
+	if err := pm.checkBatteryAdmission(modelID); err != nil {
+		return err
+	}
+	if err := pm.checkThermalAdmission(modelID); err != nil {
+		return err
+	}
+	return pm.checkVRAMAdmission(modelID)
+}
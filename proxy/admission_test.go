@@ -0,0 +1,277 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func fakeGPUs(freeMiB ...int) gpuReader {
+	return func() ([]GPUInfo, error) {
+		gpus := make([]GPUInfo, 0, len(freeMiB))
+		for i, free := range freeMiB {
+			gpus = append(gpus, GPUInfo{Index: i, MemoryTotal: 24576, MemoryFree: free})
+		}
+		return gpus, nil
+	}
+}
+
+func TestParseNvidiaSMI(t *testing.T) {
+	output := "0, GPU-aaaa, NVIDIA GeForce RTX 3090, 24576, 1024, 23552, 45, 110.50\n" +
+		"1, GPU-bbbb, NVIDIA GeForce RTX 3090, 24576, [N/A], [N/A], 50, [N/A]\n"
+	gpus, err := parseNvidiaSMI(output)
+	assert.NoError(t, err)
+	if assert.Len(t, gpus, 2) {
+		assert.Equal(t, GPUInfo{
+			Index: 0, UUID: "GPU-aaaa", Name: "NVIDIA GeForce RTX 3090",
+			MemoryTotal: 24576, MemoryUsed: 1024, MemoryFree: 23552,
+			Temperature: 45, PowerDraw: 110.5,
+		}, gpus[0])
+		assert.Equal(t, 0, gpus[1].MemoryFree)
+	}
+
+	_, err = parseNvidiaSMI("0, GPU-aaaa\n")
+	assert.Error(t, err)
+}
+
+func TestParseROCmSMI(t *testing.T) {
+	output := `{"card1": {"VRAM Total Memory (B)": "25753026560", "VRAM Total Used Memory (B)": "1073741824"},
+		"card0": {"VRAM Total Memory (B)": "17163091968", "VRAM Total Used Memory (B)": "0"},
+		"system": {"Driver version": "6.8.5"}}`
+	gpus, err := parseROCmSMI([]byte(output))
+	assert.NoError(t, err)
+	if assert.Len(t, gpus, 2) {
+		assert.Equal(t, GPUInfo{Index: 0, Name: "card0", MemoryTotal: 16368, MemoryFree: 16368}, gpus[0])
+		assert.Equal(t, GPUInfo{Index: 1, Name: "card1", MemoryTotal: 24560, MemoryUsed: 1024, MemoryFree: 23536}, gpus[1])
+	}
+
+	_, err = parseROCmSMI([]byte("WARNING: no AMD GPUs"))
+	assert.Error(t, err)
+}
+
+func TestProxyManager_VRAMAdmission(t *testing.T) {
+	model1 := getTestSimpleResponderConfig("model1")
+	model1.VRAMEstimate = "16GiB"
+	model2 := getTestSimpleResponderConfig("model2")
+	model2.VRAMEstimate = "12GiB"
+
+	conf := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]config.ModelConfig{
+			"model1": model1,
+			"model2": model2,
+		},
+		LogLevel: "error",
+	})
+
+	proxy := New(conf)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	doRequest := func(model string) *TestResponseRecorder {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model":"`+model+`"}`))
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("rejects a model that does not fit", func(t *testing.T) {
+		// free memory spread over GPUs does not add up
+		proxy.readGPUs = fakeGPUs(8192, 8192)
+		w := doRequest("model1")
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.Equal(t, "30", w.Header().Get("Retry-After"))
+		assert.Contains(t, w.Body.String(), "needs 16384 MiB of VRAM")
+
+		process, _ := proxy.processGroups[config.DEFAULT_GROUP_ID].GetMember("model1")
+		assert.Equal(t, StateStopped, process.CurrentState())
+	})
+
+	t.Run("counts memory released by swapping", func(t *testing.T) {
+		proxy.readGPUs = fakeGPUs(24576)
+		w := doRequest("model2")
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		// model2 is running and will be swapped out: 8GiB free + 12GiB released
+		proxy.readGPUs = fakeGPUs(8192)
+		w = doRequest("model1")
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("admits when GPU readings are unavailable", func(t *testing.T) {
+		proxy.StopProcesses(StopWaitForInflightRequest)
+		proxy.readGPUs = func() ([]GPUInfo, error) {
+			return nil, errors.New("nvidia-smi not found")
+		}
+		w := doRequest("model1")
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+}
+
+func TestUsableVRAM(t *testing.T) {
+	gpus, _ := fakeGPUs(4096, 16384, 8192)()
+
+	assert.Equal(t, 16384, usableVRAM(config.ModelConfig{}, gpus))
+	assert.Equal(t, 24576, usableVRAM(config.ModelConfig{Placement: config.GPUPlacement{GPUs: 2}}, gpus))
+	assert.Equal(t, 12288, usableVRAM(config.ModelConfig{Placement: config.GPUPlacement{GPUs: 2, Devices: []string{"0", "2"}}}, gpus))
+	assert.Equal(t, 4096, usableVRAM(config.ModelConfig{Env: []string{"CUDA_VISIBLE_DEVICES=0"}}, gpus))
+	assert.Equal(t, 12288, usableVRAM(config.ModelConfig{Env: []string{"CUDA_VISIBLE_DEVICES=0,2"}}, gpus))
+	// ${GPU} is placed on any device
+	assert.Equal(t, 16384, usableVRAM(config.ModelConfig{Cmd: "server", Env: []string{"CUDA_VISIBLE_DEVICES=${GPU}"}}, gpus))
+}
+
+func TestProxyManager_VRAMEvictsLeastRecentlyUsed(t *testing.T) {
+	models := map[string]config.ModelConfig{}
+	for _, model := range []string{"model1", "model2", "model3"} {
+		modelConfig := getTestSimpleResponderConfig(model)
+		modelConfig.VRAMEstimate = "8GiB"
+		models[model] = modelConfig
+	}
+	model4 := getTestSimpleResponderConfig("model4")
+	model4.VRAMEstimate = "32GiB"
+	models["model4"] = model4
+
+	// groups that do not swap each other out
+	conf := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Models:             models,
+		Groups: map[string]config.GroupConfig{
+			"g1": {Swap: true, Exclusive: false, Members: []string{"model1"}},
+			"g2": {Swap: true, Exclusive: false, Members: []string{"model2"}},
+			"g3": {Swap: true, Exclusive: false, Members: []string{"model3"}},
+			"g4": {Swap: true, Exclusive: false, Members: []string{"model4"}},
+		},
+		LogLevel: "error",
+	})
+
+	proxy := New(conf)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	doRequest := func(model string) *TestResponseRecorder {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model":"`+model+`"}`))
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		return w
+	}
+	state := func(model string) ProcessState {
+		process, _ := proxy.findGroupByModelName(model).GetMember(model)
+		return process.CurrentState()
+	}
+
+	proxy.readGPUs = fakeGPUs(24576)
+	assert.Equal(t, http.StatusOK, doRequest("model1").Code)
+	assert.Equal(t, http.StatusOK, doRequest("model2").Code)
+	assert.Equal(t, http.StatusOK, doRequest("model1").Code)
+
+	// model2 was used last longest ago and is idled for model3
+	proxy.readGPUs = fakeGPUs(4096)
+	assert.Equal(t, http.StatusOK, doRequest("model3").Code)
+	assert.Equal(t, StateReady, state("model1"))
+	assert.Equal(t, StateStopped, state("model2"))
+
+	// idling every other model would still not make room
+	w := doRequest("model4")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, StateReady, state("model1"))
+	assert.Equal(t, StateReady, state("model3"))
+}
+
+func TestProxyManager_VRAMFreedBySwap(t *testing.T) {
+	startPort := getTestPort()
+	for range 6 {
+		getTestPort()
+	}
+	conf, err := config.LoadConfigFromReader(strings.NewReader(fmt.Sprintf(`
+logLevel: error
+startPort: %d
+macros:
+  responder: %s -port ${PORT} -silent
+models:
+  gpu0:
+    cmd: ${responder} -respond gpu0
+    env: [CUDA_VISIBLE_DEVICES=0]
+    vramEstimate: 8GiB
+  gpu1:
+    cmd: ${responder} -respond gpu1
+    env: [CUDA_VISIBLE_DEVICES=1]
+    vramEstimate: 8GiB
+  large:
+    cmd: ${responder} -respond large
+    env: [CUDA_VISIBLE_DEVICES=1]
+    vramEstimate: 12GiB
+  pair:
+    cmd: ${responder} -respond pair
+    instances: 2
+    vramEstimate: 8GiB
+    weight: 1
+  small:
+    cmd: ${responder} -respond small
+    vramEstimate: 4GiB
+    weight: 1
+  other:
+    cmd: ${responder} -respond other
+    vramEstimate: 8GiB
+    weight: 1
+groups:
+  devices:
+    swap: true
+    exclusive: false
+    members: [gpu0, gpu1, large]
+  budget:
+    swap: true
+    exclusive: false
+    weightBudget: 2
+    members: [pair, small, other]
+`, startPort, getSimpleResponderPath())))
+	require.NoError(t, err)
+
+	proxy := New(conf)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	doRequest := func(model string) *TestResponseRecorder {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model":"`+model+`"}`))
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("counts only the GPUs the model can use", func(t *testing.T) {
+		proxy.readGPUs = fakeGPUs(24576, 24576)
+		require.Equal(t, http.StatusOK, doRequest("gpu1").Code)
+
+		gpus, _ := fakeGPUs(4096, 4096)()
+		assert.Equal(t, 0, proxy.vramFreedBySwap("gpu0", gpus))
+		assert.Equal(t, 8192, proxy.vramFreedBySwap("large", gpus))
+
+		// swapping out gpu1 frees nothing on GPU 0
+		proxy.readGPUs = fakeGPUs(4096, 4096)
+		assert.Equal(t, http.StatusServiceUnavailable, doRequest("gpu0").Code)
+		assert.Equal(t, http.StatusOK, doRequest("large").Code)
+	})
+
+	t.Run("counts the instances idled for the weight budget", func(t *testing.T) {
+		proxy.readGPUs = fakeGPUs(49152)
+		require.Equal(t, http.StatusOK, doRequest("pair").Code)
+		instances := proxy.findGroupByModelName("pair").instancesOf("pair")
+		require.Len(t, instances, 2)
+		require.Eventually(t, func() bool {
+			return instances[1].CurrentState() == StateReady
+		}, 5*time.Second, 50*time.Millisecond)
+
+		gpus, _ := fakeGPUs(4096)()
+		// small fits in the budget next to pair
+		assert.Equal(t, 0, proxy.vramFreedBySwap("small", gpus))
+		require.Equal(t, http.StatusOK, doRequest("small").Code)
+
+		// other idles pair, used longest ago, with both of its instances
+		assert.Equal(t, 16384, proxy.vramFreedBySwap("other", gpus))
+	})
+}
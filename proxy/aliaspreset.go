@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"encoding/json"
+	"slices"
+	"sort"
+
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// applyAliasPreset sets the defaults of the alias's preset when
+// requestedModel is an alias with one. Values sent by the client are kept.
+func (pm *ProxyManager) applyAliasPreset(body []byte, requestedModel string) ([]byte, error) {
+	modelID, found := pm.config.RealModelName(requestedModel)
+	if !found {
+		return body, nil
+	}
+	preset, found := pm.config.Models[modelID].AliasPresets[requestedModel]
+	if !found {
+		return body, nil
+	}
+
+	keys := make([]string, 0, len(preset.Params))
+	for key := range preset.Params {
+		if !slices.Contains(config.ProtectedParams, key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var err error
+	for _, key := range keys {
+		if gjson.GetBytes(body, key).Exists() {
+			continue
+		}
+		if body, err = sjson.SetBytes(body, key, preset.Params[key]); err != nil {
+			return nil, err
+		}
+	}
+
+	if preset.SystemPrompt != "" {
+		if body, err = addSystemPrompt(body, preset.SystemPrompt); err != nil {
+			return nil, err
+		}
+	}
+
+	pm.proxyLogger.Debugf("<%s> applied preset of alias %s", modelID, requestedModel)
+	return body, nil
+}
+
+// addSystemPrompt puts a system message first in a chat request that has
+// none
+func addSystemPrompt(body []byte, prompt string) ([]byte, error) {
+	messages := gjson.GetBytes(body, "messages")
+	if !messages.IsArray() {
+		return body, nil
+	}
+
+	var withPrompt []json.RawMessage
+	system, err := json.Marshal(map[string]string{"role": "system", "content": prompt})
+	if err != nil {
+		return nil, err
+	}
+	withPrompt = append(withPrompt, system)
+	for _, message := range messages.Array() {
+		if role := message.Get("role").String(); role == "system" || role == "developer" {
+			return body, nil
+		}
+		withPrompt = append(withPrompt, json.RawMessage(message.Raw))
+	}
+
+	return sjson.SetBytes(body, "messages", withPrompt)
+}
@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+func TestAddSystemPrompt(t *testing.T) {
+	body, err := addSystemPrompt([]byte(`{"messages":[{"role":"user","content":"hi"}]}`), "be brief")
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"messages":[{"role":"system","content":"be brief"},{"role":"user","content":"hi"}]}`, string(body))
+
+	// the client's system message is kept
+	original := []byte(`{"messages":[{"role":"system","content":"mine"},{"role":"user","content":"hi"}]}`)
+	body, err = addSystemPrompt(original, "be brief")
+	assert.NoError(t, err)
+	assert.Equal(t, string(original), string(body))
+
+	// not a chat request
+	original = []byte(`{"prompt":"hi"}`)
+	body, err = addSystemPrompt(original, "be brief")
+	assert.NoError(t, err)
+	assert.Equal(t, string(original), string(body))
+}
+
+func TestProxyManager_AliasPreset(t *testing.T) {
+	configStr := fmt.Sprintf(`
+logLevel: error
+models:
+  coder:
+    cmd: %s -port ${PORT} -silent -respond coder
+    aliases:
+      - name: fast-coder
+        params:
+          temperature: 0.2
+          max_tokens: 512
+          model: ignored
+      - name: careful-coder
+        systemPrompt: Think step by step.
+        params:
+          temperature: 0.8
+`, getSimpleResponderPath())
+
+	conf, err := config.LoadConfigFromReader(strings.NewReader(configStr))
+	assert.NoError(t, err)
+
+	proxy := New(conf)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	upstreamBody := func(body string) gjson.Result {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(body))
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		return gjson.Parse(gjson.Get(w.Body.String(), "request_body").String())
+	}
+
+	t.Run("params are set", func(t *testing.T) {
+		sent := upstreamBody(`{"model":"fast-coder","messages":[{"role":"user","content":"hi"}]}`)
+		assert.Equal(t, "fast-coder", sent.Get("model").String())
+		assert.Equal(t, 0.2, sent.Get("temperature").Float())
+		assert.Equal(t, int64(512), sent.Get("max_tokens").Int())
+		assert.Equal(t, int64(1), sent.Get("messages.#").Int())
+	})
+
+	t.Run("client values win", func(t *testing.T) {
+		sent := upstreamBody(`{"model":"fast-coder","temperature":1.0,"messages":[]}`)
+		assert.Equal(t, 1.0, sent.Get("temperature").Float())
+		assert.Equal(t, int64(512), sent.Get("max_tokens").Int())
+	})
+
+	t.Run("system prompt", func(t *testing.T) {
+		sent := upstreamBody(`{"model":"careful-coder","messages":[{"role":"user","content":"hi"}]}`)
+		assert.Equal(t, 0.8, sent.Get("temperature").Float())
+		assert.Equal(t, "Think step by step.", sent.Get(`messages.0.content`).String())
+	})
+
+	t.Run("model ID has no preset", func(t *testing.T) {
+		sent := upstreamBody(`{"model":"coder","messages":[]}`)
+		assert.False(t, sent.Get("temperature").Exists())
+	})
+}
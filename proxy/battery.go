@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// interval between power source readings
+const batteryCheckInterval = 30 * time.Second
+
+// batteryMonitor tracks if the host runs on battery for groups with onBattery
+type batteryMonitor struct {
+	onBattery atomic.Bool
+}
+
+func (b *batteryMonitor) saving() bool {
+	return b != nil && b.onBattery.Load()
+}
+
+// watchBattery reads the power source until shutdown
+func (pm *ProxyManager) watchBattery() {
+	ticker := time.NewTicker(batteryCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-pm.shutdownCtx.Done():
+			return
+		case <-ticker.C:
+			pm.checkBattery()
+		}
+	}
+}
+
+// checkBattery reads the power source. On the switch to battery the models
+// of groups with onBattery.unload are stopped.
+func (pm *ProxyManager) checkBattery() {
+	if pm.battery == nil || pm.readPower == nil {
+		return
+	}
+	source, err := pm.readPower()
+	if err != nil {
+		pm.proxyLogger.Debugf("skipping battery check, unable to read power source: %v", err)
+		return
+	}
+
+	saving := source.saving()
+	if pm.battery.onBattery.Swap(saving) == saving {
+		return
+	}
+	if !saving {
+		pm.proxyLogger.Infof("running on AC power, battery restrictions lifted")
+		return
+	}
+
+	pm.proxyLogger.Infof("running on battery or power saver, applying onBattery group settings")
+	for groupID, processGroup := range pm.processGroups {
+		if pm.config.Groups[groupID].OnBattery.Unload {
+			go processGroup.StopProcesses(StopWaitForInflightRequest)
+		}
+	}
+}
+
+// checkBatteryAdmission returns an error when modelID is in a group with
+// onBattery.unload and the host runs on battery
+func (pm *ProxyManager) checkBatteryAdmission(modelID string) error {
+	if !pm.battery.saving() {
+		return nil
+	}
+	processGroup := pm.findGroupByModelName(modelID)
+	if processGroup == nil || !pm.config.Groups[processGroup.id].OnBattery.Unload {
+		return nil
+	}
+	return fmt.Errorf("model %s is not available on battery power", modelID)
+}
@@ -0,0 +1,121 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadLinuxPowerSource(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		path := filepath.Join(dir, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(t, os.WriteFile(path, []byte(content+"\n"), 0644))
+	}
+	write("supply/AC/type", "Mains")
+	write("supply/AC/online", "1")
+	write("supply/BAT0/type", "Battery")
+	write("platform_profile", "balanced")
+
+	source, err := readLinuxPowerSource(filepath.Join(dir, "supply"), filepath.Join(dir, "platform_profile"))
+	assert.NoError(t, err)
+	assert.Equal(t, powerSource{}, source)
+
+	write("supply/AC/online", "0")
+	write("platform_profile", "low-power")
+	source, err = readLinuxPowerSource(filepath.Join(dir, "supply"), filepath.Join(dir, "platform_profile"))
+	assert.NoError(t, err)
+	assert.Equal(t, powerSource{OnBattery: true, PowerSaver: true}, source)
+
+	// desktops without a battery are never on battery
+	assert.NoError(t, os.RemoveAll(filepath.Join(dir, "supply/BAT0")))
+	source, err = readLinuxPowerSource(filepath.Join(dir, "supply"), filepath.Join(dir, "missing"))
+	assert.NoError(t, err)
+	assert.Equal(t, powerSource{}, source)
+}
+
+func TestParsePowerSource(t *testing.T) {
+	assert.Equal(t, powerSource{OnBattery: true, PowerSaver: true}, parsePmset(
+		"Now drawing from 'Battery Power'\n -InternalBattery-0 (id=1234)\t85%; discharging; 4:12 remaining present: true\n",
+		"System-wide power settings:\nCurrently in use:\n lowpowermode         1\n sleep                1\n",
+	))
+	assert.Equal(t, powerSource{}, parsePmset(
+		"Now drawing from 'AC Power'\n",
+		"Currently in use:\n lowpowermode         0\n",
+	))
+
+	assert.Equal(t, powerSource{OnBattery: true}, parseWindowsPower(
+		"1\r\n",
+		"Power Scheme GUID: 381b4222-f694-41f0-9685-ff5bb260df2e  (Balanced)\r\n",
+	))
+	assert.Equal(t, powerSource{PowerSaver: true}, parseWindowsPower(
+		"2\r\n",
+		"Power Scheme GUID: a1841308-3541-4fab-bc81-f71556f20b4a  (Power saver)\r\n",
+	))
+}
+
+func TestProcess_UnloadAfterOnBattery(t *testing.T) {
+	onBattery := false
+	process := &Process{config: config.ModelConfig{UnloadAfter: 300}}
+	process.setHooks(&processHooks{batteryTTL: 30, onBattery: func() bool { return onBattery }})
+
+	assert.Equal(t, 300, process.unloadAfter())
+	onBattery = true
+	assert.Equal(t, 30, process.unloadAfter())
+}
+
+func TestProxyManager_OnBatteryUnload(t *testing.T) {
+	conf := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]config.ModelConfig{
+			"model1": getTestSimpleResponderConfig("model1"),
+			"model2": getTestSimpleResponderConfig("model2"),
+		},
+		Groups: map[string]config.GroupConfig{
+			"big":   {Swap: true, Members: []string{"model1"}, OnBattery: config.BatteryConfig{Unload: true}},
+			"small": {Swap: true, Members: []string{"model2"}},
+		},
+		LogLevel: "error",
+	})
+
+	proxy := New(conf)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	source := powerSource{}
+	proxy.readPower = func() (powerSource, error) { return source, nil }
+	proxy.checkBattery()
+
+	doRequest := func(model string) *TestResponseRecorder {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model":"`+model+`"}`))
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, doRequest("model1").Code)
+	assert.Equal(t, http.StatusOK, doRequest("model2").Code)
+
+	source.OnBattery = true
+	proxy.checkBattery()
+	process1, _ := proxy.findGroupByModelName("model1").GetMember("model1")
+	assert.Eventually(t, func() bool {
+		return process1.CurrentState() == StateStopped
+	}, 5*time.Second, 50*time.Millisecond)
+
+	w := doRequest("model1")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), "model model1 is not available on battery power")
+	assert.Equal(t, http.StatusOK, doRequest("model2").Code)
+
+	source.OnBattery = false
+	proxy.checkBattery()
+	assert.Equal(t, http.StatusOK, doRequest("model1").Code)
+}
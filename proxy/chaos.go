@@ -0,0 +1,94 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/napmany/llmsnap/proxy/config"
+)
+
+var (
+	errChaosStartFailure  = errors.New("chaos: injected start failure")
+	errChaosDroppedStream = errors.New("chaos: dropped stream")
+)
+
+// chaosInjector injects the faults of the chaos config into a process
+type chaosInjector struct {
+	config config.ChaosConfig
+
+	// returns a number in [0, 1), replaced in tests
+	random func() float64
+}
+
+func newChaosInjector(conf config.ChaosConfig) *chaosInjector {
+	return &chaosInjector{config: conf, random: rand.Float64}
+}
+
+// failStart reports if this start should fail
+func (c *chaosInjector) failStart() bool {
+	return c.random() < c.config.FailStartRate
+}
+
+// delay waits the injected latency, it returns false when ctx is done first
+func (c *chaosInjector) delay(ctx context.Context) bool {
+	least, most := c.config.LatencyRange()
+	latency := least + time.Duration(c.random()*float64(most-least))
+	if latency <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(latency)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// dropWriter returns a writer that cuts off an event stream, nil when this
+// response is not dropped
+func (c *chaosInjector) dropWriter(w http.ResponseWriter) *chaosDropWriter {
+	if c.random() >= c.config.DropStreamRate {
+		return nil
+	}
+	return &chaosDropWriter{ResponseWriter: w, remaining: c.config.DropAfter()}
+}
+
+// chaosDropWriter lets the first bytes of an event stream through and fails
+// every write after them. Other responses are not touched.
+type chaosDropWriter struct {
+	http.ResponseWriter
+	remaining int
+	dropped   bool
+}
+
+func (w *chaosDropWriter) Write(b []byte) (int, error) {
+	if w.dropped {
+		return 0, errChaosDroppedStream
+	}
+	if !strings.Contains(strings.ToLower(w.Header().Get("Content-Type")), "text/event-stream") {
+		return w.ResponseWriter.Write(b)
+	}
+	if len(b) <= w.remaining {
+		w.remaining -= len(b)
+		return w.ResponseWriter.Write(b)
+	}
+
+	n, _ := w.ResponseWriter.Write(b[:w.remaining])
+	w.remaining = 0
+	w.dropped = true
+	w.Flush()
+	return n, errChaosDroppedStream
+}
+
+func (w *chaosDropWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChaos_FailStart(t *testing.T) {
+	process := NewProcess("chaos", 2, getTestSimpleResponderConfig("chaos"), debugLogger, debugLogger)
+	defer process.Stop()
+
+	var failure error
+	process.setHooks(&processHooks{
+		onFailure: func(err error) { failure = err },
+		chaos:     newChaosInjector(config.ChaosConfig{Active: true, FailStartRate: 1}),
+	})
+
+	assert.ErrorIs(t, process.start(), errChaosStartFailure)
+	assert.ErrorIs(t, failure, errChaosStartFailure)
+	assert.Equal(t, StateStopped, process.CurrentState())
+
+	process.hooks().chaos.config.FailStartRate = 0
+	require.NoError(t, process.start())
+	assert.Equal(t, StateReady, process.CurrentState())
+}
+
+func TestChaos_DropWriter(t *testing.T) {
+	chaos := newChaosInjector(config.ChaosConfig{Active: true, DropStreamRate: 0.5, DropAfterBytes: 10})
+	chaos.random = func() float64 { return 0.9 }
+	assert.Nil(t, chaos.dropWriter(httptest.NewRecorder()))
+
+	chaos.random = func() float64 { return 0.1 }
+
+	t.Run("event streams are cut off", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		w := chaos.dropWriter(rec)
+		require.NotNil(t, w)
+		w.Header().Set("Content-Type", "text/event-stream")
+
+		n, err := w.Write([]byte("data: 1\n\n"))
+		assert.NoError(t, err)
+		assert.Equal(t, 9, n)
+
+		n, err = w.Write([]byte("data: 2\n\n"))
+		assert.ErrorIs(t, err, errChaosDroppedStream)
+		assert.Equal(t, 1, n)
+
+		_, err = w.Write([]byte("data: 3\n\n"))
+		assert.ErrorIs(t, err, errChaosDroppedStream)
+		assert.Equal(t, "data: 1\n\nd", rec.Body.String())
+	})
+
+	t.Run("other responses pass", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		w := chaos.dropWriter(rec)
+		w.Header().Set("Content-Type", "application/json")
+		_, err := w.Write([]byte(`{"choices":[{"message":{"content":"hello"}}]}`))
+		assert.NoError(t, err)
+		assert.Equal(t, `{"choices":[{"message":{"content":"hello"}}]}`, rec.Body.String())
+	})
+}
+
+func TestChaos_Delay(t *testing.T) {
+	chaos := newChaosInjector(config.ChaosConfig{Active: true, Latency: 50, LatencyJitter: 100})
+	chaos.random = func() float64 { return 0.5 }
+
+	start := time.Now()
+	assert.True(t, chaos.delay(context.Background()))
+	assert.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.False(t, chaos.delay(ctx))
+}
+
+func TestProxyManager_ChaosSelectedModels(t *testing.T) {
+	conf := config.AddDefaultGroupToConfig(config.Config{
+		Models: map[string]config.ModelConfig{
+			"model1": getTestSimpleResponderConfig("model1"),
+			"model2": getTestSimpleResponderConfig("model2"),
+		},
+		Chaos:    config.ChaosConfig{Models: []string{"model1"}, FailStartRate: 1},
+		LogLevel: "error",
+	})
+
+	// without --chaos nothing is injected
+	proxy := New(conf)
+	process, _ := proxy.findGroupByModelName("model1").GetMember("model1")
+	assert.Nil(t, process.hooks().chaos)
+	proxy.StopProcesses(StopImmediately)
+
+	conf.Chaos.Active = true
+	proxy = New(conf)
+	defer proxy.StopProcesses(StopImmediately)
+	process, _ = proxy.findGroupByModelName("model1").GetMember("model1")
+	assert.NotNil(t, process.hooks().chaos)
+	process, _ = proxy.findGroupByModelName("model2").GetMember("model2")
+	assert.Nil(t, process.hooks().chaos)
+}
@@ -0,0 +1,45 @@
+package proxy
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// applyChatTemplateSuffix handles requests for "model:suffix" where suffix is
+// one of the model's chatTemplateSuffixes. The suffix's kwargs are merged
+// into chat_template_kwargs, overriding the ones sent by the client, and the
+// suffix is removed from the model name.
+func (pm *ProxyManager) applyChatTemplateSuffix(body []byte, requestedModel string) ([]byte, string, error) {
+	if _, found := pm.realModelName(requestedModel); found {
+		return body, requestedModel, nil
+	}
+	modelID, kwargs, found := pm.config.ChatTemplateSuffix(requestedModel)
+	if !found {
+		return body, requestedModel, nil
+	}
+
+	merged := make(map[string]any)
+	if existing := gjson.GetBytes(body, "chat_template_kwargs"); existing.IsObject() {
+		if err := json.Unmarshal([]byte(existing.Raw), &merged); err != nil {
+			return nil, "", err
+		}
+	}
+	for key, value := range kwargs {
+		merged[key] = value
+	}
+
+	body, err := sjson.SetBytes(body, "chat_template_kwargs", merged)
+	if err != nil {
+		return nil, "", err
+	}
+
+	baseModel := requestedModel[:strings.LastIndex(requestedModel, ":")]
+	if body, err = sjson.SetBytes(body, "model", baseModel); err != nil {
+		return nil, "", err
+	}
+	pm.proxyLogger.Debugf("<%s> applied chat template suffix %s", modelID, requestedModel[len(baseModel)+1:])
+	return body, baseModel, nil
+}
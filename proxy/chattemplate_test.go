@@ -0,0 +1,72 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+func TestProxyManager_ChatTemplateSuffixes(t *testing.T) {
+	configStr := fmt.Sprintf(`
+logLevel: error
+models:
+  model1:
+    cmd: %s -port ${PORT} -silent -respond model1
+    aliases: [m1]
+    chatTemplateSuffixes:
+      high:
+        reasoning_effort: high
+      fast:
+        enable_thinking: false
+`, getSimpleResponderPath())
+
+	conf, err := config.LoadConfigFromReader(strings.NewReader(configStr))
+	assert.NoError(t, err)
+
+	proxy := New(conf)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	upstreamBody := func(body string) string {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(body))
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		return gjson.Get(w.Body.String(), "request_body").String()
+	}
+
+	t.Run("suffix injects kwargs", func(t *testing.T) {
+		body := upstreamBody(`{"model":"model1:high","messages":[]}`)
+		assert.Equal(t, "model1", gjson.Get(body, "model").String())
+		assert.Equal(t, "high", gjson.Get(body, "chat_template_kwargs.reasoning_effort").String())
+	})
+
+	t.Run("suffix on an alias merges with client kwargs", func(t *testing.T) {
+		body := upstreamBody(`{"model":"m1:fast","chat_template_kwargs":{"enable_thinking":true,"foo":1}}`)
+		assert.Equal(t, "m1", gjson.Get(body, "model").String())
+		assert.JSONEq(t, `{"enable_thinking":false,"foo":1}`, gjson.Get(body, "chat_template_kwargs").Raw)
+	})
+
+	t.Run("unknown suffix", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model":"model1:medium"}`))
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("listed in /v1/models", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/v1/models", nil)
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		ids := gjson.Get(w.Body.String(), "data.#.id").Array()
+		assert.Len(t, ids, 3)
+		assert.Equal(t, "model1:fast", ids[1].String())
+		assert.Equal(t, "model1:high", ids[2].String())
+	})
+}
@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// clientLimiter counts the requests each client has in flight to each model
+type clientLimiter struct {
+	mu       sync.Mutex
+	inFlight map[clientModel]int
+}
+
+type clientModel struct {
+	client  string
+	modelID string
+}
+
+func newClientLimiter() *clientLimiter {
+	return &clientLimiter{inFlight: make(map[clientModel]int)}
+}
+
+// acquire takes a slot for client unless it already has limit requests in
+// flight to modelID
+func (l *clientLimiter) acquire(client, modelID string, limit int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	key := clientModel{client: client, modelID: modelID}
+	if limit > 0 && l.inFlight[key] >= limit {
+		return false
+	}
+	l.inFlight[key]++
+	return true
+}
+
+func (l *clientLimiter) release(client, modelID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	key := clientModel{client: client, modelID: modelID}
+	if l.inFlight[key] <= 1 {
+		delete(l.inFlight, key)
+	} else {
+		l.inFlight[key]--
+	}
+}
+
+// requestClient identifies the client of a request by the name given by
+// identifyClient, else by its API key, else by its IP address
+func requestClient(c *gin.Context) (client, name, apiKey string) {
+	name = requestClientName(c.Request)
+	apiKey = c.GetString(apiKeyContextKey)
+	switch {
+	case name != "":
+		return "name:" + name, name, apiKey
+	case apiKey != "":
+		return "key:" + apiKey, name, apiKey
+	default:
+		return "ip:" + c.ClientIP(), name, apiKey
+	}
+}
+
+// limitClient takes one of the client's in-flight slots for modelID. The
+// returned release func must be called when the request is done. When ok is
+// false a 429 response has already been sent. Only local models are limited,
+// modelID is empty for the others.
+func (pm *ProxyManager) limitClient(c *gin.Context, modelID string) (release func(), ok bool) {
+	if pm.clientLimiter == nil || modelID == "" {
+		return func() {}, true
+	}
+
+	client, name, apiKey := requestClient(c)
+	limit := pm.config.ClientLimits.Limit(name, apiKey)
+	if !pm.clientLimiter.acquire(client, modelID, limit) {
+		// the API key itself is not logged
+		switch {
+		case name != "":
+			pm.proxyLogger.Infof("<%s> client %s has %d requests in flight, rejecting", modelID, name, limit)
+		case apiKey != "":
+			pm.proxyLogger.Infof("<%s> API key client has %d requests in flight, rejecting", modelID, limit)
+		default:
+			pm.proxyLogger.Infof("<%s> client %s has %d requests in flight, rejecting", modelID, c.ClientIP(), limit)
+		}
+		pm.sendErrorResponse(c, http.StatusTooManyRequests, fmt.Sprintf("too many requests in flight for %s, this client is limited to %d", modelID, limit))
+		return nil, false
+	}
+	return func() { pm.clientLimiter.release(client, modelID) }, true
+}
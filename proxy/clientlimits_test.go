@@ -0,0 +1,82 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientLimiter_AcquireRelease(t *testing.T) {
+	limiter := newClientLimiter()
+
+	assert.True(t, limiter.acquire("ip:10.0.0.1", "model1", 2))
+	assert.True(t, limiter.acquire("ip:10.0.0.1", "model1", 2))
+	assert.False(t, limiter.acquire("ip:10.0.0.1", "model1", 2))
+
+	// counted per client and per model
+	assert.True(t, limiter.acquire("ip:10.0.0.2", "model1", 2))
+	assert.True(t, limiter.acquire("ip:10.0.0.1", "model2", 2))
+
+	limiter.release("ip:10.0.0.1", "model1")
+	assert.True(t, limiter.acquire("ip:10.0.0.1", "model1", 2))
+
+	// 0 is unlimited
+	for range 5 {
+		assert.True(t, limiter.acquire("key:secret", "model1", 0))
+	}
+
+	for range 5 {
+		limiter.release("key:secret", "model1")
+	}
+	limiter.release("ip:10.0.0.2", "model1")
+	limiter.release("ip:10.0.0.1", "model2")
+	assert.Len(t, limiter.inFlight, 1)
+}
+
+func TestProxyManager_ClientLimits(t *testing.T) {
+	conf := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]config.ModelConfig{
+			"model1": getTestSimpleResponderConfig("model1"),
+		},
+		ClientLimits: config.ClientLimitsConfig{MaxInFlight: 1},
+		LogLevel:     "error",
+	})
+
+	proxy := New(conf)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	chat := func(remoteAddr, wait string) *TestResponseRecorder {
+		req := httptest.NewRequest("POST", "/v1/chat/completions?wait="+wait, bytes.NewBufferString(`{"model":"model1"}`))
+		req.RemoteAddr = remoteAddr
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		return w
+	}
+
+	slow := make(chan *TestResponseRecorder)
+	go func() { slow <- chat("10.0.0.1:5000", "1000ms") }()
+
+	assert.Eventually(t, func() bool {
+		proxy.clientLimiter.mu.Lock()
+		defer proxy.clientLimiter.mu.Unlock()
+		return proxy.clientLimiter.inFlight[clientModel{client: "ip:10.0.0.1", modelID: "model1"}] == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	w := chat("10.0.0.1:5001", "0s")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Contains(t, w.Body.String(), "this client is limited to 1")
+
+	// other clients still get through
+	w = chat("10.0.0.2:5000", "0s")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	assert.Equal(t, http.StatusOK, (<-slow).Code)
+	w = chat("10.0.0.1:5002", "0s")
+	assert.Equal(t, http.StatusOK, w.Code)
+}
@@ -0,0 +1,28 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// identifyClient names the client of a request with the clients rules. The
+// name is kept in the request context for metrics and client limits.
+func (pm *ProxyManager) identifyClient(c *gin.Context) {
+	// the auth middleware removed the key from the headers
+	apiKey := c.GetString(apiKeyContextKey)
+	for _, matcher := range pm.clientMatchers {
+		if name := matcher.ClientName(c.Request.Header, apiKey); name != "" {
+			c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), proxyCtxKey("client"), name))
+			return
+		}
+	}
+}
+
+// requestClientName returns the name identifyClient gave the client of r,
+// empty when no rule matched
+func requestClientName(r *http.Request) string {
+	name, _ := r.Context().Value(proxyCtxKey("client")).(string)
+	return name
+}
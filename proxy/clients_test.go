@@ -0,0 +1,112 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxyManager_ClientNames(t *testing.T) {
+	conf := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]config.ModelConfig{
+			"model1": getTestSimpleResponderConfig("model1"),
+		},
+		Clients: []config.ClientRule{
+			{Header: "User-Agent", Match: "^agent-loop/", Name: "agent"},
+			{Header: "X-Title"},
+		},
+		ClientLimits: config.ClientLimitsConfig{Clients: map[string]int{"agent": 1}},
+		LogLevel:     "error",
+	})
+
+	proxy := New(conf)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	chat := func(remoteAddr, wait string, header http.Header) *TestResponseRecorder {
+		req := httptest.NewRequest("POST", "/v1/chat/completions?wait="+wait, bytes.NewBufferString(`{"model":"model1"}`))
+		req.RemoteAddr = remoteAddr
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		return w
+	}
+
+	w := chat("10.0.0.1:5000", "0s", http.Header{"X-Title": {"notebook"}})
+	require.Equal(t, http.StatusOK, w.Code)
+	metrics := proxy.metricsMonitor.getMetrics()
+	require.Len(t, metrics, 1)
+	assert.Equal(t, "notebook", metrics[0].Client)
+
+	// the named client is limited across addresses
+	agent := http.Header{"User-Agent": {"agent-loop/2.0"}}
+	slow := make(chan *TestResponseRecorder)
+	go func() { slow <- chat("10.0.0.1:5001", "1000ms", agent) }()
+
+	assert.Eventually(t, func() bool {
+		proxy.clientLimiter.mu.Lock()
+		defer proxy.clientLimiter.mu.Unlock()
+		return proxy.clientLimiter.inFlight[clientModel{client: "name:agent", modelID: "model1"}] == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	w = chat("10.0.0.2:5000", "0s", agent)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+
+	// unnamed clients are not limited
+	w = chat("10.0.0.2:5001", "0s", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	assert.Equal(t, http.StatusOK, (<-slow).Code)
+	metrics = proxy.metricsMonitor.getMetrics()
+	assert.Equal(t, "agent", metrics[len(metrics)-1].Client)
+}
+
+func TestProxyManager_ClientByAPIKey(t *testing.T) {
+	conf := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]config.ModelConfig{
+			"model1": getTestSimpleResponderConfig("model1"),
+		},
+		RequiredAPIKeys: []string{"batch-key", "app-key"},
+		Clients: []config.ClientRule{
+			{APIKey: "batch-key", Name: "batch"},
+		},
+		LogLevel: "error",
+	})
+
+	proxy := New(conf)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	for _, key := range []string{"batch-key", "app-key", "batch-key"} {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model":"model1"}`))
+		req.Header.Set("Authorization", "Bearer "+key)
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	metrics := proxy.metricsMonitor.getMetrics()
+	require.Len(t, metrics, 3)
+	assert.Equal(t, "batch", metrics[0].Client)
+	assert.Equal(t, "", metrics[1].Client)
+
+	// the activity can be limited to a client
+	req := httptest.NewRequest("GET", "/api/metrics?client=batch", nil)
+	req.Header.Set("Authorization", "Bearer app-key")
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	var filtered []TokenMetrics
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &filtered))
+	require.Len(t, filtered, 2)
+	assert.Equal(t, "batch", filtered[1].Client)
+}
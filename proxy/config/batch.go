@@ -0,0 +1,41 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// BatchConfig coalesces concurrent /v1/embeddings requests to a model into
+// one upstream request, the embeddings are split back out to each request
+type BatchConfig struct {
+	// MaxSize is the most inputs sent upstream in one request. 0 or 1
+	// disables batching.
+	MaxSize int `yaml:"maxSize"`
+
+	// MaxWaitMs is how long the first request of a batch waits for others
+	// to join it, 0 is 20
+	MaxWaitMs int `yaml:"maxWaitMs"`
+}
+
+// Enabled reports if requests are batched
+func (b BatchConfig) Enabled() bool {
+	return b.MaxSize > 1
+}
+
+// MaxWait returns how long a batch is held open
+func (b BatchConfig) MaxWait() time.Duration {
+	if b.MaxWaitMs > 0 {
+		return time.Duration(b.MaxWaitMs) * time.Millisecond
+	}
+	return 20 * time.Millisecond
+}
+
+func (b BatchConfig) validate() error {
+	if b.MaxSize < 0 {
+		return fmt.Errorf("maxSize must be greater than or equal to 0")
+	}
+	if b.MaxWaitMs < 0 {
+		return fmt.Errorf("maxWaitMs must be greater than or equal to 0")
+	}
+	return nil
+}
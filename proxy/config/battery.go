@@ -0,0 +1,17 @@
+package config
+
+// BatteryConfig changes how a group's models behave while the host runs on
+// battery or in a power saving profile
+type BatteryConfig struct {
+	// TTL replaces the members' ttl in seconds while on battery, 0 keeps it
+	TTL int `yaml:"ttl"`
+
+	// Unload stops the members when the host switches to battery and
+	// refuses to load them until it is back on AC power
+	Unload bool `yaml:"unload"`
+}
+
+// IsZero reports if the group behaves the same on battery
+func (b BatteryConfig) IsZero() bool {
+	return b.TTL == 0 && !b.Unload
+}
@@ -0,0 +1,72 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// ChaosConfig injects faults into selected models so clients can test their
+// retry logic and fallback chains. It only takes effect when llmsnap runs
+// with the --chaos flag.
+type ChaosConfig struct {
+	// Active is set by the --chaos flag
+	Active bool `yaml:"-"`
+
+	// Models the faults are injected into, empty is every model
+	Models []string `yaml:"models"`
+
+	// Latency in milliseconds added before each request is proxied
+	Latency int `yaml:"latency"`
+
+	// LatencyJitter in milliseconds, a random part of it is added to Latency
+	LatencyJitter int `yaml:"latencyJitter"`
+
+	// DropStreamRate is the fraction, 0 to 1, of streamed responses that are
+	// cut off
+	DropStreamRate float64 `yaml:"dropStreamRate"`
+
+	// DropAfterBytes of a dropped stream reach the client, 0 is 512
+	DropAfterBytes int `yaml:"dropAfterBytes"`
+
+	// FailStartRate is the fraction, 0 to 1, of model starts that fail
+	FailStartRate float64 `yaml:"failStartRate"`
+}
+
+// Applies reports if faults are injected into modelID
+func (c ChaosConfig) Applies(modelID string) bool {
+	return c.Active && (len(c.Models) == 0 || slices.Contains(c.Models, modelID))
+}
+
+// LatencyRange returns the least and most latency added to a request
+func (c ChaosConfig) LatencyRange() (time.Duration, time.Duration) {
+	least := time.Duration(c.Latency) * time.Millisecond
+	return least, least + time.Duration(c.LatencyJitter)*time.Millisecond
+}
+
+// DropAfter returns how many bytes of a dropped stream reach the client
+func (c ChaosConfig) DropAfter() int {
+	if c.DropAfterBytes > 0 {
+		return c.DropAfterBytes
+	}
+	return 512
+}
+
+func (c ChaosConfig) Validate() error {
+	if c.Latency < 0 {
+		return fmt.Errorf("chaos.latency must be greater than or equal to 0")
+	}
+	if c.LatencyJitter < 0 {
+		return fmt.Errorf("chaos.latencyJitter must be greater than or equal to 0")
+	}
+	if c.DropAfterBytes < 0 {
+		return fmt.Errorf("chaos.dropAfterBytes must be greater than or equal to 0")
+	}
+	if c.DropStreamRate < 0 || c.DropStreamRate > 1 {
+		return fmt.Errorf("chaos.dropStreamRate must be between 0 and 1")
+	}
+	if c.FailStartRate < 0 || c.FailStartRate > 1 {
+		return fmt.Errorf("chaos.failStartRate must be between 0 and 1")
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package config
+
+import "fmt"
+
+// ClientLimitsConfig caps the requests one client has in flight to a model so
+// a single client can not take every slot of a shared model. A client is the
+// name given by the clients rules, else its API key, else its IP address.
+type ClientLimitsConfig struct {
+	// MaxInFlight is the number of requests a client may have in flight to one
+	// model, 0 is unlimited
+	MaxInFlight int `yaml:"maxInFlight"`
+
+	// APIKeys overrides MaxInFlight for clients using these API keys, 0 is
+	// unlimited
+	APIKeys map[string]int `yaml:"apiKeys"`
+
+	// Clients overrides MaxInFlight and APIKeys for clients named by the
+	// clients rules, 0 is unlimited
+	Clients map[string]int `yaml:"clients"`
+}
+
+// Enabled reports if any client is limited
+func (c ClientLimitsConfig) Enabled() bool {
+	if c.MaxInFlight > 0 {
+		return true
+	}
+	for _, limit := range c.APIKeys {
+		if limit > 0 {
+			return true
+		}
+	}
+	for _, limit := range c.Clients {
+		if limit > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Limit returns the in-flight cap of the client named name using apiKey,
+// either may be empty
+func (c ClientLimitsConfig) Limit(name, apiKey string) int {
+	if limit, found := c.Clients[name]; found && name != "" {
+		return limit
+	}
+	if limit, found := c.APIKeys[apiKey]; found && apiKey != "" {
+		return limit
+	}
+	return c.MaxInFlight
+}
+
+// Validate checks that no negative limits were configured
+func (c ClientLimitsConfig) Validate() error {
+	if c.MaxInFlight < 0 {
+		return fmt.Errorf("clientLimits.maxInFlight must be greater than or equal to 0")
+	}
+	for _, limit := range c.APIKeys {
+		if limit < 0 {
+			return fmt.Errorf("clientLimits.apiKeys limits must be greater than or equal to 0")
+		}
+	}
+	for name, limit := range c.Clients {
+		if limit < 0 {
+			return fmt.Errorf("clientLimits.clients.%s must be greater than or equal to 0", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+)
+
+// ClientRule names the client of requests that carry a header, e.g. the
+// user header Open WebUI forwards or a User-Agent pattern, or that use one of
+// the apiKeys
+type ClientRule struct {
+	// Header is the request header checked
+	Header string `yaml:"header"`
+
+	// Match is an optional regex the header value must match. Without a Name
+	// its first capture group, when it has one, names the client.
+	Match string `yaml:"match"`
+
+	// APIKey names the requests using this key of apiKeys, instead of a
+	// header
+	APIKey string `yaml:"apiKey"`
+
+	// Name of the client, the header value itself when empty
+	Name string `yaml:"name"`
+}
+
+// ClientMatcher is a compiled ClientRule
+type ClientMatcher struct {
+	Header string
+	Match  *regexp.Regexp
+	APIKey string
+	Name   string
+}
+
+// ClientName returns the name of the client a request with these headers
+// and API key comes from, empty when it matches no rule
+func (m ClientMatcher) ClientName(header http.Header, apiKey string) string {
+	if m.APIKey != "" {
+		if apiKey != m.APIKey {
+			return ""
+		}
+		return m.Name
+	}
+
+	value := header.Get(m.Header)
+	if value == "" {
+		return ""
+	}
+	if m.Match != nil {
+		groups := m.Match.FindStringSubmatch(value)
+		if groups == nil {
+			return ""
+		}
+		if m.Name == "" && len(groups) > 1 {
+			return groups[1]
+		}
+	}
+	if m.Name != "" {
+		return m.Name
+	}
+	return value
+}
+
+// CompileClientRules compiles the rules in the order they are tried
+func CompileClientRules(rules []ClientRule) ([]ClientMatcher, error) {
+	matchers := make([]ClientMatcher, 0, len(rules))
+	for i, rule := range rules {
+		if rule.APIKey != "" {
+			if rule.Header != "" || rule.Match != "" {
+				return nil, fmt.Errorf("clients[%d]: apiKey can not be combined with header or match", i)
+			}
+			// the key itself must never show as the client name
+			if rule.Name == "" {
+				return nil, fmt.Errorf("clients[%d]: name is required with apiKey", i)
+			}
+			matchers = append(matchers, ClientMatcher{APIKey: rule.APIKey, Name: rule.Name})
+			continue
+		}
+		if rule.Header == "" {
+			return nil, fmt.Errorf("clients[%d]: header or apiKey is required", i)
+		}
+		matcher := ClientMatcher{Header: rule.Header, Name: rule.Name}
+		if rule.Match != "" {
+			re, err := regexp.Compile(rule.Match)
+			if err != nil {
+				return nil, fmt.Errorf("clients[%d]: invalid match regex: %w", i, err)
+			}
+			matcher.Match = re
+		}
+		matchers = append(matchers, matcher)
+	}
+	return matchers, nil
+}
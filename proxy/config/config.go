@@ -7,6 +7,7 @@ import (
 	"os"
 	"regexp"
 	"runtime"
+	"slices"
 	"sort"
 	"strings"
 
@@ -82,8 +83,55 @@ type GroupConfig struct {
 	Exclusive  bool     `yaml:"exclusive"`
 	Persistent bool     `yaml:"persistent"`
 	Members    []string `yaml:"members"`
+
+	// FairShare is the number of requests a model in a swap group may serve
+	// while other models are waiting before it has to give up its turn.
+	// 0 disables fair sharing.
+	FairShare int `yaml:"fairShare"`
+
+	// SwapWindow is how many milliseconds a swap group waits for more
+	// requests before swapping, so competing requests are loaded together.
+	// 0 swaps right away.
+	SwapWindow int `yaml:"swapWindow"`
+
+	// OnBattery applies while the host runs on battery, see BatteryConfig
+	OnBattery BatteryConfig `yaml:"onBattery"`
+
+	// OnEvict decides how members are idled to make room for another model,
+	// by a swap in the group or by an exclusive group
+	OnEvict EvictMode `yaml:"onEvict"`
+
+	// Schedule loads, sleeps and unloads the members at set times, see
+	// CronSchedule
+	Schedule CronSchedule `yaml:"schedule"`
+
+	// WeightBudget lets a swap group keep several members loaded as long as
+	// their weights add up to at most the budget, the least recently used
+	// ones are idled to make room. 0 runs one member at a time.
+	WeightBudget int `yaml:"weightBudget"`
+}
+
+// MemberWeight returns how much of the group's WeightBudget a member takes,
+// the whole budget when its weight is not set
+func (c GroupConfig) MemberWeight(model ModelConfig) int {
+	if model.Weight <= 0 || model.Weight > c.WeightBudget {
+		return c.WeightBudget
+	}
+	return model.Weight
 }
 
+// EvictMode is how a group idles its members
+type EvictMode string
+
+const (
+	// EvictSleep puts members with sleepMode enabled to sleep and stops the
+	// others, the default
+	EvictSleep EvictMode = EvictMode("sleep")
+
+	// EvictStop always stops members, freeing all of their memory
+	EvictStop EvictMode = EvictMode("stop")
+)
+
 var (
 	macroNameRegex    = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
 	macroPatternRegex = regexp.MustCompile(`\$\{([a-zA-Z0-9_-]+)\}`)
@@ -114,6 +162,15 @@ type HooksConfig struct {
 
 type HookOnStartup struct {
 	Preload []string `yaml:"preload"`
+
+	// Parallelism limits how many preload models, and models loaded because a
+	// requested model requires them, are started at the same time. 0 or 1
+	// starts them one after the other.
+	Parallelism int `yaml:"parallelism"`
+
+	// RestoreModels loads the models that were loaded when llmsnap last
+	// stopped, they are saved in stateDir
+	RestoreModels bool `yaml:"restoreModels"`
 }
 
 type Config struct {
@@ -130,15 +187,21 @@ type Config struct {
 	Profiles            map[string][]string    `yaml:"profiles"`
 	Groups              map[string]GroupConfig `yaml:"groups"` /* key is group ID */
 
-	// for key/value replacements in model's cmd, cmdStop, proxy, checkEndPoint
+	// for key/value replacements in model's cmd, cmdStop, proxy, checkEndPoint, checkCmd
 	Macros MacroList `yaml:"macros"`
 
 	// map aliases to actual model IDs
 	aliases map[string]string
 
+	// ports picked from portRange, keyed like the PortAllocator
+	ports map[string]portAssignment
+
 	// automatic port assignments
 	StartPort int `yaml:"startPort"`
 
+	// pick ${PORT} values from a range of free ports
+	PortRange PortRangeConfig `yaml:"portRange"`
+
 	// hooks, see: #209
 	Hooks HooksConfig `yaml:"hooks"`
 
@@ -153,6 +216,78 @@ type Config struct {
 
 	// support remote peers, see issue #433, #296
 	Peers PeerDictionaryConfig `yaml:"peers"`
+
+	// tune connections to upstream servers
+	Transport TransportConfig `yaml:"transport"`
+
+	// serve repeated deterministic requests from memory
+	ResponseCache ResponseCacheConfig `yaml:"responseCache"`
+
+	// order requests by priority when upstreams are busy
+	Scheduler SchedulerConfig `yaml:"scheduler"`
+
+	// external executables run at hook points of inference requests
+	Middleware []MiddlewareConfig `yaml:"middleware"`
+
+	// check prompts with a guard model before they reach the requested model
+	Guardrail GuardrailConfig `yaml:"guardrail"`
+
+	// remove personal data and secrets from request bodies
+	Scrub ScrubConfig `yaml:"scrub"`
+
+	// re-ask models whose replies do not match the requested JSON schema
+	StructuredOutput StructuredOutputConfig `yaml:"structuredOutput"`
+
+	// JSONL files of prompt and response pairs, see ModelConfig.CaptureDataset
+	Datasets DatasetConfig `yaml:"datasets"`
+
+	// devices ${GPU} and placement assign models to, nvidia-smi is used when empty
+	GPUs []GPUDevice `yaml:"gpus"`
+
+	// sleep models and refuse to wake them while GPUs are too hot
+	Thermal ThermalConfig `yaml:"thermal"`
+
+	// keep models loaded during time windows
+	Schedules []ScheduleConfig `yaml:"schedules"`
+
+	// directory for state kept across restarts
+	StateDir string `yaml:"stateDir"`
+
+	// advertise the server and its models on the local network
+	MDNS MDNSConfig `yaml:"mdns"`
+
+	// coordinate with other instances in front of the same GPUs
+	SharedState SharedStateConfig `yaml:"sharedState"`
+
+	// take models that keep crashing out of routing
+	Quarantine QuarantineConfig `yaml:"quarantine"`
+
+	// faults injected while running with --chaos
+	Chaos ChaosConfig `yaml:"chaos"`
+
+	// cap the requests each client has in flight to a model
+	ClientLimits ClientLimitsConfig `yaml:"clientLimits"`
+
+	// name clients by request headers, the first rule that matches wins
+	Clients []ClientRule `yaml:"clients"`
+
+	// share the server between tenants with their own models and quotas
+	Tenants map[string]TenantConfig `yaml:"tenants"`
+
+	// pass or hide upstream response headers and add llmsnap headers
+	ResponseHeaders ResponseHeadersConfig `yaml:"responseHeaders"`
+
+	// compare models on live traffic, keyed by eval name
+	Evals map[string]EvalConfig `yaml:"evals"`
+
+	// periodically write anonymized aggregate usage to a file or URL
+	UsageExport UsageExportConfig `yaml:"usageExport"`
+
+	// persist activity metrics so the history survives restarts
+	MetricsStore MetricsStoreConfig `yaml:"metricsStore"`
+
+	// model IDs that route requests by prompt size, keyed by the published ID
+	Routers map[string]RouterConfig `yaml:"routers"`
 }
 
 func (c *Config) RealModelName(search string) (string, bool) {
@@ -165,6 +300,21 @@ func (c *Config) RealModelName(search string) (string, bool) {
 	}
 }
 
+// ChatTemplateSuffix resolves a model name with a chat template suffix, like
+// "model:high", to the model ID and the chat_template_kwargs of the suffix
+func (c *Config) ChatTemplateSuffix(search string) (string, map[string]any, bool) {
+	idx := strings.LastIndex(search, ":")
+	if idx < 0 {
+		return "", nil, false
+	}
+	modelID, found := c.RealModelName(search[:idx])
+	if !found {
+		return "", nil, false
+	}
+	kwargs, found := c.Models[modelID].ChatTemplateSuffixes[search[idx+1:]]
+	return modelID, kwargs, found
+}
+
 func (c *Config) FindConfig(modelName string) (ModelConfig, string, bool) {
 	if realName, found := c.RealModelName(modelName); !found {
 		return ModelConfig{}, "", false
@@ -173,6 +323,27 @@ func (c *Config) FindConfig(modelName string) (ModelConfig, string, bool) {
 	}
 }
 
+// Requirements returns the IDs of the models modelID requires, directly or
+// through other required models, in the order they are found
+func (c *Config) Requirements(modelID string) []string {
+	var requirements []string
+	seen := map[string]bool{modelID: true}
+	queue := []string{modelID}
+	for len(queue) > 0 {
+		for _, name := range c.Models[queue[0]].Requires {
+			required, found := c.RealModelName(name)
+			if !found || seen[required] {
+				continue
+			}
+			seen[required] = true
+			requirements = append(requirements, required)
+			queue = append(queue, required)
+		}
+		queue = queue[1:]
+	}
+	return requirements
+}
+
 func LoadConfig(path string) (Config, error) {
 	file, err := os.Open(path)
 	if err != nil {
@@ -182,7 +353,22 @@ func LoadConfig(path string) (Config, error) {
 	return LoadConfigFromReader(file)
 }
 
+// LoadConfigWithPorts loads the config at path, keeping the portRange ports
+// ports has assigned. Pass the config to ports.Keep once it is used.
+func LoadConfigWithPorts(path string, ports *PortAllocator) (Config, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Config{}, err
+	}
+	defer file.Close()
+	return loadConfig(file, ports)
+}
+
 func LoadConfigFromReader(r io.Reader) (Config, error) {
+	return loadConfig(r, nil)
+}
+
+func loadConfig(r io.Reader, ports *PortAllocator) (Config, error) {
 	data, err := io.ReadAll(r)
 	if err != nil {
 		return Config{}, err
@@ -208,9 +394,18 @@ func LoadConfigFromReader(r io.Reader) (Config, error) {
 		MetricsMaxInMemory:  1000,
 		CaptureBuffer:       5,
 	}
-	if err = yaml.Unmarshal([]byte(yamlStr), &config); err != nil {
+	var root yaml.Node
+	if err = yaml.Unmarshal([]byte(yamlStr), &root); err != nil {
+		return Config{}, err
+	}
+	if err = applyModelProfiles(&root); err != nil {
 		return Config{}, err
 	}
+	if root.Kind != 0 {
+		if err = root.Decode(&config); err != nil {
+			return Config{}, err
+		}
+	}
 
 	if config.HealthCheckTimeout < 15 {
 		config.HealthCheckTimeout = 15
@@ -230,12 +425,110 @@ func LoadConfigFromReader(r io.Reader) (Config, error) {
 		return Config{}, fmt.Errorf("startPort must be greater than 1")
 	}
 
+	if config.PortRange.Enabled() && config.PortRange.Start == 0 {
+		config.PortRange.Start = config.StartPort
+	}
+	if err = config.PortRange.validate(); err != nil {
+		return Config{}, err
+	}
+
 	switch config.LogToStdout {
 	case LogToStdoutProxy, LogToStdoutUpstream, LogToStdoutBoth, LogToStdoutNone:
 	default:
 		return Config{}, fmt.Errorf("logToStdout must be one of: proxy, upstream, both, none")
 	}
 
+	if err = config.Transport.Validate(); err != nil {
+		return Config{}, err
+	}
+
+	if err = config.ResponseCache.Validate(); err != nil {
+		return Config{}, err
+	}
+
+	if err = config.Scheduler.Validate(); err != nil {
+		return Config{}, err
+	}
+
+	if err = config.Scrub.Validate(); err != nil {
+		return Config{}, err
+	}
+
+	if err = config.StructuredOutput.Validate(); err != nil {
+		return Config{}, err
+	}
+
+	if err = config.Datasets.Validate(); err != nil {
+		return Config{}, err
+	}
+
+	if err = ValidateGPUDevices(config.GPUs); err != nil {
+		return Config{}, err
+	}
+
+	if err = config.Thermal.Validate(); err != nil {
+		return Config{}, err
+	}
+
+	if err = config.MDNS.Validate(); err != nil {
+		return Config{}, err
+	}
+
+	if err = config.SharedState.Validate(); err != nil {
+		return Config{}, err
+	}
+
+	if err = config.Quarantine.Validate(); err != nil {
+		return Config{}, err
+	}
+
+	if err = config.Chaos.Validate(); err != nil {
+		return Config{}, err
+	}
+
+	if err = config.ClientLimits.Validate(); err != nil {
+		return Config{}, err
+	}
+
+	if _, err = CompileClientRules(config.Clients); err != nil {
+		return Config{}, err
+	}
+
+	if err = ValidateTenants(config.Tenants); err != nil {
+		return Config{}, err
+	}
+
+	if err = config.ResponseHeaders.Validate(); err != nil {
+		return Config{}, err
+	}
+
+	if err = ValidateRouters(config.Routers); err != nil {
+		return Config{}, err
+	}
+
+	if err = ValidateEvals(config.Evals); err != nil {
+		return Config{}, err
+	}
+
+	if err = config.UsageExport.Validate(); err != nil {
+		return Config{}, err
+	}
+
+	if err = config.MetricsStore.Validate(); err != nil {
+		return Config{}, err
+	}
+
+	middlewareNames := make(map[string]bool)
+	for _, middleware := range config.Middleware {
+		if err = middleware.Validate(); err != nil {
+			return Config{}, err
+		}
+		if middlewareNames[middleware.Name] {
+			return Config{}, fmt.Errorf("duplicate middleware name: %s", middleware.Name)
+		}
+		middlewareNames[middleware.Name] = true
+	}
+
 	// Populate the aliases map
 	config.aliases = make(map[string]string)
 	for modelName, modelConfig := range config.Models {
@@ -247,6 +540,55 @@ func LoadConfigFromReader(r io.Reader) (Config, error) {
 		}
 	}
 
+	for modelID, modelConfig := range config.Models {
+		if modelConfig.CaptureDataset && config.Datasets.Dir == "" {
+			return Config{}, fmt.Errorf("model %s: captureDataset requires datasets.dir", modelID)
+		}
+		if len(config.GPUs) > 0 {
+			for _, device := range modelConfig.Placement.Devices {
+				if !slices.ContainsFunc(config.GPUs, func(gpu GPUDevice) bool { return gpu.Device == device }) {
+					return Config{}, fmt.Errorf("model %s: placement device %s is not in gpus", modelID, device)
+				}
+			}
+		}
+		for _, name := range modelConfig.Requires {
+			required, found := config.RealModelName(name)
+			if !found {
+				return Config{}, fmt.Errorf("model %s: requires %s which is not a configured model", modelID, name)
+			}
+			if required == modelID {
+				return Config{}, fmt.Errorf("model %s: can not require itself", modelID)
+			}
+		}
+		for i, name := range modelConfig.Fallback {
+			fallback, found := config.RealModelName(name)
+			if !found {
+				return Config{}, fmt.Errorf("model %s: fallback %s is not a configured model", modelID, name)
+			}
+			if fallback == modelID || slices.Contains(modelConfig.Fallback[:i], fallback) {
+				return Config{}, fmt.Errorf("model %s: fallback %s is listed twice or is the model itself", modelID, name)
+			}
+			modelConfig.Fallback[i] = fallback
+		}
+		for suffix := range modelConfig.ChatTemplateSuffixes {
+			if suffix == "" || strings.Contains(suffix, ":") {
+				return Config{}, fmt.Errorf("model %s: invalid chatTemplateSuffixes name '%s'", modelID, suffix)
+			}
+			if _, found := config.RealModelName(modelID + ":" + suffix); found {
+				return Config{}, fmt.Errorf("model %s: chatTemplateSuffixes %s:%s conflicts with a model ID or alias", modelID, modelID, suffix)
+			}
+		}
+	}
+
+	if err = config.Guardrail.Validate(); err != nil {
+		return Config{}, err
+	}
+	if config.Guardrail.Model != "" {
+		if _, found := config.RealModelName(config.Guardrail.Model); !found {
+			return Config{}, fmt.Errorf("guardrail.model %s is not a configured model", config.Guardrail.Model)
+		}
+	}
+
 	// Validate global macros
 	for _, macro := range config.Macros {
 		if err = validateMacro(macro.Name, macro.Value); err != nil {
@@ -261,13 +603,29 @@ func LoadConfigFromReader(r io.Reader) (Config, error) {
 	}
 	sort.Strings(modelIds)
 
+	// ports count up from startPort unless they come from portRange
 	nextPort := config.StartPort
+	if ports == nil {
+		ports = NewPortAllocator()
+	}
+	portPlan := ports.plan(config.Models)
+	takePort := func(modelId, key string) (int, error) {
+		if !config.PortRange.Enabled() {
+			nextPort++
+			return nextPort - 1, nil
+		}
+		return portPlan.take(modelId, key, config.PortRange)
+	}
+
 	for _, modelId := range modelIds {
 		modelConfig := config.Models[modelId]
 
 		// Strip comments from command fields
 		modelConfig.Cmd = StripComments(modelConfig.Cmd)
 		modelConfig.CmdStop = StripComments(modelConfig.CmdStop)
+		modelConfig.CheckCmd = StripComments(modelConfig.CheckCmd)
+		modelConfig.Draft.Cmd = StripComments(modelConfig.Draft.Cmd)
+		modelConfig.Draft.CmdStop = StripComments(modelConfig.Draft.CmdStop)
 
 		// Validate model macros
 		for _, macro := range modelConfig.Macros {
@@ -296,72 +654,114 @@ func LoadConfigFromReader(r io.Reader) (Config, error) {
 			}
 		}
 
-		// Substitute remaining macros in model fields (LIFO order)
-		for i := len(mergedMacros) - 1; i >= 0; i-- {
-			entry := mergedMacros[i]
-			macroSlug := fmt.Sprintf("${%s}", entry.Name)
-			macroStr := fmt.Sprintf("%v", entry.Value)
-
-			modelConfig.Cmd = strings.ReplaceAll(modelConfig.Cmd, macroSlug, macroStr)
-			modelConfig.CmdStop = strings.ReplaceAll(modelConfig.CmdStop, macroSlug, macroStr)
-			modelConfig.Proxy = strings.ReplaceAll(modelConfig.Proxy, macroSlug, macroStr)
-			modelConfig.CheckEndpoint = strings.ReplaceAll(modelConfig.CheckEndpoint, macroSlug, macroStr)
-			modelConfig.Filters.StripParams = strings.ReplaceAll(modelConfig.Filters.StripParams, macroSlug, macroStr)
-
-			// Substitute in sleep/wake endpoint arrays
-			for j := range modelConfig.SleepEndpoints {
-				modelConfig.SleepEndpoints[j].Endpoint = strings.ReplaceAll(modelConfig.SleepEndpoints[j].Endpoint, macroSlug, macroStr)
-				modelConfig.SleepEndpoints[j].Body = strings.ReplaceAll(modelConfig.SleepEndpoints[j].Body, macroSlug, macroStr)
+		// Expand macros, macro calls and conditionals in model fields
+		expander := newMacroExpander(mergedMacros)
+		fields := map[string]*string{
+			"cmd":                 &modelConfig.Cmd,
+			"cmdStop":             &modelConfig.CmdStop,
+			"checkCmd":            &modelConfig.CheckCmd,
+			"proxy":               &modelConfig.Proxy,
+			"checkEndpoint":       &modelConfig.CheckEndpoint,
+			"filters.stripParams": &modelConfig.Filters.StripParams,
+			"draft.cmd":           &modelConfig.Draft.Cmd,
+			"draft.cmdStop":       &modelConfig.Draft.CmdStop,
+			"draft.proxy":         &modelConfig.Draft.Proxy,
+			"draft.checkEndpoint": &modelConfig.Draft.CheckEndpoint,
+		}
+		for j := range modelConfig.SleepEndpoints {
+			fields[fmt.Sprintf("sleepEndpoints[%d].endpoint", j)] = &modelConfig.SleepEndpoints[j].Endpoint
+			fields[fmt.Sprintf("sleepEndpoints[%d].body", j)] = &modelConfig.SleepEndpoints[j].Body
+		}
+		for j := range modelConfig.WakeEndpoints {
+			fields[fmt.Sprintf("wakeEndpoints[%d].endpoint", j)] = &modelConfig.WakeEndpoints[j].Endpoint
+			fields[fmt.Sprintf("wakeEndpoints[%d].body", j)] = &modelConfig.WakeEndpoints[j].Body
+		}
+		for fieldName, field := range fields {
+			if *field, err = expander.expand(*field); err != nil {
+				return Config{}, fmt.Errorf("model %s %s: %w", modelId, fieldName, err)
 			}
-			for j := range modelConfig.WakeEndpoints {
-				modelConfig.WakeEndpoints[j].Endpoint = strings.ReplaceAll(modelConfig.WakeEndpoints[j].Endpoint, macroSlug, macroStr)
-				modelConfig.WakeEndpoints[j].Body = strings.ReplaceAll(modelConfig.WakeEndpoints[j].Body, macroSlug, macroStr)
+		}
+
+		// Expand in metadata (type-preserving)
+		if len(modelConfig.Metadata) > 0 {
+			result, err := expander.expandValue(modelConfig.Metadata)
+			if err != nil {
+				return Config{}, fmt.Errorf("model %s metadata: %s", modelId, err.Error())
 			}
+			modelConfig.Metadata = result.(map[string]any)
+		}
 
-			// Substitute in metadata (type-preserving)
-			if len(modelConfig.Metadata) > 0 {
-				result, err := substituteMacroInValue(modelConfig.Metadata, entry.Name, entry.Value)
-				if err != nil {
-					return Config{}, fmt.Errorf("model %s metadata: %s", modelId, err.Error())
-				}
-				modelConfig.Metadata = result.(map[string]any)
+		if modelConfig.SendLoadingState == nil {
+			v := config.SendLoadingState
+			modelConfig.SendLoadingState = &v
+		}
+
+		// Set default timeouts on sleep/wake endpoints if not already configured
+		// Use global config timeout values as defaults
+		for i := range modelConfig.SleepEndpoints {
+			if modelConfig.SleepEndpoints[i].Timeout == 0 {
+				modelConfig.SleepEndpoints[i].Timeout = config.SleepRequestTimeout
+			}
+		}
+		for i := range modelConfig.WakeEndpoints {
+			if modelConfig.WakeEndpoints[i].Timeout == 0 {
+				modelConfig.WakeEndpoints[i].Timeout = config.WakeRequestTimeout
 			}
 		}
 
 		// Handle PORT macro - only allocate if cmd uses it
 		cmdHasPort := strings.Contains(modelConfig.Cmd, "${PORT}")
 		proxyHasPort := strings.Contains(modelConfig.Proxy, "${PORT}")
-		if cmdHasPort || proxyHasPort {
-			if !cmdHasPort && proxyHasPort {
-				return Config{}, fmt.Errorf("model %s: proxy uses ${PORT} but cmd does not - ${PORT} is only available when used in cmd", modelId)
-			}
-
-			macroSlug := "${PORT}"
-			macroStr := fmt.Sprintf("%v", nextPort)
-
-			modelConfig.Cmd = strings.ReplaceAll(modelConfig.Cmd, macroSlug, macroStr)
-			modelConfig.CmdStop = strings.ReplaceAll(modelConfig.CmdStop, macroSlug, macroStr)
-			modelConfig.Proxy = strings.ReplaceAll(modelConfig.Proxy, macroSlug, macroStr)
+		if !cmdHasPort && proxyHasPort {
+			return Config{}, fmt.Errorf("model %s: proxy uses ${PORT} but cmd does not - ${PORT} is only available when used in cmd", modelId)
+		}
+		if modelConfig.Instances > 1 && !cmdHasPort {
+			return Config{}, fmt.Errorf("model %s: instances requires ${PORT} in cmd so every instance has its own port", modelId)
+		}
 
-			// Substitute PORT in sleep/wake endpoint arrays
-			for j := range modelConfig.SleepEndpoints {
-				modelConfig.SleepEndpoints[j].Endpoint = strings.ReplaceAll(modelConfig.SleepEndpoints[j].Endpoint, macroSlug, macroStr)
-				modelConfig.SleepEndpoints[j].Body = strings.ReplaceAll(modelConfig.SleepEndpoints[j].Body, macroSlug, macroStr)
+		// every instance gets its own ${INSTANCE} and ${PORT}
+		base := modelConfig
+		for instance := range max(base.Instances, 1) {
+			instanceConfig := substituteInstance(base, instance)
+			if cmdHasPort {
+				key := modelId
+				if instance > 0 {
+					key = fmt.Sprintf("%s#%d", modelId, instance+1)
+				}
+				port, err := takePort(modelId, key)
+				if err != nil {
+					return Config{}, fmt.Errorf("model %s: %w", modelId, err)
+				}
+				if instanceConfig, err = substitutePort(instanceConfig, port); err != nil {
+					return Config{}, fmt.Errorf("model %s metadata: %s", modelId, err.Error())
+				}
 			}
-			for j := range modelConfig.WakeEndpoints {
-				modelConfig.WakeEndpoints[j].Endpoint = strings.ReplaceAll(modelConfig.WakeEndpoints[j].Endpoint, macroSlug, macroStr)
-				modelConfig.WakeEndpoints[j].Body = strings.ReplaceAll(modelConfig.WakeEndpoints[j].Body, macroSlug, macroStr)
+			if instance == 0 {
+				modelConfig = instanceConfig
+			} else {
+				modelConfig.replicas = append(modelConfig.replicas, instanceConfig)
 			}
+		}
 
-			if len(modelConfig.Metadata) > 0 {
-				result, err := substituteMacroInValue(modelConfig.Metadata, "PORT", nextPort)
+		// the draft gets the next port, the model reaches it with ${DRAFT_PROXY}
+		if modelConfig.Draft.Enabled() {
+			draft := &modelConfig.Draft
+			if strings.Contains(draft.Cmd, "${PORT}") {
+				draftPort, err := takePort(modelId, modelId+".draft")
 				if err != nil {
-					return Config{}, fmt.Errorf("model %s metadata: %s", modelId, err.Error())
+					return Config{}, fmt.Errorf("model %s: draft: %w", modelId, err)
 				}
-				modelConfig.Metadata = result.(map[string]any)
+				port := fmt.Sprintf("%v", draftPort)
+				draft.Cmd = strings.ReplaceAll(draft.Cmd, "${PORT}", port)
+				draft.CmdStop = strings.ReplaceAll(draft.CmdStop, "${PORT}", port)
+				draft.Proxy = strings.ReplaceAll(draft.Proxy, "${PORT}", port)
+			} else if strings.Contains(draft.Proxy, "${PORT}") {
+				return Config{}, fmt.Errorf("model %s: draft.proxy uses ${PORT} but draft.cmd does not - ${PORT} is only available when used in draft.cmd", modelId)
+			}
+			modelConfig.Cmd = strings.ReplaceAll(modelConfig.Cmd, "${DRAFT_PROXY}", draft.Proxy)
+			if _, err := url.Parse(draft.Proxy); err != nil {
+				return Config{}, fmt.Errorf("model %s: invalid draft.proxy URL: %w", modelId, err)
 			}
-
-			nextPort++
 		}
 
 		// Validate no unknown macros remain
@@ -370,16 +770,24 @@ func LoadConfigFromReader(r io.Reader) (Config, error) {
 			"cmdStop":             modelConfig.CmdStop,
 			"proxy":               modelConfig.Proxy,
 			"checkEndpoint":       modelConfig.CheckEndpoint,
+			"checkCmd":            modelConfig.CheckCmd,
 			"filters.stripParams": modelConfig.Filters.StripParams,
+			"draft.cmd":           modelConfig.Draft.Cmd,
+			"draft.cmdStop":       modelConfig.Draft.CmdStop,
+			"draft.proxy":         modelConfig.Draft.Proxy,
+			"draft.checkEndpoint": modelConfig.Draft.CheckEndpoint,
 		}
 
 		for fieldName, fieldValue := range fieldMap {
 			matches := macroPatternRegex.FindAllStringSubmatch(fieldValue, -1)
 			for _, match := range matches {
 				macroName := match[1]
-				if macroName == "PID" && fieldName == "cmdStop" {
+				if macroName == "PID" && (fieldName == "cmdStop" || fieldName == "checkCmd" || fieldName == "draft.cmdStop") {
 					continue // replaced at runtime
 				}
+				if macroName == "GPU" && fieldName == "cmd" {
+					continue // replaced on every start
+				}
 				if macroName == "PORT" || macroName == "MODEL_ID" {
 					return Config{}, fmt.Errorf("macro '${%s}' should have been substituted in %s.%s", macroName, modelId, fieldName)
 				}
@@ -405,32 +813,41 @@ func LoadConfigFromReader(r io.Reader) (Config, error) {
 			return Config{}, fmt.Errorf("model %s: invalid proxy URL: %w", modelId, err)
 		}
 
-		if modelConfig.SendLoadingState == nil {
-			v := config.SendLoadingState
-			modelConfig.SendLoadingState = &v
-		}
-
-		// Set default timeouts on sleep/wake endpoints if not already configured
-		// Use global config timeout values as defaults
-		for i := range modelConfig.SleepEndpoints {
-			if modelConfig.SleepEndpoints[i].Timeout == 0 {
-				modelConfig.SleepEndpoints[i].Timeout = config.SleepRequestTimeout
-			}
-		}
-		for i := range modelConfig.WakeEndpoints {
-			if modelConfig.WakeEndpoints[i].Timeout == 0 {
-				modelConfig.WakeEndpoints[i].Timeout = config.WakeRequestTimeout
-			}
-		}
-
 		config.Models[modelId] = modelConfig
 	}
+	if config.PortRange.Enabled() {
+		config.ports = portPlan.result()
+	}
 
 	config = AddDefaultGroupToConfig(config)
 
 	// Validate group members
 	memberUsage := make(map[string]string)
 	for groupID, groupConfig := range config.Groups {
+		if groupConfig.FairShare < 0 {
+			return Config{}, fmt.Errorf("fairShare must be greater than or equal to 0 in group: %s", groupID)
+		}
+		if groupConfig.SwapWindow < 0 {
+			return Config{}, fmt.Errorf("swapWindow must be greater than or equal to 0 in group: %s", groupID)
+		}
+		if groupConfig.OnBattery.TTL < 0 {
+			return Config{}, fmt.Errorf("onBattery.ttl must be greater than or equal to 0 in group: %s", groupID)
+		}
+		switch groupConfig.OnEvict {
+		case "", EvictSleep, EvictStop:
+		default:
+			return Config{}, fmt.Errorf("invalid onEvict value '%s' in group: %s, must be 'sleep' or 'stop'", groupConfig.OnEvict, groupID)
+		}
+		if err := groupConfig.Schedule.validate(); err != nil {
+			return Config{}, fmt.Errorf("schedule in group %s: %v", groupID, err)
+		}
+		if groupConfig.WeightBudget < 0 {
+			return Config{}, fmt.Errorf("weightBudget must be greater than or equal to 0 in group: %s", groupID)
+		}
+		if groupConfig.WeightBudget > 0 && (groupConfig.FairShare > 0 || groupConfig.SwapWindow > 0) {
+			return Config{}, fmt.Errorf("weightBudget can not be used with fairShare or swapWindow in group: %s", groupID)
+		}
+
 		prevSet := make(map[string]bool)
 		for _, member := range groupConfig.Members {
 			if _, found := prevSet[member]; found {
@@ -442,6 +859,85 @@ func LoadConfigFromReader(r io.Reader) (Config, error) {
 				return Config{}, fmt.Errorf("model member %s is used in multiple groups: %s and %s", member, existingGroup, groupID)
 			}
 			memberUsage[member] = groupID
+
+			if weight := config.Models[member].Weight; groupConfig.WeightBudget > 0 && weight > groupConfig.WeightBudget {
+				return Config{}, fmt.Errorf("model %s has weight %d, more than the weightBudget %d of group: %s", member, weight, groupConfig.WeightBudget, groupID)
+			}
+		}
+	}
+
+	// Validate schedules, models are stored as their real IDs
+	for i, schedule := range config.Schedules {
+		if err = schedule.Validate(); err != nil {
+			return Config{}, fmt.Errorf("schedules[%d]: %v", i, err)
+		}
+		for _, groupID := range schedule.Groups {
+			if _, found := config.Groups[groupID]; !found {
+				return Config{}, fmt.Errorf("schedules[%d]: group %s not found", i, groupID)
+			}
+		}
+		for j, modelID := range schedule.Models {
+			realModelID, found := config.RealModelName(modelID)
+			if !found {
+				return Config{}, fmt.Errorf("schedules[%d]: model %s not found", i, modelID)
+			}
+			config.Schedules[i].Models[j] = realModelID
+		}
+	}
+
+	// chaos models are stored as their real IDs
+	for i, modelID := range config.Chaos.Models {
+		realModelID, found := config.RealModelName(modelID)
+		if !found {
+			return Config{}, fmt.Errorf("chaos.models: model %s not found", modelID)
+		}
+		config.Chaos.Models[i] = realModelID
+	}
+
+	// tenant models are stored as their real IDs
+	for name, tenant := range config.Tenants {
+		for i, modelID := range tenant.Models {
+			realModelID, found := config.RealModelName(modelID)
+			if !found {
+				return Config{}, fmt.Errorf("tenants.%s.models: model %s not found", name, modelID)
+			}
+			tenant.Models[i] = realModelID
+		}
+	}
+
+	// eval models are stored as their real IDs
+	for name, eval := range config.Evals {
+		for _, field := range []struct {
+			key   string
+			value *string
+		}{{"model", &eval.Model}, {"candidate", &eval.Candidate}, {"judge", &eval.Judge}} {
+			if *field.value == "" {
+				continue
+			}
+			realModelID, found := config.RealModelName(*field.value)
+			if !found {
+				return Config{}, fmt.Errorf("evals.%s.%s: model %s not found", name, field.key, *field.value)
+			}
+			*field.value = realModelID
+		}
+		if eval.Model == eval.Candidate {
+			return Config{}, fmt.Errorf("evals.%s: candidate must be a different model than model", name)
+		}
+		config.Evals[name] = eval
+	}
+
+	// router models are stored as their real IDs, a router ID can not be
+	// taken by a model
+	for name, router := range config.Routers {
+		if _, found := config.RealModelName(name); found {
+			return Config{}, fmt.Errorf("routers.%s: conflicts with a model ID or alias", name)
+		}
+		for i, rule := range router.Route {
+			realModelID, found := config.RealModelName(rule.Model)
+			if !found {
+				return Config{}, fmt.Errorf("routers.%s.route[%d]: model %s not found", name, i, rule.Model)
+			}
+			router.Route[i].Model = realModelID
 		}
 	}
 
@@ -460,6 +956,14 @@ func LoadConfigFromReader(r io.Reader) (Config, error) {
 		config.Hooks.OnStartup.Preload = toPreload
 	}
 
+	if config.Hooks.OnStartup.RestoreModels && config.StateDir == "" {
+		return Config{}, fmt.Errorf("hooks.on_startup.restoreModels requires stateDir")
+	}
+
+	if config.Hooks.OnStartup.Parallelism < 0 {
+		return Config{}, fmt.Errorf("hooks.on_startup.parallelism must be greater than or equal to 0")
+	}
+
 	// Validate API keys (env macros already substituted at string level)
 	for i, apikey := range config.RequiredAPIKeys {
 		if apikey == "" {
@@ -473,23 +977,22 @@ func LoadConfigFromReader(r io.Reader) (Config, error) {
 
 	// Process peers with global macro substitution
 	for peerName, peerConfig := range config.Peers {
-		// Substitute global macros (LIFO order)
-		for i := len(config.Macros) - 1; i >= 0; i-- {
-			entry := config.Macros[i]
-			macroSlug := fmt.Sprintf("${%s}", entry.Name)
-			macroStr := fmt.Sprintf("%v", entry.Value)
-
-			peerConfig.ApiKey = strings.ReplaceAll(peerConfig.ApiKey, macroSlug, macroStr)
-			peerConfig.Filters.StripParams = strings.ReplaceAll(peerConfig.Filters.StripParams, macroSlug, macroStr)
-
-			// Substitute in setParams (type-preserving)
-			if len(peerConfig.Filters.SetParams) > 0 {
-				result, err := substituteMacroInValue(peerConfig.Filters.SetParams, entry.Name, entry.Value)
-				if err != nil {
-					return Config{}, fmt.Errorf("peers.%s.filters.setParams: %w", peerName, err)
-				}
-				peerConfig.Filters.SetParams = result.(map[string]any)
+		// Expand global macros
+		expander := newMacroExpander(config.Macros)
+		if peerConfig.ApiKey, err = expander.expand(peerConfig.ApiKey); err != nil {
+			return Config{}, fmt.Errorf("peers.%s.apiKey: %w", peerName, err)
+		}
+		if peerConfig.Filters.StripParams, err = expander.expand(peerConfig.Filters.StripParams); err != nil {
+			return Config{}, fmt.Errorf("peers.%s.filters.stripParams: %w", peerName, err)
+		}
+
+		// Expand in setParams (type-preserving)
+		if len(peerConfig.Filters.SetParams) > 0 {
+			result, err := expander.expandValue(peerConfig.Filters.SetParams)
+			if err != nil {
+				return Config{}, fmt.Errorf("peers.%s.filters.setParams: %w", peerName, err)
 			}
+			peerConfig.Filters.SetParams = result.(map[string]any)
 		}
 
 		// Validate no unknown macros remain
@@ -630,7 +1133,7 @@ func validateMacro(name string, value any) error {
 	}
 
 	switch name {
-	case "PORT", "MODEL_ID":
+	case "PORT", "MODEL_ID", "GPU", "INSTANCE", "DRAFT_PROXY":
 		return fmt.Errorf("macro name '%s' is reserved", name)
 	}
 
@@ -693,8 +1196,57 @@ func validateEndpointMacros(endpoints []HTTPEndpoint, modelId, endpointType stri
 	return nil
 }
 
+// substituteInstance replaces ${INSTANCE} with the instance's index, 0 for
+// the first, in the fields of m that start and reach the upstream. The env of
+// m is copied, not changed.
+func substituteInstance(m ModelConfig, instance int) ModelConfig {
+	macroStr := fmt.Sprintf("%v", instance)
+	m.Env = slices.Clone(m.Env)
+	for i := range m.Env {
+		m.Env[i] = strings.ReplaceAll(m.Env[i], "${INSTANCE}", macroStr)
+	}
+	m.Cmd = strings.ReplaceAll(m.Cmd, "${INSTANCE}", macroStr)
+	m.CmdStop = strings.ReplaceAll(m.CmdStop, "${INSTANCE}", macroStr)
+	m.CheckCmd = strings.ReplaceAll(m.CheckCmd, "${INSTANCE}", macroStr)
+	m.Proxy = strings.ReplaceAll(m.Proxy, "${INSTANCE}", macroStr)
+	m.CheckEndpoint = strings.ReplaceAll(m.CheckEndpoint, "${INSTANCE}", macroStr)
+	return m
+}
+
+// substitutePort replaces ${PORT} with port in the fields of m that may use
+// it. The endpoints and metadata of m are copied, not changed.
+func substitutePort(m ModelConfig, port int) (ModelConfig, error) {
+	macroSlug := "${PORT}"
+	macroStr := fmt.Sprintf("%v", port)
+
+	m.Cmd = strings.ReplaceAll(m.Cmd, macroSlug, macroStr)
+	m.CmdStop = strings.ReplaceAll(m.CmdStop, macroSlug, macroStr)
+	m.CheckCmd = strings.ReplaceAll(m.CheckCmd, macroSlug, macroStr)
+	m.Proxy = strings.ReplaceAll(m.Proxy, macroSlug, macroStr)
+
+	// Substitute PORT in sleep/wake endpoint arrays
+	m.SleepEndpoints = slices.Clone(m.SleepEndpoints)
+	for j := range m.SleepEndpoints {
+		m.SleepEndpoints[j].Endpoint = strings.ReplaceAll(m.SleepEndpoints[j].Endpoint, macroSlug, macroStr)
+		m.SleepEndpoints[j].Body = strings.ReplaceAll(m.SleepEndpoints[j].Body, macroSlug, macroStr)
+	}
+	m.WakeEndpoints = slices.Clone(m.WakeEndpoints)
+	for j := range m.WakeEndpoints {
+		m.WakeEndpoints[j].Endpoint = strings.ReplaceAll(m.WakeEndpoints[j].Endpoint, macroSlug, macroStr)
+		m.WakeEndpoints[j].Body = strings.ReplaceAll(m.WakeEndpoints[j].Body, macroSlug, macroStr)
+	}
+
+	if len(m.Metadata) > 0 {
+		result, err := substituteMacroInValue(m.Metadata, "PORT", port)
+		if err != nil {
+			return m, err
+		}
+		m.Metadata = result.(map[string]any)
+	}
+	return m, nil
+}
+
 // substituteMacroInValue recursively substitutes a single macro in a value structure
-// This is called once per macro, allowing LIFO substitution order
 func substituteMacroInValue(value any, macroName string, macroValue any) (any, error) {
 	macroSlug := fmt.Sprintf("${%s}", macroName)
 	macroStr := fmt.Sprintf("%v", macroValue)
@@ -1,9 +1,13 @@
 package config
 
 import (
+	"net/http"
+	"os"
+	"path/filepath"
 	"slices"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -1556,6 +1560,53 @@ models:
 	assert.Contains(t, err.Error(), "sleepEndpoints")
 }
 
+func TestConfig_SleepAfter(t *testing.T) {
+	load := func(model string) error {
+		_, err := LoadConfigFromReader(strings.NewReader("models:\n  test-model:\n    cmd: server --port ${PORT}\n" + model))
+		return err
+	}
+	sleep := `    sleepMode: enable
+    sleepEndpoints:
+      - endpoint: /sleep
+    wakeEndpoints:
+      - endpoint: /wake_up
+`
+
+	assert.NoError(t, load(sleep+"    sleepAfter: 60\n    ttl: 600\n"))
+	assert.NoError(t, load(sleep+"    sleepAfter: 60\n"))
+
+	err := load(sleep + "    sleepAfter: -1\n")
+	assert.ErrorContains(t, err, "sleepAfter must be non-negative")
+
+	err = load(sleep + "    sleepAfter: 600\n    ttl: 600\n")
+	assert.ErrorContains(t, err, "must be less than ttl")
+
+	err = load("    sleepAfter: 60\n")
+	assert.ErrorContains(t, err, "sleepAfter requires sleepMode 'enable'")
+}
+
+func TestConfig_Batch(t *testing.T) {
+	load := func(model string) (Config, error) {
+		return LoadConfigFromReader(strings.NewReader("models:\n  embed:\n    cmd: server --port ${PORT}\n" + model))
+	}
+
+	config, err := load("    batch:\n      maxSize: 64\n")
+	assert.NoError(t, err)
+	batch := config.Models["embed"].Batch
+	assert.True(t, batch.Enabled())
+	assert.Equal(t, 20*time.Millisecond, batch.MaxWait())
+
+	config, err = load("    batch:\n      maxSize: 1\n      maxWaitMs: 5\n")
+	assert.NoError(t, err)
+	assert.False(t, config.Models["embed"].Batch.Enabled())
+	assert.Equal(t, 5*time.Millisecond, config.Models["embed"].Batch.MaxWait())
+
+	_, err = load("    batch:\n      maxSize: -1\n")
+	assert.ErrorContains(t, err, "batch.maxSize must be greater than or equal to 0")
+	_, err = load("    batch:\n      maxWaitMs: -1\n")
+	assert.ErrorContains(t, err, "batch.maxWaitMs must be greater than or equal to 0")
+}
+
 func TestConfig_SleepWakeDefaultTimeouts(t *testing.T) {
 	content := `
 startPort: 10000
@@ -1618,3 +1669,1210 @@ models:
 	// model2 should use global macro
 	assert.Equal(t, "/sleep?level=1", config.Models["model2"].SleepEndpoints[0].Endpoint)
 }
+
+func TestConfig_Transport(t *testing.T) {
+	content := `
+transport:
+  dialTimeout: 5
+  maxIdleConnsPerHost: 256
+  responseHeaderTimeout: 600
+models:
+  model1:
+    cmd: path/to/cmd --port ${PORT}
+`
+	config, err := LoadConfigFromReader(strings.NewReader(content))
+	assert.NoError(t, err)
+	assert.Equal(t, 5, config.Transport.DialTimeout)
+	assert.Equal(t, 256, config.Transport.MaxIdleConnsPerHost)
+	assert.Equal(t, 600, config.Transport.ResponseHeaderTimeout)
+	assert.Equal(t, 0, config.Transport.IdleConnTimeout)
+
+	content = `
+transport:
+  idleConnTimeout: -1
+models:
+  model1:
+    cmd: path/to/cmd --port ${PORT}
+`
+	_, err = LoadConfigFromReader(strings.NewReader(content))
+	assert.ErrorContains(t, err, "transport.idleConnTimeout must be greater than or equal to 0")
+}
+
+func TestConfig_PreloadParallelism(t *testing.T) {
+	content := `
+hooks:
+  on_startup:
+    parallelism: 3
+    preload:
+      - model1
+models:
+  model1:
+    cmd: path/to/cmd --port ${PORT}
+`
+	config, err := LoadConfigFromReader(strings.NewReader(content))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, config.Hooks.OnStartup.Parallelism)
+
+	content = `
+hooks:
+  on_startup:
+    parallelism: -1
+models:
+  model1:
+    cmd: path/to/cmd --port ${PORT}
+`
+	_, err = LoadConfigFromReader(strings.NewReader(content))
+	assert.ErrorContains(t, err, "hooks.on_startup.parallelism must be greater than or equal to 0")
+}
+
+func TestConfig_ResponseCache(t *testing.T) {
+	content := `
+responseCache:
+  enabled: true
+  ttl: 60
+  maxSizeMB: 8
+models:
+  model1:
+    cmd: path/to/cmd --port ${PORT}
+`
+	config, err := LoadConfigFromReader(strings.NewReader(content))
+	assert.NoError(t, err)
+	assert.True(t, config.ResponseCache.Enabled)
+	assert.Equal(t, 60, config.ResponseCache.TTL)
+	assert.Equal(t, 0, config.ResponseCache.MaxEntries)
+	assert.Equal(t, 8, config.ResponseCache.MaxSizeMB)
+
+	content = `
+responseCache:
+  maxEntries: -5
+models:
+  model1:
+    cmd: path/to/cmd --port ${PORT}
+`
+	_, err = LoadConfigFromReader(strings.NewReader(content))
+	assert.ErrorContains(t, err, "responseCache.maxEntries must be greater than or equal to 0")
+
+	content = `
+responseCache:
+  dir: /var/cache/llmsnap
+models:
+  model1:
+    cmd: path/to/cmd --port ${PORT}
+    responseCache:
+      enabled: false
+  model2:
+    cmd: path/to/cmd --port ${PORT}
+    responseCache:
+      ttl: 3600
+      maxEntries: 50
+`
+	config, err = LoadConfigFromReader(strings.NewReader(content))
+	assert.NoError(t, err)
+	assert.Equal(t, "/var/cache/llmsnap", config.ResponseCache.Dir)
+	if assert.NotNil(t, config.Models["model1"].ResponseCache.Enabled) {
+		assert.False(t, *config.Models["model1"].ResponseCache.Enabled)
+	}
+	assert.Nil(t, config.Models["model2"].ResponseCache.Enabled)
+	assert.Equal(t, 3600, config.Models["model2"].ResponseCache.TTL)
+	assert.True(t, config.Models["model2"].ResponseCache.OwnLimits())
+	assert.False(t, config.Models["model1"].ResponseCache.OwnLimits())
+
+	_, err = LoadConfigFromReader(strings.NewReader(strings.Replace(content, "ttl: 3600", "ttl: -1", 1)))
+	assert.ErrorContains(t, err, "responseCache.ttl must be greater than or equal to 0")
+}
+
+func TestConfig_Scheduler(t *testing.T) {
+	content := `
+scheduler:
+  maxConcurrent: 2
+  maxWait: 30
+  priorityHeader: X-Priority
+  apiKeyPriority:
+    sk-batch: -10
+models:
+  model1:
+    cmd: path/to/cmd --port ${PORT}
+    priority: 5
+`
+	config, err := LoadConfigFromReader(strings.NewReader(content))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, config.Scheduler.MaxConcurrent)
+	assert.Equal(t, 30, config.Scheduler.MaxWait)
+	assert.Equal(t, "X-Priority", config.Scheduler.PriorityHeader)
+	assert.Equal(t, map[string]int{"sk-batch": -10}, config.Scheduler.APIKeyPriority)
+	assert.Equal(t, 5, config.Models["model1"].Priority)
+
+	content = `
+scheduler:
+  maxConcurrent: -1
+models:
+  model1:
+    cmd: path/to/cmd --port ${PORT}
+`
+	_, err = LoadConfigFromReader(strings.NewReader(content))
+	assert.ErrorContains(t, err, "scheduler.maxConcurrent must be greater than or equal to 0")
+}
+
+func TestConfig_GroupFairShare(t *testing.T) {
+	content := `
+models:
+  model1:
+    cmd: path/to/cmd --port ${PORT}
+  model2:
+    cmd: path/to/cmd --port ${PORT}
+groups:
+  G1:
+    fairShare: 4
+    members: [model1, model2]
+`
+	config, err := LoadConfigFromReader(strings.NewReader(content))
+	assert.NoError(t, err)
+	assert.Equal(t, 4, config.Groups["G1"].FairShare)
+
+	content = strings.Replace(content, "fairShare: 4", "fairShare: -1", 1)
+	_, err = LoadConfigFromReader(strings.NewReader(content))
+	assert.ErrorContains(t, err, "fairShare must be greater than or equal to 0 in group: G1")
+}
+
+func TestConfig_GroupSwapWindow(t *testing.T) {
+	content := `
+models:
+  model1:
+    cmd: path/to/cmd --port ${PORT}
+  model2:
+    cmd: path/to/cmd --port ${PORT}
+groups:
+  G1:
+    swapWindow: 250
+    members: [model1, model2]
+`
+	config, err := LoadConfigFromReader(strings.NewReader(content))
+	assert.NoError(t, err)
+	assert.Equal(t, 250, config.Groups["G1"].SwapWindow)
+
+	content = strings.Replace(content, "swapWindow: 250", "swapWindow: -1", 1)
+	_, err = LoadConfigFromReader(strings.NewReader(content))
+	assert.ErrorContains(t, err, "swapWindow must be greater than or equal to 0 in group: G1")
+}
+
+func TestConfig_Middleware(t *testing.T) {
+	content := `
+models:
+  model1:
+    cmd: path/to/cmd --port ${PORT}
+middleware:
+  - name: auth
+    hook: preRoute
+    cmd: /usr/local/bin/auth --strict
+    timeout: 2
+  - name: audit
+    hook: postResponse
+    cmd: /usr/local/bin/audit
+    models: [model1]
+`
+	config, err := LoadConfigFromReader(strings.NewReader(content))
+	assert.NoError(t, err)
+	if assert.Len(t, config.Middleware, 2) {
+		assert.Equal(t, MiddlewareConfig{
+			Name:    "auth",
+			Hook:    MiddlewarePreRoute,
+			Cmd:     "/usr/local/bin/auth --strict",
+			Timeout: 2,
+		}, config.Middleware[0])
+		assert.Equal(t, []string{"model1"}, config.Middleware[1].Models)
+	}
+
+	tests := []struct {
+		name    string
+		replace string
+		with    string
+		err     string
+	}{
+		{"invalid hook", "hook: preRoute", "hook: preRequest", "middleware auth: hook must be one of"},
+		{"missing name", "name: auth", "name: ''", "middleware name is required"},
+		{"duplicate name", "name: audit", "name: auth", "duplicate middleware name: auth"},
+		{"negative timeout", "timeout: 2", "timeout: -1", "middleware auth: timeout must be greater than or equal to 0"},
+		{"empty cmd", "cmd: /usr/local/bin/audit", "cmd: ''", "middleware audit: empty command"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadConfigFromReader(strings.NewReader(strings.Replace(content, tt.replace, tt.with, 1)))
+			assert.ErrorContains(t, err, tt.err)
+		})
+	}
+}
+
+func TestConfig_Script(t *testing.T) {
+	content := `
+models:
+  model1:
+    cmd: path/to/cmd --port ${PORT}
+    script:
+      source: |
+        function on_request(body)
+          body.mirostat = nil
+        end
+      timeout: 50
+`
+	config, err := LoadConfigFromReader(strings.NewReader(content))
+	assert.NoError(t, err)
+	script := config.Models["model1"].Script
+	assert.True(t, script.Enabled())
+	assert.Equal(t, 50*time.Millisecond, script.TimeoutDuration())
+
+	_, err = LoadConfigFromReader(strings.NewReader(strings.Replace(content, "end\n", "\n", 1)))
+	assert.ErrorContains(t, err, "script: invalid script")
+
+	_, err = LoadConfigFromReader(strings.NewReader(strings.Replace(content, "timeout: 50", "file: hook.lua", 1)))
+	assert.ErrorContains(t, err, "script: only one of file or source can be set")
+
+	_, err = LoadConfigFromReader(strings.NewReader("models:\n  m:\n    cmd: x\n    script:\n      file: /does/not/exist.lua\n"))
+	assert.ErrorContains(t, err, "script: open /does/not/exist.lua")
+}
+
+func TestConfig_WasmFilters(t *testing.T) {
+	dir := t.TempDir()
+	filter := filepath.Join(dir, "filter.wasm")
+	assert.NoError(t, os.WriteFile(filter, []byte("\x00asm\x01\x00\x00\x00"), 0644))
+	notWasm := filepath.Join(dir, "filter.txt")
+	assert.NoError(t, os.WriteFile(notWasm, []byte("text"), 0644))
+
+	content := `
+models:
+  model1:
+    cmd: path/to/cmd --port ${PORT}
+    wasmFilters:
+      - file: ` + filter + `
+        config:
+          strip: [mirostat]
+      - file: ` + filter + `
+        config: plain
+        timeout: 50
+`
+	config, err := LoadConfigFromReader(strings.NewReader(content))
+	assert.NoError(t, err)
+	filters := config.Models["model1"].WasmFilters
+	if assert.Len(t, filters, 2) {
+		conf, err := filters[0].Configuration()
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"strip":["mirostat"]}`, string(conf))
+		conf, _ = filters[1].Configuration()
+		assert.Equal(t, "plain", string(conf))
+		assert.Equal(t, 100*time.Millisecond, filters[0].TimeoutDuration())
+		assert.Equal(t, 50*time.Millisecond, filters[1].TimeoutDuration())
+	}
+
+	_, err = LoadConfigFromReader(strings.NewReader(strings.Replace(content, filter+"\n        config: plain", notWasm+"\n        config: plain", 1)))
+	assert.ErrorContains(t, err, "wasmFilters.1: "+notWasm+" is not a WASM module")
+
+	_, err = LoadConfigFromReader(strings.NewReader("models:\n  m:\n    cmd: x\n    wasmFilters:\n      - timeout: 1\n"))
+	assert.ErrorContains(t, err, "wasmFilters.0: file is required")
+}
+
+func TestConfig_Guardrail(t *testing.T) {
+	content := `
+models:
+  model1:
+    cmd: path/to/cmd --port ${PORT}
+  guard:
+    cmd: path/to/cmd --port ${PORT}
+    aliases: [llama-guard]
+guardrail:
+  model: llama-guard
+  action: tag
+  models: [model1]
+`
+	config, err := LoadConfigFromReader(strings.NewReader(content))
+	assert.NoError(t, err)
+	assert.Equal(t, GuardrailConfig{
+		Model:  "llama-guard",
+		Action: GuardrailTag,
+		Models: []string{"model1"},
+	}, config.Guardrail)
+
+	_, err = LoadConfigFromReader(strings.NewReader(strings.Replace(content, "action: tag", "action: drop", 1)))
+	assert.ErrorContains(t, err, "guardrail.action must be one of: block, tag")
+
+	_, err = LoadConfigFromReader(strings.NewReader(strings.Replace(content, "model: llama-guard", "model: nope", 1)))
+	assert.ErrorContains(t, err, "guardrail.model nope is not a configured model")
+}
+
+func TestConfig_Scrub(t *testing.T) {
+	content := `
+models:
+  model1:
+    cmd: path/to/cmd --port ${PORT}
+scrub:
+  detectors: [email, key]
+  patterns:
+    - name: employee
+      regex: 'EMP-\d{6}'
+  forward: true
+`
+	config, err := LoadConfigFromReader(strings.NewReader(content))
+	assert.NoError(t, err)
+	assert.Equal(t, ScrubConfig{
+		Detectors: []string{"email", "key"},
+		Patterns:  []ScrubPattern{{Name: "employee", Regex: `EMP-\d{6}`}},
+		Forward:   true,
+	}, config.Scrub)
+
+	rules, err := config.Scrub.Rules()
+	assert.NoError(t, err)
+	if assert.Len(t, rules, 3) {
+		assert.Equal(t, "[EMPLOYEE]", rules[2].Replacement)
+	}
+
+	tests := []struct {
+		name    string
+		replace string
+		with    string
+		err     string
+	}{
+		{"unknown detector", "[email, key]", "[email, ssn]", "scrub.detectors: unknown detector ssn, must be one of: email, key, phone"},
+		{"invalid regex", `'EMP-\d{6}'`, `'EMP-(\d'`, "scrub.patterns employee: invalid regex"},
+		{"missing name", "name: employee", "name: ''", "scrub.patterns[0]: name is required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadConfigFromReader(strings.NewReader(strings.Replace(content, tt.replace, tt.with, 1)))
+			assert.ErrorContains(t, err, tt.err)
+		})
+	}
+}
+
+func TestConfig_StructuredOutput(t *testing.T) {
+	content := `
+models:
+  model1:
+    cmd: path/to/cmd --port ${PORT}
+structuredOutput:
+  retries: 2
+`
+	config, err := LoadConfigFromReader(strings.NewReader(content))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, config.StructuredOutput.Retries)
+
+	_, err = LoadConfigFromReader(strings.NewReader(strings.Replace(content, "retries: 2", "retries: -1", 1)))
+	assert.ErrorContains(t, err, "structuredOutput.retries must be greater than or equal to 0")
+}
+
+func TestConfig_ChatTemplateSuffixes(t *testing.T) {
+	content := `
+models:
+  model1:
+    cmd: path/to/cmd --port ${PORT}
+    aliases: [m1]
+    chatTemplateSuffixes:
+      high:
+        reasoning_effort: high
+`
+	config, err := LoadConfigFromReader(strings.NewReader(content))
+	assert.NoError(t, err)
+
+	modelID, kwargs, found := config.ChatTemplateSuffix("m1:high")
+	assert.True(t, found)
+	assert.Equal(t, "model1", modelID)
+	assert.Equal(t, map[string]any{"reasoning_effort": "high"}, kwargs)
+
+	_, _, found = config.ChatTemplateSuffix("model1:low")
+	assert.False(t, found)
+	_, _, found = config.ChatTemplateSuffix("model1")
+	assert.False(t, found)
+
+	_, err = LoadConfigFromReader(strings.NewReader(strings.Replace(content, "aliases: [m1]", "aliases: ['model1:high']", 1)))
+	assert.ErrorContains(t, err, "chatTemplateSuffixes model1:high conflicts with a model ID or alias")
+}
+
+func TestConfig_Datasets(t *testing.T) {
+	content := `
+models:
+  model1:
+    cmd: path/to/cmd --port ${PORT}
+    captureDataset: true
+datasets:
+  dir: /var/lib/llmsnap/datasets
+  maxSizeMB: 50
+  maxFiles: 3
+`
+	config, err := LoadConfigFromReader(strings.NewReader(content))
+	assert.NoError(t, err)
+	assert.True(t, config.Models["model1"].CaptureDataset)
+	assert.Equal(t, DatasetConfig{Dir: "/var/lib/llmsnap/datasets", MaxSizeMB: 50, MaxFiles: 3}, config.Datasets)
+
+	_, err = LoadConfigFromReader(strings.NewReader(strings.Replace(content, "dir: /var/lib/llmsnap/datasets", "dir: ''", 1)))
+	assert.ErrorContains(t, err, "model model1: captureDataset requires datasets.dir")
+
+	_, err = LoadConfigFromReader(strings.NewReader(strings.Replace(content, "maxFiles: 3", "maxFiles: -1", 1)))
+	assert.ErrorContains(t, err, "datasets.maxFiles must be greater than or equal to 0")
+}
+
+func TestConfig_GPUs(t *testing.T) {
+	content := `
+gpus:
+  - device: "0"
+    vram: 24GiB
+  - device: "1"
+models:
+  model1:
+    cmd: path/to/cmd --port ${PORT} --main-gpu ${GPU}
+    env:
+      - LOG_FILE=/tmp/gpu-${GPU}.log
+`
+	config, err := LoadConfigFromReader(strings.NewReader(content))
+	assert.NoError(t, err)
+	assert.Equal(t, []GPUDevice{{Device: "0", VRAM: "24GiB"}, {Device: "1"}}, config.GPUs)
+
+	model := config.Models["model1"]
+	assert.True(t, model.UsesGPUMacro())
+	withGPU := model.WithGPU("1")
+	assert.Equal(t, "path/to/cmd --port 5800 --main-gpu 1", withGPU.Cmd)
+	assert.Equal(t, []string{"LOG_FILE=/tmp/gpu-1.log", "CUDA_VISIBLE_DEVICES=1"}, withGPU.Env)
+	assert.Contains(t, model.Cmd, "${GPU}", "the original config is unchanged")
+
+	_, err = LoadConfigFromReader(strings.NewReader(strings.Replace(content, `device: "1"`, `device: "0"`, 1)))
+	assert.ErrorContains(t, err, "gpus[1]: duplicate device 0")
+
+	_, err = LoadConfigFromReader(strings.NewReader(strings.Replace(content, "vram: 24GiB", "vram: lots", 1)))
+	assert.ErrorContains(t, err, "gpus[0]: vram:")
+
+	_, err = LoadConfigFromReader(strings.NewReader(strings.Replace(content, "--port ${PORT}", "--port ${PORT} --log ${GPU}", 1) + `
+    cmdStop: kill ${GPU}
+`))
+	assert.ErrorContains(t, err, "unknown macro '${GPU}' found in model1.cmdStop")
+}
+
+func TestConfig_GPUPlacement(t *testing.T) {
+	content := `
+gpus:
+  - device: "0"
+  - device: "1"
+models:
+  model1:
+    cmd: path/to/cmd --port ${PORT}
+    placement:
+      gpus: 2
+      devices: ["0", "1"]
+  model2:
+    cmd: path/to/cmd --port ${PORT}
+`
+	config, err := LoadConfigFromReader(strings.NewReader(content))
+	assert.NoError(t, err)
+
+	model := config.Models["model1"]
+	assert.Equal(t, GPUPlacement{GPUs: 2, Devices: []string{"0", "1"}}, model.Placement)
+	assert.Equal(t, 2, model.Placement.GPUCount())
+	assert.True(t, model.NeedsGPUPlacement())
+	assert.False(t, config.Models["model2"].NeedsGPUPlacement())
+	assert.Equal(t, 1, config.Models["model2"].Placement.GPUCount())
+
+	_, err = LoadConfigFromReader(strings.NewReader(strings.Replace(content, `devices: ["0", "1"]`, `devices: ["0", "2"]`, 1)))
+	assert.ErrorContains(t, err, "model model1: placement device 2 is not in gpus")
+
+	_, err = LoadConfigFromReader(strings.NewReader(strings.Replace(content, `devices: ["0", "1"]`, `devices: ["0"]`, 1)))
+	assert.ErrorContains(t, err, "placement: gpus is 2 but only 1 devices are allowed")
+
+	_, err = LoadConfigFromReader(strings.NewReader(strings.Replace(content, "gpus: 2", "gpus: -1", 1)))
+	assert.ErrorContains(t, err, "placement: gpus must be non-negative, got -1")
+}
+
+func TestConfig_Thermal(t *testing.T) {
+	content := `
+thermal:
+  maxTemperature: 85
+  maxPowerDraw: 600
+  action: refuse
+models:
+  model1:
+    cmd: path/to/cmd --port ${PORT}
+`
+	config, err := LoadConfigFromReader(strings.NewReader(content))
+	assert.NoError(t, err)
+	assert.True(t, config.Thermal.Enabled())
+	assert.Equal(t, ThermalRefuse, config.Thermal.Action)
+	assert.Equal(t, 80, config.Thermal.ResumeTemperatureC())
+	assert.Equal(t, 540.0, config.Thermal.ResumePowerDrawW())
+	assert.Equal(t, 10*time.Second, config.Thermal.Interval())
+
+	_, err = LoadConfigFromReader(strings.NewReader(strings.Replace(content, "action: refuse", "action: stop", 1)))
+	assert.ErrorContains(t, err, "thermal.action must be one of: sleep, refuse")
+
+	_, err = LoadConfigFromReader(strings.NewReader(strings.Replace(content, "action: refuse", "resumeTemperature: 90", 1)))
+	assert.ErrorContains(t, err, "thermal.resumeTemperature must be below thermal.maxTemperature")
+}
+
+func TestConfig_GroupOnBattery(t *testing.T) {
+	content := `
+models:
+  model1:
+    cmd: path/to/cmd --port ${PORT}
+groups:
+  big:
+    members: [model1]
+    onBattery:
+      ttl: 60
+      unload: true
+`
+	config, err := LoadConfigFromReader(strings.NewReader(content))
+	assert.NoError(t, err)
+	assert.Equal(t, BatteryConfig{TTL: 60, Unload: true}, config.Groups["big"].OnBattery)
+	assert.True(t, config.Groups[DEFAULT_GROUP_ID].OnBattery.IsZero())
+
+	_, err = LoadConfigFromReader(strings.NewReader(strings.Replace(content, "ttl: 60", "ttl: -1", 1)))
+	assert.ErrorContains(t, err, "onBattery.ttl must be greater than or equal to 0 in group: big")
+}
+
+func TestConfig_RestoreModelsRequiresStateDir(t *testing.T) {
+	content := `
+hooks:
+  on_startup:
+    restoreModels: true
+models:
+  model1:
+    cmd: path/to/cmd --port ${PORT}
+`
+	_, err := LoadConfigFromReader(strings.NewReader(content))
+	assert.ErrorContains(t, err, "hooks.on_startup.restoreModels requires stateDir")
+
+	config, err := LoadConfigFromReader(strings.NewReader("stateDir: /var/lib/llmsnap\n" + content))
+	assert.NoError(t, err)
+	assert.True(t, config.Hooks.OnStartup.RestoreModels)
+	assert.Equal(t, "/var/lib/llmsnap", config.StateDir)
+}
+
+func TestConfig_MDNS(t *testing.T) {
+	content := `
+mdns:
+  enabled: true
+  name: office server
+models:
+  model1:
+    cmd: path/to/cmd --port ${PORT}
+`
+	config, err := LoadConfigFromReader(strings.NewReader(content))
+	assert.NoError(t, err)
+	assert.Equal(t, MDNSConfig{Enabled: true, Name: "office server"}, config.MDNS)
+
+	_, err = LoadConfigFromReader(strings.NewReader(strings.Replace(content, "office server", "office.server", 1)))
+	assert.ErrorContains(t, err, "mdns.name must not contain a '.'")
+}
+
+func TestConfig_SharedState(t *testing.T) {
+	content := `
+sharedState:
+  redis: redis://localhost:6379/1
+  instanceURL: http://10.0.0.5:8080
+models:
+  model1:
+    cmd: path/to/cmd --port ${PORT}
+`
+	config, err := LoadConfigFromReader(strings.NewReader(content))
+	assert.NoError(t, err)
+	assert.True(t, config.SharedState.Enabled())
+	assert.Equal(t, "llmsnap", config.SharedState.Prefix())
+	assert.Equal(t, 30*time.Second, config.SharedState.Lease())
+
+	_, err = LoadConfigFromReader(strings.NewReader(strings.Replace(content, "instanceURL: http://10.0.0.5:8080", "leaseTTL: 10", 1)))
+	assert.ErrorContains(t, err, "sharedState.instanceURL is required with sharedState.redis")
+
+	_, err = LoadConfigFromReader(strings.NewReader(strings.Replace(content, "http://10.0.0.5:8080", "10.0.0.5:8080", 1)))
+	assert.ErrorContains(t, err, "sharedState.instanceURL must be an http or https URL")
+}
+
+func TestConfig_Quarantine(t *testing.T) {
+	content := `
+quarantine:
+  maxFailures: 3
+models:
+  model1:
+    cmd: path/to/cmd --port ${PORT}
+`
+	config, err := LoadConfigFromReader(strings.NewReader(content))
+	assert.NoError(t, err)
+	assert.True(t, config.Quarantine.Enabled())
+	assert.Equal(t, 10*time.Minute, config.Quarantine.WindowDuration())
+
+	_, err = LoadConfigFromReader(strings.NewReader(strings.Replace(content, "maxFailures: 3", "window: -1", 1)))
+	assert.ErrorContains(t, err, "quarantine.window must be greater than or equal to 0")
+}
+
+func TestConfig_Chaos(t *testing.T) {
+	content := `
+chaos:
+  models: [m1]
+  latency: 200
+  dropStreamRate: 0.1
+models:
+  model1:
+    cmd: path/to/cmd --port ${PORT}
+    aliases: [m1]
+`
+	config, err := LoadConfigFromReader(strings.NewReader(content))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"model1"}, config.Chaos.Models)
+	assert.Equal(t, 512, config.Chaos.DropAfter())
+
+	// only the --chaos flag turns it on
+	assert.False(t, config.Chaos.Applies("model1"))
+	config.Chaos.Active = true
+	assert.True(t, config.Chaos.Applies("model1"))
+	assert.False(t, config.Chaos.Applies("model2"))
+
+	_, err = LoadConfigFromReader(strings.NewReader(strings.Replace(content, "dropStreamRate: 0.1", "dropStreamRate: 1.5", 1)))
+	assert.ErrorContains(t, err, "chaos.dropStreamRate must be between 0 and 1")
+
+	_, err = LoadConfigFromReader(strings.NewReader(strings.Replace(content, "models: [m1]", "models: [nope]", 1)))
+	assert.ErrorContains(t, err, "chaos.models: model nope not found")
+}
+
+func TestConfig_ClientLimits(t *testing.T) {
+	content := `
+clientLimits:
+  maxInFlight: 2
+  apiKeys:
+    agent-key: 1
+    batch-key: 0
+  clients:
+    open-webui: 4
+models:
+  model1:
+    cmd: path/to/cmd --port ${PORT}
+`
+	config, err := LoadConfigFromReader(strings.NewReader(content))
+	assert.NoError(t, err)
+	assert.True(t, config.ClientLimits.Enabled())
+	assert.Equal(t, 2, config.ClientLimits.Limit("", ""))
+	assert.Equal(t, 2, config.ClientLimits.Limit("", "other-key"))
+	assert.Equal(t, 1, config.ClientLimits.Limit("", "agent-key"))
+	assert.Equal(t, 0, config.ClientLimits.Limit("", "batch-key"))
+	assert.Equal(t, 4, config.ClientLimits.Limit("open-webui", "agent-key"))
+	assert.Equal(t, 2, config.ClientLimits.Limit("cursor", ""))
+
+	_, err = LoadConfigFromReader(strings.NewReader(strings.Replace(content, "maxInFlight: 2", "maxInFlight: -1", 1)))
+	assert.ErrorContains(t, err, "clientLimits.maxInFlight must be greater than or equal to 0")
+}
+
+func TestConfig_Clients(t *testing.T) {
+	content := `
+clients:
+  - header: X-OpenWebUI-User-Name
+  - header: User-Agent
+    match: ^Cursor/
+    name: cursor
+  - apiKey: batch-key
+    name: batch
+  - header: User-Agent
+    match: ^(\w+)/
+models:
+  model1:
+    cmd: path/to/cmd --port ${PORT}
+`
+	config, err := LoadConfigFromReader(strings.NewReader(content))
+	assert.NoError(t, err)
+	matchers, err := CompileClientRules(config.Clients)
+	assert.NoError(t, err)
+	assert.Len(t, matchers, 4)
+
+	header := http.Header{}
+	header.Set("X-OpenWebUI-User-Name", "alice")
+	header.Set("User-Agent", "Cursor/1.2")
+	assert.Equal(t, "alice", matchers[0].ClientName(header, ""))
+	assert.Equal(t, "cursor", matchers[1].ClientName(header, ""))
+
+	header.Set("User-Agent", "curl/8.0")
+	assert.Equal(t, "", matchers[1].ClientName(header, ""))
+
+	// the capture group names the client
+	assert.Equal(t, "curl", matchers[3].ClientName(header, ""))
+
+	assert.Equal(t, "batch", matchers[2].ClientName(header, "batch-key"))
+	assert.Equal(t, "", matchers[2].ClientName(header, "other-key"))
+	assert.Equal(t, "", matchers[2].ClientName(header, ""))
+
+	_, err = LoadConfigFromReader(strings.NewReader(strings.Replace(content, "^Cursor/", "(", 1)))
+	assert.ErrorContains(t, err, "clients[1]: invalid match regex")
+
+	_, err = LoadConfigFromReader(strings.NewReader(strings.Replace(content, "header: User-Agent", `header: ""`, 1)))
+	assert.ErrorContains(t, err, "clients[1]: header or apiKey is required")
+
+	_, err = LoadConfigFromReader(strings.NewReader(strings.Replace(content, "    name: batch\n", "", 1)))
+	assert.ErrorContains(t, err, "clients[2]: name is required with apiKey")
+
+	_, err = LoadConfigFromReader(strings.NewReader(strings.Replace(content, "    name: batch\n", "    name: batch\n    header: X-App\n", 1)))
+	assert.ErrorContains(t, err, "clients[2]: apiKey can not be combined with header or match")
+}
+
+func TestConfig_Tenants(t *testing.T) {
+	content := `
+tenants:
+  research:
+    organizations: [org-research]
+    models: [m1]
+    maxTokens: 100000
+  support:
+    projects: [proj-support]
+models:
+  model1:
+    cmd: path/to/cmd --port ${PORT}
+    aliases: [m1]
+  model2:
+    cmd: path/to/cmd --port ${PORT}
+`
+	config, err := LoadConfigFromReader(strings.NewReader(content))
+	assert.NoError(t, err)
+	research := config.Tenants["research"]
+	assert.Equal(t, []string{"model1"}, research.Models)
+	assert.True(t, research.Allows("model1"))
+	assert.False(t, research.Allows("model2"))
+	assert.Equal(t, 24*time.Hour, research.Window())
+	assert.True(t, config.Tenants["support"].Allows("model2"))
+
+	_, err = LoadConfigFromReader(strings.NewReader(strings.Replace(content, "projects: [proj-support]", "organizations: [org-research]", 1)))
+	assert.ErrorContains(t, err, "tenants.support: organization org-research is also used by tenant research")
+
+	_, err = LoadConfigFromReader(strings.NewReader(strings.Replace(content, "models: [m1]", "models: [m3]", 1)))
+	assert.ErrorContains(t, err, "tenants.research.models: model m3 not found")
+
+	_, err = LoadConfigFromReader(strings.NewReader(strings.Replace(content, "projects: [proj-support]", "maxRequests: 5", 1)))
+	assert.ErrorContains(t, err, "tenants.support: organizations or projects is required")
+}
+
+func TestConfig_ResponseHeaders(t *testing.T) {
+	content := `
+responseHeaders:
+  allow: [x-ratelimit-*, Server]
+  deny: [server]
+  add: [x-llmsnap-model]
+models:
+  model1:
+    cmd: path/to/cmd --port ${PORT}
+`
+	config, err := LoadConfigFromReader(strings.NewReader(content))
+	assert.NoError(t, err)
+	headers := config.ResponseHeaders
+	assert.True(t, headers.Enabled())
+	assert.True(t, headers.Passes("X-Ratelimit-Remaining"))
+	assert.True(t, headers.Passes("Content-Type"))
+	assert.False(t, headers.Passes("Server"))
+	assert.False(t, headers.Passes("X-Upstream-Host"))
+	assert.True(t, headers.Adds(ModelResponseHeader))
+	assert.False(t, headers.Adds(QueueTimeResponseHeader))
+
+	_, err = LoadConfigFromReader(strings.NewReader(strings.Replace(content, "add: [x-llmsnap-model]", "add: [X-Powered-By]", 1)))
+	assert.ErrorContains(t, err, "responseHeaders.add: unknown header X-Powered-By")
+
+	_, err = LoadConfigFromReader(strings.NewReader(strings.Replace(content, "deny: [server]", `deny: ["*"]`, 1)))
+	assert.ErrorContains(t, err, "responseHeaders.deny: header names must not be empty")
+}
+
+func TestConfig_Evals(t *testing.T) {
+	content := `
+evals:
+  compare:
+    model: m1
+    candidate: model2
+    judge: model3
+    sampleRate: 0.25
+models:
+  model1:
+    cmd: path/to/cmd --port ${PORT}
+    aliases: [m1]
+  model2:
+    cmd: path/to/cmd --port ${PORT}
+  model3:
+    cmd: path/to/cmd --port ${PORT}
+`
+	config, err := LoadConfigFromReader(strings.NewReader(content))
+	assert.NoError(t, err)
+	eval := config.Evals["compare"]
+	assert.Equal(t, "model1", eval.Model)
+	assert.Equal(t, "model3", eval.Judge)
+	assert.Equal(t, 0.25, eval.Rate())
+	assert.Equal(t, DefaultEvalMaxResults, eval.Results())
+	assert.Equal(t, DefaultEvalJudgePrompt, eval.JudgeSystemPrompt())
+
+	_, err = LoadConfigFromReader(strings.NewReader(strings.Replace(content, "candidate: model2", "candidate: model1", 1)))
+	assert.ErrorContains(t, err, "evals.compare: candidate must be a different model than model")
+
+	_, err = LoadConfigFromReader(strings.NewReader(strings.Replace(content, "judge: model3", "judge: model4", 1)))
+	assert.ErrorContains(t, err, "evals.compare.judge: model model4 not found")
+
+	_, err = LoadConfigFromReader(strings.NewReader(strings.Replace(content, "sampleRate: 0.25", "sampleRate: 2", 1)))
+	assert.ErrorContains(t, err, "evals.compare.sampleRate must be between 0 and 1")
+}
+
+func TestConfig_CheckCmdMacros(t *testing.T) {
+	content := `
+macros:
+  probe: /usr/local/bin/probe
+models:
+  model1:
+    cmd: path/to/cmd --port ${PORT}
+    checkCmd: |
+      # wait for the weights to load
+      ${probe} --port ${PORT} --model ${MODEL_ID} --pid ${PID}
+`
+	config, err := LoadConfigFromReader(strings.NewReader(content))
+	assert.NoError(t, err)
+	assert.Equal(t, "/usr/local/bin/probe --port 5800 --model model1 --pid ${PID}", strings.TrimSpace(config.Models["model1"].CheckCmd))
+
+	_, err = LoadConfigFromReader(strings.NewReader(strings.Replace(content, "${probe}", "${unknown}", 1)))
+	assert.ErrorContains(t, err, "unknown macro '${unknown}' found in model1.checkCmd")
+}
+
+func TestConfig_HealthCheckExpectations(t *testing.T) {
+	content := `
+models:
+  model1:
+    cmd: path/to/cmd --port ${PORT}
+    expectBody: '"status":"ok"'
+    expectJSON:
+      model.loaded: true
+      slots: 4
+`
+	config, err := LoadConfigFromReader(strings.NewReader(content))
+	assert.NoError(t, err)
+	assert.Equal(t, `"status":"ok"`, config.Models["model1"].ExpectBody)
+	assert.Equal(t, map[string]string{"model.loaded": "true", "slots": "4"}, config.Models["model1"].ExpectJSON)
+
+	_, err = LoadConfigFromReader(strings.NewReader(content + "    checkEndpoint: none\n"))
+	assert.ErrorContains(t, err, "expectBody and expectJSON require a checkEndpoint and no checkCmd")
+
+	_, err = LoadConfigFromReader(strings.NewReader(content + "    checkCmd: is-ready\n"))
+	assert.ErrorContains(t, err, "expectBody and expectJSON require a checkEndpoint and no checkCmd")
+}
+
+func TestConfig_UsageExport(t *testing.T) {
+	content := `
+usageExport:
+  file: /var/lib/llmsnap/usage.jsonl
+  interval: 600
+  hashModels: true
+  salt: fleet
+models:
+  model1:
+    cmd: path/to/cmd --port ${PORT}
+`
+	config, err := LoadConfigFromReader(strings.NewReader(content))
+	assert.NoError(t, err)
+	assert.True(t, config.UsageExport.Enabled())
+	assert.Equal(t, 10*time.Minute, config.UsageExport.IntervalDuration())
+	assert.Equal(t, time.Hour, UsageExportConfig{}.IntervalDuration())
+	assert.False(t, UsageExportConfig{}.Enabled())
+
+	_, err = LoadConfigFromReader(strings.NewReader(strings.Replace(content, "interval: 600", "interval: -1", 1)))
+	assert.ErrorContains(t, err, "usageExport.interval must be greater than or equal to 0")
+
+	_, err = LoadConfigFromReader(strings.NewReader(strings.Replace(content, "hashModels: true", "url: ftp://collector", 1)))
+	assert.ErrorContains(t, err, "usageExport.url 'ftp://collector' must be an http or https URL")
+
+	_, err = LoadConfigFromReader(strings.NewReader(strings.Replace(content, "hashModels: true", "hashModels: false", 1)))
+	assert.ErrorContains(t, err, "usageExport.salt requires hashModels")
+}
+
+func TestConfig_Requires(t *testing.T) {
+	content := `
+models:
+  chat:
+    cmd: path/to/cmd --port ${PORT}
+    requires: [embed, rerank]
+  embed:
+    cmd: path/to/cmd --port ${PORT}
+    aliases: [embedder]
+  rerank:
+    cmd: path/to/cmd --port ${PORT}
+    requires: [embedder, chat]
+  other:
+    cmd: path/to/cmd --port ${PORT}
+`
+	config, err := LoadConfigFromReader(strings.NewReader(content))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"embed", "rerank"}, config.Requirements("chat"))
+	assert.Equal(t, []string{"embed", "chat"}, config.Requirements("rerank"))
+	assert.Empty(t, config.Requirements("other"))
+	assert.Empty(t, config.Requirements("unknown"))
+
+	_, err = LoadConfigFromReader(strings.NewReader(content + "    requires: [missing]\n"))
+	assert.ErrorContains(t, err, "model other: requires missing which is not a configured model")
+
+	_, err = LoadConfigFromReader(strings.NewReader(content + "    requires: [other]\n"))
+	assert.ErrorContains(t, err, "model other: can not require itself")
+}
+
+func TestConfig_MetricsStore(t *testing.T) {
+	content := `
+metricsStore:
+  file: /var/lib/llmsnap/activity.jsonl
+  maxAge: 168
+  maxRecords: 100000
+models:
+  model1:
+    cmd: path/to/cmd --port ${PORT}
+`
+	config, err := LoadConfigFromReader(strings.NewReader(content))
+	assert.NoError(t, err)
+	assert.True(t, config.MetricsStore.Enabled())
+	assert.Equal(t, 7*24*time.Hour, config.MetricsStore.MaxAgeDuration())
+	assert.Equal(t, 100000, config.MetricsStore.MaxRecords)
+	assert.False(t, MetricsStoreConfig{}.Enabled())
+
+	_, err = LoadConfigFromReader(strings.NewReader(strings.Replace(content, "maxAge: 168", "maxAge: -1", 1)))
+	assert.ErrorContains(t, err, "metricsStore.maxAge must be greater than or equal to 0")
+
+	_, err = LoadConfigFromReader(strings.NewReader(strings.Replace(content, "maxRecords: 100000", "maxRecords: -1", 1)))
+	assert.ErrorContains(t, err, "metricsStore.maxRecords must be greater than or equal to 0")
+}
+
+func TestConfig_Pricing(t *testing.T) {
+	content := `
+models:
+  model1:
+    cmd: path/to/cmd --port ${PORT}
+    pricing:
+      input_per_1m: 0.1
+      output_per_1m: 0.4
+`
+	config, err := LoadConfigFromReader(strings.NewReader(content))
+	assert.NoError(t, err)
+	pricing := config.Models["model1"].Pricing
+	assert.True(t, pricing.Enabled())
+	assert.InDelta(t, 0.0003, pricing.Cost(1000, 500), 1e-12)
+	assert.False(t, PricingConfig{}.Enabled())
+
+	_, err = LoadConfigFromReader(strings.NewReader(strings.Replace(content, "0.4", "-0.4", 1)))
+	assert.ErrorContains(t, err, "pricing: output_per_1m must be non-negative")
+}
+
+func TestConfig_GroupOnEvict(t *testing.T) {
+	content := `
+models:
+  model1:
+    cmd: path/to/cmd --port ${PORT}
+groups:
+  G1:
+    onEvict: stop
+    members: [model1]
+`
+	config, err := LoadConfigFromReader(strings.NewReader(content))
+	assert.NoError(t, err)
+	assert.Equal(t, EvictStop, config.Groups["G1"].OnEvict)
+
+	_, err = LoadConfigFromReader(strings.NewReader(strings.Replace(content, "onEvict: stop", "onEvict: unload", 1)))
+	assert.ErrorContains(t, err, "invalid onEvict value 'unload' in group: G1, must be 'sleep' or 'stop'")
+}
+
+func TestConfig_ModelQueue(t *testing.T) {
+	content := `
+models:
+  model1:
+    cmd: path/to/cmd --port ${PORT}
+    queue:
+      maxDepth: 20
+      timeout: 120
+`
+	config, err := LoadConfigFromReader(strings.NewReader(content))
+	assert.NoError(t, err)
+	queue := config.Models["model1"].Queue
+	assert.Equal(t, 20, queue.MaxDepth)
+	assert.Equal(t, 2*time.Minute, queue.TimeoutDuration())
+
+	_, err = LoadConfigFromReader(strings.NewReader(strings.Replace(content, "maxDepth: 20", "maxDepth: -1", 1)))
+	assert.ErrorContains(t, err, "queue: maxDepth must be non-negative")
+}
+
+func TestConfig_Rewrites(t *testing.T) {
+	content := `
+models:
+  model1:
+    cmd: path/to/cmd --port ${PORT}
+    requestRewrites:
+      - set: stream_options.include_usage
+        value: true
+        if: stream
+      - rename: max_completion_tokens
+        to: max_tokens
+    responseRewrites:
+      - delete: timings
+`
+	config, err := LoadConfigFromReader(strings.NewReader(content))
+	assert.NoError(t, err)
+	model := config.Models["model1"]
+	assert.Equal(t, []Rewrite{
+		{Set: "stream_options.include_usage", Value: true, If: "stream"},
+		{Rename: "max_completion_tokens", To: "max_tokens"},
+	}, model.RequestRewrites)
+	assert.Equal(t, []Rewrite{{Delete: "timings"}}, model.ResponseRewrites)
+
+	_, err = LoadConfigFromReader(strings.NewReader(strings.Replace(content, "to: max_tokens", "to: model", 1)))
+	assert.ErrorContains(t, err, "requestRewrites.1: model can not be rewritten")
+
+	_, err = LoadConfigFromReader(strings.NewReader(strings.Replace(content, "- delete: timings", "- to: timings", 1)))
+	assert.ErrorContains(t, err, "responseRewrites.0: exactly one of set, delete or rename is required")
+}
+
+func TestConfig_EnsureUsage(t *testing.T) {
+	content := `
+models:
+  model1:
+    cmd: path/to/cmd --port ${PORT}
+    ensureUsage: true
+`
+	config, err := LoadConfigFromReader(strings.NewReader(content))
+	assert.NoError(t, err)
+	assert.True(t, config.Models["model1"].EnsureUsage)
+}
+
+func TestConfig_Routers(t *testing.T) {
+	content := `
+routers:
+  auto:
+    name: Auto
+    route:
+      - maxTokens: 4000
+        model: small
+      - model: model2
+models:
+  model1:
+    cmd: path/to/cmd --port ${PORT}
+    aliases: [small]
+  model2:
+    cmd: path/to/cmd --port ${PORT}
+`
+	config, err := LoadConfigFromReader(strings.NewReader(content))
+	assert.NoError(t, err)
+	router := config.Routers["auto"]
+	assert.Equal(t, "model1", router.Route[0].Model)
+
+	model, ok := router.Pick(4000)
+	assert.True(t, ok)
+	assert.Equal(t, "model1", model)
+	model, ok = router.Pick(100000)
+	assert.True(t, ok)
+	assert.Equal(t, "model2", model)
+
+	_, err = LoadConfigFromReader(strings.NewReader(strings.Replace(content, "model: model2", "model: model3", 1)))
+	assert.ErrorContains(t, err, "routers.auto.route[1]: model model3 not found")
+
+	_, err = LoadConfigFromReader(strings.NewReader(strings.Replace(content, "- maxTokens: 4000", "- maxTokens: 0", 1)))
+	assert.ErrorContains(t, err, "routers.auto.route[0]: only the last rule can take any size")
+
+	_, err = LoadConfigFromReader(strings.NewReader(strings.Replace(content, "  auto:", "  model2:", 1)))
+	assert.ErrorContains(t, err, "routers.model2: conflicts with a model ID or alias")
+}
+
+func TestConfig_Fallback(t *testing.T) {
+	content := `
+models:
+  model1:
+    cmd: path/to/cmd --port ${PORT}
+    fallback: [m2, model3]
+  model2:
+    cmd: path/to/cmd --port ${PORT}
+    aliases: [m2]
+  model3:
+    cmd: path/to/cmd --port ${PORT}
+`
+	config, err := LoadConfigFromReader(strings.NewReader(content))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"model2", "model3"}, config.Models["model1"].Fallback)
+
+	_, err = LoadConfigFromReader(strings.NewReader(strings.Replace(content, "[m2, model3]", "[m2, model4]", 1)))
+	assert.ErrorContains(t, err, "model model1: fallback model4 is not a configured model")
+
+	_, err = LoadConfigFromReader(strings.NewReader(strings.Replace(content, "[m2, model3]", "[m2, model2]", 1)))
+	assert.ErrorContains(t, err, "model model1: fallback model2 is listed twice or is the model itself")
+}
+
+func TestConfig_GroupWeightBudget(t *testing.T) {
+	content := `
+models:
+  chat:
+    cmd: server --port ${PORT}
+    weight: 8
+  embed:
+    cmd: server --port ${PORT}
+    weight: 1
+  big:
+    cmd: server --port ${PORT}
+groups:
+  G1:
+    weightBudget: 10
+    members: [chat, embed, big]
+`
+	config, err := LoadConfigFromReader(strings.NewReader(content))
+	assert.NoError(t, err)
+
+	group := config.Groups["G1"]
+	assert.Equal(t, 10, group.WeightBudget)
+	assert.Equal(t, 8, group.MemberWeight(config.Models["chat"]))
+	assert.Equal(t, 1, group.MemberWeight(config.Models["embed"]))
+	assert.Equal(t, 10, group.MemberWeight(config.Models["big"]))
+
+	for _, tc := range []struct{ from, to, err string }{
+		{"weight: 8", "weight: 12", "model chat has weight 12, more than the weightBudget 10 of group: G1"},
+		{"weight: 8", "weight: -1", "weight must be non-negative"},
+		{"weightBudget: 10", "weightBudget: -1", "weightBudget must be greater than or equal to 0 in group: G1"},
+		{"weightBudget: 10", "weightBudget: 10\n    fairShare: 4", "weightBudget can not be used with fairShare or swapWindow in group: G1"},
+	} {
+		_, err := LoadConfigFromReader(strings.NewReader(strings.Replace(content, tc.from, tc.to, 1)))
+		assert.ErrorContains(t, err, tc.err)
+	}
+}
+
+func TestConfig_PortRange(t *testing.T) {
+	busy := map[int]bool{7001: true}
+	ports := newPortAllocator(func(port int) bool { return busy[port] })
+
+	load := func(models string) (Config, error) {
+		config, err := loadConfig(strings.NewReader("portRange:\n  start: 7000\n  end: 7004\nmodels:\n"+models), ports)
+		if err == nil {
+			ports.Keep(config)
+		}
+		return config, err
+	}
+	model1 := "  model1:\n    cmd: server --port ${PORT}\n    instances: 2\n"
+	model2 := "  model2:\n    cmd: server --port ${PORT}\n    draft:\n      cmd: draft --port ${PORT}\n"
+
+	// 7001 is bound by something else
+	config, err := load(model1 + model2)
+	assert.NoError(t, err)
+	assert.Equal(t, "server --port 7000", config.Models["model1"].Cmd)
+	assert.Equal(t, "server --port 7002", config.Models["model1"].InstanceConfigs()[1].Cmd)
+	assert.Equal(t, "http://localhost:7003", config.Models["model2"].Proxy)
+	assert.Equal(t, "http://localhost:7004", config.Models["model2"].Draft.Proxy)
+
+	// models keep their ports across reloads, even when now in use
+	busy[7000] = true
+	config, err = load(model2 + model1)
+	assert.NoError(t, err)
+	assert.Equal(t, "server --port 7000", config.Models["model1"].Cmd)
+	assert.Equal(t, "server --port 7003", config.Models["model2"].Cmd)
+
+	// ports of removed models are released
+	busy[7000] = false
+	config, err = load(model2 + "  model3:\n    cmd: server --port ${PORT}\n")
+	assert.NoError(t, err)
+	assert.Equal(t, "server --port 7003", config.Models["model2"].Cmd)
+	assert.Equal(t, "server --port 7000", config.Models["model3"].Cmd)
+
+	// a config that fails to load or is not used does not change the ports
+	_, err = load(model1 + model2 + "  model4:\n    cmd: server --port ${PORT}\n")
+	assert.ErrorContains(t, err, "model model4: no free port left in portRange 7000-7004")
+	_, err = loadConfig(strings.NewReader("portRange:\n  start: 7000\n  end: 7004\nmodels:\n"+model1), ports)
+	assert.NoError(t, err)
+	busy[7000] = true
+	config, err = load(model2 + "  model3:\n    cmd: server --port ${PORT}\n")
+	assert.NoError(t, err)
+	assert.Equal(t, "server --port 7003", config.Models["model2"].Cmd)
+	assert.Equal(t, "server --port 7000", config.Models["model3"].Cmd)
+
+	// start defaults to startPort
+	config, err = LoadConfigFromReader(strings.NewReader("startPort: 7002\nportRange:\n  end: 7010\nmodels:\n" + model1))
+	assert.NoError(t, err)
+	assert.Equal(t, 7002, config.PortRange.Start)
+
+	_, err = LoadConfigFromReader(strings.NewReader("portRange:\n  start: 7010\n  end: 7000\n"))
+	assert.ErrorContains(t, err, "portRange.end must be greater than or equal to portRange.start")
+	_, err = LoadConfigFromReader(strings.NewReader("portRange:\n  end: 70000\n"))
+	assert.ErrorContains(t, err, "portRange must be within 1-65535")
+}
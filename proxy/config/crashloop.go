@@ -0,0 +1,67 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// CrashLoopConfig spaces out the restarts of a model that keeps failing and
+// stops restarting it after too many failures. A failure is a start that
+// fails, a health check that times out or a process that exits on its own.
+type CrashLoopConfig struct {
+	// MaxRestarts is how many failures within Window put the model in the
+	// failed state, it is not started again until it is reset. 0 disables
+	// crash loop detection.
+	MaxRestarts int `yaml:"maxRestarts"`
+
+	// Window in seconds the failures are counted in, 0 is 300
+	Window int `yaml:"window"`
+
+	// Backoff in seconds the model is not started after a failure, doubled
+	// for every other failure within the window. 0 is 1.
+	Backoff int `yaml:"backoff"`
+
+	// MaxBackoff in seconds caps Backoff, 0 is 60
+	MaxBackoff int `yaml:"maxBackoff"`
+}
+
+// Enabled reports if crash loops are detected
+func (c CrashLoopConfig) Enabled() bool {
+	return c.MaxRestarts > 0
+}
+
+// WindowDuration returns how far back failures are counted
+func (c CrashLoopConfig) WindowDuration() time.Duration {
+	if c.Window > 0 {
+		return time.Duration(c.Window) * time.Second
+	}
+	return 5 * time.Minute
+}
+
+// BackoffDuration returns how long to wait before a start after the given
+// number of failures within the window
+func (c CrashLoopConfig) BackoffDuration(failures int) time.Duration {
+	backoff, maxBackoff := time.Second, time.Minute
+	if c.Backoff > 0 {
+		backoff = time.Duration(c.Backoff) * time.Second
+	}
+	if c.MaxBackoff > 0 {
+		maxBackoff = time.Duration(c.MaxBackoff) * time.Second
+	}
+	for i := 1; i < failures && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, maxBackoff)
+}
+
+func (c CrashLoopConfig) validate() error {
+	for _, field := range []struct {
+		name  string
+		value int
+	}{{"maxRestarts", c.MaxRestarts}, {"window", c.Window}, {"backoff", c.Backoff}, {"maxBackoff", c.MaxBackoff}} {
+		if field.value < 0 {
+			return fmt.Errorf("%s must be greater than or equal to 0", field.name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cron is a parsed five field cron expression: minute, hour, day of month,
+// month and day of week
+type Cron struct {
+	minutes, hours, days, months, weekdays []bool
+
+	// cron matches either day field when both are restricted
+	anyDay, anyWeekday bool
+}
+
+type cronField struct {
+	min, max int
+	names    []string
+}
+
+var cronFields = []cronField{
+	{0, 59, nil},
+	{0, 23, nil},
+	{1, 31, nil},
+	{1, 12, []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}},
+	// 7 is sunday too
+	{0, 7, scheduleDays},
+}
+
+// ParseCron parses an expression like "0 8 * * 1-5". Fields are *, numbers,
+// ranges, lists and steps like */15. Months and days of week may be names
+// like jan or mon.
+func ParseCron(expr string) (Cron, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return Cron{}, fmt.Errorf("invalid cron expression '%s', expected 5 fields", expr)
+	}
+
+	sets := make([][]bool, len(fields))
+	for i, field := range fields {
+		set, err := cronFields[i].parse(strings.ToLower(field))
+		if err != nil {
+			return Cron{}, fmt.Errorf("invalid cron expression '%s': %v", expr, err)
+		}
+		sets[i] = set
+	}
+
+	// sunday is 0 and 7
+	sets[4][0] = sets[4][0] || sets[4][7]
+
+	return Cron{
+		minutes:    sets[0],
+		hours:      sets[1],
+		days:       sets[2],
+		months:     sets[3],
+		weekdays:   sets[4],
+		anyDay:     strings.HasPrefix(fields[2], "*"),
+		anyWeekday: strings.HasPrefix(fields[4], "*"),
+	}, nil
+}
+
+// parse returns the values in a field, indexed by value
+func (f cronField) parse(field string) ([]bool, error) {
+	set := make([]bool, f.max+1)
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepPart); err != nil || step < 1 {
+				return nil, fmt.Errorf("invalid step '%s'", stepPart)
+			}
+		}
+
+		low, high := f.min, f.max
+		if rangePart != "*" {
+			lowPart, highPart, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if low, err = f.value(lowPart); err != nil {
+				return nil, err
+			}
+			high = low
+			if isRange {
+				if high, err = f.value(highPart); err != nil {
+					return nil, err
+				}
+			} else if hasStep {
+				high = f.max
+			}
+			if high < low {
+				return nil, fmt.Errorf("invalid range '%s'", rangePart)
+			}
+		}
+
+		for v := low; v <= high; v += step {
+			set[v] = true
+		}
+	}
+	return set, nil
+}
+
+// value parses a number or a name in the field's range
+func (f cronField) value(s string) (int, error) {
+	if i := slices.Index(f.names, s); i >= 0 {
+		return i + f.min, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("invalid value '%s', must be %d-%d", s, f.min, f.max)
+	}
+	return v, nil
+}
+
+// Matches reports if the minute of t is one of the expression's
+func (c Cron) Matches(t time.Time) bool {
+	if !c.minutes[t.Minute()] || !c.hours[t.Hour()] || !c.months[int(t.Month())] {
+		return false
+	}
+	day, weekday := c.days[t.Day()], c.weekdays[int(t.Weekday())]
+	switch {
+	case c.anyDay && c.anyWeekday:
+		return true
+	case c.anyDay:
+		return weekday
+	case c.anyWeekday:
+		return day
+	default:
+		return day || weekday
+	}
+}
+
+// CronSchedule loads, sleeps and unloads the models of a model or group at
+// times given as cron expressions. Empty expressions are not used.
+type CronSchedule struct {
+	// Preload starts or wakes the models
+	Preload string `yaml:"preload"`
+
+	// Sleep puts the models that support sleep to sleep
+	Sleep string `yaml:"sleep"`
+
+	// Unload stops the models
+	Unload string `yaml:"unload"`
+
+	// Timezone is an IANA name like Europe/Berlin, empty is the local time
+	Timezone string `yaml:"timezone"`
+}
+
+// Enabled reports if any expression is set
+func (s CronSchedule) Enabled() bool {
+	return s.Preload != "" || s.Sleep != "" || s.Unload != ""
+}
+
+// Location returns the schedule's time zone. The schedule must be valid.
+func (s CronSchedule) Location() *time.Location {
+	if s.Timezone == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+func (s CronSchedule) validate() error {
+	for _, field := range [][2]string{{"preload", s.Preload}, {"sleep", s.Sleep}, {"unload", s.Unload}} {
+		if field[1] == "" {
+			continue
+		}
+		if _, err := ParseCron(field[1]); err != nil {
+			return fmt.Errorf("%s: %v", field[0], err)
+		}
+	}
+	if _, err := time.LoadLocation(s.Timezone); err != nil {
+		return fmt.Errorf("timezone: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package config
+
+import "fmt"
+
+// DatasetConfig configures where prompt and response pairs of models with
+// captureDataset enabled are written. A value of 0 uses the default for that
+// setting.
+type DatasetConfig struct {
+	// Dir holds one JSONL file per model
+	Dir string `yaml:"dir"`
+
+	// MaxSizeMB is the size at which a model's file is rotated
+	MaxSizeMB int `yaml:"maxSizeMB"`
+
+	// MaxFiles is the number of rotated files kept per model
+	MaxFiles int `yaml:"maxFiles"`
+}
+
+// Validate checks that no negative values were configured
+func (d DatasetConfig) Validate() error {
+	if d.MaxSizeMB < 0 {
+		return fmt.Errorf("datasets.maxSizeMB must be greater than or equal to 0")
+	}
+	if d.MaxFiles < 0 {
+		return fmt.Errorf("datasets.maxFiles must be greater than or equal to 0")
+	}
+	return nil
+}
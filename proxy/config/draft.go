@@ -0,0 +1,74 @@
+package config
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// DraftConfig is a draft model for speculative decoding that runs as its own
+// server next to the model. It is started and health checked before the
+// model, stopped with it, and its logs go to the model's. The model's cmd
+// reaches it with ${DRAFT_PROXY}.
+type DraftConfig struct {
+	// Cmd starts the draft server, it gets its own ${PORT}
+	Cmd string `yaml:"cmd"`
+
+	// CmdStop stops the draft server, like the model's cmdStop
+	CmdStop string `yaml:"cmdStop"`
+
+	// Proxy is the URL of the draft server, default http://localhost:${PORT}
+	Proxy string `yaml:"proxy"`
+
+	// CheckEndpoint is checked until the draft server is healthy, default
+	// /health, none skips the check
+	CheckEndpoint string `yaml:"checkEndpoint"`
+
+	// Env is added to the environment of the draft server
+	Env []string `yaml:"env"`
+}
+
+// Enabled reports if the model has a draft
+func (d DraftConfig) Enabled() bool {
+	return d.Cmd != ""
+}
+
+func (d *DraftConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type rawDraftConfig DraftConfig
+	defaults := rawDraftConfig{
+		Proxy:         "http://localhost:${PORT}",
+		CheckEndpoint: "/health",
+		Env:           []string{},
+	}
+
+	// the same default cmdStop as models
+	if runtime.GOOS == "windows" {
+		defaults.CmdStop = "taskkill /f /t /pid ${PID}"
+	}
+
+	if err := unmarshal(&defaults); err != nil {
+		return err
+	}
+	*d = DraftConfig(defaults)
+	return nil
+}
+
+func (d DraftConfig) validate() error {
+	// the defaults are only set when draft is in the config
+	if d.Cmd == "" && d.Proxy != "" {
+		return fmt.Errorf("cmd is required")
+	}
+	return nil
+}
+
+// DraftModel returns the config the draft server of m runs with
+func (m ModelConfig) DraftModel() ModelConfig {
+	return ModelConfig{
+		Cmd:              m.Draft.Cmd,
+		CmdStop:          m.Draft.CmdStop,
+		Proxy:            m.Draft.Proxy,
+		CheckEndpoint:    m.Draft.CheckEndpoint,
+		Env:              m.Draft.Env,
+		SendLoadingState: m.SendLoadingState,
+		SleepMode:        SleepModeDisable,
+	}
+}
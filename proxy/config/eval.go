@@ -0,0 +1,84 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	DefaultEvalMaxResults = 500
+
+	DefaultEvalJudgePrompt = "You compare two answers to the same request. Rate how well each answer " +
+		"serves the request from 1 to 10. Reply with JSON only, like {\"a\": 7, \"b\": 5}."
+)
+
+// EvalConfig compares a model with a candidate on live traffic. Requests
+// for the model are mirrored to the candidate in the background, both
+// outputs are kept and a judge model may score them.
+type EvalConfig struct {
+	// Model is the model whose requests are mirrored, an ID or alias
+	Model string `yaml:"model"`
+
+	// Candidate is the model compared with Model, an ID or alias
+	Candidate string `yaml:"candidate"`
+
+	// SampleRate is the fraction of requests mirrored, 0 mirrors all
+	SampleRate float64 `yaml:"sampleRate"`
+
+	// Judge scores both outputs when set, an ID or alias
+	Judge string `yaml:"judge"`
+
+	// JudgePrompt is the judge's system prompt
+	JudgePrompt string `yaml:"judgePrompt"`
+
+	// MaxResults is the number of results kept, 0 uses the default
+	MaxResults int `yaml:"maxResults"`
+}
+
+// Rate returns the fraction of requests mirrored
+func (e EvalConfig) Rate() float64 {
+	if e.SampleRate == 0 {
+		return 1
+	}
+	return e.SampleRate
+}
+
+// JudgeSystemPrompt returns the judge's system prompt
+func (e EvalConfig) JudgeSystemPrompt() string {
+	if e.JudgePrompt == "" {
+		return DefaultEvalJudgePrompt
+	}
+	return e.JudgePrompt
+}
+
+// Results returns the number of results kept
+func (e EvalConfig) Results() int {
+	if e.MaxResults == 0 {
+		return DefaultEvalMaxResults
+	}
+	return e.MaxResults
+}
+
+// ValidateEvals checks the settings of each eval, the models are checked
+// once they are resolved to their IDs
+func ValidateEvals(evals map[string]EvalConfig) error {
+	names := make([]string, 0, len(evals))
+	for name := range evals {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		eval := evals[name]
+		if eval.Model == "" || eval.Candidate == "" {
+			return fmt.Errorf("evals.%s: model and candidate are required", name)
+		}
+		if eval.SampleRate < 0 || eval.SampleRate > 1 {
+			return fmt.Errorf("evals.%s.sampleRate must be between 0 and 1", name)
+		}
+		if eval.MaxResults < 0 {
+			return fmt.Errorf("evals.%s.maxResults must be greater than or equal to 0", name)
+		}
+	}
+	return nil
+}
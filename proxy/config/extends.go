@@ -0,0 +1,214 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// applyModelProfiles merges the modelProfiles a model extends into the
+// model before the config is decoded. Settings of the model override the
+// profile's: mappings like filters are merged key by key, env is merged by
+// variable name and other lists are replaced. Profiles can extend other
+// profiles. modelProfiles and extends are removed from root.
+func applyModelProfiles(root *yaml.Node) error {
+	doc := root
+	if doc.Kind == yaml.DocumentNode && len(doc.Content) > 0 {
+		doc = doc.Content[0]
+	}
+	if doc.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	profileNodes := make(map[string]*yaml.Node)
+	if profiles := removeKey(doc, "modelProfiles"); profiles != nil {
+		if profiles.Kind != yaml.MappingNode {
+			return fmt.Errorf("modelProfiles must be a mapping")
+		}
+		for i := 0; i < len(profiles.Content); i += 2 {
+			name, profile := profiles.Content[i].Value, resolveAlias(profiles.Content[i+1])
+			if profile.Kind != yaml.MappingNode {
+				return fmt.Errorf("modelProfiles.%s must be a mapping", name)
+			}
+			profileNodes[name] = profile
+		}
+	}
+
+	resolved := make(map[string]*yaml.Node)
+	var resolve func(name string, stack []string) (*yaml.Node, error)
+	resolve = func(name string, stack []string) (*yaml.Node, error) {
+		if node, found := resolved[name]; found {
+			return node, nil
+		}
+		if slices.Contains(stack, name) {
+			return nil, fmt.Errorf("modelProfiles: extends cycle %s", strings.Join(append(stack, name), " -> "))
+		}
+		profile, found := profileNodes[name]
+		if !found {
+			if len(stack) > 0 {
+				return nil, fmt.Errorf("modelProfiles.%s: unknown profile %s", stack[len(stack)-1], name)
+			}
+			return nil, fmt.Errorf("unknown profile %s", name)
+		}
+		var parentErr error
+		node, err := extend(profile, func(parent string) (*yaml.Node, error) {
+			node, err := resolve(parent, append(stack, name))
+			parentErr = err
+			return node, err
+		})
+		if err != nil {
+			if parentErr != nil {
+				// it already names the profile it is about
+				return nil, parentErr
+			}
+			return nil, fmt.Errorf("modelProfiles.%s: %w", name, err)
+		}
+		resolved[name] = node
+		return node, nil
+	}
+
+	models := findKey(doc, "models")
+	if models == nil || models.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i < len(models.Content); i += 2 {
+		modelID, model := models.Content[i].Value, resolveAlias(models.Content[i+1])
+		if model.Kind != yaml.MappingNode {
+			continue
+		}
+		node, err := extend(model, func(name string) (*yaml.Node, error) {
+			return resolve(name, nil)
+		})
+		if err != nil {
+			return fmt.Errorf("model %s: %w", modelID, err)
+		}
+		models.Content[i+1] = node
+	}
+	return nil
+}
+
+// extend returns node merged over the profiles in its extends, which is a
+// name or a list of names applied in order
+func extend(node *yaml.Node, profile func(name string) (*yaml.Node, error)) (*yaml.Node, error) {
+	extends := findKey(node, "extends")
+	if extends == nil {
+		return node, nil
+	}
+
+	var names []string
+	switch extends.Kind {
+	case yaml.ScalarNode:
+		names = []string{extends.Value}
+	case yaml.SequenceNode:
+		for _, item := range extends.Content {
+			if item.Kind != yaml.ScalarNode {
+				return nil, fmt.Errorf("extends must be a profile name or a list of them")
+			}
+			names = append(names, item.Value)
+		}
+	default:
+		return nil, fmt.Errorf("extends must be a profile name or a list of them")
+	}
+
+	own := &yaml.Node{Kind: yaml.MappingNode, Tag: node.Tag, Line: node.Line, Column: node.Column}
+	for i := 0; i < len(node.Content); i += 2 {
+		if node.Content[i].Value != "extends" {
+			own.Content = append(own.Content, node.Content[i], node.Content[i+1])
+		}
+	}
+
+	merged := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	for _, name := range names {
+		base, err := profile(name)
+		if err != nil {
+			return nil, err
+		}
+		merged = mergeNodes(merged, base)
+	}
+	return mergeNodes(merged, own), nil
+}
+
+// mergeNodes returns over merged into base without changing either
+func mergeNodes(base, over *yaml.Node) *yaml.Node {
+	base, over = resolveAlias(base), resolveAlias(over)
+	if base.Kind != yaml.MappingNode || over.Kind != yaml.MappingNode {
+		return over
+	}
+
+	merged := *over
+	merged.Content = slices.Clone(base.Content)
+	for i := 0; i < len(over.Content); i += 2 {
+		key, value := over.Content[i], over.Content[i+1]
+		j := -1
+		for k := 0; k+1 < len(merged.Content); k += 2 {
+			if merged.Content[k].Value == key.Value {
+				j = k
+				break
+			}
+		}
+		if j < 0 {
+			merged.Content = append(merged.Content, key, value)
+			continue
+		}
+
+		previous := resolveAlias(merged.Content[j+1])
+		if key.Value == "env" && previous.Kind == yaml.SequenceNode && resolveAlias(value).Kind == yaml.SequenceNode {
+			merged.Content[j+1] = mergeEnv(previous, resolveAlias(value))
+		} else {
+			merged.Content[j+1] = mergeNodes(previous, value)
+		}
+	}
+	return &merged
+}
+
+// mergeEnv merges two env lists of NAME=value, over replaces the entries of
+// base with the same name
+func mergeEnv(base, over *yaml.Node) *yaml.Node {
+	name := func(n *yaml.Node) string {
+		name, _, _ := strings.Cut(n.Value, "=")
+		return name
+	}
+
+	merged := *over
+	merged.Content = slices.Clone(base.Content)
+	for _, item := range over.Content {
+		i := slices.IndexFunc(merged.Content, func(n *yaml.Node) bool { return name(n) == name(item) })
+		if i >= 0 {
+			merged.Content[i] = item
+		} else {
+			merged.Content = append(merged.Content, item)
+		}
+	}
+	return &merged
+}
+
+// findKey returns the value of key in the mapping node
+func findKey(node *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return resolveAlias(node.Content[i+1])
+		}
+	}
+	return nil
+}
+
+// removeKey removes key from the mapping node and returns its value
+func removeKey(node *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			value := resolveAlias(node.Content[i+1])
+			node.Content = slices.Delete(node.Content, i, i+2)
+			return value
+		}
+	}
+	return nil
+}
+
+func resolveAlias(node *yaml.Node) *yaml.Node {
+	for node.Kind == yaml.AliasNode && node.Alias != nil {
+		node = node.Alias
+	}
+	return node
+}
@@ -0,0 +1,92 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfig_ModelProfiles(t *testing.T) {
+	content := `
+startPort: 10000
+modelProfiles:
+  llama:
+    cmd: llama-server --port ${PORT} -m /models/${MODEL_ID}.gguf ${flags}
+    macros:
+      flags: -ngl 99 -fa on
+    env:
+      - CUDA_VISIBLE_DEVICES=0
+      - LLAMA_CACHE=/cache
+    filters:
+      stripParams: temperature
+      setParams:
+        top_k: 40
+    sleepMode: enable
+    sleepEndpoints:
+      - endpoint: /sleep
+        method: POST
+    wakeEndpoints:
+      - endpoint: /wake_up
+        method: POST
+    ttl: 300
+  big:
+    extends: llama
+    macros:
+      flags: -ngl 99 -fa on -c 32768
+    ttl: 0
+  vision:
+    aliases: [vision]
+
+models:
+  small:
+    extends: llama
+  large:
+    extends: [big, vision]
+    env:
+      - CUDA_VISIBLE_DEVICES=1
+      - HF_HOME=/hf
+    filters:
+      setParams:
+        top_p: 0.9
+  plain:
+    cmd: server --port ${PORT}
+`
+	config, err := LoadConfigFromReader(strings.NewReader(content))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	small := config.Models["small"]
+	assert.Equal(t, "llama-server --port 10002 -m /models/small.gguf -ngl 99 -fa on", small.Cmd)
+	assert.Equal(t, []string{"CUDA_VISIBLE_DEVICES=0", "LLAMA_CACHE=/cache"}, small.Env)
+	assert.Equal(t, 300, small.UnloadAfter)
+	assert.Equal(t, SleepModeEnable, small.SleepMode)
+	assert.Equal(t, "/sleep", small.SleepEndpoints[0].Endpoint)
+
+	large := config.Models["large"]
+	assert.Equal(t, "llama-server --port 10000 -m /models/large.gguf -ngl 99 -fa on -c 32768", large.Cmd)
+	// env is merged by name, mappings key by key
+	assert.Equal(t, []string{"CUDA_VISIBLE_DEVICES=1", "LLAMA_CACHE=/cache", "HF_HOME=/hf"}, large.Env)
+	assert.Equal(t, "temperature", large.Filters.StripParams)
+	assert.Equal(t, map[string]any{"top_k": 40, "top_p": 0.9}, large.Filters.SetParams)
+	assert.Equal(t, 0, large.UnloadAfter)
+	assert.Equal(t, []string{"vision"}, large.Aliases)
+
+	assert.Equal(t, "server --port 10001", config.Models["plain"].Cmd)
+
+	errors := []struct {
+		content  string
+		expected string
+	}{
+		{"models:\n  m:\n    extends: nope\n", "model m: unknown profile nope"},
+		{"modelProfiles:\n  a:\n    extends: b\n  b:\n    extends: a\nmodels:\n  m:\n    extends: a\n", "model m: modelProfiles: extends cycle a -> b -> a"},
+		{"modelProfiles:\n  a:\n    extends: nope\nmodels:\n  m:\n    extends: a\n", "model m: modelProfiles.a: unknown profile nope"},
+		{"modelProfiles:\n  a: [x]\n", "modelProfiles.a must be a mapping"},
+		{"models:\n  m:\n    extends: {a: b}\n", "model m: extends must be a profile name or a list of them"},
+	}
+	for _, tt := range errors {
+		_, err := LoadConfigFromReader(strings.NewReader(tt.content))
+		assert.EqualError(t, err, tt.expected)
+	}
+}
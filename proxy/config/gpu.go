@@ -0,0 +1,110 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// GPUMacro is resolved to the device a model is started on, see GPUDevice
+const GPUMacro = "${GPU}"
+
+// GPUDevice declares a GPU that ${GPU} can assign models to. Without
+// declared devices the GPUs reported by nvidia-smi are used.
+type GPUDevice struct {
+	// Device is the value ${GPU} is replaced with, e.g. "0"
+	Device string `yaml:"device"`
+
+	// VRAM is the memory of the device, e.g. "24GiB"
+	VRAM string `yaml:"vram"`
+}
+
+// VRAMMiB returns the declared memory in MiB, 0 when it is not set
+func (d GPUDevice) VRAMMiB() int {
+	mib, _ := ParseMemoryMiB(d.VRAM)
+	return mib
+}
+
+// ValidateGPUDevices checks that devices are named, unique and have a valid vram
+func ValidateGPUDevices(devices []GPUDevice) error {
+	seen := make([]string, 0, len(devices))
+	for i, device := range devices {
+		if strings.TrimSpace(device.Device) == "" {
+			return fmt.Errorf("gpus[%d]: device is required", i)
+		}
+		if slices.Contains(seen, device.Device) {
+			return fmt.Errorf("gpus[%d]: duplicate device %s", i, device.Device)
+		}
+		seen = append(seen, device.Device)
+		if _, err := ParseMemoryMiB(device.VRAM); err != nil {
+			return fmt.Errorf("gpus[%d]: vram: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// UsesGPUMacro reports if the model's cmd or env has ${GPU}
+func (m ModelConfig) UsesGPUMacro() bool {
+	if strings.Contains(m.Cmd, GPUMacro) {
+		return true
+	}
+	return slices.ContainsFunc(m.Env, func(env string) bool {
+		return strings.Contains(env, GPUMacro)
+	})
+}
+
+// WithGPU returns a copy of the model config with ${GPU} replaced by device
+// in cmd and env. CUDA_VISIBLE_DEVICES is set to device unless env already
+// sets it.
+func (m ModelConfig) WithGPU(device string) ModelConfig {
+	m.Cmd = strings.ReplaceAll(m.Cmd, GPUMacro, device)
+
+	env := make([]string, 0, len(m.Env)+1)
+	visibleDevicesSet := false
+	for _, entry := range m.Env {
+		if strings.HasPrefix(entry, "CUDA_VISIBLE_DEVICES=") {
+			visibleDevicesSet = true
+		}
+		env = append(env, strings.ReplaceAll(entry, GPUMacro, device))
+	}
+	if !visibleDevicesSet {
+		env = append(env, "CUDA_VISIBLE_DEVICES="+device)
+	}
+	m.Env = env
+	return m
+}
+
+// GPUPlacement is what a model needs from the GPUs it is placed on
+type GPUPlacement struct {
+	// GPUs is how many devices the model is started on, 1 when not set
+	GPUs int `yaml:"gpus"`
+
+	// Devices limits the devices the model may be placed on, all when empty
+	Devices []string `yaml:"devices"`
+}
+
+// GPUCount returns how many devices the model is started on
+func (p GPUPlacement) GPUCount() int {
+	return max(1, p.GPUs)
+}
+
+// IsZero reports if no placement is set
+func (p GPUPlacement) IsZero() bool {
+	return p.GPUs == 0 && len(p.Devices) == 0
+}
+
+func (p GPUPlacement) validate() error {
+	if p.GPUs < 0 {
+		return fmt.Errorf("gpus must be non-negative, got %d", p.GPUs)
+	}
+	if len(p.Devices) > 0 && len(p.Devices) < p.GPUs {
+		return fmt.Errorf("gpus is %d but only %d devices are allowed", p.GPUs, len(p.Devices))
+	}
+	return nil
+}
+
+// NeedsGPUPlacement reports if the model is placed on GPUs when it starts,
+// either because it uses ${GPU} or has a placement
+func (m ModelConfig) NeedsGPUPlacement() bool {
+	return m.UsesGPUMacro() || !m.Placement.IsZero()
+}
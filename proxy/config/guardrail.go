@@ -0,0 +1,41 @@
+package config
+
+import "fmt"
+
+// GuardrailAction is what happens to a request the guard model flags
+type GuardrailAction string
+
+const (
+	// GuardrailBlock rejects flagged requests
+	GuardrailBlock GuardrailAction = "block"
+
+	// GuardrailTag forwards flagged requests with a verdict header
+	GuardrailTag GuardrailAction = "tag"
+)
+
+// GuardrailConfig sends prompts to a small guard model, like Llama Guard,
+// before they reach the requested model
+type GuardrailConfig struct {
+	// Model is the guard model's ID or alias, empty disables the guardrail
+	Model string `yaml:"model"`
+
+	// Action is block or tag, empty means block
+	Action GuardrailAction `yaml:"action"`
+
+	// Models limits the check to these model IDs or aliases, empty checks
+	// requests for all models
+	Models []string `yaml:"models"`
+
+	// FailOpen lets requests through when the guard model can not be reached
+	FailOpen bool `yaml:"failOpen"`
+}
+
+// Validate checks the action
+func (g GuardrailConfig) Validate() error {
+	switch g.Action {
+	case "", GuardrailBlock, GuardrailTag:
+		return nil
+	default:
+		return fmt.Errorf("guardrail.action must be one of: block, tag")
+	}
+}
@@ -0,0 +1,87 @@
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// HealthCheckConfig is how the server of a model is checked, until it is
+// ready after a start and, with an Interval, in the background while it is
+// ready. A ready model that fails FailureThreshold checks in a row is
+// unhealthy: it is stopped and the failure counts towards crashLoop and
+// quarantine.
+type HealthCheckConfig struct {
+	// Endpoint replaces checkEndpoint when set
+	Endpoint string `yaml:"endpoint"`
+
+	// Method of the check request, default GET
+	Method string `yaml:"method"`
+
+	// ExpectStatus is the status of a healthy response, default 200
+	ExpectStatus int `yaml:"expectStatus"`
+
+	// ExpectBodyContains must be in the body of a healthy response, like
+	// expectBody
+	ExpectBodyContains string `yaml:"expectBodyContains"`
+
+	// Interval in seconds between the checks of a ready model, 0 only checks
+	// the model while it starts
+	Interval int `yaml:"interval"`
+
+	// FailureThreshold is how many checks in a row have to fail for a ready
+	// model to be unhealthy, 0 is 3
+	FailureThreshold int `yaml:"failureThreshold"`
+}
+
+// RequestMethod returns the method of the check request
+func (c HealthCheckConfig) RequestMethod() string {
+	if c.Method == "" {
+		return http.MethodGet
+	}
+	return c.Method
+}
+
+// HealthyStatus returns the status of a healthy response
+func (c HealthCheckConfig) HealthyStatus() int {
+	if c.ExpectStatus == 0 {
+		return http.StatusOK
+	}
+	return c.ExpectStatus
+}
+
+// IntervalDuration returns the time between the checks of a ready model, 0
+// when it is not checked
+func (c HealthCheckConfig) IntervalDuration() time.Duration {
+	return time.Duration(c.Interval) * time.Second
+}
+
+// Threshold returns how many checks in a row fail before a ready model is
+// unhealthy
+func (c HealthCheckConfig) Threshold() int {
+	if c.FailureThreshold > 0 {
+		return c.FailureThreshold
+	}
+	return 3
+}
+
+func (c *HealthCheckConfig) validate() error {
+	c.Method = strings.ToUpper(c.Method)
+	switch c.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodPost:
+		// Valid values
+	default:
+		return fmt.Errorf("invalid method %q (must be GET, HEAD or POST)", c.Method)
+	}
+	if c.ExpectStatus != 0 && (c.ExpectStatus < 100 || c.ExpectStatus > 599) {
+		return fmt.Errorf("expectStatus must be a HTTP status code, got %d", c.ExpectStatus)
+	}
+	if c.Interval < 0 {
+		return fmt.Errorf("interval must be non-negative, got %d", c.Interval)
+	}
+	if c.FailureThreshold < 0 {
+		return fmt.Errorf("failureThreshold must be non-negative, got %d", c.FailureThreshold)
+	}
+	return nil
+}
@@ -0,0 +1,486 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var (
+	// a whole string that is one macro, its value keeps its type
+	wholeMacroRegex = regexp.MustCompile(`^\$\{([a-zA-Z0-9_-]+)\}$`)
+
+	// {{ if cond }}, {{ else if cond }}, {{ else }} and {{ end }}
+	directiveRegex = regexp.MustCompile(`^\{\{\s*(if|else\s+if|else|end)\b\s*(.*?)\s*\}\}`)
+
+	// name, name == value or name != value
+	conditionRegex = regexp.MustCompile(`^([a-zA-Z0-9_-]+)(?:\s*(==|!=)\s*(?:"([^"]*)"|([^\s"]+)))?$`)
+)
+
+// macroExpander expands macros in config strings:
+//
+//   - ${name} is replaced with the value of the macro, macros in the value
+//     are expanded too, in any order they are defined
+//   - ${name(arg=value, other="a, b")} expands name with ${arg} and ${other}
+//     set to the arguments, for it and the macros it uses. A macro of the
+//     same name is the default of an argument.
+//   - {{ if name == "value" }} ... {{ else if name }} ... {{ else }} ...
+//     {{ end }} keeps the first branch whose condition holds. A bare name
+//     holds unless its value is empty, false or 0.
+//
+// Names that are not macros, like ${PORT}, are left for later passes.
+type macroExpander struct {
+	macros MacroList
+}
+
+// macroScope is what a string is expanded with: the arguments of the macro
+// calls it is in and the macros being expanded, to find cycles
+type macroScope struct {
+	args  map[string]string
+	stack []string
+}
+
+func newMacroExpander(macros MacroList) macroExpander {
+	return macroExpander{macros: macros}
+}
+
+// expand returns s with its macros and conditionals expanded
+func (e macroExpander) expand(s string) (string, error) {
+	return e.expandIn(s, macroScope{})
+}
+
+// expandValue expands the strings in a value of a YAML map or list. A string
+// that is a single macro becomes the macro's value with its type.
+func (e macroExpander) expandValue(value any) (any, error) {
+	switch v := value.(type) {
+	case string:
+		if match := wholeMacroRegex.FindStringSubmatch(v); match != nil {
+			if typed, found := e.typedValue(match[1]); found {
+				return typed, nil
+			}
+		}
+		return e.expand(v)
+
+	case map[string]any:
+		newMap := make(map[string]any, len(v))
+		for key, val := range v {
+			newVal, err := e.expandValue(val)
+			if err != nil {
+				return nil, err
+			}
+			newMap[key] = newVal
+		}
+		return newMap, nil
+
+	case []any:
+		newSlice := make([]any, len(v))
+		for i, val := range v {
+			newVal, err := e.expandValue(val)
+			if err != nil {
+				return nil, err
+			}
+			newSlice[i] = newVal
+		}
+		return newSlice, nil
+
+	default:
+		return value, nil
+	}
+}
+
+// typedValue follows macros whose value is another single macro to a value
+// that is not a string
+func (e macroExpander) typedValue(name string) (any, bool) {
+	seen := make(map[string]bool)
+	for !seen[name] {
+		seen[name] = true
+		value, found := e.macros.Get(name)
+		if !found {
+			return nil, false
+		}
+		str, isString := value.(string)
+		if !isString {
+			return value, true
+		}
+		match := wholeMacroRegex.FindStringSubmatch(str)
+		if match == nil {
+			return nil, false
+		}
+		name = match[1]
+	}
+	return nil, false
+}
+
+func (e macroExpander) expandIn(s string, scope macroScope) (string, error) {
+	s, err := e.conditionals(s, scope)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		b.WriteString(s[:i])
+		s = s[i:]
+
+		call, n, err := parseMacroCall(s)
+		if err != nil {
+			return "", err
+		}
+		if n == 0 {
+			// not a macro, e.g. ${env.VAR} or a literal ${
+			b.WriteString("${")
+			s = s[2:]
+			continue
+		}
+
+		value, found, err := e.call(call, scope)
+		if err != nil {
+			return "", err
+		}
+		if found {
+			b.WriteString(value)
+		} else {
+			b.WriteString(s[:n])
+		}
+		s = s[n:]
+	}
+}
+
+// call expands a macro call, found is false when name is not a macro or
+// an argument
+func (e macroExpander) call(call macroCall, scope macroScope) (string, bool, error) {
+	if value, found := scope.args[call.name]; found && len(call.args) == 0 {
+		return value, true, nil
+	}
+
+	value, found := e.macros.Get(call.name)
+	if !found {
+		if len(call.args) > 0 {
+			return "", false, fmt.Errorf("unknown macro '%s' called with arguments", call.name)
+		}
+		return "", false, nil
+	}
+	if i := slices.Index(scope.stack, call.name); i >= 0 {
+		cycle := append(append([]string{}, scope.stack[i:]...), call.name)
+		return "", false, fmt.Errorf("macro cycle: %s", strings.Join(cycle, " -> "))
+	}
+
+	str, isString := value.(string)
+	if !isString {
+		if len(call.args) > 0 {
+			return "", false, fmt.Errorf("macro '%s' takes no arguments", call.name)
+		}
+		return fmt.Sprintf("%v", value), true, nil
+	}
+
+	inner := macroScope{args: scope.args, stack: append(append([]string{}, scope.stack...), call.name)}
+	if len(call.args) > 0 {
+		used := referencedNames(str)
+		inner.args = make(map[string]string, len(scope.args)+len(call.args))
+		for name, value := range scope.args {
+			inner.args[name] = value
+		}
+		for _, arg := range call.args {
+			if !used[arg.name] {
+				return "", false, fmt.Errorf("macro '%s' has no argument '%s'", call.name, arg.name)
+			}
+			// arguments are expanded where the macro is called
+			value, err := e.expandIn(arg.value, scope)
+			if err != nil {
+				return "", false, fmt.Errorf("macro '%s' argument '%s': %w", call.name, arg.name, err)
+			}
+			inner.args[arg.name] = value
+		}
+	}
+
+	expanded, err := e.expandIn(str, inner)
+	if err != nil {
+		return "", false, err
+	}
+	return expanded, true, nil
+}
+
+// conditionFrame is an {{ if }} block being read
+type conditionFrame struct {
+	condition    string
+	parentActive bool
+	active       bool
+	taken        bool
+	sawElse      bool
+}
+
+// conditionals keeps the branches of {{ if }} blocks in s whose condition
+// holds and removes the others
+func (e macroExpander) conditionals(s string, scope macroScope) (string, error) {
+	if !strings.Contains(s, "{{") {
+		return s, nil
+	}
+
+	var b strings.Builder
+	var frames []*conditionFrame
+	active := func() bool {
+		return len(frames) == 0 || frames[len(frames)-1].active
+	}
+
+	for {
+		i := strings.Index(s, "{{")
+		if i < 0 {
+			if active() {
+				b.WriteString(s)
+			}
+			break
+		}
+		if active() {
+			b.WriteString(s[:i])
+		}
+		s = s[i:]
+
+		match := directiveRegex.FindStringSubmatch(s)
+		if match == nil {
+			// not ours, e.g. a chat template
+			if active() {
+				b.WriteString("{{")
+			}
+			s = s[2:]
+			continue
+		}
+		s = s[len(match[0]):]
+
+		keyword, condition := strings.Join(strings.Fields(match[1]), " "), match[2]
+		if (keyword == "else" || keyword == "end") && condition != "" {
+			return "", fmt.Errorf("unexpected '%s' in {{ %s }}", condition, keyword)
+		}
+		switch keyword {
+		case "if":
+			frame := &conditionFrame{condition: condition, parentActive: active()}
+			if frame.parentActive {
+				holds, err := e.condition(condition, scope)
+				if err != nil {
+					return "", err
+				}
+				frame.active, frame.taken = holds, holds
+			}
+			frames = append(frames, frame)
+
+		case "else if", "else":
+			if len(frames) == 0 {
+				return "", fmt.Errorf("{{ %s }} without {{ if }}", keyword)
+			}
+			frame := frames[len(frames)-1]
+			if frame.sawElse {
+				return "", fmt.Errorf("{{ %s }} after {{ else }} in {{ if %s }}", keyword, frame.condition)
+			}
+			if keyword == "else" {
+				frame.sawElse = true
+				frame.active = frame.parentActive && !frame.taken
+				frame.taken = true
+				break
+			}
+			frame.active = false
+			if frame.parentActive && !frame.taken {
+				holds, err := e.condition(condition, scope)
+				if err != nil {
+					return "", err
+				}
+				frame.active, frame.taken = holds, holds
+			}
+
+		case "end":
+			if len(frames) == 0 {
+				return "", fmt.Errorf("{{ end }} without {{ if }}")
+			}
+			frames = frames[:len(frames)-1]
+		}
+	}
+
+	if len(frames) > 0 {
+		return "", fmt.Errorf("{{ if %s }} without {{ end }}", frames[len(frames)-1].condition)
+	}
+	return b.String(), nil
+}
+
+// condition evaluates the condition of an {{ if }}
+func (e macroExpander) condition(condition string, scope macroScope) (bool, error) {
+	match := conditionRegex.FindStringSubmatch(condition)
+	if match == nil {
+		return false, fmt.Errorf("invalid condition '%s', must be name, name == value or name != value", condition)
+	}
+
+	name := match[1]
+	value, found, err := e.call(macroCall{name: name}, scope)
+	if err != nil {
+		return false, err
+	}
+	if !found {
+		return false, fmt.Errorf("unknown macro '%s' in condition '%s'", name, condition)
+	}
+
+	switch match[2] {
+	case "==":
+		return value == match[3]+match[4], nil
+	case "!=":
+		return value != match[3]+match[4], nil
+	default:
+		return value != "" && value != "false" && value != "0", nil
+	}
+}
+
+// macroCall is a ${name} or ${name(arg=value, ...)} in a string
+type macroCall struct {
+	name string
+	args []macroArg
+}
+
+type macroArg struct {
+	name  string
+	value string
+}
+
+// parseMacroCall reads the macro call at the start of s, which starts with
+// ${. n is the length of the call, 0 when s does not start with one.
+func parseMacroCall(s string) (call macroCall, n int, err error) {
+	i := 2
+	for i < len(s) && isMacroNameByte(s[i]) {
+		i++
+	}
+	call.name = s[2:i]
+	if call.name == "" || i == len(s) {
+		return call, 0, nil
+	}
+	switch s[i] {
+	case '}':
+		return call, i + 1, nil
+	case '(':
+	default:
+		return call, 0, nil
+	}
+
+	fail := func(format string, args ...any) (macroCall, int, error) {
+		return call, 0, fmt.Errorf("invalid call of macro '%s': %s", call.name, fmt.Sprintf(format, args...))
+	}
+	skipSpaces := func() {
+		for i < len(s) && (s[i] == ' ' || s[i] == '\t' || s[i] == '\n') {
+			i++
+		}
+	}
+
+	i++ // (
+	for {
+		skipSpaces()
+		if i < len(s) && s[i] == ')' && len(call.args) == 0 {
+			break
+		}
+
+		start := i
+		for i < len(s) && isMacroNameByte(s[i]) {
+			i++
+		}
+		arg := macroArg{name: s[start:i]}
+		if arg.name == "" {
+			return fail("expected an argument name at '%s'", excerpt(s[start:]))
+		}
+		skipSpaces()
+		if i == len(s) || s[i] != '=' {
+			return fail("expected '=' after argument '%s'", arg.name)
+		}
+		i++
+		skipSpaces()
+
+		if i < len(s) && s[i] == '"' {
+			// quoted, may hold commas and parentheses
+			var value strings.Builder
+			i++
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				value.WriteByte(s[i])
+			}
+			if i == len(s) {
+				return fail("argument '%s' is missing its closing quote", arg.name)
+			}
+			i++
+			arg.value = value.String()
+		} else {
+			// up to the next , or ) that is not inside a ${...} or (...)
+			start, depth := i, 0
+			for ; i < len(s); i++ {
+				c := s[i]
+				if depth == 0 && (c == ',' || c == ')') {
+					break
+				}
+				switch {
+				case c == '(' || (c == '$' && i+1 < len(s) && s[i+1] == '{'):
+					depth++
+					if c == '$' {
+						i++
+					}
+				case c == ')' || c == '}':
+					depth--
+				}
+			}
+			arg.value = strings.TrimSpace(s[start:i])
+		}
+		for _, other := range call.args {
+			if other.name == arg.name {
+				return fail("argument '%s' is given twice", arg.name)
+			}
+		}
+		call.args = append(call.args, arg)
+
+		skipSpaces()
+		if i == len(s) {
+			return fail("missing ')'")
+		}
+		if s[i] == ')' {
+			break
+		}
+		if s[i] != ',' {
+			return fail("expected ',' or ')' at '%s'", excerpt(s[i:]))
+		}
+		i++
+	}
+
+	i++ // )
+	if i == len(s) || s[i] != '}' {
+		return fail("expected '}' after ')'")
+	}
+	return call, i + 1, nil
+}
+
+// referencedNames returns the names a macro value uses in ${name} and in
+// conditions, the arguments it can be called with
+func referencedNames(value string) map[string]bool {
+	names := make(map[string]bool)
+	for _, match := range macroPatternRegex.FindAllStringSubmatch(value, -1) {
+		names[match[1]] = true
+	}
+	for i := strings.Index(value, "{{"); i >= 0; i = strings.Index(value, "{{") {
+		value = value[i:]
+		if match := directiveRegex.FindStringSubmatch(value); match != nil {
+			if condition := conditionRegex.FindStringSubmatch(match[2]); condition != nil {
+				names[condition[1]] = true
+			}
+		}
+		value = value[2:]
+	}
+	return names
+}
+
+func isMacroNameByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+// excerpt shortens s for error messages
+func excerpt(s string) string {
+	if len(s) > 20 {
+		return s[:20] + "..."
+	}
+	return s
+}
@@ -0,0 +1,131 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMacroExpander(t *testing.T) {
+	expander := newMacroExpander(MacroList{
+		{Name: "ctx", Value: 4096},
+		{Name: "gpu", Value: "rocm"},
+		{Name: "debug", Value: false},
+		{Name: "llama", Value: "llama-server --port ${PORT} -c ${ctx}{{ if gpu == \"rocm\" }} --rocm{{ end }}${extra}"},
+		{Name: "extra", Value: ""},
+		{Name: "model", Value: "${llama(ctx=${size}, extra=\" -m ${file}\")}"},
+		{Name: "size", Value: 8192},
+		{Name: "file", Value: "a, b.gguf"},
+		{Name: "forward", Value: "${later}"},
+		{Name: "later", Value: "done"},
+	})
+
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"plain", "${gpu}", "rocm"},
+		{"unknown is kept", "${PORT} ${env.HOME} $${x", "${PORT} ${env.HOME} $${x"},
+		{"defaults", "${llama}", "llama-server --port ${PORT} -c 4096 --rocm"},
+		{"arguments", "${llama(ctx=32768)}", "llama-server --port ${PORT} -c 32768 --rocm"},
+		{"arguments with spaces", "${llama( ctx = 1 , gpu = cuda )}", "llama-server --port ${PORT} -c 1"},
+		{"nested calls", "${model}", "llama-server --port ${PORT} -c 8192 --rocm -m a, b.gguf"},
+		{"defined later", "${forward}", "done"},
+		{"else", `{{ if gpu == "cuda" }}cuda{{ else if gpu != "rocm" }}other{{ else }}rocm{{ end }}`, "rocm"},
+		{"else if", `{{ if debug }}debug{{ else if ctx }}ctx{{ else }}none{{ end }}`, "ctx"},
+		{"nested conditions", `{{ if ctx }}a{{ if debug }}b{{ else }}c{{ end }}d{{ end }}`, "acd"},
+		{"not a directive", "--chat-template '{{ bos_token }}'", "--chat-template '{{ bos_token }}'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := expander.expand(tt.input)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, result)
+		})
+	}
+
+	errors := []struct {
+		input    string
+		expected string
+	}{
+		{"${llama(size=1)}", "macro 'llama' has no argument 'size'"},
+		{"${llama(ctx=1, ctx=2)}", "invalid call of macro 'llama': argument 'ctx' is given twice"},
+		{"${llama(ctx 1)}", "invalid call of macro 'llama': expected '=' after argument 'ctx'"},
+		{"${llama(ctx=1", "invalid call of macro 'llama': missing ')'"},
+		{"${llama(ctx=1) }", "invalid call of macro 'llama': expected '}' after ')'"},
+		{`${llama(ctx="1)}`, "invalid call of macro 'llama': argument 'ctx' is missing its closing quote"},
+		{"${nope(a=1)}", "unknown macro 'nope' called with arguments"},
+		{"${ctx(a=1)}", "macro 'ctx' takes no arguments"},
+		{"{{ if gpu }}x", "{{ if gpu }} without {{ end }}"},
+		{"x{{ end }}", "{{ end }} without {{ if }}"},
+		{"{{ if gpu }}a{{ else }}b{{ else }}c{{ end }}", "{{ else }} after {{ else }} in {{ if gpu }}"},
+		{"{{ if gpu = rocm }}x{{ end }}", "invalid condition 'gpu = rocm'"},
+		{"{{ if vendor }}x{{ end }}", "unknown macro 'vendor' in condition 'vendor'"},
+		{"{{ if gpu }}x{{ end gpu }}", "unexpected 'gpu' in {{ end }}"},
+	}
+	for _, tt := range errors {
+		_, err := expander.expand(tt.input)
+		assert.ErrorContains(t, err, tt.expected, tt.input)
+	}
+}
+
+func TestMacroExpander_Cycles(t *testing.T) {
+	expander := newMacroExpander(MacroList{
+		{Name: "a", Value: "x ${b}"},
+		{Name: "b", Value: "{{ if c }}${c}{{ end }}"},
+		{Name: "c", Value: "${a}"},
+		{Name: "f", Value: "${f2(n=1)}"},
+		{Name: "f2", Value: "${n} ${f}"},
+	})
+
+	_, err := expander.expand("${a}")
+	assert.EqualError(t, err, "macro cycle: a -> b -> c -> a")
+	_, err = expander.expand("${f}")
+	assert.EqualError(t, err, "macro cycle: f -> f2 -> f")
+
+	// typed values do not loop either
+	_, err = expander.expandValue(map[string]any{"v": "${c}"})
+	assert.ErrorContains(t, err, "macro cycle")
+}
+
+func TestConfig_MacroFunctions(t *testing.T) {
+	content := `
+startPort: 10000
+macros:
+  gpu: rocm
+  ctx: 4096
+  llama: >-
+    llama-server --port ${PORT} -m /models/${MODEL_ID}.gguf -c ${ctx}
+    {{ if gpu == "rocm" }}-ngl 99{{ else }}-ngl 0{{ end }}
+
+models:
+  small:
+    cmd: ${llama}
+  large:
+    cmd: ${llama(ctx=32768)}
+    metadata:
+      ctx: ${ctx}
+  cpu:
+    macros:
+      gpu: none
+    cmd: ${llama}
+`
+	config, err := LoadConfigFromReader(strings.NewReader(content))
+	assert.NoError(t, err)
+	assert.Equal(t, "llama-server --port 10002 -m /models/small.gguf -c 4096 -ngl 99", config.Models["small"].Cmd)
+	assert.Equal(t, "llama-server --port 10001 -m /models/large.gguf -c 32768 -ngl 99", config.Models["large"].Cmd)
+	assert.Equal(t, 4096, config.Models["large"].Metadata["ctx"])
+	assert.Equal(t, "llama-server --port 10000 -m /models/cpu.gguf -c 4096 -ngl 0", config.Models["cpu"].Cmd)
+
+	_, err = LoadConfigFromReader(strings.NewReader(`
+macros:
+  a: ${b}
+  b: ${a}
+models:
+  m:
+    cmd: server ${a}
+`))
+	assert.ErrorContains(t, err, "model m cmd: macro cycle: a -> b -> a")
+}
@@ -0,0 +1,25 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MDNSConfig advertises llmsnap on the local network with mDNS so desktop
+// clients can find it without entering a URL
+type MDNSConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// Name is the instance name shown to clients, empty is "llmsnap on <hostname>"
+	Name string `yaml:"name"`
+}
+
+func (m MDNSConfig) Validate() error {
+	if len(m.Name) > 63 {
+		return fmt.Errorf("mdns.name must be at most 63 bytes, got %d", len(m.Name))
+	}
+	if strings.Contains(m.Name, ".") {
+		return fmt.Errorf("mdns.name must not contain a '.'")
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// memoryUnits maps size suffixes to MiB. Decimal and binary suffixes are
+// treated the same as GPU tools report memory in MiB anyway.
+var memoryUnits = []struct {
+	suffix string
+	mib    float64
+}{
+	{"tib", 1024 * 1024},
+	{"tb", 1024 * 1024},
+	{"t", 1024 * 1024},
+	{"gib", 1024},
+	{"gb", 1024},
+	{"g", 1024},
+	{"mib", 1},
+	{"mb", 1},
+	{"m", 1},
+}
+
+// ParseMemoryMiB parses a memory size like "24GiB", "512MiB" or "8G" and
+// returns it in MiB. A plain number is taken as MiB. An empty string is 0.
+func ParseMemoryMiB(value string) (int, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "" {
+		return 0, nil
+	}
+
+	multiplier := 1.0
+	for _, unit := range memoryUnits {
+		if number, found := strings.CutSuffix(value, unit.suffix); found {
+			value = strings.TrimSpace(number)
+			multiplier = unit.mib
+			break
+		}
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("invalid memory size %q", value)
+	}
+	return int(number * multiplier), nil
+}
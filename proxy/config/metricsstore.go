@@ -0,0 +1,39 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// MetricsStoreConfig persists the activity metrics of every request to an
+// append-only JSON lines file, so the Activity history survives restarts
+type MetricsStoreConfig struct {
+	// File the metrics are appended to, empty disables the store
+	File string `yaml:"file"`
+
+	// MaxAge in hours metrics are kept for, 0 keeps them forever
+	MaxAge int `yaml:"maxAge"`
+
+	// MaxRecords is the number of most recent metrics kept, 0 keeps all
+	MaxRecords int `yaml:"maxRecords"`
+}
+
+// Enabled reports if metrics are persisted
+func (m MetricsStoreConfig) Enabled() bool {
+	return m.File != ""
+}
+
+// MaxAgeDuration returns how long metrics are kept, 0 is forever
+func (m MetricsStoreConfig) MaxAgeDuration() time.Duration {
+	return time.Duration(m.MaxAge) * time.Hour
+}
+
+func (m MetricsStoreConfig) Validate() error {
+	if m.MaxAge < 0 {
+		return fmt.Errorf("metricsStore.maxAge must be greater than or equal to 0")
+	}
+	if m.MaxRecords < 0 {
+		return fmt.Errorf("metricsStore.maxRecords must be greater than or equal to 0")
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package config
+
+import "fmt"
+
+// MiddlewareHook is the point in a request where a middleware runs
+type MiddlewareHook string
+
+const (
+	// MiddlewarePreRoute runs before the model is looked up
+	MiddlewarePreRoute MiddlewareHook = "preRoute"
+
+	// MiddlewarePreUpstream runs right before the request is sent upstream
+	MiddlewarePreUpstream MiddlewareHook = "preUpstream"
+
+	// MiddlewarePostResponse runs after the response was sent to the client
+	MiddlewarePostResponse MiddlewareHook = "postResponse"
+)
+
+// MiddlewareConfig declares an external executable that is run for each
+// inference request at a hook point. It reads the request as JSON on stdin
+// and may answer on stdout to change or reject it.
+type MiddlewareConfig struct {
+	Name string         `yaml:"name"`
+	Hook MiddlewareHook `yaml:"hook"`
+	Cmd  string         `yaml:"cmd"`
+
+	// Timeout is the maximum seconds the command may run, 0 uses the default
+	Timeout int `yaml:"timeout"`
+
+	// Models limits the middleware to these model IDs or aliases, empty
+	// runs it for all models
+	Models []string `yaml:"models"`
+}
+
+// SanitizedCommand splits Cmd into the executable and its arguments
+func (m MiddlewareConfig) SanitizedCommand() ([]string, error) {
+	return SanitizeCommand(m.Cmd)
+}
+
+// Validate checks a single middleware declaration
+func (m MiddlewareConfig) Validate() error {
+	if m.Name == "" {
+		return fmt.Errorf("middleware name is required")
+	}
+	switch m.Hook {
+	case MiddlewarePreRoute, MiddlewarePreUpstream, MiddlewarePostResponse:
+	default:
+		return fmt.Errorf("middleware %s: hook must be one of: preRoute, preUpstream, postResponse", m.Name)
+	}
+	if _, err := m.SanitizedCommand(); err != nil {
+		return fmt.Errorf("middleware %s: %v", m.Name, err)
+	}
+	if m.Timeout < 0 {
+		return fmt.Errorf("middleware %s: timeout must be greater than or equal to 0", m.Name)
+	}
+	return nil
+}
@@ -23,17 +23,60 @@ const (
 	SleepModeDisable SleepMode = SleepMode("disable")
 )
 
+// SSEFlushMode controls how proxied event streams are flushed to the client
+type SSEFlushMode string
+
+const (
+	SSEFlushImmediate SSEFlushMode = SSEFlushMode("immediate")
+	SSEFlushEvent     SSEFlushMode = SSEFlushMode("event")
+	SSEFlushBuffered  SSEFlushMode = SSEFlushMode("buffered")
+)
+
+// ReasoningMode controls how a model's reasoning is returned to clients
+type ReasoningMode string
+
+const (
+	// ReasoningStrip removes <think> blocks and reasoning fields
+	ReasoningStrip ReasoningMode = ReasoningMode("strip")
+
+	// ReasoningContent moves reasoning into the reasoning_content field
+	ReasoningContent ReasoningMode = ReasoningMode("reasoning_content")
+
+	// ReasoningField moves reasoning into the reasoning field
+	ReasoningField ReasoningMode = ReasoningMode("reasoning")
+)
+
 type ModelConfig struct {
 	Cmd           string   `yaml:"cmd"`
 	CmdStop       string   `yaml:"cmdStop"`
 	Proxy         string   `yaml:"proxy"`
-	Aliases       []string `yaml:"aliases"`
+	Aliases       []string `yaml:"-"`
 	Env           []string `yaml:"env"`
 	CheckEndpoint string   `yaml:"checkEndpoint"`
 	UnloadAfter   int      `yaml:"ttl"`
 	Unlisted      bool     `yaml:"unlisted"`
 	UseModelName  string   `yaml:"useModelName"`
 
+	// CheckCmd is run instead of requesting CheckEndpoint to check if the
+	// server is ready, it is ready once the command exits with 0
+	CheckCmd string `yaml:"checkCmd"`
+
+	// ExpectBody must be in the body of the CheckEndpoint response for the
+	// server to be ready, for servers that respond 200 while loading
+	ExpectBody string `yaml:"expectBody"`
+
+	// ExpectJSON maps gjson paths to the values they must have in the
+	// CheckEndpoint response for the server to be ready
+	ExpectJSON map[string]string `yaml:"expectJSON"`
+
+	// HealthCheck customizes the checks of the server and checks it in the
+	// background once it is ready, see HealthCheckConfig
+	HealthCheck HealthCheckConfig `yaml:"healthCheck"`
+
+	// AliasPresets holds what alias entries written as mappings set for
+	// requests to them, by alias
+	AliasPresets map[string]AliasPreset `yaml:"-"`
+
 	// SleepMode explicitly controls sleep/wake behavior
 	// Valid values: SleepModeEnable, SleepModeDisable
 	// Future values may include: "auto", "level1", "level2"
@@ -43,6 +86,10 @@ type ModelConfig struct {
 	SleepEndpoints []HTTPEndpoint `yaml:"sleepEndpoints"`
 	WakeEndpoints  []HTTPEndpoint `yaml:"wakeEndpoints"`
 
+	// SleepAfter puts a ready model to sleep after this many seconds without
+	// requests, ttl still stops it later. 0 never sleeps on its own.
+	SleepAfter int `yaml:"sleepAfter"`
+
 	// #179 for /v1/models
 	Name        string `yaml:"name"`
 	Description string `yaml:"description"`
@@ -50,6 +97,14 @@ type ModelConfig struct {
 	// Limit concurrency of HTTP requests to process
 	ConcurrencyLimit int `yaml:"concurrencyLimit"`
 
+	// StreamingConcurrencyLimit gives streamed requests their own slots, 0
+	// has them share ConcurrencyLimit with the other requests
+	StreamingConcurrencyLimit int `yaml:"streamingConcurrencyLimit"`
+
+	// ConcurrencyOverflow is what happens to a request when all slots are
+	// taken
+	ConcurrencyOverflow ConcurrencyOverflowMode `yaml:"concurrencyOverflow"`
+
 	// Model filters see issue #174
 	Filters ModelFilters `yaml:"filters"`
 
@@ -63,6 +118,188 @@ type ModelConfig struct {
 
 	// override global setting
 	SendLoadingState *bool `yaml:"sendLoadingState"`
+
+	// ResponseCache overrides responseCache for the model
+	ResponseCache ModelResponseCacheConfig `yaml:"responseCache"`
+
+	// SSEFlush controls flushing of text/event-stream responses, empty is immediate
+	SSEFlush SSEFlushMode `yaml:"sseFlush"`
+
+	// SSEFlushInterval is the time in milliseconds between flushes when SSEFlush is buffered
+	SSEFlushInterval int `yaml:"sseFlushInterval"`
+
+	// Script inspects and changes JSON requests and responses with Lua, see
+	// ScriptConfig
+	Script ScriptConfig `yaml:"script"`
+
+	// WasmFilters change JSON requests and responses with sandboxed WASM
+	// modules, requests pass them in order and responses in reverse order,
+	// see WasmFilterConfig
+	WasmFilters []WasmFilterConfig `yaml:"wasmFilters"`
+
+	// VRAMEstimate is how much GPU memory the model needs, e.g. "24GiB"
+	VRAMEstimate string `yaml:"vramEstimate"`
+
+	// Weight is how much of its swap group's weightBudget the model takes
+	// while it is loaded, 0 takes all of it
+	Weight int `yaml:"weight"`
+
+	// Priority of requests to this model when the scheduler is queueing
+	Priority int `yaml:"priority"`
+
+	// Reasoning normalizes <think> blocks and reasoning fields in responses,
+	// empty returns them unchanged
+	Reasoning ReasoningMode `yaml:"reasoning"`
+
+	// RepairToolCalls fixes malformed JSON in tool call arguments
+	RepairToolCalls bool `yaml:"repairToolCalls"`
+
+	// ChatTemplateSuffixes maps model name suffixes to chat_template_kwargs,
+	// requesting "model:suffix" injects them into the request
+	ChatTemplateSuffixes map[string]map[string]any `yaml:"chatTemplateSuffixes"`
+
+	// CaptureDataset appends prompt and response pairs to a JSONL file in
+	// the global datasets.dir
+	CaptureDataset bool `yaml:"captureDataset"`
+
+	// DiscoverModels routes the model IDs listed by the backend's /v1/models
+	// to this model, for backends that serve several models in one process
+	DiscoverModels bool `yaml:"discoverModels"`
+
+	// Placement decides which GPUs the model is started on, see GPUPlacement
+	Placement GPUPlacement `yaml:"placement"`
+
+	// Probe measures availability with synthetic requests, see ProbeConfig
+	Probe ProbeConfig `yaml:"probe"`
+
+	// Webhook receives a summary of every completed request, see WebhookConfig
+	Webhook WebhookConfig `yaml:"webhook"`
+
+	// UpstreamProxy reaches a remote upstream through a proxy, see
+	// UpstreamProxyConfig
+	UpstreamProxy UpstreamProxyConfig `yaml:"upstreamProxy"`
+
+	// Requires lists models, by ID or alias, that are loaded or woken with
+	// this model and kept with it when its group swaps
+	Requires []string `yaml:"requires"`
+
+	// Fallback lists models, by ID or alias, that get a request in order
+	// when this model fails to start or replies with a 5xx. They are
+	// resolved to IDs when the config is loaded.
+	Fallback []string `yaml:"fallback"`
+
+	// Pricing computes a cost for every request, see PricingConfig
+	Pricing PricingConfig `yaml:"pricing"`
+
+	// Queue limits the requests waiting for this model, see QueueConfig
+	Queue QueueConfig `yaml:"queue"`
+
+	// TranslateMessages converts Anthropic /v1/messages requests into chat
+	// completions, and the responses back, for upstreams that only speak
+	// the OpenAI API
+	TranslateMessages bool `yaml:"translateMessages"`
+
+	// RequestRewrites change JSON request bodies after the filters, see
+	// Rewrite
+	RequestRewrites []Rewrite `yaml:"requestRewrites"`
+
+	// ResponseRewrites change JSON responses and the chat completion events
+	// of streams as they come from the upstream
+	ResponseRewrites []Rewrite `yaml:"responseRewrites"`
+
+	// EnsureUsage asks for the usage in streamed completions, for backends
+	// like vLLM that only report it when asked
+	EnsureUsage bool `yaml:"ensureUsage"`
+
+	// Schedule loads, sleeps and unloads the model at set times, see
+	// CronSchedule
+	Schedule CronSchedule `yaml:"schedule"`
+
+	// Instances runs this many copies of the model, each with its own
+	// ${PORT} and ${INSTANCE}, and balances requests over the ready ones
+	Instances int `yaml:"instances"`
+
+	// LoadBalance picks the instance a request is sent to
+	LoadBalance LoadBalanceMode `yaml:"loadBalance"`
+
+	// Draft runs a draft model for speculative decoding with this model, see
+	// DraftConfig
+	Draft DraftConfig `yaml:"draft"`
+
+	// CrashLoop backs off restarts of a model that keeps failing, see
+	// CrashLoopConfig
+	CrashLoop CrashLoopConfig `yaml:"crashLoop"`
+
+	// Batch coalesces concurrent embeddings requests, see BatchConfig
+	Batch BatchConfig `yaml:"batch"`
+
+	// configs of the instances after the first, set when the config is loaded
+	replicas []ModelConfig
+}
+
+// LoadBalanceMode is how requests are spread over the instances of a model
+type LoadBalanceMode string
+
+const (
+	// LoadBalanceLeastConnections sends a request to the instance with the
+	// fewest inflight requests, the default
+	LoadBalanceLeastConnections LoadBalanceMode = "leastConnections"
+
+	// LoadBalanceRoundRobin sends requests to the instances in turn
+	LoadBalanceRoundRobin LoadBalanceMode = "roundRobin"
+)
+
+// ConcurrencyOverflowMode is what happens to a request over a model's
+// concurrency limit
+type ConcurrencyOverflowMode string
+
+const (
+	// ConcurrencyOverflowReject answers with a 429, the default
+	ConcurrencyOverflowReject ConcurrencyOverflowMode = "reject"
+
+	// ConcurrencyOverflowQueue waits for a slot, up to the queue timeout
+	ConcurrencyOverflowQueue ConcurrencyOverflowMode = "queue"
+)
+
+// AliasPreset is applied to requests for an alias
+type AliasPreset struct {
+	// UseModelName is sent upstream instead of the model's useModelName
+	UseModelName string `yaml:"useModelName"`
+
+	// Params are set in requests that do not have them, e.g. temperature
+	Params map[string]any `yaml:"params"`
+
+	// SystemPrompt is added to chat requests that have no system message
+	SystemPrompt string `yaml:"systemPrompt"`
+}
+
+func (p AliasPreset) isZero() bool {
+	return p.UseModelName == "" && len(p.Params) == 0 && p.SystemPrompt == ""
+}
+
+// aliasEntry is an item of a model's aliases, either a name or a mapping
+// with the name and a preset
+type aliasEntry struct {
+	Name        string `yaml:"name"`
+	AliasPreset `yaml:",inline"`
+}
+
+func (a *aliasEntry) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&a.Name); err == nil {
+		return nil
+	}
+	type rawAliasEntry aliasEntry
+	return unmarshal((*rawAliasEntry)(a))
+}
+
+// UpstreamModelName returns the model name sent upstream for a request to
+// requested, the model ID or one of its aliases. Empty keeps the requested
+// name.
+func (m ModelConfig) UpstreamModelName(requested string) string {
+	if name := m.AliasPresets[requested].UseModelName; name != "" {
+		return name
+	}
+	return m.UseModelName
 }
 
 func (m *ModelConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
@@ -88,11 +325,30 @@ func (m *ModelConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
 		defaults.CmdStop = "taskkill /f /t /pid ${PID}"
 	}
 
-	if err := unmarshal(&defaults); err != nil {
+	// aliases are decoded separately as entries may be mappings
+	raw := struct {
+		rawModelConfig `yaml:",inline"`
+		Aliases        []aliasEntry `yaml:"aliases"`
+	}{rawModelConfig: defaults}
+
+	if err := unmarshal(&raw); err != nil {
 		return err
 	}
 
-	*m = ModelConfig(defaults)
+	*m = ModelConfig(raw.rawModelConfig)
+
+	for _, alias := range raw.Aliases {
+		if alias.Name == "" {
+			return errors.New("aliases: name is required")
+		}
+		m.Aliases = append(m.Aliases, alias.Name)
+		if !alias.AliasPreset.isZero() {
+			if m.AliasPresets == nil {
+				m.AliasPresets = make(map[string]AliasPreset)
+			}
+			m.AliasPresets[alias.Name] = alias.AliasPreset
+		}
+	}
 
 	// Validate sleepMode field
 	switch m.SleepMode {
@@ -102,6 +358,133 @@ func (m *ModelConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
 		return fmt.Errorf("invalid sleepMode value '%s': must be 'enable' or 'disable'", m.SleepMode)
 	}
 
+	switch m.SSEFlush {
+	case "", SSEFlushImmediate, SSEFlushEvent, SSEFlushBuffered:
+		// Valid values
+	default:
+		return fmt.Errorf("invalid sseFlush value '%s': must be 'immediate', 'event' or 'buffered'", m.SSEFlush)
+	}
+
+	switch m.Reasoning {
+	case "", ReasoningStrip, ReasoningContent, ReasoningField:
+		// Valid values
+	default:
+		return fmt.Errorf("invalid reasoning value '%s': must be 'strip', 'reasoning_content' or 'reasoning'", m.Reasoning)
+	}
+
+	if m.SSEFlushInterval < 0 {
+		return fmt.Errorf("sseFlushInterval must be non-negative, got %d", m.SSEFlushInterval)
+	}
+
+	if err := m.Script.validate(); err != nil {
+		return fmt.Errorf("script: %v", err)
+	}
+
+	for i, filter := range m.WasmFilters {
+		if err := filter.validate(); err != nil {
+			return fmt.Errorf("wasmFilters.%d: %v", i, err)
+		}
+	}
+
+	if _, err := ParseMemoryMiB(m.VRAMEstimate); err != nil {
+		return fmt.Errorf("vramEstimate: %v", err)
+	}
+
+	if err := m.HealthCheck.validate(); err != nil {
+		return fmt.Errorf("healthCheck: %v", err)
+	}
+	if m.HealthCheck.Endpoint != "" {
+		m.CheckEndpoint = m.HealthCheck.Endpoint
+	}
+	if m.HealthCheck.ExpectBodyContains != "" {
+		if m.ExpectBody != "" && m.ExpectBody != m.HealthCheck.ExpectBodyContains {
+			return errors.New("expectBody and healthCheck.expectBodyContains can not both be set")
+		}
+		m.ExpectBody = m.HealthCheck.ExpectBodyContains
+	}
+
+	if (m.ExpectBody != "" || len(m.ExpectJSON) > 0) && (strings.TrimSpace(m.CheckEndpoint) == "none" || m.CheckCmd != "") {
+		return errors.New("expectBody and expectJSON require a checkEndpoint and no checkCmd")
+	}
+
+	if err := m.Placement.validate(); err != nil {
+		return fmt.Errorf("placement: %v", err)
+	}
+
+	if err := m.Probe.validate(); err != nil {
+		return fmt.Errorf("probe: %v", err)
+	}
+
+	if err := m.Webhook.validate(); err != nil {
+		return fmt.Errorf("webhook: %v", err)
+	}
+
+	if err := m.UpstreamProxy.validate(); err != nil {
+		return fmt.Errorf("upstreamProxy: %v", err)
+	}
+
+	if err := m.Pricing.validate(); err != nil {
+		return fmt.Errorf("pricing: %v", err)
+	}
+
+	if err := m.Queue.validate(); err != nil {
+		return fmt.Errorf("queue: %v", err)
+	}
+
+	if err := validateRewrites(m.RequestRewrites, ProtectedParams); err != nil {
+		return fmt.Errorf("requestRewrites.%v", err)
+	}
+	if err := validateRewrites(m.ResponseRewrites, nil); err != nil {
+		return fmt.Errorf("responseRewrites.%v", err)
+	}
+
+	if err := m.Schedule.validate(); err != nil {
+		return fmt.Errorf("schedule: %v", err)
+	}
+
+	if m.Weight < 0 {
+		return fmt.Errorf("weight must be non-negative, got %d", m.Weight)
+	}
+
+	if m.Instances < 0 {
+		return fmt.Errorf("instances must be non-negative, got %d", m.Instances)
+	}
+	switch m.LoadBalance {
+	case "", LoadBalanceLeastConnections, LoadBalanceRoundRobin:
+		// Valid values
+	default:
+		return fmt.Errorf("invalid loadBalance value '%s': must be 'leastConnections' or 'roundRobin'", m.LoadBalance)
+	}
+
+	if err := m.Draft.validate(); err != nil {
+		return fmt.Errorf("draft: %v", err)
+	}
+	if m.Draft.Enabled() && m.Instances > 1 {
+		return errors.New("draft can not be used with instances")
+	}
+
+	if err := m.CrashLoop.validate(); err != nil {
+		return fmt.Errorf("crashLoop: %v", err)
+	}
+
+	if err := m.Batch.validate(); err != nil {
+		return fmt.Errorf("batch.%v", err)
+	}
+
+	if err := m.ResponseCache.validate(); err != nil {
+		return fmt.Errorf("responseCache.%v", err)
+	}
+
+	if m.StreamingConcurrencyLimit < 0 {
+		return fmt.Errorf("streamingConcurrencyLimit must be non-negative, got %d", m.StreamingConcurrencyLimit)
+	}
+	switch m.ConcurrencyOverflow {
+	case "", ConcurrencyOverflowReject, ConcurrencyOverflowQueue:
+		// Valid values
+	default:
+		return fmt.Errorf("invalid concurrencyOverflow value '%s': must be 'reject' or 'queue'", m.ConcurrencyOverflow)
+	}
+
 	// Require endpoints when sleepMode is "enable"
 	if m.SleepMode == SleepModeEnable {
 		if len(m.SleepEndpoints) == 0 {
@@ -112,6 +495,18 @@ func (m *ModelConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
 		}
 	}
 
+	if m.SleepAfter < 0 {
+		return fmt.Errorf("sleepAfter must be non-negative, got %d", m.SleepAfter)
+	}
+	if m.SleepAfter > 0 {
+		if m.SleepMode != SleepModeEnable {
+			return errors.New("sleepAfter requires sleepMode 'enable'")
+		}
+		if m.UnloadAfter > 0 && m.SleepAfter >= m.UnloadAfter {
+			return fmt.Errorf("sleepAfter (%ds) must be less than ttl (%ds)", m.SleepAfter, m.UnloadAfter)
+		}
+	}
+
 	// Validate and normalize each endpoint
 	for i := range m.SleepEndpoints {
 		if err := m.validateEndpoint(&m.SleepEndpoints[i]); err != nil {
@@ -155,6 +550,18 @@ func (m *ModelConfig) validateEndpoint(ep *HTTPEndpoint) error {
 	return nil
 }
 
+// InstanceConfigs returns the configs of the model's instances, the model's
+// own config first. Only configs read by LoadConfig have more than one.
+func (m ModelConfig) InstanceConfigs() []ModelConfig {
+	return append([]ModelConfig{m}, m.replicas...)
+}
+
+// VRAMEstimateMiB returns the declared GPU memory requirement in MiB, 0 when unknown
+func (m ModelConfig) VRAMEstimateMiB() int {
+	mib, _ := ParseMemoryMiB(m.VRAMEstimate)
+	return mib
+}
+
 func (m *ModelConfig) SanitizedCommand() ([]string, error) {
 	return SanitizeCommand(m.Cmd)
 }
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -104,3 +105,394 @@ models:
 	assert.Equal(t, 0.7, setParams["temperature"])
 	assert.Equal(t, 0.9, setParams["top_p"])
 }
+
+func TestConfig_ModelSSEFlush(t *testing.T) {
+	content := `
+models:
+  model1:
+    cmd: path/to/cmd --port ${PORT}
+    sseFlush: buffered
+    sseFlushInterval: 50
+  model2:
+    cmd: path/to/cmd --port ${PORT}
+`
+	config, err := LoadConfigFromReader(strings.NewReader(content))
+	assert.NoError(t, err)
+	assert.Equal(t, SSEFlushBuffered, config.Models["model1"].SSEFlush)
+	assert.Equal(t, 50, config.Models["model1"].SSEFlushInterval)
+	assert.Equal(t, SSEFlushMode(""), config.Models["model2"].SSEFlush)
+
+	content = `
+models:
+  model1:
+    cmd: path/to/cmd --port ${PORT}
+    sseFlush: sometimes
+`
+	_, err = LoadConfigFromReader(strings.NewReader(content))
+	assert.ErrorContains(t, err, "invalid sseFlush value 'sometimes'")
+}
+
+func TestConfig_ModelReasoning(t *testing.T) {
+	content := `
+models:
+  model1:
+    cmd: path/to/cmd --port ${PORT}
+    reasoning: reasoning_content
+`
+	config, err := LoadConfigFromReader(strings.NewReader(content))
+	assert.NoError(t, err)
+	assert.Equal(t, ReasoningContent, config.Models["model1"].Reasoning)
+
+	_, err = LoadConfigFromReader(strings.NewReader(strings.Replace(content, "reasoning: reasoning_content", "reasoning: hide", 1)))
+	assert.ErrorContains(t, err, "invalid reasoning value 'hide'")
+}
+
+func TestConfig_ModelRepairToolCalls(t *testing.T) {
+	content := `
+models:
+  model1:
+    cmd: path/to/cmd --port ${PORT}
+    repairToolCalls: true
+`
+	config, err := LoadConfigFromReader(strings.NewReader(content))
+	assert.NoError(t, err)
+	assert.True(t, config.Models["model1"].RepairToolCalls)
+}
+
+func TestConfig_ModelAliasUseModelName(t *testing.T) {
+	content := `
+models:
+  model1:
+    cmd: path/to/cmd --port ${PORT}
+    useModelName: org/base
+    aliases:
+      - plain
+      - name: coder
+        useModelName: org/coder-lora
+      - name: no-override
+`
+	config, err := LoadConfigFromReader(strings.NewReader(content))
+	assert.NoError(t, err)
+
+	model := config.Models["model1"]
+	assert.Equal(t, []string{"plain", "coder", "no-override"}, model.Aliases)
+	assert.Equal(t, map[string]AliasPreset{"coder": {UseModelName: "org/coder-lora"}}, model.AliasPresets)
+	assert.Equal(t, "org/base", model.UpstreamModelName("model1"))
+	assert.Equal(t, "org/base", model.UpstreamModelName("plain"))
+	assert.Equal(t, "org/coder-lora", model.UpstreamModelName("coder"))
+
+	modelID, found := config.RealModelName("coder")
+	assert.True(t, found)
+	assert.Equal(t, "model1", modelID)
+
+	_, err = LoadConfigFromReader(strings.NewReader(strings.Replace(content, "name: no-override", "useModelName: x", 1)))
+	assert.ErrorContains(t, err, "aliases: name is required")
+}
+
+func TestConfig_ModelAliasPresets(t *testing.T) {
+	content := `
+models:
+  coder:
+    cmd: path/to/cmd --port ${PORT}
+    aliases:
+      - name: fast-coder
+        params:
+          temperature: 0.2
+          max_tokens: 512
+      - name: careful-coder
+        systemPrompt: Think step by step.
+        params:
+          temperature: 0.8
+`
+	config, err := LoadConfigFromReader(strings.NewReader(content))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]AliasPreset{
+		"fast-coder":    {Params: map[string]any{"temperature": 0.2, "max_tokens": 512}},
+		"careful-coder": {SystemPrompt: "Think step by step.", Params: map[string]any{"temperature": 0.8}},
+	}, config.Models["coder"].AliasPresets)
+}
+
+func TestConfig_ParseMemoryMiB(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int
+	}{
+		{"", 0},
+		{"512", 512},
+		{"512MiB", 512},
+		{"512 MB", 512},
+		{"24GiB", 24576},
+		{"1.5G", 1536},
+		{"1TiB", 1048576},
+	}
+	for _, tt := range tests {
+		mib, err := ParseMemoryMiB(tt.input)
+		assert.NoError(t, err, tt.input)
+		assert.Equal(t, tt.expected, mib, tt.input)
+	}
+
+	for _, input := range []string{"lots", "-1GiB", "GiB"} {
+		_, err := ParseMemoryMiB(input)
+		assert.Error(t, err, input)
+	}
+
+	content := `
+models:
+  model1:
+    cmd: path/to/cmd --port ${PORT}
+    vramEstimate: 10 gigs
+`
+	_, err := LoadConfigFromReader(strings.NewReader(content))
+	assert.ErrorContains(t, err, "vramEstimate: invalid memory size")
+}
+
+func TestConfig_ModelProbe(t *testing.T) {
+	content := `
+models:
+  model1:
+    cmd: path/to/cmd --port ${PORT}
+    probe:
+      interval: 300
+  model2:
+    cmd: path/to/cmd --port ${PORT}
+`
+	config, err := LoadConfigFromReader(strings.NewReader(content))
+	assert.NoError(t, err)
+	probe := config.Models["model1"].Probe
+	assert.True(t, probe.Enabled())
+	assert.Equal(t, 5*time.Minute, probe.Every())
+	assert.Equal(t, "ping", probe.PromptText())
+	assert.Equal(t, 1, probe.ReplyTokens())
+	assert.Equal(t, time.Minute, probe.TimeoutDuration())
+	assert.False(t, config.Models["model2"].Probe.Enabled())
+
+	_, err = LoadConfigFromReader(strings.NewReader(strings.Replace(content, "interval: 300", "timeout: -5", 1)))
+	assert.ErrorContains(t, err, "probe: timeout must be non-negative, got -5")
+}
+
+func TestConfig_ModelWebhook(t *testing.T) {
+	content := `
+models:
+  model1:
+    cmd: path/to/cmd --port ${PORT}
+    webhook:
+      url: https://analytics.example.com/llm
+      bodies: true
+`
+	config, err := LoadConfigFromReader(strings.NewReader(content))
+	assert.NoError(t, err)
+	webhook := config.Models["model1"].Webhook
+	assert.True(t, webhook.Enabled())
+	assert.True(t, webhook.Bodies)
+	assert.Equal(t, 10*time.Second, webhook.TimeoutDuration())
+
+	_, err = LoadConfigFromReader(strings.NewReader(strings.Replace(content, "https://analytics.example.com/llm", "analytics:9000", 1)))
+	assert.ErrorContains(t, err, "webhook: invalid url 'analytics:9000'")
+}
+
+func TestConfig_ModelUpstreamProxy(t *testing.T) {
+	content := `
+models:
+  model1:
+    cmd: path/to/cmd --port ${PORT}
+    proxy: http://gpu-box:8080
+    upstreamProxy:
+      url: socks5h://127.0.0.1:1080
+      noProxy: 10.0.0.0/8
+`
+	config, err := LoadConfigFromReader(strings.NewReader(content))
+	assert.NoError(t, err)
+	assert.True(t, config.Models["model1"].UpstreamProxy.Enabled())
+	assert.Equal(t, "10.0.0.0/8", config.Models["model1"].UpstreamProxy.NoProxy)
+
+	_, err = LoadConfigFromReader(strings.NewReader(strings.Replace(content, "socks5h://", "ftp://", 1)))
+	assert.ErrorContains(t, err, "upstreamProxy: invalid url 'ftp://127.0.0.1:1080', scheme must be http, https, socks5 or socks5h")
+}
+
+func TestConfig_ModelInstances(t *testing.T) {
+	content := `
+models:
+  model1:
+    cmd: server --port ${PORT} --device ${INSTANCE}
+    checkEndpoint: /health/${INSTANCE}
+    env: ["CUDA_VISIBLE_DEVICES=${INSTANCE}"]
+    instances: 3
+    loadBalance: roundRobin
+  model2:
+    cmd: server --port ${PORT} --device ${INSTANCE}
+`
+	config, err := LoadConfigFromReader(strings.NewReader(content))
+	assert.NoError(t, err)
+
+	instances := config.Models["model1"].InstanceConfigs()
+	if assert.Len(t, instances, 3) {
+		for i, instance := range instances {
+			port := 5800 + i
+			assert.Equal(t, fmt.Sprintf("server --port %d --device %d", port, i), instance.Cmd)
+			assert.Equal(t, fmt.Sprintf("http://localhost:%d", port), instance.Proxy)
+			assert.Equal(t, fmt.Sprintf("/health/%d", i), instance.CheckEndpoint)
+			assert.Equal(t, []string{fmt.Sprintf("CUDA_VISIBLE_DEVICES=%d", i)}, instance.Env)
+		}
+	}
+	assert.Equal(t, LoadBalanceRoundRobin, config.Models["model1"].LoadBalance)
+
+	// the ports of the instances are taken before the next model's
+	assert.Len(t, config.Models["model2"].InstanceConfigs(), 1)
+	assert.Equal(t, "server --port 5803 --device 0", config.Models["model2"].Cmd)
+
+	for _, tc := range []struct{ from, to, err string }{
+		{"loadBalance: roundRobin", "loadBalance: random", "invalid loadBalance value 'random'"},
+		{"instances: 3", "instances: -1", "instances must be non-negative"},
+		{"cmd: server --port ${PORT} --device ${INSTANCE}\n    checkEndpoint", "cmd: server --port 8080\n    proxy: http://localhost:8080\n    checkEndpoint", "model model1: instances requires ${PORT} in cmd"},
+	} {
+		_, err := LoadConfigFromReader(strings.NewReader(strings.Replace(content, tc.from, tc.to, 1)))
+		assert.ErrorContains(t, err, tc.err)
+	}
+
+	_, err = LoadConfigFromReader(strings.NewReader("macros:\n  INSTANCE: 1\n" + content))
+	assert.ErrorContains(t, err, "macro name 'INSTANCE' is reserved")
+}
+
+func TestConfig_StreamingConcurrencyLimit(t *testing.T) {
+	content := `
+models:
+  model1:
+    cmd: server --port ${PORT}
+    concurrencyLimit: 8
+    streamingConcurrencyLimit: 2
+    concurrencyOverflow: queue
+  model2:
+    cmd: server --port ${PORT}
+`
+	config, err := LoadConfigFromReader(strings.NewReader(content))
+	assert.NoError(t, err)
+	assert.Equal(t, 8, config.Models["model1"].ConcurrencyLimit)
+	assert.Equal(t, 2, config.Models["model1"].StreamingConcurrencyLimit)
+	assert.Equal(t, ConcurrencyOverflowQueue, config.Models["model1"].ConcurrencyOverflow)
+	assert.Equal(t, 0, config.Models["model2"].StreamingConcurrencyLimit)
+
+	for _, tc := range []struct{ from, to, err string }{
+		{"streamingConcurrencyLimit: 2", "streamingConcurrencyLimit: -1", "streamingConcurrencyLimit must be non-negative"},
+		{"concurrencyOverflow: queue", "concurrencyOverflow: drop", "invalid concurrencyOverflow value 'drop'"},
+	} {
+		_, err := LoadConfigFromReader(strings.NewReader(strings.Replace(content, tc.from, tc.to, 1)))
+		assert.ErrorContains(t, err, tc.err)
+	}
+}
+
+func TestConfig_ModelDraft(t *testing.T) {
+	content := `
+macros:
+  models: /models
+models:
+  model1:
+    cmd: server --port ${PORT} --draft-url ${DRAFT_PROXY}
+    draft:
+      cmd: server --port ${PORT} -m ${models}/small.gguf
+      env: ["CUDA_VISIBLE_DEVICES=1"]
+  model2:
+    cmd: server --port ${PORT}
+`
+	config, err := LoadConfigFromReader(strings.NewReader(content))
+	assert.NoError(t, err)
+
+	model1 := config.Models["model1"]
+	assert.True(t, model1.Draft.Enabled())
+	assert.Equal(t, "server --port 5800 --draft-url http://localhost:5801", model1.Cmd)
+	assert.Equal(t, "server --port 5801 -m /models/small.gguf", model1.Draft.Cmd)
+
+	draft := model1.DraftModel()
+	assert.Equal(t, "http://localhost:5801", draft.Proxy)
+	assert.Equal(t, "/health", draft.CheckEndpoint)
+	assert.Equal(t, []string{"CUDA_VISIBLE_DEVICES=1"}, draft.Env)
+
+	// the draft's port is taken before the next model's
+	assert.False(t, config.Models["model2"].Draft.Enabled())
+	assert.Equal(t, "server --port 5802", config.Models["model2"].Cmd)
+
+	for _, tc := range []struct{ from, to, err string }{
+		{"      cmd: server --port ${PORT} -m ${models}/small.gguf\n", "", "draft: cmd is required"},
+		{"model1:\n    cmd: server --port ${PORT}", "model1:\n    instances: 2\n    cmd: server --port ${PORT}", "draft can not be used with instances"},
+		{"-m ${models}", "-m ${unknown}", "unknown macro '${unknown}' found in model1.draft.cmd"},
+		{"      env:", "      proxy: http://localhost:${PORT}\n      env:", ""},
+		{"      cmd: server --port ${PORT} -m", "      proxy: http://localhost:${PORT}\n      cmd: server -m", "draft.proxy uses ${PORT} but draft.cmd does not"},
+	} {
+		_, err := LoadConfigFromReader(strings.NewReader(strings.Replace(content, tc.from, tc.to, 1)))
+		if tc.err == "" {
+			assert.NoError(t, err)
+		} else {
+			assert.ErrorContains(t, err, tc.err)
+		}
+	}
+
+	_, err = LoadConfigFromReader(strings.NewReader(strings.Replace(content, "    draft:\n      cmd: server --port ${PORT} -m ${models}/small.gguf\n      env: [\"CUDA_VISIBLE_DEVICES=1\"]\n", "", 1)))
+	assert.ErrorContains(t, err, "unknown macro '${DRAFT_PROXY}' found in model1.cmd")
+}
+
+func TestConfig_ModelCrashLoop(t *testing.T) {
+	content := `
+models:
+  model1:
+    cmd: server --port ${PORT}
+    crashLoop:
+      maxRestarts: 3
+      backoff: 2
+      maxBackoff: 10
+`
+	config, err := LoadConfigFromReader(strings.NewReader(content))
+	assert.NoError(t, err)
+
+	crashLoop := config.Models["model1"].CrashLoop
+	assert.True(t, crashLoop.Enabled())
+	assert.Equal(t, 5*time.Minute, crashLoop.WindowDuration())
+	assert.Equal(t, 2*time.Second, crashLoop.BackoffDuration(1))
+	assert.Equal(t, 8*time.Second, crashLoop.BackoffDuration(3))
+	assert.Equal(t, 10*time.Second, crashLoop.BackoffDuration(4))
+
+	_, err = LoadConfigFromReader(strings.NewReader(strings.Replace(content, "maxRestarts: 3", "maxRestarts: -1", 1)))
+	assert.ErrorContains(t, err, "crashLoop: maxRestarts must be greater than or equal to 0")
+}
+
+func TestConfig_ModelHealthCheck(t *testing.T) {
+	content := `
+models:
+  model1:
+    cmd: server --port ${PORT}
+    healthCheck:
+      endpoint: /v1/models
+      method: post
+      expectStatus: 204
+      expectBodyContains: ready
+      interval: 10
+  model2:
+    cmd: server --port ${PORT}
+`
+	config, err := LoadConfigFromReader(strings.NewReader(content))
+	assert.NoError(t, err)
+
+	model1 := config.Models["model1"]
+	assert.Equal(t, "/v1/models", model1.CheckEndpoint)
+	assert.Equal(t, "ready", model1.ExpectBody)
+	assert.Equal(t, "POST", model1.HealthCheck.RequestMethod())
+	assert.Equal(t, 204, model1.HealthCheck.HealthyStatus())
+	assert.Equal(t, 10*time.Second, model1.HealthCheck.IntervalDuration())
+	assert.Equal(t, 3, model1.HealthCheck.Threshold())
+
+	model2 := config.Models["model2"]
+	assert.Equal(t, "/health", model2.CheckEndpoint)
+	assert.Equal(t, "GET", model2.HealthCheck.RequestMethod())
+	assert.Equal(t, 200, model2.HealthCheck.HealthyStatus())
+	assert.Equal(t, time.Duration(0), model2.HealthCheck.IntervalDuration())
+
+	for _, tc := range []struct{ from, to, err string }{
+		{"method: post", "method: delete", `healthCheck: invalid method "DELETE"`},
+		{"expectStatus: 204", "expectStatus: 42", "healthCheck: expectStatus must be a HTTP status code"},
+		{"interval: 10", "interval: -1", "healthCheck: interval must be non-negative"},
+		{"interval: 10", "interval: 10\n      failureThreshold: -2", "healthCheck: failureThreshold must be non-negative"},
+		{"    healthCheck:", "    expectBody: loaded\n    healthCheck:", "expectBody and healthCheck.expectBodyContains can not both be set"},
+		{"    healthCheck:", "    checkCmd: is-ready\n    healthCheck:", "expectBody and expectJSON require a checkEndpoint and no checkCmd"},
+	} {
+		_, err := LoadConfigFromReader(strings.NewReader(strings.Replace(content, tc.from, tc.to, 1)))
+		assert.ErrorContains(t, err, tc.err)
+	}
+}
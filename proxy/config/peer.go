@@ -3,6 +3,7 @@ package config
 import (
 	"fmt"
 	"net/url"
+	"time"
 )
 
 type PeerDictionaryConfig map[string]PeerConfig
@@ -12,15 +13,29 @@ type PeerConfig struct {
 	ApiKey   string   `yaml:"apiKey"`
 	Models   []string `yaml:"models"`
 	Filters  Filters  `yaml:"filters"`
+
+	// HealthCheckInterval is the seconds between requests to the peer's
+	// /v1/models to check it is up, 0 disables the checks
+	HealthCheckInterval int `yaml:"healthCheckInterval"`
+
+	// Activity pulls the activity of a peer that is another llmsnap from its
+	// /api/metrics after every successful health check
+	Activity bool `yaml:"activity"`
+}
+
+// HealthCheckDuration returns HealthCheckInterval as a time.Duration
+func (c PeerConfig) HealthCheckDuration() time.Duration {
+	return time.Duration(c.HealthCheckInterval) * time.Second
 }
 
 func (c *PeerConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	type rawPeerConfig PeerConfig
 	defaults := rawPeerConfig{
-		Proxy:   "",
-		ApiKey:  "",
-		Models:  []string{},
-		Filters: Filters{},
+		Proxy:               "",
+		ApiKey:              "",
+		Models:              []string{},
+		Filters:             Filters{},
+		HealthCheckInterval: 30,
 	}
 
 	if err := unmarshal(&defaults); err != nil {
@@ -44,6 +59,13 @@ func (c *PeerConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
 		return fmt.Errorf("peer models can not be empty")
 	}
 
+	if defaults.HealthCheckInterval < 0 {
+		return fmt.Errorf("healthCheckInterval must be non-negative, got %d", defaults.HealthCheckInterval)
+	}
+	if defaults.Activity && defaults.HealthCheckInterval == 0 {
+		return fmt.Errorf("activity requires healthCheckInterval")
+	}
+
 	*c = PeerConfig(defaults)
 	return nil
 }
@@ -73,6 +73,25 @@ models: []
 `,
 			wantErr: "peer models can not be empty",
 		},
+		{
+			name: "negative healthCheckInterval",
+			yaml: `
+proxy: http://localhost:8080
+models: [model_a]
+healthCheckInterval: -1
+`,
+			wantErr: "healthCheckInterval must be non-negative",
+		},
+		{
+			name: "activity without health checks",
+			yaml: `
+proxy: http://localhost:8080
+models: [model_a]
+healthCheckInterval: 0
+activity: true
+`,
+			wantErr: "activity requires healthCheckInterval",
+		},
 	}
 
 	for _, tt := range tests {
@@ -123,6 +142,10 @@ models:
 	if config.ProxyURL.Path != "/api" {
 		t.Errorf("expected path %q, got %q", "/api", config.ProxyURL.Path)
 	}
+
+	if config.HealthCheckInterval != 30 {
+		t.Errorf("expected healthCheckInterval %d, got %d", 30, config.HealthCheckInterval)
+	}
 }
 
 func contains(s, substr string) bool {
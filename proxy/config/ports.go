@@ -0,0 +1,139 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"net"
+	"sync"
+)
+
+// PortRangeConfig makes ${PORT} values come from a range of free ports
+// instead of counting up from startPort
+type PortRangeConfig struct {
+	// Start is the first port of the range, 0 is startPort
+	Start int `yaml:"start"`
+
+	// End is the last port of the range, 0 turns the range off
+	End int `yaml:"end"`
+}
+
+// Enabled reports if ports are picked from the range
+func (p PortRangeConfig) Enabled() bool {
+	return p.End > 0
+}
+
+func (p PortRangeConfig) validate() error {
+	if !p.Enabled() {
+		return nil
+	}
+	if p.Start < 1 || p.End > 65535 {
+		return fmt.Errorf("portRange must be within 1-65535")
+	}
+	if p.End < p.Start {
+		return fmt.Errorf("portRange.end must be greater than or equal to portRange.start")
+	}
+	return nil
+}
+
+// PortAllocator keeps the ports picked from portRange across config loads:
+// a key, the model ID, model#N for instances and model.draft for drafts,
+// keeps its port so reloading the config does not move, and restart, models
+// that did not change. New keys get the first port that is not assigned and
+// that nothing else listens on.
+type PortAllocator struct {
+	mu       sync.Mutex
+	assigned map[string]portAssignment
+	inUse    func(port int) bool
+}
+
+type portAssignment struct {
+	model string
+	port  int
+}
+
+// NewPortAllocator returns a PortAllocator without assigned ports
+func NewPortAllocator() *PortAllocator {
+	return newPortAllocator(portInUse)
+}
+
+func newPortAllocator(inUse func(port int) bool) *PortAllocator {
+	return &PortAllocator{assigned: make(map[string]portAssignment), inUse: inUse}
+}
+
+// portInUse reports if something already listens on port
+func portInUse(port int) bool {
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return true
+	}
+	l.Close()
+	return false
+}
+
+// Keep makes the ports of conf the assigned ones, call it once conf is in
+// use. Loading a config does not change the assignments so a config that
+// fails to load or is only validated does not release the ports of running
+// models.
+func (a *PortAllocator) Keep(conf Config) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.assigned = maps.Clone(conf.ports)
+	if a.assigned == nil {
+		a.assigned = make(map[string]portAssignment)
+	}
+}
+
+// plan returns the assignments a config with models starts from, the ports
+// of models it no longer has are released so its new models can have them
+func (a *PortAllocator) plan(models map[string]ModelConfig) *portPlan {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	plan := &portPlan{assigned: make(map[string]portAssignment), keys: make(map[string]bool), inUse: a.inUse}
+	for key, assigned := range a.assigned {
+		if _, found := models[assigned.model]; found {
+			plan.assigned[key] = assigned
+		}
+	}
+	return plan
+}
+
+// portPlan holds the ports of a config while it is loaded
+type portPlan struct {
+	assigned map[string]portAssignment
+	keys     map[string]bool
+	inUse    func(port int) bool
+}
+
+// take returns the port of key, assigning one from r when key has none or
+// its port is outside of r
+func (p *portPlan) take(model, key string, r PortRangeConfig) (int, error) {
+	p.keys[key] = true
+	if assigned, found := p.assigned[key]; found && assigned.port >= r.Start && assigned.port <= r.End {
+		return assigned.port, nil
+	}
+
+	taken := make(map[int]bool, len(p.assigned))
+	for other, assigned := range p.assigned {
+		if other != key {
+			taken[assigned.port] = true
+		}
+	}
+	for port := r.Start; port <= r.End; port++ {
+		if taken[port] || p.inUse(port) {
+			continue
+		}
+		p.assigned[key] = portAssignment{model: model, port: port}
+		return port, nil
+	}
+	return 0, fmt.Errorf("no free port left in portRange %d-%d", r.Start, r.End)
+}
+
+// result returns the ports of the keys that were taken, instances and drafts
+// the config no longer has are left out
+func (p *portPlan) result() map[string]portAssignment {
+	result := make(map[string]portAssignment, len(p.keys))
+	for key := range p.keys {
+		result[key] = p.assigned[key]
+	}
+	return result
+}
@@ -0,0 +1,33 @@
+package config
+
+import "fmt"
+
+// PricingConfig prices the tokens of a model, in any currency, to compare
+// local inference with cloud pricing
+type PricingConfig struct {
+	// InputPer1M is the price of a million input tokens
+	InputPer1M float64 `yaml:"input_per_1m"`
+
+	// OutputPer1M is the price of a million output tokens
+	OutputPer1M float64 `yaml:"output_per_1m"`
+}
+
+// Enabled reports if the model has a price
+func (p PricingConfig) Enabled() bool {
+	return p.InputPer1M > 0 || p.OutputPer1M > 0
+}
+
+// Cost returns the price of a request with the given token counts
+func (p PricingConfig) Cost(inputTokens, outputTokens int) float64 {
+	return (float64(inputTokens)*p.InputPer1M + float64(outputTokens)*p.OutputPer1M) / 1_000_000
+}
+
+func (p PricingConfig) validate() error {
+	if p.InputPer1M < 0 {
+		return fmt.Errorf("input_per_1m must be non-negative, got %g", p.InputPer1M)
+	}
+	if p.OutputPer1M < 0 {
+		return fmt.Errorf("output_per_1m must be non-negative, got %g", p.OutputPer1M)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// ProbeConfig sends a tiny chat completion to a model on an interval. The
+// results are kept apart from user traffic and measure the model's
+// availability.
+type ProbeConfig struct {
+	// Interval in seconds between probes, 0 disables probing
+	Interval int `yaml:"interval"`
+
+	// Prompt sent as the user message, empty is "ping"
+	Prompt string `yaml:"prompt"`
+
+	// MaxTokens of the reply, 0 is 1
+	MaxTokens int `yaml:"maxTokens"`
+
+	// Timeout in seconds of a probe, 0 is 60
+	Timeout int `yaml:"timeout"`
+
+	// Load starts the model for a probe, otherwise probes are skipped while
+	// the model is not loaded
+	Load bool `yaml:"load"`
+}
+
+// Enabled reports if the model is probed
+func (p ProbeConfig) Enabled() bool {
+	return p.Interval > 0
+}
+
+// Every returns the time between probes
+func (p ProbeConfig) Every() time.Duration {
+	return time.Duration(p.Interval) * time.Second
+}
+
+// PromptText returns the prompt sent in a probe
+func (p ProbeConfig) PromptText() string {
+	if p.Prompt != "" {
+		return p.Prompt
+	}
+	return "ping"
+}
+
+// ReplyTokens returns the max_tokens sent in a probe
+func (p ProbeConfig) ReplyTokens() int {
+	if p.MaxTokens > 0 {
+		return p.MaxTokens
+	}
+	return 1
+}
+
+// TimeoutDuration returns how long a probe may take
+func (p ProbeConfig) TimeoutDuration() time.Duration {
+	if p.Timeout > 0 {
+		return time.Duration(p.Timeout) * time.Second
+	}
+	return time.Minute
+}
+
+func (p ProbeConfig) validate() error {
+	if p.Interval < 0 {
+		return fmt.Errorf("interval must be non-negative, got %d", p.Interval)
+	}
+	if p.MaxTokens < 0 {
+		return fmt.Errorf("maxTokens must be non-negative, got %d", p.MaxTokens)
+	}
+	if p.Timeout < 0 {
+		return fmt.Errorf("timeout must be non-negative, got %d", p.Timeout)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// QuarantineConfig takes models out of routing when they keep failing. A
+// failure is a start that fails, a health check that times out or a process
+// that exits on its own. A quarantined model stays out until an operator
+// enables it again.
+type QuarantineConfig struct {
+	// MaxFailures within Window that quarantine a model, 0 disables quarantine
+	MaxFailures int `yaml:"maxFailures"`
+
+	// Window in seconds the failures are counted in, 0 is 600
+	Window int `yaml:"window"`
+}
+
+// Enabled reports if models are quarantined
+func (q QuarantineConfig) Enabled() bool {
+	return q.MaxFailures > 0
+}
+
+// WindowDuration returns how far back failures are counted
+func (q QuarantineConfig) WindowDuration() time.Duration {
+	if q.Window > 0 {
+		return time.Duration(q.Window) * time.Second
+	}
+	return 10 * time.Minute
+}
+
+func (q QuarantineConfig) Validate() error {
+	if q.MaxFailures < 0 {
+		return fmt.Errorf("quarantine.maxFailures must be greater than or equal to 0")
+	}
+	if q.Window < 0 {
+		return fmt.Errorf("quarantine.window must be greater than or equal to 0")
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// QueueConfig bounds the requests waiting for a model, while it is swapped
+// in or while its slots are busy
+type QueueConfig struct {
+	// MaxDepth is the number of requests that may wait, more are rejected
+	// with a 429. 0 is unlimited.
+	MaxDepth int `yaml:"maxDepth"`
+
+	// Timeout in seconds a request may wait before it is rejected with a
+	// 503, 0 waits as long as it takes
+	Timeout int `yaml:"timeout"`
+}
+
+// TimeoutDuration returns how long a request may wait, 0 when unlimited
+func (q QueueConfig) TimeoutDuration() time.Duration {
+	return time.Duration(q.Timeout) * time.Second
+}
+
+func (q QueueConfig) validate() error {
+	if q.MaxDepth < 0 {
+		return fmt.Errorf("maxDepth must be non-negative, got %d", q.MaxDepth)
+	}
+	if q.Timeout < 0 {
+		return fmt.Errorf("timeout must be non-negative, got %d", q.Timeout)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package config
+
+import "fmt"
+
+// ResponseCacheConfig configures caching of responses to identical,
+// deterministic requests. A value of 0 uses the default for that setting.
+type ResponseCacheConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// TTL is how long a cached response is served, in seconds
+	TTL int `yaml:"ttl"`
+
+	// MaxEntries limits the number of cached responses
+	MaxEntries int `yaml:"maxEntries"`
+
+	// MaxSizeMB limits the total size of all cached response bodies
+	MaxSizeMB int `yaml:"maxSizeMB"`
+
+	// Dir keeps the cached responses on disk as well, so they survive
+	// restarts and config reloads. Empty keeps them in memory only.
+	Dir string `yaml:"dir"`
+
+	// MaxDiskMB limits the total size of the responses in Dir
+	MaxDiskMB int `yaml:"maxDiskMB"`
+}
+
+// Validate checks that no negative values were configured
+func (r ResponseCacheConfig) Validate() error {
+	if r.TTL < 0 {
+		return fmt.Errorf("responseCache.ttl must be greater than or equal to 0")
+	}
+	if r.MaxEntries < 0 {
+		return fmt.Errorf("responseCache.maxEntries must be greater than or equal to 0")
+	}
+	if r.MaxSizeMB < 0 {
+		return fmt.Errorf("responseCache.maxSizeMB must be greater than or equal to 0")
+	}
+	if r.MaxDiskMB < 0 {
+		return fmt.Errorf("responseCache.maxDiskMB must be greater than or equal to 0")
+	}
+	return nil
+}
+
+// ModelResponseCacheConfig overrides responseCache for one model. A value of
+// 0 uses the global setting.
+type ModelResponseCacheConfig struct {
+	// Enabled turns the cache on or off for the model, unset follows
+	// responseCache.enabled
+	Enabled *bool `yaml:"enabled"`
+
+	// TTL is how long a cached response of the model is served, in seconds
+	TTL int `yaml:"ttl"`
+
+	// MaxEntries and MaxSizeMB give the model its own share of the cache,
+	// its responses no longer evict those of other models
+	MaxEntries int `yaml:"maxEntries"`
+	MaxSizeMB  int `yaml:"maxSizeMB"`
+}
+
+// OwnLimits reports if the model has its own share of the cache
+func (r ModelResponseCacheConfig) OwnLimits() bool {
+	return r.MaxEntries > 0 || r.MaxSizeMB > 0
+}
+
+func (r ModelResponseCacheConfig) validate() error {
+	if r.TTL < 0 {
+		return fmt.Errorf("ttl must be greater than or equal to 0")
+	}
+	if r.MaxEntries < 0 {
+		return fmt.Errorf("maxEntries must be greater than or equal to 0")
+	}
+	if r.MaxSizeMB < 0 {
+		return fmt.Errorf("maxSizeMB must be greater than or equal to 0")
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// headers llmsnap can add to responses
+const (
+	// the model that served the request
+	ModelResponseHeader = "X-LLMSnap-Model"
+
+	// milliseconds between llmsnap receiving the request and sending it
+	// upstream, the time spent waiting for a slot and for the model to load
+	QueueTimeResponseHeader = "X-LLMSnap-Queue-Time"
+)
+
+var llmsnapResponseHeaders = []string{ModelResponseHeader, QueueTimeResponseHeader}
+
+// upstream headers that are always passed through, a response can not be
+// read without them
+var essentialResponseHeaders = []string{"Content-Type", "Content-Length", "Content-Encoding"}
+
+// ResponseHeadersConfig controls which upstream response headers reach
+// clients and which headers llmsnap adds. Names are case-insensitive and a
+// trailing * matches any name with that prefix, like X-Ratelimit-*.
+type ResponseHeadersConfig struct {
+	// Allow passes only these upstream headers, all when empty
+	Allow []string `yaml:"allow"`
+
+	// Deny removes these upstream headers
+	Deny []string `yaml:"deny"`
+
+	// Add lists the llmsnap headers to add: X-LLMSnap-Model and
+	// X-LLMSnap-Queue-Time
+	Add []string `yaml:"add"`
+}
+
+// Filters reports if upstream headers are removed
+func (c ResponseHeadersConfig) Filters() bool {
+	return len(c.Allow) > 0 || len(c.Deny) > 0
+}
+
+// Enabled reports if responses are changed at all
+func (c ResponseHeadersConfig) Enabled() bool {
+	return c.Filters() || len(c.Add) > 0
+}
+
+// Passes reports if the upstream header name reaches the client
+func (c ResponseHeadersConfig) Passes(name string) bool {
+	if matchesHeader(essentialResponseHeaders, name) {
+		return true
+	}
+	if len(c.Allow) > 0 && !matchesHeader(c.Allow, name) {
+		return false
+	}
+	return !matchesHeader(c.Deny, name)
+}
+
+// Adds reports if the llmsnap header name is added to responses
+func (c ResponseHeadersConfig) Adds(name string) bool {
+	return matchesHeader(c.Add, name)
+}
+
+func matchesHeader(patterns []string, name string) bool {
+	name = http.CanonicalHeaderKey(name)
+	for _, pattern := range patterns {
+		pattern = http.CanonicalHeaderKey(pattern)
+		if prefix, wildcard := strings.CutSuffix(pattern, "*"); wildcard {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		} else if name == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks the header names and that only known llmsnap headers are
+// added
+func (c ResponseHeadersConfig) Validate() error {
+	for _, list := range []struct {
+		key     string
+		headers []string
+	}{{"allow", c.Allow}, {"deny", c.Deny}, {"add", c.Add}} {
+		for _, name := range list.headers {
+			if strings.TrimSuffix(name, "*") == "" {
+				return fmt.Errorf("responseHeaders.%s: header names must not be empty", list.key)
+			}
+		}
+	}
+	for _, name := range c.Add {
+		if !matchesHeader(llmsnapResponseHeaders, name) {
+			return fmt.Errorf("responseHeaders.add: unknown header %s, expected one of %s", name, strings.Join(llmsnapResponseHeaders, ", "))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Rewrite is an operation on a JSON body at a gjson/sjson path, e.g.
+// "stream_options.include_usage". Exactly one of Set, Delete and Rename is
+// used.
+type Rewrite struct {
+	// Set writes Value at this path
+	Set   string `yaml:"set"`
+	Value any    `yaml:"value"`
+
+	// Delete removes this path
+	Delete string `yaml:"delete"`
+
+	// Rename moves the value at this path to To
+	Rename string `yaml:"rename"`
+	To     string `yaml:"to"`
+
+	// If only applies the rewrite when the value at this path exists and is
+	// not false or null, e.g. "stream"
+	If string `yaml:"if"`
+}
+
+// Paths returns the paths the rewrite writes to or removes
+func (r Rewrite) Paths() []string {
+	switch {
+	case r.Set != "":
+		return []string{r.Set}
+	case r.Delete != "":
+		return []string{r.Delete}
+	default:
+		return []string{r.Rename, r.To}
+	}
+}
+
+func (r Rewrite) validate() error {
+	ops := 0
+	for _, path := range []string{r.Set, r.Delete, r.Rename} {
+		if path != "" {
+			ops++
+		}
+	}
+	if ops != 1 {
+		return errors.New("exactly one of set, delete or rename is required")
+	}
+	if r.Rename != "" && r.To == "" {
+		return errors.New("rename requires to")
+	}
+	if r.Rename == "" && r.To != "" {
+		return errors.New("to is only used with rename")
+	}
+	return nil
+}
+
+// validateRewrites checks a list of rewrites, the paths in protected can not
+// be changed
+func validateRewrites(rewrites []Rewrite, protected []string) error {
+	for i, rewrite := range rewrites {
+		if err := rewrite.validate(); err != nil {
+			return fmt.Errorf("%d: %v", i, err)
+		}
+		for _, path := range rewrite.Paths() {
+			if slices.Contains(protected, path) {
+				return fmt.Errorf("%d: %s can not be rewritten", i, path)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+)
+
+// RouterConfig publishes a model ID that sends each request to one of
+// several models by the size of its prompt, e.g. short chats to a small
+// model and long documents to a model with a large context
+type RouterConfig struct {
+	// Route is tried in order, the request goes to the first rule it fits
+	Route []RouteRule `yaml:"route"`
+
+	// Name and Description are shown in /v1/models
+	Name        string `yaml:"name"`
+	Description string `yaml:"description"`
+}
+
+// RouteRule sends requests up to a size to a model
+type RouteRule struct {
+	// MaxTokens is the largest estimate of the prompt tokens plus the
+	// requested max_tokens the rule takes, 0 takes any size
+	MaxTokens int `yaml:"maxTokens"`
+
+	// Model gets the requests, an ID or alias
+	Model string `yaml:"model"`
+}
+
+// ValidateRouters checks the rules of each router, the models are checked
+// once they are resolved to their IDs
+func ValidateRouters(routers map[string]RouterConfig) error {
+	names := make([]string, 0, len(routers))
+	for name := range routers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		router := routers[name]
+		if len(router.Route) == 0 {
+			return fmt.Errorf("routers.%s.route must have at least one rule", name)
+		}
+		for i, rule := range router.Route {
+			if rule.Model == "" {
+				return fmt.Errorf("routers.%s.route[%d]: model is required", name, i)
+			}
+			if rule.MaxTokens < 0 {
+				return fmt.Errorf("routers.%s.route[%d].maxTokens must be greater than or equal to 0", name, i)
+			}
+			if rule.MaxTokens == 0 && i < len(router.Route)-1 {
+				return fmt.Errorf("routers.%s.route[%d]: only the last rule can take any size", name, i)
+			}
+		}
+	}
+	return nil
+}
+
+// Pick returns the model of the first rule that takes a request of tokens
+func (r RouterConfig) Pick(tokens int) (string, bool) {
+	for _, rule := range r.Route {
+		if rule.MaxTokens == 0 || tokens <= rule.MaxTokens {
+			return rule.Model, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,117 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ScheduleAction is what happens to a schedule's models when its window ends
+type ScheduleAction string
+
+const (
+	// ScheduleSleep puts the models that support sleep to sleep
+	ScheduleSleep ScheduleAction = "sleep"
+
+	// ScheduleUnload stops the models
+	ScheduleUnload ScheduleAction = "unload"
+)
+
+var scheduleDays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// ScheduleConfig keeps the models of groups loaded and awake during a time
+// window, like business hours. Outside the window they idle as usual.
+type ScheduleConfig struct {
+	// Name is used in logs
+	Name string `yaml:"name"`
+
+	// Days the window starts on: mon, tue, wed, thu, fri, sat or sun. Empty
+	// is every day.
+	Days []string `yaml:"days"`
+
+	// Start and End of the window as HH:MM. A window that ends before it
+	// starts runs past midnight, the same start and end is all day.
+	Start string `yaml:"start"`
+	End   string `yaml:"end"`
+
+	// Timezone is an IANA name like Europe/Berlin, empty is the local time
+	Timezone string `yaml:"timezone"`
+
+	// Groups whose members are kept loaded
+	Groups []string `yaml:"groups"`
+
+	// Models are model IDs or aliases kept loaded
+	Models []string `yaml:"models"`
+
+	// AfterHours is sleep, unload or empty to leave the models to their ttl
+	AfterHours ScheduleAction `yaml:"afterHours"`
+}
+
+// Validate checks the days, times, timezone and action
+func (s ScheduleConfig) Validate() error {
+	for _, day := range s.Days {
+		if !slices.Contains(scheduleDays, strings.ToLower(day)) {
+			return fmt.Errorf("invalid day '%s', must be one of: %s", day, strings.Join(scheduleDays, ", "))
+		}
+	}
+	if _, err := parseClock(s.Start); err != nil {
+		return fmt.Errorf("start: %v", err)
+	}
+	if _, err := parseClock(s.End); err != nil {
+		return fmt.Errorf("end: %v", err)
+	}
+	if _, err := time.LoadLocation(s.Timezone); err != nil {
+		return fmt.Errorf("timezone: %v", err)
+	}
+	switch s.AfterHours {
+	case "", ScheduleSleep, ScheduleUnload:
+	default:
+		return fmt.Errorf("afterHours must be one of: sleep, unload")
+	}
+	if len(s.Groups) == 0 && len(s.Models) == 0 {
+		return fmt.Errorf("groups or models are required")
+	}
+	return nil
+}
+
+// Active reports if now is inside the window. The schedule must be valid.
+func (s ScheduleConfig) Active(now time.Time) bool {
+	if loc, err := time.LoadLocation(s.Timezone); err == nil {
+		now = now.In(loc)
+	}
+	start, _ := parseClock(s.Start)
+	end, _ := parseClock(s.End)
+	minute := now.Hour()*60 + now.Minute()
+
+	day := now
+	switch {
+	case start < end:
+		if minute < start || minute >= end {
+			return false
+		}
+	case start > end:
+		if minute < end {
+			// after midnight, the window started the day before
+			day = now.AddDate(0, 0, -1)
+		} else if minute < start {
+			return false
+		}
+	}
+
+	if len(s.Days) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(s.Days, func(d string) bool {
+		return strings.ToLower(d) == scheduleDays[day.Weekday()]
+	})
+}
+
+// parseClock returns the minutes after midnight of a HH:MM time
+func parseClock(clock string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(clock))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time '%s', expected HH:MM", clock)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
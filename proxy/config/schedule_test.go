@@ -0,0 +1,150 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScheduleConfig_Active(t *testing.T) {
+	// 2026-10-12 is a Monday
+	at := func(day int, clock string) time.Time {
+		t, _ := time.Parse("15:04", clock)
+		return time.Date(2026, time.October, day, t.Hour(), t.Minute(), 0, 0, time.UTC)
+	}
+
+	workday := ScheduleConfig{Days: []string{"mon", "tue", "wed", "thu", "fri"}, Start: "09:00", End: "18:00", Timezone: "UTC"}
+	assert.False(t, workday.Active(at(12, "08:59")))
+	assert.True(t, workday.Active(at(12, "09:00")))
+	assert.True(t, workday.Active(at(12, "17:59")))
+	assert.False(t, workday.Active(at(12, "18:00")))
+	assert.False(t, workday.Active(at(17, "12:00")), "saturday")
+
+	// the window after midnight belongs to the day it started on
+	nights := ScheduleConfig{Days: []string{"Fri"}, Start: "22:00", End: "02:00", Timezone: "UTC"}
+	assert.True(t, nights.Active(at(16, "23:00")))
+	assert.True(t, nights.Active(at(17, "01:00")))
+	assert.False(t, nights.Active(at(16, "01:00")), "thursday night")
+	assert.False(t, nights.Active(at(17, "03:00")))
+
+	allDay := ScheduleConfig{Start: "00:00", End: "00:00", Timezone: "UTC"}
+	assert.True(t, allDay.Active(at(14, "13:37")))
+
+	// 09:00 in Berlin is 07:00 UTC in summer time
+	berlin := ScheduleConfig{Start: "09:00", End: "10:00", Timezone: "Europe/Berlin"}
+	assert.True(t, berlin.Active(at(12, "07:30")))
+	assert.False(t, berlin.Active(at(12, "09:30")))
+}
+
+func TestConfig_Schedules(t *testing.T) {
+	content := `
+models:
+  model1:
+    cmd: path/to/cmd --port ${PORT}
+    aliases: [m1]
+  model2:
+    cmd: path/to/cmd --port ${PORT}
+groups:
+  office:
+    members: [model2]
+schedules:
+  - name: workday
+    days: [mon, tue, wed, thu, fri]
+    start: "09:00"
+    end: "18:00"
+    groups: [office]
+    models: [m1]
+    afterHours: unload
+`
+	config, err := LoadConfigFromReader(strings.NewReader(content))
+	assert.NoError(t, err)
+	assert.Equal(t, []ScheduleConfig{{
+		Name:       "workday",
+		Days:       []string{"mon", "tue", "wed", "thu", "fri"},
+		Start:      "09:00",
+		End:        "18:00",
+		Groups:     []string{"office"},
+		Models:     []string{"model1"},
+		AfterHours: ScheduleUnload,
+	}}, config.Schedules)
+
+	for _, tc := range []struct{ from, to, err string }{
+		{"days: [mon, tue, wed, thu, fri]", "days: [someday]", "schedules[0]: invalid day 'someday'"},
+		{`start: "09:00"`, `start: "9am"`, "schedules[0]: start: invalid time '9am', expected HH:MM"},
+		{"afterHours: unload", "afterHours: stop", "schedules[0]: afterHours must be one of: sleep, unload"},
+		{"groups: [office]", "groups: [home]", "schedules[0]: group home not found"},
+		{"models: [m1]", "models: [model3]", "schedules[0]: model model3 not found"},
+		{"afterHours: unload", "timezone: Mars/Olympus", "schedules[0]: timezone:"},
+	} {
+		_, err := LoadConfigFromReader(strings.NewReader(strings.Replace(content, tc.from, tc.to, 1)))
+		assert.ErrorContains(t, err, tc.err)
+	}
+}
+
+func TestCron_Matches(t *testing.T) {
+	// 2026-10-12 is a Monday
+	at := func(day int, clock string) time.Time {
+		t, _ := time.Parse("15:04", clock)
+		return time.Date(2026, time.October, day, t.Hour(), t.Minute(), 0, 0, time.UTC)
+	}
+	cron := func(expr string) Cron {
+		c, err := ParseCron(expr)
+		assert.NoError(t, err)
+		return c
+	}
+
+	workdays := cron("0 8 * * 1-5")
+	assert.True(t, workdays.Matches(at(12, "08:00")))
+	assert.False(t, workdays.Matches(at(12, "08:01")))
+	assert.False(t, workdays.Matches(at(17, "08:00")), "saturday")
+
+	assert.True(t, cron("*/15 * * * *").Matches(at(14, "13:45")))
+	assert.False(t, cron("*/15 * * * *").Matches(at(14, "13:50")))
+	assert.True(t, cron("30 20,22 * oct sat,sun").Matches(at(18, "22:30")))
+	assert.True(t, cron("0 0 * * 7").Matches(at(18, "00:00")), "7 is sunday")
+
+	// either day field matches when both are restricted
+	assert.True(t, cron("0 0 1 * mon").Matches(at(12, "00:00")))
+	assert.False(t, cron("0 0 1 * mon").Matches(at(13, "00:00")))
+
+	for _, expr := range []string{"0 8 * *", "60 * * * *", "0 8 * * someday", "0 20-8 * * *", "*/0 * * * *"} {
+		_, err := ParseCron(expr)
+		assert.Error(t, err, expr)
+	}
+}
+
+func TestConfig_CronSchedule(t *testing.T) {
+	content := `
+models:
+  model1:
+    cmd: path/to/cmd --port ${PORT}
+    schedule:
+      preload: "0 8 * * 1-5"
+      unload: "0 20 * * *"
+  model2:
+    cmd: path/to/cmd --port ${PORT}
+groups:
+  office:
+    members: [model2]
+    schedule:
+      sleep: "30 12 * * *"
+      timezone: Europe/Berlin
+`
+	config, err := LoadConfigFromReader(strings.NewReader(content))
+	assert.NoError(t, err)
+	assert.Equal(t, CronSchedule{Preload: "0 8 * * 1-5", Unload: "0 20 * * *"}, config.Models["model1"].Schedule)
+	assert.Equal(t, time.Local, config.Models["model1"].Schedule.Location())
+	assert.Equal(t, CronSchedule{Sleep: "30 12 * * *", Timezone: "Europe/Berlin"}, config.Groups["office"].Schedule)
+	assert.False(t, config.Models["model2"].Schedule.Enabled())
+
+	for _, tc := range []struct{ from, to, err string }{
+		{`preload: "0 8 * * 1-5"`, `preload: "8am"`, "schedule: preload: invalid cron expression '8am', expected 5 fields"},
+		{`unload: "0 20 * * *"`, `unload: "0 25 * * *"`, "schedule: unload: invalid cron expression '0 25 * * *': invalid value '25', must be 0-23"},
+		{"timezone: Europe/Berlin", "timezone: Mars/Olympus", "schedule in group office: timezone:"},
+	} {
+		_, err := LoadConfigFromReader(strings.NewReader(strings.Replace(content, tc.from, tc.to, 1)))
+		assert.ErrorContains(t, err, tc.err)
+	}
+}
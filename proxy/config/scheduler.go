@@ -0,0 +1,30 @@
+package config
+
+import "fmt"
+
+// SchedulerConfig limits how many requests are sent to upstreams at the same
+// time. Waiting requests are released highest priority first.
+type SchedulerConfig struct {
+	// MaxConcurrent is the number of requests proxied at once, 0 disables the scheduler
+	MaxConcurrent int `yaml:"maxConcurrent"`
+
+	// MaxWait is the maximum seconds a request waits in the queue, 0 waits forever
+	MaxWait int `yaml:"maxWait"`
+
+	// PriorityHeader is an optional request header holding an integer priority
+	PriorityHeader string `yaml:"priorityHeader"`
+
+	// APIKeyPriority maps API keys to a priority
+	APIKeyPriority map[string]int `yaml:"apiKeyPriority"`
+}
+
+// Validate checks that no negative values were configured
+func (s SchedulerConfig) Validate() error {
+	if s.MaxConcurrent < 0 {
+		return fmt.Errorf("scheduler.maxConcurrent must be greater than or equal to 0")
+	}
+	if s.MaxWait < 0 {
+		return fmt.Errorf("scheduler.maxWait must be greater than or equal to 0")
+	}
+	return nil
+}
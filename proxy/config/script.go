@@ -0,0 +1,78 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// ScriptConfig runs a Lua script in-process on the JSON requests and
+// responses of a model. The script defines any of these functions, each
+// gets the decoded JSON as a table and returns the table to use, or nothing
+// to keep the table it was given with its changes:
+//
+//	on_request(body, path)   the request, after the filters and rewrites
+//	on_response(body, path)  a complete JSON response
+//	on_event(event)          an event of a chat completion stream, return
+//	                         false to drop it
+//
+// Integers a Lua number can not hold exactly, like 64 bit seeds, are passed
+// as values that keep their digits, tostring() returns them.
+type ScriptConfig struct {
+	// File is the path of the script
+	File string `yaml:"file"`
+
+	// Source is the script itself, instead of a File
+	Source string `yaml:"source"`
+
+	// Timeout in milliseconds of a call of the script, 0 is 100
+	Timeout int `yaml:"timeout"`
+}
+
+// Enabled reports if the model has a script
+func (s ScriptConfig) Enabled() bool {
+	return s.File != "" || s.Source != ""
+}
+
+// Code returns the source of the script and the name it is known by
+func (s ScriptConfig) Code() (string, string, error) {
+	if s.Source != "" {
+		return s.Source, "script", nil
+	}
+	data, err := os.ReadFile(s.File)
+	if err != nil {
+		return "", "", err
+	}
+	return string(data), s.File, nil
+}
+
+// TimeoutDuration returns how long a call of the script may run
+func (s ScriptConfig) TimeoutDuration() time.Duration {
+	if s.Timeout > 0 {
+		return time.Duration(s.Timeout) * time.Millisecond
+	}
+	return 100 * time.Millisecond
+}
+
+func (s ScriptConfig) validate() error {
+	if !s.Enabled() {
+		return nil
+	}
+	if s.File != "" && s.Source != "" {
+		return fmt.Errorf("only one of file or source can be set")
+	}
+	if s.Timeout < 0 {
+		return fmt.Errorf("timeout must be non-negative, got %d", s.Timeout)
+	}
+	code, name, err := s.Code()
+	if err != nil {
+		return err
+	}
+	if _, err := parse.Parse(strings.NewReader(code), name); err != nil {
+		return fmt.Errorf("invalid script: %v", err)
+	}
+	return nil
+}
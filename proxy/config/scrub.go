@@ -0,0 +1,94 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// scrubDetectors are the built-in patterns that can be enabled by name
+var scrubDetectors = map[string]string{
+	"email": `[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`,
+	"phone": `(?:\+\d{1,3}[ .-]?)?(?:\(\d{2,4}\)|\d{2,4})[ .-]\d{3,4}[ .-]\d{3,4}`,
+	"key": `(?:sk|pk|rk)-[A-Za-z0-9_-]{16,}` +
+		`|(?:AKIA|ASIA)[A-Z0-9]{16}` +
+		`|gh[pousr]_[A-Za-z0-9]{36,}` +
+		`|xox[abprs]-[A-Za-z0-9-]{10,}` +
+		`|(?i:bearer) [A-Za-z0-9._~+/-]{20,}=*`,
+}
+
+// ScrubPattern is a custom detector
+type ScrubPattern struct {
+	// Name is used in the replacement text, [NAME]
+	Name  string `yaml:"name"`
+	Regex string `yaml:"regex"`
+}
+
+// ScrubConfig removes personal data and secrets from request bodies before
+// they are captured or logged, and optionally before they are forwarded
+type ScrubConfig struct {
+	// Detectors are names of built-in detectors: email, phone, key
+	Detectors []string `yaml:"detectors"`
+
+	// Patterns are custom detectors, applied after the built-in ones
+	Patterns []ScrubPattern `yaml:"patterns"`
+
+	// Forward also scrubs request bodies before they are sent upstream
+	Forward bool `yaml:"forward"`
+}
+
+// ScrubRule is a compiled detector. Matches are replaced with Replacement.
+type ScrubRule struct {
+	Name        string
+	Regex       *regexp.Regexp
+	Replacement string
+}
+
+// Enabled returns whether any detector is configured
+func (s ScrubConfig) Enabled() bool {
+	return len(s.Detectors) > 0 || len(s.Patterns) > 0
+}
+
+// Rules compiles the configured detectors in the order they are applied
+func (s ScrubConfig) Rules() ([]ScrubRule, error) {
+	rules := make([]ScrubRule, 0, len(s.Detectors)+len(s.Patterns))
+	for _, name := range s.Detectors {
+		pattern, found := scrubDetectors[name]
+		if !found {
+			names := make([]string, 0, len(scrubDetectors))
+			for name := range scrubDetectors {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return nil, fmt.Errorf("scrub.detectors: unknown detector %s, must be one of: %s", name, strings.Join(names, ", "))
+		}
+		rules = append(rules, ScrubRule{Name: name, Regex: regexp.MustCompile(pattern), Replacement: scrubReplacement(name)})
+	}
+
+	for i, pattern := range s.Patterns {
+		if pattern.Name == "" {
+			return nil, fmt.Errorf("scrub.patterns[%d]: name is required", i)
+		}
+		if pattern.Regex == "" {
+			return nil, fmt.Errorf("scrub.patterns %s: regex is required", pattern.Name)
+		}
+		re, err := regexp.Compile(pattern.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("scrub.patterns %s: invalid regex: %w", pattern.Name, err)
+		}
+		rules = append(rules, ScrubRule{Name: pattern.Name, Regex: re, Replacement: scrubReplacement(pattern.Name)})
+	}
+
+	return rules, nil
+}
+
+// Validate checks that every detector exists and every pattern compiles
+func (s ScrubConfig) Validate() error {
+	_, err := s.Rules()
+	return err
+}
+
+func scrubReplacement(name string) string {
+	return "[" + strings.ToUpper(name) + "]"
+}
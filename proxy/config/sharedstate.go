@@ -0,0 +1,68 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// SharedStateConfig lets llmsnap instances in front of the same GPUs agree on
+// which instance runs each group. An instance holds a lease on a group in
+// Redis while any of its models are loaded. Requests for a group leased by
+// another instance are forwarded to that instance instead of starting a
+// second copy of the backend.
+type SharedStateConfig struct {
+	// Redis is host:port or redis://[:password@]host:port[/db], empty disables
+	// shared state
+	Redis string `yaml:"redis"`
+
+	// InstanceURL is where the other instances reach this one
+	InstanceURL string `yaml:"instanceURL"`
+
+	// KeyPrefix of the Redis keys, empty is "llmsnap"
+	KeyPrefix string `yaml:"keyPrefix"`
+
+	// LeaseTTL is the seconds a lease lasts without renewal, 0 is 30
+	LeaseTTL int `yaml:"leaseTTL"`
+}
+
+// Enabled reports if a Redis server is set
+func (s SharedStateConfig) Enabled() bool {
+	return s.Redis != ""
+}
+
+// Prefix returns the prefix of the Redis keys
+func (s SharedStateConfig) Prefix() string {
+	if s.KeyPrefix != "" {
+		return s.KeyPrefix
+	}
+	return "llmsnap"
+}
+
+// Lease returns how long a lease lasts without renewal
+func (s SharedStateConfig) Lease() time.Duration {
+	if s.LeaseTTL > 0 {
+		return time.Duration(s.LeaseTTL) * time.Second
+	}
+	return 30 * time.Second
+}
+
+func (s SharedStateConfig) Validate() error {
+	if !s.Enabled() {
+		return nil
+	}
+	if s.LeaseTTL < 0 {
+		return fmt.Errorf("sharedState.leaseTTL must be greater than or equal to 0")
+	}
+	if s.InstanceURL == "" {
+		return fmt.Errorf("sharedState.instanceURL is required with sharedState.redis")
+	}
+	instanceURL, err := url.Parse(s.InstanceURL)
+	if err != nil || (instanceURL.Scheme != "http" && instanceURL.Scheme != "https") || instanceURL.Host == "" {
+		return fmt.Errorf("sharedState.instanceURL must be an http or https URL, got %s", s.InstanceURL)
+	}
+	if _, err := url.Parse(s.Redis); err != nil {
+		return fmt.Errorf("sharedState.redis: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+package config
+
+import "fmt"
+
+// StructuredOutputConfig validates replies to chat completion requests with
+// a response_format of json_schema and re-asks the model when they do not
+// match the schema
+type StructuredOutputConfig struct {
+	// Retries is how many times an invalid reply is re-asked, 0 disables
+	// validation
+	Retries int `yaml:"retries"`
+}
+
+// Validate checks that no negative values were configured
+func (s StructuredOutputConfig) Validate() error {
+	if s.Retries < 0 {
+		return fmt.Errorf("structuredOutput.retries must be greater than or equal to 0")
+	}
+	return nil
+}
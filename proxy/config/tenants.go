@@ -0,0 +1,86 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+)
+
+// TenantConfig is a named group of clients sharing the server. Requests
+// belong to a tenant by their OpenAI-Organization or OpenAI-Project header.
+type TenantConfig struct {
+	// Organizations are OpenAI-Organization header values of the tenant
+	Organizations []string `yaml:"organizations"`
+
+	// Projects are OpenAI-Project header values of the tenant, they win over
+	// organizations
+	Projects []string `yaml:"projects"`
+
+	// Models the tenant may use, every model when empty. Aliases are stored
+	// as their real model IDs.
+	Models []string `yaml:"models"`
+
+	// MaxRequests within QuotaWindow, 0 is unlimited
+	MaxRequests int `yaml:"maxRequests"`
+
+	// MaxTokens, input and output, within QuotaWindow, 0 is unlimited
+	MaxTokens int `yaml:"maxTokens"`
+
+	// QuotaWindow in seconds the quotas are counted in, 0 is 86400
+	QuotaWindow int `yaml:"quotaWindow"`
+}
+
+// Allows reports if the tenant may use modelID
+func (t TenantConfig) Allows(modelID string) bool {
+	return len(t.Models) == 0 || slices.Contains(t.Models, modelID)
+}
+
+// Window returns how far back requests and tokens count towards the quotas
+func (t TenantConfig) Window() time.Duration {
+	if t.QuotaWindow > 0 {
+		return time.Duration(t.QuotaWindow) * time.Second
+	}
+	return 24 * time.Hour
+}
+
+// ValidateTenants checks the limits and that every header value belongs to
+// one tenant
+func ValidateTenants(tenants map[string]TenantConfig) error {
+	names := make([]string, 0, len(tenants))
+	for name := range tenants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	organizations := make(map[string]string)
+	projects := make(map[string]string)
+	for _, name := range names {
+		tenant := tenants[name]
+		if len(tenant.Organizations) == 0 && len(tenant.Projects) == 0 {
+			return fmt.Errorf("tenants.%s: organizations or projects is required", name)
+		}
+		if tenant.MaxRequests < 0 {
+			return fmt.Errorf("tenants.%s.maxRequests must be greater than or equal to 0", name)
+		}
+		if tenant.MaxTokens < 0 {
+			return fmt.Errorf("tenants.%s.maxTokens must be greater than or equal to 0", name)
+		}
+		if tenant.QuotaWindow < 0 {
+			return fmt.Errorf("tenants.%s.quotaWindow must be greater than or equal to 0", name)
+		}
+		for _, organization := range tenant.Organizations {
+			if other, found := organizations[organization]; found {
+				return fmt.Errorf("tenants.%s: organization %s is also used by tenant %s", name, organization, other)
+			}
+			organizations[organization] = name
+		}
+		for _, project := range tenant.Projects {
+			if other, found := projects[project]; found {
+				return fmt.Errorf("tenants.%s: project %s is also used by tenant %s", name, project, other)
+			}
+			projects[project] = name
+		}
+	}
+	return nil
+}
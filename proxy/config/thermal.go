@@ -0,0 +1,91 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// ThermalAction is what happens to loaded models when the GPUs are throttled
+type ThermalAction string
+
+const (
+	// ThermalSleep puts models with sleepMode enabled to sleep
+	ThermalSleep ThermalAction = "sleep"
+
+	// ThermalRefuse leaves loaded models alone and only refuses to load or
+	// wake models
+	ThermalRefuse ThermalAction = "refuse"
+)
+
+// ThermalConfig throttles models while a GPU runs too hot or the GPUs draw
+// too much power together. While throttled, requests that would load or wake
+// a model are refused. Throttling ends once the readings fall to the resume
+// thresholds.
+type ThermalConfig struct {
+	// MaxTemperature in °C of any GPU, 0 disables the temperature check
+	MaxTemperature int `yaml:"maxTemperature"`
+
+	// ResumeTemperature in °C, 0 is 5 below MaxTemperature
+	ResumeTemperature int `yaml:"resumeTemperature"`
+
+	// MaxPowerDraw in watts of all GPUs together, 0 disables the power check
+	MaxPowerDraw float64 `yaml:"maxPowerDraw"`
+
+	// ResumePowerDraw in watts, 0 is 90% of MaxPowerDraw
+	ResumePowerDraw float64 `yaml:"resumePowerDraw"`
+
+	// Action is sleep or refuse, empty means sleep
+	Action ThermalAction `yaml:"action"`
+
+	// CheckInterval is the seconds between GPU readings, 0 is 10
+	CheckInterval int `yaml:"checkInterval"`
+}
+
+// Enabled reports if a temperature or power limit is set
+func (t ThermalConfig) Enabled() bool {
+	return t.MaxTemperature > 0 || t.MaxPowerDraw > 0
+}
+
+// ResumeTemperatureC returns the temperature throttling ends at
+func (t ThermalConfig) ResumeTemperatureC() int {
+	if t.ResumeTemperature > 0 {
+		return t.ResumeTemperature
+	}
+	return t.MaxTemperature - 5
+}
+
+// ResumePowerDrawW returns the power draw throttling ends at
+func (t ThermalConfig) ResumePowerDrawW() float64 {
+	if t.ResumePowerDraw > 0 {
+		return t.ResumePowerDraw
+	}
+	return t.MaxPowerDraw * 0.9
+}
+
+// Interval returns the time between GPU readings
+func (t ThermalConfig) Interval() time.Duration {
+	if t.CheckInterval > 0 {
+		return time.Duration(t.CheckInterval) * time.Second
+	}
+	return 10 * time.Second
+}
+
+// Validate checks the action and that the resume thresholds are below the
+// limits
+func (t ThermalConfig) Validate() error {
+	switch t.Action {
+	case "", ThermalSleep, ThermalRefuse:
+	default:
+		return fmt.Errorf("thermal.action must be one of: sleep, refuse")
+	}
+	if t.MaxTemperature < 0 || t.ResumeTemperature < 0 || t.MaxPowerDraw < 0 || t.ResumePowerDraw < 0 || t.CheckInterval < 0 {
+		return fmt.Errorf("thermal values must be greater than or equal to 0")
+	}
+	if t.ResumeTemperature > 0 && t.ResumeTemperature >= t.MaxTemperature {
+		return fmt.Errorf("thermal.resumeTemperature must be below thermal.maxTemperature")
+	}
+	if t.ResumePowerDraw > 0 && t.ResumePowerDraw >= t.MaxPowerDraw {
+		return fmt.Errorf("thermal.resumePowerDraw must be below thermal.maxPowerDraw")
+	}
+	return nil
+}
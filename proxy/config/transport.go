@@ -0,0 +1,50 @@
+package config
+
+import "fmt"
+
+// TransportConfig tunes the http.Transport used to connect to upstream servers.
+// All durations are in seconds. A value of 0 keeps the Go http.DefaultTransport
+// behaviour for that setting.
+type TransportConfig struct {
+	// DialTimeout is the maximum time to wait for a TCP connection
+	DialTimeout int `yaml:"dialTimeout"`
+
+	// KeepAlive is the interval between TCP keep-alive probes
+	KeepAlive int `yaml:"keepAlive"`
+
+	// MaxIdleConns limits idle connections across all upstreams
+	MaxIdleConns int `yaml:"maxIdleConns"`
+
+	// MaxIdleConnsPerHost limits idle connections kept per upstream
+	MaxIdleConnsPerHost int `yaml:"maxIdleConnsPerHost"`
+
+	// IdleConnTimeout is how long an idle connection is kept before closing
+	IdleConnTimeout int `yaml:"idleConnTimeout"`
+
+	// ResponseHeaderTimeout is the time to wait for an upstream's response headers.
+	// Keep this larger than the slowest prompt processing time or leave at 0
+	// to disable it.
+	ResponseHeaderTimeout int `yaml:"responseHeaderTimeout"`
+}
+
+// Validate checks that no negative values were configured
+func (t TransportConfig) Validate() error {
+	fields := []struct {
+		name  string
+		value int
+	}{
+		{"dialTimeout", t.DialTimeout},
+		{"keepAlive", t.KeepAlive},
+		{"maxIdleConns", t.MaxIdleConns},
+		{"maxIdleConnsPerHost", t.MaxIdleConnsPerHost},
+		{"idleConnTimeout", t.IdleConnTimeout},
+		{"responseHeaderTimeout", t.ResponseHeaderTimeout},
+	}
+
+	for _, f := range fields {
+		if f.value < 0 {
+			return fmt.Errorf("transport.%s must be greater than or equal to 0", f.name)
+		}
+	}
+	return nil
+}
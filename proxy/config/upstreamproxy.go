@@ -0,0 +1,46 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// UpstreamProxyConfig sends a model's upstream connections through an HTTP
+// or SOCKS proxy, for remote backends behind a corporate proxy or an SSH
+// tunnel. Without it the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment
+// variables apply.
+type UpstreamProxyConfig struct {
+	// URL of the proxy: http, https, socks5 or socks5h
+	URL string `yaml:"url"`
+
+	// NoProxy lists hosts, domains and CIDRs reached directly, comma
+	// separated as in NO_PROXY. Loopback addresses are always reached directly.
+	NoProxy string `yaml:"noProxy"`
+}
+
+// Enabled reports if the model connects through the proxy
+func (u UpstreamProxyConfig) Enabled() bool {
+	return u.URL != ""
+}
+
+func (u UpstreamProxyConfig) validate() error {
+	if u.URL == "" {
+		if u.NoProxy != "" {
+			return fmt.Errorf("noProxy requires url")
+		}
+		return nil
+	}
+	parsed, err := url.Parse(u.URL)
+	if err != nil {
+		return fmt.Errorf("invalid url '%s': %v", u.URL, err)
+	}
+	switch parsed.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return fmt.Errorf("invalid url '%s', scheme must be http, https, socks5 or socks5h", u.URL)
+	}
+	if parsed.Host == "" {
+		return fmt.Errorf("invalid url '%s', host is required", u.URL)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// UsageExportConfig periodically writes anonymized aggregate usage to a file
+// or URL, for capacity planning across a fleet. Reports hold request counts,
+// token totals and latency percentiles per model, never prompts, responses,
+// clients or tenants.
+type UsageExportConfig struct {
+	// File the reports are appended to as JSON lines
+	File string `yaml:"file"`
+
+	// URL the reports are posted to
+	URL string `yaml:"url"`
+
+	// Headers are added to every post, e.g. Authorization
+	Headers map[string]string `yaml:"headers"`
+
+	// Interval in seconds between reports, 0 is 3600
+	Interval int `yaml:"interval"`
+
+	// HashModels replaces model IDs with a hash of them
+	HashModels bool `yaml:"hashModels"`
+
+	// Salt is mixed into the model hashes so they can not be matched with
+	// the hashes of known model names
+	Salt string `yaml:"salt"`
+
+	// Instance names this server in reports, empty leaves it out
+	Instance string `yaml:"instance"`
+}
+
+// Enabled reports if usage is exported
+func (u UsageExportConfig) Enabled() bool {
+	return u.File != "" || u.URL != ""
+}
+
+// IntervalDuration returns the time between reports
+func (u UsageExportConfig) IntervalDuration() time.Duration {
+	if u.Interval > 0 {
+		return time.Duration(u.Interval) * time.Second
+	}
+	return time.Hour
+}
+
+func (u UsageExportConfig) Validate() error {
+	if u.URL != "" {
+		parsed, err := url.Parse(u.URL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("usageExport.url '%s' must be an http or https URL", u.URL)
+		}
+	}
+	if u.Interval < 0 {
+		return fmt.Errorf("usageExport.interval must be greater than or equal to 0")
+	}
+	if u.Salt != "" && !u.HashModels {
+		return fmt.Errorf("usageExport.salt requires hashModels")
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// WasmFilterConfig is a sandboxed WASM filter of a model, see
+// ModelConfig.WasmFilters. A filter is a proxy-wasm module, llmsnap calls
+// proxy_on_request_body with the JSON request and proxy_on_response_body
+// with each complete JSON response or chat completion event. The filter
+// reads and replaces the body with proxy_get_buffer_bytes and
+// proxy_set_buffer_bytes, and can answer a request itself with
+// proxy_send_local_response.
+type WasmFilterConfig struct {
+	// File is the path of the .wasm module
+	File string `yaml:"file"`
+
+	// Config is the plugin configuration the filter gets in
+	// proxy_on_configure, a string as it is and other values as JSON
+	Config any `yaml:"config"`
+
+	// Timeout in milliseconds of a call of the filter, 0 is 100
+	Timeout int `yaml:"timeout"`
+}
+
+// Configuration returns the plugin configuration of the filter
+func (w WasmFilterConfig) Configuration() ([]byte, error) {
+	switch config := w.Config.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []byte(config), nil
+	default:
+		return json.Marshal(config)
+	}
+}
+
+// TimeoutDuration returns how long a call of the filter may run
+func (w WasmFilterConfig) TimeoutDuration() time.Duration {
+	if w.Timeout > 0 {
+		return time.Duration(w.Timeout) * time.Millisecond
+	}
+	return 100 * time.Millisecond
+}
+
+func (w WasmFilterConfig) validate() error {
+	if w.File == "" {
+		return fmt.Errorf("file is required")
+	}
+	if w.Timeout < 0 {
+		return fmt.Errorf("timeout must be non-negative, got %d", w.Timeout)
+	}
+	if _, err := w.Configuration(); err != nil {
+		return fmt.Errorf("config: %v", err)
+	}
+
+	f, err := os.Open(w.File)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(f, magic); err != nil || !bytes.Equal(magic, []byte("\x00asm")) {
+		return fmt.Errorf("%s is not a WASM module", w.File)
+	}
+	return nil
+}
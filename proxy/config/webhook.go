@@ -0,0 +1,49 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// WebhookConfig posts a summary of every completed request of a model to an
+// external URL, in the background, for analytics pipelines
+type WebhookConfig struct {
+	// URL the summaries are posted to, empty disables the webhook
+	URL string `yaml:"url"`
+
+	// Bodies adds the request and response bodies when captureBuffer is on
+	Bodies bool `yaml:"bodies"`
+
+	// Headers are added to every post, e.g. Authorization
+	Headers map[string]string `yaml:"headers"`
+
+	// Timeout in seconds of a post, 0 is 10
+	Timeout int `yaml:"timeout"`
+}
+
+// Enabled reports if requests are posted to the webhook
+func (w WebhookConfig) Enabled() bool {
+	return w.URL != ""
+}
+
+// TimeoutDuration returns how long a post may take
+func (w WebhookConfig) TimeoutDuration() time.Duration {
+	if w.Timeout > 0 {
+		return time.Duration(w.Timeout) * time.Second
+	}
+	return 10 * time.Second
+}
+
+func (w WebhookConfig) validate() error {
+	if w.URL != "" {
+		u, err := url.Parse(w.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid url '%s', must be an http or https URL", w.URL)
+		}
+	}
+	if w.Timeout < 0 {
+		return fmt.Errorf("timeout must be non-negative, got %d", w.Timeout)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Models returns every model of the config with its state, like /api/events
+// sends to the UI
+func (pm *ProxyManager) Models() []Model {
+	return pm.getModelStatus()
+}
+
+// RunningModels returns the loaded models, like /running
+func (pm *ProxyManager) RunningModels() []RunningModel {
+	return pm.runningModels()
+}
+
+// Metrics returns the recorded requests, oldest first
+func (pm *ProxyManager) Metrics() []TokenMetrics {
+	return pm.metricsMonitor.getMetrics()
+}
+
+// LoadModel loads or wakes model, a model ID or alias, and swaps out the
+// models its group does not run together with it. It returns once the model
+// is ready.
+func (pm *ProxyManager) LoadModel(model string) error {
+	_, err := pm.loadModel(model)
+	return err
+}
+
+// UnloadModel stops model, a model ID or alias
+func (pm *ProxyManager) UnloadModel(model string) error {
+	_, err := pm.unloadModel(model)
+	return err
+}
+
+// SleepModel puts model, a model ID or alias, to sleep. It must have
+// sleepMode enabled.
+func (pm *ProxyManager) SleepModel(model string) error {
+	modelID, found := pm.realModelName(model)
+	if !found {
+		return fmt.Errorf("model not found: %s", model)
+	}
+	processGroup := pm.findGroupByModelName(modelID)
+	if processGroup == nil {
+		return fmt.Errorf("process group not found for model %s", modelID)
+	}
+	return processGroup.SleepProcess(modelID)
+}
+
+// loadModel loads model and returns its ID
+func (pm *ProxyManager) loadModel(model string) (string, error) {
+	modelID, found := pm.realModelName(model)
+	if !found {
+		return "", fmt.Errorf("model not found: %s", model)
+	}
+	if err := pm.checkDrain(); err != nil {
+		return "", err
+	}
+	if err := pm.checkQuarantine(modelID); err != nil {
+		return "", err
+	}
+	if err := pm.leaseGroup(modelID); err != nil {
+		return "", err
+	}
+	processGroup, err := pm.swapProcessGroup(modelID)
+	if err != nil {
+		return "", err
+	}
+	req, _ := http.NewRequest("GET", "/", nil)
+	processGroup.ProxyRequest(modelID, &DiscardWriter{}, req)
+	if state := processGroup.processes[modelID].CurrentState(); state != StateReady {
+		return "", fmt.Errorf("model %s did not become ready, state: %s", modelID, state)
+	}
+	return modelID, nil
+}
+
+// unloadModel stops model and returns its ID
+func (pm *ProxyManager) unloadModel(model string) (string, error) {
+	modelID, found := pm.realModelName(model)
+	if !found {
+		return "", fmt.Errorf("model not found: %s", model)
+	}
+	processGroup := pm.findGroupByModelName(modelID)
+	if processGroup == nil {
+		return "", fmt.Errorf("process group not found for model %s", modelID)
+	}
+	if err := processGroup.StopProcess(modelID, StopImmediately); err != nil {
+		return "", err
+	}
+	return modelID, nil
+}
@@ -0,0 +1,172 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/napmany/llmsnap/proxy/config"
+)
+
+// CrashLoopInfo is why and since when a process is in StateFailed
+type CrashLoopInfo struct {
+	Since  time.Time `json:"since"`
+	Reason string    `json:"reason"`
+}
+
+// crashBackoffError is returned by start() while a process that failed may
+// not start yet
+type crashBackoffError struct {
+	retryIn time.Duration
+	err     error
+}
+
+func (e *crashBackoffError) Error() string {
+	return fmt.Sprintf("restart delayed for %s after a failure: %v", e.retryIn.Round(time.Second), e.err)
+}
+
+// retryAfter is the Retry-After header value for a backoff, in whole seconds
+func (e *crashBackoffError) retryAfter() string {
+	return fmt.Sprintf("%d", int(math.Ceil(e.retryIn.Seconds())))
+}
+
+// errCrashLoop is returned by start() for a process in StateFailed
+var errCrashLoop = errors.New("process failed too many times, reset it to start it again")
+
+// crashLoop counts the failures of a process, delays its restarts and
+// marks it failed after too many, see config.CrashLoopConfig
+type crashLoop struct {
+	sync.Mutex
+	config config.CrashLoopConfig
+
+	// failure times within the window
+	failures []time.Time
+	lastErr  error
+	retryAt  time.Time
+
+	// set once the process failed too many times
+	failed *CrashLoopInfo
+}
+
+func newCrashLoop(conf config.CrashLoopConfig) *crashLoop {
+	return &crashLoop{config: conf}
+}
+
+// recordFailure counts a failure at now and returns true when it makes the
+// process failed
+func (c *crashLoop) recordFailure(err error, now time.Time) bool {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.failed != nil {
+		return false
+	}
+
+	cutoff := now.Add(-c.config.WindowDuration())
+	failures := []time.Time{}
+	for _, failure := range c.failures {
+		if failure.After(cutoff) {
+			failures = append(failures, failure)
+		}
+	}
+	c.failures = append(failures, now)
+	c.lastErr = err
+
+	if len(c.failures) >= c.config.MaxRestarts {
+		c.failed = &CrashLoopInfo{
+			Since:  now,
+			Reason: fmt.Sprintf("%d failures within %s, last: %v", len(c.failures), c.config.WindowDuration(), err),
+		}
+		return true
+	}
+	c.retryAt = now.Add(c.config.BackoffDuration(len(c.failures)))
+	return false
+}
+
+// checkStart returns errCrashLoop when the process failed and a
+// *crashBackoffError while it has to wait before it starts again
+func (c *crashLoop) checkStart(now time.Time) error {
+	if c == nil {
+		return nil
+	}
+	c.Lock()
+	defer c.Unlock()
+
+	if c.failed != nil {
+		return errCrashLoop
+	}
+	if now.Before(c.retryAt) {
+		return &crashBackoffError{retryIn: c.retryAt.Sub(now), err: c.lastErr}
+	}
+	return nil
+}
+
+// info returns why the process failed, found is false when it has not
+func (c *crashLoop) info() (info CrashLoopInfo, found bool) {
+	if c == nil {
+		return CrashLoopInfo{}, false
+	}
+	c.Lock()
+	defer c.Unlock()
+	if c.failed == nil {
+		return CrashLoopInfo{}, false
+	}
+	return *c.failed, true
+}
+
+// reset forgets the failures
+func (c *crashLoop) reset() {
+	if c == nil {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	c.failures = nil
+	c.lastErr = nil
+	c.retryAt = time.Time{}
+	c.failed = nil
+}
+
+// resetCrashLoop forgets the failures of the process and takes it out of
+// StateFailed, it returns false when it was not failed
+func (p *Process) resetCrashLoop() bool {
+	_, failed := p.crashLoop.info()
+	p.crashLoop.reset()
+	if p.CurrentState() == StateFailed {
+		p.swapState(StateFailed, StateStopped)
+		return true
+	}
+	return failed
+}
+
+// apiResetModelHandler takes every instance of a model, or one instance by
+// its process ID like model#2, out of StateFailed so the next request starts
+// it again
+func (pm *ProxyManager) apiResetModelHandler(c *gin.Context) {
+	requested := strings.TrimPrefix(c.Param("model"), "/")
+
+	var processes []*Process
+	if realModelName, found := pm.realModelName(requested); found {
+		if processGroup := pm.findGroupByModelName(realModelName); processGroup != nil {
+			processes = processGroup.instancesOf(realModelName)
+		}
+	} else if process, found := pm.findProcess(requested); found {
+		processes = []*Process{process}
+	}
+	if len(processes) == 0 {
+		pm.sendErrorResponse(c, http.StatusNotFound, "Model not found")
+		return
+	}
+
+	for _, process := range processes {
+		if process.resetCrashLoop() {
+			pm.proxyLogger.Infof("<%s> reset, it can start again", process.ID)
+		}
+	}
+	c.String(http.StatusOK, "OK")
+}
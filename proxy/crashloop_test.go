@@ -0,0 +1,116 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCrashLoop_Backoff(t *testing.T) {
+	crashes := newCrashLoop(config.CrashLoopConfig{MaxRestarts: 4, Window: 60, Backoff: 2, MaxBackoff: 5})
+	start := time.Now()
+	failure := errors.New("exit status 1")
+
+	assert.False(t, crashes.recordFailure(failure, start))
+	var backoff *crashBackoffError
+	require.ErrorAs(t, crashes.checkStart(start.Add(time.Second)), &backoff)
+	assert.Equal(t, time.Second, backoff.retryIn)
+	assert.Equal(t, "1", backoff.retryAfter())
+	assert.NoError(t, crashes.checkStart(start.Add(2*time.Second)))
+
+	// the backoff doubles up to maxBackoff
+	assert.False(t, crashes.recordFailure(failure, start.Add(2*time.Second)))
+	assert.NoError(t, crashes.checkStart(start.Add(6*time.Second)))
+	assert.False(t, crashes.recordFailure(failure, start.Add(6*time.Second)))
+	require.ErrorAs(t, crashes.checkStart(start.Add(6*time.Second)), &backoff)
+	assert.Equal(t, 5*time.Second, backoff.retryIn)
+
+	// failures out of the window are forgotten
+	assert.False(t, crashes.recordFailure(failure, start.Add(63*time.Second)))
+	assert.False(t, crashes.recordFailure(failure, start.Add(64*time.Second)))
+	assert.True(t, crashes.recordFailure(failure, start.Add(65*time.Second)))
+	assert.ErrorIs(t, crashes.checkStart(start.Add(time.Hour)), errCrashLoop)
+
+	info, found := crashes.info()
+	assert.True(t, found)
+	assert.Equal(t, "4 failures within 1m0s, last: exit status 1", info.Reason)
+
+	crashes.reset()
+	assert.NoError(t, crashes.checkStart(start.Add(70*time.Second)))
+	_, found = crashes.info()
+	assert.False(t, found)
+}
+
+func TestProxyManager_CrashLoop(t *testing.T) {
+	modelConfig := getTestSimpleResponderConfig("model1")
+	modelConfig.CrashLoop = config.CrashLoopConfig{MaxRestarts: 2, Backoff: 30}
+	conf := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Models:             map[string]config.ModelConfig{"model1": modelConfig},
+		LogLevel:           "error",
+	})
+
+	proxy := New(conf)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	// every start fails like a command that exits right away
+	process := proxy.processGroups[config.DEFAULT_GROUP_ID].processes["model1"]
+	chaos := newChaosInjector(config.ChaosConfig{Active: true, FailStartRate: 1})
+	process.setHooks(&processHooks{chaos: chaos})
+
+	send := func(method, path, body string) *TestResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		return w
+	}
+	chat := func() *TestResponseRecorder {
+		return send("POST", "/v1/chat/completions", `{"model":"model1"}`)
+	}
+
+	w := chat()
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+
+	// the restart waits for the backoff
+	w = chat()
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "30", w.Header().Get("Retry-After"))
+	assert.Contains(t, w.Body.String(), "restart delayed")
+
+	process.crashLoop.Lock()
+	process.crashLoop.retryAt = time.Time{}
+	process.crashLoop.Unlock()
+
+	// the second failure is too many
+	w = chat()
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+	assert.Equal(t, StateFailed, process.CurrentState())
+
+	w = chat()
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), "POST /api/models/reset/model1")
+
+	status := proxy.getModelStatus()[0]
+	assert.Equal(t, "failed", status.State)
+	if assert.NotNil(t, status.Failure) {
+		assert.Contains(t, status.Failure.Reason, "2 failures within 5m0s")
+	}
+
+	// reset takes it out of the failed state
+	chaos.config.FailStartRate = 0
+	assert.Equal(t, http.StatusNotFound, send("POST", "/api/models/reset/nope", "").Code)
+	assert.Equal(t, http.StatusOK, send("POST", "/api/models/reset/model1", "").Code)
+	assert.Equal(t, StateStopped, process.CurrentState())
+	assert.Nil(t, proxy.getModelStatus()[0].Failure)
+
+	w = chat()
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, StateReady, process.CurrentState())
+}
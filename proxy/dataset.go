@@ -0,0 +1,287 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	defaultDatasetMaxSizeMB = 100
+	defaultDatasetMaxFiles  = 10
+
+	// responses larger than this are not recorded
+	datasetMaxResponseBytes = 16 * 1024 * 1024
+)
+
+var datasetFileNameUnsafe = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// datasetWriter appends prompt and response pairs to one JSONL file per
+// model, in the format used for fine-tuning chat models:
+//
+//	{"messages": [..., {"role": "assistant", "content": "..."}], "tools": [...]}
+//
+// Completions are written as {"prompt": "...", "completion": "..."}.
+type datasetWriter struct {
+	mu       sync.Mutex
+	dir      string
+	maxBytes int64
+	maxFiles int
+
+	// records are scrubbed before they are written, may be nil
+	scrubber *scrubber
+	logger   *LogMonitor
+}
+
+func newDatasetWriter(conf config.DatasetConfig, scrubber *scrubber, logger *LogMonitor) *datasetWriter {
+	maxSizeMB := conf.MaxSizeMB
+	if maxSizeMB == 0 {
+		maxSizeMB = defaultDatasetMaxSizeMB
+	}
+	maxFiles := conf.MaxFiles
+	if maxFiles == 0 {
+		maxFiles = defaultDatasetMaxFiles
+	}
+	return &datasetWriter{
+		dir:      conf.Dir,
+		maxBytes: int64(maxSizeMB) * 1024 * 1024,
+		maxFiles: maxFiles,
+		scrubber: scrubber,
+		logger:   logger,
+	}
+}
+
+// datasetFileName returns the base name of a model's dataset files
+func datasetFileName(modelID string) string {
+	return datasetFileNameUnsafe.ReplaceAllString(modelID, "_")
+}
+
+// append writes a record to the model's file, rotating it when it is full
+func (dw *datasetWriter) append(modelID string, record []byte) error {
+	record = dw.scrubber.scrub(record)
+
+	dw.mu.Lock()
+	defer dw.mu.Unlock()
+
+	if err := os.MkdirAll(dw.dir, 0o755); err != nil {
+		return err
+	}
+
+	name := datasetFileName(modelID)
+	path := filepath.Join(dw.dir, name+".jsonl")
+	if info, err := os.Stat(path); err == nil && info.Size() > 0 && info.Size()+int64(len(record)) > dw.maxBytes {
+		if err := dw.rotate(name, path); err != nil {
+			return err
+		}
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(record, '\n'))
+	return err
+}
+
+// rotate renames the current file and removes the oldest rotated files
+func (dw *datasetWriter) rotate(name, path string) error {
+	// the timestamp keeps rotated files in order, it is bumped when files
+	// are rotated faster than the clock ticks
+	stamp := time.Now().UTC()
+	rotated := ""
+	for {
+		rotated = filepath.Join(dw.dir, name+"-"+stamp.Format("20060102T150405.000000000")+".jsonl")
+		if _, err := os.Stat(rotated); os.IsNotExist(err) {
+			break
+		}
+		stamp = stamp.Add(time.Nanosecond)
+	}
+	if err := os.Rename(path, rotated); err != nil {
+		return err
+	}
+
+	matches, err := filepath.Glob(filepath.Join(dw.dir, name+"-*.jsonl"))
+	if err != nil {
+		return err
+	}
+	// the timestamps sort oldest first
+	sort.Strings(matches)
+	for len(matches) > dw.maxFiles {
+		if err := os.Remove(matches[0]); err != nil {
+			return err
+		}
+		matches = matches[1:]
+	}
+	return nil
+}
+
+// wrapHandler records successful responses of next to the model's dataset
+func (dw *datasetWriter) wrapHandler(
+	modelID string,
+	path string,
+	reqBody []byte,
+	next func(modelID string, w http.ResponseWriter, r *http.Request) error,
+) func(modelID string, w http.ResponseWriter, r *http.Request) error {
+	return func(modelID string, w http.ResponseWriter, r *http.Request) error {
+		recorder := &cacheRecorder{ResponseWriter: w, limit: datasetMaxResponseBytes}
+		if err := next(modelID, recorder, r); err != nil {
+			return err
+		}
+		if (recorder.status != 0 && recorder.status != http.StatusOK) || recorder.overflow {
+			return nil
+		}
+
+		body, err := decompressBody(recorder.body.Bytes(), w.Header().Get("Content-Encoding"))
+		if err != nil {
+			dw.logger.Warnf("<%s> dataset: %v", modelID, err)
+			return nil
+		}
+		streaming := strings.Contains(w.Header().Get("Content-Type"), "text/event-stream")
+
+		record := datasetRecord(path, reqBody, body, streaming)
+		if record == nil {
+			return nil
+		}
+		if err := dw.append(modelID, record); err != nil {
+			dw.logger.Errorf("<%s> dataset: could not write record: %v", modelID, err)
+		}
+		return nil
+	}
+}
+
+// datasetRecord builds the record for a request and its response, or nil
+// for endpoints that are not recorded
+func datasetRecord(path string, reqBody, respBody []byte, streaming bool) []byte {
+	var record map[string]any
+
+	switch path {
+	case "/v1/chat/completions":
+		messages := gjson.GetBytes(reqBody, "messages")
+		if !messages.IsArray() {
+			return nil
+		}
+
+		var reply map[string]any
+		if streaming {
+			reply = assembleStreamedMessage(respBody)
+		} else {
+			message := gjson.GetBytes(respBody, "choices.0.message")
+			if !message.Exists() {
+				return nil
+			}
+			reply = map[string]any{"role": "assistant", "content": message.Get("content").Value()}
+			if calls := message.Get("tool_calls"); calls.IsArray() && len(calls.Array()) > 0 {
+				reply["tool_calls"] = json.RawMessage(calls.Raw)
+			}
+		}
+
+		var history []json.RawMessage
+		if err := json.Unmarshal([]byte(messages.Raw), &history); err != nil {
+			return nil
+		}
+		replyJSON, err := json.Marshal(reply)
+		if err != nil {
+			return nil
+		}
+		record = map[string]any{"messages": append(history, replyJSON)}
+		if tools := gjson.GetBytes(reqBody, "tools"); tools.IsArray() {
+			record["tools"] = json.RawMessage(tools.Raw)
+		}
+
+	case "/v1/completions":
+		prompt := gjson.GetBytes(reqBody, "prompt")
+		if prompt.Type != gjson.String {
+			return nil
+		}
+		var completion string
+		if streaming {
+			forEachStreamEvent(respBody, func(event gjson.Result) {
+				completion += event.Get("choices.0.text").String()
+			})
+		} else {
+			completion = gjson.GetBytes(respBody, "choices.0.text").String()
+		}
+		record = map[string]any{"prompt": prompt.String(), "completion": completion}
+
+	default:
+		return nil
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// assembleStreamedMessage rebuilds the assistant message of the first
+// choice from the deltas of a chat completion stream
+func assembleStreamedMessage(body []byte) map[string]any {
+	var content strings.Builder
+	type toolCall struct {
+		ID        string `json:"id,omitempty"`
+		Type      string `json:"type"`
+		Name      string `json:"-"`
+		Arguments string `json:"-"`
+		Function  any    `json:"function"`
+	}
+	calls := make(map[int64]*toolCall)
+
+	forEachStreamEvent(body, func(event gjson.Result) {
+		delta := event.Get("choices.0.delta")
+		content.WriteString(delta.Get("content").String())
+		for _, call := range delta.Get("tool_calls").Array() {
+			index := call.Get("index").Int()
+			tc, ok := calls[index]
+			if !ok {
+				tc = &toolCall{Type: "function"}
+				calls[index] = tc
+			}
+			if id := call.Get("id").String(); id != "" {
+				tc.ID = id
+			}
+			tc.Name += call.Get("function.name").String()
+			tc.Arguments += call.Get("function.arguments").String()
+		}
+	})
+
+	reply := map[string]any{"role": "assistant", "content": content.String()}
+	if len(calls) > 0 {
+		indexes := make([]int64, 0, len(calls))
+		for index := range calls {
+			indexes = append(indexes, index)
+		}
+		sort.Slice(indexes, func(i, j int) bool { return indexes[i] < indexes[j] })
+
+		toolCalls := make([]*toolCall, 0, len(calls))
+		for _, index := range indexes {
+			tc := calls[index]
+			tc.Function = map[string]string{"name": tc.Name, "arguments": tc.Arguments}
+			toolCalls = append(toolCalls, tc)
+		}
+		reply["tool_calls"] = toolCalls
+	}
+	return reply
+}
+
+// forEachStreamEvent calls fn with the JSON data of every event of a stream
+func forEachStreamEvent(body []byte, fn func(event gjson.Result)) {
+	forEachSSEEvent(body, func(event sseEvent) {
+		payload := bytes.TrimSpace(event.data)
+		if gjson.ValidBytes(payload) {
+			fn(gjson.ParseBytes(payload))
+		}
+	})
+}
@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+func TestDatasetRecord(t *testing.T) {
+	chatRequest := []byte(`{"model":"m","messages":[{"role":"user","content":"weather?"}],"tools":[{"type":"function","function":{"name":"get_weather"}}]}`)
+
+	t.Run("chat completion", func(t *testing.T) {
+		response := []byte(`{"choices":[{"message":{"role":"assistant","content":"sunny","reasoning_content":"hmm"}}]}`)
+		record := datasetRecord("/v1/chat/completions", chatRequest, response, false)
+		assert.JSONEq(t, `{
+			"messages": [{"role":"user","content":"weather?"},{"role":"assistant","content":"sunny"}],
+			"tools": [{"type":"function","function":{"name":"get_weather"}}]
+		}`, string(record))
+	})
+
+	t.Run("streamed tool call", func(t *testing.T) {
+		stream := strings.Join([]string{
+			`data: {"choices":[{"index":0,"delta":{"role":"assistant","content":null}}]}`,
+			`data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"c1","type":"function","function":{"name":"get_weather","arguments":"{\"city\":"}}]}}]}`,
+			`data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"Paris\"}"}}]}}]}`,
+			`data: {"choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}`,
+			`data: [DONE]`,
+		}, "\n\n")
+		record := datasetRecord("/v1/chat/completions", chatRequest, []byte(stream), true)
+		reply := gjson.GetBytes(record, "messages.1")
+		assert.Equal(t, "", reply.Get("content").String())
+		assert.JSONEq(t, `[{"id":"c1","type":"function","function":{"name":"get_weather","arguments":"{\"city\":\"Paris\"}"}}]`, reply.Get("tool_calls").Raw)
+	})
+
+	t.Run("completion", func(t *testing.T) {
+		record := datasetRecord("/v1/completions", []byte(`{"prompt":"1+1="}`), []byte(`{"choices":[{"text":"2"}]}`), false)
+		assert.JSONEq(t, `{"prompt":"1+1=","completion":"2"}`, string(record))
+	})
+
+	t.Run("other endpoints are not recorded", func(t *testing.T) {
+		assert.Nil(t, datasetRecord("/v1/embeddings", []byte(`{"input":"x"}`), []byte(`{}`), false))
+	})
+}
+
+func TestDatasetWriter_Rotate(t *testing.T) {
+	dir := t.TempDir()
+	dw := newDatasetWriter(config.DatasetConfig{Dir: dir, MaxFiles: 2}, nil, testLogger)
+	dw.maxBytes = 100
+
+	record := []byte(`{"prompt":"` + strings.Repeat("x", 60) + `"}`)
+	for range 5 {
+		assert.NoError(t, dw.append("org/model:q4", record))
+	}
+
+	current, err := os.ReadFile(filepath.Join(dir, "org_model_q4.jsonl"))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, bytes.Count(current, []byte("\n")))
+
+	rotated, _ := filepath.Glob(filepath.Join(dir, "org_model_q4-*.jsonl"))
+	assert.Len(t, rotated, 2)
+}
+
+func TestProxyManager_CaptureDataset(t *testing.T) {
+	dir := t.TempDir()
+	model1 := getTestSimpleResponderConfig("model1")
+	model1.CaptureDataset = true
+
+	conf := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]config.ModelConfig{
+			"model1": model1,
+			"model2": getTestSimpleResponderConfig("model2"),
+		},
+		Datasets: config.DatasetConfig{Dir: dir},
+		Scrub:    config.ScrubConfig{Detectors: []string{"email"}},
+		LogLevel: "error",
+	})
+
+	proxy := New(conf)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	for _, model := range []string{"model1", "model2"} {
+		body := `{"model":"` + model + `","stream":true,"messages":[{"role":"user","content":"I am jane@example.com"}]}`
+		req := httptest.NewRequest("POST", "/v1/chat/completions?stream=true", bytes.NewBufferString(body))
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "model1.jsonl"))
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if assert.Len(t, lines, 1) {
+		assert.Equal(t, "I am [EMAIL]", gjson.Get(lines[0], "messages.0.content").String())
+		assert.Equal(t, strings.Repeat("asdf", 10), gjson.Get(lines[0], "messages.1.content").String())
+	}
+
+	_, err = os.Stat(filepath.Join(dir, "model2.jsonl"))
+	assert.True(t, os.IsNotExist(err), "model2 did not opt in")
+}
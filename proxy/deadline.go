@@ -0,0 +1,114 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// absolute deadline as an RFC 3339 timestamp or unix milliseconds
+	requestDeadlineHeader = "X-Request-Deadline"
+
+	// relative deadline in milliseconds from when the request arrived
+	maxWaitHeader = "X-Max-Wait-Ms"
+
+	// sent with a 503 when the deadline can not be met
+	estimatedWaitHeader = "X-Estimated-Wait-Ms"
+)
+
+// observeDuration folds d into a moving average stored in avg. The first
+// observation is taken as is.
+func observeDuration(avg *atomic.Int64, d time.Duration) {
+	for {
+		old := avg.Load()
+		next := int64(d)
+		if old > 0 {
+			next = (3*old + int64(d)) / 4
+		}
+		if avg.CompareAndSwap(old, next) {
+			return
+		}
+	}
+}
+
+// expectedReadyWait guesses how long until the process can serve a request.
+// It is 0 when the process has never been started as nothing is known yet.
+func (p *Process) expectedReadyWait() time.Duration {
+	switch p.CurrentState() {
+	case StateReady:
+		return 0
+	case StateAsleep, StateWaking, StateSleepPending:
+		return time.Duration(p.wakeDuration.Load())
+	default:
+		return time.Duration(p.loadDuration.Load())
+	}
+}
+
+// requestDeadline returns the deadline a client set on the request, if any
+func requestDeadline(r *http.Request, now time.Time) (time.Time, bool, error) {
+	if value := strings.TrimSpace(r.Header.Get(requestDeadlineHeader)); value != "" {
+		if deadline, err := time.Parse(time.RFC3339Nano, value); err == nil {
+			return deadline, true, nil
+		}
+		if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return time.UnixMilli(ms), true, nil
+		}
+		return time.Time{}, false, fmt.Errorf("invalid %s header, expected RFC 3339 or unix milliseconds: %q", requestDeadlineHeader, value)
+	}
+
+	if value := strings.TrimSpace(r.Header.Get(maxWaitHeader)); value != "" {
+		ms, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || ms < 0 {
+			return time.Time{}, false, fmt.Errorf("invalid %s header, expected milliseconds: %q", maxWaitHeader, value)
+		}
+		return now.Add(time.Duration(ms) * time.Millisecond), true, nil
+	}
+
+	return time.Time{}, false, nil
+}
+
+// estimatedWait guesses how long a request for modelID waits before the
+// upstream starts working on it: queueing in the scheduler plus loading
+func (pm *ProxyManager) estimatedWait(modelID string) time.Duration {
+	var wait time.Duration
+	if pm.scheduler != nil {
+		wait += pm.scheduler.estimatedWait()
+	}
+	if processGroup := pm.findGroupByModelName(modelID); processGroup != nil {
+		if process, found := processGroup.processes[modelID]; found {
+			wait += process.expectedReadyWait()
+		}
+	}
+	return wait
+}
+
+// rejectPastDeadline sends a 503 and returns true when the client's deadline
+// can not be met, so the client can retry elsewhere instead of waiting
+func (pm *ProxyManager) rejectPastDeadline(c *gin.Context, modelID string) bool {
+	now := time.Now()
+	deadline, found, err := requestDeadline(c.Request, now)
+	if err != nil {
+		pm.sendErrorResponse(c, http.StatusBadRequest, err.Error())
+		return true
+	}
+	if !found {
+		return false
+	}
+
+	wait := pm.estimatedWait(modelID)
+	if !now.Add(wait).After(deadline) {
+		return false
+	}
+
+	waitMs := wait.Milliseconds()
+	c.Header(estimatedWaitHeader, strconv.FormatInt(waitMs, 10))
+	pm.sendErrorResponse(c, http.StatusServiceUnavailable,
+		fmt.Sprintf("request deadline can not be met for %s, estimated wait %dms", modelID, waitMs))
+	return true
+}
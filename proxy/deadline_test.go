@@ -0,0 +1,117 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestDeadline(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		header   string
+		value    string
+		expected time.Time
+		found    bool
+		wantErr  bool
+	}{
+		{"none", "", "", time.Time{}, false, false},
+		{"rfc3339", requestDeadlineHeader, "2025-01-01T12:00:05Z", now.Add(5 * time.Second), true, false},
+		{"unix millis", requestDeadlineHeader, "1735732805000", now.Add(5 * time.Second), true, false},
+		{"max wait", maxWaitHeader, "1500", now.Add(1500 * time.Millisecond), true, false},
+		{"invalid deadline", requestDeadlineHeader, "soon", time.Time{}, false, true},
+		{"negative max wait", maxWaitHeader, "-1", time.Time{}, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/v1/chat/completions", nil)
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			deadline, found, err := requestDeadline(req, now)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.found, found)
+			assert.True(t, tt.expected.Equal(deadline), "expected %v, got %v", tt.expected, deadline)
+		})
+	}
+}
+
+func TestObserveDuration(t *testing.T) {
+	var avg atomic.Int64
+	observeDuration(&avg, 8*time.Second)
+	assert.Equal(t, 8*time.Second, time.Duration(avg.Load()))
+	observeDuration(&avg, 4*time.Second)
+	assert.Equal(t, 7*time.Second, time.Duration(avg.Load()))
+}
+
+func TestRequestScheduler_EstimatedWait(t *testing.T) {
+	s := newRequestScheduler(2)
+	s.holdDuration.Store(int64(4 * time.Second))
+	assert.Equal(t, time.Duration(0), s.estimatedWait())
+
+	s.running = 2
+	assert.Equal(t, 2*time.Second, s.estimatedWait())
+}
+
+func TestProxyManager_RequestDeadline(t *testing.T) {
+	conf := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]config.ModelConfig{
+			"model1": getTestSimpleResponderConfig("model1"),
+		},
+		LogLevel: "error",
+	})
+
+	proxy := New(conf)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	process, _ := proxy.processGroups[config.DEFAULT_GROUP_ID].GetMember("model1")
+	process.loadDuration.Store(int64(10 * time.Second))
+
+	doRequest := func(header, value string) *TestResponseRecorder {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model":"model1"}`))
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("rejects when the load takes longer than the deadline", func(t *testing.T) {
+		w := doRequest(maxWaitHeader, "500")
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.Equal(t, "10000", w.Header().Get(estimatedWaitHeader))
+		assert.Contains(t, w.Body.String(), "estimated wait 10000ms")
+		assert.Equal(t, StateStopped, process.CurrentState())
+	})
+
+	t.Run("invalid header", func(t *testing.T) {
+		w := doRequest(requestDeadlineHeader, "soon")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("proceeds without a deadline", func(t *testing.T) {
+		w := doRequest("", "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, StateReady, process.CurrentState())
+	})
+
+	t.Run("proceeds once the model is loaded", func(t *testing.T) {
+		w := doRequest(maxWaitHeader, "500")
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+}
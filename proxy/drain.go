@@ -0,0 +1,213 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// default seconds a drain waits for the requests in flight
+const defaultDrainTimeout = 300
+
+// what a drain does with the models once their requests are done
+const (
+	drainStop  = "stop"
+	drainSleep = "sleep"
+)
+
+// drainState is a drain started with POST /api/drain. New requests for local
+// models are refused, or sent to a peer that serves the model, and once the
+// requests in flight are done or the deadline passed every model is stopped
+// or put to sleep. It lasts until DELETE /api/drain.
+type drainState struct {
+	DrainInfo
+
+	// set once the models are stopped or asleep
+	idled atomic.Bool
+}
+
+// DrainInfo is when and how a drain was started
+type DrainInfo struct {
+	Since    time.Time `json:"since"`
+	Deadline time.Time `json:"deadline"`
+	Action   string    `json:"action"`
+	Peers    bool      `json:"peers"`
+}
+
+// DrainStatus is the response of /api/drain
+type DrainStatus struct {
+	Draining bool       `json:"draining"`
+	Drain    *DrainInfo `json:"drain,omitempty"`
+
+	// requests in flight to local models
+	InFlight int `json:"inFlight"`
+
+	// true once the models are stopped or asleep
+	Idled bool `json:"idled"`
+}
+
+func (pm *ProxyManager) drainStatus() DrainStatus {
+	status := DrainStatus{InFlight: pm.inFlightTotal()}
+	if drain := pm.drain.Load(); drain != nil {
+		status.Draining = true
+		status.Drain = &drain.DrainInfo
+		status.Idled = drain.idled.Load()
+	}
+	return status
+}
+
+// inFlightTotal counts the requests in flight to every local process
+func (pm *ProxyManager) inFlightTotal() int {
+	total := 0
+	for _, processGroup := range pm.processGroups {
+		for _, process := range processGroup.instances() {
+			total += int(process.inFlightRequestsCount.Load())
+		}
+	}
+	return total
+}
+
+// drainsToPeer reports if a request for requestedModel goes to a peer that
+// serves it instead of the local model while draining
+func (pm *ProxyManager) drainsToPeer(requestedModel string) bool {
+	drain := pm.drain.Load()
+	return drain != nil && drain.Peers && pm.peerProxy != nil && pm.peerProxy.HasPeerModel(requestedModel)
+}
+
+// checkDrain returns an error while draining, new requests for local models
+// are refused
+func (pm *ProxyManager) checkDrain() error {
+	if drain := pm.drain.Load(); drain != nil {
+		return fmt.Errorf("llmsnap is draining since %s and does not accept new requests", drain.Since.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// startDrain starts draining, the models are idled with action once the
+// requests in flight are done or timeout passed. It returns false when a
+// drain is already running.
+func (pm *ProxyManager) startDrain(timeout time.Duration, action string, peers bool) bool {
+	now := time.Now()
+	drain := &drainState{DrainInfo: DrainInfo{Since: now, Deadline: now.Add(timeout), Action: action, Peers: peers}}
+	if !pm.drain.CompareAndSwap(nil, drain) {
+		return false
+	}
+	pm.proxyLogger.Infof("draining, waiting up to %s for %d request(s) in flight, then every model is idled with %s", timeout, pm.inFlightTotal(), action)
+	go pm.finishDrain(drain)
+	return true
+}
+
+// finishDrain waits for the requests in flight until the deadline of drain
+// and idles every model, unless the drain ended or pm shut down meanwhile
+func (pm *ProxyManager) finishDrain(drain *drainState) {
+	if drain.idled.Load() {
+		return
+	}
+
+	ctx, cancel := context.WithDeadline(pm.shutdownCtx, drain.Deadline)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, processGroup := range pm.processGroups {
+		for _, process := range processGroup.instances() {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				process.waitInFlight(ctx)
+			}()
+		}
+	}
+	wg.Wait()
+
+	if pm.shutdownCtx.Err() != nil || pm.drain.Load() != drain {
+		return
+	}
+	if inFlight := pm.inFlightTotal(); inFlight > 0 {
+		pm.proxyLogger.Warnf("drain deadline passed, %d request(s) still in flight are cut off", inFlight)
+	}
+
+	if drain.Action == drainSleep {
+		pm.sleepOrStopProcesses()
+	} else {
+		pm.StopProcesses(StopImmediately)
+	}
+	drain.idled.Store(true)
+	pm.proxyLogger.Infof("drained, all models are idle")
+}
+
+// sleepOrStopProcesses puts every model that supports sleep to sleep and
+// stops the others
+func (pm *ProxyManager) sleepOrStopProcesses() {
+	pm.Lock()
+	defer pm.Unlock()
+
+	var wg sync.WaitGroup
+	for _, processGroup := range pm.processGroups {
+		for _, process := range processGroup.instances() {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if process.isSleepEnabled() && process.CurrentState() == StateReady {
+					process.Sleep()
+				} else {
+					process.StopImmediately()
+				}
+			}()
+		}
+	}
+	wg.Wait()
+}
+
+// apiStartDrain starts draining. timeout is the seconds to wait for the
+// requests in flight, action is stop or sleep and peers=false refuses the
+// requests peers could serve too.
+func (pm *ProxyManager) apiStartDrain(c *gin.Context) {
+	timeout := defaultDrainTimeout
+	if value := c.Query("timeout"); value != "" {
+		var err error
+		if timeout, err = strconv.Atoi(value); err != nil || timeout < 0 {
+			pm.sendErrorResponse(c, http.StatusBadRequest, fmt.Sprintf("invalid timeout %q, must be seconds", value))
+			return
+		}
+	}
+
+	action := c.DefaultQuery("action", drainStop)
+	if action != drainStop && action != drainSleep {
+		pm.sendErrorResponse(c, http.StatusBadRequest, fmt.Sprintf("invalid action %q, must be stop or sleep", action))
+		return
+	}
+
+	peers := true
+	if value := c.Query("peers"); value != "" {
+		var err error
+		if peers, err = strconv.ParseBool(value); err != nil {
+			pm.sendErrorResponse(c, http.StatusBadRequest, fmt.Sprintf("invalid peers %q, must be true or false", value))
+			return
+		}
+	}
+
+	if !pm.startDrain(time.Duration(timeout)*time.Second, action, peers) {
+		c.JSON(http.StatusConflict, pm.drainStatus())
+		return
+	}
+	c.JSON(http.StatusAccepted, pm.drainStatus())
+}
+
+func (pm *ProxyManager) apiGetDrain(c *gin.Context) {
+	c.JSON(http.StatusOK, pm.drainStatus())
+}
+
+// apiStopDrain ends a drain, new requests are accepted again and idled
+// models are loaded by the next request for them
+func (pm *ProxyManager) apiStopDrain(c *gin.Context) {
+	if drain := pm.drain.Swap(nil); drain != nil {
+		pm.proxyLogger.Infof("drain ended, accepting requests")
+	}
+	c.JSON(http.StatusOK, pm.drainStatus())
+}
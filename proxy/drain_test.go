@@ -0,0 +1,143 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxyManager_Drain(t *testing.T) {
+	conf := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Models:             map[string]config.ModelConfig{"model1": getTestSimpleResponderConfig("model1")},
+		LogLevel:           "error",
+	})
+
+	proxy := New(conf)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	send := func(method, path, body string) *TestResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		return w
+	}
+	chat := func() *TestResponseRecorder {
+		return send("POST", "/v1/chat/completions", `{"model":"model1"}`)
+	}
+	status := func() DrainStatus {
+		var status DrainStatus
+		require.NoError(t, json.Unmarshal(send("GET", "/api/drain", "").Body.Bytes(), &status))
+		return status
+	}
+
+	require.Equal(t, http.StatusOK, chat().Code)
+	assert.False(t, status().Draining)
+
+	assert.Equal(t, http.StatusBadRequest, send("POST", "/api/drain?action=pause", "").Code)
+	assert.Equal(t, http.StatusBadRequest, send("POST", "/api/drain?timeout=soon", "").Code)
+
+	assert.Equal(t, http.StatusAccepted, send("POST", "/api/drain?timeout=5", "").Code)
+	assert.Equal(t, http.StatusConflict, send("POST", "/api/drain", "").Code)
+
+	w := chat()
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "30", w.Header().Get("Retry-After"))
+	assert.Contains(t, w.Body.String(), "draining")
+	assert.Equal(t, http.StatusServiceUnavailable, send("GET", "/health", "").Code)
+
+	// nothing is in flight, the models are stopped right away
+	process := proxy.processGroups[config.DEFAULT_GROUP_ID].processes["model1"]
+	assert.Eventually(t, func() bool { return status().Idled }, 5*time.Second, 50*time.Millisecond)
+	assert.Equal(t, StateStopped, process.CurrentState())
+	drain := status()
+	assert.True(t, drain.Draining)
+	assert.Equal(t, "stop", drain.Drain.Action)
+
+	assert.Equal(t, http.StatusOK, send("DELETE", "/api/drain", "").Code)
+	assert.False(t, status().Draining)
+	assert.Equal(t, http.StatusOK, send("GET", "/health", "").Code)
+	assert.Equal(t, http.StatusOK, chat().Code)
+}
+
+func TestProxyManager_DrainWaitsForInFlight(t *testing.T) {
+	conf := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Models:             map[string]config.ModelConfig{"model1": getTestSimpleResponderConfig("model1")},
+		LogLevel:           "error",
+	})
+
+	proxy := New(conf)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	done := make(chan int)
+	go func() {
+		req := httptest.NewRequest("GET", "/upstream/model1/slow-respond?echo=hi&delay=1s", nil)
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		done <- w.Code
+	}()
+
+	process := proxy.processGroups[config.DEFAULT_GROUP_ID].processes["model1"]
+	require.Eventually(t, func() bool { return process.inFlightRequestsCount.Load() == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	require.True(t, proxy.startDrain(time.Minute, drainStop, true))
+	<-time.After(200 * time.Millisecond)
+	assert.False(t, proxy.drainStatus().Idled)
+	assert.Equal(t, 1, proxy.drainStatus().InFlight)
+
+	assert.Equal(t, http.StatusOK, <-done)
+	assert.Eventually(t, func() bool { return proxy.drainStatus().Idled }, 5*time.Second, 50*time.Millisecond)
+	assert.Equal(t, StateStopped, process.CurrentState())
+}
+
+func TestProxyManager_DrainToPeer(t *testing.T) {
+	peerServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"response":"from-peer"}`))
+	}))
+	defer peerServer.Close()
+
+	configStr := fmt.Sprintf(`
+logLevel: error
+peers:
+  test-peer:
+    proxy: %s
+    models:
+      - model1
+models:
+  model1:
+    cmd: %s -port ${PORT} -silent -respond model1
+`, peerServer.URL, getSimpleResponderPath())
+
+	testConfig, err := config.LoadConfigFromReader(strings.NewReader(configStr))
+	require.NoError(t, err)
+
+	proxy := New(testConfig)
+	defer proxy.StopProcesses(StopImmediately)
+
+	chat := func() *TestResponseRecorder {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model":"model1"}`))
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		return w
+	}
+
+	require.True(t, proxy.startDrain(time.Minute, drainSleep, true))
+	w := chat()
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "from-peer")
+
+	proxy.drain.Store(nil)
+	require.True(t, proxy.startDrain(time.Minute, drainSleep, false))
+	assert.Equal(t, http.StatusServiceUnavailable, chat().Code)
+}
@@ -0,0 +1,348 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// embeddingBatcher coalesces concurrent /v1/embeddings requests to a model
+// into one upstream request and splits the embeddings back out. The first
+// request of a batch waits up to maxWait for others to join, a batch is sent
+// right away once it holds maxSize inputs.
+type embeddingBatcher struct {
+	maxSize int
+	maxWait time.Duration
+	logger  *LogMonitor
+
+	mu sync.Mutex
+	// open batches by the request options other than the input, requests
+	// with different options are not batched together
+	pending map[string]*embeddingBatch
+}
+
+type embeddingBatch struct {
+	body    []byte
+	members []*batchMember
+	inputs  int
+	full    chan struct{}
+}
+
+// batchMember is a request in a batch with its inputs
+type batchMember struct {
+	inputs []string
+	result chan batchResult
+}
+
+type batchResult struct {
+	status int
+	header http.Header
+	body   []byte
+	err    error
+}
+
+func newEmbeddingBatcher(conf config.BatchConfig, logger *LogMonitor) *embeddingBatcher {
+	return &embeddingBatcher{
+		maxSize: conf.MaxSize,
+		maxWait: conf.MaxWait(),
+		logger:  logger,
+		pending: make(map[string]*embeddingBatch),
+	}
+}
+
+// batchInputs returns the inputs of an embeddings request as raw JSON and
+// the key of the batches it can join. ok is false for bodies that can not
+// be batched.
+func batchInputs(body []byte) (inputs []string, key string, ok bool) {
+	input := gjson.GetBytes(body, "input")
+	switch {
+	case input.Type == gjson.String:
+		inputs = []string{input.Raw}
+	case input.IsArray():
+		elements := input.Array()
+		if len(elements) == 0 {
+			return nil, "", false
+		}
+		// an array of token ids is a single input
+		if elements[0].Type == gjson.Number {
+			inputs = []string{input.Raw}
+		} else {
+			for _, element := range elements {
+				inputs = append(inputs, element.Raw)
+			}
+		}
+	default:
+		return nil, "", false
+	}
+
+	var options map[string]any
+	if err := json.Unmarshal(body, &options); err != nil {
+		return nil, "", false
+	}
+	delete(options, "input")
+	normalized, err := json.Marshal(options)
+	if err != nil {
+		return nil, "", false
+	}
+	return inputs, string(normalized), true
+}
+
+// callerHeaders tell the callers of requests apart upstream, requests are
+// only batched with requests that send the same ones
+var callerHeaders = []string{"Authorization", "X-Api-Key", organizationHeader, projectHeader}
+
+// callerKey extends the batch key of r with the tenant, the client and the
+// caller headers of r
+func callerKey(r *http.Request, key string) string {
+	parts := []string{key, requestTenantName(r), requestClientName(r)}
+	for _, name := range callerHeaders {
+		parts = append(parts, strings.Join(r.Header.Values(name), ","))
+	}
+	return strings.Join(parts, "\x00")
+}
+
+// wrapHandler sends the request body upstream in a batch with other
+// requests. Requests that can not be batched, or fill a batch by
+// themselves, go to next as they are.
+func (b *embeddingBatcher) wrapHandler(
+	body []byte,
+	next func(modelID string, w http.ResponseWriter, r *http.Request) error,
+) func(modelID string, w http.ResponseWriter, r *http.Request) error {
+	return func(modelID string, w http.ResponseWriter, r *http.Request) error {
+		inputs, key, ok := batchInputs(body)
+		if !ok || len(inputs) >= b.maxSize {
+			return next(modelID, w, r)
+		}
+
+		key = callerKey(r, key)
+		member := &batchMember{inputs: inputs, result: make(chan batchResult, 1)}
+		if batch, leader := b.join(key, body, member); leader {
+			b.wait(r.Context(), key, batch)
+			b.send(modelID, r, batch, next)
+		}
+
+		select {
+		case result := <-member.result:
+			if result.err != nil {
+				return result.err
+			}
+			for name, values := range result.header {
+				w.Header()[name] = values
+			}
+			w.WriteHeader(result.status)
+			_, err := w.Write(result.body)
+			return err
+		case <-r.Context().Done():
+			return r.Context().Err()
+		}
+	}
+}
+
+// join adds member to the open batch for key. leader is true when member
+// starts a new batch and must send it.
+func (b *embeddingBatcher) join(key string, body []byte, member *batchMember) (batch *embeddingBatch, leader bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	batch, found := b.pending[key]
+	if found && batch.inputs+len(member.inputs) > b.maxSize {
+		// send the open batch now, member starts the next one
+		b.close(key, batch)
+		found = false
+	}
+	if !found {
+		batch = &embeddingBatch{body: body, full: make(chan struct{})}
+		b.pending[key] = batch
+		leader = true
+	}
+
+	batch.members = append(batch.members, member)
+	batch.inputs += len(member.inputs)
+	if batch.inputs >= b.maxSize {
+		b.close(key, batch)
+	}
+	return batch, leader
+}
+
+// close takes batch out of the open batches, must be called with b locked
+func (b *embeddingBatcher) close(key string, batch *embeddingBatch) {
+	if b.pending[key] == batch {
+		delete(b.pending, key)
+		close(batch.full)
+	}
+}
+
+// wait holds batch open until it is full, maxWait has passed or the
+// leader's request is cancelled
+func (b *embeddingBatcher) wait(ctx context.Context, key string, batch *embeddingBatch) {
+	timer := time.NewTimer(b.maxWait)
+	defer timer.Stop()
+	select {
+	case <-batch.full:
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	b.mu.Lock()
+	b.close(key, batch)
+	b.mu.Unlock()
+}
+
+// send makes one upstream request with the inputs of every member and hands
+// each member its share of the response
+func (b *embeddingBatcher) send(
+	modelID string,
+	r *http.Request,
+	batch *embeddingBatch,
+	next func(modelID string, w http.ResponseWriter, r *http.Request) error,
+) {
+	var inputs []string
+	for _, member := range batch.members {
+		inputs = append(inputs, member.inputs...)
+	}
+
+	deliver := func(result func(i int) batchResult) {
+		for i, member := range batch.members {
+			member.result <- result(i)
+		}
+	}
+
+	body, err := sjson.SetRawBytes(batch.body, "input", []byte("["+strings.Join(inputs, ",")+"]"))
+	if err != nil {
+		deliver(func(int) batchResult { return batchResult{err: err} })
+		return
+	}
+
+	// the other members wait on it, it must not end with the leader's client
+	req := r.Clone(context.WithoutCancel(r.Context()))
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	// the response is split here, keep it uncompressed
+	req.Header.Del("Accept-Encoding")
+	// every member has its own request ID, a batch of several has none
+	if len(batch.members) > 1 {
+		req.Header.Del(requestIDHeader)
+	}
+
+	b.logger.Debugf("<%s> sending %d embeddings requests with %d inputs in one batch", modelID, len(batch.members), len(inputs))
+	buffered := &bufferedResponseWriter{header: make(http.Header)}
+	if err := next(modelID, buffered, req); err != nil {
+		deliver(func(int) batchResult { return batchResult{err: err} })
+		return
+	}
+	if buffered.status == 0 {
+		buffered.status = http.StatusOK
+	}
+	buffered.header.Del("Content-Length")
+
+	// every member gets the error
+	if buffered.status != http.StatusOK {
+		deliver(func(int) batchResult {
+			return batchResult{status: buffered.status, header: buffered.header.Clone(), body: buffered.body.Bytes()}
+		})
+		return
+	}
+
+	counts := make([]int, len(batch.members))
+	for i, member := range batch.members {
+		counts[i] = len(member.inputs)
+	}
+	bodies, err := splitEmbeddings(buffered.body.Bytes(), counts)
+	if err != nil {
+		err = fmt.Errorf("unable to split batched embeddings response: %w", err)
+		b.logger.Warnf("<%s> %v", modelID, err)
+		deliver(func(int) batchResult { return batchResult{err: err} })
+		return
+	}
+	deliver(func(i int) batchResult {
+		return batchResult{status: http.StatusOK, header: buffered.header.Clone(), body: bodies[i]}
+	})
+}
+
+// splitEmbeddings splits an embeddings response into one response per
+// member, counts are the numbers of inputs of the members in order. The
+// usage is shared out by the number of inputs, the upstream does not report
+// it per input.
+func splitEmbeddings(body []byte, counts []int) ([][]byte, error) {
+	if !gjson.ValidBytes(body) {
+		return nil, errors.New("invalid JSON")
+	}
+	data := gjson.GetBytes(body, "data")
+	if !data.IsArray() {
+		return nil, errors.New("no data array")
+	}
+
+	total := 0
+	for _, count := range counts {
+		total += count
+	}
+	elements := data.Array()
+	if len(elements) != total {
+		return nil, fmt.Errorf("%d embeddings for %d inputs", len(elements), total)
+	}
+	// upstreams may return the embeddings in any order
+	sort.SliceStable(elements, func(i, j int) bool {
+		return elements[i].Get("index").Int() < elements[j].Get("index").Int()
+	})
+
+	usage := gjson.GetBytes(body, "usage")
+	promptTokens, totalTokens := usage.Get("prompt_tokens"), usage.Get("total_tokens")
+
+	bodies := make([][]byte, len(counts))
+	offset, promptShared, totalShared := 0, 0, 0
+	for i, count := range counts {
+		parts := make([]string, count)
+		for j := range count {
+			element, err := sjson.Set(elements[offset+j].Raw, "index", j)
+			if err != nil {
+				return nil, err
+			}
+			parts[j] = element
+		}
+
+		memberBody, err := sjson.SetRawBytes(body, "data", []byte("["+strings.Join(parts, ",")+"]"))
+		if err != nil {
+			return nil, err
+		}
+		last := i == len(counts)-1
+		if promptTokens.Exists() {
+			if memberBody, err = sjson.SetBytes(memberBody, "usage.prompt_tokens", shareTokens(int(promptTokens.Int()), count, total, &promptShared, last)); err != nil {
+				return nil, err
+			}
+		}
+		if totalTokens.Exists() {
+			if memberBody, err = sjson.SetBytes(memberBody, "usage.total_tokens", shareTokens(int(totalTokens.Int()), count, total, &totalShared, last)); err != nil {
+				return nil, err
+			}
+		}
+		bodies[i] = memberBody
+		offset += count
+	}
+	return bodies, nil
+}
+
+// shareTokens returns the share of tokens of a member with count of total
+// inputs. shared adds up the shares so far, the last member gets what is
+// left over from rounding.
+func shareTokens(tokens, count, total int, shared *int, last bool) int {
+	share := tokens * count / total
+	if last {
+		share = tokens - *shared
+	}
+	*shared += share
+	return share
+}
@@ -0,0 +1,200 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+func TestBatchInputs(t *testing.T) {
+	inputs, key, ok := batchInputs([]byte(`{"model":"m","input":"hello"}`))
+	require.True(t, ok)
+	assert.Equal(t, []string{`"hello"`}, inputs)
+	assert.Equal(t, `{"model":"m"}`, key)
+
+	inputs, _, ok = batchInputs([]byte(`{"model":"m","input":["a","b"]}`))
+	require.True(t, ok)
+	assert.Equal(t, []string{`"a"`, `"b"`}, inputs)
+
+	// token ids are one input, arrays of them are several
+	inputs, _, ok = batchInputs([]byte(`{"input":[1,2,3]}`))
+	require.True(t, ok)
+	assert.Equal(t, []string{`[1,2,3]`}, inputs)
+	inputs, _, ok = batchInputs([]byte(`{"input":[[1,2],[3]]}`))
+	require.True(t, ok)
+	assert.Equal(t, []string{`[1,2]`, `[3]`}, inputs)
+
+	// key order does not matter, other options do
+	_, key1, _ := batchInputs([]byte(`{"model":"m","encoding_format":"float","input":"a"}`))
+	_, key2, _ := batchInputs([]byte(`{"input":"b","encoding_format":"float","model":"m"}`))
+	_, key3, _ := batchInputs([]byte(`{"input":"b","encoding_format":"base64","model":"m"}`))
+	assert.Equal(t, key1, key2)
+	assert.NotEqual(t, key1, key3)
+
+	for _, body := range []string{`{"input":[]}`, `{"model":"m"}`, `{"input":`} {
+		_, _, ok = batchInputs([]byte(body))
+		assert.False(t, ok, body)
+	}
+}
+
+func TestSplitEmbeddings(t *testing.T) {
+	body := `{"object":"list","model":"m","data":[
+		{"object":"embedding","index":2,"embedding":[2]},
+		{"object":"embedding","index":0,"embedding":[0]},
+		{"object":"embedding","index":1,"embedding":[1]}
+	],"usage":{"prompt_tokens":10,"total_tokens":10}}`
+
+	bodies, err := splitEmbeddings([]byte(body), []int{1, 2})
+	require.NoError(t, err)
+	require.Len(t, bodies, 2)
+
+	first := gjson.ParseBytes(bodies[0])
+	assert.Equal(t, "m", first.Get("model").String())
+	assert.Equal(t, `[0]`, first.Get("data.0.embedding").Raw)
+	assert.Equal(t, int64(1), first.Get("data.#").Int())
+	assert.Equal(t, int64(3), first.Get("usage.prompt_tokens").Int())
+
+	second := gjson.ParseBytes(bodies[1])
+	assert.Equal(t, `[1]`, second.Get("data.0.embedding").Raw)
+	assert.Equal(t, int64(0), second.Get("data.0.index").Int())
+	assert.Equal(t, `[2]`, second.Get("data.1.embedding").Raw)
+	assert.Equal(t, int64(1), second.Get("data.1.index").Int())
+	// the last member gets the rest of the usage
+	assert.Equal(t, int64(7), second.Get("usage.prompt_tokens").Int())
+	assert.Equal(t, int64(7), second.Get("usage.total_tokens").Int())
+
+	_, err = splitEmbeddings([]byte(body), []int{1, 1})
+	assert.ErrorContains(t, err, "3 embeddings for 2 inputs")
+	_, err = splitEmbeddings([]byte(`{"error":"x"}`), []int{1})
+	assert.ErrorContains(t, err, "no data array")
+}
+
+// embeddingsUpstream answers embeddings requests with one embedding per
+// input, the length of the input string
+func embeddingsUpstream(calls *atomic.Int32) func(string, http.ResponseWriter, *http.Request) error {
+	return func(modelID string, w http.ResponseWriter, r *http.Request) error {
+		calls.Add(1)
+		body, _ := io.ReadAll(r.Body)
+		var data []string
+		for i, input := range gjson.GetBytes(body, "input").Array() {
+			data = append(data, fmt.Sprintf(`{"object":"embedding","index":%d,"embedding":[%d]}`, i, len(input.String())))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"object":"list","data":[%s],"usage":{"prompt_tokens":%d,"total_tokens":%d}}`, strings.Join(data, ","), len(data), len(data))
+		return nil
+	}
+}
+
+func TestEmbeddingBatcher(t *testing.T) {
+	send := func(b *embeddingBatcher, next func(string, http.ResponseWriter, *http.Request) error, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/v1/embeddings", strings.NewReader(body))
+		require.NoError(t, b.wrapHandler([]byte(body), next)("m", w, req))
+		return w
+	}
+	sendAll := func(b *embeddingBatcher, next func(string, http.ResponseWriter, *http.Request) error, bodies []string) []*httptest.ResponseRecorder {
+		results := make([]*httptest.ResponseRecorder, len(bodies))
+		var wg sync.WaitGroup
+		for i, body := range bodies {
+			wg.Go(func() { results[i] = send(b, next, body) })
+		}
+		wg.Wait()
+		return results
+	}
+
+	t.Run("coalesces concurrent requests", func(t *testing.T) {
+		var calls atomic.Int32
+		b := newEmbeddingBatcher(config.BatchConfig{MaxSize: 64, MaxWaitMs: 200}, testLogger)
+		results := sendAll(b, embeddingsUpstream(&calls), []string{
+			`{"model":"m","input":"a"}`,
+			`{"model":"m","input":["bb","ccc"]}`,
+			`{"model":"m","input":"dddd"}`,
+		})
+
+		assert.Equal(t, int32(1), calls.Load())
+		require.Equal(t, http.StatusOK, results[0].Code)
+		assert.Equal(t, `[1]`, gjson.Get(results[0].Body.String(), "data.#.embedding.0").Raw)
+		assert.Equal(t, `[2,3]`, gjson.Get(results[1].Body.String(), "data.#.embedding.0").Raw)
+		assert.Equal(t, `[0,1]`, gjson.Get(results[1].Body.String(), "data.#.index").Raw)
+		assert.Equal(t, `[4]`, gjson.Get(results[2].Body.String(), "data.#.embedding.0").Raw)
+		assert.Equal(t, "application/json", results[2].Header().Get("Content-Type"))
+	})
+
+	t.Run("a full batch is sent without waiting", func(t *testing.T) {
+		var calls atomic.Int32
+		b := newEmbeddingBatcher(config.BatchConfig{MaxSize: 3, MaxWaitMs: 10000}, testLogger)
+		start := time.Now()
+		results := sendAll(b, embeddingsUpstream(&calls), []string{`{"input":"a"}`, `{"input":"b"}`, `{"input":"c"}`})
+		assert.Less(t, time.Since(start), 5*time.Second)
+		assert.Equal(t, int32(1), calls.Load())
+		for _, w := range results {
+			assert.Equal(t, `[1]`, gjson.Get(w.Body.String(), "data.#.embedding.0").Raw)
+		}
+
+		// a request that fills a batch by itself goes straight upstream
+		w := send(b, embeddingsUpstream(&calls), `{"input":["a","b","c"]}`)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, int32(2), calls.Load())
+	})
+
+	t.Run("different options are not batched together", func(t *testing.T) {
+		var calls atomic.Int32
+		b := newEmbeddingBatcher(config.BatchConfig{MaxSize: 64, MaxWaitMs: 50}, testLogger)
+		sendAll(b, embeddingsUpstream(&calls), []string{`{"input":"a","dimensions":8}`, `{"input":"b","dimensions":16}`})
+		assert.Equal(t, int32(2), calls.Load())
+	})
+
+	t.Run("different callers are not batched together", func(t *testing.T) {
+		var calls atomic.Int32
+		var requestIDs atomic.Int32
+		upstream := embeddingsUpstream(&calls)
+		next := func(modelID string, w http.ResponseWriter, r *http.Request) error {
+			if r.Header.Get(requestIDHeader) != "" {
+				requestIDs.Add(1)
+			}
+			return upstream(modelID, w, r)
+		}
+
+		b := newEmbeddingBatcher(config.BatchConfig{MaxSize: 64, MaxWaitMs: 200}, testLogger)
+		var wg sync.WaitGroup
+		for i, auth := range []string{"Bearer a", "Bearer b", "Bearer a"} {
+			wg.Go(func() {
+				req := httptest.NewRequest("POST", "/v1/embeddings", strings.NewReader(`{"input":"x"}`))
+				req.Header.Set("Authorization", auth)
+				req.Header.Set(requestIDHeader, fmt.Sprintf("req-%d", i))
+				w := httptest.NewRecorder()
+				assert.NoError(t, b.wrapHandler([]byte(`{"input":"x"}`), next)("m", w, req))
+				assert.Equal(t, http.StatusOK, w.Code)
+			})
+		}
+		wg.Wait()
+
+		assert.Equal(t, int32(2), calls.Load())
+		// only the request batched alone keeps its request ID
+		assert.Equal(t, int32(1), requestIDs.Load())
+	})
+
+	t.Run("errors go to every request", func(t *testing.T) {
+		b := newEmbeddingBatcher(config.BatchConfig{MaxSize: 64, MaxWaitMs: 100}, testLogger)
+		failing := func(modelID string, w http.ResponseWriter, r *http.Request) error {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"input too long"}`))
+			return nil
+		}
+		for _, w := range sendAll(b, failing, []string{`{"input":"a"}`, `{"input":"b"}`}) {
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			assert.Contains(t, w.Body.String(), "input too long")
+		}
+	})
+}
@@ -0,0 +1,393 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+const (
+	// mirrored requests waiting for the candidate, more are dropped
+	evalQueueSize = 100
+
+	// responses larger than this are not compared
+	evalMaxResponseBytes = 4 * 1024 * 1024
+
+	// the judge replies with two scores
+	evalJudgeMaxTokens = 64
+)
+
+// EvalResult is one request answered by the model and the candidate
+type EvalResult struct {
+	Timestamp time.Time       `json:"timestamp"`
+	Request   json.RawMessage `json:"request"`
+
+	ModelOutput     string `json:"model_output"`
+	CandidateOutput string `json:"candidate_output"`
+
+	ModelDurationMs     int `json:"model_duration_ms"`
+	CandidateDurationMs int `json:"candidate_duration_ms"`
+
+	// scores from 1 to 10 given by the judge, null without a judge
+	ModelScore     *float64 `json:"model_score"`
+	CandidateScore *float64 `json:"candidate_score"`
+
+	// set when the candidate or the judge failed
+	Error string `json:"error,omitempty"`
+}
+
+// EvalSummary compares the model and the candidate over the kept results
+type EvalSummary struct {
+	Name      string `json:"name"`
+	Model     string `json:"model"`
+	Candidate string `json:"candidate"`
+	Judge     string `json:"judge,omitempty"`
+
+	Samples  int `json:"samples"`
+	Failures int `json:"failures"`
+
+	AvgModelDurationMs     int `json:"avg_model_duration_ms"`
+	AvgCandidateDurationMs int `json:"avg_candidate_duration_ms"`
+
+	// averages of the judged results, null before the first one
+	Judged            int      `json:"judged"`
+	AvgModelScore     *float64 `json:"avg_model_score"`
+	AvgCandidateScore *float64 `json:"avg_candidate_score"`
+	ModelWins         int      `json:"model_wins"`
+	CandidateWins     int      `json:"candidate_wins"`
+	Ties              int      `json:"ties"`
+
+	Results []EvalResult `json:"results,omitempty"`
+}
+
+type evalJob struct {
+	name            string
+	path            string
+	request         []byte
+	modelOutput     string
+	modelDurationMs int
+	timestamp       time.Time
+}
+
+// evalRunner mirrors requests to the candidates of evals and keeps the
+// results of each eval
+type evalRunner struct {
+	evals map[string]config.EvalConfig
+	jobs  chan evalJob
+
+	mu      sync.Mutex
+	results map[string][]EvalResult
+}
+
+func newEvalRunner(evals map[string]config.EvalConfig) *evalRunner {
+	return &evalRunner{
+		evals:   evals,
+		jobs:    make(chan evalJob, evalQueueSize),
+		results: make(map[string][]EvalResult),
+	}
+}
+
+func (er *evalRunner) record(name string, result EvalResult) {
+	er.mu.Lock()
+	defer er.mu.Unlock()
+	results := append(er.results[name], result)
+	if limit := er.evals[name].Results(); len(results) > limit {
+		results = results[len(results)-limit:]
+	}
+	er.results[name] = results
+}
+
+// summary compares the kept results of the eval name
+func (er *evalRunner) summary(name string, withResults bool) EvalSummary {
+	eval := er.evals[name]
+	summary := EvalSummary{Name: name, Model: eval.Model, Candidate: eval.Candidate, Judge: eval.Judge}
+
+	er.mu.Lock()
+	results := append([]EvalResult(nil), er.results[name]...)
+	er.mu.Unlock()
+
+	var modelMs, candidateMs int
+	var modelScore, candidateScore float64
+	for _, result := range results {
+		summary.Samples++
+		if result.Error != "" && result.CandidateOutput == "" {
+			summary.Failures++
+			continue
+		}
+		modelMs += result.ModelDurationMs
+		candidateMs += result.CandidateDurationMs
+		if result.ModelScore == nil || result.CandidateScore == nil {
+			continue
+		}
+		summary.Judged++
+		modelScore += *result.ModelScore
+		candidateScore += *result.CandidateScore
+		switch {
+		case *result.ModelScore > *result.CandidateScore:
+			summary.ModelWins++
+		case *result.ModelScore < *result.CandidateScore:
+			summary.CandidateWins++
+		default:
+			summary.Ties++
+		}
+	}
+
+	if answered := summary.Samples - summary.Failures; answered > 0 {
+		summary.AvgModelDurationMs = modelMs / answered
+		summary.AvgCandidateDurationMs = candidateMs / answered
+	}
+	if summary.Judged > 0 {
+		avgModel := modelScore / float64(summary.Judged)
+		avgCandidate := candidateScore / float64(summary.Judged)
+		summary.AvgModelScore = &avgModel
+		summary.AvgCandidateScore = &avgCandidate
+	}
+	if withResults {
+		summary.Results = results
+	}
+	return summary
+}
+
+// wrapHandler mirrors requests for modelID that next answered successfully
+// to the candidates of the evals of modelID
+func (er *evalRunner) wrapHandler(
+	modelID string,
+	path string,
+	reqBody []byte,
+	next func(modelID string, w http.ResponseWriter, r *http.Request) error,
+) func(modelID string, w http.ResponseWriter, r *http.Request) error {
+	var names []string
+	for name, eval := range er.evals {
+		if eval.Model == modelID && rand.Float64() < eval.Rate() {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 || (path != "/v1/chat/completions" && path != "/v1/completions") {
+		return next
+	}
+
+	return func(modelID string, w http.ResponseWriter, r *http.Request) error {
+		start := time.Now()
+		recorder := &cacheRecorder{ResponseWriter: w, limit: evalMaxResponseBytes}
+		if err := next(modelID, recorder, r); err != nil {
+			return err
+		}
+		if (recorder.status != 0 && recorder.status != http.StatusOK) || recorder.overflow {
+			return nil
+		}
+
+		body, err := decompressBody(recorder.body.Bytes(), w.Header().Get("Content-Encoding"))
+		if err != nil {
+			return nil
+		}
+		streaming := strings.Contains(w.Header().Get("Content-Type"), "text/event-stream")
+
+		for _, name := range names {
+			job := evalJob{
+				name:            name,
+				path:            path,
+				request:         reqBody,
+				modelOutput:     completionText(path, body, streaming),
+				modelDurationMs: int(time.Since(start).Milliseconds()),
+				timestamp:       start,
+			}
+			select {
+			case er.jobs <- job:
+			default:
+				// the candidate can not keep up, skip rather than pile up
+			}
+		}
+		return nil
+	}
+}
+
+// completionText returns the text of the first choice of a completion
+func completionText(path string, body []byte, streaming bool) string {
+	if path == "/v1/completions" {
+		if !streaming {
+			return gjson.GetBytes(body, "choices.0.text").String()
+		}
+		var text strings.Builder
+		forEachStreamEvent(body, func(event gjson.Result) {
+			text.WriteString(event.Get("choices.0.text").String())
+		})
+		return text.String()
+	}
+
+	if !streaming {
+		return gjson.GetBytes(body, "choices.0.message.content").String()
+	}
+	content, _ := assembleStreamedMessage(body)["content"].(string)
+	return content
+}
+
+// runEvals answers the mirrored requests until shutdown
+func (pm *ProxyManager) runEvals() {
+	for {
+		select {
+		case <-pm.shutdownCtx.Done():
+			return
+		case job := <-pm.evals.jobs:
+			pm.evals.record(job.name, pm.runEval(job))
+		}
+	}
+}
+
+func (pm *ProxyManager) runEval(job evalJob) EvalResult {
+	eval := pm.evals.evals[job.name]
+	result := EvalResult{
+		Timestamp:       job.timestamp,
+		Request:         json.RawMessage(job.request),
+		ModelOutput:     job.modelOutput,
+		ModelDurationMs: job.modelDurationMs,
+	}
+
+	body, err := sjson.SetBytes(job.request, "model", pm.upstreamModelName(eval.Candidate))
+	if err == nil {
+		body, err = sjson.SetBytes(body, "stream", false)
+	}
+	if err == nil {
+		body, err = sjson.DeleteBytes(body, "stream_options")
+	}
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	start := time.Now()
+	reply, err := pm.evalRequest(eval.Candidate, job.path, body)
+	result.CandidateDurationMs = int(time.Since(start).Milliseconds())
+	if err != nil {
+		result.Error = fmt.Sprintf("candidate %s: %v", eval.Candidate, err)
+		pm.proxyLogger.Warnf("eval %s: %s", job.name, result.Error)
+		return result
+	}
+	result.CandidateOutput = completionText(job.path, reply, false)
+
+	if eval.Judge != "" {
+		modelScore, candidateScore, err := pm.judgeEval(eval, job, result)
+		if err != nil {
+			result.Error = fmt.Sprintf("judge %s: %v", eval.Judge, err)
+			pm.proxyLogger.Warnf("eval %s: %s", job.name, result.Error)
+		} else {
+			result.ModelScore = &modelScore
+			result.CandidateScore = &candidateScore
+		}
+	}
+	return result
+}
+
+// upstreamModelName returns the model name sent to the upstream of modelID
+func (pm *ProxyManager) upstreamModelName(modelID string) string {
+	if useModelName := pm.config.Models[modelID].UpstreamModelName(modelID); useModelName != "" {
+		return useModelName
+	}
+	return modelID
+}
+
+// evalRequest sends body to modelID and returns the reply
+func (pm *ProxyManager) evalRequest(modelID, path string, body []byte) ([]byte, error) {
+	if err := pm.checkQuarantine(modelID); err != nil {
+		return nil, err
+	}
+	processGroup, err := pm.swapProcessGroup(modelID)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(pm.shutdownCtx, http.MethodPost, path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	w := &bufferedResponseWriter{header: make(http.Header)}
+	if err := processGroup.ProxyRequest(modelID, w, req); err != nil {
+		return nil, err
+	}
+	if w.status != 0 && w.status != http.StatusOK {
+		return nil, fmt.Errorf("status %d: %s", w.status, probeExcerpt(w.body.Bytes()))
+	}
+	return w.body.Bytes(), nil
+}
+
+// judgeEval asks the judge to score both outputs
+func (pm *ProxyManager) judgeEval(eval config.EvalConfig, job evalJob, result EvalResult) (modelScore, candidateScore float64, err error) {
+	prompt := gjson.GetBytes(job.request, "messages").Raw
+	if prompt == "" {
+		prompt = gjson.GetBytes(job.request, "prompt").String()
+	}
+	content := fmt.Sprintf("Request:\n%s\n\nAnswer A:\n%s\n\nAnswer B:\n%s", prompt, result.ModelOutput, result.CandidateOutput)
+
+	body, err := json.Marshal(gin.H{
+		"model": pm.upstreamModelName(eval.Judge),
+		"messages": []gin.H{
+			{"role": "system", "content": eval.JudgeSystemPrompt()},
+			{"role": "user", "content": content},
+		},
+		"temperature": 0,
+		"max_tokens":  evalJudgeMaxTokens,
+		"stream":      false,
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	reply, err := pm.evalRequest(eval.Judge, "/v1/chat/completions", body)
+	if err != nil {
+		return 0, 0, err
+	}
+	return parseJudgeScores(gjson.GetBytes(reply, "choices.0.message.content").String())
+}
+
+// parseJudgeScores reads {"a": <score>, "b": <score>} from a judge reply,
+// text around the JSON object is ignored
+func parseJudgeScores(reply string) (a, b float64, err error) {
+	start, end := strings.Index(reply, "{"), strings.LastIndex(reply, "}")
+	if start < 0 || end < start {
+		return 0, 0, fmt.Errorf("no scores in reply: %s", probeExcerpt([]byte(reply)))
+	}
+	scores := gjson.Parse(reply[start : end+1])
+	scoreA, scoreB := scores.Get("a"), scores.Get("b")
+	if scoreA.Type != gjson.Number || scoreB.Type != gjson.Number {
+		return 0, 0, fmt.Errorf("no scores in reply: %s", probeExcerpt([]byte(reply)))
+	}
+	return scoreA.Float(), scoreB.Float(), nil
+}
+
+// apiGetEvals returns the summary of every eval
+func (pm *ProxyManager) apiGetEvals(c *gin.Context) {
+	summaries := []EvalSummary{}
+	if pm.evals != nil {
+		for name := range pm.evals.evals {
+			summaries = append(summaries, pm.evals.summary(name, false))
+		}
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].Name < summaries[j].Name })
+	c.JSON(http.StatusOK, summaries)
+}
+
+// apiGetEval returns the summary and the kept results of one eval
+func (pm *ProxyManager) apiGetEval(c *gin.Context) {
+	name := c.Param("name")
+	if pm.evals == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("eval %s not found", name)})
+		return
+	}
+	if _, found := pm.evals.evals[name]; !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("eval %s not found", name)})
+		return
+	}
+	c.JSON(http.StatusOK, pm.evals.summary(name, true))
+}
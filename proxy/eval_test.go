@@ -0,0 +1,99 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseJudgeScores(t *testing.T) {
+	a, b, err := parseJudgeScores(`Scores: {"a": 7, "b": 8.5} done`)
+	require.NoError(t, err)
+	assert.Equal(t, 7.0, a)
+	assert.Equal(t, 8.5, b)
+
+	_, _, err = parseJudgeScores("A is better")
+	assert.ErrorContains(t, err, "no scores in reply")
+
+	_, _, err = parseJudgeScores(`{"a": "good", "b": 3}`)
+	assert.ErrorContains(t, err, "no scores in reply")
+}
+
+func TestEvalRunner_Summary(t *testing.T) {
+	runner := newEvalRunner(map[string]config.EvalConfig{
+		"qwen": {Model: "model1", Candidate: "model2", MaxResults: 3},
+	})
+	score := func(v float64) *float64 { return &v }
+
+	runner.record("qwen", EvalResult{ModelDurationMs: 100, CandidateDurationMs: 300, CandidateOutput: "a", ModelScore: score(8), CandidateScore: score(6)})
+	runner.record("qwen", EvalResult{ModelDurationMs: 100, CandidateDurationMs: 300, CandidateOutput: "a", ModelScore: score(8), CandidateScore: score(6)})
+	runner.record("qwen", EvalResult{ModelDurationMs: 200, CandidateDurationMs: 100, CandidateOutput: "a", ModelScore: score(5), CandidateScore: score(9)})
+	runner.record("qwen", EvalResult{ModelDurationMs: 300, CandidateDurationMs: 200, CandidateOutput: "a", ModelScore: score(7), CandidateScore: score(7)})
+	runner.record("qwen", EvalResult{ModelDurationMs: 300, Error: "candidate model2: status 500"})
+
+	// only the last 3 results are kept
+	summary := runner.summary("qwen", true)
+	assert.Equal(t, 3, summary.Samples)
+	assert.Equal(t, 1, summary.Failures)
+	assert.Equal(t, 250, summary.AvgModelDurationMs)
+	assert.Equal(t, 150, summary.AvgCandidateDurationMs)
+	assert.Equal(t, 2, summary.Judged)
+	assert.Equal(t, 6.0, *summary.AvgModelScore)
+	assert.Equal(t, 8.0, *summary.AvgCandidateScore)
+	assert.Equal(t, 0, summary.ModelWins)
+	assert.Equal(t, 1, summary.CandidateWins)
+	assert.Equal(t, 1, summary.Ties)
+	assert.Len(t, summary.Results, 3)
+}
+
+func TestProxyManager_Evals(t *testing.T) {
+	conf := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]config.ModelConfig{
+			"model1": getTestSimpleResponderConfig("model1"),
+			"model2": getTestSimpleResponderConfig("model2"),
+		},
+		Evals: map[string]config.EvalConfig{
+			"compare": {Model: "model1", Candidate: "model2"},
+		},
+		LogLevel: "error",
+	})
+	// the candidate runs next to the model instead of swapping it out
+	conf.Groups[config.DEFAULT_GROUP_ID] = config.GroupConfig{Swap: false, Members: []string{"model1", "model2"}}
+
+	proxy := New(conf)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	req := httptest.NewRequest("POST", "/v1/chat/completions?stream=true", bytes.NewBufferString(`{"model":"model1","stream":true}`))
+	w := CreateTestResponseRecorder()
+	proxy.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var summary EvalSummary
+	assert.Eventually(t, func() bool {
+		req := httptest.NewRequest("GET", "/api/evals/compare", nil)
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		return w.Code == http.StatusOK && json.Unmarshal(w.Body.Bytes(), &summary) == nil && summary.Samples == 1
+	}, 10*time.Second, 50*time.Millisecond)
+
+	assert.Equal(t, "model2", summary.Candidate)
+	assert.Equal(t, 0, summary.Failures)
+	require.Len(t, summary.Results, 1)
+	assert.Equal(t, strings.Repeat("asdf", 10), summary.Results[0].ModelOutput)
+	assert.Empty(t, summary.Results[0].Error)
+	assert.Equal(t, StateReady, proxy.modelState("model2"))
+
+	req = httptest.NewRequest("GET", "/api/evals/unknown", nil)
+	w = CreateTestResponseRecorder()
+	proxy.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
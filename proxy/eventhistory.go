@@ -0,0 +1,163 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/napmany/llmsnap/event"
+)
+
+// how many UI events are kept for clients that reconnect with Last-Event-ID
+const uiEventHistorySize = 1000
+
+// uiEvent is a message for the UI event stream with its SSE event id
+type uiEvent struct {
+	ID       uint64
+	Envelope messageEnvelope
+}
+
+func (e uiEvent) Type() uint32 {
+	return UIEventID
+}
+
+// uiEventHistory numbers UI events and keeps the most recent ones so a
+// client that lost its connection can catch up on what it missed. IDs start
+// at the creation time in microseconds so IDs from a previous ProxyManager,
+// e.g. before a config reload, are never mistaken for IDs of this one.
+type uiEventHistory struct {
+	mu      sync.Mutex
+	startID uint64
+	nextID  uint64
+	size    int
+	entries []uiEvent
+	bus     *event.Dispatcher
+}
+
+func newUIEventHistory(size int) *uiEventHistory {
+	startID := uint64(time.Now().UnixMicro())
+	return &uiEventHistory{
+		startID: startID,
+		nextID:  startID,
+		size:    size,
+		entries: make([]uiEvent, 0, size),
+		bus:     event.NewDispatcherConfig(1000),
+	}
+}
+
+// record adds a message to the history and notifies subscribers
+func (h *uiEventHistory) record(msgType messageType, data string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ev := uiEvent{ID: h.nextID, Envelope: messageEnvelope{Type: msgType, Data: data}}
+	h.nextID++
+
+	if len(h.entries) == h.size {
+		copy(h.entries, h.entries[1:])
+		h.entries = h.entries[:len(h.entries)-1]
+	}
+	h.entries = append(h.entries, ev)
+
+	event.Publish(h.bus, ev)
+}
+
+// lastID returns the id of the most recent event
+func (h *uiEventHistory) lastID() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.nextID - 1
+}
+
+// since returns the events after lastID. ok is false when the history no
+// longer holds all of them or lastID is not from this history.
+func (h *uiEventHistory) since(lastID uint64) (events []uiEvent, ok bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if lastID < h.startID-1 || lastID >= h.nextID {
+		return nil, false
+	}
+	if len(h.entries) > 0 && h.entries[0].ID > lastID+1 {
+		return nil, false
+	}
+
+	for _, ev := range h.entries {
+		if ev.ID > lastID {
+			events = append(events, ev)
+		}
+	}
+	return events, true
+}
+
+// onRecord calls callback after each recorded event
+func (h *uiEventHistory) onRecord(callback func()) context.CancelFunc {
+	return event.SubscribeWithOptions(h.bus, func(uiEvent) {
+		callback()
+	}, event.Options[uiEvent]{MaxQueue: uiEventQueueSize, Overflow: event.OverflowDrop})
+}
+
+// recordUIEvents feeds the UI event history until the ProxyManager shuts down
+func (pm *ProxyManager) recordUIEvents() {
+	recordModels := func() {
+		if data, err := json.Marshal(pm.getModelStatus()); err == nil {
+			pm.uiEvents.record(msgTypeModelStatus, string(data))
+		}
+	}
+
+	recordLogData := func(source string, data []byte) {
+		if data, err := json.Marshal(gin.H{"source": source, "data": string(data)}); err == nil {
+			pm.uiEvents.record(msgTypeLogData, string(data))
+		}
+	}
+
+	cancels := []context.CancelFunc{
+		event.On(func(e ProcessStateChangeEvent) {
+			recordModels()
+		}),
+		event.On(func(e ConfigFileChangedEvent) {
+			recordModels()
+		}),
+		event.On(func(e QuarantineChangedEvent) {
+			recordModels()
+		}),
+		event.On(func(e InFlightChangedEvent) {
+			// instances after the first are named model#2, model#3, ...
+			modelID := e.ProcessName
+			if _, found := pm.config.Models[modelID]; !found {
+				if i := strings.LastIndex(modelID, "#"); i > 0 {
+					modelID = modelID[:i]
+				}
+			}
+			if data, err := json.Marshal(pm.modelInFlight(modelID)); err == nil {
+				pm.uiEvents.record(msgTypeInFlight, string(data))
+			}
+		}),
+		event.On(func(e TokenMetricsEvent) {
+			if data, err := json.Marshal([]TokenMetrics{e.Metrics}); err == nil {
+				pm.uiEvents.record(msgTypeMetrics, string(data))
+			}
+		}),
+		event.On(func(e RequestErrorEvent) {
+			if data, err := json.Marshal([]RequestError{e.Error}); err == nil {
+				pm.uiEvents.record(msgTypeErrors, string(data))
+			}
+		}),
+		pm.proxyLogger.OnLogData(func(data []byte) {
+			recordLogData("proxy", data)
+		}),
+		pm.upstreamLogger.OnLogData(func(data []byte) {
+			recordLogData("upstream", data)
+		}),
+	}
+
+	go func() {
+		<-pm.shutdownCtx.Done()
+		for _, cancel := range cancels {
+			cancel()
+		}
+	}()
+}
@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"context"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUIEventHistory_Since(t *testing.T) {
+	h := newUIEventHistory(3)
+	start := h.lastID()
+
+	events, ok := h.since(start)
+	assert.True(t, ok)
+	assert.Empty(t, events)
+
+	for i := 0; i < 5; i++ {
+		h.record(msgTypeLogData, strconv.Itoa(i))
+	}
+	assert.Equal(t, start+5, h.lastID())
+
+	// the last 3 are kept
+	events, ok = h.since(start + 2)
+	assert.True(t, ok)
+	if assert.Len(t, events, 3) {
+		assert.Equal(t, start+3, events[0].ID)
+		assert.Equal(t, "2", events[0].Envelope.Data)
+		assert.Equal(t, "4", events[2].Envelope.Data)
+	}
+
+	events, ok = h.since(start + 4)
+	assert.True(t, ok)
+	assert.Len(t, events, 1)
+
+	// too old, from the future or from another history
+	_, ok = h.since(start + 1)
+	assert.False(t, ok)
+	_, ok = h.since(start + 6)
+	assert.False(t, ok)
+	_, ok = h.since(1)
+	assert.False(t, ok)
+}
+
+func TestProxyManager_EventsLastEventID(t *testing.T) {
+	conf := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]config.ModelConfig{
+			"model1": getTestSimpleResponderConfig("model1"),
+		},
+		LogLevel: "error",
+	})
+
+	proxy := New(conf)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	lastID := proxy.uiEvents.lastID()
+	proxy.uiEvents.record(msgTypeLogData, "missed-1")
+	proxy.uiEvents.record(msgTypeLogData, "missed-2")
+
+	getEvents := func(header string) string {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		req := httptest.NewRequest("GET", "/api/events", nil).WithContext(ctx)
+		if header != "" {
+			req.Header.Set("Last-Event-ID", header)
+		}
+		rec := CreateTestResponseRecorder()
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			proxy.ServeHTTP(rec, req)
+		}()
+		<-done
+		return rec.Body.String()
+	}
+
+	t.Run("replays missed events", func(t *testing.T) {
+		body := getEvents(strconv.FormatUint(lastID, 10))
+		assert.Contains(t, body, "id:"+strconv.FormatUint(lastID+1, 10))
+		assert.Contains(t, body, "missed-1")
+		assert.Contains(t, body, "missed-2")
+		assert.NotContains(t, body, string(msgTypeModelStatus))
+	})
+
+	t.Run("sends the initial state without an id", func(t *testing.T) {
+		body := getEvents("")
+		assert.Contains(t, body, string(msgTypeModelStatus))
+		assert.NotContains(t, body, "missed-1")
+		assert.Equal(t, strings.Count(body, "id:"+strconv.FormatUint(lastID+2, 10)), 5)
+	})
+
+	t.Run("sends the initial state for an unknown id", func(t *testing.T) {
+		body := getEvents("12")
+		assert.Contains(t, body, string(msgTypeModelStatus))
+		assert.NotContains(t, body, "missed-1")
+	})
+}
@@ -8,6 +8,10 @@ const ConfigFileChangedEventID = 0x03
 const LogDataEventID = 0x04
 const TokenMetricsEventID = 0x05
 const ModelPreloadedEventID = 0x06
+const UIEventID = 0x07
+const QuarantineChangedEventID = 0x08
+const InFlightChangedEventID = 0x09
+const RequestErrorEventID = 0x0A
 
 type ProcessStateChangeEvent struct {
 	ProcessName string
@@ -58,3 +62,22 @@ type ModelPreloadedEvent struct {
 func (e ModelPreloadedEvent) Type() uint32 {
 	return ModelPreloadedEventID
 }
+
+type QuarantineChangedEvent struct {
+	ModelName   string
+	Quarantined bool
+}
+
+func (e QuarantineChangedEvent) Type() uint32 {
+	return QuarantineChangedEventID
+}
+
+type InFlightChangedEvent struct {
+	ProcessName string
+	InFlight    int
+	Streaming   int
+}
+
+func (e InFlightChangedEvent) Type() uint32 {
+	return InFlightChangedEventID
+}
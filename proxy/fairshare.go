@@ -0,0 +1,191 @@
+package proxy
+
+import (
+	"net/http"
+	"slices"
+	"time"
+)
+
+// fairShare lets the models of a swap group take turns when more than one
+// of them has waiting requests. The running model may serve up to quantum
+// requests while others are waiting, then the next model with waiting
+// requests, in member order, gets the group. Without fair sharing a busy
+// model can keep the group indefinitely or the group thrashes by swapping
+// on every request.
+//
+// With a swap window the group waits a little before swapping so that
+// competing requests can pile up. The model with the most waiting requests
+// then gets the group and its requests are released together.
+//
+// All fields are guarded by the ProcessGroup's lock.
+type fairShare struct {
+	quantum  int
+	window   time.Duration
+	members  []string
+	inflight int
+	served   int
+	waiting  map[string][]chan struct{}
+
+	// windowOpen is set while waiting for the swap window to pass,
+	// windowElapsed once it has passed and the swap may happen
+	windowOpen    bool
+	windowElapsed bool
+}
+
+func newFairShare(quantum int, window time.Duration, members []string) *fairShare {
+	return &fairShare{
+		quantum: quantum,
+		window:  window,
+		members: members,
+		waiting: make(map[string][]chan struct{}),
+	}
+}
+
+func (f *fairShare) othersWaiting(modelID string) bool {
+	for memberID, queue := range f.waiting {
+		if memberID != modelID && len(queue) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// nextTurn picks the model that gets the group next. Without a swap window
+// models take turns in member order starting after current. With a window
+// the model with the most waiting requests goes first, current only when
+// no other model is waiting.
+func (f *fairShare) nextTurn(current string) string {
+	start := slices.Index(f.members, current) + 1
+	next, most := "", 0
+	for i := 0; i < len(f.members); i++ {
+		memberID := f.members[(start+i)%len(f.members)]
+		count := len(f.waiting[memberID])
+		if count == 0 {
+			continue
+		}
+		if f.window == 0 || memberID == current {
+			if next == "" {
+				return memberID
+			}
+			return next
+		}
+		if count > most {
+			next, most = memberID, count
+		}
+	}
+	return next
+}
+
+// proxyFairShare waits for modelID's turn then proxies the request
+func (pg *ProcessGroup) proxyFairShare(modelID string, writer http.ResponseWriter, request *http.Request) {
+	pg.Lock()
+	ready := pg.fairEnter(modelID)
+	pg.Unlock()
+
+	if ready != nil {
+		select {
+		case <-ready:
+		case <-request.Context().Done():
+			pg.Lock()
+			queue := pg.fair.waiting[modelID]
+			if i := slices.Index(queue, ready); i >= 0 {
+				pg.fair.waiting[modelID] = slices.Delete(queue, i, i+1)
+				pg.Unlock()
+				return
+			}
+			pg.Unlock()
+
+			// the turn was granted while the client went away, give it back
+			pg.fairDone()
+			return
+		}
+	}
+
+	defer pg.fairDone()
+	pg.serve(modelID, writer, request)
+}
+
+// fairEnter admits the request right away by returning nil or queues it and
+// returns a channel that is closed when it is admitted. Called with pg locked.
+func (pg *ProcessGroup) fairEnter(modelID string) chan struct{} {
+	f := pg.fair
+
+	// with a swap window even the first load waits for competing requests
+	if pg.lastUsedProcess == "" && f.inflight == 0 && f.window == 0 {
+		pg.lastUsedProcess = modelID
+		f.served = 0
+	}
+
+	if pg.lastUsedProcess == modelID && len(f.waiting[modelID]) == 0 &&
+		(f.served < f.quantum || !f.othersWaiting(modelID)) {
+		f.inflight++
+		f.served++
+		return nil
+	}
+
+	ready := make(chan struct{})
+	f.waiting[modelID] = append(f.waiting[modelID], ready)
+	pg.fairDispatch()
+	return ready
+}
+
+// fairDone marks an admitted request as finished and hands the group to the
+// next model when it is idle
+func (pg *ProcessGroup) fairDone() {
+	pg.Lock()
+	defer pg.Unlock()
+	pg.fair.inflight--
+	pg.fairDispatch()
+}
+
+// fairDispatch switches the group to the next model with waiting requests
+// once all in-flight requests are done. Called with pg locked.
+func (pg *ProcessGroup) fairDispatch() {
+	f := pg.fair
+	if f.inflight > 0 || f.windowOpen {
+		return
+	}
+
+	elapsed := f.windowElapsed
+	f.windowElapsed = false
+
+	next := f.nextTurn(pg.lastUsedProcess)
+	if next == "" {
+		return
+	}
+
+	if next != pg.lastUsedProcess && f.window > 0 && !elapsed {
+		f.windowOpen = true
+		time.AfterFunc(f.window, func() {
+			pg.Lock()
+			defer pg.Unlock()
+			f.windowOpen = false
+			f.windowElapsed = true
+			pg.fairDispatch()
+		})
+		return
+	}
+
+	if pg.lastUsedProcess != next {
+		if pg.lastUsedProcess != "" {
+			pg.evict(pg.processes[pg.lastUsedProcess])
+		}
+		pg.forgetPrefixes(pg.lastUsedProcess)
+		pg.lastUsedProcess = next
+	}
+	f.served = 0
+
+	// admit a full turn, or everyone when nobody else is waiting or turns
+	// are not limited
+	queue := f.waiting[next]
+	admit := len(queue)
+	if f.quantum > 0 && f.othersWaiting(next) {
+		admit = min(admit, f.quantum)
+	}
+	for _, ready := range queue[:admit] {
+		close(ready)
+	}
+	f.waiting[next] = queue[admit:]
+	f.inflight += admit
+	f.served += admit
+}
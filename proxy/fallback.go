@@ -0,0 +1,157 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/tidwall/sjson"
+)
+
+// servedModel holds the model that served a request when a fallback took
+// over from the requested one
+type servedModel struct {
+	id string
+}
+
+// withServedModel lets fallbackHandler record the model that served r
+func withServedModel(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), proxyCtxKey("served"), &servedModel{}))
+}
+
+// servedModelID returns the fallback that served r, "" when it was the
+// requested model
+func servedModelID(r *http.Request) string {
+	if served, ok := r.Context().Value(proxyCtxKey("served")).(*servedModel); ok {
+		return served.id
+	}
+	return ""
+}
+
+// fallbackHandler sends the request to the fallbacks of the model in order
+// until one does not fail. A model fails when it can not start, which
+// includes failed health checks, or when it replies with a 5xx. body is the
+// request before the model's filters were applied, each fallback gets it
+// with its own. Fallbacks that are not admitted, see checkAdmission, are
+// skipped.
+func (pm *ProxyManager) fallbackHandler(
+	body []byte,
+	path string,
+	next func(modelID string, w http.ResponseWriter, r *http.Request) error,
+) func(modelID string, w http.ResponseWriter, r *http.Request) error {
+	return func(modelID string, w http.ResponseWriter, r *http.Request) error {
+		chain := []string{modelID}
+		for _, fallback := range pm.config.Models[modelID].Fallback {
+			if err := pm.checkAdmission(fallback); err != nil {
+				pm.proxyLogger.Warnf("<%s> skipping fallback %s: %v", modelID, fallback, err)
+				continue
+			}
+			chain = append(chain, fallback)
+		}
+		handler, req := next, r
+
+		for i, candidate := range chain {
+			if i > 0 {
+				processGroup, err := pm.swapProcessGroup(candidate)
+				if err != nil {
+					return err
+				}
+				candidateBody, err := sjson.SetBytes(body, "model", candidate)
+				if err != nil {
+					return err
+				}
+				if candidateBody, err = pm.upstreamBody(candidate, candidate, path, candidateBody); err != nil {
+					// a wasm filter of the fallback answers the request itself
+					var local *wasmLocalResponse
+					if errors.As(err, &local) {
+						local.write(w)
+						return nil
+					}
+					return err
+				}
+				req = r.Clone(r.Context())
+				req.Body = io.NopCloser(bytes.NewReader(candidateBody))
+				req.ContentLength = int64(len(candidateBody))
+				req.Header.Set("Content-Length", strconv.Itoa(len(candidateBody)))
+				handler = processGroup.ProxyRequest
+
+				// the response headers name the model that answers
+				if stamp := requestStamp(r); stamp != nil {
+					stamp.model = candidate
+				}
+			}
+
+			last := i == len(chain)-1
+			fw := &fallbackWriter{w: w, header: make(http.Header), canFail: !last}
+			err := handler(candidate, fw, req)
+			if err == nil && !fw.failed {
+				if served, ok := r.Context().Value(proxyCtxKey("served")).(*servedModel); ok && i > 0 {
+					served.id = candidate
+				}
+				return nil
+			}
+			if last {
+				return err
+			}
+
+			reason := fmt.Sprintf("status %d", fw.status)
+			if err != nil {
+				reason = err.Error()
+			}
+			pm.proxyLogger.Warnf("<%s> failed with %s, falling back to %s", candidate, reason, chain[i+1])
+		}
+		return nil
+	}
+}
+
+// fallbackWriter passes a response through unless it is a 5xx and canFail
+// is set, then it is dropped so the next model of the chain can answer
+type fallbackWriter struct {
+	w       http.ResponseWriter
+	header  http.Header
+	status  int
+	canFail bool
+	failed  bool
+}
+
+func (fw *fallbackWriter) Header() http.Header {
+	return fw.header
+}
+
+func (fw *fallbackWriter) WriteHeader(statusCode int) {
+	if fw.status != 0 {
+		return
+	}
+	fw.status = statusCode
+	if fw.canFail && statusCode >= http.StatusInternalServerError {
+		fw.failed = true
+		return
+	}
+	for key, values := range fw.header {
+		fw.w.Header()[key] = values
+	}
+	fw.w.WriteHeader(statusCode)
+}
+
+func (fw *fallbackWriter) Write(data []byte) (int, error) {
+	if fw.status == 0 {
+		fw.WriteHeader(http.StatusOK)
+	}
+	if fw.failed {
+		return len(data), nil
+	}
+	return fw.w.Write(data)
+}
+
+func (fw *fallbackWriter) Flush() {
+	if fw.status == 0 || fw.failed {
+		return
+	}
+	if flusher, ok := fw.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
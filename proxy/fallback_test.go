@@ -0,0 +1,144 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+func TestProxyManager_Fallback(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error":"out of memory"}`))
+	}))
+	defer upstream.Close()
+
+	broken := getTestSimpleResponderConfig("broken")
+	broken.Cmd = "/does/not/exist --port ${PORT}"
+	broken.Fallback = []string{"backup"}
+
+	erroring := getTestSimpleResponderConfig("erroring")
+	erroring.Proxy = upstream.URL
+	erroring.CheckEndpoint = "none"
+	erroring.Fallback = []string{"backup"}
+
+	alone := erroring
+	alone.Fallback = nil
+
+	chain := broken
+	chain.Fallback = []string{"alone"}
+
+	conf := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]config.ModelConfig{
+			"broken":   broken,
+			"erroring": erroring,
+			"alone":    alone,
+			"chain":    chain,
+			"backup":   getTestSimpleResponderConfig("backup"),
+		},
+		LogLevel: "error",
+	})
+
+	proxy := New(conf)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	for _, model := range []string{"broken", "erroring"} {
+		t.Run(model, func(t *testing.T) {
+			reqBody := `{"model":"` + model + `","messages":[{"role":"user","content":"hello"}]}`
+			req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(reqBody))
+			w := CreateTestResponseRecorder()
+			proxy.ServeHTTP(w, req)
+			require.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, "backup", gjson.Get(w.Body.String(), "responseMessage").String())
+
+			// the usage is recorded for the model that answered
+			metrics := proxy.metricsMonitor.getMetrics()
+			require.NotEmpty(t, metrics)
+			assert.Equal(t, "backup", metrics[len(metrics)-1].Model)
+		})
+	}
+
+	// the reply of the last model is sent as it is
+	t.Run("last model fails", func(t *testing.T) {
+		reqBody := `{"model":"chain","messages":[{"role":"user","content":"hello"}]}`
+		req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(reqBody))
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.Contains(t, w.Body.String(), "out of memory")
+	})
+}
+
+func TestProxyManager_FallbackWasmLocalResponse(t *testing.T) {
+	file := getWasmFilterPath(t)
+	broken := getTestSimpleResponderConfig("broken")
+	broken.Cmd = "/does/not/exist --port ${PORT}"
+	broken.Fallback = []string{"backup"}
+
+	backup := getTestSimpleResponderConfig("backup")
+	backup.WasmFilters = []config.WasmFilterConfig{
+		{File: file, Config: map[string]any{"maxTokens": 100}, Timeout: 5000},
+	}
+
+	conf := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]config.ModelConfig{
+			"broken": broken,
+			"backup": backup,
+		},
+		LogLevel: "error",
+	})
+
+	proxy := New(conf)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	// the answer of the fallback's filter is sent, not an error
+	req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model":"broken","max_tokens":1000}`))
+	w := CreateTestResponseRecorder()
+	proxy.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "max_tokens must be at most 100")
+}
+
+func TestProxyManager_FallbackSkipsUnadmitted(t *testing.T) {
+	broken := getTestSimpleResponderConfig("broken")
+	broken.Cmd = "/does/not/exist --port ${PORT}"
+	broken.Fallback = []string{"quarantined", "backup"}
+
+	conf := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]config.ModelConfig{
+			"broken":      broken,
+			"quarantined": getTestSimpleResponderConfig("quarantined"),
+			"backup":      getTestSimpleResponderConfig("backup"),
+		},
+		Quarantine: config.QuarantineConfig{MaxFailures: 1},
+		LogLevel:   "error",
+	})
+
+	proxy := New(conf)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+	require.True(t, proxy.quarantine.recordFailure("quarantined", errors.New("crashed"), time.Now()))
+
+	// the quarantined fallback is not started
+	reqBody := `{"model":"broken","messages":[{"role":"user","content":"hello"}]}`
+	req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(reqBody))
+	w := CreateTestResponseRecorder()
+	proxy.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "backup", gjson.Get(w.Body.String(), "responseMessage").String())
+
+	processGroup := proxy.findGroupByModelName("quarantined")
+	require.NotNil(t, processGroup)
+	assert.Equal(t, StateStopped, processGroup.processes["quarantined"].CurrentState())
+}
@@ -0,0 +1,180 @@
+package proxy
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GPUInfo is a snapshot of a single GPU's state
+type GPUInfo struct {
+	Index       int     `json:"index"`
+	UUID        string  `json:"uuid"`
+	Name        string  `json:"name"`
+	MemoryTotal int     `json:"memory_total_mib"`
+	MemoryUsed  int     `json:"memory_used_mib"`
+	MemoryFree  int     `json:"memory_free_mib"`
+	Temperature int     `json:"temperature_c"`
+	PowerDraw   float64 `json:"power_draw_w"`
+}
+
+// gpuReader returns the current state of all GPUs
+type gpuReader func() ([]GPUInfo, error)
+
+const nvidiaSMIQuery = "index,uuid,name,memory.total,memory.used,memory.free,temperature.gpu,power.draw"
+
+// readNvidiaSMI queries nvidia-smi for GPU readings
+func readNvidiaSMI() ([]GPUInfo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	output, err := exec.CommandContext(ctx, "nvidia-smi",
+		"--query-gpu="+nvidiaSMIQuery,
+		"--format=csv,noheader,nounits",
+	).Output()
+	if err != nil {
+		return nil, fmt.Errorf("nvidia-smi: %w", err)
+	}
+	return parseNvidiaSMI(string(output))
+}
+
+func parseNvidiaSMI(output string) ([]GPUInfo, error) {
+	reader := csv.NewReader(strings.NewReader(output))
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("nvidia-smi: unable to parse output: %w", err)
+	}
+
+	gpus := make([]GPUInfo, 0, len(records))
+	for _, record := range records {
+		if len(record) < 8 {
+			return nil, fmt.Errorf("nvidia-smi: expected 8 fields, got %d", len(record))
+		}
+
+		// fields can be "[N/A]" on some devices, those are left at 0
+		atoi := func(s string) int {
+			v, _ := strconv.Atoi(strings.TrimSpace(s))
+			return v
+		}
+		power, _ := strconv.ParseFloat(strings.TrimSpace(record[7]), 64)
+
+		gpus = append(gpus, GPUInfo{
+			Index:       atoi(record[0]),
+			UUID:        strings.TrimSpace(record[1]),
+			Name:        strings.TrimSpace(record[2]),
+			MemoryTotal: atoi(record[3]),
+			MemoryUsed:  atoi(record[4]),
+			MemoryFree:  atoi(record[5]),
+			Temperature: atoi(record[6]),
+			PowerDraw:   power,
+		})
+	}
+	return gpus, nil
+}
+
+// readGPUs reads the GPUs with nvidia-smi, or with rocm-smi on hosts without
+// nvidia-smi
+func readGPUs() ([]GPUInfo, error) {
+	gpus, err := readNvidiaSMI()
+	if errors.Is(err, exec.ErrNotFound) {
+		if rocm, rocmErr := readROCmSMI(); !errors.Is(rocmErr, exec.ErrNotFound) {
+			return rocm, rocmErr
+		}
+	}
+	return gpus, err
+}
+
+// readROCmSMI queries rocm-smi for the memory of AMD GPUs
+func readROCmSMI() ([]GPUInfo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	output, err := exec.CommandContext(ctx, "rocm-smi", "--showmeminfo", "vram", "--json").Output()
+	if err != nil {
+		return nil, fmt.Errorf("rocm-smi: %w", err)
+	}
+	return parseROCmSMI(output)
+}
+
+// parseROCmSMI reads the output of rocm-smi --showmeminfo vram --json, an
+// object of cards like "card0" with their memory in bytes
+func parseROCmSMI(output []byte) ([]GPUInfo, error) {
+	var cards map[string]map[string]string
+	if err := json.Unmarshal(output, &cards); err != nil {
+		return nil, fmt.Errorf("rocm-smi: unable to parse output: %w", err)
+	}
+
+	gpus := make([]GPUInfo, 0, len(cards))
+	for card, fields := range cards {
+		index, err := strconv.Atoi(strings.TrimPrefix(card, "card"))
+		if err != nil {
+			// e.g. the "system" entry
+			continue
+		}
+		mib := func(key string) int {
+			bytes, _ := strconv.ParseInt(strings.TrimSpace(fields[key]), 10, 64)
+			return int(bytes / (1024 * 1024))
+		}
+		total, used := mib("VRAM Total Memory (B)"), mib("VRAM Total Used Memory (B)")
+		gpus = append(gpus, GPUInfo{
+			Index:       index,
+			Name:        card,
+			MemoryTotal: total,
+			MemoryUsed:  used,
+			MemoryFree:  total - used,
+		})
+	}
+	sort.Slice(gpus, func(i, j int) bool { return gpus[i].Index < gpus[j].Index })
+	return gpus, nil
+}
+
+// gpuProcessReader returns the MiB of GPU memory used by each process ID
+type gpuProcessReader func() (map[int]int, error)
+
+// readNvidiaSMIProcesses queries nvidia-smi for the memory used by each
+// process running on a GPU
+func readNvidiaSMIProcesses() (map[int]int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	output, err := exec.CommandContext(ctx, "nvidia-smi",
+		"--query-compute-apps=pid,used_memory",
+		"--format=csv,noheader,nounits",
+	).Output()
+	if err != nil {
+		return nil, fmt.Errorf("nvidia-smi: %w", err)
+	}
+	return parseNvidiaSMIProcesses(string(output))
+}
+
+// parseNvidiaSMIProcesses sums the memory of a process over all its GPUs
+func parseNvidiaSMIProcesses(output string) (map[int]int, error) {
+	reader := csv.NewReader(strings.NewReader(output))
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("nvidia-smi: unable to parse output: %w", err)
+	}
+
+	used := make(map[int]int)
+	for _, record := range records {
+		if len(record) < 2 {
+			return nil, fmt.Errorf("nvidia-smi: expected 2 fields, got %d", len(record))
+		}
+		pid, err := strconv.Atoi(strings.TrimSpace(record[0]))
+		if err != nil {
+			return nil, fmt.Errorf("nvidia-smi: invalid pid %q", record[0])
+		}
+		memory, _ := strconv.Atoi(strings.TrimSpace(record[1]))
+		used[pid] += memory
+	}
+	return used, nil
+}
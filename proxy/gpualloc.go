@@ -0,0 +1,220 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/napmany/llmsnap/proxy/config"
+)
+
+// gpuAllocator places models that use ${GPU} or placement on devices. With
+// declared gpus the free memory is the device's vram less the vramEstimate
+// of the models on it, otherwise it comes from live readings.
+type gpuAllocator struct {
+	mu sync.Mutex
+
+	declared []config.GPUDevice
+	readGPUs gpuReader
+
+	// the current state of a model's process
+	modelState func(modelID string) ProcessState
+
+	models map[string]config.ModelConfig
+
+	// model ID -> devices, kept until the model is started again
+	assigned map[string][]string
+}
+
+// gpuCandidate is a device and how much memory is left on it
+type gpuCandidate struct {
+	device string
+	free   int
+	models int
+}
+
+func newGPUAllocator(declared []config.GPUDevice, readGPUs gpuReader, modelState func(string) ProcessState, models map[string]config.ModelConfig) *gpuAllocator {
+	return &gpuAllocator{
+		declared:   declared,
+		readGPUs:   readGPUs,
+		modelState: modelState,
+		models:     models,
+		assigned:   make(map[string][]string),
+	}
+}
+
+// allocate places modelID on the devices it may use that have the most free
+// memory, as many as its placement asks for. Ties go to devices with fewer
+// models.
+func (a *gpuAllocator) allocate(modelID string) ([]string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	delete(a.assigned, modelID)
+
+	candidates, err := a.candidates()
+	if err != nil {
+		return nil, err
+	}
+
+	placement := a.models[modelID].Placement
+	if len(placement.Devices) > 0 {
+		candidates = slices.DeleteFunc(candidates, func(c gpuCandidate) bool {
+			return !slices.Contains(placement.Devices, c.device)
+		})
+	}
+	count := placement.GPUCount()
+	if len(candidates) < count {
+		return nil, fmt.Errorf("model needs %d GPUs but %d are available to it", count, len(candidates))
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].free != candidates[j].free {
+			return candidates[i].free > candidates[j].free
+		}
+		return candidates[i].models < candidates[j].models
+	})
+
+	devices := make([]string, 0, count)
+	for _, candidate := range candidates[:count] {
+		devices = append(devices, candidate.device)
+	}
+	a.assigned[modelID] = devices
+	return devices, nil
+}
+
+// assignedTo returns the devices processID was last placed on, none when it
+// was not placed
+func (a *gpuAllocator) assignedTo(processID string) []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return slices.Clone(a.assigned[processID])
+}
+
+func (a *gpuAllocator) candidates() ([]gpuCandidate, error) {
+	var candidates []gpuCandidate
+	index := make(map[string]int)
+
+	if len(a.declared) > 0 {
+		for _, device := range a.declared {
+			free := device.VRAMMiB()
+			if free == 0 {
+				// unknown size, only the models on it count
+				free = math.MaxInt32
+			}
+			index[device.Device] = len(candidates)
+			candidates = append(candidates, gpuCandidate{device: device.Device, free: free})
+		}
+	} else {
+		gpus, err := a.readGPUs()
+		if err != nil {
+			return nil, fmt.Errorf("no GPUs declared in gpus and unable to read GPUs: %w", err)
+		}
+		for _, gpu := range gpus {
+			device := strconv.Itoa(gpu.Index)
+			index[device] = len(candidates)
+			candidates = append(candidates, gpuCandidate{device: device, free: gpu.MemoryFree})
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, errors.New("no GPUs found")
+	}
+
+	for modelID, devices := range a.assigned {
+		state := a.modelState(modelID)
+		if !holdsVRAM(state) {
+			continue
+		}
+		// the estimate is spread evenly over the model's devices
+		perDevice := a.models[modelID].VRAMEstimateMiB() / len(devices)
+		for _, device := range devices {
+			i, found := index[device]
+			if !found {
+				continue
+			}
+			candidates[i].models++
+			// live readings already include models that finished loading
+			if len(a.declared) > 0 || state == StateStarting {
+				candidates[i].free -= perDevice
+			}
+		}
+	}
+	return candidates, nil
+}
+
+// GPUStatus is a GPU and the models llmsnap placed on it
+type GPUStatus struct {
+	// Device is the value ${GPU} is replaced with
+	Device string `json:"device"`
+	GPUInfo
+
+	// Models are the model IDs started or running on the device
+	Models []string `json:"models"`
+}
+
+// inventory lists the GPUs with live readings when nvidia-smi is available.
+// Declared devices without a reading report their vram and the vramEstimate
+// of their models instead.
+func (a *gpuAllocator) inventory() ([]GPUStatus, error) {
+	readings, readErr := a.readGPUs()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	occupants := a.occupants()
+	gpus := []GPUStatus{}
+	var err error
+	if len(a.declared) > 0 {
+		for _, device := range a.declared {
+			status := GPUStatus{Device: device.Device, Models: append([]string{}, occupants[device.Device]...)}
+			reading := slices.IndexFunc(readings, func(gpu GPUInfo) bool {
+				return strconv.Itoa(gpu.Index) == device.Device
+			})
+			if reading >= 0 {
+				status.GPUInfo = readings[reading]
+			} else {
+				if status.Index, err = strconv.Atoi(device.Device); err != nil {
+					status.Index = -1
+				}
+				status.MemoryTotal = device.VRAMMiB()
+				for _, modelID := range status.Models {
+					status.MemoryUsed += a.models[modelID].VRAMEstimateMiB() / len(a.assigned[modelID])
+				}
+				status.MemoryFree = max(0, status.MemoryTotal-status.MemoryUsed)
+			}
+			gpus = append(gpus, status)
+		}
+		return gpus, nil
+	}
+
+	if readErr != nil {
+		return nil, fmt.Errorf("no GPUs declared in gpus and unable to read GPUs: %w", readErr)
+	}
+	for _, reading := range readings {
+		device := strconv.Itoa(reading.Index)
+		gpus = append(gpus, GPUStatus{Device: device, GPUInfo: reading, Models: append([]string{}, occupants[device]...)})
+	}
+	return gpus, nil
+}
+
+// occupants maps devices to the sorted IDs of the models that hold memory
+// on them
+func (a *gpuAllocator) occupants() map[string][]string {
+	occupants := make(map[string][]string)
+	for modelID, devices := range a.assigned {
+		if !holdsVRAM(a.modelState(modelID)) {
+			continue
+		}
+		for _, device := range devices {
+			occupants[device] = append(occupants[device], modelID)
+		}
+	}
+	for _, models := range occupants {
+		sort.Strings(models)
+	}
+	return occupants
+}
@@ -0,0 +1,251 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGPUAllocator_Declared(t *testing.T) {
+	states := map[string]ProcessState{}
+	a := newGPUAllocator(
+		[]config.GPUDevice{{Device: "0", VRAM: "24GiB"}, {Device: "1", VRAM: "24GiB"}},
+		nil,
+		func(modelID string) ProcessState { return states[modelID] },
+		map[string]config.ModelConfig{
+			"big":   {VRAMEstimate: "20000MiB"},
+			"small": {VRAMEstimate: "4000MiB"},
+			"other": {VRAMEstimate: "4000MiB"},
+		},
+	)
+
+	devices, err := a.allocate("big")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"0"}, devices)
+	states["big"] = StateReady
+
+	devices, err = a.allocate("small")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1"}, devices, "device 1 has more free memory")
+	states["small"] = StateReady
+
+	// stopped models do not use memory
+	states["big"] = StateStopped
+	devices, err = a.allocate("other")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"0"}, devices)
+}
+
+func TestGPUAllocator_LiveReadings(t *testing.T) {
+	states := map[string]ProcessState{}
+	gpus := []GPUInfo{{Index: 0, MemoryFree: 10000}, {Index: 1, MemoryFree: 12000}}
+	a := newGPUAllocator(
+		nil,
+		func() ([]GPUInfo, error) { return gpus, nil },
+		func(modelID string) ProcessState { return states[modelID] },
+		map[string]config.ModelConfig{
+			"m1": {VRAMEstimate: "8000MiB"},
+			"m2": {VRAMEstimate: "8000MiB"},
+		},
+	)
+
+	devices, err := a.allocate("m1")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1"}, devices)
+
+	// m1 is still loading so its memory is not in the readings yet
+	states["m1"] = StateStarting
+	devices, err = a.allocate("m2")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"0"}, devices)
+
+	a.readGPUs = func() ([]GPUInfo, error) { return nil, errors.New("nvidia-smi: not found") }
+	_, err = a.allocate("m3")
+	assert.ErrorContains(t, err, "nvidia-smi: not found")
+}
+
+func TestGPUAllocator_Placement(t *testing.T) {
+	states := map[string]ProcessState{}
+	a := newGPUAllocator(
+		[]config.GPUDevice{
+			{Device: "0", VRAM: "24GiB"},
+			{Device: "1", VRAM: "24GiB"},
+			{Device: "2", VRAM: "48GiB"},
+		},
+		nil,
+		func(modelID string) ProcessState { return states[modelID] },
+		map[string]config.ModelConfig{
+			"split":  {VRAMEstimate: "40GiB", Placement: config.GPUPlacement{GPUs: 2}},
+			"pinned": {VRAMEstimate: "8GiB", Placement: config.GPUPlacement{Devices: []string{"0", "1"}}},
+			"huge":   {Placement: config.GPUPlacement{GPUs: 4}},
+			"narrow": {Placement: config.GPUPlacement{GPUs: 2, Devices: []string{"1", "9"}}},
+		},
+	)
+
+	devices, err := a.allocate("split")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"2", "0"}, devices)
+	states["split"] = StateReady
+
+	// split uses 20GiB on each of its devices
+	devices, err = a.allocate("pinned")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1"}, devices)
+
+	_, err = a.allocate("huge")
+	assert.ErrorContains(t, err, "model needs 4 GPUs but 3 are available to it")
+
+	_, err = a.allocate("narrow")
+	assert.ErrorContains(t, err, "model needs 2 GPUs but 1 are available to it")
+}
+
+func TestProxyManager_GPUMacro(t *testing.T) {
+	configStr := fmt.Sprintf(`
+logLevel: error
+gpus:
+  - device: "0"
+    vram: 24GiB
+  - device: "1"
+    vram: 24GiB
+groups:
+  all:
+    swap: false
+    members: [model1, model2]
+models:
+  model1:
+    cmd: %s -port ${PORT} -silent -respond gpu-${GPU}
+    vramEstimate: 20GiB
+  model2:
+    cmd: %s -port ${PORT} -silent -respond gpu-${GPU}
+    vramEstimate: 8GiB
+`, getSimpleResponderPath(), getSimpleResponderPath())
+
+	conf, err := config.LoadConfigFromReader(strings.NewReader(configStr))
+	assert.NoError(t, err)
+
+	proxy := New(conf)
+	proxy.readGPUs = nil
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	// model1 goes first and takes most of device 0
+	for _, tc := range []struct{ model, want string }{
+		{"model1", "gpu-0"},
+		{"model2", "gpu-1"},
+	} {
+		req := httptest.NewRequest("GET", "/upstream/"+tc.model+"/test", nil)
+		rec := CreateTestResponseRecorder()
+		proxy.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, tc.want, rec.Body.String())
+	}
+}
+
+func TestProxyManager_GPUPlacement(t *testing.T) {
+	configStr := fmt.Sprintf(`
+logLevel: error
+gpus:
+  - device: "0"
+  - device: "1"
+  - device: "2"
+models:
+  model1:
+    cmd: %s -port ${PORT} -silent -respond gpu-${GPU}
+    placement:
+      gpus: 2
+      devices: ["1", "2"]
+`, getSimpleResponderPath())
+
+	conf, err := config.LoadConfigFromReader(strings.NewReader(configStr))
+	assert.NoError(t, err)
+
+	proxy := New(conf)
+	proxy.readGPUs = nil
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	req := httptest.NewRequest("GET", "/upstream/model1/test", nil)
+	rec := CreateTestResponseRecorder()
+	proxy.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "gpu-1,2", rec.Body.String())
+}
+
+func TestGPUAllocator_Inventory(t *testing.T) {
+	states := map[string]ProcessState{"split": StateReady, "stopped": StateStopped}
+	a := newGPUAllocator(
+		[]config.GPUDevice{{Device: "0", VRAM: "24GiB"}, {Device: "1", VRAM: "24GiB"}},
+		func() ([]GPUInfo, error) {
+			return []GPUInfo{{Index: 1, Name: "RTX", MemoryTotal: 24576, MemoryUsed: 10000, Temperature: 61}}, nil
+		},
+		func(modelID string) ProcessState { return states[modelID] },
+		map[string]config.ModelConfig{"split": {VRAMEstimate: "16GiB"}},
+	)
+	a.assigned["split"] = []string{"0", "1"}
+	a.assigned["stopped"] = []string{"0"}
+
+	gpus, err := a.inventory()
+	assert.NoError(t, err)
+	assert.Equal(t, []GPUStatus{
+		{
+			Device:  "0",
+			GPUInfo: GPUInfo{Index: 0, MemoryTotal: 24576, MemoryUsed: 8192, MemoryFree: 16384},
+			Models:  []string{"split"},
+		},
+		{
+			Device:  "1",
+			GPUInfo: GPUInfo{Index: 1, Name: "RTX", MemoryTotal: 24576, MemoryUsed: 10000, Temperature: 61},
+			Models:  []string{"split"},
+		},
+	}, gpus)
+
+	// without declared devices nvidia-smi is required
+	a.declared = nil
+	a.readGPUs = func() ([]GPUInfo, error) { return nil, errors.New("nvidia-smi: not found") }
+	_, err = a.inventory()
+	assert.ErrorContains(t, err, "nvidia-smi: not found")
+}
+
+func TestProxyManager_APIGetGPUs(t *testing.T) {
+	configStr := fmt.Sprintf(`
+logLevel: error
+gpus:
+  - device: "0"
+    vram: 24GiB
+models:
+  model1:
+    cmd: %s -port ${PORT} -silent -respond gpu-${GPU}
+    vramEstimate: 20GiB
+`, getSimpleResponderPath())
+
+	conf, err := config.LoadConfigFromReader(strings.NewReader(configStr))
+	assert.NoError(t, err)
+
+	proxy := New(conf)
+	proxy.readGPUs = nil
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	req := httptest.NewRequest("GET", "/upstream/model1/test", nil)
+	rec := CreateTestResponseRecorder()
+	proxy.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	req = httptest.NewRequest("GET", "/api/gpus", nil)
+	rec = CreateTestResponseRecorder()
+	proxy.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var gpus []GPUStatus
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &gpus))
+	if assert.Len(t, gpus, 1) {
+		assert.Equal(t, "0", gpus[0].Device)
+		assert.Equal(t, 24576, gpus[0].MemoryTotal)
+		assert.Equal(t, 20480, gpus[0].MemoryUsed)
+		assert.Equal(t, []string{"model1"}, gpus[0].Models)
+	}
+}
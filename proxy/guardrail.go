@@ -0,0 +1,200 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	// tells the client and upstream what the guard model decided, tag mode only
+	guardrailVerdictHeader = "X-Guardrail-Verdict"
+
+	// a verdict is a single word and a list of categories
+	guardrailMaxTokens = 32
+)
+
+type guardrailVerdict struct {
+	safe       bool
+	categories string
+}
+
+// String returns "safe", "unsafe" or "unsafe:<categories>"
+func (v guardrailVerdict) String() string {
+	if v.safe {
+		return "safe"
+	}
+	if v.categories != "" {
+		return "unsafe:" + v.categories
+	}
+	return "unsafe"
+}
+
+// parseGuardVerdict reads a Llama Guard style reply: "safe", or "unsafe"
+// followed by a line with the violated categories
+func parseGuardVerdict(content string) guardrailVerdict {
+	lines := strings.Split(strings.TrimSpace(content), "\n")
+	if !strings.HasPrefix(strings.ToLower(strings.TrimSpace(lines[0])), "unsafe") {
+		return guardrailVerdict{safe: true}
+	}
+
+	verdict := guardrailVerdict{}
+	if len(lines) > 1 {
+		verdict.categories = strings.ReplaceAll(strings.TrimSpace(lines[1]), " ", "")
+	}
+	return verdict
+}
+
+// guardrailApplies returns whether requests for modelID are checked
+func (pm *ProxyManager) guardrailApplies(modelID string) bool {
+	guardrail := pm.config.Guardrail
+	if guardrail.Model == "" {
+		return false
+	}
+	if guardID, _ := pm.realModelName(guardrail.Model); guardID == modelID {
+		return false
+	}
+	if len(guardrail.Models) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(guardrail.Models, func(name string) bool {
+		realName, found := pm.realModelName(name)
+		return found && realName == modelID
+	})
+}
+
+// runGuardrail asks the guard model about the prompt in body. checked is
+// false when the request has no messages or prompt to check.
+func (pm *ProxyManager) runGuardrail(ctx context.Context, body []byte) (verdict guardrailVerdict, checked bool, err error) {
+	messages := gjson.GetBytes(body, "messages")
+	var guardMessages any
+	if messages.IsArray() {
+		guardMessages = json.RawMessage(messages.Raw)
+	} else if prompt := gjson.GetBytes(body, "prompt"); prompt.Exists() {
+		guardMessages = []gin.H{{"role": "user", "content": prompt.String()}}
+	} else {
+		return verdict, false, nil
+	}
+
+	guardID, _ := pm.realModelName(pm.config.Guardrail.Model)
+	guardModelName := guardID
+	if useModelName := pm.config.Models[guardID].UpstreamModelName(pm.config.Guardrail.Model); useModelName != "" {
+		guardModelName = useModelName
+	}
+
+	guardBody, err := json.Marshal(gin.H{
+		"model":       guardModelName,
+		"messages":    guardMessages,
+		"temperature": 0,
+		"max_tokens":  guardrailMaxTokens,
+		"stream":      false,
+	})
+	if err != nil {
+		return verdict, true, err
+	}
+
+	processGroup, err := pm.swapProcessGroup(guardID)
+	if err != nil {
+		return verdict, true, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "/v1/chat/completions", bytes.NewReader(guardBody))
+	if err != nil {
+		return verdict, true, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	w := &bufferedResponseWriter{header: make(http.Header)}
+	if err := processGroup.ProxyRequest(guardID, w, req); err != nil {
+		return verdict, true, err
+	}
+	if w.status != 0 && w.status != http.StatusOK {
+		return verdict, true, fmt.Errorf("guard model %s returned status %d", guardID, w.status)
+	}
+
+	content := gjson.GetBytes(w.body.Bytes(), "choices.0.message.content")
+	if !content.Exists() {
+		return verdict, true, fmt.Errorf("guard model %s returned no message", guardID)
+	}
+	return parseGuardVerdict(content.String()), true, nil
+}
+
+// checkGuardrail runs the guardrail for a request to modelID. It returns
+// false when the request was blocked and a response has been sent.
+func (pm *ProxyManager) checkGuardrail(c *gin.Context, modelID string, body []byte) bool {
+	if !pm.guardrailApplies(modelID) {
+		return true
+	}
+
+	verdict, checked, err := pm.runGuardrail(c.Request.Context(), body)
+	if err != nil {
+		pm.proxyLogger.Errorf("<%s> guardrail check failed: %v", modelID, err)
+		if pm.config.Guardrail.FailOpen {
+			return true
+		}
+		pm.sendErrorResponse(c, http.StatusServiceUnavailable, "guardrail check failed")
+		return false
+	}
+	if !checked {
+		return true
+	}
+
+	// recorded with the request's metrics for the Activity page
+	c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), proxyCtxKey("guardrail"), verdict.String()))
+
+	if pm.config.Guardrail.Action == config.GuardrailTag {
+		c.Header(guardrailVerdictHeader, verdict.String())
+		c.Request.Header.Set(guardrailVerdictHeader, verdict.String())
+		return true
+	}
+
+	if verdict.safe {
+		return true
+	}
+
+	pm.proxyLogger.Infof("<%s> request blocked by guardrail: %s", modelID, verdict)
+	if pm.metricsMonitor != nil {
+		pm.metricsMonitor.addMetrics(TokenMetrics{
+			Timestamp: time.Now(),
+			Model:     modelID,
+			Guardrail: "blocked:" + verdict.String(),
+		})
+	}
+	pm.sendErrorResponse(c, http.StatusBadRequest, fmt.Sprintf("request blocked by guardrail (%s)", verdict))
+	return false
+}
+
+// bufferedResponseWriter keeps a whole response in memory
+type bufferedResponseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *bufferedResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *bufferedResponseWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(data)
+}
+
+func (w *bufferedResponseWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+}
+
+func (w *bufferedResponseWriter) Flush() {}
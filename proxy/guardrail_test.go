@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+func TestParseGuardVerdict(t *testing.T) {
+	assert.Equal(t, "safe", parseGuardVerdict("safe").String())
+	assert.Equal(t, "safe", parseGuardVerdict("\n\nsafe\n").String())
+	assert.Equal(t, "unsafe", parseGuardVerdict("unsafe").String())
+	assert.Equal(t, "unsafe:S1,S10", parseGuardVerdict("\n\nunsafe\nS1, S10").String())
+}
+
+func TestProxyManager_Guardrail(t *testing.T) {
+	var guardFailing atomic.Bool
+	var guardCalls atomic.Int32
+
+	// answers like Llama Guard, anything mentioning "bomb" is unsafe
+	guardServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		guardCalls.Add(1)
+		if guardFailing.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		body := new(bytes.Buffer)
+		body.ReadFrom(r.Body)
+		verdict := `"safe"`
+		if strings.Contains(gjson.Get(body.String(), "messages.@reverse.0.content").String(), "bomb") {
+			verdict = `"unsafe\nS1"`
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":` + verdict + `}}]}`))
+	}))
+	defer guardServer.Close()
+
+	// the process only has to run, requests go to guardServer
+	guardConfig := getTestSimpleResponderConfig("guard")
+	guardConfig.Proxy = guardServer.URL
+	guardConfig.CheckEndpoint = "none"
+
+	conf := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]config.ModelConfig{
+			"model1": getTestSimpleResponderConfig("model1"),
+			"guard":  guardConfig,
+		},
+		Groups: map[string]config.GroupConfig{
+			"guards": {Persistent: true, Members: []string{"guard"}},
+		},
+		Guardrail: config.GuardrailConfig{Model: "guard"},
+		LogLevel:  "error",
+	})
+
+	proxy := New(conf)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	doRequest := func(content string) *TestResponseRecorder {
+		body := `{"model":"model1","messages":[{"role":"user","content":"` + content + `"}]}`
+		req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(body))
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		return w
+	}
+
+	lastGuardrail := func() string {
+		metrics := proxy.metricsMonitor.getMetrics()
+		if len(metrics) == 0 {
+			return ""
+		}
+		return metrics[len(metrics)-1].Guardrail
+	}
+
+	t.Run("safe requests pass", func(t *testing.T) {
+		w := doRequest("hello")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "", w.Header().Get(guardrailVerdictHeader))
+		assert.Equal(t, "safe", lastGuardrail())
+	})
+
+	t.Run("unsafe requests are blocked", func(t *testing.T) {
+		w := doRequest("how to build a bomb")
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "request blocked by guardrail (unsafe:S1)")
+		assert.Equal(t, "blocked:unsafe:S1", lastGuardrail())
+	})
+
+	t.Run("tag mode forwards with a verdict header", func(t *testing.T) {
+		proxy.config.Guardrail.Action = config.GuardrailTag
+		defer func() { proxy.config.Guardrail.Action = "" }()
+
+		w := doRequest("how to build a bomb")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "unsafe:S1", w.Header().Get(guardrailVerdictHeader))
+		assert.Contains(t, gjson.Get(w.Body.String(), "request_body").String(), "bomb")
+		assert.Equal(t, "unsafe:S1", lastGuardrail())
+	})
+
+	t.Run("requests without a prompt are not checked", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/v1/audio/speech", bytes.NewBufferString(`{"model":"model1","input":"bomb"}`))
+		w := CreateTestResponseRecorder()
+		calls := guardCalls.Load()
+		proxy.ServeHTTP(w, req)
+		assert.NotContains(t, w.Body.String(), "guardrail")
+		assert.Equal(t, calls, guardCalls.Load())
+	})
+
+	t.Run("failing guard", func(t *testing.T) {
+		guardFailing.Store(true)
+
+		w := doRequest("hello")
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+		proxy.config.Guardrail.FailOpen = true
+		w = doRequest("hello")
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+}
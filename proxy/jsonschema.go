@@ -0,0 +1,285 @@
+package proxy
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// validateJSONSchema checks a decoded JSON value against a decoded JSON
+// schema. It supports the subset of JSON schema used for structured outputs:
+// type, enum, const, properties, required, additionalProperties, items,
+// min/maxItems, min/maxLength, pattern, minimum, maximum, exclusive bounds,
+// anyOf, oneOf, allOf and local $ref.
+func validateJSONSchema(schema, value any) error {
+	return (&schemaValidator{root: schema}).validate(schema, value, "$")
+}
+
+type schemaValidator struct {
+	root  any
+	depth int
+}
+
+func (v *schemaValidator) validate(schemaValue, value any, path string) error {
+	schema, ok := schemaValue.(map[string]any)
+	if !ok {
+		// true, or an empty schema, accepts everything
+		if b, isBool := schemaValue.(bool); isBool && !b {
+			return fmt.Errorf("%s: no value is allowed", path)
+		}
+		return nil
+	}
+
+	if ref, ok := schema["$ref"].(string); ok {
+		// guards against recursive references
+		if v.depth > 64 {
+			return fmt.Errorf("%s: schema is nested too deeply", path)
+		}
+		target, err := v.resolveRef(ref)
+		if err != nil {
+			return err
+		}
+		v.depth++
+		defer func() { v.depth-- }()
+		return v.validate(target, value, path)
+	}
+
+	if types, ok := schema["type"]; ok && !matchesSchemaType(types, value) {
+		return fmt.Errorf("%s: expected %s, got %s", path, describeSchemaType(types), jsonTypeOf(value))
+	}
+
+	if enum, ok := schema["enum"].([]any); ok {
+		found := false
+		for _, option := range enum {
+			if reflect.DeepEqual(option, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: value is not one of the allowed values", path)
+		}
+	}
+
+	if constValue, ok := schema["const"]; ok && !reflect.DeepEqual(constValue, value) {
+		return fmt.Errorf("%s: value must be %v", path, constValue)
+	}
+
+	switch value := value.(type) {
+	case map[string]any:
+		if err := v.validateObject(schema, value, path); err != nil {
+			return err
+		}
+	case []any:
+		if err := v.validateArray(schema, value, path); err != nil {
+			return err
+		}
+	case string:
+		length := utf8.RuneCountInString(value)
+		if min, ok := schemaNumber(schema, "minLength"); ok && float64(length) < min {
+			return fmt.Errorf("%s: string is shorter than %v characters", path, min)
+		}
+		if max, ok := schemaNumber(schema, "maxLength"); ok && float64(length) > max {
+			return fmt.Errorf("%s: string is longer than %v characters", path, max)
+		}
+		if pattern, ok := schema["pattern"].(string); ok {
+			re, err := regexp.Compile(pattern)
+			if err == nil && !re.MatchString(value) {
+				return fmt.Errorf("%s: string does not match pattern %s", path, pattern)
+			}
+		}
+	case float64:
+		if min, ok := schemaNumber(schema, "minimum"); ok && value < min {
+			return fmt.Errorf("%s: %v is less than the minimum %v", path, value, min)
+		}
+		if max, ok := schemaNumber(schema, "maximum"); ok && value > max {
+			return fmt.Errorf("%s: %v is greater than the maximum %v", path, value, max)
+		}
+		if min, ok := schemaNumber(schema, "exclusiveMinimum"); ok && value <= min {
+			return fmt.Errorf("%s: %v must be greater than %v", path, value, min)
+		}
+		if max, ok := schemaNumber(schema, "exclusiveMaximum"); ok && value >= max {
+			return fmt.Errorf("%s: %v must be less than %v", path, value, max)
+		}
+	}
+
+	if allOf, ok := schema["allOf"].([]any); ok {
+		for _, sub := range allOf {
+			if err := v.validate(sub, value, path); err != nil {
+				return err
+			}
+		}
+	}
+
+	if anyOf, ok := schema["anyOf"].([]any); ok {
+		var firstErr error
+		for _, sub := range anyOf {
+			err := v.validate(sub, value, path)
+			if err == nil {
+				firstErr = nil
+				break
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		if firstErr != nil {
+			return fmt.Errorf("%s: value does not match any of the allowed schemas (%v)", path, firstErr)
+		}
+	}
+
+	if oneOf, ok := schema["oneOf"].([]any); ok {
+		matches := 0
+		for _, sub := range oneOf {
+			if v.validate(sub, value, path) == nil {
+				matches++
+			}
+		}
+		if matches != 1 {
+			return fmt.Errorf("%s: value must match exactly one schema, matched %d", path, matches)
+		}
+	}
+
+	return nil
+}
+
+func (v *schemaValidator) validateObject(schema map[string]any, value map[string]any, path string) error {
+	if required, ok := schema["required"].([]any); ok {
+		for _, name := range required {
+			if name, ok := name.(string); ok {
+				if _, found := value[name]; !found {
+					return fmt.Errorf("%s: missing required property %q", path, name)
+				}
+			}
+		}
+	}
+
+	properties, _ := schema["properties"].(map[string]any)
+	for name, propertyValue := range value {
+		propertyPath := path + "." + name
+		if propertySchema, found := properties[name]; found {
+			if err := v.validate(propertySchema, propertyValue, propertyPath); err != nil {
+				return err
+			}
+			continue
+		}
+
+		switch additional := schema["additionalProperties"].(type) {
+		case bool:
+			if !additional {
+				return fmt.Errorf("%s: property %q is not allowed", path, name)
+			}
+		case map[string]any:
+			if err := v.validate(additional, propertyValue, propertyPath); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (v *schemaValidator) validateArray(schema map[string]any, value []any, path string) error {
+	if min, ok := schemaNumber(schema, "minItems"); ok && float64(len(value)) < min {
+		return fmt.Errorf("%s: array has fewer than %v items", path, min)
+	}
+	if max, ok := schemaNumber(schema, "maxItems"); ok && float64(len(value)) > max {
+		return fmt.Errorf("%s: array has more than %v items", path, max)
+	}
+	if items, ok := schema["items"]; ok {
+		for i, item := range value {
+			if err := v.validate(items, item, path+"["+strconv.Itoa(i)+"]"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resolveRef follows a local reference like #/$defs/address
+func (v *schemaValidator) resolveRef(ref string) (any, error) {
+	if ref != "#" && !strings.HasPrefix(ref, "#/") {
+		return nil, fmt.Errorf("unsupported $ref %s, only local references are supported", ref)
+	}
+
+	current := v.root
+	for _, part := range strings.Split(strings.TrimPrefix(strings.TrimPrefix(ref, "#"), "/"), "/") {
+		if part == "" {
+			continue
+		}
+		part = strings.ReplaceAll(strings.ReplaceAll(part, "~1", "/"), "~0", "~")
+		object, ok := current.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("$ref %s not found", ref)
+		}
+		if current, ok = object[part]; !ok {
+			return nil, fmt.Errorf("$ref %s not found", ref)
+		}
+	}
+	return current, nil
+}
+
+func schemaNumber(schema map[string]any, key string) (float64, bool) {
+	n, ok := schema[key].(float64)
+	return n, ok
+}
+
+func matchesSchemaType(types any, value any) bool {
+	switch types := types.(type) {
+	case string:
+		return matchesType(types, value)
+	case []any:
+		for _, t := range types {
+			if t, ok := t.(string); ok && matchesType(t, value) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func matchesType(t string, value any) bool {
+	switch t {
+	case "integer":
+		n, ok := value.(float64)
+		return ok && n == math.Trunc(n)
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	default:
+		return jsonTypeOf(value) == t
+	}
+}
+
+func describeSchemaType(types any) string {
+	if list, ok := types.([]any); ok {
+		names := make([]string, 0, len(list))
+		for _, t := range list {
+			names = append(names, fmt.Sprint(t))
+		}
+		return strings.Join(names, " or ")
+	}
+	return fmt.Sprint(types)
+}
+
+func jsonTypeOf(value any) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return "unknown"
+}
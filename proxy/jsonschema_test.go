@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateJSONSchema(t *testing.T) {
+	schema := `{
+		"type": "object",
+		"properties": {
+			"name": {"type": "string", "minLength": 1},
+			"age": {"type": "integer", "minimum": 0},
+			"role": {"enum": ["admin", "user"]},
+			"tags": {"type": "array", "items": {"type": "string"}, "maxItems": 2},
+			"address": {"$ref": "#/$defs/address"},
+			"nickname": {"type": ["string", "null"]}
+		},
+		"required": ["name", "age"],
+		"additionalProperties": false,
+		"$defs": {
+			"address": {
+				"type": "object",
+				"properties": {"zip": {"type": "string", "pattern": "^[0-9]{5}$"}},
+				"required": ["zip"]
+			}
+		}
+	}`
+
+	tests := []struct {
+		name  string
+		value string
+		err   string
+	}{
+		{"valid", `{"name":"ann","age":30,"role":"admin","tags":["a"],"address":{"zip":"12345"},"nickname":null}`, ""},
+		{"wrong type", `["ann"]`, "$: expected object, got array"},
+		{"missing required", `{"name":"ann"}`, `$: missing required property "age"`},
+		{"not an integer", `{"name":"ann","age":1.5}`, "$.age: expected integer, got number"},
+		{"below minimum", `{"name":"ann","age":-1}`, "$.age: -1 is less than the minimum 0"},
+		{"empty string", `{"name":"","age":1}`, "$.name: string is shorter than 1 characters"},
+		{"not in enum", `{"name":"ann","age":1,"role":"root"}`, "$.role: value is not one of the allowed values"},
+		{"too many items", `{"name":"ann","age":1,"tags":["a","b","c"]}`, "$.tags: array has more than 2 items"},
+		{"item type", `{"name":"ann","age":1,"tags":[1]}`, "$.tags[0]: expected string, got number"},
+		{"ref", `{"name":"ann","age":1,"address":{"zip":"abc"}}`, "$.address.zip: string does not match pattern"},
+		{"additional property", `{"name":"ann","age":1,"extra":true}`, `$: property "extra" is not allowed`},
+		{"type list", `{"name":"ann","age":1,"nickname":3}`, "$.nickname: expected string or null, got number"},
+	}
+
+	var decodedSchema any
+	assert.NoError(t, json.Unmarshal([]byte(schema), &decodedSchema))
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var value any
+			assert.NoError(t, json.Unmarshal([]byte(tt.value), &value))
+			err := validateJSONSchema(decodedSchema, value)
+			if tt.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.err)
+			}
+		})
+	}
+
+	t.Run("anyOf and oneOf", func(t *testing.T) {
+		var schema any
+		json.Unmarshal([]byte(`{"anyOf":[{"type":"string"},{"type":"number"}],"oneOf":[{"type":"number"},{"minimum":0}]}`), &schema)
+		assert.NoError(t, validateJSONSchema(schema, -1.0))
+		assert.ErrorContains(t, validateJSONSchema(schema, 1.0), "must match exactly one schema, matched 2")
+		assert.ErrorContains(t, validateJSONSchema(schema, true), "does not match any of the allowed schemas")
+	})
+
+	t.Run("recursive ref", func(t *testing.T) {
+		var schema any
+		json.Unmarshal([]byte(`{"$ref":"#"}`), &schema)
+		assert.ErrorContains(t, validateJSONSchema(schema, 1.0), "nested too deeply")
+	})
+}
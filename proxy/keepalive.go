@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// parseKeepAlive reads the keep_alive of an Ollama request: a duration like
+// "10m", a number of seconds, or a negative value to keep the model loaded
+func parseKeepAlive(value gjson.Result) (time.Duration, bool) {
+	switch value.Type {
+	case gjson.Number:
+		return time.Duration(value.Float() * float64(time.Second)), true
+	case gjson.String:
+		if seconds, err := strconv.ParseFloat(value.Str, 64); err == nil {
+			return time.Duration(seconds * float64(time.Second)), true
+		}
+		if d, err := time.ParseDuration(value.Str); err == nil {
+			return d, true
+		}
+	}
+	return 0, false
+}
+
+// setKeepAlive sets the TTL of the process from a request's keep_alive, a
+// negative value keeps the model loaded and 0 restores the configured TTL
+func (p *Process) setKeepAlive(keepAlive time.Duration) {
+	switch {
+	case keepAlive < 0:
+		p.keepAlive.Store(-1)
+	case keepAlive == 0:
+		p.keepAlive.Store(0)
+	default:
+		p.keepAlive.Store(int64(math.Ceil(keepAlive.Seconds())))
+	}
+
+	if p.CurrentState() == StateReady {
+		p.startUnloadMonitoring()
+	}
+}
+
+// applyKeepAlive lets Ollama clients decide how long the model stays loaded.
+// The keep_alive of a request sets the TTL until the next request, one
+// without it restores the configured TTL. It returns true for a keep_alive of
+// 0 as the model is unloaded once the request is done, like Ollama does.
+func (pm *ProxyManager) applyKeepAlive(processGroup *ProcessGroup, modelID string, body []byte) (unload bool) {
+	process, found := processGroup.GetMember(modelID)
+	if !found {
+		return false
+	}
+
+	keepAlive, ok := parseKeepAlive(gjson.GetBytes(body, "keep_alive"))
+	if !ok {
+		process.setKeepAlive(0)
+		return false
+	}
+	if keepAlive == 0 {
+		return true
+	}
+
+	pm.proxyLogger.Debugf("<%s> keep_alive of %s sets the ttl", modelID, keepAlive)
+	process.setKeepAlive(keepAlive)
+	return false
+}
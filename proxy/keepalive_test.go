@@ -0,0 +1,112 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+func TestParseKeepAlive(t *testing.T) {
+	tests := []struct {
+		body string
+		want time.Duration
+		ok   bool
+	}{
+		{`{"keep_alive":"10m"}`, 10 * time.Minute, true},
+		{`{"keep_alive":"1h30m"}`, 90 * time.Minute, true},
+		{`{"keep_alive":300}`, 300 * time.Second, true},
+		{`{"keep_alive":"300"}`, 300 * time.Second, true},
+		{`{"keep_alive":0}`, 0, true},
+		{`{"keep_alive":"0s"}`, 0, true},
+		{`{"keep_alive":-1}`, -time.Second, true},
+		{`{"keep_alive":"-1m"}`, -time.Minute, true},
+		{`{"keep_alive":"forever"}`, 0, false},
+		{`{"keep_alive":true}`, 0, false},
+		{`{}`, 0, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := parseKeepAlive(gjson.Get(tt.body, "keep_alive"))
+		assert.Equal(t, tt.ok, ok, tt.body)
+		assert.Equal(t, tt.want, got, tt.body)
+	}
+}
+
+func TestProcess_KeepAliveOverridesTTL(t *testing.T) {
+	process := NewProcess("keepalive", 5, config.ModelConfig{UnloadAfter: 60}, testLogger, testLogger)
+	assert.Equal(t, 60, process.unloadAfter())
+
+	process.setKeepAlive(90 * time.Second)
+	assert.Equal(t, 90, process.unloadAfter())
+
+	process.setKeepAlive(1500 * time.Millisecond)
+	assert.Equal(t, 2, process.unloadAfter())
+
+	process.setKeepAlive(-1)
+	assert.Equal(t, 0, process.unloadAfter(), "a negative keep_alive never unloads")
+
+	process.setKeepAlive(0)
+	assert.Equal(t, 60, process.unloadAfter())
+}
+
+func TestProxyManager_KeepAliveZeroUnloads(t *testing.T) {
+	conf := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]config.ModelConfig{
+			"model1": getTestSimpleResponderConfig("model1"),
+		},
+		LogLevel: "error",
+	})
+
+	proxy := New(conf)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model":"model1"}`))
+	w := CreateTestResponseRecorder()
+	proxy.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, StateReady, proxy.modelState("model1"))
+
+	req = httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model":"model1","keep_alive":0}`))
+	w = CreateTestResponseRecorder()
+	proxy.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Eventually(t, func() bool {
+		return proxy.modelState("model1") == StateStopped
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestProxyManager_KeepAliveSetsTTL(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping slow keep_alive test")
+	}
+
+	conf := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]config.ModelConfig{
+			"model1": getTestSimpleResponderConfig("model1"),
+		},
+		LogLevel: "error",
+	})
+
+	proxy := New(conf)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	// the model has no ttl, keep_alive unloads it
+	req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model":"model1","keep_alive":"1s"}`))
+	w := CreateTestResponseRecorder()
+	proxy.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, StateReady, proxy.modelState("model1"))
+
+	assert.Eventually(t, func() bool {
+		return proxy.modelState("model1") == StateStopped
+	}, 5*time.Second, 100*time.Millisecond)
+}
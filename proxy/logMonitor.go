@@ -138,7 +138,11 @@ func (w *LogMonitor) Write(p []byte) (n int, err error) {
 		return 0, nil
 	}
 
-	n, err = w.stdout.Write(p)
+	w.mu.RLock()
+	stdout := w.stdout
+	w.mu.RUnlock()
+
+	n, err = stdout.Write(p)
 	if err != nil {
 		return n, err
 	}
@@ -174,16 +178,26 @@ func (w *LogMonitor) Clear() {
 	w.bufferMu.Unlock()
 }
 
+// OnLogData calls callback with new log data. Log data is dropped for a
+// callback that can not keep up so a slow reader never blocks logging.
 func (w *LogMonitor) OnLogData(callback func(data []byte)) context.CancelFunc {
-	return event.Subscribe(w.eventbus, func(e LogDataEvent) {
+	return event.SubscribeWithOptions(w.eventbus, func(e LogDataEvent) {
 		callback(e.Data)
-	})
+	}, event.Options[LogDataEvent]{MaxQueue: uiEventQueueSize, Overflow: event.OverflowDrop})
 }
 
 func (w *LogMonitor) broadcast(msg []byte) {
 	event.Publish(w.eventbus, LogDataEvent{Data: msg})
 }
 
+// setOutput replaces the writer logs are copied to, the history and the
+// OnLogData callbacks are kept
+func (w *LogMonitor) setOutput(stdout io.Writer) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.stdout = stdout
+}
+
 func (w *LogMonitor) SetPrefix(prefix string) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
@@ -215,10 +229,15 @@ func (w *LogMonitor) formatMessage(level string, msg string) []byte {
 }
 
 func (w *LogMonitor) log(level LogLevel, msg string) {
+	// the level and format change when the config is reloaded
+	w.mu.RLock()
 	if level < w.level {
+		w.mu.RUnlock()
 		return
 	}
-	w.Write(w.formatMessage(level.String(), msg))
+	data := w.formatMessage(level.String(), msg)
+	w.mu.RUnlock()
+	w.Write(data)
 }
 
 func (w *LogMonitor) Debug(msg string) {
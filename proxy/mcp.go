@@ -0,0 +1,259 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+// the MCP protocol versions the /mcp endpoint speaks, newest first
+var mcpProtocolVersions = []string{"2025-06-18", "2025-03-26", "2024-11-05"}
+
+// JSON-RPC 2.0 error codes
+const (
+	jsonrpcParseError     = -32700
+	jsonrpcInvalidRequest = -32600
+	jsonrpcMethodNotFound = -32601
+	jsonrpcInvalidParams  = -32602
+)
+
+type jsonrpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type jsonrpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *jsonrpcError   `json:"error,omitempty"`
+}
+
+type jsonrpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type mcpTool struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	InputSchema map[string]any `json:"inputSchema"`
+}
+
+// mcpModelArgument is the input schema of tools that take a model name
+func mcpModelArgument(required bool, description string) map[string]any {
+	schema := map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"model": map[string]any{"type": "string", "description": description},
+		},
+	}
+	if required {
+		schema["required"] = []string{"model"}
+	}
+	return schema
+}
+
+var mcpTools = []mcpTool{
+	{
+		Name:        "list_models",
+		Description: "List the configured models and their current state (ready, starting, stopped, asleep, ...).",
+		InputSchema: map[string]any{"type": "object", "properties": map[string]any{}},
+	},
+	{
+		Name:        "load_model",
+		Description: "Load a model and wait until it is ready. Other models may be unloaded according to the group configuration.",
+		InputSchema: mcpModelArgument(true, "model ID or alias to load"),
+	},
+	{
+		Name:        "unload_model",
+		Description: "Unload a model. Unloads all models when no model is given.",
+		InputSchema: mcpModelArgument(false, "model ID or alias to unload"),
+	},
+	{
+		Name:        "get_activity",
+		Description: "Summarize recent requests per model: request count, tokens and average speeds.",
+		InputSchema: mcpModelArgument(false, "only summarize this model"),
+	},
+}
+
+// mcpHandler serves the MCP streamable HTTP transport. Every request is
+// answered with a single JSON response, no server initiated streams are
+// offered.
+func (pm *ProxyManager) mcpHandler(c *gin.Context) {
+	if c.Request.Method != http.MethodPost {
+		c.Header("Allow", http.MethodPost)
+		c.Status(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req jsonrpcRequest
+	if err := json.NewDecoder(c.Request.Body).Decode(&req); err != nil {
+		c.JSON(http.StatusBadRequest, jsonrpcResponse{
+			JSONRPC: "2.0",
+			ID:      json.RawMessage("null"),
+			Error:   &jsonrpcError{Code: jsonrpcParseError, Message: "invalid JSON-RPC request"},
+		})
+		return
+	}
+
+	// notifications and responses from the client need no answer
+	if len(req.ID) == 0 {
+		c.Status(http.StatusAccepted)
+		return
+	}
+
+	resp := jsonrpcResponse{JSONRPC: "2.0", ID: req.ID}
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		resp.Error = &jsonrpcError{Code: jsonrpcInvalidRequest, Message: "invalid JSON-RPC request"}
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	switch req.Method {
+	case "initialize":
+		var params struct {
+			ProtocolVersion string `json:"protocolVersion"`
+		}
+		json.Unmarshal(req.Params, &params)
+		version := mcpProtocolVersions[0]
+		if slices.Contains(mcpProtocolVersions, params.ProtocolVersion) {
+			version = params.ProtocolVersion
+		}
+		resp.Result = gin.H{
+			"protocolVersion": version,
+			"capabilities":    gin.H{"tools": gin.H{}},
+			"serverInfo":      gin.H{"name": "llmsnap", "version": pm.version},
+		}
+	case "ping":
+		resp.Result = gin.H{}
+	case "tools/list":
+		resp.Result = gin.H{"tools": mcpTools}
+	case "tools/call":
+		var params struct {
+			Name      string            `json:"name"`
+			Arguments map[string]string `json:"arguments"`
+		}
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			resp.Error = &jsonrpcError{Code: jsonrpcInvalidParams, Message: "invalid tools/call params"}
+			break
+		}
+		result, err := pm.mcpCallTool(params.Name, params.Arguments)
+		if errors.Is(err, errMCPUnknownTool) {
+			resp.Error = &jsonrpcError{Code: jsonrpcInvalidParams, Message: "unknown tool: " + params.Name}
+			break
+		}
+		resp.Result = mcpToolResult(result, err)
+	default:
+		resp.Error = &jsonrpcError{Code: jsonrpcMethodNotFound, Message: "method not found: " + req.Method}
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+var errMCPUnknownTool = errors.New("unknown tool")
+
+// mcpToolResult wraps a tool's result, or error, as MCP text content
+func mcpToolResult(result any, err error) gin.H {
+	if err != nil {
+		return gin.H{
+			"content": []gin.H{{"type": "text", "text": err.Error()}},
+			"isError": true,
+		}
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		return mcpToolResult(nil, err)
+	}
+	return gin.H{
+		"content": []gin.H{{"type": "text", "text": string(data)}},
+		"isError": false,
+	}
+}
+
+func (pm *ProxyManager) mcpCallTool(name string, args map[string]string) (any, error) {
+	switch name {
+	case "list_models":
+		return pm.getModelStatus(), nil
+
+	case "load_model":
+		modelID, err := pm.loadModel(args["model"])
+		if err != nil {
+			return nil, err
+		}
+		return gin.H{"model": modelID, "state": "ready"}, nil
+
+	case "unload_model":
+		if args["model"] == "" {
+			pm.StopProcesses(StopImmediately)
+			return gin.H{"unloaded": "all"}, nil
+		}
+		modelID, err := pm.unloadModel(args["model"])
+		if err != nil {
+			return nil, err
+		}
+		return gin.H{"unloaded": modelID}, nil
+
+	case "get_activity":
+		filter := ""
+		if args["model"] != "" {
+			modelID, found := pm.realModelName(args["model"])
+			if !found {
+				return nil, fmt.Errorf("model not found: %s", args["model"])
+			}
+			filter = modelID
+		}
+		return summarizeActivity(pm.metricsMonitor.getMetrics(), filter), nil
+	}
+
+	return nil, errMCPUnknownTool
+}
+
+type modelActivity struct {
+	Model              string  `json:"model"`
+	Requests           int     `json:"requests"`
+	InputTokens        int     `json:"input_tokens"`
+	OutputTokens       int     `json:"output_tokens"`
+	AvgPromptPerSecond float64 `json:"avg_prompt_per_second"`
+	AvgTokensPerSecond float64 `json:"avg_tokens_per_second"`
+	AvgDurationMs      int     `json:"avg_duration_ms"`
+}
+
+// summarizeActivity aggregates metrics per model, sorted by model
+func summarizeActivity(metrics []TokenMetrics, model string) []modelActivity {
+	byModel := make(map[string]*modelActivity)
+	for _, m := range metrics {
+		if model != "" && m.Model != model {
+			continue
+		}
+		a, found := byModel[m.Model]
+		if !found {
+			a = &modelActivity{Model: m.Model}
+			byModel[m.Model] = a
+		}
+		a.Requests++
+		a.InputTokens += m.InputTokens
+		a.OutputTokens += m.OutputTokens
+		a.AvgPromptPerSecond += m.PromptPerSecond
+		a.AvgTokensPerSecond += m.TokensPerSecond
+		a.AvgDurationMs += m.DurationMs
+	}
+
+	result := make([]modelActivity, 0, len(byModel))
+	for _, a := range byModel {
+		a.AvgPromptPerSecond /= float64(a.Requests)
+		a.AvgTokensPerSecond /= float64(a.Requests)
+		a.AvgDurationMs /= a.Requests
+		result = append(result, *a)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Model < result[j].Model })
+	return result
+}
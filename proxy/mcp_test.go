@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+func TestProxyManager_MCP(t *testing.T) {
+	conf := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]config.ModelConfig{
+			"model1": getTestSimpleResponderConfig("model1"),
+		},
+		LogLevel: "error",
+	})
+
+	proxy := New(conf)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	call := func(body string) *TestResponseRecorder {
+		req := httptest.NewRequest("POST", "/mcp", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("initialize", func(t *testing.T) {
+		w := call(`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"protocolVersion":"2025-03-26"}}`)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "2025-03-26", gjson.Get(w.Body.String(), "result.protocolVersion").String())
+		assert.Equal(t, "llmsnap", gjson.Get(w.Body.String(), "result.serverInfo.name").String())
+	})
+
+	t.Run("notifications are accepted", func(t *testing.T) {
+		w := call(`{"jsonrpc":"2.0","method":"notifications/initialized"}`)
+		assert.Equal(t, http.StatusAccepted, w.Code)
+	})
+
+	t.Run("tools/list", func(t *testing.T) {
+		w := call(`{"jsonrpc":"2.0","id":2,"method":"tools/list"}`)
+		names := gjson.Get(w.Body.String(), "result.tools.#.name").Array()
+		assert.Len(t, names, len(mcpTools))
+	})
+
+	t.Run("load and list models", func(t *testing.T) {
+		w := call(`{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"load_model","arguments":{"model":"model1"}}}`)
+		assert.False(t, gjson.Get(w.Body.String(), "result.isError").Bool(), w.Body.String())
+
+		w = call(`{"jsonrpc":"2.0","id":4,"method":"tools/call","params":{"name":"list_models"}}`)
+		text := gjson.Get(w.Body.String(), "result.content.0.text").String()
+		assert.Equal(t, "ready", gjson.Get(text, `#(id=="model1").state`).String())
+
+		w = call(`{"jsonrpc":"2.0","id":5,"method":"tools/call","params":{"name":"unload_model","arguments":{"model":"model1"}}}`)
+		assert.False(t, gjson.Get(w.Body.String(), "result.isError").Bool(), w.Body.String())
+		process, _ := proxy.processGroups[config.DEFAULT_GROUP_ID].GetMember("model1")
+		assert.Equal(t, StateStopped, process.CurrentState())
+	})
+
+	t.Run("tool errors", func(t *testing.T) {
+		w := call(`{"jsonrpc":"2.0","id":6,"method":"tools/call","params":{"name":"load_model","arguments":{"model":"nope"}}}`)
+		assert.True(t, gjson.Get(w.Body.String(), "result.isError").Bool())
+
+		w = call(`{"jsonrpc":"2.0","id":7,"method":"tools/call","params":{"name":"nope"}}`)
+		assert.Equal(t, int64(jsonrpcInvalidParams), gjson.Get(w.Body.String(), "error.code").Int())
+	})
+
+	t.Run("unknown method", func(t *testing.T) {
+		w := call(`{"jsonrpc":"2.0","id":8,"method":"resources/list"}`)
+		assert.Equal(t, int64(jsonrpcMethodNotFound), gjson.Get(w.Body.String(), "error.code").Int())
+		assert.Equal(t, int64(8), gjson.Get(w.Body.String(), "id").Int())
+	})
+
+	t.Run("GET is not supported", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/mcp", nil)
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	})
+}
+
+func TestSummarizeActivity(t *testing.T) {
+	metrics := []TokenMetrics{
+		{Model: "b", InputTokens: 10, OutputTokens: 20, TokensPerSecond: 10, DurationMs: 100},
+		{Model: "a", InputTokens: 5, OutputTokens: 5, TokensPerSecond: 30, DurationMs: 300},
+		{Model: "b", InputTokens: 10, OutputTokens: 40, TokensPerSecond: 20, DurationMs: 200},
+	}
+
+	activity := summarizeActivity(metrics, "")
+	if assert.Len(t, activity, 2) {
+		assert.Equal(t, "a", activity[0].Model)
+		assert.Equal(t, modelActivity{
+			Model: "b", Requests: 2, InputTokens: 20, OutputTokens: 60,
+			AvgTokensPerSecond: 15, AvgDurationMs: 150,
+		}, activity[1])
+	}
+
+	assert.Len(t, summarizeActivity(metrics, "a"), 1)
+}
@@ -0,0 +1,349 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// DNS-SD service type clients browse for
+	mdnsService = "_llmsnap._tcp.local."
+
+	// lists the service types on the network, see RFC 6763 section 9
+	mdnsServiceEnumeration = "_services._dns-sd._udp.local."
+
+	// ttl of the advertised records in seconds
+	mdnsTTL = 120
+
+	// a TXT string holds at most 255 bytes
+	mdnsMaxTXT = 255
+
+	// set in the class of records that replace earlier ones and in the class
+	// of questions that ask for a unicast reply
+	mdnsCacheFlush = 1 << 15
+)
+
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+// mdnsResponder answers mDNS queries for the llmsnap service
+type mdnsResponder struct {
+	service  dnsmessage.Name
+	instance dnsmessage.Name
+	host     dnsmessage.Name
+	port     uint16
+	ips      []net.IP
+	txt      []string
+}
+
+// AdvertiseMDNS announces the server listening on listenAddr on the local
+// network until Shutdown. It does nothing unless mdns.enabled is set.
+func (pm *ProxyManager) AdvertiseMDNS(listenAddr string, useTLS bool) {
+	if !pm.config.MDNS.Enabled {
+		return
+	}
+
+	responder, err := pm.newMDNSResponder(listenAddr, useTLS)
+	if err != nil {
+		pm.proxyLogger.Warnf("not advertising with mDNS: %v", err)
+		return
+	}
+
+	conn, err := net.ListenMulticastUDP("udp4", nil, mdnsGroup)
+	if err != nil {
+		pm.proxyLogger.Warnf("not advertising with mDNS: %v", err)
+		return
+	}
+
+	pm.proxyLogger.Infof("advertising %s with mDNS", responder.instance)
+	go responder.serve(conn, pm)
+}
+
+func (pm *ProxyManager) newMDNSResponder(listenAddr string, useTLS bool) (*mdnsResponder, error) {
+	host, portStr, err := net.SplitHostPort(listenAddr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %s", portStr)
+	}
+
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil && !ip.IsUnspecified() {
+		if ip.IsLoopback() {
+			return nil, fmt.Errorf("listening on %s is not reachable from the network", host)
+		}
+		ips = append(ips, ip)
+	} else {
+		ips, err = lanIPs()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(ips) == 0 {
+		return nil, errors.New("no network address found")
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	hostname, _, _ = strings.Cut(hostname, ".")
+
+	name := pm.config.MDNS.Name
+	if name == "" {
+		name = "llmsnap on " + hostname
+	}
+
+	scheme := "http"
+	if useTLS {
+		scheme = "https"
+	}
+	txt := []string{"path=/v1", "scheme=" + scheme}
+	if pm.version != "" {
+		txt = append(txt, "version="+pm.version)
+	}
+
+	var models []string
+	for modelID, modelConfig := range pm.config.Models {
+		if !modelConfig.Unlisted {
+			models = append(models, modelID)
+		}
+	}
+	sort.Strings(models)
+	txt = append(txt, mdnsModelsTXT(models))
+
+	return newMDNSResponder(name, hostname, uint16(port), ips, txt)
+}
+
+func newMDNSResponder(name, hostname string, port uint16, ips []net.IP, txt []string) (*mdnsResponder, error) {
+	service, err := dnsmessage.NewName(mdnsService)
+	if err != nil {
+		return nil, err
+	}
+	instance, err := dnsmessage.NewName(name + "." + mdnsService)
+	if err != nil {
+		return nil, err
+	}
+	host, err := dnsmessage.NewName(hostname + ".local.")
+	if err != nil {
+		return nil, err
+	}
+	return &mdnsResponder{
+		service:  service,
+		instance: instance,
+		host:     host,
+		port:     port,
+		ips:      ips,
+		txt:      txt,
+	}, nil
+}
+
+// mdnsModelsTXT lists as many models as fit in one TXT string. Clients get
+// the full list from /v1/models.
+func mdnsModelsTXT(models []string) string {
+	txt := "models="
+	for i, model := range models {
+		entry := model
+		if i > 0 {
+			entry = "," + model
+		}
+		if len(txt)+len(entry) > mdnsMaxTXT {
+			break
+		}
+		txt += entry
+	}
+	return txt
+}
+
+// lanIPs returns the IPv4 addresses of the network interfaces that are up
+func lanIPs() ([]net.IP, error) {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	var ips []net.IP
+	for _, iface := range interfaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() != nil {
+				ips = append(ips, ipNet.IP.To4())
+			}
+		}
+	}
+	return ips, nil
+}
+
+// serve announces the service, answers queries and says goodbye on shutdown
+func (r *mdnsResponder) serve(conn *net.UDPConn, pm *ProxyManager) {
+	go func() {
+		// announce twice, a second apart, see RFC 6762 section 8.3
+		for i := 0; i < 2; i++ {
+			r.send(conn, mdnsGroup, r.announcement(mdnsTTL), pm)
+			select {
+			case <-pm.shutdownCtx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+		}
+	}()
+
+	go func() {
+		<-pm.shutdownCtx.Done()
+		r.send(conn, mdnsGroup, r.announcement(0), pm)
+		conn.Close()
+	}()
+
+	buf := make([]byte, 9000)
+	for {
+		n, src, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if pm.shutdownCtx.Err() == nil {
+				pm.proxyLogger.Warnf("mDNS read failed, no longer advertising: %v", err)
+			}
+			return
+		}
+
+		reply, unicast := r.answer(buf[:n])
+		if reply == nil {
+			continue
+		}
+		dst := mdnsGroup
+		if src.Port != mdnsGroup.Port {
+			legacyAnswer(reply, buf[:n])
+			dst = src
+		} else if unicast {
+			dst = src
+		}
+		r.send(conn, dst, reply, pm)
+	}
+}
+
+func (r *mdnsResponder) send(conn *net.UDPConn, dst *net.UDPAddr, msg *dnsmessage.Message, pm *ProxyManager) {
+	packet, err := msg.Pack()
+	if err != nil {
+		pm.proxyLogger.Errorf("unable to pack mDNS message: %v", err)
+		return
+	}
+	if _, err := conn.WriteToUDP(packet, dst); err != nil && pm.shutdownCtx.Err() == nil {
+		pm.proxyLogger.Debugf("unable to send mDNS message: %v", err)
+	}
+}
+
+// announcement is an unsolicited response with all records, a ttl of 0 tells
+// clients the service is gone
+func (r *mdnsResponder) announcement(ttl uint32) *dnsmessage.Message {
+	return &dnsmessage.Message{
+		Header:  dnsmessage.Header{Response: true, Authoritative: true},
+		Answers: r.records(ttl),
+	}
+}
+
+// answer returns the reply to a query, nil when it asks about nothing we
+// advertise. unicast is set when every answered question has the unicast
+// bit, the reply then goes to the sender only.
+func (r *mdnsResponder) answer(packet []byte) (reply *dnsmessage.Message, unicast bool) {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(packet)
+	if err != nil || header.Response {
+		return nil, false
+	}
+	questions, err := parser.AllQuestions()
+	if err != nil {
+		return nil, false
+	}
+
+	records := r.records(mdnsTTL)
+	answered := make([]bool, len(records))
+	reply = &dnsmessage.Message{Header: dnsmessage.Header{Response: true, Authoritative: true}}
+	unicast = true
+	for _, question := range questions {
+		matched := false
+		for i, record := range records {
+			if !answered[i] && mdnsMatches(question, record.Header) {
+				answered[i] = true
+				matched = true
+				reply.Answers = append(reply.Answers, record)
+			}
+		}
+		if matched && question.Class&mdnsCacheFlush == 0 {
+			unicast = false
+		}
+	}
+	if len(reply.Answers) == 0 {
+		return nil, false
+	}
+
+	// the other records save clients from asking for them next
+	for i, record := range records {
+		if !answered[i] {
+			reply.Additionals = append(reply.Additionals, record)
+		}
+	}
+	return reply, unicast
+}
+
+// legacyAnswer marks a reply for a one-shot query, it echoes the query ID and
+// questions, see RFC 6762 section 6.7
+func legacyAnswer(reply *dnsmessage.Message, packet []byte) {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(packet)
+	if err != nil {
+		return
+	}
+	reply.Header.ID = header.ID
+	reply.Questions, _ = parser.AllQuestions()
+	for i := range reply.Answers {
+		reply.Answers[i].Header.Class &^= mdnsCacheFlush
+	}
+	for i := range reply.Additionals {
+		reply.Additionals[i].Header.Class &^= mdnsCacheFlush
+	}
+}
+
+func mdnsMatches(question dnsmessage.Question, header dnsmessage.ResourceHeader) bool {
+	if question.Type != dnsmessage.TypeALL && question.Type != header.Type {
+		return false
+	}
+	return strings.EqualFold(question.Name.String(), header.Name.String())
+}
+
+// records are the PTR, SRV, TXT and A records of the service
+func (r *mdnsResponder) records(ttl uint32) []dnsmessage.Resource {
+	header := func(name dnsmessage.Name, rtype dnsmessage.Type, class dnsmessage.Class) dnsmessage.ResourceHeader {
+		return dnsmessage.ResourceHeader{Name: name, Type: rtype, Class: class, TTL: ttl}
+	}
+	unique := dnsmessage.ClassINET | mdnsCacheFlush
+	enumeration := dnsmessage.MustNewName(mdnsServiceEnumeration)
+
+	records := []dnsmessage.Resource{
+		{Header: header(enumeration, dnsmessage.TypePTR, dnsmessage.ClassINET), Body: &dnsmessage.PTRResource{PTR: r.service}},
+		{Header: header(r.service, dnsmessage.TypePTR, dnsmessage.ClassINET), Body: &dnsmessage.PTRResource{PTR: r.instance}},
+		{Header: header(r.instance, dnsmessage.TypeSRV, unique), Body: &dnsmessage.SRVResource{Port: r.port, Target: r.host}},
+		{Header: header(r.instance, dnsmessage.TypeTXT, unique), Body: &dnsmessage.TXTResource{TXT: r.txt}},
+	}
+	for _, ip := range r.ips {
+		if ip4 := ip.To4(); ip4 != nil {
+			records = append(records, dnsmessage.Resource{
+				Header: header(r.host, dnsmessage.TypeA, unique),
+				Body:   &dnsmessage.AResource{A: [4]byte(ip4)},
+			})
+		}
+	}
+	return records
+}
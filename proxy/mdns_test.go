@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func mdnsQuery(t *testing.T, name string, qtype dnsmessage.Type, class dnsmessage.Class) []byte {
+	t.Helper()
+	msg := dnsmessage.Message{
+		Header: dnsmessage.Header{ID: 42},
+		Questions: []dnsmessage.Question{
+			{Name: dnsmessage.MustNewName(name), Type: qtype, Class: class},
+		},
+	}
+	packet, err := msg.Pack()
+	require.NoError(t, err)
+	return packet
+}
+
+func TestMDNS_Answer(t *testing.T) {
+	responder, err := newMDNSResponder("llmsnap on box", "box", 8080,
+		[]net.IP{net.IPv4(192, 168, 1, 10)}, []string{"path=/v1", "models=a,b"})
+	require.NoError(t, err)
+
+	t.Run("browse for the service", func(t *testing.T) {
+		reply, unicast := responder.answer(mdnsQuery(t, "_llmsnap._tcp.local.", dnsmessage.TypePTR, dnsmessage.ClassINET))
+		require.NotNil(t, reply)
+		assert.False(t, unicast)
+
+		require.Len(t, reply.Answers, 1)
+		assert.Equal(t, "llmsnap on box._llmsnap._tcp.local.", reply.Answers[0].Body.(*dnsmessage.PTRResource).PTR.String())
+
+		var srv *dnsmessage.SRVResource
+		var txt *dnsmessage.TXTResource
+		var a *dnsmessage.AResource
+		for _, record := range reply.Additionals {
+			switch body := record.Body.(type) {
+			case *dnsmessage.SRVResource:
+				srv = body
+			case *dnsmessage.TXTResource:
+				txt = body
+			case *dnsmessage.AResource:
+				a = body
+			}
+		}
+		require.NotNil(t, srv)
+		assert.Equal(t, uint16(8080), srv.Port)
+		assert.Equal(t, "box.local.", srv.Target.String())
+		require.NotNil(t, txt)
+		assert.Equal(t, []string{"path=/v1", "models=a,b"}, txt.TXT)
+		require.NotNil(t, a)
+		assert.Equal(t, [4]byte{192, 168, 1, 10}, a.A)
+
+		// the reply must survive a round trip on the wire
+		_, err := reply.Pack()
+		assert.NoError(t, err)
+	})
+
+	t.Run("host address with the unicast bit", func(t *testing.T) {
+		reply, unicast := responder.answer(mdnsQuery(t, "BOX.local.", dnsmessage.TypeA, dnsmessage.ClassINET|mdnsCacheFlush))
+		require.NotNil(t, reply)
+		assert.True(t, unicast)
+		require.Len(t, reply.Answers, 1)
+		assert.Equal(t, dnsmessage.TypeA, reply.Answers[0].Header.Type)
+	})
+
+	t.Run("one-shot query echoes the ID and question", func(t *testing.T) {
+		query := mdnsQuery(t, "_llmsnap._tcp.local.", dnsmessage.TypePTR, dnsmessage.ClassINET)
+		reply, _ := responder.answer(query)
+		require.NotNil(t, reply)
+		legacyAnswer(reply, query)
+		assert.Equal(t, uint16(42), reply.Header.ID)
+		require.Len(t, reply.Questions, 1)
+		for _, record := range reply.Additionals {
+			assert.Equal(t, dnsmessage.ClassINET, record.Header.Class)
+		}
+	})
+
+	t.Run("other names are ignored", func(t *testing.T) {
+		reply, _ := responder.answer(mdnsQuery(t, "_http._tcp.local.", dnsmessage.TypePTR, dnsmessage.ClassINET))
+		assert.Nil(t, reply)
+	})
+}
+
+func TestMDNS_ModelsTXT(t *testing.T) {
+	assert.Equal(t, "models=a,b", mdnsModelsTXT([]string{"a", "b"}))
+
+	long := strings.Repeat("m", 230)
+	assert.Equal(t, "models=a,"+long, mdnsModelsTXT([]string{"a", long, "another-model-that-does-not-fit"}))
+}
@@ -0,0 +1,543 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// messagesPath is the endpoint of the Anthropic Messages API
+const messagesPath = "/v1/messages"
+
+// translateMessagesRequest turns an Anthropic Messages API request into an
+// OpenAI chat completion request for upstreams that only speak the latter.
+// It returns the new request and the model the client asked for.
+func translateMessagesRequest(r *http.Request) (*http.Request, string, error) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	converted, err := messagesToChatCompletion(body)
+	if err != nil {
+		return nil, "", err
+	}
+
+	translated := r.Clone(r.Context())
+	translated.URL.Path = "/v1/chat/completions"
+	translated.URL.RawPath = ""
+	translated.Body = io.NopCloser(bytes.NewReader(converted))
+	translated.ContentLength = int64(len(converted))
+	translated.Header.Set("Content-Length", strconv.Itoa(len(converted)))
+	translated.Header.Del("anthropic-version")
+	translated.Header.Del("anthropic-beta")
+	return translated, gjson.GetBytes(body, "model").String(), nil
+}
+
+// messagesToChatCompletion converts the body of a Messages API request.
+// Thinking blocks of earlier turns and documents are dropped, the upstream
+// has no use for them.
+func messagesToChatCompletion(body []byte) ([]byte, error) {
+	if !gjson.ValidBytes(body) {
+		return nil, errors.New("request body is not valid JSON")
+	}
+	request := gjson.ParseBytes(body)
+	if !request.Get("messages").IsArray() {
+		return nil, errors.New("messages must be an array")
+	}
+
+	messages := []any{}
+	if system := blocksText(request.Get("system")); system != "" {
+		messages = append(messages, map[string]any{"role": "system", "content": system})
+	}
+	for _, message := range request.Get("messages").Array() {
+		messages = append(messages, chatMessages(message)...)
+	}
+
+	completion := map[string]any{
+		"model":    request.Get("model").String(),
+		"messages": messages,
+	}
+	for _, key := range []string{"max_tokens", "temperature", "top_p", "top_k"} {
+		if value := request.Get(key); value.Exists() {
+			completion[key] = json.RawMessage(value.Raw)
+		}
+	}
+	if stop := request.Get("stop_sequences"); stop.IsArray() {
+		completion["stop"] = json.RawMessage(stop.Raw)
+	}
+	if user := request.Get("metadata.user_id"); user.Exists() {
+		completion["user"] = user.String()
+	}
+	if request.Get("stream").Bool() {
+		completion["stream"] = true
+		// the usage is reported in the last event of the stream
+		completion["stream_options"] = map[string]any{"include_usage": true}
+	}
+
+	if tools := request.Get("tools"); tools.IsArray() {
+		var functions []any
+		for _, tool := range tools.Array() {
+			function := map[string]any{"name": tool.Get("name").String()}
+			if description := tool.Get("description"); description.Exists() {
+				function["description"] = description.String()
+			}
+			if schema := tool.Get("input_schema"); schema.IsObject() {
+				function["parameters"] = json.RawMessage(schema.Raw)
+			}
+			functions = append(functions, map[string]any{"type": "function", "function": function})
+		}
+		if len(functions) > 0 {
+			completion["tools"] = functions
+		}
+	}
+
+	switch choice := request.Get("tool_choice"); choice.Get("type").String() {
+	case "auto":
+		completion["tool_choice"] = "auto"
+	case "any":
+		completion["tool_choice"] = "required"
+	case "none":
+		completion["tool_choice"] = "none"
+	case "tool":
+		completion["tool_choice"] = map[string]any{
+			"type":     "function",
+			"function": map[string]any{"name": choice.Get("name").String()},
+		}
+	}
+	if request.Get("tool_choice.disable_parallel_tool_use").Bool() {
+		completion["parallel_tool_calls"] = false
+	}
+
+	return json.Marshal(completion)
+}
+
+// chatMessages converts a message of the Messages API. Tool results become
+// tool messages of their own, ahead of the rest of a user's message.
+func chatMessages(message gjson.Result) []any {
+	role := message.Get("role").String()
+	content := message.Get("content")
+	if content.Type == gjson.String {
+		return []any{map[string]any{"role": role, "content": content.String()}}
+	}
+
+	var messages, parts, toolCalls []any
+	var texts []string
+	hasImage := false
+	for _, block := range content.Array() {
+		switch block.Get("type").String() {
+		case "text":
+			texts = append(texts, block.Get("text").String())
+			parts = append(parts, map[string]any{"type": "text", "text": block.Get("text").String()})
+		case "image":
+			source := block.Get("source")
+			url := source.Get("url").String()
+			if source.Get("type").String() == "base64" {
+				url = "data:" + source.Get("media_type").String() + ";base64," + source.Get("data").String()
+			}
+			hasImage = true
+			parts = append(parts, map[string]any{"type": "image_url", "image_url": map[string]any{"url": url}})
+		case "tool_use":
+			arguments := "{}"
+			if input := block.Get("input"); input.Exists() {
+				arguments = input.Raw
+			}
+			toolCalls = append(toolCalls, map[string]any{
+				"id":   block.Get("id").String(),
+				"type": "function",
+				"function": map[string]any{
+					"name":      block.Get("name").String(),
+					"arguments": arguments,
+				},
+			})
+		case "tool_result":
+			result := blocksText(block.Get("content"))
+			if block.Get("is_error").Bool() {
+				result = "Error: " + result
+			}
+			messages = append(messages, map[string]any{
+				"role":         "tool",
+				"tool_call_id": block.Get("tool_use_id").String(),
+				"content":      result,
+			})
+		}
+	}
+
+	if len(parts) == 0 && len(toolCalls) == 0 {
+		return messages
+	}
+	converted := map[string]any{"role": role}
+	switch {
+	case hasImage:
+		converted["content"] = parts
+	case len(texts) > 0:
+		converted["content"] = strings.Join(texts, "\n")
+	default:
+		converted["content"] = nil
+	}
+	if len(toolCalls) > 0 {
+		converted["tool_calls"] = toolCalls
+	}
+	return append(messages, converted)
+}
+
+// blocksText returns the text of content that is a string or an array of
+// blocks
+func blocksText(content gjson.Result) string {
+	if content.Type == gjson.String {
+		return content.String()
+	}
+	var texts []string
+	for _, block := range content.Array() {
+		if block.Get("type").String() == "text" {
+			texts = append(texts, block.Get("text").String())
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// stopReason maps the finish_reason of a chat completion to a stop_reason
+func stopReason(finishReason string) string {
+	switch finishReason {
+	case "length":
+		return "max_tokens"
+	case "tool_calls", "function_call":
+		return "tool_use"
+	default:
+		return "end_turn"
+	}
+}
+
+// messageID returns the id of a message for the id of a chat completion
+func messageID(completionID string) string {
+	return "msg_" + strings.TrimPrefix(completionID, "chatcmpl-")
+}
+
+// messageUsage converts the usage of a chat completion. Unlike prompt_tokens
+// input_tokens does not count the tokens read from the cache.
+func messageUsage(usage gjson.Result) map[string]any {
+	cached := usage.Get("prompt_tokens_details.cached_tokens").Int()
+	converted := map[string]any{
+		"input_tokens":  usage.Get("prompt_tokens").Int() - cached,
+		"output_tokens": usage.Get("completion_tokens").Int(),
+	}
+	if cached > 0 {
+		converted["cache_read_input_tokens"] = cached
+	}
+	return converted
+}
+
+// chatCompletionToMessage converts a complete chat completion response.
+// llama-server's timings are kept for the metrics.
+func chatCompletionToMessage(body []byte, model string) []byte {
+	completion := gjson.ParseBytes(body)
+	choice := completion.Get("choices.0")
+	message := choice.Get("message")
+
+	content := []any{}
+	if reasoning := upstreamReasoning(message); reasoning != "" {
+		content = append(content, map[string]any{"type": "thinking", "thinking": reasoning, "signature": ""})
+	}
+	if text := message.Get("content").String(); text != "" {
+		content = append(content, map[string]any{"type": "text", "text": text})
+	}
+	for _, call := range message.Get("tool_calls").Array() {
+		content = append(content, map[string]any{
+			"type":  "tool_use",
+			"id":    call.Get("id").String(),
+			"name":  call.Get("function.name").String(),
+			"input": toolInput(call.Get("function.arguments").String()),
+		})
+	}
+
+	converted := map[string]any{
+		"id":            messageID(completion.Get("id").String()),
+		"type":          "message",
+		"role":          "assistant",
+		"model":         model,
+		"content":       content,
+		"stop_reason":   stopReason(choice.Get("finish_reason").String()),
+		"stop_sequence": nil,
+		"usage":         messageUsage(completion.Get("usage")),
+	}
+	if timings := completion.Get("timings"); timings.IsObject() {
+		converted["timings"] = json.RawMessage(timings.Raw)
+	}
+	out, _ := json.Marshal(converted)
+	return out
+}
+
+// toolInput returns the arguments of a tool call as a JSON object, an empty
+// one when the model did not write valid JSON
+func toolInput(arguments string) json.RawMessage {
+	if !gjson.Valid(arguments) || !gjson.Parse(arguments).IsObject() {
+		return json.RawMessage("{}")
+	}
+	return json.RawMessage(arguments)
+}
+
+// messagesWriter converts the chat completion response of a translated
+// request back into a Messages API response. JSON responses are buffered
+// and converted when complete, event streams become the message_start,
+// content_block_* and message_* events of the Messages API. Compressed or
+// unsuccessful responses are passed through untouched.
+type messagesWriter struct {
+	http.ResponseWriter
+	model string
+
+	checked     bool
+	passthrough bool
+	isSSE       bool
+
+	// the whole JSON body, or the unfinished line of an event stream
+	buf bytes.Buffer
+
+	// state of the event stream
+	started    bool
+	finished   bool
+	block      string // type of the open content block, "" when none
+	blocks     int
+	stopReason string
+	usage      gjson.Result
+	timings    gjson.Result
+}
+
+func newMessagesWriter(w http.ResponseWriter, model string) *messagesWriter {
+	return &messagesWriter{
+		ResponseWriter: w,
+		model:          model,
+	}
+}
+
+func (mw *messagesWriter) WriteHeader(statusCode int) {
+	if !mw.checked {
+		mw.checked = true
+		header := mw.ResponseWriter.Header()
+		contentType := strings.ToLower(header.Get("Content-Type"))
+		mw.isSSE = strings.Contains(contentType, "text/event-stream")
+		mw.passthrough = statusCode != http.StatusOK || header.Get("Content-Encoding") != "" ||
+			(!mw.isSSE && !strings.Contains(contentType, "application/json"))
+		if !mw.passthrough {
+			header.Del("Content-Length")
+		}
+	}
+	mw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (mw *messagesWriter) Write(data []byte) (int, error) {
+	if !mw.checked {
+		mw.WriteHeader(http.StatusOK)
+	}
+	if mw.passthrough {
+		return mw.ResponseWriter.Write(data)
+	}
+
+	mw.buf.Write(data)
+	if !mw.isSSE {
+		return len(data), nil
+	}
+
+	var out bytes.Buffer
+	for {
+		line, err := mw.buf.ReadBytes('\n')
+		if err != nil {
+			// keep the unfinished line for the next write
+			rest := bytes.Clone(line)
+			mw.buf.Reset()
+			mw.buf.Write(rest)
+			break
+		}
+		mw.convertLine(&out, line)
+	}
+	if out.Len() > 0 {
+		if _, err := mw.ResponseWriter.Write(out.Bytes()); err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+// convertLine writes the events for a line of a chat completion stream.
+// Only data lines carry anything, blank lines and comments are dropped as
+// every event is written with its own line endings.
+func (mw *messagesWriter) convertLine(out *bytes.Buffer, line []byte) {
+	payload, found := bytes.CutPrefix(bytes.TrimSpace(line), []byte("data:"))
+	if !found {
+		return
+	}
+	payload = bytes.TrimSpace(payload)
+	if bytes.Equal(payload, []byte("[DONE]")) {
+		mw.finish(out)
+		return
+	}
+	if !gjson.ValidBytes(payload) {
+		return
+	}
+
+	chunk := gjson.ParseBytes(payload)
+	if apiError := chunk.Get("error"); apiError.Exists() {
+		message := apiError.Get("message").String()
+		if message == "" {
+			message = apiError.String()
+		}
+		writeMessagesEvent(out, "error", map[string]any{
+			"type":  "error",
+			"error": map[string]any{"type": "api_error", "message": message},
+		})
+		return
+	}
+
+	if !mw.started {
+		mw.started = true
+		writeMessagesEvent(out, "message_start", map[string]any{
+			"type": "message_start",
+			"message": map[string]any{
+				"id":            messageID(chunk.Get("id").String()),
+				"type":          "message",
+				"role":          "assistant",
+				"model":         mw.model,
+				"content":       []any{},
+				"stop_reason":   nil,
+				"stop_sequence": nil,
+				"usage":         map[string]any{"input_tokens": 0, "output_tokens": 0},
+			},
+		})
+	}
+
+	if usage := chunk.Get("usage"); usage.IsObject() {
+		mw.usage = usage
+	}
+	if timings := chunk.Get("timings"); timings.IsObject() {
+		mw.timings = timings
+	}
+
+	choice := chunk.Get("choices.0")
+	delta := choice.Get("delta")
+	if reasoning := upstreamReasoning(delta); reasoning != "" {
+		mw.startBlock(out, "thinking", map[string]any{"type": "thinking", "thinking": "", "signature": ""})
+		mw.writeDelta(out, map[string]any{"type": "thinking_delta", "thinking": reasoning})
+	}
+	if text := delta.Get("content").String(); text != "" {
+		mw.startBlock(out, "text", map[string]any{"type": "text", "text": ""})
+		mw.writeDelta(out, map[string]any{"type": "text_delta", "text": text})
+	}
+	for _, call := range delta.Get("tool_calls").Array() {
+		// a call starts with its id, later chunks add to its arguments
+		if id := call.Get("id").String(); id != "" || mw.block != "tool_use" {
+			mw.stopBlock(out)
+			mw.startBlock(out, "tool_use", map[string]any{
+				"type":  "tool_use",
+				"id":    id,
+				"name":  call.Get("function.name").String(),
+				"input": map[string]any{},
+			})
+		}
+		if arguments := call.Get("function.arguments").String(); arguments != "" {
+			mw.writeDelta(out, map[string]any{"type": "input_json_delta", "partial_json": arguments})
+		}
+	}
+	if finish := choice.Get("finish_reason"); finish.Type == gjson.String {
+		mw.stopReason = stopReason(finish.String())
+	}
+}
+
+// startBlock opens a content block of kind unless one is open already,
+// closing the block before it
+func (mw *messagesWriter) startBlock(out *bytes.Buffer, kind string, block map[string]any) {
+	if mw.block == kind {
+		return
+	}
+	mw.stopBlock(out)
+	mw.block = kind
+	writeMessagesEvent(out, "content_block_start", map[string]any{
+		"type":          "content_block_start",
+		"index":         mw.blocks,
+		"content_block": block,
+	})
+}
+
+func (mw *messagesWriter) writeDelta(out *bytes.Buffer, delta map[string]any) {
+	writeMessagesEvent(out, "content_block_delta", map[string]any{
+		"type":  "content_block_delta",
+		"index": mw.blocks,
+		"delta": delta,
+	})
+}
+
+// stopBlock closes the open content block, if any
+func (mw *messagesWriter) stopBlock(out *bytes.Buffer) {
+	if mw.block == "" {
+		return
+	}
+	writeMessagesEvent(out, "content_block_stop", map[string]any{"type": "content_block_stop", "index": mw.blocks})
+	mw.blocks++
+	mw.block = ""
+}
+
+// finish ends the message with its stop reason and usage. The usage carries
+// the input tokens too as they are only known at the end of the stream.
+func (mw *messagesWriter) finish(out *bytes.Buffer) {
+	if !mw.started || mw.finished {
+		return
+	}
+	mw.finished = true
+	mw.stopBlock(out)
+
+	if mw.stopReason == "" {
+		mw.stopReason = "end_turn"
+	}
+	event := map[string]any{
+		"type":  "message_delta",
+		"delta": map[string]any{"stop_reason": mw.stopReason, "stop_sequence": nil},
+		"usage": messageUsage(mw.usage),
+	}
+	if mw.timings.IsObject() {
+		event["timings"] = json.RawMessage(mw.timings.Raw)
+	}
+	writeMessagesEvent(out, "message_delta", event)
+	writeMessagesEvent(out, "message_stop", map[string]any{"type": "message_stop"})
+}
+
+// writeMessagesEvent writes a named event of the Messages API
+func writeMessagesEvent(out *bytes.Buffer, name string, payload map[string]any) {
+	data, _ := json.Marshal(payload)
+	fmt.Fprintf(out, "event: %s\ndata: %s\n\n", name, data)
+}
+
+func (mw *messagesWriter) Flush() {
+	if mw.passthrough || mw.isSSE {
+		if flusher, ok := mw.ResponseWriter.(http.Flusher); ok {
+			flusher.Flush()
+		}
+	}
+}
+
+// Close writes the converted JSON body, or ends a stream the upstream did
+// not finish with [DONE]
+func (mw *messagesWriter) Close() {
+	if mw.passthrough || !mw.checked {
+		return
+	}
+	if mw.isSSE {
+		var out bytes.Buffer
+		mw.convertLine(&out, append(mw.buf.Bytes(), '\n'))
+		mw.finish(&out)
+		mw.buf.Reset()
+		mw.ResponseWriter.Write(out.Bytes())
+		return
+	}
+	if mw.buf.Len() > 0 {
+		mw.ResponseWriter.Write(chatCompletionToMessage(mw.buf.Bytes(), mw.model))
+		mw.buf.Reset()
+	}
+}
+
+func (mw *messagesWriter) Unwrap() http.ResponseWriter {
+	return mw.ResponseWriter
+}
@@ -0,0 +1,223 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+func TestMessagesToChatCompletion(t *testing.T) {
+	body := `{
+		"model": "claude",
+		"max_tokens": 1024,
+		"system": [{"type": "text", "text": "be brief"}],
+		"stop_sequences": ["END"],
+		"stream": true,
+		"tools": [{"name": "get_weather", "description": "weather of a city", "input_schema": {"type": "object"}}],
+		"tool_choice": {"type": "any"},
+		"messages": [
+			{"role": "user", "content": "weather in Paris?"},
+			{"role": "assistant", "content": [
+				{"type": "thinking", "thinking": "use the tool", "signature": "x"},
+				{"type": "tool_use", "id": "toolu_1", "name": "get_weather", "input": {"city": "Paris"}}
+			]},
+			{"role": "user", "content": [
+				{"type": "tool_result", "tool_use_id": "toolu_1", "content": [{"type": "text", "text": "sunny"}]},
+				{"type": "text", "text": "and the image?"},
+				{"type": "image", "source": {"type": "base64", "media_type": "image/png", "data": "AAAA"}}
+			]}
+		]
+	}`
+
+	converted, err := messagesToChatCompletion([]byte(body))
+	require.NoError(t, err)
+	completion := gjson.ParseBytes(converted)
+
+	assert.Equal(t, "claude", completion.Get("model").String())
+	assert.Equal(t, int64(1024), completion.Get("max_tokens").Int())
+	assert.Equal(t, `["END"]`, completion.Get("stop").Raw)
+	assert.True(t, completion.Get("stream_options.include_usage").Bool())
+	assert.Equal(t, "get_weather", completion.Get("tools.0.function.name").String())
+	assert.Equal(t, `{"type":"object"}`, completion.Get("tools.0.function.parameters").Raw)
+	assert.Equal(t, "required", completion.Get("tool_choice").String())
+
+	messages := completion.Get("messages").Array()
+	require.Len(t, messages, 5)
+	assert.Equal(t, "system", messages[0].Get("role").String())
+	assert.Equal(t, "be brief", messages[0].Get("content").String())
+	assert.Equal(t, "weather in Paris?", messages[1].Get("content").String())
+
+	assert.Equal(t, "assistant", messages[2].Get("role").String())
+	assert.Equal(t, gjson.Null, messages[2].Get("content").Type)
+	assert.Equal(t, "toolu_1", messages[2].Get("tool_calls.0.id").String())
+	assert.JSONEq(t, `{"city":"Paris"}`, messages[2].Get("tool_calls.0.function.arguments").String())
+
+	assert.Equal(t, "tool", messages[3].Get("role").String())
+	assert.Equal(t, "toolu_1", messages[3].Get("tool_call_id").String())
+	assert.Equal(t, "sunny", messages[3].Get("content").String())
+
+	assert.Equal(t, "user", messages[4].Get("role").String())
+	assert.Equal(t, "and the image?", messages[4].Get("content.0.text").String())
+	assert.Equal(t, "data:image/png;base64,AAAA", messages[4].Get("content.1.image_url.url").String())
+
+	_, err = messagesToChatCompletion([]byte(`{"model":"claude"}`))
+	assert.ErrorContains(t, err, "messages must be an array")
+}
+
+func TestChatCompletionToMessage(t *testing.T) {
+	body := `{
+		"id": "chatcmpl-abc",
+		"choices": [{"index": 0, "finish_reason": "tool_calls", "message": {
+			"role": "assistant",
+			"content": "checking",
+			"reasoning_content": "hmm",
+			"tool_calls": [{"id": "call_1", "type": "function", "function": {"name": "get_weather", "arguments": "{\"city\":\"Paris\"}"}}]
+		}}],
+		"usage": {"prompt_tokens": 30, "completion_tokens": 10, "prompt_tokens_details": {"cached_tokens": 20}}
+	}`
+
+	message := gjson.ParseBytes(chatCompletionToMessage([]byte(body), "claude"))
+	assert.Equal(t, "msg_abc", message.Get("id").String())
+	assert.Equal(t, "message", message.Get("type").String())
+	assert.Equal(t, "claude", message.Get("model").String())
+	assert.Equal(t, "tool_use", message.Get("stop_reason").String())
+	assert.Equal(t, "thinking", message.Get("content.0.type").String())
+	assert.Equal(t, "hmm", message.Get("content.0.thinking").String())
+	assert.Equal(t, "checking", message.Get("content.1.text").String())
+	assert.Equal(t, "get_weather", message.Get("content.2.name").String())
+	assert.JSONEq(t, `{"city":"Paris"}`, message.Get("content.2.input").Raw)
+	assert.Equal(t, int64(10), message.Get("usage.input_tokens").Int())
+	assert.Equal(t, int64(20), message.Get("usage.cache_read_input_tokens").Int())
+	assert.Equal(t, int64(10), message.Get("usage.output_tokens").Int())
+}
+
+func TestMessagesWriter(t *testing.T) {
+	t.Run("event stream", func(t *testing.T) {
+		stream := strings.Join([]string{
+			`data: {"id":"chatcmpl-abc","choices":[{"index":0,"delta":{"role":"assistant","reasoning_content":"hmm"}}]}`,
+			`data: {"id":"chatcmpl-abc","choices":[{"index":0,"delta":{"content":"Hi"}}]}`,
+			`data: {"id":"chatcmpl-abc","choices":[{"index":0,"delta":{"content":"!"}}]}`,
+			`data: {"id":"chatcmpl-abc","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","function":{"name":"get_weather","arguments":""}}]}}]}`,
+			`data: {"id":"chatcmpl-abc","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"city\":"}}]}}]}`,
+			`data: {"id":"chatcmpl-abc","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"Paris\"}"}}]}}]}`,
+			`data: {"id":"chatcmpl-abc","choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}`,
+			`data: {"id":"chatcmpl-abc","choices":[],"usage":{"prompt_tokens":25,"completion_tokens":10}}`,
+			`data: [DONE]`,
+			``,
+		}, "\n\n")
+
+		rec := httptest.NewRecorder()
+		rec.Header().Set("Content-Type", "text/event-stream")
+		mw := newMessagesWriter(rec, "claude")
+
+		// write in odd sized pieces so events are split across writes
+		for i := 0; i < len(stream); i += 7 {
+			mw.Write([]byte(stream[i:min(i+7, len(stream))]))
+		}
+		mw.Close()
+
+		var names []string
+		var text, thinking, arguments string
+		var last gjson.Result
+		forEachSSEEvent(rec.Body.Bytes(), func(event sseEvent) {
+			names = append(names, event.name)
+			data := gjson.ParseBytes(event.data)
+			assert.Equal(t, event.name, data.Get("type").String())
+			text += data.Get("delta.text").String()
+			thinking += data.Get("delta.thinking").String()
+			arguments += data.Get("delta.partial_json").String()
+			if event.name == "message_delta" {
+				last = data
+			}
+		})
+
+		assert.Equal(t, []string{
+			"message_start",
+			"content_block_start", "content_block_delta", "content_block_stop",
+			"content_block_start", "content_block_delta", "content_block_delta", "content_block_stop",
+			"content_block_start", "content_block_delta", "content_block_delta", "content_block_stop",
+			"message_delta", "message_stop",
+		}, names)
+		assert.Equal(t, "Hi!", text)
+		assert.Equal(t, "hmm", thinking)
+		assert.JSONEq(t, `{"city":"Paris"}`, arguments)
+		assert.Equal(t, "tool_use", last.Get("delta.stop_reason").String())
+		assert.Equal(t, int64(25), last.Get("usage.input_tokens").Int())
+		assert.Equal(t, int64(10), last.Get("usage.output_tokens").Int())
+	})
+
+	t.Run("errors pass through", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		rec.Header().Set("Content-Type", "application/json")
+		mw := newMessagesWriter(rec, "claude")
+		mw.WriteHeader(http.StatusBadRequest)
+		mw.Write([]byte(`{"error":{"message":"bad"}}`))
+		mw.Close()
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Equal(t, `{"error":{"message":"bad"}}`, rec.Body.String())
+	})
+}
+
+func TestProxyManager_TranslateMessages(t *testing.T) {
+	modelConfig := getTestSimpleResponderConfig("model1")
+	modelConfig.TranslateMessages = true
+	conf := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]config.ModelConfig{
+			"model1": modelConfig,
+		},
+		LogLevel: "error",
+	})
+
+	proxy := New(conf)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	t.Run("json response", func(t *testing.T) {
+		reqBody := `{"model":"model1","max_tokens":100,"messages":[{"role":"user","content":"hello"}]}`
+		req := httptest.NewRequest("POST", "/v1/messages", bytes.NewBufferString(reqBody))
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		message := gjson.Parse(w.Body.String())
+		assert.Equal(t, "message", message.Get("type").String())
+		assert.Equal(t, "model1", message.Get("model").String())
+		assert.Equal(t, int64(25), message.Get("usage.input_tokens").Int())
+		assert.Equal(t, int64(10), message.Get("usage.output_tokens").Int())
+	})
+
+	t.Run("event stream", func(t *testing.T) {
+		reqBody := `{"model":"model1","max_tokens":100,"stream":true,"messages":[{"role":"user","content":"hello"}]}`
+		req := httptest.NewRequest("POST", "/v1/messages?stream=true", bytes.NewBufferString(reqBody))
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var text string
+		var names []string
+		forEachSSEEvent(w.Body.Bytes(), func(event sseEvent) {
+			names = append(names, event.name)
+			text += gjson.GetBytes(event.data, "delta.text").String()
+		})
+		assert.Equal(t, strings.Repeat("asdf", 10), text)
+		require.NotEmpty(t, names)
+		assert.Equal(t, "message_start", names[0])
+		assert.Equal(t, "message_stop", names[len(names)-1])
+	})
+
+	// the usage of both is recorded from the translated responses
+	metrics := proxy.metricsMonitor.getMetrics()
+	require.Len(t, metrics, 2)
+	for _, metric := range metrics {
+		assert.Equal(t, 25, metric.InputTokens)
+		assert.Equal(t, 10, metric.OutputTokens)
+	}
+}
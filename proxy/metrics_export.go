@@ -0,0 +1,147 @@
+package proxy
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// metricsExportColumns is the header of the CSV written by
+// /api/metrics/export
+var metricsExportColumns = []string{
+	"id", "timestamp", "model", "input_tokens", "output_tokens", "cache_tokens",
+	"prompt_per_second", "tokens_per_second", "duration_ms", "ttft_ms", "cost",
+	"client", "tenant", "request_id", "embedding_inputs", "embedding_dimensions", "guardrail",
+	"cache_hit",
+}
+
+func metricsExportRecord(m TokenMetrics) []string {
+	return []string{
+		strconv.Itoa(m.ID),
+		m.Timestamp.UTC().Format(time.RFC3339Nano),
+		m.Model,
+		strconv.Itoa(m.InputTokens),
+		strconv.Itoa(m.OutputTokens),
+		strconv.Itoa(m.CachedTokens),
+		strconv.FormatFloat(m.PromptPerSecond, 'f', -1, 64),
+		strconv.FormatFloat(m.TokensPerSecond, 'f', -1, 64),
+		strconv.Itoa(m.DurationMs),
+		strconv.Itoa(m.TTFTMs),
+		strconv.FormatFloat(m.Cost, 'f', -1, 64),
+		m.Client,
+		m.Tenant,
+		m.RequestID,
+		strconv.Itoa(m.EmbeddingInputs),
+		strconv.Itoa(m.EmbeddingDimensions),
+		m.Guardrail,
+		strconv.FormatBool(m.CacheHit),
+	}
+}
+
+// history returns the recorded metrics from from to to, oldest first. With
+// metricsStore the file is read, it holds more than metricsMaxInMemory, and
+// the metrics still queued for it are taken from memory.
+func (mp *metricsMonitor) history(from, to time.Time) ([]TokenMetrics, error) {
+	inMemory := mp.getMetrics()
+
+	var all []TokenMetrics
+	if mp.store != nil {
+		stored, err := mp.store.read()
+		if err != nil {
+			return nil, err
+		}
+		all = stored
+		lastID := -1
+		if len(stored) > 0 {
+			lastID = stored[len(stored)-1].ID
+		}
+		for _, m := range inMemory {
+			if m.ID > lastID {
+				all = append(all, m)
+			}
+		}
+	} else {
+		all = inMemory
+	}
+
+	result := make([]TokenMetrics, 0, len(all))
+	for _, m := range all {
+		if (!from.IsZero() && m.Timestamp.Before(from)) || (!to.IsZero() && !m.Timestamp.Before(to)) {
+			continue
+		}
+		result = append(result, m)
+	}
+	return result, nil
+}
+
+// parseExportTime reads a from or to value, RFC 3339 or a date like
+// 2025-01-31. Empty is the zero time, no limit.
+func parseExportTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, value)
+}
+
+// apiExportMetrics writes the recorded metrics as CSV or JSONL for
+// spreadsheets and notebooks. from is inclusive and to exclusive, ?client=
+// limits it to a client.
+func (pm *ProxyManager) apiExportMetrics(c *gin.Context) {
+	format := c.DefaultQuery("format", "jsonl")
+	if format != "csv" && format != "jsonl" {
+		pm.sendErrorResponse(c, http.StatusBadRequest, fmt.Sprintf("invalid format %q, must be csv or jsonl", format))
+		return
+	}
+	from, err := parseExportTime(c.Query("from"))
+	if err != nil {
+		pm.sendErrorResponse(c, http.StatusBadRequest, fmt.Sprintf("invalid from %q, must be RFC 3339 or a date", c.Query("from")))
+		return
+	}
+	to, err := parseExportTime(c.Query("to"))
+	if err != nil {
+		pm.sendErrorResponse(c, http.StatusBadRequest, fmt.Sprintf("invalid to %q, must be RFC 3339 or a date", c.Query("to")))
+		return
+	}
+
+	metrics, err := pm.metricsMonitor.history(from, to)
+	if err != nil {
+		pm.sendErrorResponse(c, http.StatusInternalServerError, fmt.Sprintf("unable to read metrics: %v", err))
+		return
+	}
+	metrics = metricsOfClient(metrics, c.Query("client"))
+
+	if format == "csv" {
+		c.Header("Content-Type", "text/csv")
+		c.Header("Content-Disposition", `attachment; filename="activity.csv"`)
+		c.Status(http.StatusOK)
+		writer := csv.NewWriter(c.Writer)
+		writer.Write(metricsExportColumns)
+		for _, m := range metrics {
+			writer.Write(metricsExportRecord(m))
+		}
+		writer.Flush()
+		if err := writer.Error(); err != nil {
+			pm.proxyLogger.Errorf("unable to write metrics export: %v", err)
+		}
+		return
+	}
+
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Header("Content-Disposition", `attachment; filename="activity.jsonl"`)
+	c.Status(http.StatusOK)
+	encoder := json.NewEncoder(c.Writer)
+	for _, m := range metrics {
+		if err := encoder.Encode(m); err != nil {
+			pm.proxyLogger.Errorf("unable to write metrics export: %v", err)
+			return
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package proxy
+
+import (
+	"encoding/csv"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricsMonitor_History(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "activity.jsonl")
+	require.NoError(t, os.WriteFile(file, []byte(strings.Join([]string{
+		`{"id":1,"timestamp":"2025-06-01T09:00:00Z","model":"model1"}`,
+		`{"id":2,"timestamp":"2025-06-02T09:00:00Z","model":"model2"}`,
+	}, "\n")), 0644))
+
+	mm := newMetricsMonitor(testLogger, 1, 0)
+	mm.store = newMetricsStore(config.MetricsStoreConfig{File: file}, testLogger)
+	mm.restoreMetrics([]TokenMetrics{{ID: 2, Model: "model2", Timestamp: time.Date(2025, 6, 2, 9, 0, 0, 0, time.UTC)}})
+	// queued for the store, not written yet
+	mm.addMetrics(TokenMetrics{Model: "model3", Timestamp: time.Date(2025, 6, 3, 9, 0, 0, 0, time.UTC)})
+
+	all, err := mm.history(time.Time{}, time.Time{})
+	require.NoError(t, err)
+	require.Len(t, all, 3)
+	assert.Equal(t, []string{"model1", "model2", "model3"}, []string{all[0].Model, all[1].Model, all[2].Model})
+
+	// from is inclusive, to exclusive
+	some, err := mm.history(time.Date(2025, 6, 2, 9, 0, 0, 0, time.UTC), time.Date(2025, 6, 3, 9, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	require.Len(t, some, 1)
+	assert.Equal(t, "model2", some[0].Model)
+}
+
+func TestProxyManager_ExportMetrics(t *testing.T) {
+	proxy := New(config.AddDefaultGroupToConfig(config.Config{LogLevel: "error"}))
+	defer proxy.StopProcesses(StopImmediately)
+
+	proxy.metricsMonitor.addMetrics(TokenMetrics{Model: "model1", Timestamp: time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC), InputTokens: 10, OutputTokens: 20, TokensPerSecond: 12.5, Client: "a,b"})
+	proxy.metricsMonitor.addMetrics(TokenMetrics{Model: "model2", Timestamp: time.Date(2025, 6, 2, 9, 0, 0, 0, time.UTC), DurationMs: 300})
+
+	get := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, httptest.NewRequest("GET", "/api/metrics/export"+query, nil))
+		return w
+	}
+
+	w := get("?format=csv")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/csv", w.Header().Get("Content-Type"))
+	records, err := csv.NewReader(w.Body).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	assert.Equal(t, metricsExportColumns, records[0])
+	assert.Equal(t, []string{"0", "2025-06-01T09:00:00Z", "model1", "10", "20", "0", "0", "12.5", "0", "0", "0", "a,b", "", "", "0", "0", "", "false"}, records[1])
+
+	w = get("?from=2025-06-02")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	require.Len(t, lines, 1)
+	assert.Contains(t, lines[0], `"model":"model2"`)
+
+	w = get("?to=2025-06-02T00:00:00Z&format=jsonl")
+	assert.Equal(t, 1, strings.Count(w.Body.String(), "\n"))
+	assert.Contains(t, w.Body.String(), `"model":"model1"`)
+
+	assert.Equal(t, http.StatusBadRequest, get("?format=xml").Code)
+	assert.Equal(t, http.StatusBadRequest, get("?from=yesterday").Code)
+}
@@ -0,0 +1,268 @@
+package proxy
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// extractUsageTimings walks a JSON response with a streaming decoder and
+// returns only the "usage" and "timings" values. Everything else is skipped
+// token by token so large embeddings or logprobs responses are never held
+// in memory, r is fed while the response is written. When the document is an array, as with /infill,
+// the values from the last element win.
+func extractUsageTimings(r io.Reader) (usage, timings gjson.Result, err error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+
+	tok, err := dec.Token()
+	if err != nil {
+		return usage, timings, err
+	}
+
+	switch tok {
+	case json.Delim('{'):
+		usage, timings, err = extractFromObject(dec)
+	case json.Delim('['):
+		for dec.More() {
+			tok, err = dec.Token()
+			if err != nil {
+				return usage, timings, err
+			}
+			if tok != json.Delim('{') {
+				if err = skipRest(dec, tok); err != nil {
+					return usage, timings, err
+				}
+				continue
+			}
+			var u, t gjson.Result
+			if u, t, err = extractFromObject(dec); err != nil {
+				return usage, timings, err
+			}
+			if u.Exists() {
+				usage = u
+			}
+			if t.Exists() {
+				timings = t
+			}
+		}
+		_, err = dec.Token() // closing ]
+	default:
+		return usage, timings, errors.New("response is not a JSON object or array")
+	}
+	if err != nil {
+		return usage, timings, err
+	}
+
+	if _, err := dec.Token(); err != io.EOF {
+		return usage, timings, errors.New("unexpected data after JSON document")
+	}
+	return usage, timings, nil
+}
+
+// extractFromObject reads the members of an object whose opening { has
+// already been consumed, including the closing }
+func extractFromObject(dec *json.Decoder) (usage, timings gjson.Result, err error) {
+	for dec.More() {
+		keyTok, err := dec.Token()
+		if err != nil {
+			return usage, timings, err
+		}
+		key, ok := keyTok.(string)
+		if !ok {
+			return usage, timings, fmt.Errorf("unexpected object key %v", keyTok)
+		}
+
+		if key == "usage" || key == "timings" {
+			var raw json.RawMessage
+			if err := dec.Decode(&raw); err != nil {
+				return usage, timings, err
+			}
+			if key == "usage" {
+				usage = gjson.ParseBytes(raw)
+			} else {
+				timings = gjson.ParseBytes(raw)
+			}
+			continue
+		}
+
+		tok, err := dec.Token()
+		if err != nil {
+			return usage, timings, err
+		}
+		if err := skipRest(dec, tok); err != nil {
+			return usage, timings, err
+		}
+	}
+
+	_, err = dec.Token() // closing }
+	return usage, timings, err
+}
+
+// extractEmbeddings walks an embeddings response like extractUsageTimings
+// and returns its usage, the number of embeddings and the dimensions of the
+// first one. Vectors are counted token by token and never decoded, base64
+// vectors are float32s.
+func extractEmbeddings(r io.Reader) (usage gjson.Result, inputs, dimensions int, err error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+
+	if tok, err := dec.Token(); err != nil {
+		return usage, 0, 0, err
+	} else if tok != json.Delim('{') {
+		return usage, 0, 0, errors.New("response is not a JSON object")
+	}
+
+	for dec.More() {
+		keyTok, err := dec.Token()
+		if err != nil {
+			return usage, 0, 0, err
+		}
+
+		switch keyTok {
+		case "usage":
+			var raw json.RawMessage
+			if err := dec.Decode(&raw); err != nil {
+				return usage, 0, 0, err
+			}
+			usage = gjson.ParseBytes(raw)
+		case "data":
+			if inputs, dimensions, err = countEmbeddings(dec); err != nil {
+				return usage, 0, 0, err
+			}
+		default:
+			tok, err := dec.Token()
+			if err != nil {
+				return usage, 0, 0, err
+			}
+			if err := skipRest(dec, tok); err != nil {
+				return usage, 0, 0, err
+			}
+		}
+	}
+
+	if _, err := dec.Token(); err != nil { // closing }
+		return usage, 0, 0, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return usage, 0, 0, errors.New("unexpected data after JSON document")
+	}
+	return usage, inputs, dimensions, nil
+}
+
+// countEmbeddings reads the data array of an embeddings response
+func countEmbeddings(dec *json.Decoder) (inputs, dimensions int, err error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return 0, 0, err
+	}
+	if tok != json.Delim('[') {
+		return 0, 0, skipRest(dec, tok)
+	}
+
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return 0, 0, err
+		}
+		if tok != json.Delim('{') {
+			if err := skipRest(dec, tok); err != nil {
+				return 0, 0, err
+			}
+			continue
+		}
+
+		for dec.More() {
+			keyTok, err := dec.Token()
+			if err != nil {
+				return 0, 0, err
+			}
+			tok, err := dec.Token()
+			if err != nil {
+				return 0, 0, err
+			}
+			if keyTok != "embedding" {
+				if err := skipRest(dec, tok); err != nil {
+					return 0, 0, err
+				}
+				continue
+			}
+
+			inputs++
+			size := 0
+			switch vector := tok.(type) {
+			case string:
+				padding := len(vector) - len(strings.TrimRight(vector, "="))
+				size = (base64.StdEncoding.DecodedLen(len(vector)) - padding) / 4
+			case json.Delim:
+				if vector != json.Delim('[') {
+					break
+				}
+				for dec.More() {
+					tok, err := dec.Token()
+					if err != nil {
+						return 0, 0, err
+					}
+					if err := skipRest(dec, tok); err != nil {
+						return 0, 0, err
+					}
+					size++
+				}
+				if _, err := dec.Token(); err != nil { // closing ]
+					return 0, 0, err
+				}
+			}
+			if inputs == 1 {
+				dimensions = size
+			}
+		}
+		if _, err := dec.Token(); err != nil { // closing }
+			return 0, 0, err
+		}
+	}
+
+	_, err = dec.Token() // closing ]
+	return inputs, dimensions, err
+}
+
+// skipRest discards the remainder of a value that started with tok
+func skipRest(dec *json.Decoder, tok json.Token) error {
+	if tok != json.Delim('{') && tok != json.Delim('[') {
+		return nil
+	}
+
+	depth := 1
+	for depth > 0 {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+	}
+	return nil
+}
+
+// newDecompressReader returns a reader that decompresses body on the fly
+// based on the Content-Encoding header
+func newDecompressReader(r io.Reader, encoding string) (io.ReadCloser, error) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "gzip":
+		return gzip.NewReader(r)
+	case "deflate":
+		return flate.NewReader(r), nil
+	default:
+		return io.NopCloser(r), nil
+	}
+}
@@ -0,0 +1,121 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExtractUsageTimings(t *testing.T) {
+	t.Run("object with usage and timings", func(t *testing.T) {
+		body := `{"id":"x","choices":[{"message":{"content":"hi {not a key}"}}],"usage":{"prompt_tokens":5,"completion_tokens":7},"timings":{"predicted_n":7}}`
+		usage, timings, err := extractUsageTimings(strings.NewReader(body))
+		assert.NoError(t, err)
+		assert.Equal(t, int64(5), usage.Get("prompt_tokens").Int())
+		assert.Equal(t, int64(7), timings.Get("predicted_n").Int())
+	})
+
+	t.Run("large embeddings are skipped", func(t *testing.T) {
+		var sb strings.Builder
+		sb.WriteString(`{"object":"list","data":[`)
+		for i := 0; i < 100; i++ {
+			if i > 0 {
+				sb.WriteString(",")
+			}
+			fmt.Fprintf(&sb, `{"object":"embedding","index":%d,"embedding":[`, i)
+			for j := 0; j < 512; j++ {
+				if j > 0 {
+					sb.WriteString(",")
+				}
+				sb.WriteString("0.123456")
+			}
+			sb.WriteString("]}")
+		}
+		sb.WriteString(`],"usage":{"prompt_tokens":42,"total_tokens":42}}`)
+
+		usage, timings, err := extractUsageTimings(strings.NewReader(sb.String()))
+		assert.NoError(t, err)
+		assert.Equal(t, int64(42), usage.Get("prompt_tokens").Int())
+		assert.False(t, timings.Exists())
+	})
+
+	t.Run("array uses last element", func(t *testing.T) {
+		body := `[{"content":"a","timings":{"predicted_n":1}},{"content":"b","timings":{"predicted_n":2}}]`
+		_, timings, err := extractUsageTimings(strings.NewReader(body))
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), timings.Get("predicted_n").Int())
+	})
+
+	t.Run("missing usage is not an error", func(t *testing.T) {
+		usage, timings, err := extractUsageTimings(strings.NewReader(`{"data":[]}`))
+		assert.NoError(t, err)
+		assert.False(t, usage.Exists())
+		assert.False(t, timings.Exists())
+	})
+
+	t.Run("invalid JSON", func(t *testing.T) {
+		for _, body := range []string{`{"usage":`, `not json`, `"string"`, `{"a":1} trailing`} {
+			_, _, err := extractUsageTimings(strings.NewReader(body))
+			assert.Error(t, err, body)
+		}
+	})
+
+	t.Run("decompresses on the fly", func(t *testing.T) {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		gz.Write([]byte(`{"usage":{"completion_tokens":3}}`))
+		gz.Close()
+
+		reader, err := newDecompressReader(&buf, "gzip")
+		assert.NoError(t, err)
+		defer reader.Close()
+
+		usage, _, err := extractUsageTimings(reader)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(3), usage.Get("completion_tokens").Int())
+	})
+}
+
+func TestExtractEmbeddings(t *testing.T) {
+	t.Run("counts inputs and dimensions", func(t *testing.T) {
+		body := `{"object":"list","data":[{"object":"embedding","index":0,"embedding":[0.1,-0.2,0.3,0.4]},{"object":"embedding","index":1,"embedding":[0.5,0.6,0.7,0.8]}],"model":"nomic","usage":{"prompt_tokens":12,"total_tokens":12}}`
+		usage, inputs, dimensions, err := extractEmbeddings(strings.NewReader(body))
+		assert.NoError(t, err)
+		assert.Equal(t, int64(12), usage.Get("total_tokens").Int())
+		assert.Equal(t, 2, inputs)
+		assert.Equal(t, 4, dimensions)
+	})
+
+	t.Run("base64 vectors are float32s", func(t *testing.T) {
+		// 3 float32s are 12 bytes, 16 base64 characters without padding
+		// and 5 float32s are 20 bytes, 28 characters with one =
+		body := `{"data":[{"embedding":"AAAAAAAAAAAAAAAA"},{"embedding":"AAAAAAAAAAAAAAAAAAAAAAAAAAA="}],"usage":{"prompt_tokens":2}}`
+		_, inputs, dimensions, err := extractEmbeddings(strings.NewReader(body))
+		assert.NoError(t, err)
+		assert.Equal(t, 2, inputs)
+		assert.Equal(t, 3, dimensions)
+
+		_, _, dimensions, err = extractEmbeddings(strings.NewReader(`{"data":[{"embedding":"AAAAAAAAAAAAAAAAAAAAAAAAAAA="}]}`))
+		assert.NoError(t, err)
+		assert.Equal(t, 5, dimensions)
+	})
+
+	t.Run("missing data", func(t *testing.T) {
+		usage, inputs, dimensions, err := extractEmbeddings(strings.NewReader(`{"usage":{"prompt_tokens":3}}`))
+		assert.NoError(t, err)
+		assert.Equal(t, int64(3), usage.Get("prompt_tokens").Int())
+		assert.Equal(t, 0, inputs)
+		assert.Equal(t, 0, dimensions)
+	})
+
+	t.Run("invalid JSON", func(t *testing.T) {
+		for _, body := range []string{`{"data":[`, `[]`, `{"data":[{"embedding":[1,2}]}`, `{} trailing`} {
+			_, _, _, err := extractEmbeddings(strings.NewReader(body))
+			assert.Error(t, err, body)
+		}
+	})
+}
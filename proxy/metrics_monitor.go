@@ -2,8 +2,6 @@ package proxy
 
 import (
 	"bytes"
-	"compress/flate"
-	"compress/gzip"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -14,7 +12,9 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/napmany/llmsnap/event"
+	"github.com/napmany/llmsnap/proxy/config"
 	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
 )
 
 // TokenMetrics represents parsed token statistics from llama-server logs
@@ -29,6 +29,32 @@ type TokenMetrics struct {
 	TokensPerSecond float64   `json:"tokens_per_second"`
 	DurationMs      int       `json:"duration_ms"`
 	HasCapture      bool      `json:"has_capture"`
+	Guardrail       string    `json:"guardrail,omitempty"`
+	Client          string    `json:"client,omitempty"`
+	Tenant          string    `json:"tenant,omitempty"`
+
+	// RequestID matches the request's access log line
+	RequestID string `json:"request_id,omitempty"`
+
+	// EmbeddingInputs and EmbeddingDimensions describe the vectors of an
+	// embeddings response, they are 0 for other responses
+	EmbeddingInputs     int `json:"embedding_inputs,omitempty"`
+	EmbeddingDimensions int `json:"embedding_dimensions,omitempty"`
+
+	// Cost of the request from the model's pricing, 0 without pricing
+	Cost float64 `json:"cost,omitempty"`
+
+	// TTFTMs is the time to first token of a streamed response, from the
+	// request reaching the handler to the first byte of the stream
+	TTFTMs int `json:"ttft_ms,omitempty"`
+
+	// Peer is the peer that served the request, only set in the activity
+	// pulled from peers
+	Peer string `json:"peer,omitempty"`
+
+	// CacheHit is set when the response came from the response cache and
+	// the upstream was skipped
+	CacheHit bool `json:"cache_hit,omitempty"`
 }
 
 type ReqRespCapture struct {
@@ -52,6 +78,15 @@ func (c *ReqRespCapture) Size() int {
 	return size
 }
 
+// CaptureExport is a capture with the metrics of its request, one line of
+// the JSONL written by GET /api/captures
+type CaptureExport struct {
+	ReqRespCapture
+	Model      string    `json:"model,omitempty"`
+	Timestamp  time.Time `json:"timestamp,omitzero"`
+	DurationMs int       `json:"duration_ms,omitempty"`
+}
+
 // TokenMetricsEvent represents a token metrics event
 type TokenMetricsEvent struct {
 	Metrics TokenMetrics
@@ -75,6 +110,23 @@ type metricsMonitor struct {
 	captureOrder   []int                  // track insertion order for FIFO eviction
 	captureSize    int                    // current total size in bytes
 	maxCaptureSize int                    // max bytes for captures
+
+	// removes personal data from captures and logged bodies, may be nil
+	scrubber *scrubber
+
+	// posts recorded metrics to model webhooks, may be nil
+	tee *webhookTee
+
+	// persists recorded metrics, may be nil
+	store *metricsStore
+
+	// prices of the models with pricing
+	pricing map[string]config.PricingConfig
+
+	// requests that failed, for /api/errors and the error counts of
+	// /api/stats
+	errors      []RequestError
+	nextErrorID int
 }
 
 // newMetricsMonitor creates a new metricsMonitor. captureBufferMB is the
@@ -99,14 +151,36 @@ func (mp *metricsMonitor) addMetrics(metric TokenMetrics) int {
 
 	metric.ID = mp.nextID
 	mp.nextID++
+	if pricing, found := mp.pricing[metric.Model]; found {
+		metric.Cost = pricing.Cost(metric.InputTokens, metric.OutputTokens)
+	}
 	mp.metrics = append(mp.metrics, metric)
 	if len(mp.metrics) > mp.maxMetrics {
 		mp.metrics = mp.metrics[len(mp.metrics)-mp.maxMetrics:]
 	}
+	mp.store.send(metric)
 	event.Emit(TokenMetricsEvent{Metrics: metric})
 	return metric.ID
 }
 
+// restoreMetrics fills the history with metrics from a previous run, oldest
+// first. Their captures are gone so HasCapture is cleared.
+func (mp *metricsMonitor) restoreMetrics(history []TokenMetrics) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	if len(history) > mp.maxMetrics {
+		history = history[len(history)-mp.maxMetrics:]
+	}
+	restored := make([]TokenMetrics, 0, len(history)+len(mp.metrics))
+	for _, metric := range history {
+		metric.HasCapture = false
+		restored = append(restored, metric)
+		mp.nextID = max(mp.nextID, metric.ID+1)
+	}
+	mp.metrics = append(restored, mp.metrics...)
+}
+
 // addCapture adds a new capture to the buffer with size-based eviction.
 // Captures are skipped if enableCaptures is false or if capture exceeds maxCaptureSize.
 func (mp *metricsMonitor) addCapture(capture ReqRespCapture) {
@@ -149,6 +223,30 @@ func (mp *metricsMonitor) getCaptureByID(id int) *ReqRespCapture {
 	return nil
 }
 
+// getCaptures returns the stored captures, oldest first, with the metrics
+// of their requests when those have not been evicted yet
+func (mp *metricsMonitor) getCaptures() []CaptureExport {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	metrics := make(map[int]TokenMetrics, len(mp.metrics))
+	for _, metric := range mp.metrics {
+		metrics[metric.ID] = metric
+	}
+
+	result := make([]CaptureExport, 0, len(mp.captureOrder))
+	for _, id := range mp.captureOrder {
+		export := CaptureExport{ReqRespCapture: mp.captures[id]}
+		if metric, ok := metrics[id]; ok {
+			export.Model = metric.Model
+			export.Timestamp = metric.Timestamp
+			export.DurationMs = metric.DurationMs
+		}
+		result = append(result, export)
+	}
+	return result
+}
+
 // getMetrics returns a copy of the current metrics
 func (mp *metricsMonitor) getMetrics() []TokenMetrics {
 	mp.mu.RLock()
@@ -159,6 +257,19 @@ func (mp *metricsMonitor) getMetrics() []TokenMetrics {
 	return result
 }
 
+// getMetricsByRequestID returns the metrics of the request with id
+func (mp *metricsMonitor) getMetricsByRequestID(id string) (TokenMetrics, bool) {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	for i := len(mp.metrics) - 1; i >= 0; i-- {
+		if mp.metrics[i].RequestID == id {
+			return mp.metrics[i], true
+		}
+	}
+	return TokenMetrics{}, false
+}
+
 // getMetricsJSON returns metrics as JSON
 func (mp *metricsMonitor) getMetricsJSON() ([]byte, error) {
 	mp.mu.RLock()
@@ -197,8 +308,27 @@ func (mp *metricsMonitor) wrapHandler(
 		redactHeaders(reqHeaders)
 	}
 
+	// JSON responses are parsed while they are written so they are not held
+	// in memory, unless captures keep the body anyway. Only usage and timings
+	// are pulled out, see extractUsageTimings. For infill the response is an
+	// array and timings are in the last element, see #463.
+	var usage, timings gjson.Result
+	var inputs, dimensions int
+	parse := func(r io.Reader) (err error) {
+		if request.URL.Path == "/v1/embeddings" {
+			usage, inputs, dimensions, err = extractEmbeddings(r)
+		} else {
+			usage, timings, err = extractUsageTimings(r)
+		}
+		return err
+	}
+
 	requestStartTime := time.Now()
 	recorder := newBodyCopier(writer, requestStartTime)
+	if !mp.enableCaptures {
+		recorder.parse = parse
+	}
+	defer recorder.finishParsing()
 
 	// Filter Accept-Encoding to only include encodings we can decompress for metrics
 	if ae := request.Header.Get("Accept-Encoding"); ae != "" {
@@ -206,70 +336,102 @@ func (mp *metricsMonitor) wrapHandler(
 	}
 
 	if err := next(modelID, recorder, request); err != nil {
+		// the caller responds with a 500
+		mp.addError(mp.newRequestError(modelID, request, http.StatusInternalServerError, requestStartTime, []byte(err.Error())))
 		return err
 	}
 
+	// the metrics go to the fallback that served the request
+	if served := servedModelID(request); served != "" {
+		modelID = served
+	}
+
 	// after this point we have to assume that data was sent to the client
 	// and we can only log errors but not send them to clients
 
 	if recorder.Status() != http.StatusOK {
-		errorMsg := string(recorder.body.Bytes())
-		mp.logger.Warnf("metrics skipped, HTTP status=%d, path=%s, error=%s", recorder.Status(), request.URL.Path, errorMsg)
+		body := recorder.body.Bytes()
+		if encoding := recorder.Header().Get("Content-Encoding"); encoding != "" {
+			if decompressed, err := decompressBody(body, encoding); err == nil {
+				body = decompressed
+			}
+		}
+		requestError := mp.newRequestError(modelID, request, recorder.Status(), requestStartTime, body)
+		mp.logger.Warnf("metrics skipped, HTTP status=%d, path=%s, error=%s", recorder.Status(), request.URL.Path, requestError.Error)
+		mp.addError(requestError)
 		return nil
 	}
 
+	// set by checkGuardrail when the request was checked
+	guardrail, _ := request.Context().Value(proxyCtxKey("guardrail")).(string)
+	client := requestClientName(request)
+	tenant := requestTenantName(request)
+
 	// Initialize default metrics - these will always be recorded
 	tm := TokenMetrics{
 		Timestamp:  time.Now(),
 		Model:      modelID,
 		DurationMs: int(time.Since(recorder.StartTime()).Milliseconds()),
+		Guardrail:  guardrail,
+		Client:     client,
+		Tenant:     tenant,
+		RequestID:  requestID(request),
 	}
 
+	parseErr := recorder.finishParsing()
 	body := recorder.body.Bytes()
-	if len(body) == 0 {
+	if recorder.written == 0 {
 		mp.logger.Warn("metrics: empty body, recording minimal metrics")
-		mp.addMetrics(tm)
+		tm.ID = mp.addMetrics(tm)
+		mp.tee.send(tm, nil)
 		return nil
 	}
 
-	// Decompress if needed
-	if encoding := recorder.Header().Get("Content-Encoding"); encoding != "" {
+	encoding := recorder.Header().Get("Content-Encoding")
+	isStreaming := strings.Contains(recorder.Header().Get("Content-Type"), "text/event-stream")
+
+	// Decompress the whole body only when it is needed, SSE parsing reads
+	// the events in memory and captures store the plain body. Other JSON
+	// responses were decompressed and parsed while they were written.
+	if encoding != "" && !recorder.parsing() && (isStreaming || mp.enableCaptures) {
 		var err error
 		body, err = decompressBody(body, encoding)
 		if err != nil {
 			mp.logger.Warnf("metrics: decompression failed: %v, path=%s, recording minimal metrics", err, request.URL.Path)
-			mp.addMetrics(tm)
+			tm.ID = mp.addMetrics(tm)
+			mp.tee.send(tm, nil)
 			return nil
 		}
+		encoding = ""
 	}
-	if strings.Contains(recorder.Header().Get("Content-Type"), "text/event-stream") {
+	if isStreaming {
 		if parsed, err := processStreamingResponse(modelID, recorder.RequestTime(), body); err != nil {
 			mp.logger.Warnf("error processing streaming response: %v, path=%s, recording minimal metrics", err, request.URL.Path)
 		} else {
 			tm = parsed
 		}
 	} else {
-		if gjson.ValidBytes(body) {
-			parsed := gjson.ParseBytes(body)
-			usage := parsed.Get("usage")
-			timings := parsed.Get("timings")
-
-			// extract timings for infill - response is an array, timings are in the last element
-			// see #463
-			if strings.HasPrefix(request.URL.Path, "/infill") {
-				if arr := parsed.Array(); len(arr) > 0 {
-					timings = arr[len(arr)-1].Get("timings")
-				}
+		if !recorder.parsing() {
+			reader, err := newDecompressReader(bytes.NewReader(body), encoding)
+			if err != nil {
+				mp.logger.Warnf("metrics: decompression failed: %v, path=%s, recording minimal metrics", err, request.URL.Path)
+				tm.ID = mp.addMetrics(tm)
+				mp.tee.send(tm, nil)
+				return nil
 			}
+			parseErr = parse(reader)
+			reader.Close()
+		}
 
+		if parseErr != nil {
+			mp.logger.Warnf("metrics: invalid JSON in response body path=%s, recording minimal metrics", request.URL.Path)
+		} else if request.URL.Path == "/v1/embeddings" {
+			tm = embeddingsMetrics(modelID, recorder.RequestTime(), usage, inputs, dimensions)
+		} else if parsedMetrics, err := parseMetrics(modelID, recorder.RequestTime(), usage, timings); err != nil {
 			// Track metrics even if usage/timings are missing (graceful degradation)
-			if parsedMetrics, err := parseMetrics(modelID, recorder.RequestTime(), usage, timings); err != nil {
-				mp.logger.Warnf("error parsing metrics: %v, path=%s, recording minimal metrics", err, request.URL.Path)
-			} else {
-				tm = parsedMetrics
-			}
+			mp.logger.Warnf("error parsing metrics: %v, path=%s, recording minimal metrics", err, request.URL.Path)
 		} else {
-			mp.logger.Warnf("metrics: invalid JSON in response body path=%s, recording minimal metrics", request.URL.Path)
+			tm = parsedMetrics
 		}
 	}
 
@@ -287,9 +449,9 @@ func (mp *metricsMonitor) wrapHandler(
 		capture = &ReqRespCapture{
 			ReqPath:     request.URL.Path,
 			ReqHeaders:  reqHeaders,
-			ReqBody:     reqBody,
+			ReqBody:     mp.scrubber.scrub(reqBody),
 			RespHeaders: respHeaders,
-			RespBody:    body,
+			RespBody:    mp.scrubber.scrub(body),
 		}
 		// Only set HasCapture if the capture will actually be stored (not too large)
 		if capture.Size() <= mp.maxCaptureSize {
@@ -297,6 +459,13 @@ func (mp *metricsMonitor) wrapHandler(
 		}
 	}
 
+	tm.Guardrail = guardrail
+	tm.Client = client
+	tm.Tenant = tenant
+	tm.RequestID = requestID(request)
+	if isStreaming && !recorder.StartTime().IsZero() {
+		tm.TTFTMs = int(recorder.StartTime().Sub(recorder.RequestTime()).Milliseconds())
+	}
 	metricID := mp.addMetrics(tm)
 
 	// Store capture if enabled
@@ -305,58 +474,78 @@ func (mp *metricsMonitor) wrapHandler(
 		mp.addCapture(*capture)
 	}
 
+	tm.ID = metricID
+	if !tm.HasCapture {
+		capture = nil
+	}
+	mp.tee.send(tm, capture)
+
 	return nil
 }
 
+// processStreamingResponse reads the token usage of a stream. OpenAI style
+// streams report it in the last event with usage or timings. Anthropic
+// streams report the input tokens in message_start and the output tokens in
+// message_delta, the Responses API reports it in response.completed.
 func processStreamingResponse(modelID string, start time.Time, body []byte) (TokenMetrics, error) {
-	// Iterate **backwards** through the body looking for the data payload with
-	// usage data. This avoids allocating a slice of all lines via bytes.Split.
-
-	// Start from the end of the body and scan backwards for newlines
-	pos := len(body)
 	foundValidJSON := false
-	for pos > 0 {
-		// Find the previous newline (or start of body)
-		lineStart := bytes.LastIndexByte(body[:pos], '\n')
-		if lineStart == -1 {
-			lineStart = 0
-		} else {
-			lineStart++ // Move past the newline
-		}
+	var usage, timings gjson.Result
 
-		line := bytes.TrimSpace(body[lineStart:pos])
-		pos = lineStart - 1 // Move position before the newline for next iteration
+	// usage of an Anthropic message, merged from its events
+	var messageUsage string
 
-		if len(line) == 0 {
-			continue
+	forEachSSEEvent(body, func(event sseEvent) {
+		data := bytes.TrimSpace(event.data)
+		if len(data) == 0 || bytes.Equal(data, []byte("[DONE]")) {
+			return
 		}
 
-		// SSE payload always follows "data:"
-		prefix := []byte("data:")
-		if !bytes.HasPrefix(line, prefix) {
-			continue
+		// only events that can carry usage are parsed once the stream is
+		// known to be JSON
+		if foundValidJSON && !bytes.Contains(data, []byte(`"usage"`)) && !bytes.Contains(data, []byte(`"timings"`)) {
+			return
 		}
-		data := bytes.TrimSpace(line[len(prefix):])
-
-		if len(data) == 0 {
-			continue
+		if !gjson.ValidBytes(data) {
+			return
 		}
+		foundValidJSON = true
 
-		if bytes.Equal(data, []byte("[DONE]")) {
-			// [DONE] line itself contains nothing of interest.
-			continue
+		parsed := gjson.ParseBytes(data)
+		eventType := event.name
+		if eventType == "" {
+			eventType = parsed.Get("type").String()
 		}
 
-		if gjson.ValidBytes(data) {
-			foundValidJSON = true
-			parsed := gjson.ParseBytes(data)
-			usage := parsed.Get("usage")
-			timings := parsed.Get("timings")
-
-			if usage.Exists() || timings.Exists() {
-				return parseMetrics(modelID, start, usage, timings)
+		switch eventType {
+		case "message_start":
+			messageUsage = mergeUsage(messageUsage, parsed.Get("message.usage"))
+		case "message_delta":
+			messageUsage = mergeUsage(messageUsage, parsed.Get("usage"))
+		case "response.completed", "response.incomplete", "response.failed":
+			if u := parsed.Get("response.usage"); u.IsObject() {
+				usage = u
 			}
+		default:
+			u, tm := parsed.Get("usage"), parsed.Get("timings")
+			if !u.IsObject() && !tm.IsObject() {
+				return
+			}
+			usage, timings = u, tm
+			return
+		}
+
+		// llama-server adds timings to the events of every API
+		if tm := parsed.Get("timings"); tm.IsObject() {
+			timings = tm
 		}
+	})
+
+	if messageUsage != "" {
+		usage = gjson.Parse(messageUsage)
+	}
+
+	if usage.IsObject() || timings.IsObject() {
+		return parseMetrics(modelID, start, usage, timings)
 	}
 
 	// If we found valid JSON but no usage/timings, still track the activity with unknown values
@@ -367,6 +556,39 @@ func processStreamingResponse(modelID string, start time.Time, body []byte) (Tok
 	return TokenMetrics{}, fmt.Errorf("no valid JSON data found in stream")
 }
 
+// embeddingsMetrics records the tokens of an embeddings response as prompt
+// tokens, they are all processed at prompt speed
+func embeddingsMetrics(modelID string, start time.Time, usage gjson.Result, inputs, dimensions int) TokenMetrics {
+	tm, _ := parseMetrics(modelID, start, usage, gjson.Result{})
+	if tm.InputTokens == 0 {
+		tm.InputTokens = int(usage.Get("total_tokens").Int())
+	}
+	if tm.PromptPerSecond < 0 && tm.InputTokens > 0 && tm.DurationMs > 0 {
+		tm.PromptPerSecond = float64(tm.InputTokens) / (float64(tm.DurationMs) / 1000.0)
+	}
+	tm.EmbeddingInputs = inputs
+	tm.EmbeddingDimensions = dimensions
+	return tm
+}
+
+// mergeUsage sets the fields of usage on merged, a JSON object, the fields
+// of later events replace those of earlier ones
+func mergeUsage(merged string, usage gjson.Result) string {
+	if !usage.IsObject() {
+		return merged
+	}
+	if merged == "" {
+		return usage.Raw
+	}
+	usage.ForEach(func(key, value gjson.Result) bool {
+		if value.Type != gjson.Null {
+			merged, _ = sjson.SetRaw(merged, key.String(), value.Raw)
+		}
+		return true
+	})
+	return merged
+}
+
 func parseMetrics(modelID string, start time.Time, usage, timings gjson.Result) (TokenMetrics, error) {
 	// default values
 	cachedTokens := -1 // unknown or missing data
@@ -434,30 +656,34 @@ func parseMetrics(modelID string, start time.Time, usage, timings gjson.Result)
 // decompressBody decompresses the body based on Content-Encoding header
 func decompressBody(body []byte, encoding string) ([]byte, error) {
 	switch strings.ToLower(strings.TrimSpace(encoding)) {
-	case "gzip":
-		reader, err := gzip.NewReader(bytes.NewReader(body))
+	case "gzip", "deflate":
+		reader, err := newDecompressReader(bytes.NewReader(body), encoding)
 		if err != nil {
 			return nil, err
 		}
 		defer reader.Close()
 		return io.ReadAll(reader)
-	case "deflate":
-		reader := flate.NewReader(bytes.NewReader(body))
-		defer reader.Close()
-		return io.ReadAll(reader)
 	default:
 		return body, nil // Return as-is for unknown/no encoding
 	}
 }
 
 // responseBodyCopier records the response body and writes to the original response writer
-// while also capturing it in a buffer for later processing
+// while also capturing it in a buffer for later processing. With parse set,
+// successful responses that are not event streams are not buffered, parse
+// reads them through a pipe while they are written.
 type responseBodyCopier struct {
 	gin.ResponseWriter
 	body        *bytes.Buffer
 	tee         io.Writer
 	start       time.Time // Time of first write (for TTFT calculation)
 	requestTime time.Time // Time when request handler started (for total duration)
+	written     int
+
+	parse    func(r io.Reader) error
+	pipe     *io.PipeWriter
+	parsed   chan struct{}
+	parseErr error
 }
 
 func newBodyCopier(w gin.ResponseWriter, requestTime time.Time) *responseBodyCopier {
@@ -473,10 +699,51 @@ func newBodyCopier(w gin.ResponseWriter, requestTime time.Time) *responseBodyCop
 func (w *responseBodyCopier) Write(b []byte) (int, error) {
 	if w.start.IsZero() {
 		w.start = time.Now()
+		if w.parse != nil && w.Status() == http.StatusOK && !strings.Contains(w.Header().Get("Content-Type"), "text/event-stream") {
+			w.startParsing()
+		}
 	}
 
 	// Single write operation that writes to both the response and buffer
-	return w.tee.Write(b)
+	n, err := w.tee.Write(b)
+	w.written += n
+	return n, err
+}
+
+// startParsing sends the body to parse instead of the buffer
+func (w *responseBodyCopier) startParsing() {
+	reader, writer := io.Pipe()
+	w.pipe = writer
+	w.parsed = make(chan struct{})
+	w.tee = io.MultiWriter(w.ResponseWriter, writer)
+
+	encoding := w.Header().Get("Content-Encoding")
+	go func() {
+		defer close(w.parsed)
+		decompressed, err := newDecompressReader(reader, encoding)
+		if err == nil {
+			err = w.parse(decompressed)
+			decompressed.Close()
+		}
+		w.parseErr = err
+		// the rest of the body is not needed, it must not block the writes
+		io.Copy(io.Discard, reader)
+	}()
+}
+
+// parsing reports if the body went to parse instead of the buffer
+func (w *responseBodyCopier) parsing() bool {
+	return w.pipe != nil
+}
+
+// finishParsing ends the body and returns once parse is done with it
+func (w *responseBodyCopier) finishParsing() error {
+	if w.pipe == nil {
+		return nil
+	}
+	w.pipe.Close()
+	<-w.parsed
+	return w.parseErr
 }
 
 func (w *responseBodyCopier) WriteHeader(statusCode int) {
@@ -5,15 +5,21 @@ import (
 	"compress/flate"
 	"compress/gzip"
 	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"slices"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/napmany/llmsnap/event"
+	"github.com/napmany/llmsnap/proxy/config"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
 )
 
 func TestMetricsMonitor_AddMetrics(t *testing.T) {
@@ -274,6 +280,55 @@ data: [DONE]
 		assert.Equal(t, 20, metrics[0].OutputTokens)
 	})
 
+	t.Run("streaming request records time to first token", func(t *testing.T) {
+		mm := newMetricsMonitor(testLogger, 10, 0)
+
+		nextHandler := func(modelID string, w http.ResponseWriter, r *http.Request) error {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.WriteHeader(http.StatusOK)
+			time.Sleep(50 * time.Millisecond)
+			w.Write([]byte("data: {\"choices\":[{\"text\":\"Hello\"}]}\n\n"))
+			time.Sleep(50 * time.Millisecond)
+			w.Write([]byte("data: {\"usage\":{\"prompt_tokens\":10,\"completion_tokens\":20}}\n\ndata: [DONE]\n\n"))
+			return nil
+		}
+
+		req := httptest.NewRequest("POST", "/test", nil)
+		rec := httptest.NewRecorder()
+		ginCtx, _ := gin.CreateTestContext(rec)
+
+		err := mm.wrapHandler("test-model", ginCtx.Writer, req, nextHandler)
+		assert.NoError(t, err)
+
+		metrics := mm.getMetrics()
+		require.Len(t, metrics, 1)
+		assert.GreaterOrEqual(t, metrics[0].TTFTMs, 50)
+		assert.Less(t, metrics[0].TTFTMs, 100)
+	})
+
+	t.Run("non-streaming request has no time to first token", func(t *testing.T) {
+		mm := newMetricsMonitor(testLogger, 10, 0)
+
+		nextHandler := func(modelID string, w http.ResponseWriter, r *http.Request) error {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			time.Sleep(10 * time.Millisecond)
+			w.Write([]byte(`{"usage":{"prompt_tokens":10,"completion_tokens":20}}`))
+			return nil
+		}
+
+		req := httptest.NewRequest("POST", "/test", nil)
+		rec := httptest.NewRecorder()
+		ginCtx, _ := gin.CreateTestContext(rec)
+
+		err := mm.wrapHandler("test-model", ginCtx.Writer, req, nextHandler)
+		assert.NoError(t, err)
+
+		metrics := mm.getMetrics()
+		require.Len(t, metrics, 1)
+		assert.Zero(t, metrics[0].TTFTMs)
+	})
+
 	t.Run("non-OK status code does not record metrics", func(t *testing.T) {
 		mm := newMetricsMonitor(testLogger, 10, 0)
 
@@ -432,6 +487,41 @@ func TestMetricsMonitor_ResponseBodyCopier(t *testing.T) {
 		assert.Equal(t, http.StatusCreated, copier.Status())
 	})
 
+	t.Run("parses JSON bodies while they are written", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		ginCtx, _ := gin.CreateTestContext(rec)
+		copier := newBodyCopier(ginCtx.Writer, time.Now())
+		var usage gjson.Result
+		copier.parse = func(r io.Reader) (err error) {
+			usage, _, err = extractUsageTimings(r)
+			return err
+		}
+
+		body := `{"choices":[{"text":"` + strings.Repeat("x", 64*1024) + `"}],"usage":{"completion_tokens":7}}`
+		for chunk := range slices.Chunk([]byte(body), 1000) {
+			_, err := copier.Write(chunk)
+			require.NoError(t, err)
+		}
+
+		assert.NoError(t, copier.finishParsing())
+		assert.True(t, copier.parsing())
+		assert.Equal(t, 0, copier.body.Len(), "the body is not buffered")
+		assert.Equal(t, int64(7), usage.Get("completion_tokens").Int())
+		assert.Equal(t, body, rec.Body.String())
+	})
+
+	t.Run("buffers error responses", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		ginCtx, _ := gin.CreateTestContext(rec)
+		copier := newBodyCopier(ginCtx.Writer, time.Now())
+		copier.parse = func(r io.Reader) error { return nil }
+
+		copier.WriteHeader(http.StatusBadRequest)
+		copier.Write([]byte(`{"error":"bad"}`))
+		assert.False(t, copier.parsing())
+		assert.Equal(t, `{"error":"bad"}`, copier.body.String())
+	})
+
 	t.Run("tracks request start time", func(t *testing.T) {
 		rec := httptest.NewRecorder()
 		ginCtx, _ := gin.CreateTestContext(rec)
@@ -740,6 +830,101 @@ func TestMetricsMonitor_ParseMetrics(t *testing.T) {
 	})
 }
 
+func TestProcessStreamingResponse_Events(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         string
+		inputTokens  int
+		outputTokens int
+		cachedTokens int
+	}{
+		{
+			name: "anthropic usage is merged from message_start and message_delta",
+			body: `event: message_start
+data: {"type":"message_start","message":{"id":"msg_1","usage":{"input_tokens":25,"cache_read_input_tokens":10,"output_tokens":1}}}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"usage"}}
+
+event: ping
+data: {"type":"ping"}
+
+event: message_delta
+data: {"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":15,"input_tokens":null}}
+
+event: message_stop
+data: {"type":"message_stop"}
+
+`,
+			inputTokens:  25,
+			outputTokens: 15,
+			cachedTokens: 10,
+		},
+		{
+			name: "anthropic events are found by type without event lines",
+			body: `data: {"type":"message_start","message":{"usage":{"input_tokens":7,"output_tokens":1}}}
+
+data: {"type":"message_delta","usage":{"output_tokens":3}}
+
+`,
+			inputTokens:  7,
+			outputTokens: 3,
+			cachedTokens: -1,
+		},
+		{
+			name: "responses api usage is read from response.completed",
+			body: `event: response.created
+data: {"type":"response.created","response":{"usage":null}}
+
+event: response.output_text.delta
+data: {"type":"response.output_text.delta","delta":"Hi"}
+
+event: response.completed
+data: {"type":"response.completed","response":{"usage":{"input_tokens":12,"output_tokens":4}}}
+
+`,
+			inputTokens:  12,
+			outputTokens: 4,
+			cachedTokens: -1,
+		},
+		{
+			name: "data split over several lines",
+			body: `data: {"choices":[],
+data:  "usage":{"prompt_tokens":9,"completion_tokens":2}}
+
+data: [DONE]
+
+`,
+			inputTokens:  9,
+			outputTokens: 2,
+			cachedTokens: -1,
+		},
+		{
+			name: "null usage in later chunks does not hide the usage",
+			body: `data: {"choices":[],"usage":{"prompt_tokens":5,"completion_tokens":6}}
+
+data: {"choices":[],"usage":null}
+
+data: [DONE]
+
+`,
+			inputTokens:  5,
+			outputTokens: 6,
+			cachedTokens: -1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metrics, err := processStreamingResponse("test-model", time.Now(), []byte(tt.body))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.inputTokens, metrics.InputTokens)
+			assert.Equal(t, tt.outputTokens, metrics.OutputTokens)
+			assert.Equal(t, tt.cachedTokens, metrics.CachedTokens)
+		})
+	}
+}
+
 func TestMetricsMonitor_StreamingResponse(t *testing.T) {
 	t.Run("finds metrics in last valid SSE data", func(t *testing.T) {
 		mm := newMetricsMonitor(testLogger, 10, 0)
@@ -1263,6 +1448,31 @@ func TestMetricsMonitor_WrapHandler_Capture(t *testing.T) {
 		assert.Equal(t, "header-value", capture.RespHeaders["X-Custom"])
 	})
 
+	t.Run("scrubs captured bodies", func(t *testing.T) {
+		mm := newMetricsMonitor(testLogger, 10, 5)
+		mm.scrubber = newScrubber(config.ScrubConfig{Detectors: []string{"email"}})
+
+		nextHandler := func(modelID string, w http.ResponseWriter, r *http.Request) error {
+			body, _ := io.ReadAll(r.Body)
+			// the upstream still receives the original body
+			assert.Contains(t, string(body), "jane@example.com")
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"reply": "mail jane@example.com"}`))
+			return nil
+		}
+
+		req := httptest.NewRequest("POST", "/test", bytes.NewBufferString(`{"prompt": "I am jane@example.com"}`))
+		rec := httptest.NewRecorder()
+		ginCtx, _ := gin.CreateTestContext(rec)
+
+		assert.NoError(t, mm.wrapHandler("test-model", ginCtx.Writer, req, nextHandler))
+		capture := mm.getCaptureByID(mm.getMetrics()[0].ID)
+		if assert.NotNil(t, capture) {
+			assert.Equal(t, `{"prompt": "I am [EMAIL]"}`, string(capture.ReqBody))
+			assert.Equal(t, `{"reply": "mail [EMAIL]"}`, string(capture.RespBody))
+		}
+	})
+
 	t.Run("does not capture when disabled", func(t *testing.T) {
 		mm := newMetricsMonitor(testLogger, 10, 0)
 
@@ -1292,3 +1502,53 @@ func TestMetricsMonitor_WrapHandler_Capture(t *testing.T) {
 		assert.Nil(t, capture)
 	})
 }
+
+func TestMetricsMonitor_GetCaptures(t *testing.T) {
+	mm := newMetricsMonitor(testLogger, 1, 5)
+
+	first := mm.addMetrics(TokenMetrics{Model: "model1", DurationMs: 100})
+	mm.addCapture(ReqRespCapture{ID: first, ReqPath: "/v1/chat/completions"})
+	second := mm.addMetrics(TokenMetrics{Model: "model2", DurationMs: 200})
+	mm.addCapture(ReqRespCapture{ID: second, ReqPath: "/v1/completions"})
+
+	captures := mm.getCaptures()
+	if assert.Len(t, captures, 2) {
+		// the metrics of the first request were evicted
+		assert.Equal(t, "/v1/chat/completions", captures[0].ReqPath)
+		assert.Empty(t, captures[0].Model)
+		assert.True(t, captures[0].Timestamp.IsZero())
+
+		assert.Equal(t, "/v1/completions", captures[1].ReqPath)
+		assert.Equal(t, "model2", captures[1].Model)
+		assert.Equal(t, 200, captures[1].DurationMs)
+	}
+}
+
+func TestMetricsMonitor_EmbeddingsResponse(t *testing.T) {
+	mm := newMetricsMonitor(testLogger, 10, 0)
+
+	responseBody := `{"object":"list","data":[{"object":"embedding","index":0,"embedding":[0.1,0.2,0.3]},{"object":"embedding","index":1,"embedding":[0.4,0.5,0.6]}],"usage":{"prompt_tokens":0,"total_tokens":20}}`
+	nextHandler := func(modelID string, w http.ResponseWriter, r *http.Request) error {
+		time.Sleep(10 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(responseBody))
+		return nil
+	}
+
+	req := httptest.NewRequest("POST", "/v1/embeddings", nil)
+	rec := httptest.NewRecorder()
+	ginCtx, _ := gin.CreateTestContext(rec)
+
+	err := mm.wrapHandler("embed-model", ginCtx.Writer, req, nextHandler)
+	assert.NoError(t, err)
+
+	metrics := mm.getMetrics()
+	require.Len(t, metrics, 1)
+	assert.Equal(t, 2, metrics[0].EmbeddingInputs)
+	assert.Equal(t, 3, metrics[0].EmbeddingDimensions)
+	assert.Equal(t, 20, metrics[0].InputTokens)
+	assert.Equal(t, 0, metrics[0].OutputTokens)
+	assert.Greater(t, metrics[0].PromptPerSecond, 0.0)
+	assert.Equal(t, -1.0, metrics[0].TokensPerSecond)
+}
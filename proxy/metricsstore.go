@@ -0,0 +1,234 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/napmany/llmsnap/proxy/config"
+)
+
+// metrics waiting to be written, more are dropped
+const metricsStoreQueueSize = 1000
+
+// metricsStore appends activity metrics to a JSON lines file in the
+// background so clients never wait for it. The file is pruned to the
+// configured retention on startup, hourly and when it holds twice
+// maxRecords.
+type metricsStore struct {
+	conf   config.MetricsStoreConfig
+	queue  chan TokenMetrics
+	logger *LogMonitor
+
+	// only used by run
+	file    *os.File
+	records int
+
+	// closed when run returned
+	done chan struct{}
+}
+
+func newMetricsStore(conf config.MetricsStoreConfig, logger *LogMonitor) *metricsStore {
+	return &metricsStore{
+		conf:   conf,
+		queue:  make(chan TokenMetrics, metricsStoreQueueSize),
+		logger: logger,
+		done:   make(chan struct{}),
+	}
+}
+
+// load prunes the file and returns the metrics it keeps, oldest first
+func (s *metricsStore) load(now time.Time) ([]TokenMetrics, error) {
+	if err := os.MkdirAll(filepath.Dir(s.conf.File), 0755); err != nil {
+		return nil, err
+	}
+	records, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	records = s.retain(records, now)
+	if err := s.rewrite(records); err != nil {
+		return nil, err
+	}
+	s.records = len(records)
+	return records, nil
+}
+
+// read returns the metrics in the file, none when it does not exist yet.
+// Lines that can not be parsed, like one cut short by a crash, are skipped.
+func (s *metricsStore) read() ([]TokenMetrics, error) {
+	file, err := os.Open(s.conf.File)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var records []TokenMetrics
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var metric TokenMetrics
+		if err := json.Unmarshal(line, &metric); err != nil {
+			s.logger.Warnf("metricsStore: skipping invalid line in %s: %v", s.conf.File, err)
+			continue
+		}
+		records = append(records, metric)
+	}
+	return records, scanner.Err()
+}
+
+// retain drops the metrics older than maxAge and all but the last maxRecords
+func (s *metricsStore) retain(records []TokenMetrics, now time.Time) []TokenMetrics {
+	if maxAge := s.conf.MaxAgeDuration(); maxAge > 0 {
+		cutoff := now.Add(-maxAge)
+		kept := records[:0]
+		for _, metric := range records {
+			if metric.Timestamp.After(cutoff) {
+				kept = append(kept, metric)
+			}
+		}
+		records = kept
+	}
+	if s.conf.MaxRecords > 0 && len(records) > s.conf.MaxRecords {
+		records = records[len(records)-s.conf.MaxRecords:]
+	}
+	return records
+}
+
+// rewrite replaces the file with records. It writes to a temporary file
+// first so a crash never leaves a partial file.
+func (s *metricsStore) rewrite(records []TokenMetrics) error {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, metric := range records {
+		if err := encoder.Encode(metric); err != nil {
+			return err
+		}
+	}
+	if err := os.WriteFile(s.conf.File+".tmp", buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(s.conf.File+".tmp", s.conf.File)
+}
+
+// send queues a metric to be written
+func (s *metricsStore) send(metric TokenMetrics) {
+	if s == nil {
+		return
+	}
+	select {
+	case s.queue <- metric:
+	default:
+		s.logger.Warnf("metricsStore: queue is full, dropping request %d", metric.ID)
+	}
+}
+
+// run writes queued metrics until ctx is done, then writes the ones still
+// queued and closes the file
+func (s *metricsStore) run(ctx context.Context) {
+	defer close(s.done)
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	defer s.close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			for {
+				select {
+				case metric := <-s.queue:
+					s.write(metric)
+				default:
+					return
+				}
+			}
+		case metric := <-s.queue:
+			s.write(metric)
+			if s.conf.MaxRecords > 0 && s.records >= 2*s.conf.MaxRecords {
+				s.prune(time.Now())
+			}
+		case now := <-ticker.C:
+			if s.conf.MaxAge > 0 {
+				s.prune(now)
+			}
+		}
+	}
+}
+
+// write appends metric to the file, opening it when needed
+func (s *metricsStore) write(metric TokenMetrics) {
+	if s.file == nil {
+		file, err := os.OpenFile(s.conf.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			s.logger.Warnf("metricsStore: unable to open %s: %v", s.conf.File, err)
+			return
+		}
+		s.file = file
+	}
+
+	line, err := json.Marshal(metric)
+	if err != nil {
+		s.logger.Warnf("metricsStore: %v", err)
+		return
+	}
+	if _, err := s.file.Write(append(line, '\n')); err != nil {
+		s.logger.Warnf("metricsStore: unable to write %s: %v", s.conf.File, err)
+		return
+	}
+	s.records++
+}
+
+// prune rewrites the file with the metrics kept by the retention settings
+func (s *metricsStore) prune(now time.Time) {
+	s.close()
+	records, err := s.read()
+	if err != nil {
+		s.logger.Warnf("metricsStore: unable to prune %s: %v", s.conf.File, err)
+		return
+	}
+	records = s.retain(records, now)
+	if err := s.rewrite(records); err != nil {
+		s.logger.Warnf("metricsStore: unable to prune %s: %v", s.conf.File, err)
+		return
+	}
+	s.records = len(records)
+}
+
+// stopStore stops sending metrics to the store and waits until it wrote the
+// queued ones and closed the file. The context run was given must be done.
+func (mp *metricsMonitor) stopStore() {
+	mp.mu.Lock()
+	store := mp.store
+	mp.store = nil
+	mp.mu.Unlock()
+
+	if store != nil {
+		<-store.done
+	}
+}
+
+// setStore sends the metrics recorded from now on to store
+func (mp *metricsMonitor) setStore(store *metricsStore) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	mp.store = store
+}
+
+func (s *metricsStore) close() {
+	if s.file != nil {
+		s.file.Close()
+		s.file = nil
+	}
+}
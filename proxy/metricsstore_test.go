@@ -0,0 +1,121 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricsStore_Load(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	file := filepath.Join(t.TempDir(), "state", "activity.jsonl")
+	store := newMetricsStore(config.MetricsStoreConfig{File: file, MaxAge: 24, MaxRecords: 2}, testLogger)
+
+	records, err := store.load(now)
+	require.NoError(t, err)
+	assert.Empty(t, records)
+
+	lines := []string{
+		`{"id":0,"timestamp":"2025-05-30T12:00:00Z","model":"old"}`,
+		`{"id":1,"timestamp":"2025-06-01T09:00:00Z","model":"model1"}`,
+		`{"id":2,"timestamp":"2025-06-01T10:00:00Z","model":"model2"}`,
+		`{"id":3,"timestamp":"2025-06-01T11:00:00Z","model":"model3","has_capture":true}`,
+		`{"id":4,"timestamp":"2025-06-01T11:`,
+	}
+	require.NoError(t, os.WriteFile(file, []byte(strings.Join(lines, "\n")), 0644))
+
+	records, err = store.load(now)
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, "model2", records[0].Model)
+	assert.Equal(t, "model3", records[1].Model)
+
+	// the file only holds what was kept
+	reread, err := store.read()
+	require.NoError(t, err)
+	assert.Equal(t, records, reread)
+}
+
+func TestMetricsStore_Run(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "activity.jsonl")
+	store := newMetricsStore(config.MetricsStoreConfig{File: file, MaxRecords: 2}, testLogger)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		store.run(ctx)
+		close(done)
+	}()
+
+	for i := range 4 {
+		store.send(TokenMetrics{ID: i, Model: "model1", Timestamp: time.Now()})
+	}
+	assert.Eventually(t, func() bool {
+		records, _ := store.read()
+		return len(records) == 2 && records[1].ID == 3
+	}, time.Second, 10*time.Millisecond)
+
+	store.send(TokenMetrics{ID: 4, Model: "model1", Timestamp: time.Now()})
+	cancel()
+	<-done
+
+	records, err := store.read()
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	assert.Equal(t, 4, records[2].ID)
+}
+
+func TestMetricsMonitor_RestoreMetrics(t *testing.T) {
+	mm := newMetricsMonitor(testLogger, 2, 0)
+	mm.restoreMetrics([]TokenMetrics{
+		{ID: 5, Model: "model1"},
+		{ID: 6, Model: "model2", HasCapture: true},
+		{ID: 7, Model: "model3"},
+	})
+
+	metrics := mm.getMetrics()
+	require.Len(t, metrics, 2)
+	assert.Equal(t, 6, metrics[0].ID)
+	assert.False(t, metrics[0].HasCapture)
+	assert.Equal(t, 8, mm.addMetrics(TokenMetrics{Model: "model1"}))
+}
+
+func TestProxyManager_MetricsStore(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "activity.jsonl")
+	require.NoError(t, os.WriteFile(file, []byte(`{"id":41,"timestamp":"2025-06-01T09:00:00Z","model":"model1","request_id":"previous"}`+"\n"), 0644))
+
+	conf := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]config.ModelConfig{
+			"model1": getTestSimpleResponderConfig("model1"),
+		},
+		LogLevel:     "error",
+		MetricsStore: config.MetricsStoreConfig{File: file},
+	})
+
+	proxy := New(conf)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	metrics := proxy.metricsMonitor.getMetrics()
+	require.Len(t, metrics, 1)
+	assert.Equal(t, "previous", metrics[0].RequestID)
+
+	req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"model":"model1"}`))
+	w := CreateTestResponseRecorder()
+	proxy.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	assert.Eventually(t, func() bool {
+		data, _ := os.ReadFile(file)
+		return strings.Contains(string(data), `"id":42`)
+	}, time.Second, 10*time.Millisecond)
+}
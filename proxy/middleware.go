@@ -0,0 +1,173 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/napmany/llmsnap/proxy/config"
+)
+
+const defaultMiddlewareTimeout = 5 * time.Second
+
+// middlewareRequest is written as JSON to a middleware's stdin
+type middlewareRequest struct {
+	Hook       config.MiddlewareHook `json:"hook"`
+	Method     string                `json:"method"`
+	Path       string                `json:"path"`
+	Model      string                `json:"model"`
+	Headers    map[string]string     `json:"headers"`
+	Body       json.RawMessage       `json:"body,omitempty"`
+	Status     int                   `json:"status,omitempty"`
+	DurationMs int64                 `json:"durationMs,omitempty"`
+}
+
+// middlewareResult is read as JSON from a middleware's stdout. An empty
+// output lets the request continue unchanged. A status rejects the request,
+// headers are set on the request and a body replaces the request body.
+type middlewareResult struct {
+	Status  int               `json:"status"`
+	Message string            `json:"message"`
+	Headers map[string]string `json:"headers"`
+	Body    json.RawMessage   `json:"body"`
+}
+
+// runMiddleware executes a middleware command with req on stdin
+func (pm *ProxyManager) runMiddleware(ctx context.Context, mw config.MiddlewareConfig, req middlewareRequest) (middlewareResult, error) {
+	var result middlewareResult
+
+	args, err := mw.SanitizedCommand()
+	if err != nil {
+		return result, err
+	}
+
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return result, err
+	}
+
+	timeout := defaultMiddlewareTimeout
+	if mw.Timeout > 0 {
+		timeout = time.Duration(mw.Timeout) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Stderr = pm.proxyLogger
+	output, err := cmd.Output()
+	if err != nil {
+		return result, err
+	}
+
+	if len(bytes.TrimSpace(output)) == 0 {
+		return result, nil
+	}
+	if err := json.Unmarshal(output, &result); err != nil {
+		return result, fmt.Errorf("invalid output: %v", err)
+	}
+	if result.Status != 0 && (result.Status < 400 || result.Status > 599) {
+		return result, fmt.Errorf("invalid status %d, must be between 400 and 599", result.Status)
+	}
+	return result, nil
+}
+
+// middlewareFor returns the middleware configured for hook that apply to model
+func (pm *ProxyManager) middlewareFor(hook config.MiddlewareHook, model string) []config.MiddlewareConfig {
+	var matched []config.MiddlewareConfig
+	realName, _ := pm.realModelName(model)
+	for _, mw := range pm.config.Middleware {
+		if mw.Hook != hook {
+			continue
+		}
+		if len(mw.Models) > 0 && !slices.ContainsFunc(mw.Models, func(name string) bool {
+			if name == model {
+				return true
+			}
+			configured, found := pm.realModelName(name)
+			return found && configured == realName
+		}) {
+			continue
+		}
+		matched = append(matched, mw)
+	}
+	return matched
+}
+
+func newMiddlewareRequest(c *gin.Context, hook config.MiddlewareHook, model string, body []byte) middlewareRequest {
+	headers := make(map[string]string, len(c.Request.Header))
+	for name, values := range c.Request.Header {
+		headers[name] = strings.Join(values, ", ")
+	}
+
+	req := middlewareRequest{
+		Hook:    hook,
+		Method:  c.Request.Method,
+		Path:    c.Request.URL.Path,
+		Model:   model,
+		Headers: headers,
+	}
+	if json.Valid(body) {
+		req.Body = body
+	}
+	return req
+}
+
+// runRequestMiddleware runs the preRoute or preUpstream middleware in order
+// and returns the possibly rewritten body. When ok is false a response has
+// already been sent.
+func (pm *ProxyManager) runRequestMiddleware(c *gin.Context, hook config.MiddlewareHook, model string, body []byte) (newBody []byte, ok bool) {
+	for _, mw := range pm.middlewareFor(hook, model) {
+		result, err := pm.runMiddleware(c.Request.Context(), mw, newMiddlewareRequest(c, hook, model, body))
+		if err != nil {
+			pm.proxyLogger.Errorf("<%s> middleware %s failed: %v", model, mw.Name, err)
+			pm.sendErrorResponse(c, http.StatusInternalServerError, fmt.Sprintf("middleware %s failed", mw.Name))
+			return nil, false
+		}
+
+		if result.Status != 0 {
+			pm.proxyLogger.Debugf("<%s> middleware %s rejected the request with status %d", model, mw.Name, result.Status)
+			pm.sendErrorResponse(c, result.Status, result.Message)
+			return nil, false
+		}
+
+		for name, value := range result.Headers {
+			c.Request.Header.Set(name, value)
+		}
+		if len(result.Body) > 0 {
+			body = result.Body
+		}
+	}
+	return body, true
+}
+
+// runPostResponseMiddleware runs the postResponse middleware in the
+// background. Their output is ignored.
+func (pm *ProxyManager) runPostResponseMiddleware(c *gin.Context, model string, body []byte, duration time.Duration) {
+	middleware := pm.middlewareFor(config.MiddlewarePostResponse, model)
+	if len(middleware) == 0 {
+		return
+	}
+
+	// postResponse commands are used for audit logs, they never see
+	// unscrubbed bodies
+	req := newMiddlewareRequest(c, config.MiddlewarePostResponse, model, pm.scrubber.scrub(body))
+	req.Status = c.Writer.Status()
+	req.DurationMs = duration.Milliseconds()
+
+	go func() {
+		for _, mw := range middleware {
+			if _, err := pm.runMiddleware(context.Background(), mw, req); err != nil {
+				pm.proxyLogger.Errorf("<%s> middleware %s failed: %v", model, mw.Name, err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeMiddlewareScript creates a shell script that runs body
+func writeMiddlewareScript(t *testing.T, name, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return filepath.ToSlash(path)
+}
+
+func TestProxyManager_Middleware(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("middleware test scripts need a posix shell")
+	}
+
+	logFile := filepath.Join(t.TempDir(), "post.json")
+	reroute := writeMiddlewareScript(t, "reroute.sh",
+		`cat > /dev/null; echo '{"headers":{"X-Routed":"yes"},"body":{"model":"model2"}}'`)
+	deny := writeMiddlewareScript(t, "deny.sh",
+		`cat > /dev/null; echo '{"status":403,"message":"model3 is not allowed"}'`)
+	broken := writeMiddlewareScript(t, "broken.sh", `cat > /dev/null; exit 3`)
+	logger := writeMiddlewareScript(t, "logger.sh", `cat > `+logFile)
+
+	conf := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]config.ModelConfig{
+			"model1": getTestSimpleResponderConfig("model1"),
+			"model2": getTestSimpleResponderConfig("model2"),
+			"model3": getTestSimpleResponderConfig("model3"),
+			"model4": getTestSimpleResponderConfig("model4"),
+		},
+		Middleware: []config.MiddlewareConfig{
+			{Name: "reroute", Hook: config.MiddlewarePreRoute, Cmd: reroute, Models: []string{"model1"}},
+			{Name: "deny", Hook: config.MiddlewarePreUpstream, Cmd: deny, Models: []string{"model3"}},
+			{Name: "broken", Hook: config.MiddlewarePreUpstream, Cmd: broken, Models: []string{"model4"}},
+			{Name: "logger", Hook: config.MiddlewarePostResponse, Cmd: logger, Models: []string{"model2"}},
+		},
+		LogLevel: "error",
+	})
+
+	proxy := New(conf)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	doRequest := func(model string) *TestResponseRecorder {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model":"`+model+`"}`))
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("preRoute rewrites the body", func(t *testing.T) {
+		w := doRequest("model1")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "model2")
+	})
+
+	t.Run("postResponse receives the status", func(t *testing.T) {
+		assert.Eventually(t, func() bool {
+			data, err := os.ReadFile(logFile)
+			return err == nil && bytes.Contains(data, []byte(`"hook":"postResponse"`)) &&
+				bytes.Contains(data, []byte(`"status":200`)) &&
+				bytes.Contains(data, []byte(`"X-Routed":"yes"`))
+		}, 5*time.Second, 20*time.Millisecond)
+	})
+
+	t.Run("preUpstream rejects", func(t *testing.T) {
+		w := doRequest("model3")
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), "model3 is not allowed")
+	})
+
+	t.Run("failing middleware", func(t *testing.T) {
+		w := doRequest("model4")
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.Contains(t, w.Body.String(), "middleware broken failed")
+	})
+}
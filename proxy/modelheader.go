@@ -0,0 +1,33 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+)
+
+// modelHeader picks the model for clients that can not change the body or
+// query of their requests, like some plugins and webhooks. It overrides the
+// model in them.
+const modelHeader = "X-Model"
+
+// headerModel returns the model of the X-Model header. The header is removed
+// as the model is written where the upstream expects it.
+func headerModel(r *http.Request) string {
+	model := strings.TrimSpace(r.Header.Get(modelHeader))
+	r.Header.Del(modelHeader)
+	return model
+}
+
+// queryModel returns the model query parameter, the X-Model header overrides
+// it and is written to the query
+func queryModel(r *http.Request) string {
+	model := headerModel(r)
+	if model == "" {
+		return r.URL.Query().Get("model")
+	}
+
+	query := r.URL.Query()
+	query.Set("model", model)
+	r.URL.RawQuery = query.Encode()
+	return model
+}
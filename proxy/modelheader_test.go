@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+func TestProxyManager_ModelHeader(t *testing.T) {
+	conf := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]config.ModelConfig{
+			"model1": getTestSimpleResponderConfig("model1"),
+			"model2": getTestSimpleResponderConfig("model2"),
+		},
+		LogLevel: "error",
+	})
+
+	proxy := New(conf)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	t.Run("sets the model of a body without one", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"messages":[]}`))
+		req.Header.Set(modelHeader, "model1")
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "model1", gjson.Get(gjson.Get(w.Body.String(), "request_body").String(), "model").String())
+	})
+
+	t.Run("overrides the model in the body", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model":"model1"}`))
+		req.Header.Set(modelHeader, " model2 ")
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "model2", gjson.Get(gjson.Get(w.Body.String(), "request_body").String(), "model").String())
+	})
+
+	t.Run("selects the model of a GET endpoint", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/v1/audio/voices", nil)
+		req.Header.Set(modelHeader, "model1")
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "voice1")
+	})
+
+	t.Run("adds the model to form data", func(t *testing.T) {
+		var b bytes.Buffer
+		mw := multipart.NewWriter(&b)
+		fw, err := mw.CreateFormFile("file", "test.mp3")
+		require.NoError(t, err)
+		_, err = fw.Write([]byte("0123456789"))
+		require.NoError(t, err)
+		mw.Close()
+
+		req := httptest.NewRequest("POST", "/v1/audio/transcriptions", &b)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		req.Header.Set(modelHeader, "model2")
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var response map[string]string
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, "model2", response["model"])
+	})
+
+	t.Run("missing header and model is rejected", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{}`))
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "X-Model header")
+	})
+}
+
+func TestQueryModel(t *testing.T) {
+	req := httptest.NewRequest("GET", "/v1/realtime?model=model1&x=1", nil)
+	assert.Equal(t, "model1", queryModel(req))
+
+	req.Header.Set(modelHeader, "model2")
+	assert.Equal(t, "model2", queryModel(req))
+	assert.Equal(t, "model2", req.URL.Query().Get("model"))
+	assert.Equal(t, "1", req.URL.Query().Get("x"))
+	assert.Empty(t, req.Header.Get(modelHeader))
+}
@@ -0,0 +1,200 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// peerCheckTimeout bounds a health check or an activity pull of a peer
+const peerCheckTimeout = 10 * time.Second
+
+// PeerStatus is the health of a peer as reported by /api/peers
+type PeerStatus struct {
+	ID      string   `json:"id"`
+	Proxy   string   `json:"proxy"`
+	Models  []string `json:"models"`
+	Healthy bool     `json:"healthy"`
+
+	// why the last check failed, empty while healthy
+	Error string `json:"error,omitempty"`
+
+	// time of the last check, null before the first one or without checks
+	CheckedAt *time.Time `json:"checked_at"`
+
+	// requests in the activity pulled from the peer
+	Activity int `json:"activity"`
+}
+
+type peerHealth struct {
+	healthy   bool
+	err       string
+	checkedAt time.Time
+	activity  []TokenMetrics
+}
+
+// healthy reports if peerID passed its last health check, with the reason
+// when it did not
+func (p *PeerProxy) healthy(peerID string) (bool, string) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	health, found := p.health[peerID]
+	if !found {
+		return true, ""
+	}
+	return health.healthy, health.err
+}
+
+// watch checks the peers with a healthCheckInterval until ctx is done. Peers
+// are taken as healthy until the first check, one interval after the start.
+func (p *PeerProxy) watch(ctx context.Context) {
+	for peerID, peer := range p.peers {
+		if peer.HealthCheckInterval <= 0 {
+			continue
+		}
+		go func() {
+			ticker := time.NewTicker(peer.HealthCheckDuration())
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					p.check(ctx, peerID)
+				}
+			}
+		}()
+	}
+}
+
+// check requests the peer's /v1/models and records if it is up. The activity
+// of a healthy peer with activity enabled is pulled too.
+func (p *PeerProxy) check(ctx context.Context, peerID string) {
+	peer := p.peers[peerID]
+	_, err := p.get(ctx, peerID, "v1/models")
+
+	var activity []TokenMetrics
+	if err == nil && peer.Activity {
+		var pullErr error
+		if activity, pullErr = p.pullActivity(ctx, peerID); pullErr != nil {
+			p.logger.Warnf("peer %s: unable to pull activity: %v", peerID, pullErr)
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	health := p.health[peerID]
+	switch {
+	case err != nil && health.healthy:
+		p.logger.Warnf("peer %s: health check failed, marking it unavailable: %v", peerID, err)
+	case err == nil && !health.healthy:
+		p.logger.Infof("peer %s: health check passed, it is available again", peerID)
+	}
+	health.healthy = err == nil
+	health.err = ""
+	if err != nil {
+		health.err = err.Error()
+	}
+	health.checkedAt = time.Now()
+	if activity != nil {
+		health.activity = activity
+	}
+}
+
+// pullActivity returns the activity in the /api/metrics of a peer that is
+// another llmsnap, each request marked with peerID
+func (p *PeerProxy) pullActivity(ctx context.Context, peerID string) ([]TokenMetrics, error) {
+	body, err := p.get(ctx, peerID, "api/metrics")
+	if err != nil {
+		return nil, err
+	}
+	var activity []TokenMetrics
+	if err := json.Unmarshal(body, &activity); err != nil {
+		return nil, fmt.Errorf("invalid /api/metrics response: %w", err)
+	}
+	for i := range activity {
+		activity[i].Peer = peerID
+	}
+	return activity, nil
+}
+
+// get requests path below the peer's proxy URL with its apiKey and returns
+// the body of a 2xx response
+func (p *PeerProxy) get(ctx context.Context, peerID, path string) ([]byte, error) {
+	peer := p.peers[peerID]
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, peer.ProxyURL.JoinPath(path).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if peer.ApiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+peer.ApiKey)
+		req.Header.Set("x-api-key", peer.ApiKey)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("%s returned HTTP %d", path, resp.StatusCode)
+	}
+	return body, nil
+}
+
+// statuses returns the health of every peer sorted by ID
+func (p *PeerProxy) statuses() []PeerStatus {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	statuses := make([]PeerStatus, 0, len(p.peers))
+	for peerID, peer := range p.peers {
+		health := p.health[peerID]
+		status := PeerStatus{
+			ID:       peerID,
+			Proxy:    peer.Proxy,
+			Models:   peer.Models,
+			Healthy:  health.healthy,
+			Error:    health.err,
+			Activity: len(health.activity),
+		}
+		if !health.checkedAt.IsZero() {
+			checkedAt := health.checkedAt
+			status.CheckedAt = &checkedAt
+		}
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].ID < statuses[j].ID })
+	return statuses
+}
+
+// activity returns the activity pulled from all peers
+func (p *PeerProxy) activity() []TokenMetrics {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	var activity []TokenMetrics
+	for _, health := range p.health {
+		activity = append(activity, health.activity...)
+	}
+	return activity
+}
+
+// apiGetPeers returns the health of every peer
+func (pm *ProxyManager) apiGetPeers(c *gin.Context) {
+	statuses := []PeerStatus{}
+	if pm.peerProxy != nil {
+		statuses = pm.peerProxy.statuses()
+	}
+	c.JSON(http.StatusOK, statuses)
+}
@@ -8,6 +8,7 @@ import (
 	"runtime"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/napmany/llmsnap/proxy/config"
@@ -22,6 +23,12 @@ type peerProxyMember struct {
 type PeerProxy struct {
 	peers    config.PeerDictionaryConfig
 	proxyMap map[string]*peerProxyMember
+
+	// health checks and activity pulls, see peerhealth.go
+	client *http.Client
+	logger *LogMonitor
+	mu     sync.RWMutex
+	health map[string]*peerHealth
 }
 
 func NewPeerProxy(peers config.PeerDictionaryConfig, proxyLogger *LogMonitor) (*PeerProxy, error) {
@@ -64,6 +71,8 @@ func NewPeerProxy(peers config.PeerDictionaryConfig, proxyLogger *LogMonitor) (*
 		}
 
 		reverseProxy.ModifyResponse = func(resp *http.Response) error {
+			// the request ID llmsnap sent back already wins
+			resp.Header.Del(requestIDHeader)
 			if strings.Contains(strings.ToLower(resp.Header.Get("Content-Type")), "text/event-stream") {
 				resp.Header.Set("X-Accel-Buffering", "no")
 			}
@@ -95,12 +104,33 @@ func NewPeerProxy(peers config.PeerDictionaryConfig, proxyLogger *LogMonitor) (*
 		}
 	}
 
+	// peers are taken as healthy until a check fails
+	health := make(map[string]*peerHealth, len(peers))
+	for peerID := range peers {
+		health[peerID] = &peerHealth{healthy: true}
+	}
+
 	return &PeerProxy{
 		peers:    peers,
 		proxyMap: proxyMap,
+		client:   &http.Client{Transport: peerTransport, Timeout: peerCheckTimeout},
+		logger:   proxyLogger,
+		health:   health,
 	}, nil
 }
 
+// setResponseHeaders applies the responseHeaders settings to the responses of
+// every peer
+func (p *PeerProxy) setResponseHeaders(conf config.ResponseHeadersConfig) {
+	applied := make(map[*peerProxyMember]bool)
+	for _, pp := range p.proxyMap {
+		if !applied[pp] {
+			applied[pp] = true
+			applyResponseHeaders(pp.reverseProxy, conf)
+		}
+	}
+}
+
 func (p *PeerProxy) HasPeerModel(modelID string) bool {
 	_, found := p.proxyMap[modelID]
 	return found
@@ -130,6 +160,12 @@ func (p *PeerProxy) ProxyRequest(model_id string, writer http.ResponseWriter, re
 		return fmt.Errorf("no peer proxy found for model %s", model_id)
 	}
 
+	// fail fast instead of waiting for a peer that is down
+	if healthy, reason := p.healthy(pp.peerID); !healthy {
+		http.Error(writer, fmt.Sprintf("peer %s is unavailable: %s", pp.peerID, reason), http.StatusServiceUnavailable)
+		return nil
+	}
+
 	// Inject API key if configured for this peer
 	if pp.apiKey != "" {
 		request.Header.Set("Authorization", "Bearer "+pp.apiKey)
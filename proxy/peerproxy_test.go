@@ -5,7 +5,9 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/napmany/llmsnap/proxy/config"
 	"github.com/stretchr/testify/assert"
@@ -266,3 +268,89 @@ func TestProxyRequest_SSEHeaderModification(t *testing.T) {
 	// The X-Accel-Buffering header should be set to "no" for SSE
 	assert.Equal(t, "no", w.Header().Get("X-Accel-Buffering"))
 }
+
+func TestPeerProxy_HealthCheck(t *testing.T) {
+	var up atomic.Bool
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !up.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/models":
+			w.Write([]byte(`{"data":[]}`))
+		case "/api/metrics":
+			assert.Equal(t, "Bearer sk-peer", r.Header.Get("Authorization"))
+			w.Write([]byte(`[{"id":1,"model":"test-model","input_tokens":25}]`))
+		default:
+			w.Write([]byte("response from peer"))
+		}
+	}))
+	defer testServer.Close()
+
+	proxyURL, _ := url.Parse(testServer.URL)
+	peers := config.PeerDictionaryConfig{
+		"peer1": config.PeerConfig{
+			Proxy:               testServer.URL,
+			ProxyURL:            proxyURL,
+			ApiKey:              "sk-peer",
+			Models:              []string{"test-model"},
+			HealthCheckInterval: 30,
+			Activity:            true,
+		},
+	}
+
+	pp, err := NewPeerProxy(peers, testLogger)
+	require.NoError(t, err)
+
+	// a peer failing its check is unavailable
+	pp.check(t.Context(), "peer1")
+	statuses := pp.statuses()
+	require.Len(t, statuses, 1)
+	assert.False(t, statuses[0].Healthy)
+	assert.Contains(t, statuses[0].Error, "HTTP 502")
+	assert.NotNil(t, statuses[0].CheckedAt)
+
+	w := httptest.NewRecorder()
+	err = pp.ProxyRequest("test-model", w, httptest.NewRequest("POST", "/v1/chat/completions", nil))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), "peer peer1 is unavailable")
+
+	// it is used again once it recovers, and its activity is pulled
+	up.Store(true)
+	pp.check(t.Context(), "peer1")
+	statuses = pp.statuses()
+	assert.True(t, statuses[0].Healthy)
+	assert.Empty(t, statuses[0].Error)
+	assert.Equal(t, 1, statuses[0].Activity)
+
+	w = httptest.NewRecorder()
+	err = pp.ProxyRequest("test-model", w, httptest.NewRequest("POST", "/v1/chat/completions", nil))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	activity := pp.activity()
+	require.Len(t, activity, 1)
+	assert.Equal(t, "peer1", activity[0].Peer)
+	assert.Equal(t, 25, activity[0].InputTokens)
+}
+
+func TestWithPeerActivity(t *testing.T) {
+	now := time.Now()
+	local := []TokenMetrics{
+		{ID: 0, RequestID: "a", Timestamp: now},
+		{ID: 1, RequestID: "b", Timestamp: now.Add(2 * time.Second)},
+	}
+	activity := []TokenMetrics{
+		// sent to the peer by this instance
+		{ID: 7, RequestID: "b", Peer: "peer1", Timestamp: now.Add(2 * time.Second)},
+		{ID: 8, RequestID: "c", Peer: "peer1", Timestamp: now.Add(time.Second)},
+		{ID: 9, Peer: "peer1", Timestamp: now.Add(3 * time.Second)},
+	}
+
+	merged := withPeerActivity(local, activity)
+	require.Len(t, merged, 4)
+	assert.Equal(t, []string{"a", "c", "b", ""}, []string{merged[0].RequestID, merged[1].RequestID, merged[2].RequestID, merged[3].RequestID})
+	assert.Empty(t, merged[2].Peer)
+}
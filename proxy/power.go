@@ -0,0 +1,129 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// powerSource is how the host is powered
+type powerSource struct {
+	// OnBattery is true when no AC adapter is connected
+	OnBattery bool
+
+	// PowerSaver is true when the OS power profile saves power, like macOS
+	// low power mode or the Windows power saver plan
+	PowerSaver bool
+}
+
+// saving reports if models should behave as on battery
+func (s powerSource) saving() bool {
+	return s.OnBattery || s.PowerSaver
+}
+
+// powerReader returns the current power source
+type powerReader func() (powerSource, error)
+
+const (
+	linuxPowerSupplyDir    = "/sys/class/power_supply"
+	linuxPlatformProfile   = "/sys/firmware/acpi/platform_profile"
+	windowsPowerSaverGUID  = "a1841308-3541-4fab-bc81-f71556f20b4a"
+	windowsBatteryStatusPS = "(Get-CimInstance Win32_Battery).BatteryStatus"
+)
+
+// readPowerSource detects the power source of the host
+func readPowerSource() (powerSource, error) {
+	switch runtime.GOOS {
+	case "linux":
+		return readLinuxPowerSource(linuxPowerSupplyDir, linuxPlatformProfile)
+	case "darwin":
+		batt, err := runPowerCommand("pmset", "-g", "batt")
+		if err != nil {
+			return powerSource{}, err
+		}
+		settings, err := runPowerCommand("pmset", "-g")
+		if err != nil {
+			return powerSource{}, err
+		}
+		return parsePmset(batt, settings), nil
+	case "windows":
+		status, err := runPowerCommand("powershell", "-NoProfile", "-Command", windowsBatteryStatusPS)
+		if err != nil {
+			return powerSource{}, err
+		}
+		scheme, err := runPowerCommand("powercfg", "/getactivescheme")
+		if err != nil {
+			return powerSource{}, err
+		}
+		return parseWindowsPower(status, scheme), nil
+	default:
+		return powerSource{}, errors.New("power source detection is not supported on " + runtime.GOOS)
+	}
+}
+
+func runPowerCommand(name string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	output, err := exec.CommandContext(ctx, name, args...).Output()
+	if err != nil {
+		return "", errors.New(name + ": " + err.Error())
+	}
+	return string(output), nil
+}
+
+// readLinuxPowerSource is on battery when there is a battery and no online
+// AC adapter. The power saver comes from the ACPI platform profile.
+func readLinuxPowerSource(supplyDir, profileFile string) (powerSource, error) {
+	supplies, err := os.ReadDir(supplyDir)
+	if err != nil {
+		return powerSource{}, err
+	}
+
+	read := func(name ...string) string {
+		data, _ := os.ReadFile(filepath.Join(name...))
+		return strings.TrimSpace(string(data))
+	}
+
+	hasBattery, acOnline := false, false
+	for _, supply := range supplies {
+		dir := filepath.Join(supplyDir, supply.Name())
+		switch read(dir, "type") {
+		case "Battery":
+			hasBattery = true
+		case "Mains", "USB":
+			if read(dir, "online") == "1" {
+				acOnline = true
+			}
+		}
+	}
+
+	return powerSource{
+		OnBattery:  hasBattery && !acOnline,
+		PowerSaver: read(profileFile) == "low-power",
+	}, nil
+}
+
+// parsePmset reads the output of `pmset -g batt` and `pmset -g`
+func parsePmset(batt, settings string) powerSource {
+	source := powerSource{OnBattery: strings.Contains(batt, "'Battery Power'")}
+	for _, line := range strings.Split(settings, "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 && fields[0] == "lowpowermode" {
+			source.PowerSaver = fields[1] == "1"
+		}
+	}
+	return source
+}
+
+// parseWindowsPower reads the Win32_Battery BatteryStatus, where 1 is
+// discharging, and the output of `powercfg /getactivescheme`
+func parseWindowsPower(status, scheme string) powerSource {
+	return powerSource{
+		OnBattery:  strings.TrimSpace(status) == "1",
+		PowerSaver: strings.Contains(strings.ToLower(scheme), windowsPowerSaverGUID),
+	}
+}
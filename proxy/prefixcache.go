@@ -0,0 +1,133 @@
+package proxy
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	// prompts without messages are hashed in chunks of this many bytes
+	promptPrefixChunkSize = 256
+
+	// how many prefix hashes are remembered per instance
+	maxPrefixesPerInstance = 4096
+)
+
+// promptPrefixHashes returns a chain of hashes for the prompt in a request
+// body. Each hash covers everything before it, so two requests that share
+// the first n hashes share the same prompt prefix. Chat requests are split
+// at message boundaries, plain prompts into fixed size chunks.
+func promptPrefixHashes(body []byte) []string {
+	var parts []string
+	if messages := gjson.GetBytes(body, "messages"); messages.IsArray() {
+		if system := gjson.GetBytes(body, "system"); system.Exists() {
+			parts = append(parts, system.Raw)
+		}
+		for _, message := range messages.Array() {
+			parts = append(parts, message.Raw)
+		}
+	} else if prompt := gjson.GetBytes(body, "prompt"); prompt.Exists() {
+		text := prompt.String()
+		for start := 0; start < len(text); start += promptPrefixChunkSize {
+			end := min(start+promptPrefixChunkSize, len(text))
+			parts = append(parts, text[start:end])
+		}
+	}
+
+	if len(parts) == 0 {
+		return nil
+	}
+
+	hashes := make([]string, 0, len(parts))
+	h := sha256.New()
+	for _, part := range parts {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+		hashes = append(hashes, hex.EncodeToString(h.Sum(nil)))
+	}
+	return hashes
+}
+
+// prefixTracker remembers the prompt prefixes recently served by each
+// upstream instance. As long as the instance keeps running its KV cache
+// likely still holds them, so requests sharing a long prefix are cheaper
+// when sent to the same instance.
+type prefixTracker struct {
+	sync.Mutex
+	instances map[string]*instancePrefixes
+}
+
+type instancePrefixes struct {
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+func newPrefixTracker() *prefixTracker {
+	return &prefixTracker{
+		instances: make(map[string]*instancePrefixes),
+	}
+}
+
+// record stores the prefix hashes of a request served by instance
+func (pt *prefixTracker) record(instance string, hashes []string) {
+	if len(hashes) == 0 {
+		return
+	}
+
+	pt.Lock()
+	defer pt.Unlock()
+
+	ip, found := pt.instances[instance]
+	if !found {
+		ip = &instancePrefixes{
+			entries: make(map[string]*list.Element),
+			lru:     list.New(),
+		}
+		pt.instances[instance] = ip
+	}
+
+	for _, hash := range hashes {
+		if elem, found := ip.entries[hash]; found {
+			ip.lru.MoveToFront(elem)
+			continue
+		}
+		ip.entries[hash] = ip.lru.PushFront(hash)
+	}
+
+	for ip.lru.Len() > maxPrefixesPerInstance {
+		oldest := ip.lru.Back()
+		ip.lru.Remove(oldest)
+		delete(ip.entries, oldest.Value.(string))
+	}
+}
+
+// match returns how many leading hashes instance has recently served
+func (pt *prefixTracker) match(instance string, hashes []string) int {
+	pt.Lock()
+	defer pt.Unlock()
+
+	ip, found := pt.instances[instance]
+	if !found {
+		return 0
+	}
+
+	matched := 0
+	for _, hash := range hashes {
+		if _, found := ip.entries[hash]; !found {
+			break
+		}
+		matched++
+	}
+	return matched
+}
+
+// forget drops everything known about an instance, e.g. after it stopped
+func (pt *prefixTracker) forget(instance string) {
+	pt.Lock()
+	defer pt.Unlock()
+	delete(pt.instances, instance)
+}
@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+func TestPromptPrefixHashes(t *testing.T) {
+	first := promptPrefixHashes([]byte(`{"messages":[{"role":"system","content":"be nice"},{"role":"user","content":"hi"}]}`))
+	second := promptPrefixHashes([]byte(`{"messages":[{"role":"system","content":"be nice"},{"role":"user","content":"hello"}]}`))
+	assert.Len(t, first, 2)
+	assert.Len(t, second, 2)
+	assert.Equal(t, first[0], second[0], "shared system prompt should have the same hash")
+	assert.NotEqual(t, first[1], second[1])
+
+	prompt := strings.Repeat("a", promptPrefixChunkSize*2+10)
+	assert.Len(t, promptPrefixHashes([]byte(`{"prompt":"`+prompt+`"}`)), 3)
+
+	assert.Nil(t, promptPrefixHashes([]byte(`{"input":"embed me"}`)))
+}
+
+func TestPrefixTracker_Match(t *testing.T) {
+	pt := newPrefixTracker()
+	conversation := promptPrefixHashes([]byte(`{"messages":[{"content":"a"},{"content":"b"}]}`))
+	continued := promptPrefixHashes([]byte(`{"messages":[{"content":"a"},{"content":"b"},{"content":"c"}]}`))
+
+	pt.record("model1", conversation)
+	assert.Equal(t, 2, pt.match("model1", continued))
+	assert.Equal(t, 0, pt.match("model2", continued))
+
+	pt.forget("model1")
+	assert.Equal(t, 0, pt.match("model1", continued))
+}
+
+func TestProcessGroup_PrefixMatchRequiresRunningProcess(t *testing.T) {
+	cfg := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]config.ModelConfig{
+			"model1": getTestSimpleResponderConfig("model1"),
+		},
+	})
+	pg := NewProcessGroup(config.DEFAULT_GROUP_ID, cfg, testLogger, testLogger)
+	defer pg.StopProcesses(StopWaitForInflightRequest)
+
+	hashes := promptPrefixHashes([]byte(`{"messages":[{"role":"user","content":"hi"}]}`))
+
+	req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"model":"model1"}`))
+	req = req.WithContext(context.WithValue(req.Context(), proxyCtxKey("prefixes"), hashes))
+	w := httptest.NewRecorder()
+	assert.NoError(t, pg.ProxyRequest("model1", w, req))
+	assert.Equal(t, 1, pg.PrefixMatch("model1", hashes))
+
+	assert.NoError(t, pg.StopProcess("model1", StopImmediately))
+	assert.Equal(t, 0, pg.PrefixMatch("model1", hashes))
+}
+
+func TestProcessGroup_PrefixRoutesToSameInstance(t *testing.T) {
+	startPort := getTestPort()
+	getTestPort()
+	getTestPort()
+	conf, err := config.LoadConfigFromReader(strings.NewReader(fmt.Sprintf(`
+logLevel: error
+startPort: %d
+models:
+  model1:
+    cmd: %s -port ${PORT} -silent -respond instance${INSTANCE}
+    instances: 3
+    loadBalance: roundRobin
+`, startPort, getSimpleResponderPath())))
+	require.NoError(t, err)
+
+	pg := NewProcessGroup(config.DEFAULT_GROUP_ID, conf, testLogger, testLogger)
+	defer pg.StopProcesses(StopWaitForInflightRequest)
+	for _, instance := range pg.instancesOf("model1") {
+		require.NoError(t, instance.makeReady())
+	}
+
+	doRequest := func(body string) string {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), proxyCtxKey("prefixes"), promptPrefixHashes([]byte(body))))
+		w := httptest.NewRecorder()
+		assert.NoError(t, pg.ProxyRequest("model1", w, req))
+		return gjson.Get(w.Body.String(), "responseMessage").String()
+	}
+
+	// a conversation keeps going to the instance that served its start
+	conversation := `{"messages":[{"role":"system","content":"be nice"},{"role":"user","content":"hi"}`
+	first := doRequest(conversation + `]}`)
+	for i := range 5 {
+		assert.Equal(t, first, doRequest(fmt.Sprintf(`%s,{"role":"user","content":"%d"}]}`, conversation, i)))
+	}
+
+	// prompts without a known prefix are still taken in turn
+	served := map[string]bool{}
+	for i := range 3 {
+		served[doRequest(fmt.Sprintf(`{"messages":[{"role":"user","content":"other %d"}]}`, i))] = true
+	}
+	assert.Len(t, served, 3)
+	assert.Equal(t, 2, pg.PrefixMatch("model1", promptPrefixHashes([]byte(conversation+`]}`))))
+}
@@ -0,0 +1,252 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+// results kept per model, a day of probes every 5 minutes
+const probeHistorySize = 288
+
+// most of a probe reply that is read
+const probeMaxBody = 64 * 1024
+
+// ProbeResult is the outcome of one synthetic probe
+type ProbeResult struct {
+	Model      string    `json:"model"`
+	Timestamp  time.Time `json:"timestamp"`
+	Success    bool      `json:"success"`
+	DurationMs int       `json:"duration_ms"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// ProbeSummary is the availability of a model measured by its probes
+type ProbeSummary struct {
+	Model    string `json:"model"`
+	Probes   int    `json:"probes"`
+	Failures int    `json:"failures"`
+
+	// Availability is the fraction of probes that succeeded, null before
+	// the first probe
+	Availability *float64 `json:"availability"`
+
+	AvgDurationMs int `json:"avg_duration_ms"`
+	MaxDurationMs int `json:"max_duration_ms"`
+
+	Results []ProbeResult `json:"results"`
+}
+
+// probeMonitor keeps the recent probe results of each model
+type probeMonitor struct {
+	mu      sync.RWMutex
+	results map[string][]ProbeResult
+}
+
+func newProbeMonitor() *probeMonitor {
+	return &probeMonitor{results: make(map[string][]ProbeResult)}
+}
+
+func (pm *probeMonitor) record(result ProbeResult) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	results := append(pm.results[result.Model], result)
+	if len(results) > probeHistorySize {
+		results = results[len(results)-probeHistorySize:]
+	}
+	pm.results[result.Model] = results
+}
+
+// summary returns the results of modelID at or after since
+func (pm *probeMonitor) summary(modelID string, since time.Time) ProbeSummary {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	summary := ProbeSummary{Model: modelID, Results: []ProbeResult{}}
+	totalMs := 0
+	for _, result := range pm.results[modelID] {
+		if result.Timestamp.Before(since) {
+			continue
+		}
+		summary.Results = append(summary.Results, result)
+		summary.Probes++
+		if !result.Success {
+			summary.Failures++
+		}
+		totalMs += result.DurationMs
+		summary.MaxDurationMs = max(summary.MaxDurationMs, result.DurationMs)
+	}
+
+	if summary.Probes > 0 {
+		availability := float64(summary.Probes-summary.Failures) / float64(summary.Probes)
+		summary.Availability = &availability
+		summary.AvgDurationMs = totalMs / summary.Probes
+	}
+	return summary
+}
+
+// probeWriter keeps the status and the start of a probe reply
+type probeWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *probeWriter) Header() http.Header {
+	if w.header == nil {
+		w.header = make(http.Header)
+	}
+	return w.header
+}
+
+func (w *probeWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if room := probeMaxBody - w.body.Len(); room > 0 {
+		w.body.Write(data[:min(len(data), room)])
+	}
+	return len(data), nil
+}
+
+func (w *probeWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+}
+
+func (w *probeWriter) Flush() {}
+
+// runProbes probes every model with a probe interval until shutdown
+func (pm *ProxyManager) runProbes() {
+	for modelID, modelConfig := range pm.config.Models {
+		if !modelConfig.Probe.Enabled() {
+			continue
+		}
+		go func() {
+			ticker := time.NewTicker(modelConfig.Probe.Every())
+			defer ticker.Stop()
+			for {
+				select {
+				case <-pm.shutdownCtx.Done():
+					return
+				case <-ticker.C:
+					pm.probeModel(modelID)
+				}
+			}
+		}()
+	}
+}
+
+// probeModel sends one probe to modelID and records the result. It returns
+// false when the probe was skipped because the model is not loaded.
+func (pm *ProxyManager) probeModel(modelID string) bool {
+	probe := pm.config.Models[modelID].Probe
+	if !probe.Load && pm.modelState(modelID) != StateReady {
+		pm.proxyLogger.Debugf("<%s> probe skipped, model is not loaded", modelID)
+		return false
+	}
+
+	result := ProbeResult{Model: modelID, Timestamp: time.Now()}
+	err := pm.sendProbe(modelID, &result)
+	result.DurationMs = int(time.Since(result.Timestamp).Milliseconds())
+	if err != nil {
+		result.Error = err.Error()
+		pm.proxyLogger.Warnf("<%s> probe failed: %v", modelID, err)
+	} else {
+		result.Success = true
+	}
+	pm.probes.record(result)
+	return true
+}
+
+func (pm *ProxyManager) sendProbe(modelID string, result *ProbeResult) error {
+	if err := pm.checkQuarantine(modelID); err != nil {
+		return err
+	}
+
+	probe := pm.config.Models[modelID].Probe
+	body, err := json.Marshal(gin.H{
+		"model":      modelID,
+		"messages":   []gin.H{{"role": "user", "content": probe.PromptText()}},
+		"max_tokens": probe.ReplyTokens(),
+		"stream":     false,
+	})
+	if err != nil {
+		return err
+	}
+
+	processGroup, err := pm.swapProcessGroup(modelID)
+	if err != nil {
+		return err
+	}
+
+	// probes do not count as use, the model still unloads after its ttl
+	ctx, cancel := context.WithTimeout(pm.shutdownCtx, probe.TimeoutDuration())
+	defer cancel()
+	ctx = context.WithValue(ctx, proxyCtxKey("probe"), true)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", "/v1/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	w := &probeWriter{}
+	if err := processGroup.ProxyRequest(modelID, w, req); err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("timed out after %s", probe.TimeoutDuration())
+	}
+
+	result.StatusCode = w.status
+	if w.status != http.StatusOK {
+		return fmt.Errorf("status %d: %s", w.status, probeExcerpt(w.body.Bytes()))
+	}
+	if !gjson.ValidBytes(w.body.Bytes()) {
+		return fmt.Errorf("reply is not JSON: %s", probeExcerpt(w.body.Bytes()))
+	}
+	return nil
+}
+
+// probeExcerpt returns the start of a reply for an error message
+func probeExcerpt(body []byte) string {
+	const size = 200
+	if len(body) > size {
+		return string(body[:size]) + "..."
+	}
+	return string(body)
+}
+
+// apiGetProbes returns the probe results of every probed model within
+// ?window=, a duration like 1h, all kept results by default
+func (pm *ProxyManager) apiGetProbes(c *gin.Context) {
+	var since time.Time
+	if value := c.Query("window"); value != "" {
+		window, err := time.ParseDuration(value)
+		if err != nil || window < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid window '%s', expected a duration like 1h", value)})
+			return
+		}
+		since = time.Now().Add(-window)
+	}
+
+	summaries := []ProbeSummary{}
+	for modelID, modelConfig := range pm.config.Models {
+		if modelConfig.Probe.Enabled() {
+			summaries = append(summaries, pm.probes.summary(modelID, since))
+		}
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].Model < summaries[j].Model })
+	c.JSON(http.StatusOK, summaries)
+}
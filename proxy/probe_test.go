@@ -0,0 +1,99 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProbeMonitor_Summary(t *testing.T) {
+	probes := newProbeMonitor()
+	now := time.Now()
+	probes.record(ProbeResult{Model: "model1", Timestamp: now.Add(-2 * time.Hour), Success: false, DurationMs: 900})
+	probes.record(ProbeResult{Model: "model1", Timestamp: now.Add(-time.Minute), Success: true, DurationMs: 100})
+	probes.record(ProbeResult{Model: "model1", Timestamp: now, Success: false, DurationMs: 300})
+
+	summary := probes.summary("model1", time.Time{})
+	assert.Equal(t, 3, summary.Probes)
+	assert.Equal(t, 2, summary.Failures)
+	require.NotNil(t, summary.Availability)
+	assert.InDelta(t, 1.0/3, *summary.Availability, 0.001)
+	assert.Equal(t, 433, summary.AvgDurationMs)
+	assert.Equal(t, 900, summary.MaxDurationMs)
+
+	summary = probes.summary("model1", now.Add(-time.Hour))
+	assert.Equal(t, 2, summary.Probes)
+	assert.InDelta(t, 0.5, *summary.Availability, 0.001)
+	assert.Equal(t, 300, summary.MaxDurationMs)
+
+	// no probes yet
+	summary = probes.summary("model2", time.Time{})
+	assert.Zero(t, summary.Probes)
+	assert.Nil(t, summary.Availability)
+	assert.NotNil(t, summary.Results)
+
+	for i := range probeHistorySize + 10 {
+		probes.record(ProbeResult{Model: "model3", Timestamp: now.Add(time.Duration(i) * time.Second)})
+	}
+	assert.Equal(t, probeHistorySize, probes.summary("model3", time.Time{}).Probes)
+}
+
+func TestProxyManager_ProbeModel(t *testing.T) {
+	model1 := getTestSimpleResponderConfig("model1")
+	model1.Probe = config.ProbeConfig{Interval: 3600}
+	model2 := getTestSimpleResponderConfig("model2")
+	model2.Probe = config.ProbeConfig{Interval: 3600, Load: true}
+
+	conf := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]config.ModelConfig{
+			"model1": model1,
+			"model2": model2,
+		},
+		LogLevel: "error",
+	})
+
+	proxy := New(conf)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	// not loaded, skipped unless the probe may load it
+	assert.False(t, proxy.probeModel("model1"))
+	assert.Equal(t, StateStopped, proxy.modelState("model1"))
+
+	assert.True(t, proxy.probeModel("model2"))
+	assert.Equal(t, StateReady, proxy.modelState("model2"))
+	summary := proxy.probes.summary("model2", time.Time{})
+	require.Equal(t, 1, summary.Probes)
+	assert.True(t, summary.Results[0].Success, summary.Results[0].Error)
+	assert.Equal(t, http.StatusOK, summary.Results[0].StatusCode)
+
+	// probes do not count as use of the model
+	process, _ := proxy.findGroupByModelName("model2").GetMember("model2")
+	assert.True(t, process.getLastRequestHandled().IsZero())
+
+	// probe results stay out of the user traffic metrics
+	assert.Empty(t, proxy.metricsMonitor.getMetrics())
+
+	req := httptest.NewRequest("GET", "/api/probes?window=1h", nil)
+	w := CreateTestResponseRecorder()
+	proxy.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	var summaries []ProbeSummary
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &summaries))
+	require.Len(t, summaries, 2)
+	assert.Equal(t, "model1", summaries[0].Model)
+	assert.Zero(t, summaries[0].Probes)
+	assert.Equal(t, "model2", summaries[1].Model)
+	assert.Equal(t, 1, summaries[1].Probes)
+
+	req = httptest.NewRequest("GET", "/api/probes?window=soon", nil)
+	w = CreateTestResponseRecorder()
+	proxy.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -1,6 +1,7 @@
 package proxy
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
@@ -12,6 +13,8 @@ import (
 	"net/http/httputil"
 	"net/url"
 	"os/exec"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -20,6 +23,7 @@ import (
 
 	"github.com/napmany/llmsnap/event"
 	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/tidwall/gjson"
 )
 
 type ProcessState string
@@ -30,9 +34,16 @@ const (
 	StateReady    ProcessState = ProcessState("ready")
 	StateStopping ProcessState = ProcessState("stopping")
 
+	// process takes no new requests and stops once the inflight ones complete
+	StateDraining ProcessState = ProcessState("draining")
+
 	// process is shutdown and will not be restarted
 	StateShutdown ProcessState = ProcessState("shutdown")
 
+	// process failed too many times and is not started until it is reset,
+	// see config.CrashLoopConfig
+	StateFailed ProcessState = ProcessState("failed")
+
 	// sleep/wake states
 	StateSleepPending ProcessState = ProcessState("sleepPending")
 	StateAsleep       ProcessState = ProcessState("asleep")
@@ -79,6 +90,15 @@ type Process struct {
 	inFlightRequests      sync.WaitGroup
 	inFlightRequestsCount atomic.Int32
 
+	// streamed requests among the ones in flight
+	inFlightStreamingCount atomic.Int32
+
+	// requests handled since llmsnap started
+	requestsServed atomic.Int64
+
+	// when the last start() finished, in unix nanoseconds
+	readyAt atomic.Int64
+
 	// used to block on multiple start() calls
 	waitStarting sync.WaitGroup
 
@@ -88,14 +108,64 @@ type Process struct {
 	// used to block on multiple Wake() calls
 	waitWaking sync.WaitGroup
 
-	// for managing concurrency limits
+	// for managing concurrency limits, streamed requests take their own
+	// slots when streamingConcurrencyLimit is set
 	concurrencyLimitSemaphore chan struct{}
+	streamingSemaphore        chan struct{}
 
 	// used for testing to override the default value
 	gracefulStopTimeout time.Duration
 
 	// track the number of failed starts
-	failedStartCount int
+	failedStartCount atomic.Int32
+
+	// delays restarts after failures, nil without crashLoop in the config
+	crashLoop *crashLoop
+
+	// moving averages of how long start() and wake() take, in nanoseconds
+	loadDuration atomic.Int64
+	wakeDuration atomic.Int64
+
+	// set by the ProxyManager that owns the process, see processHooks
+	hookSet atomic.Pointer[processHooks]
+
+	// TTL in seconds set by the keep_alive of the last request, 0 uses the
+	// configured TTL and a negative value keeps the model loaded
+	keepAlive atomic.Int64
+
+	// true while the TTL is being checked
+	unloadMonitoring atomic.Bool
+
+	// the draft server started before and stopped with this one, nil
+	// without a draft
+	draft *Process
+}
+
+// processHooks connect a process to the ProxyManager that owns it. They are
+// replaced as a whole when a config reload hands a running process to a new
+// ProxyManager.
+type processHooks struct {
+	// places the model on GPUs on every start, nil when it does not need it
+	gpus *gpuAllocator
+
+	// the group's onBattery ttl, used while onBattery returns true
+	batteryTTL int
+	onBattery  func() bool
+
+	// true while a schedule keeps the model loaded, the TTL is paused
+	keepLoaded func() bool
+
+	// called when the process fails to start or exits on its own
+	onFailure func(err error)
+
+	// injects faults while running with --chaos, nil for other models
+	chaos *chaosInjector
+
+	// the model's script, nil when it has none
+	script *luaScript
+
+	// the model's wasmFilters
+	wasmFilters wasmFilterChain
 }
 
 func NewProcess(ID string, healthCheckTimeout int, config config.ModelConfig, processLogger *LogMonitor, proxyLogger *LogMonitor) *Process {
@@ -103,6 +173,10 @@ func NewProcess(ID string, healthCheckTimeout int, config config.ModelConfig, pr
 	if config.ConcurrencyLimit > 0 {
 		concurrentLimit = config.ConcurrencyLimit
 	}
+	var streamingSemaphore chan struct{}
+	if config.StreamingConcurrencyLimit > 0 {
+		streamingSemaphore = make(chan struct{}, config.StreamingConcurrencyLimit)
+	}
 
 	// Setup the reverse proxy.
 	proxyURL, err := url.Parse(config.Proxy)
@@ -114,6 +188,8 @@ func NewProcess(ID string, healthCheckTimeout int, config config.ModelConfig, pr
 	if proxyURL != nil {
 		reverseProxy = httputil.NewSingleHostReverseProxy(proxyURL)
 		reverseProxy.ModifyResponse = func(resp *http.Response) error {
+			// the request ID llmsnap sent back already wins
+			resp.Header.Del(requestIDHeader)
 			// prevent nginx from buffering streaming responses (e.g., SSE)
 			if strings.Contains(strings.ToLower(resp.Header.Get("Content-Type")), "text/event-stream") {
 				resp.Header.Set("X-Accel-Buffering", "no")
@@ -122,6 +198,11 @@ func NewProcess(ID string, healthCheckTimeout int, config config.ModelConfig, pr
 		}
 	}
 
+	var crashes *crashLoop
+	if config.CrashLoop.Enabled() {
+		crashes = newCrashLoop(config.CrashLoop)
+	}
+
 	return &Process{
 		ID:                      ID,
 		config:                  config,
@@ -133,9 +214,11 @@ func NewProcess(ID string, healthCheckTimeout int, config config.ModelConfig, pr
 		healthCheckTimeout:      healthCheckTimeout,
 		healthCheckLoopInterval: 5 * time.Second, /* default, can not be set by user - used for testing */
 		state:                   StateStopped,
+		crashLoop:               crashes,
 
 		// concurrency limit
 		concurrencyLimitSemaphore: make(chan struct{}, concurrentLimit),
+		streamingSemaphore:        streamingSemaphore,
 
 		// To be removed when migration over exec.CommandContext is complete
 		// stop timeout
@@ -144,6 +227,17 @@ func NewProcess(ID string, healthCheckTimeout int, config config.ModelConfig, pr
 	}
 }
 
+func (p *Process) hooks() *processHooks {
+	if hooks := p.hookSet.Load(); hooks != nil {
+		return hooks
+	}
+	return &processHooks{}
+}
+
+func (p *Process) setHooks(hooks *processHooks) {
+	p.hookSet.Store(hooks)
+}
+
 // LogMonitor returns the log monitor associated with the process.
 func (p *Process) LogMonitor() *LogMonitor {
 	return p.processLogger
@@ -207,11 +301,15 @@ func (p *Process) swapState(expectedState, newState ProcessState) (ProcessState,
 func isValidTransition(from, to ProcessState) bool {
 	switch from {
 	case StateStopped:
-		return to == StateStarting
+		return to == StateStarting || to == StateFailed
+	case StateFailed:
+		return to == StateStopped
 	case StateStarting:
 		return to == StateReady || to == StateStopping || to == StateStopped
 	case StateReady:
-		return to == StateStopping || to == StateSleepPending
+		return to == StateStopping || to == StateSleepPending || to == StateDraining
+	case StateDraining:
+		return to == StateStopping || to == StateStopped
 	case StateStopping:
 		return to == StateStopped || to == StateShutdown
 	case StateShutdown:
@@ -241,6 +339,18 @@ func (p *Process) forceState(newState ProcessState) {
 	p.state = newState
 }
 
+// assignGPU picks the devices for this start and returns the config with
+// ${GPU} replaced by them, comma separated
+func (p *Process) assignGPU() (config.ModelConfig, error) {
+	devices, err := p.hooks().gpus.allocate(p.ID)
+	if err != nil {
+		return config.ModelConfig{}, fmt.Errorf("unable to assign a GPU: %v", err)
+	}
+	device := strings.Join(devices, ",")
+	p.proxyLogger.Infof("<%s> starting on GPU %s", p.ID, device)
+	return p.config.WithGPU(device), nil
+}
+
 func (p *Process) makeReady() error {
 	currentState := p.CurrentState()
 	if currentState == StateSleepPending || currentState == StateAsleep || currentState == StateWaking {
@@ -274,6 +384,13 @@ func (p *Process) start() error {
 		return fmt.Errorf("unable to get sanitized command: %v", err)
 	}
 
+	if err := p.crashLoop.checkStart(time.Now()); err != nil {
+		if errors.Is(err, errCrashLoop) && p.CurrentState() == StateStopped {
+			p.swapState(StateStopped, StateFailed)
+		}
+		return err
+	}
+
 	if curState, err := p.swapState(StateStopped, StateStarting); err != nil {
 		if err == ErrExpectedStateMismatch {
 			// already starting, just wait for it to complete and expect
@@ -295,12 +412,55 @@ func (p *Process) start() error {
 
 	// waitStarting.Add(1) is now called atomically in swapState() when transitioning to StateStarting
 	defer p.waitStarting.Done()
+	loadStartTime := time.Now()
+
+	if chaos := p.hooks().chaos; chaos != nil && chaos.failStart() {
+		if _, swapErr := p.swapState(StateStarting, StateStopped); swapErr != nil {
+			p.forceState(StateStopped)
+		}
+		return p.reportFailure(errChaosStartFailure)
+	}
+
+	if p.draft != nil {
+		if err := p.draft.makeReady(); err != nil {
+			if _, swapErr := p.swapState(StateStarting, StateStopped); swapErr != nil {
+				p.forceState(StateStopped)
+			}
+			return p.reportFailure(fmt.Errorf("draft failed to start: %v", err))
+		}
+
+		// the draft is not left running when the model does not start
+		defer func() {
+			if p.CurrentState() != StateReady {
+				p.draft.StopImmediately()
+			}
+		}()
+	}
+
+	env := p.config.Env
+	if p.hooks().gpus != nil {
+		withGPU, err := p.assignGPU()
+		if err != nil {
+			if _, swapErr := p.swapState(StateStarting, StateStopped); swapErr != nil {
+				p.forceState(StateStopped)
+			}
+			return err
+		}
+		if args, err = withGPU.SanitizedCommand(); err != nil {
+			if _, swapErr := p.swapState(StateStarting, StateStopped); swapErr != nil {
+				p.forceState(StateStopped)
+			}
+			return fmt.Errorf("unable to get sanitized command: %v", err)
+		}
+		env = withGPU.Env
+	}
+
 	cmdContext, ctxCancelUpstream := context.WithCancel(context.Background())
 
 	p.cmd = exec.CommandContext(cmdContext, args[0], args[1:]...)
 	p.cmd.Stdout = p.processLogger
 	p.cmd.Stderr = p.processLogger
-	p.cmd.Env = append(p.cmd.Environ(), p.config.Env...)
+	p.cmd.Env = append(append(p.cmd.Environ(), requestIDEnv+"="+requestIDHeader), env...)
 	p.cmd.Cancel = p.cmdStopUpstreamProcess
 	p.cmd.WaitDelay = p.gracefulStopTimeout
 	setProcAttributes(p.cmd)
@@ -310,9 +470,9 @@ func (p *Process) start() error {
 	p.cmdWaitChan = make(chan struct{})
 	p.cmdMutex.Unlock()
 
-	p.failedStartCount++ // this will be reset to zero when the process has successfully started
+	p.failedStartCount.Add(1) // this will be reset to zero when the process has successfully started
 
-	p.proxyLogger.Debugf("<%s> Executing start command: %s, env: %s", p.ID, strings.Join(args, " "), strings.Join(p.config.Env, ", "))
+	p.proxyLogger.Debugf("<%s> Executing start command: %s, env: %s", p.ID, strings.Join(args, " "), strings.Join(env, ", "))
 	err = p.cmd.Start()
 
 	// Set process state to failed
@@ -324,7 +484,7 @@ func (p *Process) start() error {
 				strings.Join(args, " "), err, curState, swapErr,
 			)
 		}
-		return fmt.Errorf("start() failed for command '%s': %v", strings.Join(args, " "), err)
+		return p.reportFailure(fmt.Errorf("start() failed for command '%s': %v", strings.Join(args, " "), err))
 	}
 
 	// Capture the exit error for later signalling
@@ -340,31 +500,28 @@ func (p *Process) start() error {
 
 	checkStartTime := time.Now()
 	maxDuration := time.Second * time.Duration(p.healthCheckTimeout)
-	checkEndpoint := strings.TrimSpace(p.config.CheckEndpoint)
-
-	// a "none" means don't check for health ... I could have picked a better word :facepalm:
-	if checkEndpoint != "none" {
-		healthURL, err := p.buildFullURL(checkEndpoint)
-		if err != nil {
-			return fmt.Errorf("failed to create health check URL proxy=%s and checkEndpoint=%s", p.config.Proxy, checkEndpoint)
-		}
 
+	healthURL, checkHealth, err := p.healthCheck()
+	if err != nil {
+		return err
+	}
+	if checkHealth != nil {
 		// Ready Check loop
 		for {
 			currentState := p.CurrentState()
 			if currentState != StateStarting {
 				if currentState == StateStopped {
-					return fmt.Errorf("upstream command exited prematurely but successfully")
+					return p.reportFailure(fmt.Errorf("upstream command exited prematurely but successfully"))
 				}
 				return errors.New("health check interrupted due to shutdown")
 			}
 
 			if time.Since(checkStartTime) > maxDuration {
 				p.stopCommand()
-				return fmt.Errorf("health check timed out after %vs", maxDuration.Seconds())
+				return p.reportFailure(fmt.Errorf("health check timed out after %vs", maxDuration.Seconds()))
 			}
 
-			if err := p.checkHealthEndpoint(checkEndpoint); err == nil {
+			if err := checkHealth(); err == nil {
 				p.proxyLogger.Infof("<%s> Health check passed on %s", p.ID, healthURL)
 				break
 			} else {
@@ -382,20 +539,140 @@ func (p *Process) start() error {
 	if curState, err := p.swapState(StateStarting, StateReady); err != nil {
 		return fmt.Errorf("failed to set Process state to ready: current state: %v, error: %v", curState, err)
 	} else {
-		p.failedStartCount = 0
+		p.failedStartCount.Store(0)
+		p.readyAt.Store(time.Now().UnixNano())
+		observeDuration(&p.loadDuration, time.Since(loadStartTime))
 		p.startUnloadMonitoring()
+		p.startHealthMonitoring()
 		return nil
 	}
 }
 
+// healthCheck returns what is checked for the logs and the check that passes
+// once the server is healthy, check is nil when checkEndpoint is none
+func (p *Process) healthCheck() (target string, check func() error, err error) {
+	checkEndpoint := strings.TrimSpace(p.config.CheckEndpoint)
+	if strings.TrimSpace(p.config.CheckCmd) != "" {
+		return "checkCmd", p.runCheckCmd, nil
+	}
+
+	// a "none" means don't check for health ... I could have picked a better word :facepalm:
+	if checkEndpoint == "none" {
+		return "", nil, nil
+	}
+
+	if target, err = p.buildFullURL(checkEndpoint); err != nil {
+		return "", nil, fmt.Errorf("failed to create health check URL proxy=%s and checkEndpoint=%s", p.config.Proxy, checkEndpoint)
+	}
+	return target, func() error { return p.checkHealthEndpoint(checkEndpoint) }, nil
+}
+
+// startHealthMonitoring checks a ready process every healthCheck.interval
+// until it stops. After healthCheck.failureThreshold failed checks in a row
+// it is unhealthy and stopped, the next request starts it again.
+func (p *Process) startHealthMonitoring() {
+	interval := p.config.HealthCheck.IntervalDuration()
+	if interval <= 0 {
+		return
+	}
+	target, checkHealth, err := p.healthCheck()
+	if err != nil || checkHealth == nil {
+		return
+	}
+
+	// a monitor belongs to one start, a restarted process gets a new one
+	readyAt := p.readyAt.Load()
+	go func() {
+		failures := 0
+		for range time.Tick(interval) {
+			if p.readyAt.Load() != readyAt {
+				return
+			}
+			switch p.CurrentState() {
+			case StateReady:
+			case StateDraining, StateSleepPending, StateAsleep, StateWaking:
+				// a sleeping server is not expected to answer
+				continue
+			default:
+				return
+			}
+
+			err := checkHealth()
+			if err == nil {
+				failures = 0
+				continue
+			}
+
+			failures++
+			p.proxyLogger.Warnf("<%s> Health check %d/%d failed on %s: %v", p.ID, failures, p.config.HealthCheck.Threshold(), target, err)
+			if failures >= p.config.HealthCheck.Threshold() {
+				p.proxyLogger.Errorf("<%s> unhealthy, stopping it", p.ID)
+				p.StopImmediately()
+				p.reportFailure(fmt.Errorf("unhealthy after %d failed health checks, last: %v", failures, err))
+				return
+			}
+		}
+	}()
+}
+
+// withDraft adds the draft server of the model. When it exits on its own the
+// model is stopped, the next request starts both again.
+func (p *Process) withDraft(draft *Process) {
+	draft.setHooks(&processHooks{onFailure: func(err error) {
+		if p.CurrentState() == StateReady {
+			p.proxyLogger.Warnf("<%s> stopping, its draft failed: %v", p.ID, err)
+			go p.StopImmediately()
+		}
+	}})
+	p.draft = draft
+}
+
+// reportFailure counts err for the crash loop detection, passes it to
+// onFailure and returns it
+func (p *Process) reportFailure(err error) error {
+	if p.crashLoop != nil {
+		if p.crashLoop.recordFailure(err, time.Now()) {
+			p.proxyLogger.Errorf("<%s> failed %d times within %s, it is not started again until POST /api/models/reset/%s",
+				p.ID, p.config.CrashLoop.MaxRestarts, p.config.CrashLoop.WindowDuration(), p.ID)
+			if p.CurrentState() == StateStopped {
+				p.swapState(StateStopped, StateFailed)
+			}
+		}
+	}
+	if onFailure := p.hooks().onFailure; onFailure != nil {
+		onFailure(err)
+	}
+	return err
+}
+
+// unloadAfter returns the TTL in seconds, 0 never unloads. The keep_alive of
+// the last request wins, then the group's onBattery ttl while the host runs on
+// battery.
+func (p *Process) unloadAfter() int {
+	if keepAlive := p.keepAlive.Load(); keepAlive != 0 {
+		return max(int(keepAlive), 0)
+	}
+	if hooks := p.hooks(); hooks.batteryTTL > 0 && hooks.onBattery != nil && hooks.onBattery() {
+		return hooks.batteryTTL
+	}
+	return p.config.UnloadAfter
+}
+
+// keptLoaded reports if a schedule keeps the model loaded, the TTL is paused
+func (p *Process) keptLoaded() bool {
+	keepLoaded := p.hooks().keepLoaded
+	return keepLoaded != nil && keepLoaded()
+}
+
 // startUnloadMonitoring begins TTL monitoring for automatic model unloading.
+// With sleepAfter the model is put to sleep first and stopped once the TTL is
+// reached.
 func (p *Process) startUnloadMonitoring() {
-	if p.config.UnloadAfter > 0 {
+	if (p.config.UnloadAfter > 0 || p.config.SleepAfter > 0 || p.hooks().batteryTTL > 0 || p.keepAlive.Load() != 0) && p.unloadMonitoring.CompareAndSwap(false, true) {
 		// start a goroutine to check every second if
 		// the process should be stopped
 		go func() {
-			maxDuration := time.Duration(p.config.UnloadAfter) * time.Second
-
+			defer p.unloadMonitoring.Store(false)
 			for range time.Tick(time.Second) {
 				curState := p.CurrentState()
 				if curState != StateReady &&
@@ -410,28 +687,68 @@ func (p *Process) startUnloadMonitoring() {
 					continue
 				}
 
-				if time.Since(p.getLastRequestHandled()) > maxDuration {
-					p.proxyLogger.Infof("<%s> Unloading model, TTL of %ds reached", p.ID, p.config.UnloadAfter)
+				if p.keptLoaded() {
+					p.setLastRequestHandled(time.Now())
+					continue
+				}
+
+				idle := time.Since(p.getLastRequestHandled())
+				ttl := p.unloadAfter()
+				if ttl > 0 && idle > time.Duration(ttl)*time.Second {
+					p.proxyLogger.Infof("<%s> Unloading model, TTL of %ds reached", p.ID, ttl)
 					p.Stop()
 					return
 				}
+
+				sleepAfter := p.config.SleepAfter
+				if sleepAfter > 0 && curState == StateReady && p.isSleepEnabled() && idle > time.Duration(sleepAfter)*time.Second {
+					p.proxyLogger.Infof("<%s> Putting model to sleep, idle for %ds", p.ID, sleepAfter)
+					p.Sleep()
+				}
 			}
 		}()
 	}
 }
 
 // Stop will wait for inflight requests to complete before stopping the process.
+// A ready process is draining meanwhile and refuses new requests.
 func (p *Process) Stop() {
-	if !isValidTransition(p.CurrentState(), StateStopping) {
+	initState := p.CurrentState()
+	if !isValidTransition(initState, StateStopping) {
 		return
 	}
 
+	if initState == StateReady {
+		if _, err := p.swapState(StateReady, StateDraining); err == nil {
+			p.proxyLogger.Infof("<%s> Draining %d inflight request(s)", p.ID, p.inFlightRequestsCount.Load())
+		}
+	}
+
 	// wait for any inflight requests before proceeding
 	p.proxyLogger.Debugf("<%s> Stop(): Waiting for inflight requests to complete", p.ID)
 	p.inFlightRequests.Wait()
 	p.StopImmediately()
 }
 
+// retire refuses the new requests of a process that is about to be stopped
+// for good: a ready process drains its in-flight requests and one that is
+// not running can not be started again
+func (p *Process) retire() {
+	p.stateMutex.Lock()
+	defer p.stateMutex.Unlock()
+
+	oldState := p.state
+	switch oldState {
+	case StateReady:
+		p.state = StateDraining
+	case StateStopped, StateFailed:
+		p.state = StateShutdown
+	default:
+		return
+	}
+	event.Emit(ProcessStateChangeEvent{ProcessName: p.ID, NewState: p.state, OldState: oldState})
+}
+
 // StopImmediately will transition the process to the stopping state and stop the process with a SIGTERM.
 // If the process does not stop within the specified timeout, it will be forcefully stopped with a SIGKILL.
 func (p *Process) StopImmediately() {
@@ -461,6 +778,9 @@ func (p *Process) Shutdown() {
 	p.stopCommand()
 	// just force it to this state since there is no recovery from shutdown
 	p.forceState(StateShutdown)
+	if p.draft != nil {
+		p.draft.Shutdown()
+	}
 }
 
 // sendSleepRequests sends all sleep requests in sequence
@@ -602,6 +922,7 @@ func (p *Process) wake() error {
 		return fmt.Errorf("failed to transition to ready after wake: current state: %v, error: %v", curState, err)
 	}
 
+	observeDuration(&p.wakeDuration, time.Since(wakeStartTime))
 	p.proxyLogger.Infof("<%s> Model wake completed in %v", p.ID, time.Since(wakeStartTime))
 	return nil
 }
@@ -629,6 +950,10 @@ func (p *Process) stopCommand() {
 
 	cancelUpstream()
 	<-cmdWaitChan
+
+	if p.draft != nil {
+		p.draft.StopImmediately()
+	}
 }
 
 // buildFullURL builds a full URL from the proxy base URL and an endpoint path
@@ -652,9 +977,16 @@ func (p *Process) buildFullURL(endpoint string) (string, error) {
 
 // sendHTTPRequest sends a single HTTP request based on endpoint config
 func (p *Process) sendHTTPRequest(endpoint config.HTTPEndpoint) error {
+	_, err := p.requestHTTPEndpoint(endpoint, http.StatusOK)
+	return err
+}
+
+// requestHTTPEndpoint sends a request to endpoint and returns the body of
+// its response with expectStatus, up to 1MiB of it
+func (p *Process) requestHTTPEndpoint(endpoint config.HTTPEndpoint, expectStatus int) ([]byte, error) {
 	fullURL, err := p.buildFullURL(endpoint.Endpoint)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	timeout := time.Duration(endpoint.Timeout) * time.Second
@@ -662,6 +994,7 @@ func (p *Process) sendHTTPRequest(endpoint config.HTTPEndpoint) error {
 	// Create HTTP client with timeout
 	client := &http.Client{
 		Transport: &http.Transport{
+			Proxy: upstreamProxyFunc(p.config.UpstreamProxy),
 			DialContext: (&net.Dialer{
 				Timeout: httpDialTimeout,
 			}).DialContext,
@@ -676,7 +1009,7 @@ func (p *Process) sendHTTPRequest(endpoint config.HTTPEndpoint) error {
 
 	req, err := http.NewRequest(endpoint.Method, fullURL, bodyReader)
 	if err != nil {
-		return fmt.Errorf("failed to create request: %v", err)
+		return nil, fmt.Errorf("failed to create request: %v", err)
 	}
 
 	if endpoint.Body != "" {
@@ -685,28 +1018,129 @@ func (p *Process) sendHTTPRequest(endpoint config.HTTPEndpoint) error {
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("status code: %d", resp.StatusCode)
+	if resp.StatusCode != expectStatus {
+		return nil, fmt.Errorf("status code: %d", resp.StatusCode)
 	}
 
-	return nil
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %v", err)
+	}
+	return body, nil
 }
 
 func (p *Process) checkHealthEndpoint(endpoint string) error {
 	// Create HTTP endpoint config for health check
 	// Health check gets 5 seconds to respond after connection is established (see issue: 276)
 	healthEndpoint := config.HTTPEndpoint{
-		Method:   "GET",
+		Method:   p.config.HealthCheck.RequestMethod(),
 		Endpoint: endpoint,
 		Timeout:  5,
 		Body:     "",
 	}
 
-	return p.sendHTTPRequest(healthEndpoint)
+	body, err := p.requestHTTPEndpoint(healthEndpoint, p.config.HealthCheck.HealthyStatus())
+	if err != nil {
+		return err
+	}
+	return matchHealthBody(body, p.config.ExpectBody, p.config.ExpectJSON)
+}
+
+// matchHealthBody fails unless body contains expectBody and the gjson paths
+// in expectJSON have their expected values
+func matchHealthBody(body []byte, expectBody string, expectJSON map[string]string) error {
+	if expectBody != "" && !bytes.Contains(body, []byte(expectBody)) {
+		return fmt.Errorf("response does not contain %q", expectBody)
+	}
+
+	paths := make([]string, 0, len(expectJSON))
+	for path := range expectJSON {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		if value := gjson.GetBytes(body, path); !value.Exists() {
+			return fmt.Errorf("response has no %s", path)
+		} else if value.String() != expectJSON[path] {
+			return fmt.Errorf("response %s is %q, expected %q", path, value.String(), expectJSON[path])
+		}
+	}
+	return nil
+}
+
+// runCheckCmd runs checkCmd and fails unless it exits with 0. ${PID} is
+// replaced with the pid of the upstream command. Like the endpoint check it
+// gets 5 seconds.
+func (p *Process) runCheckCmd() error {
+	checkArgs, err := config.SanitizeCommand(strings.ReplaceAll(p.config.CheckCmd, "${PID}", strconv.Itoa(p.pid())))
+	if err != nil {
+		return fmt.Errorf("unable to sanitize check command: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	checkCmd := exec.CommandContext(ctx, checkArgs[0], checkArgs[1:]...)
+	setProcAttributes(checkCmd)
+	checkCmd.Env = p.cmd.Env
+	checkCmd.WaitDelay = time.Second
+
+	output, err := checkCmd.CombinedOutput()
+	if err != nil {
+		if out := strings.TrimSpace(string(output)); out != "" {
+			return fmt.Errorf("check command failed: %v: %s", err, out)
+		}
+		return fmt.Errorf("check command failed: %v", err)
+	}
+	return nil
+}
+
+// takeSlot takes a concurrency slot for r, one of the streaming slots for
+// streamed requests when streamingConcurrencyLimit is set. When all are taken
+// it waits for one with concurrencyOverflow queue and sends a 429 otherwise.
+// ok is false when r got no slot and a response has been sent.
+func (p *Process) takeSlot(w http.ResponseWriter, r *http.Request, streaming bool) (release func(), ok bool) {
+	slots, setting := p.concurrencyLimitSemaphore, "concurrencyLimit"
+	if streaming && p.streamingSemaphore != nil {
+		slots, setting = p.streamingSemaphore, "streamingConcurrencyLimit"
+	}
+	release = func() { <-slots }
+
+	select {
+	case slots <- struct{}{}:
+		return release, true
+	default:
+	}
+
+	if p.config.ConcurrencyOverflow == config.ConcurrencyOverflowQueue {
+		p.proxyLogger.Debugf("<%s> all %d slots of %s are taken, waiting for one", p.ID, cap(slots), setting)
+		select {
+		case slots <- struct{}{}:
+			return release, true
+		case <-r.Context().Done():
+			if queueTimedOut(r) {
+				rejectQueueTimeout(w, p.ID)
+			}
+			return nil, false
+		}
+	}
+
+	http.Error(w, fmt.Sprintf("Too many requests. Consider increasing %s in your llmsnap model configuration.", setting), http.StatusTooManyRequests)
+	return nil, false
+}
+
+// emitInFlight sends the number of requests in flight
+func (p *Process) emitInFlight() {
+	event.Emit(InFlightChangedEvent{
+		ProcessName: p.ID,
+		InFlight:    int(p.inFlightRequestsCount.Load()),
+		Streaming:   int(p.inFlightStreamingCount.Load()),
+	})
 }
 
 func (p *Process) ProxyRequest(w http.ResponseWriter, r *http.Request) {
@@ -721,25 +1155,40 @@ func (p *Process) ProxyRequest(w http.ResponseWriter, r *http.Request) {
 
 	// prevent new requests from being made while stopping or irrecoverable
 	currentState := p.CurrentState()
-	if currentState == StateShutdown || currentState == StateStopping || currentState == StateSleepPending {
+	if currentState == StateShutdown || currentState == StateStopping || currentState == StateDraining || currentState == StateSleepPending {
 		http.Error(w, fmt.Sprintf("Process can not ProxyRequest, state is %s", currentState), http.StatusServiceUnavailable)
 		return
 	}
+	if info, failed := p.crashLoop.info(); failed || currentState == StateFailed {
+		http.Error(w, fmt.Sprintf("Process can not ProxyRequest, it failed after %s, reset it with POST /api/models/reset/%s", info.Reason, p.ID), http.StatusServiceUnavailable)
+		return
+	}
 
-	select {
-	case p.concurrencyLimitSemaphore <- struct{}{}:
-		defer func() { <-p.concurrencyLimitSemaphore }()
-	default:
-		http.Error(w, "Too many requests. Consider increasing concurrencyLimit in your llmsnap model configuration.", http.StatusTooManyRequests)
+	isStreaming, _ := r.Context().Value(proxyCtxKey("streaming")).(bool)
+	releaseSlot, ok := p.takeSlot(w, r, isStreaming)
+	if !ok {
 		return
 	}
+	defer releaseSlot()
 
 	p.inFlightRequests.Add(1)
 	p.inFlightRequestsCount.Add(1)
+	if isStreaming {
+		p.inFlightStreamingCount.Add(1)
+	}
+	p.emitInFlight()
 	defer func() {
-		p.setLastRequestHandled(time.Now())
+		// synthetic probes do not keep the model loaded
+		if probe, _ := r.Context().Value(proxyCtxKey("probe")).(bool); !probe {
+			p.setLastRequestHandled(time.Now())
+		}
+		p.requestsServed.Add(1)
 		p.inFlightRequestsCount.Add(-1)
+		if isStreaming {
+			p.inFlightStreamingCount.Add(-1)
+		}
 		p.inFlightRequests.Done()
+		p.emitInFlight()
 	}()
 
 	// for #366
@@ -751,8 +1200,6 @@ func (p *Process) ProxyRequest(w http.ResponseWriter, r *http.Request) {
 		// start a goroutine to stream loading status messages into the response writer
 		// add a sync so the streaming client only runs when the goroutine has exited
 
-		isStreaming, _ := r.Context().Value(proxyCtxKey("streaming")).(bool)
-
 		// PR #417 (no support for anthropic v1/messages yet)
 		isChatCompletions := strings.HasPrefix(r.URL.Path, "/v1/chat/completions")
 		if p.config.SendLoadingState != nil && *p.config.SendLoadingState && isStreaming && isChatCompletions {
@@ -773,7 +1220,13 @@ func (p *Process) ProxyRequest(w http.ResponseWriter, r *http.Request) {
 				// the goroutine can write its cleanup messages, causing incomplete SSE output.
 				srw.waitForCompletion(100 * time.Millisecond)
 			} else {
-				http.Error(w, errstr, http.StatusBadGateway)
+				status := http.StatusBadGateway
+				var backoff *crashBackoffError
+				if errors.As(err, &backoff) {
+					w.Header().Set("Retry-After", backoff.retryAfter())
+					status = http.StatusServiceUnavailable
+				}
+				http.Error(w, errstr, status)
 			}
 			return
 		}
@@ -783,11 +1236,33 @@ func (p *Process) ProxyRequest(w http.ResponseWriter, r *http.Request) {
 	// should trigger srw to stop sending loading events ...
 	cancelLoadCtx()
 
+	// the request may have run out of time in the queue while loading
+	if queueTimedOut(r) {
+		if srw != nil {
+			srw.sendData(fmt.Sprintf("Request for %s waited too long in the queue\n", p.ID))
+			srw.waitForCompletion(100 * time.Millisecond)
+		} else {
+			rejectQueueTimeout(w, p.ID)
+		}
+		return
+	}
+	requestStarted(r)
+
+	chaos := p.hooks().chaos
+	if chaos != nil && !chaos.delay(r.Context()) {
+		return
+	}
+
 	// recover from http.ErrAbortHandler panics that can occur when the client
 	// disconnects before the response is sent
+	var chaosDrop *chaosDropWriter
 	defer func() {
 		if r := recover(); r != nil {
-			if r == http.ErrAbortHandler {
+			if r == http.ErrAbortHandler && chaosDrop != nil && chaosDrop.dropped {
+				// let the server close the connection like a real drop
+				p.proxyLogger.Infof("<%s> chaos: dropped stream", p.ID)
+				panic(r)
+			} else if r == http.ErrAbortHandler {
 				p.proxyLogger.Infof("<%s> recovered from client disconnection during streaming", p.ID)
 			} else {
 				p.proxyLogger.Infof("<%s> recovered from panic: %v", p.ID, r)
@@ -795,17 +1270,80 @@ func (p *Process) ProxyRequest(w http.ResponseWriter, r *http.Request) {
 		}
 	}()
 
+	var dst http.ResponseWriter = w
 	if srw != nil {
 		// Wait for the goroutine to finish writing its final messages
 		const completionTimeout = 1 * time.Second
 		if !srw.waitForCompletion(completionTimeout) {
 			p.proxyLogger.Warnf("<%s> status updates goroutine did not complete within %v, proceeding with proxy request", p.ID, completionTimeout)
 		}
-		p.reverseProxy.ServeHTTP(srw, r)
-	} else {
-		p.reverseProxy.ServeHTTP(w, r)
+		dst = srw
+	}
+
+	// sockets hijack the connection, which the writers below can not pass on
+	if !isWebSocketUpgrade(r) {
+		if chaos != nil {
+			if chaosDrop = chaos.dropWriter(dst); chaosDrop != nil {
+				dst = chaosDrop
+			}
+		}
+
+		if p.config.SSEFlush == config.SSEFlushEvent || p.config.SSEFlush == config.SSEFlushBuffered {
+			fw := newSSEFlushWriter(dst, p.config.SSEFlush, time.Duration(p.config.SSEFlushInterval)*time.Millisecond)
+			defer fw.Close()
+			dst = fw
+		}
+
+		// the chat completion writers below see the response before it is
+		// converted back
+		if p.config.TranslateMessages && r.Method == http.MethodPost && r.URL.Path == messagesPath {
+			translated, model, err := translateMessagesRequest(r)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("unable to translate messages request: %v", err), http.StatusBadRequest)
+				return
+			}
+			r = translated
+			mw := newMessagesWriter(dst, model)
+			defer mw.Close()
+			dst = mw
+		}
+
+		// closed before the flush writer so the buffered body goes through it
+		if p.config.RepairToolCalls {
+			tw := newTransformWriter(dst, newToolCallRepairer(p.ID, p.proxyLogger))
+			defer tw.Close()
+			dst = tw
+		}
+		if p.config.Reasoning != "" {
+			tw := newTransformWriter(dst, newReasoningNormalizer(p.config.Reasoning))
+			defer tw.Close()
+			dst = tw
+		}
+		if script := p.hooks().script; script != nil {
+			tw := newTransformWriter(dst, &scriptTransformer{script: script, path: r.URL.Path, logger: p.proxyLogger, id: p.ID})
+			defer tw.Close()
+			dst = tw
+		}
+		// responses pass the filters in reverse order, before the script
+		for _, filter := range p.hooks().wasmFilters {
+			if !filter.acquire() {
+				continue
+			}
+			defer filter.release()
+			tw := newTransformWriter(dst, &wasmResponseFilter{filter: filter, id: p.ID})
+			defer tw.Close()
+			dst = tw
+		}
+		// the rewrites see the response as the upstream sent it
+		if len(p.config.ResponseRewrites) > 0 {
+			tw := newTransformWriter(dst, &responseRewriter{rewrites: p.config.ResponseRewrites})
+			defer tw.Close()
+			dst = tw
+		}
 	}
 
+	p.reverseProxy.ServeHTTP(dst, r)
+
 	totalTime := time.Since(requestBeginTime)
 	p.proxyLogger.Debugf("<%s> request %s - start: %v, total: %v",
 		p.ID, r.RequestURI, startDuration, totalTime)
@@ -844,6 +1382,12 @@ func (p *Process) waitForCmd() {
 	default:
 		p.proxyLogger.Infof("<%s> process exited but not StateStopping, current state: %s", p.ID, currentState)
 		p.forceState(StateStopped) // force it to be in this state
+
+		// failures while starting are reported by start()
+		switch currentState {
+		case StateReady, StateDraining, StateSleepPending, StateAsleep, StateWaking:
+			p.reportFailure(fmt.Errorf("process exited unexpectedly in state %s: %v", currentState, exitErr))
+		}
 	}
 
 	p.cmdMutex.Lock()
@@ -1119,3 +1663,22 @@ func (s *statusResponseWriter) Flush() {
 		flusher.Flush()
 	}
 }
+
+// waitInFlight waits until the process has no requests in flight. It returns
+// false when ctx is done first.
+func (p *Process) waitInFlight(ctx context.Context) bool {
+	if ctx.Done() == nil {
+		return true
+	}
+
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for p.inFlightRequestsCount.Load() != 0 {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+	}
+	return true
+}
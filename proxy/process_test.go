@@ -1,17 +1,22 @@
 package proxy
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"runtime"
+	"slices"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/napmany/llmsnap/event"
 	"github.com/napmany/llmsnap/proxy/config"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 var (
@@ -238,6 +243,59 @@ func TestProcess_HTTPRequestsHaveTimeToFinish(t *testing.T) {
 	}
 }
 
+func TestProcess_StopDrainsInflightRequests(t *testing.T) {
+	config := getTestSimpleResponderConfig("draining")
+	process := NewProcess("draining", 10, config, debugLogger, debugLogger)
+	defer process.StopImmediately()
+
+	var states []ProcessState
+	var mu sync.Mutex
+	cancelEvent := event.On(func(e ProcessStateChangeEvent) {
+		if e.ProcessName == "draining" {
+			mu.Lock()
+			states = append(states, e.NewState)
+			mu.Unlock()
+		}
+	})
+	defer cancelEvent()
+
+	require.NoError(t, process.start())
+
+	inflight := make(chan *httptest.ResponseRecorder)
+	go func() {
+		w := httptest.NewRecorder()
+		process.ProxyRequest(w, httptest.NewRequest("GET", "/slow-respond?echo=12345&delay=300ms", nil))
+		inflight <- w
+	}()
+	assert.Eventually(t, func() bool { return process.inFlightRequestsCount.Load() == 1 }, time.Second, 10*time.Millisecond)
+
+	stopped := make(chan struct{})
+	go func() {
+		process.Stop()
+		close(stopped)
+	}()
+	assert.Eventually(t, func() bool { return process.CurrentState() == StateDraining }, time.Second, 10*time.Millisecond)
+
+	// new requests are refused while draining
+	w := httptest.NewRecorder()
+	process.ProxyRequest(w, httptest.NewRequest("GET", "/test", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), "state is draining")
+
+	// the inflight request completes before the process stops
+	w = <-inflight
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "12345", w.Body.String())
+	<-stopped
+	assert.Equal(t, StateStopped, process.CurrentState())
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Equal([]ProcessState{StateStarting, StateReady, StateDraining, StateStopping, StateStopped}, states)
+	}, time.Second, 10*time.Millisecond)
+}
+
 func TestProcess_SwapState(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -252,6 +310,9 @@ func TestProcess_SwapState(t *testing.T) {
 		{"Starting to Stopping", StateStarting, StateStarting, StateStopping, nil, StateStopping},
 		{"Starting to Stopped", StateStarting, StateStarting, StateStopped, nil, StateStopped},
 		{"Ready to Stopping", StateReady, StateReady, StateStopping, nil, StateStopping},
+		{"Ready to Draining", StateReady, StateReady, StateDraining, nil, StateDraining},
+		{"Draining to Stopping", StateDraining, StateDraining, StateStopping, nil, StateStopping},
+		{"Draining to Ready", StateDraining, StateDraining, StateReady, ErrInvalidStateTransition, StateDraining},
 		{"Stopping to Stopped", StateStopping, StateStopping, StateStopped, nil, StateStopped},
 		{"Stopping to Shutdown", StateStopping, StateStopping, StateShutdown, nil, StateShutdown},
 		{"Stopped to Ready", StateStopped, StateStopped, StateReady, ErrInvalidStateTransition, StateStopped},
@@ -351,6 +412,141 @@ func TestProcess_ExitInterruptsHealthCheck(t *testing.T) {
 	assert.Equal(t, process.CurrentState(), StateStopped)
 }
 
+func TestProcess_CheckCmd(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skipping checkCmd test on Windows")
+	}
+
+	readyFile := filepath.Join(t.TempDir(), "ready")
+	config := getTestSimpleResponderConfig("checkcmd_test")
+	config.CheckEndpoint = "none"
+	config.CheckCmd = fmt.Sprintf("sh -c 'kill -0 ${PID} && test -f %s'", readyFile)
+
+	process := NewProcess("checkcmd", 5, config, debugLogger, debugLogger)
+	process.healthCheckLoopInterval = 100 * time.Millisecond
+	defer process.Stop()
+
+	go func() {
+		<-time.After(500 * time.Millisecond)
+		os.WriteFile(readyFile, nil, 0644)
+	}()
+
+	start := time.Now()
+	require.NoError(t, process.start())
+	assert.GreaterOrEqual(t, time.Since(start), 500*time.Millisecond)
+	assert.Equal(t, StateReady, process.CurrentState())
+}
+
+func TestProcess_CheckCmdTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skipping checkCmd test on Windows")
+	}
+
+	config := getTestSimpleResponderConfig("checkcmd_timeout_test")
+	config.CheckCmd = "false"
+
+	process := NewProcess("checkcmd", 1, config, debugLogger, debugLogger)
+	process.healthCheckLoopInterval = 100 * time.Millisecond
+
+	err := process.start()
+	assert.ErrorContains(t, err, "health check timed out")
+	assert.Equal(t, StateStopped, process.CurrentState())
+}
+
+func TestProcess_MatchHealthBody(t *testing.T) {
+	body := []byte(`{"status":"ok","model":{"loaded":true,"slots":4}}`)
+
+	tests := []struct {
+		name       string
+		expectBody string
+		expectJSON map[string]string
+		wantErr    string
+	}{
+		{name: "no expectations"},
+		{name: "body matches", expectBody: `"status":"ok"`},
+		{name: "body does not match", expectBody: `"status":"loading"`, wantErr: `response does not contain "\"status\":\"loading\""`},
+		{name: "json matches", expectJSON: map[string]string{"status": "ok", "model.loaded": "true", "model.slots": "4"}},
+		{name: "json value differs", expectJSON: map[string]string{"model.loaded": "false"}, wantErr: `response model.loaded is "true", expected "false"`},
+		{name: "json path missing", expectJSON: map[string]string{"model.ready": "true"}, wantErr: "response has no model.ready"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := matchHealthBody(body, tt.expectBody, tt.expectJSON)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestProcess_HealthCheckExpectBody(t *testing.T) {
+	config := getTestSimpleResponderConfig("expect_body_test")
+	config.ExpectJSON = map[string]string{"status": "ok"}
+
+	process := NewProcess("expect-body", 5, config, debugLogger, debugLogger)
+	process.healthCheckLoopInterval = 100 * time.Millisecond
+	defer process.Stop()
+	require.NoError(t, process.start())
+	assert.Equal(t, StateReady, process.CurrentState())
+
+	config = getTestSimpleResponderConfig("expect_body_fail_test")
+	config.ExpectJSON = map[string]string{"status": "loaded"}
+	process = NewProcess("expect-body-fail", 1, config, debugLogger, debugLogger)
+	process.healthCheckLoopInterval = 100 * time.Millisecond
+	assert.ErrorContains(t, process.start(), "health check timed out")
+	assert.Equal(t, StateStopped, process.CurrentState())
+}
+
+func TestProcess_HealthCheckExpectStatus(t *testing.T) {
+	config := getTestSimpleResponderConfig("expect_status_test")
+	config.CheckEndpoint = "/missing"
+	config.HealthCheck.ExpectStatus = http.StatusNotFound
+
+	process := NewProcess("expect-status", 5, config, debugLogger, debugLogger)
+	process.healthCheckLoopInterval = 100 * time.Millisecond
+	defer process.Stop()
+	require.NoError(t, process.start())
+	assert.Equal(t, StateReady, process.CurrentState())
+}
+
+func TestProcess_HealthCheckInterval(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skipping checkCmd test on Windows")
+	}
+
+	readyFile := filepath.Join(t.TempDir(), "ready")
+	require.NoError(t, os.WriteFile(readyFile, nil, 0644))
+
+	config := getTestSimpleResponderConfig("health_interval_test")
+	config.CheckCmd = fmt.Sprintf("test -f %s", readyFile)
+	config.HealthCheck.Interval = 1
+	config.HealthCheck.FailureThreshold = 2
+
+	process := NewProcess("health-interval", 5, config, debugLogger, debugLogger)
+	process.healthCheckLoopInterval = 100 * time.Millisecond
+	defer process.Stop()
+
+	failures := make(chan error, 1)
+	process.setHooks(&processHooks{onFailure: func(err error) { failures <- err }})
+	require.NoError(t, process.start())
+
+	// passing checks keep it ready
+	<-time.After(1500 * time.Millisecond)
+	assert.Equal(t, StateReady, process.CurrentState())
+
+	require.NoError(t, os.Remove(readyFile))
+	select {
+	case err := <-failures:
+		assert.ErrorContains(t, err, "unhealthy after 2 failed health checks")
+	case <-time.After(5 * time.Second):
+		t.Fatal("onFailure was not called")
+	}
+	assert.Eventually(t, func() bool { return process.CurrentState() == StateStopped }, 5*time.Second, 50*time.Millisecond)
+}
+
 func TestProcess_ConcurrencyLimit(t *testing.T) {
 	if testing.Short() {
 		t.Skip("skipping long concurrency limit test")
@@ -383,6 +579,90 @@ func TestProcess_ConcurrencyLimit(t *testing.T) {
 	assert.Equal(t, http.StatusTooManyRequests, w.Code)
 }
 
+func TestProcess_StreamingConcurrencyLimit(t *testing.T) {
+	conf := getTestSimpleResponderConfig("streaming_limit")
+	conf.ConcurrencyLimit = 1
+	conf.StreamingConcurrencyLimit = 1
+	process := NewProcess("streaming_limit", 2, conf, debugLogger, debugLogger)
+
+	take := func(streaming bool) (func(), *httptest.ResponseRecorder) {
+		w := httptest.NewRecorder()
+		release, _ := process.takeSlot(w, httptest.NewRequest("POST", "/v1/chat/completions", nil), streaming)
+		return release, w
+	}
+
+	// streamed and other requests have their own slots
+	releaseOther, _ := take(false)
+	require.NotNil(t, releaseOther)
+	releaseStream, _ := take(true)
+	require.NotNil(t, releaseStream)
+
+	release, w := take(true)
+	assert.Nil(t, release)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Contains(t, w.Body.String(), "streamingConcurrencyLimit")
+
+	release, w = take(false)
+	assert.Nil(t, release)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Contains(t, w.Body.String(), "increasing concurrencyLimit")
+
+	// with the queue overflow a request waits for a slot
+	process.config.ConcurrencyOverflow = config.ConcurrencyOverflowQueue
+	got := make(chan func())
+	go func() {
+		release, _ := take(true)
+		got <- release
+	}()
+	select {
+	case <-got:
+		t.Fatal("request got a slot while all were taken")
+	case <-time.After(50 * time.Millisecond):
+	}
+	releaseStream()
+	select {
+	case release := <-got:
+		require.NotNil(t, release)
+		release()
+	case <-time.After(time.Second):
+		t.Fatal("request did not get the released slot")
+	}
+
+	// it gives up when its client does
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, ok := process.takeSlot(httptest.NewRecorder(), httptest.NewRequest("POST", "/", nil).WithContext(ctx), false)
+	assert.False(t, ok)
+	releaseOther()
+}
+
+func TestProcess_Draft(t *testing.T) {
+	process := NewProcess("draft_test", 5, getTestSimpleResponderConfig("main"), debugLogger, debugLogger)
+	draft := NewProcess("draft_test/draft", 5, getTestSimpleResponderConfig("draft"), debugLogger, debugLogger)
+	process.withDraft(draft)
+	defer process.Stop()
+
+	// the draft is ready before the model
+	require.NoError(t, process.makeReady())
+	assert.Equal(t, StateReady, draft.CurrentState())
+
+	w := httptest.NewRecorder()
+	process.ProxyRequest(w, httptest.NewRequest("GET", "/test", nil))
+	assert.Equal(t, "main", w.Body.String())
+
+	// and stopped with it
+	process.StopImmediately()
+	assert.Equal(t, StateStopped, process.CurrentState())
+	assert.Equal(t, StateStopped, draft.CurrentState())
+
+	// a draft that exits on its own takes the model down with it
+	require.NoError(t, process.makeReady())
+	require.NoError(t, draft.cmd.Process.Kill())
+	assert.Eventually(t, func() bool {
+		return process.CurrentState() == StateStopped
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
 func TestProcess_StopImmediately(t *testing.T) {
 	expectedMessage := "test_stop_immediate"
 	config := getTestSimpleResponderConfig(expectedMessage)
@@ -614,6 +894,44 @@ func TestProcess_SleepAndWakeBasic(t *testing.T) {
 	assert.Equal(t, StateReady, process.CurrentState())
 }
 
+// TestProcess_SleepAfterThenTTL tests that an idle model sleeps after
+// sleepAfter and is stopped once the ttl is reached
+func TestProcess_SleepAfterThenTTL(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping sleepAfter TTL test")
+	}
+
+	cfg := getTestSimpleResponderConfig("sleep_after")
+	cfg.SleepMode = config.SleepModeEnable
+	cfg.SleepEndpoints = []config.HTTPEndpoint{
+		{Endpoint: "/sleep", Method: "POST", Timeout: 5},
+	}
+	cfg.WakeEndpoints = []config.HTTPEndpoint{
+		{Endpoint: "/wake_up", Method: "POST", Timeout: 5},
+	}
+	cfg.SleepAfter = 1
+	cfg.UnloadAfter = 4
+
+	process := NewProcess("sleep-after", 5, cfg, debugLogger, debugLogger)
+	defer process.Stop()
+
+	w := httptest.NewRecorder()
+	process.ProxyRequest(w, httptest.NewRequest("GET", "/test", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, StateReady, process.CurrentState())
+
+	assert.Eventually(t, func() bool { return process.CurrentState() == StateAsleep }, 4*time.Second, 100*time.Millisecond)
+
+	// a request wakes it up and restarts the idle timers
+	w = httptest.NewRecorder()
+	process.ProxyRequest(w, httptest.NewRequest("GET", "/test", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, StateReady, process.CurrentState())
+
+	assert.Eventually(t, func() bool { return process.CurrentState() == StateAsleep }, 4*time.Second, 100*time.Millisecond)
+	assert.Eventually(t, func() bool { return process.CurrentState() == StateStopped }, 6*time.Second, 100*time.Millisecond)
+}
+
 // TestProcess_MultiStepWakeSequence tests multi-step wake sequences like vLLM level 2
 func TestProcess_MultiStepWakeSequence(t *testing.T) {
 	expectedMessage := "multi_step_wake"
@@ -709,3 +1027,32 @@ func TestProcess_WakeFailureFallsBackToStart(t *testing.T) {
 	// Process should be ready
 	assert.Equal(t, StateReady, process.CurrentState())
 }
+
+func TestProcess_ReportsUnexpectedExit(t *testing.T) {
+	config := getTestSimpleResponderConfig("test_unexpected_exit")
+	process := NewProcess("unexpected_exit", 2, config, debugLogger, debugLogger)
+	defer process.Stop()
+
+	failures := make(chan error, 1)
+	process.setHooks(&processHooks{onFailure: func(err error) { failures <- err }})
+
+	require.NoError(t, process.start())
+	require.NoError(t, process.cmd.Process.Kill())
+
+	select {
+	case err := <-failures:
+		assert.ErrorContains(t, err, "process exited unexpectedly in state ready")
+	case <-time.After(5 * time.Second):
+		t.Fatal("onFailure was not called")
+	}
+	assert.Equal(t, StateStopped, process.CurrentState())
+
+	// stopping on purpose is not a failure
+	require.NoError(t, process.start())
+	process.StopImmediately()
+	select {
+	case err := <-failures:
+		t.Fatalf("unexpected failure: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
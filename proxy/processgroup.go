@@ -2,9 +2,12 @@ package proxy
 
 import (
 	"fmt"
+	"iter"
 	"net/http"
 	"slices"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/napmany/llmsnap/proxy/config"
 )
@@ -17,6 +20,7 @@ type ProcessGroup struct {
 	swap       bool
 	exclusive  bool
 	persistent bool
+	onEvict    config.EvictMode
 
 	proxyLogger    *LogMonitor
 	upstreamLogger *LogMonitor
@@ -24,6 +28,22 @@ type ProcessGroup struct {
 	// map of current processes
 	processes       map[string]*Process
 	lastUsedProcess string
+
+	// instances after the first of members with instances, by model ID
+	replicas map[string][]*Process
+
+	// requests balanced over the instances of each member with instances
+	turns map[string]*atomic.Uint64
+
+	// recent prompt prefixes served by each instance, by process ID
+	prefixes *prefixTracker
+
+	// nil unless fair sharing or a swap window is enabled for a swap group
+	fair *fairShare
+
+	// members of a swap group loaded together may weigh this much, 0 runs
+	// one member at a time
+	weightBudget int
 }
 
 func NewProcessGroup(id string, config config.Config, proxyLogger *LogMonitor, upstreamLogger *LogMonitor) *ProcessGroup {
@@ -38,17 +58,48 @@ func NewProcessGroup(id string, config config.Config, proxyLogger *LogMonitor, u
 		swap:           groupConfig.Swap,
 		exclusive:      groupConfig.Exclusive,
 		persistent:     groupConfig.Persistent,
+		onEvict:        groupConfig.OnEvict,
 		proxyLogger:    proxyLogger,
 		upstreamLogger: upstreamLogger,
 		processes:      make(map[string]*Process),
+		replicas:       make(map[string][]*Process),
+		turns:          make(map[string]*atomic.Uint64),
+		prefixes:       newPrefixTracker(),
+		weightBudget:   groupConfig.WeightBudget,
 	}
 
-	// Create a Process for each member in the group
+	if groupConfig.Swap && (groupConfig.FairShare > 0 || groupConfig.SwapWindow > 0) {
+		window := time.Duration(groupConfig.SwapWindow) * time.Millisecond
+		pg.fair = newFairShare(groupConfig.FairShare, window, groupConfig.Members)
+	}
+
+	// all members of the group share a connection pool to their upstreams
+	transport := newUpstreamTransport(config.Transport)
+
+	// Create a Process for each member in the group, and for each instance
+	// after the first one named like model#2
 	for _, modelID := range groupConfig.Members {
 		modelConfig, modelID, _ := pg.config.FindConfig(modelID)
-		processLogger := NewLogMonitorWriter(upstreamLogger)
-		process := NewProcess(modelID, pg.config.HealthCheckTimeout, modelConfig, processLogger, pg.proxyLogger)
-		pg.processes[modelID] = process
+		for i, instanceConfig := range modelConfig.InstanceConfigs() {
+			processID := instanceProcessID(modelID, i)
+			processLogger := NewLogMonitorWriter(upstreamLogger)
+			process := NewProcess(processID, pg.config.HealthCheckTimeout, instanceConfig, processLogger, pg.proxyLogger)
+			if instanceConfig.Draft.Enabled() {
+				process.withDraft(NewProcess(processID+"/draft", pg.config.HealthCheckTimeout, instanceConfig.DraftModel(), processLogger, pg.proxyLogger))
+			}
+			if process.reverseProxy != nil {
+				process.reverseProxy.Transport = withUpstreamProxy(transport, modelConfig.UpstreamProxy)
+				applyResponseHeaders(process.reverseProxy, config.ResponseHeaders)
+			}
+			if i == 0 {
+				pg.processes[modelID] = process
+			} else {
+				pg.replicas[modelID] = append(pg.replicas[modelID], process)
+			}
+		}
+		if len(pg.replicas[modelID]) > 0 {
+			pg.turns[modelID] = &atomic.Uint64{}
+		}
 	}
 
 	return pg
@@ -60,19 +111,61 @@ func (pg *ProcessGroup) ProxyRequest(modelID string, writer http.ResponseWriter,
 		return fmt.Errorf("model %s not part of group %s", modelID, pg.id)
 	}
 
+	// a socket would hold its turn for as long as it is open
+	if pg.fair != nil && !isWebSocketUpgrade(request) {
+		pg.proxyFairShare(modelID, writer, request)
+		return nil
+	}
+
 	if pg.swap {
-		pg.Lock()
-		if pg.lastUsedProcess != modelID {
+		// the request gives up waiting when its context is done
+		giveUp := func() error {
+			if queueTimedOut(request) {
+				rejectQueueTimeout(writer, modelID)
+			}
+			return nil
+		}
+		if !pg.lockRequest(request) {
+			return giveUp()
+		}
+		if pg.weightBudget > 0 {
+			if !pg.resident(modelID) {
+				if !pg.makeRoom(modelID, request) {
+					pg.Unlock()
+					return giveUp()
+				}
+
+				// like a swap, the first request is handled under the lock
+				pg.serve(modelID, writer, request)
+				pg.lastUsedProcess = modelID
+				pg.Unlock()
+				return nil
+			}
+			pg.Unlock()
+		} else if pg.lastUsedProcess != modelID && !slices.Contains(pg.config.Requirements(pg.lastUsedProcess), modelID) {
 
-			// is there something already running?
+			// is there something already running? the members it requires
+			// go with it unless modelID requires them too
 			if pg.lastUsedProcess != "" {
-				lastProcess := pg.processes[pg.lastUsedProcess]
-				lastProcess.MakeIdle()
+				keep := pg.config.Requirements(modelID)
+				unit := append([]string{pg.lastUsedProcess}, pg.config.Requirements(pg.lastUsedProcess)...)
+				for _, memberID := range unit {
+					member, found := pg.processes[memberID]
+					if !found || memberID == modelID || slices.Contains(keep, memberID) {
+						continue
+					}
+					if !member.waitInFlight(request.Context()) {
+						pg.Unlock()
+						return giveUp()
+					}
+					pg.evict(member)
+					pg.forgetPrefixes(memberID)
+				}
 			}
 
 			// wait for the request to the new model to be fully handled
 			// and prevent race conditions see issue #277
-			pg.processes[modelID].ProxyRequest(writer, request)
+			pg.serve(modelID, writer, request)
 			pg.lastUsedProcess = modelID
 
 			// short circuit and exit
@@ -82,10 +175,274 @@ func (pg *ProcessGroup) ProxyRequest(modelID string, writer http.ResponseWriter,
 		pg.Unlock()
 	}
 
-	pg.processes[modelID].ProxyRequest(writer, request)
+	pg.serve(modelID, writer, request)
 	return nil
 }
 
+// serve sends request to the instance of modelID that balance picks and
+// remembers the prompt prefixes the instance served
+func (pg *ProcessGroup) serve(modelID string, writer http.ResponseWriter, request *http.Request) {
+	hashes, _ := request.Context().Value(proxyCtxKey("prefixes")).([]string)
+	instance := pg.balance(modelID, hashes)
+	if len(hashes) > 0 {
+		pg.proxyLogger.Debugf("<%s> prompt prefix cache match %d/%d", instance.ID, pg.prefixMatch(instance, hashes), len(hashes))
+		defer pg.prefixes.record(instance.ID, hashes)
+	}
+	instance.ProxyRequest(writer, request)
+}
+
+// resident reports if an instance of modelID is loaded and awake, it takes
+// its weight of the budget
+func (pg *ProcessGroup) resident(modelID string) bool {
+	for _, instance := range pg.instancesOf(modelID) {
+		if state := instance.CurrentState(); isLoaded(state) && state != StateAsleep {
+			return true
+		}
+	}
+	return false
+}
+
+// weight returns how much of the group's weight budget modelID takes
+func (pg *ProcessGroup) weight(modelID string) int {
+	return pg.config.Groups[pg.id].MemberWeight(pg.config.Models[modelID])
+}
+
+// makeRoom idles the members roomFor picks. It returns false when the
+// request gave up waiting for the in-flight requests of a member.
+func (pg *ProcessGroup) makeRoom(modelID string, request *http.Request) bool {
+	for _, member := range pg.roomFor(modelID) {
+		pg.proxyLogger.Debugf("<%s> idling %s to fit in the weight budget of group %s", modelID, member.ID, pg.id)
+		if !member.waitInFlight(request.Context()) {
+			return false
+		}
+		pg.evict(member)
+		pg.forgetPrefixes(member.ID)
+	}
+	return true
+}
+
+// roomFor returns the least recently used resident members that are idled
+// so modelID and the members it requires fit in the weight budget next to
+// the resident ones
+func (pg *ProcessGroup) roomFor(modelID string) []*Process {
+	keep := append([]string{modelID}, pg.config.Requirements(modelID)...)
+
+	needed, used := 0, 0
+	var others []*Process
+	for memberID, member := range pg.processes {
+		switch {
+		case slices.Contains(keep, memberID):
+			if !pg.resident(memberID) {
+				needed += pg.weight(memberID)
+			}
+		case pg.resident(memberID):
+			used += pg.weight(memberID)
+			others = append(others, member)
+		}
+	}
+
+	slices.SortFunc(others, func(a, b *Process) int {
+		return a.getLastRequestHandled().Compare(b.getLastRequestHandled())
+	})
+	var idled []*Process
+	for _, member := range others {
+		if used+needed <= pg.weightBudget {
+			break
+		}
+		idled = append(idled, member)
+		used -= pg.weight(member.ID)
+	}
+	return idled
+}
+
+// instancesOf returns the processes of modelID, the first instance first
+func (pg *ProcessGroup) instancesOf(modelID string) []*Process {
+	process, found := pg.processes[modelID]
+	if !found {
+		return nil
+	}
+	return append([]*Process{process}, pg.replicas[modelID]...)
+}
+
+// instanceProcessID names the process of an instance, the first one is
+// named like the model and the ones after it model#2, model#3, ...
+func instanceProcessID(modelID string, i int) string {
+	if i == 0 {
+		return modelID
+	}
+	return fmt.Sprintf("%s#%d", modelID, i+1)
+}
+
+// replace swaps the instance with the ID of process for it, used when a
+// config reload keeps a process running. It returns false when no instance
+// has the ID.
+func (pg *ProcessGroup) replace(process *Process) bool {
+	for modelID, current := range pg.processes {
+		found := current.ID == process.ID
+		if found {
+			pg.processes[modelID] = process
+		}
+		for i, replica := range pg.replicas[modelID] {
+			if replica.ID == process.ID {
+				pg.replicas[modelID][i] = process
+				found = true
+			}
+		}
+		if !found {
+			continue
+		}
+		// a swap group swaps out the running member for the next one
+		if state := process.CurrentState(); state != StateStopped && state != StateFailed {
+			pg.lastUsedProcess = modelID
+		}
+		return true
+	}
+	return false
+}
+
+// instances iterates over the processes of all members and their instances
+func (pg *ProcessGroup) instances() iter.Seq2[string, *Process] {
+	return func(yield func(string, *Process) bool) {
+		for modelID := range pg.processes {
+			for _, process := range pg.instancesOf(modelID) {
+				if !yield(modelID, process) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// balance returns the instance of modelID a request is sent to. Only ready
+// instances are picked while the stopped and sleeping ones are started in the
+// background. Without a ready instance the first one takes the request and
+// starts on demand. The ready instances that recently served the longest
+// prefix of the prompt hashes are preferred, they likely still hold it in
+// their KV cache. Between them config.ModelConfig.LoadBalance decides.
+func (pg *ProcessGroup) balance(modelID string, hashes []string) *Process {
+	instances := pg.instancesOf(modelID)
+	if len(instances) == 1 {
+		return instances[0]
+	}
+
+	var ready, idle []*Process
+	for _, instance := range instances {
+		switch instance.CurrentState() {
+		case StateReady:
+			ready = append(ready, instance)
+		case StateStopped, StateAsleep:
+			idle = append(idle, instance)
+		}
+	}
+	for _, instance := range idle {
+		if len(ready) == 0 && instance == instances[0] {
+			continue
+		}
+		go func() {
+			if err := instance.makeReady(); err != nil {
+				pg.proxyLogger.Warnf("<%s> unable to start instance: %v", instance.ID, err)
+			}
+		}()
+	}
+	if len(ready) == 0 {
+		return instances[0]
+	}
+
+	if len(hashes) > 0 && len(ready) > 1 {
+		var longest []*Process
+		best := 0
+		for _, instance := range ready {
+			switch matched := pg.prefixMatch(instance, hashes); {
+			case matched > best:
+				longest, best = []*Process{instance}, matched
+			case matched == best && best > 0:
+				longest = append(longest, instance)
+			}
+		}
+		if best > 0 {
+			ready = longest
+		}
+	}
+
+	turn := int(pg.turns[modelID].Add(1) - 1)
+	picked := ready[turn%len(ready)]
+	if instances[0].config.LoadBalance == config.LoadBalanceRoundRobin {
+		return picked
+	}
+
+	// least connections, ties are taken in turn
+	for i := range ready {
+		instance := ready[(turn+i)%len(ready)]
+		if instance.inFlightRequestsCount.Load() < picked.inFlightRequestsCount.Load() {
+			picked = instance
+		}
+	}
+	return picked
+}
+
+// lockRequest locks pg for request. It gives up and returns false when the
+// request's context is done first, e.g. when it timed out in the queue.
+func (pg *ProcessGroup) lockRequest(request *http.Request) bool {
+	done := request.Context().Done()
+	if done == nil {
+		pg.Lock()
+		return true
+	}
+	if pg.TryLock() {
+		return true
+	}
+
+	locked := make(chan struct{})
+	go func() {
+		pg.Lock()
+		close(locked)
+	}()
+
+	select {
+	case <-locked:
+		return true
+	case <-done:
+		// hand the lock back once it is acquired
+		go func() {
+			<-locked
+			pg.Unlock()
+		}()
+		return false
+	}
+}
+
+// PrefixMatch returns how many leading prompt prefix hashes an instance of
+// the member has recently served and likely still holds in its KV cache, the
+// most of any of its instances
+func (pg *ProcessGroup) PrefixMatch(modelID string, hashes []string) int {
+	if !pg.HasMember(modelID) {
+		return 0
+	}
+	matched := 0
+	for _, instance := range pg.instancesOf(modelID) {
+		matched = max(matched, pg.prefixMatch(instance, hashes))
+	}
+	return matched
+}
+
+// prefixMatch returns how many leading prompt prefix hashes instance has
+// recently served
+func (pg *ProcessGroup) prefixMatch(instance *Process, hashes []string) int {
+	// a restarted process starts with an empty cache
+	if instance.CurrentState() != StateReady {
+		pg.prefixes.forget(instance.ID)
+		return 0
+	}
+	return pg.prefixes.match(instance.ID, hashes)
+}
+
+// forgetPrefixes drops the prompt prefixes of every instance of modelID
+func (pg *ProcessGroup) forgetPrefixes(modelID string) {
+	for _, instance := range pg.instancesOf(modelID) {
+		pg.prefixes.forget(instance.ID)
+	}
+}
+
 func (pg *ProcessGroup) HasMember(modelName string) bool {
 	return slices.Contains(pg.config.Groups[pg.id].Members, modelName)
 }
@@ -109,14 +466,44 @@ func (pg *ProcessGroup) StopProcess(modelID string, strategy StopStrategy) error
 	if pg.lastUsedProcess == modelID {
 		pg.lastUsedProcess = ""
 	}
+	pg.forgetPrefixes(modelID)
 	pg.Unlock()
 
-	switch strategy {
-	case StopImmediately:
-		process.StopImmediately()
-	default:
-		process.Stop()
+	var wg sync.WaitGroup
+	for _, instance := range pg.instancesOf(process.ID) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			switch strategy {
+			case StopImmediately:
+				instance.StopImmediately()
+			default:
+				instance.Stop()
+			}
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+// EvictProcess idles a member to make room for another model, like a swap
+// does
+func (pg *ProcessGroup) EvictProcess(modelID string) error {
+	pg.Lock()
+
+	process, exists := pg.processes[modelID]
+	if !exists {
+		pg.Unlock()
+		return fmt.Errorf("process not found for %s", modelID)
 	}
+
+	if pg.lastUsedProcess == modelID {
+		pg.lastUsedProcess = ""
+	}
+	pg.forgetPrefixes(modelID)
+	pg.Unlock()
+
+	pg.evict(process)
 	return nil
 }
 
@@ -136,7 +523,15 @@ func (pg *ProcessGroup) SleepProcess(modelID string) error {
 
 	pg.Unlock()
 
-	process.Sleep()
+	var wg sync.WaitGroup
+	for _, instance := range pg.instancesOf(process.ID) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			instance.Sleep()
+		}()
+	}
+	wg.Wait()
 	return nil
 }
 
@@ -150,7 +545,7 @@ func (pg *ProcessGroup) StopProcesses(strategy StopStrategy) {
 
 	// stop Processes in parallel
 	var wg sync.WaitGroup
-	for _, process := range pg.processes {
+	for _, process := range pg.instances() {
 		wg.Add(1)
 		go func(process *Process) {
 			defer wg.Done()
@@ -165,7 +560,8 @@ func (pg *ProcessGroup) StopProcesses(strategy StopStrategy) {
 	wg.Wait()
 }
 
-func (pg *ProcessGroup) MakeIdleProcesses() {
+// MakeIdleProcesses idles all members of the group except the ones in keep
+func (pg *ProcessGroup) MakeIdleProcesses(keep ...string) {
 	pg.Lock()
 	defer pg.Unlock()
 
@@ -174,19 +570,44 @@ func (pg *ProcessGroup) MakeIdleProcesses() {
 	}
 
 	var wg sync.WaitGroup
-	for _, process := range pg.processes {
+	for modelID, process := range pg.processes {
+		if slices.Contains(keep, modelID) {
+			continue
+		}
 		wg.Add(1)
 		go func(process *Process) {
 			defer wg.Done()
-			process.MakeIdle()
+			pg.evict(process)
 		}(process)
 	}
 	wg.Wait()
 }
 
+// evict idles a member and its other instances to make room for another
+// model, see config.GroupConfig.OnEvict
+func (pg *ProcessGroup) evict(process *Process) {
+	var wg sync.WaitGroup
+	for _, instance := range pg.instancesOf(process.ID) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pg.idle(instance)
+		}()
+	}
+	wg.Wait()
+}
+
+func (pg *ProcessGroup) idle(process *Process) {
+	if pg.onEvict == config.EvictStop {
+		process.Stop()
+		return
+	}
+	process.MakeIdle()
+}
+
 func (pg *ProcessGroup) Shutdown() {
 	var wg sync.WaitGroup
-	for _, process := range pg.processes {
+	for _, process := range pg.instances() {
 		wg.Add(1)
 		go func(process *Process) {
 			defer wg.Done()
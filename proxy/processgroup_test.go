@@ -2,13 +2,17 @@ package proxy
 
 import (
 	"bytes"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/napmany/llmsnap/proxy/config"
 	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
 )
 
 var processGroupTestConfig = config.AddDefaultGroupToConfig(config.Config{
@@ -237,6 +241,38 @@ func TestProcessGroup_MakeIdleProcesses(t *testing.T) {
 		assert.Equal(t, StateStopped, pg.processes["mixed_nosleep"].CurrentState())
 	})
 
+	t.Run("onEvict stop stops sleep-enabled processes", func(t *testing.T) {
+		sleepCfg := getTestSimpleResponderConfig("evict_sleep")
+		sleepCfg.SleepMode = config.SleepModeEnable
+		sleepCfg.SleepEndpoints = []config.HTTPEndpoint{
+			{Endpoint: "/sleep", Method: "POST", Timeout: 5},
+		}
+		sleepCfg.WakeEndpoints = []config.HTTPEndpoint{
+			{Endpoint: "/wake_up", Method: "POST", Timeout: 5},
+		}
+
+		testConfig := config.AddDefaultGroupToConfig(config.Config{
+			HealthCheckTimeout: 15,
+			Models: map[string]config.ModelConfig{
+				"evict_sleep": sleepCfg,
+			},
+			Groups: map[string]config.GroupConfig{
+				"G1": {
+					Swap:    true,
+					OnEvict: config.EvictStop,
+					Members: []string{"evict_sleep"},
+				},
+			},
+		})
+
+		pg := NewProcessGroup("G1", testConfig, testLogger, testLogger)
+		defer pg.StopProcesses(StopImmediately)
+
+		assert.NoError(t, pg.processes["evict_sleep"].start())
+		pg.MakeIdleProcesses()
+		assert.Equal(t, StateStopped, pg.processes["evict_sleep"].CurrentState())
+	})
+
 	t.Run("empty group", func(t *testing.T) {
 		testConfig := config.AddDefaultGroupToConfig(config.Config{
 			HealthCheckTimeout: 15,
@@ -254,3 +290,337 @@ func TestProcessGroup_MakeIdleProcesses(t *testing.T) {
 		pg.MakeIdleProcesses()
 	})
 }
+
+func TestProcessGroup_FairShareTurns(t *testing.T) {
+	cfg := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]config.ModelConfig{
+			"model1": getTestSimpleResponderConfig("model1"),
+			"model2": getTestSimpleResponderConfig("model2"),
+		},
+		Groups: map[string]config.GroupConfig{
+			"G1": {
+				Swap:      true,
+				FairShare: 2,
+				Members:   []string{"model1", "model2"},
+			},
+		},
+	})
+
+	pg := NewProcessGroup("G1", cfg, testLogger, testLogger)
+	defer pg.StopProcesses(StopWaitForInflightRequest)
+
+	isClosed := func(ch chan struct{}) bool {
+		select {
+		case <-ch:
+			return true
+		default:
+			return false
+		}
+	}
+
+	enter := func(modelID string) chan struct{} {
+		pg.Lock()
+		defer pg.Unlock()
+		return pg.fairEnter(modelID)
+	}
+
+	// nobody else is waiting, model1 may take any number of requests
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, enter("model1"))
+	}
+
+	wait2a := enter("model2")
+	wait2b := enter("model2")
+	assert.NotNil(t, wait2a)
+	assert.False(t, isClosed(wait2a))
+
+	// model1 used up its turn while model2 is waiting
+	wait1 := enter("model1")
+	assert.NotNil(t, wait1)
+
+	for i := 0; i < 3; i++ {
+		pg.fairDone()
+	}
+	assert.True(t, isClosed(wait2a))
+	assert.True(t, isClosed(wait2b))
+	assert.False(t, isClosed(wait1))
+	assert.Equal(t, "model2", pg.lastUsedProcess)
+
+	// model2 has served its quantum, model1 is next
+	wait2c := enter("model2")
+	assert.NotNil(t, wait2c)
+
+	pg.fairDone()
+	pg.fairDone()
+	assert.True(t, isClosed(wait1))
+	assert.False(t, isClosed(wait2c))
+	assert.Equal(t, "model1", pg.lastUsedProcess)
+
+	pg.fairDone()
+	assert.True(t, isClosed(wait2c))
+	pg.fairDone()
+}
+
+func TestProcessGroup_SwapWindow(t *testing.T) {
+	cfg := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]config.ModelConfig{
+			"model1": getTestSimpleResponderConfig("model1"),
+			"model2": getTestSimpleResponderConfig("model2"),
+		},
+		Groups: map[string]config.GroupConfig{
+			"G1": {
+				Swap:       true,
+				SwapWindow: 50,
+				Members:    []string{"model1", "model2"},
+			},
+		},
+	})
+
+	pg := NewProcessGroup("G1", cfg, testLogger, testLogger)
+	defer pg.StopProcesses(StopWaitForInflightRequest)
+
+	isClosed := func(ch chan struct{}) bool {
+		select {
+		case <-ch:
+			return true
+		default:
+			return false
+		}
+	}
+
+	enter := func(modelID string) chan struct{} {
+		pg.Lock()
+		defer pg.Unlock()
+		return pg.fairEnter(modelID)
+	}
+
+	// the first load waits for the window, model2 has the most requests
+	wait1 := enter("model1")
+	wait2a := enter("model2")
+	wait2b := enter("model2")
+	assert.NotNil(t, wait1)
+	assert.False(t, isClosed(wait2a))
+
+	assert.Eventually(t, func() bool {
+		return isClosed(wait2a) && isClosed(wait2b)
+	}, time.Second, 5*time.Millisecond)
+	assert.False(t, isClosed(wait1))
+
+	// model1 is waiting so model2 does not take new requests
+	wait2c := enter("model2")
+	assert.NotNil(t, wait2c)
+
+	pg.fairDone()
+	pg.fairDone()
+	assert.Eventually(t, func() bool { return isClosed(wait1) }, time.Second, 5*time.Millisecond)
+	assert.False(t, isClosed(wait2c))
+
+	pg.Lock()
+	assert.Equal(t, "model1", pg.lastUsedProcess)
+	pg.Unlock()
+
+	pg.fairDone()
+	assert.Eventually(t, func() bool { return isClosed(wait2c) }, time.Second, 5*time.Millisecond)
+	pg.fairDone()
+}
+
+func TestProcessGroup_FairShareProxyRequest(t *testing.T) {
+	cfg := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]config.ModelConfig{
+			"model1": getTestSimpleResponderConfigPort("model1", 9833),
+			"model2": getTestSimpleResponderConfigPort("model2", 9833),
+		},
+		Groups: map[string]config.GroupConfig{
+			"G1": {
+				Swap:      true,
+				FairShare: 1,
+				Members:   []string{"model1", "model2"},
+			},
+		},
+	})
+
+	pg := NewProcessGroup("G1", cfg, testLogger, testLogger)
+	defer pg.StopProcesses(StopWaitForInflightRequest)
+
+	var wg sync.WaitGroup
+	for _, modelName := range []string{"model1", "model2", "model1", "model2"} {
+		wg.Add(1)
+		go func(modelName string) {
+			defer wg.Done()
+			req := httptest.NewRequest("POST", "/v1/chat/completions", nil)
+			w := httptest.NewRecorder()
+			assert.NoError(t, pg.ProxyRequest(modelName, w, req))
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Contains(t, w.Body.String(), modelName)
+		}(modelName)
+	}
+	wg.Wait()
+	assert.Equal(t, 0, pg.fair.inflight)
+}
+
+func TestProcessGroup_SwapRequires(t *testing.T) {
+	chat := getTestSimpleResponderConfig("chat")
+	chat.Requires = []string{"embed"}
+	testConfig := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]config.ModelConfig{
+			"chat":  chat,
+			"embed": getTestSimpleResponderConfig("embed"),
+			"other": getTestSimpleResponderConfig("other"),
+		},
+		Groups: map[string]config.GroupConfig{
+			"G1": {
+				Swap:    true,
+				Members: []string{"chat", "embed", "other"},
+			},
+		},
+	})
+
+	pg := NewProcessGroup("G1", testConfig, testLogger, testLogger)
+	defer pg.StopProcesses(StopImmediately)
+
+	request := func(modelID string) {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", nil)
+		w := httptest.NewRecorder()
+		assert.NoError(t, pg.ProxyRequest(modelID, w, req))
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+
+	// the required model is loaded first, then swapping to chat keeps it
+	request("embed")
+	request("chat")
+	assert.Equal(t, StateReady, pg.processes["embed"].CurrentState())
+	assert.Equal(t, StateReady, pg.processes["chat"].CurrentState())
+
+	// requests for the required model do not swap chat out
+	request("embed")
+	assert.Equal(t, "chat", pg.lastUsedProcess)
+	assert.Equal(t, StateReady, pg.processes["chat"].CurrentState())
+
+	// chat and what it requires are swapped out together
+	request("other")
+	assert.Equal(t, StateStopped, pg.processes["chat"].CurrentState())
+	assert.Equal(t, StateStopped, pg.processes["embed"].CurrentState())
+	assert.Equal(t, StateReady, pg.processes["other"].CurrentState())
+}
+
+func TestProcessGroup_WeightBudget(t *testing.T) {
+	chat := getTestSimpleResponderConfig("chat")
+	chat.Weight = 8
+	coder := getTestSimpleResponderConfig("coder")
+	coder.Weight = 8
+	embed := getTestSimpleResponderConfig("embed")
+	embed.Weight = 1
+	testConfig := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]config.ModelConfig{
+			"chat":  chat,
+			"coder": coder,
+			"embed": embed,
+			"big":   getTestSimpleResponderConfig("big"),
+		},
+		Groups: map[string]config.GroupConfig{
+			"G1": {
+				Swap:         true,
+				Members:      []string{"chat", "coder", "embed", "big"},
+				WeightBudget: 10,
+			},
+		},
+	})
+
+	pg := NewProcessGroup("G1", testConfig, testLogger, testLogger)
+	defer pg.StopProcesses(StopImmediately)
+
+	request := func(modelID string) {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", nil)
+		w := httptest.NewRecorder()
+		assert.NoError(t, pg.ProxyRequest(modelID, w, req))
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+	states := func() map[string]ProcessState {
+		states := map[string]ProcessState{}
+		for modelID, process := range pg.processes {
+			states[modelID] = process.CurrentState()
+		}
+		return states
+	}
+
+	// the embeddings model fits next to the chat model
+	request("chat")
+	request("embed")
+	assert.Equal(t, map[string]ProcessState{"chat": StateReady, "coder": StateStopped, "embed": StateReady, "big": StateStopped}, states())
+
+	// the least recently used model makes room
+	request("coder")
+	assert.Equal(t, map[string]ProcessState{"chat": StateStopped, "coder": StateReady, "embed": StateReady, "big": StateStopped}, states())
+
+	// a model without a weight takes the whole budget
+	request("big")
+	assert.Equal(t, map[string]ProcessState{"chat": StateStopped, "coder": StateStopped, "embed": StateStopped, "big": StateReady}, states())
+	request("embed")
+	assert.Equal(t, map[string]ProcessState{"chat": StateStopped, "coder": StateStopped, "embed": StateReady, "big": StateStopped}, states())
+}
+
+func TestProcessGroup_Instances(t *testing.T) {
+	startPort := getTestPort()
+	getTestPort()
+	getTestPort()
+	conf, err := config.LoadConfigFromReader(strings.NewReader(fmt.Sprintf(`
+logLevel: error
+startPort: %d
+models:
+  model1:
+    cmd: %s -port ${PORT} -silent -respond instance${INSTANCE}
+    instances: 3
+    loadBalance: roundRobin
+`, startPort, getSimpleResponderPath())))
+	assert.NoError(t, err)
+
+	pg := NewProcessGroup(config.DEFAULT_GROUP_ID, conf, testLogger, testLogger)
+	defer pg.StopProcesses(StopWaitForInflightRequest)
+	instances := pg.instancesOf("model1")
+	if !assert.Len(t, instances, 3) {
+		return
+	}
+	assert.Equal(t, "model1#3", instances[2].ID)
+
+	doRequest := func() string {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model":"model1"}`))
+		w := httptest.NewRecorder()
+		assert.NoError(t, pg.ProxyRequest("model1", w, req))
+		assert.Equal(t, http.StatusOK, w.Code)
+		return gjson.Get(w.Body.String(), "responseMessage").String()
+	}
+
+	// the first instance serves while the others start in the background
+	assert.Equal(t, "instance0", doRequest())
+	assert.Eventually(t, func() bool {
+		for _, instance := range instances {
+			if instance.CurrentState() != StateReady {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, 50*time.Millisecond)
+
+	served := map[string]int{}
+	for range 6 {
+		served[doRequest()]++
+	}
+	assert.Equal(t, map[string]int{"instance0": 2, "instance1": 2, "instance2": 2}, served)
+
+	// a stopped instance is left out and started again in the background
+	instances[1].StopImmediately()
+	assert.NotEqual(t, "instance1", doRequest())
+	assert.Eventually(t, func() bool {
+		return instances[1].CurrentState() == StateReady
+	}, 5*time.Second, 50*time.Millisecond)
+
+	assert.NoError(t, pg.StopProcess("model1", StopImmediately))
+	for _, instance := range instances {
+		assert.Equal(t, StateStopped, instance.CurrentState())
+	}
+}
@@ -4,15 +4,18 @@ import (
 	"bytes"
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
 	"net/http"
 	"os"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -24,6 +27,9 @@ import (
 
 const (
 	PROFILE_SPLIT_CHAR = ":"
+
+	// gin context key holding the API key a request was authorized with
+	apiKeyContextKey = "apiKey"
 )
 
 type proxyCtxKey string
@@ -54,32 +60,131 @@ type ProxyManager struct {
 
 	// peer proxy see: #296, #433
 	peerProxy *PeerProxy
+
+	// nil when the response cache is disabled
+	responseCache *responseCache
+
+	// coalesce embeddings requests of models with batch, by model ID
+	batchers map[string]*embeddingBatcher
+
+	// nil when no scrub detectors are configured
+	scrubber *scrubber
+
+	// nil when datasets.dir is not set
+	datasets *datasetWriter
+
+	// model IDs listed by backends of models with discoverModels
+	servedModels *servedModels
+
+	// live GPU readings for VRAM admission control
+	readGPUs gpuReader
+
+	// GPU memory used by each upstream process, see /running
+	readGPUProcesses gpuProcessReader
+
+	// places models that use ${GPU} or placement on devices
+	gpus *gpuAllocator
+
+	// nil when the scheduler is disabled
+	scheduler *requestScheduler
+
+	// compiled scripts of the models with a script, by model ID
+	scripts map[string]*luaScript
+
+	// the wasmFilters of the models that have them, by model ID
+	wasmFilters map[string]wasmFilterChain
+
+	// nil when clientLimits is disabled
+	clientLimiter *clientLimiter
+
+	// compiled clients rules
+	clientMatchers []config.ClientMatcher
+
+	// requests and tokens counted towards tenant quotas
+	tenantQuotas *tenantQuotas
+
+	// requests for local models, for /api/queue
+	queue *requestQueue
+
+	// nil when no thermal limits are configured
+	thermal *thermalThrottle
+
+	// power source readings for groups with onBattery
+	readPower powerReader
+
+	// nil when no group has onBattery
+	battery *batteryMonitor
+
+	// nil when no schedules are configured
+	schedules *warmSchedules
+
+	// nil when no model or group has a schedule
+	cron *cronSchedules
+
+	// nil when sharedState.redis is not set
+	sharedState *sharedState
+
+	// nil when quarantine is disabled
+	quarantine *modelQuarantine
+
+	// results of synthetic probes, kept apart from metricsMonitor
+	probes *probeMonitor
+
+	// nil when no evals are configured
+	evals *evalRunner
+
+	// set when Shutdown starts, models stopped after that are not recorded
+	shuttingDown atomic.Bool
+
+	// serializes writes of the loaded models to stateDir
+	loadedModelsMu sync.Mutex
+
+	// recent messages for the UI event stream
+	uiEvents *uiEventHistory
+
+	// limits how many models preloads and loads of required models start at
+	// the same time, see hooks.on_startup.parallelism
+	startSlots chan struct{}
+
+	// called by POST /api/config/reload, see SetReloadFunc
+	reload func() error
+
+	// set while draining, see POST /api/drain
+	drain atomic.Pointer[drainState]
 }
 
 func New(proxyConfig config.Config) *ProxyManager {
-	// set up loggers
+	return newProxyManager(proxyConfig, nil)
+}
+
+// newProxyManager creates a ProxyManager. When it replaces previous on a
+// config reload it takes over its loggers and the processes in kept.
+func newProxyManager(proxyConfig config.Config, previous *ProxyManager, kept ...*Process) *ProxyManager {
+	// set up loggers, a reload keeps their history and the clients
+	// streaming them
+	muxLogger, upstreamLogger, proxyLogger := NewLogMonitor(), NewLogMonitor(), NewLogMonitor()
+	if previous != nil {
+		muxLogger, upstreamLogger, proxyLogger = previous.muxLogger, previous.upstreamLogger, previous.proxyLogger
+	}
 
-	var muxLogger, upstreamLogger, proxyLogger *LogMonitor
+	muxLogger.setOutput(os.Stdout)
 	switch proxyConfig.LogToStdout {
 	case config.LogToStdoutNone:
-		muxLogger = NewLogMonitorWriter(io.Discard)
-		upstreamLogger = NewLogMonitorWriter(io.Discard)
-		proxyLogger = NewLogMonitorWriter(io.Discard)
+		muxLogger.setOutput(io.Discard)
+		upstreamLogger.setOutput(io.Discard)
+		proxyLogger.setOutput(io.Discard)
 	case config.LogToStdoutBoth:
-		muxLogger = NewLogMonitorWriter(os.Stdout)
-		upstreamLogger = NewLogMonitorWriter(muxLogger)
-		proxyLogger = NewLogMonitorWriter(muxLogger)
+		upstreamLogger.setOutput(muxLogger)
+		proxyLogger.setOutput(muxLogger)
 	case config.LogToStdoutUpstream:
-		muxLogger = NewLogMonitorWriter(os.Stdout)
-		upstreamLogger = NewLogMonitorWriter(muxLogger)
-		proxyLogger = NewLogMonitorWriter(io.Discard)
+		upstreamLogger.setOutput(muxLogger)
+		proxyLogger.setOutput(io.Discard)
 	default:
 		// same as config.LogToStdoutProxy
 		// helpful because some old tests create a config.Config directly and it
 		// may not have LogToStdout set explicitly
-		muxLogger = NewLogMonitorWriter(os.Stdout)
-		upstreamLogger = NewLogMonitorWriter(io.Discard)
-		proxyLogger = NewLogMonitorWriter(muxLogger)
+		upstreamLogger.setOutput(io.Discard)
+		proxyLogger.setOutput(muxLogger)
 	}
 
 	if proxyConfig.LogRequests {
@@ -123,10 +228,10 @@ func New(proxyConfig config.Config) *ProxyManager {
 		"stampnano":   time.StampNano,
 	}
 
-	if timeFormat, ok := timeFormats[strings.ToLower(strings.TrimSpace(proxyConfig.LogTimeFormat))]; ok {
-		proxyLogger.SetLogTimeFormat(timeFormat)
-		upstreamLogger.SetLogTimeFormat(timeFormat)
-	}
+	// an unknown format logs without timestamps
+	timeFormat := timeFormats[strings.ToLower(strings.TrimSpace(proxyConfig.LogTimeFormat))]
+	proxyLogger.SetLogTimeFormat(timeFormat)
+	upstreamLogger.SetLogTimeFormat(timeFormat)
 
 	shutdownCtx, shutdownCancel := context.WithCancel(context.Background())
 
@@ -141,6 +246,8 @@ func New(proxyConfig config.Config) *ProxyManager {
 	if err != nil {
 		proxyLogger.Errorf("Disabling Peering. Failed to create proxy peers: %v", err)
 		peerProxy = nil
+	} else {
+		peerProxy.setResponseHeaders(proxyConfig.ResponseHeaders)
 	}
 
 	pm := &ProxyManager{
@@ -163,52 +270,351 @@ func New(proxyConfig config.Config) *ProxyManager {
 		version:   "0",
 
 		peerProxy: peerProxy,
+
+		readGPUs:         readGPUs,
+		readGPUProcesses: readNvidiaSMIProcesses,
+		readPower:        readPowerSource,
+
+		uiEvents: newUIEventHistory(uiEventHistorySize),
+
+		servedModels: newServedModels(),
+
+		startSlots: make(chan struct{}, max(1, proxyConfig.Hooks.OnStartup.Parallelism)),
+	}
+
+	pm.scrubber = newScrubber(proxyConfig.Scrub)
+	pm.metricsMonitor.scrubber = pm.scrubber
+	pm.metricsMonitor.pricing = make(map[string]config.PricingConfig)
+	for modelID, modelConfig := range proxyConfig.Models {
+		if modelConfig.Pricing.Enabled() {
+			pm.metricsMonitor.pricing[modelID] = modelConfig.Pricing
+		}
+	}
+
+	if pm.peerProxy != nil {
+		pm.peerProxy.watch(pm.shutdownCtx)
+	}
+
+	if tee := newWebhookTee(proxyConfig.Models, proxyLogger); tee != nil {
+		pm.metricsMonitor.tee = tee
+		go tee.run(pm.shutdownCtx)
+	}
+
+	if proxyConfig.MetricsStore.Enabled() {
+		store := newMetricsStore(proxyConfig.MetricsStore, proxyLogger)
+		if history, err := store.load(time.Now()); err != nil {
+			proxyLogger.Errorf("metricsStore: unable to load %s: %v", proxyConfig.MetricsStore.File, err)
+		} else {
+			pm.metricsMonitor.restoreMetrics(history)
+		}
+		pm.metricsMonitor.store = store
+		go store.run(pm.shutdownCtx)
+	} else if previous != nil {
+		// without a store the activity of previous is only in its memory,
+		// requests it still has in flight are not carried over
+		pm.metricsMonitor.restoreMetrics(previous.metricsMonitor.getMetrics())
+	}
+
+	if proxyConfig.UsageExport.Enabled() {
+		go newUsageExporter(proxyConfig.UsageExport, proxyLogger).run(pm.shutdownCtx)
+	}
+
+	if proxyConfig.Datasets.Dir != "" {
+		pm.datasets = newDatasetWriter(proxyConfig.Datasets, pm.scrubber, proxyLogger)
+	}
+
+	pm.batchers = make(map[string]*embeddingBatcher)
+	for modelID, modelConfig := range proxyConfig.Models {
+		if modelConfig.Batch.Enabled() {
+			pm.batchers[modelID] = newEmbeddingBatcher(modelConfig.Batch, proxyLogger)
+		}
 	}
 
+	if responseCacheUsed(proxyConfig) {
+		pm.responseCache = newResponseCache(proxyConfig.ResponseCache, proxyConfig.Models, proxyLogger)
+	}
+
+	if proxyConfig.Scheduler.MaxConcurrent > 0 {
+		pm.scheduler = newRequestScheduler(proxyConfig.Scheduler.MaxConcurrent)
+	}
+
+	pm.scripts = make(map[string]*luaScript)
+	for modelID, modelConfig := range proxyConfig.Models {
+		if !modelConfig.Script.Enabled() {
+			continue
+		}
+		script, err := newLuaScript(modelConfig.Script)
+		if err != nil {
+			proxyLogger.Errorf("<%s> unable to load script: %v", modelID, err)
+			continue
+		}
+		pm.scripts[modelID] = script
+	}
+
+	pm.wasmFilters = make(map[string]wasmFilterChain)
+	for modelID, modelConfig := range proxyConfig.Models {
+		for _, filterConfig := range modelConfig.WasmFilters {
+			filter, err := newWasmFilter(filterConfig, proxyLogger)
+			if err != nil {
+				proxyLogger.Errorf("<%s> unable to load wasm filter %s: %v", modelID, filterConfig.File, err)
+				continue
+			}
+			// compiling takes a while, the first request waits for it
+			go filter.load()
+			pm.wasmFilters[modelID] = append(pm.wasmFilters[modelID], filter)
+		}
+	}
+
+	if proxyConfig.ClientLimits.Enabled() {
+		pm.clientLimiter = newClientLimiter()
+	}
+
+	if matchers, err := config.CompileClientRules(proxyConfig.Clients); err != nil {
+		proxyLogger.Errorf("clients rules ignored: %v", err)
+	} else {
+		pm.clientMatchers = matchers
+	}
+
+	pm.tenantQuotas = newTenantQuotas()
+	pm.queue = newRequestQueue()
+
 	// create the process groups
 	for groupID := range proxyConfig.Groups {
 		processGroup := NewProcessGroup(groupID, proxyConfig, proxyLogger, upstreamLogger)
 		pm.processGroups[groupID] = processGroup
 	}
+	for _, process := range kept {
+		for _, processGroup := range pm.processGroups {
+			if processGroup.replace(process) {
+				break
+			}
+		}
+	}
+
+	// readGPUs is looked up on every call so it can be replaced in tests
+	readGPUs := func() ([]GPUInfo, error) {
+		if pm.readGPUs == nil {
+			return nil, errors.New("GPU readings are disabled")
+		}
+		return pm.readGPUs()
+	}
+	pm.gpus = newGPUAllocator(proxyConfig.GPUs, readGPUs, pm.modelState, proxyConfig.Models)
+
+	for _, groupConfig := range proxyConfig.Groups {
+		if !groupConfig.OnBattery.IsZero() {
+			pm.battery = &batteryMonitor{}
+			break
+		}
+	}
+	if len(proxyConfig.Schedules) > 0 {
+		pm.schedules = newWarmSchedules(proxyConfig)
+	}
+	if proxyConfig.Chaos.Active {
+		proxyLogger.Warn("chaos mode is on, faults are injected into models")
+	}
+	if proxyConfig.Quarantine.Enabled() {
+		pm.quarantine = newModelQuarantine(proxyConfig.Quarantine)
+	}
 
+	for groupID, processGroup := range pm.processGroups {
+		for modelID, process := range processGroup.instances() {
+			process.setHooks(pm.processHooks(groupID, modelID, process))
+		}
+	}
+
+	if pm.battery != nil {
+		pm.checkBattery()
+		go pm.watchBattery()
+	}
+
+	if pm.schedules != nil {
+		pm.checkSchedules(time.Now())
+	}
+	pm.cron = newCronSchedules(proxyConfig, time.Now())
+	if pm.schedules != nil || pm.cron != nil {
+		go pm.watchSchedules()
+	}
+
+	if proxyConfig.Thermal.Enabled() {
+		pm.thermal = &thermalThrottle{config: proxyConfig.Thermal}
+		go pm.watchThermal()
+	}
+
+	if proxyConfig.SharedState.Enabled() {
+		if sharedState, err := newSharedState(proxyConfig.SharedState); err != nil {
+			proxyLogger.Errorf("unable to set up shared state, running standalone: %v", err)
+		} else {
+			pm.sharedState = sharedState
+			go pm.keepLeases()
+		}
+	}
+
+	pm.probes = newProbeMonitor()
+	pm.runProbes()
+
+	if len(proxyConfig.Tenants) > 0 {
+		pm.countTenantTokens()
+	}
+
+	if len(proxyConfig.Evals) > 0 {
+		pm.evals = newEvalRunner(proxyConfig.Evals)
+		go pm.runEvals()
+	}
+
+	// a reload does not end a drain
+	if previous != nil {
+		if drain := previous.drain.Load(); drain != nil {
+			pm.drain.Store(drain)
+			go pm.finishDrain(drain)
+		}
+	}
+
+	pm.recordUIEvents()
+	pm.discoverServedModels()
 	pm.setupGinEngine()
 
 	// run any startup hooks
-	if len(proxyConfig.Hooks.OnStartup.Preload) > 0 {
+	preload := slices.Clone(proxyConfig.Hooks.OnStartup.Preload)
+	if proxyConfig.Hooks.OnStartup.RestoreModels {
+		for _, modelID := range pm.modelsToRestore() {
+			if !slices.Contains(preload, modelID) {
+				preload = append(preload, modelID)
+			}
+		}
+		pm.recordLoadedModels()
+	}
+	if len(preload) > 0 && pm.drain.Load() == nil {
 		// do it in the background, don't block startup -- not sure if good idea yet
-		go func() {
-			discardWriter := &DiscardWriter{}
-			for _, preloadModelName := range proxyConfig.Hooks.OnStartup.Preload {
-				modelID, ok := proxyConfig.RealModelName(preloadModelName)
+		go pm.preloadModels(preload)
+	}
 
-				if !ok {
-					proxyLogger.Warnf("Preload model %s not found in config", preloadModelName)
-					continue
-				}
+	return pm
+}
 
-				proxyLogger.Infof("Preloading model: %s", modelID)
-				processGroup, err := pm.swapProcessGroup(modelID)
+// processHooks connects a process of a group to the parts of pm it uses
+func (pm *ProxyManager) processHooks(groupID, modelID string, process *Process) *processHooks {
+	hooks := &processHooks{}
+	if process.config.NeedsGPUPlacement() {
+		hooks.gpus = pm.gpus
+	}
+	if onBattery := pm.config.Groups[groupID].OnBattery; !onBattery.IsZero() {
+		hooks.batteryTTL = onBattery.TTL
+		hooks.onBattery = pm.battery.saving
+	}
+	if pm.schedules != nil {
+		hooks.keepLoaded = func() bool { return pm.schedules.keepLoaded(modelID) }
+	}
+	if pm.config.Chaos.Active && pm.config.Chaos.Applies(modelID) {
+		hooks.chaos = newChaosInjector(pm.config.Chaos)
+	}
+	hooks.script = pm.scripts[modelID]
+	hooks.wasmFilters = pm.wasmFilters[modelID]
+	if pm.quarantine != nil {
+		hooks.onFailure = func(err error) { pm.modelFailed(modelID, err) }
+	}
+	return hooks
+}
 
-				if err != nil {
-					event.Emit(ModelPreloadedEvent{
-						ModelName: modelID,
-						Success:   false,
-					})
-					proxyLogger.Errorf("Failed to preload model %s: %v", modelID, err)
-					continue
-				} else {
-					req, _ := http.NewRequest("GET", "/", nil)
-					processGroup.ProxyRequest(modelID, discardWriter, req)
-					event.Emit(ModelPreloadedEvent{
-						ModelName: modelID,
-						Success:   true,
-					})
-				}
+// preloadModels starts the models in the list, see startModels
+func (pm *ProxyManager) preloadModels(preload []string) {
+	var models []string
+	for _, preloadModelName := range preload {
+		modelID, ok := pm.realModelName(preloadModelName)
+		if !ok {
+			pm.proxyLogger.Warnf("Preload model %s not found in config", preloadModelName)
+			continue
+		}
+		models = append(models, modelID)
+	}
+	pm.startModels(models, pm.preloadModel)
+}
+
+// startModels calls start for each model, running up to startSlots starts at
+// the same time. Models that would unload each other are started one after
+// the other in list order: members of the same swap group are chained, and
+// the members of an exclusive group are started apart from the models
+// listed before and after them.
+func (pm *ProxyManager) startModels(models []string, start func(modelID string)) {
+	// phases run one after the other, a phase holds the models of one
+	// exclusive group or models of groups that are not exclusive
+	var phases [][]string
+	phaseKey := ""
+	for i, modelID := range models {
+		key := ""
+		if processGroup := pm.findGroupByModelName(modelID); processGroup != nil && processGroup.exclusive {
+			key = processGroup.id
+		}
+		if i == 0 || key != phaseKey {
+			phases = append(phases, nil)
+			phaseKey = key
+		}
+		phases[len(phases)-1] = append(phases[len(phases)-1], modelID)
+	}
+
+	for _, phase := range phases {
+		// split the phase into chains that are safe to run concurrently
+		var chains [][]string
+		chainIndex := make(map[string]int)
+		for _, modelID := range phase {
+			key := modelID
+			if processGroup := pm.findGroupByModelName(modelID); processGroup != nil && processGroup.swap {
+				key = "group:" + processGroup.id
+			}
+			if cap(pm.startSlots) == 1 {
+				// one at a time keeps the list order
+				key = ""
+			}
+			if i, found := chainIndex[key]; found {
+				chains[i] = append(chains[i], modelID)
+			} else {
+				chainIndex[key] = len(chains)
+				chains = append(chains, []string{modelID})
 			}
-		}()
+		}
+
+		var wg sync.WaitGroup
+		for _, chain := range chains {
+			wg.Add(1)
+			go func(chain []string) {
+				defer wg.Done()
+				for _, modelID := range chain {
+					pm.startSlots <- struct{}{}
+					start(modelID)
+					<-pm.startSlots
+				}
+			}(chain)
+		}
+		wg.Wait()
 	}
+}
 
-	return pm
+func (pm *ProxyManager) preloadModel(modelID string) {
+	pm.proxyLogger.Infof("Preloading model: %s", modelID)
+	if err := pm.checkQuarantine(modelID); err != nil {
+		pm.proxyLogger.Warnf("Not preloading model %s: %v", modelID, err)
+		return
+	}
+	if err := pm.leaseGroup(modelID); err != nil {
+		pm.proxyLogger.Infof("Not preloading model %s: %v", modelID, err)
+		return
+	}
+	processGroup, err := pm.swapProcessGroup(modelID)
+
+	if err != nil {
+		event.Emit(ModelPreloadedEvent{
+			ModelName: modelID,
+			Success:   false,
+		})
+		pm.proxyLogger.Errorf("Failed to preload model %s: %v", modelID, err)
+		return
+	}
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	processGroup.ProxyRequest(modelID, &DiscardWriter{}, req)
+	event.Emit(ModelPreloadedEvent{
+		ModelName: modelID,
+		Success:   true,
+	})
 }
 
 func (pm *ProxyManager) setupGinEngine() {
@@ -224,6 +630,16 @@ func (pm *ProxyManager) setupGinEngine() {
 		// Start timer
 		start := time.Now()
 
+		// the ID of a client or peer is kept so the request can be traced
+		// across them, upstreams get it in the same header
+		id := incomingRequestID(c.Request)
+		if id == "" {
+			id = newRequestID()
+		}
+		c.Request.Header.Set(requestIDHeader, id)
+		c.Header(requestIDHeader, id)
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), proxyCtxKey("requestID"), id))
+
 		// capture these because /upstream/:model rewrites them in c.Next()
 		clientIP := c.ClientIP()
 		method := c.Request.Method
@@ -238,7 +654,8 @@ func (pm *ProxyManager) setupGinEngine() {
 		statusCode := c.Writer.Status()
 		bodySize := c.Writer.Size()
 
-		pm.proxyLogger.Infof("Request %s \"%s %s %s\" %d %d \"%s\" %v",
+		pm.proxyLogger.Infof("Request [%s] %s \"%s %s %s\" %d %d \"%s\" %v",
+			id,
 			clientIP,
 			method,
 			path,
@@ -308,6 +725,10 @@ func (pm *ProxyManager) setupGinEngine() {
 	pm.ginEngine.POST("/v1/images/generations", pm.apiKeyAuth(), pm.proxyInferenceHandler)
 	pm.ginEngine.POST("/v1/images/edits", pm.apiKeyAuth(), pm.proxyOAIPostFormHandler)
 
+	// OpenAI Realtime API, sockets and the sessions that mint their tokens
+	pm.ginEngine.GET("/v1/realtime", pm.apiKeyAuth(), pm.proxyRealtimeHandler)
+	pm.ginEngine.POST("/v1/realtime/sessions", pm.apiKeyAuth(), pm.proxyInferenceHandler)
+
 	pm.ginEngine.GET("/v1/models", pm.apiKeyAuth(), pm.listModelsHandler)
 
 	// in proxymanager_loghandlers.go
@@ -329,6 +750,11 @@ func (pm *ProxyManager) setupGinEngine() {
 	pm.ginEngine.GET("/unload", pm.apiKeyAuth(), pm.unloadAllModelsHandler)
 	pm.ginEngine.GET("/running", pm.apiKeyAuth(), pm.listRunningProcessesHandler)
 	pm.ginEngine.GET("/health", func(c *gin.Context) {
+		// load balancers take a draining instance out of rotation
+		if pm.drain.Load() != nil {
+			c.String(http.StatusServiceUnavailable, "draining")
+			return
+		}
 		c.String(http.StatusOK, "OK")
 	})
 
@@ -421,6 +847,7 @@ func (pm *ProxyManager) Shutdown() {
 	defer pm.Unlock()
 
 	pm.proxyLogger.Debug("Shutdown() called in proxy manager")
+	pm.shuttingDown.Store(true)
 
 	var wg sync.WaitGroup
 	// Send shutdown signal to all process in groups
@@ -432,6 +859,9 @@ func (pm *ProxyManager) Shutdown() {
 		}(processGroup)
 	}
 	wg.Wait()
+	for _, filters := range pm.wasmFilters {
+		filters.close()
+	}
 	pm.shutdownCancel()
 }
 
@@ -441,19 +871,56 @@ func (pm *ProxyManager) swapProcessGroup(realModelName string) (*ProcessGroup, e
 		return nil, fmt.Errorf("could not find process group for model %s", realModelName)
 	}
 
+	requirements := pm.config.Requirements(realModelName)
 	if processGroup.exclusive {
 		pm.proxyLogger.Debugf("Exclusive mode for group %s, idling other process groups", processGroup.id)
 		for groupId, otherGroup := range pm.processGroups {
 			if groupId != processGroup.id && !otherGroup.persistent {
-				otherGroup.MakeIdleProcesses()
+				otherGroup.MakeIdleProcesses(requirements...)
 			}
 		}
 	}
 
+	pm.loadRequirements(realModelName, requirements)
 	return processGroup, nil
 }
 
+// loadRequirements loads or wakes the models realModelName requires in the
+// background, with the same limits as preloads, see startModels
+func (pm *ProxyManager) loadRequirements(realModelName string, requirements []string) {
+	var toLoad []string
+	for _, required := range requirements {
+		processGroup := pm.findGroupByModelName(required)
+		if processGroup == nil {
+			continue
+		}
+		switch processGroup.processes[required].CurrentState() {
+		case StateReady, StateStarting, StateWaking:
+			continue
+		}
+
+		pm.proxyLogger.Infof("<%s> loading %s, it is required", realModelName, required)
+		toLoad = append(toLoad, required)
+	}
+	if len(toLoad) == 0 {
+		return
+	}
+
+	go pm.startModels(toLoad, func(required string) {
+		req, _ := http.NewRequest("GET", "/", nil)
+		pm.findGroupByModelName(required).ProxyRequest(required, &DiscardWriter{}, req)
+	})
+}
+
 func (pm *ProxyManager) listModelsHandler(c *gin.Context) {
+	tenantName, err := pm.requestTenant(c.Request.Header)
+	if err != nil {
+		pm.sendErrorResponse(c, http.StatusForbidden, err.Error())
+		return
+	}
+	// a tenant only sees the models it may use
+	tenant := pm.config.Tenants[tenantName]
+
 	data := make([]gin.H, 0, len(pm.config.Models))
 	createdTime := time.Now().Unix()
 
@@ -482,11 +949,25 @@ func (pm *ProxyManager) listModelsHandler(c *gin.Context) {
 	}
 
 	for id, modelConfig := range pm.config.Models {
-		if modelConfig.Unlisted {
+		if modelConfig.Unlisted || !tenant.Allows(id) {
 			continue
 		}
 
-		data = append(data, newRecord(id, modelConfig))
+		record := newRecord(id, modelConfig)
+		if _, quarantined := pm.quarantine.info(id); quarantined {
+			record["quarantined"] = true
+		}
+		data = append(data, record)
+
+		// model:suffix picks a chat_template_kwargs preset
+		for suffix := range modelConfig.ChatTemplateSuffixes {
+			data = append(data, newRecord(id+":"+suffix, modelConfig))
+		}
+
+		// served by the same backend, see discoverModels
+		for _, name := range pm.servedModels.list(id) {
+			data = append(data, newRecord(name, modelConfig))
+		}
 
 		// Include aliases
 		if pm.config.IncludeAliasesInList {
@@ -498,10 +979,27 @@ func (pm *ProxyManager) listModelsHandler(c *gin.Context) {
 		}
 	}
 
+	// routers are listed when the tenant may use one of their models
+	for id, router := range pm.config.Routers {
+		if !slices.ContainsFunc(router.Route, func(rule config.RouteRule) bool { return tenant.Allows(rule.Model) }) {
+			continue
+		}
+		data = append(data, newRecord(id, config.ModelConfig{Name: router.Name, Description: router.Description}))
+	}
+
 	if pm.peerProxy != nil {
 		for peerID, peer := range pm.peerProxy.ListPeers() {
+			// models of a peer that is down are left out
+			if healthy, _ := pm.peerProxy.healthy(peerID); !healthy {
+				continue
+			}
+
 			// add peer models
 			for _, modelID := range peer.Models {
+				if !tenant.Allows(modelID) {
+					continue
+				}
+
 				// Skip unlisted models if not showing them
 				record := newRecord(modelID, config.ModelConfig{
 					Name: fmt.Sprintf("%s: %s", peerID, modelID),
@@ -553,7 +1051,7 @@ func (pm *ProxyManager) findModelInPath(path string) (searchName string, realNam
 			searchModelName = searchModelName + "/" + part
 		}
 
-		if modelID, ok := pm.config.RealModelName(searchModelName); ok {
+		if modelID, ok := pm.realModelName(searchModelName); ok {
 			return searchModelName, modelID, "/" + strings.Join(parts[i+1:], "/"), true
 		}
 	}
@@ -588,12 +1086,38 @@ func (pm *ProxyManager) proxyToUpstream(c *gin.Context) {
 		return
 	}
 
+	if !pm.admitTenant(c, searchModelName) {
+		return
+	}
+
+	owner, ok := pm.groupOwner(c, modelID)
+	if !ok {
+		return
+	} else if owner != "" {
+		if err := pm.forwardTo(owner)(modelID, c.Writer, c.Request); err != nil {
+			pm.sendErrorResponse(c, http.StatusInternalServerError, fmt.Sprintf("error forwarding request: %s", err.Error()))
+		}
+		return
+	}
+
+	if pm.rejectUnadmitted(c, modelID) {
+		return
+	}
+
 	processGroup, err := pm.swapProcessGroup(modelID)
 	if err != nil {
 		pm.sendErrorResponse(c, http.StatusInternalServerError, fmt.Sprintf("error swapping process group: %s", err.Error()))
 		return
 	}
 
+	// backends with websocket APIs get the upgrade once the model is loaded
+	if isWebSocketUpgrade(c.Request) {
+		if !pm.loadForSocket(c, modelID, processGroup) {
+			return
+		}
+		pm.proxyLogger.Debugf("<%s> opening upstream socket on %s", modelID, remainingPath)
+	}
+
 	// rewrite the path
 	originalPath := c.Request.URL.Path
 	c.Request.URL.Path = remainingPath
@@ -621,61 +1145,153 @@ func (pm *ProxyManager) proxyInferenceHandler(c *gin.Context) {
 		return
 	}
 
+	if pm.config.Scrub.Forward {
+		bodyBytes = pm.scrubber.scrub(bodyBytes)
+	}
+
+	if model := headerModel(c.Request); model != "" {
+		if bodyBytes, err = sjson.SetBytes(bodyBytes, "model", model); err != nil {
+			pm.sendErrorResponse(c, http.StatusBadRequest, fmt.Sprintf("error setting model from %s header: %s", modelHeader, err.Error()))
+			return
+		}
+	}
+
+	bodyBytes, proceed := pm.runRequestMiddleware(c, config.MiddlewarePreRoute, gjson.GetBytes(bodyBytes, "model").String(), bodyBytes)
+	if !proceed {
+		return
+	}
+
 	requestedModel := gjson.GetBytes(bodyBytes, "model").String()
 	if requestedModel == "" {
-		pm.sendErrorResponse(c, http.StatusBadRequest, "missing or invalid 'model' key")
+		pm.sendErrorResponse(c, http.StatusBadRequest, "missing or invalid 'model' key or X-Model header")
 		return
 	}
 
-	// Look for a matching local model first
-	var nextHandler func(modelID string, w http.ResponseWriter, r *http.Request) error
+	bodyBytes, requestedModel, err = pm.applyRouter(bodyBytes, requestedModel)
+	if err != nil {
+		pm.sendErrorResponse(c, http.StatusBadRequest, fmt.Sprintf("error routing request: %s", err.Error()))
+		return
+	}
+
+	bodyBytes, requestedModel, err = pm.applyChatTemplateSuffix(bodyBytes, requestedModel)
+	if err != nil {
+		pm.sendErrorResponse(c, http.StatusBadRequest, fmt.Sprintf("error applying chat template suffix: %s", err.Error()))
+		return
+	}
+
+	if bodyBytes, err = pm.applyAliasPreset(bodyBytes, requestedModel); err != nil {
+		pm.sendErrorResponse(c, http.StatusBadRequest, fmt.Sprintf("error applying alias preset: %s", err.Error()))
+		return
+	}
 
-	modelID, found := pm.config.RealModelName(requestedModel)
+	modelID, found := pm.realModelName(requestedModel)
+
+	if found && pm.rejectPastDeadline(c, modelID) {
+		return
+	}
+
+	if found && !pm.checkGuardrail(c, modelID, bodyBytes) {
+		return
+	}
+
+	pm.stampRequest(c, modelID, requestedModel)
+
+	// shows in /api/queue until the upstream starts on it
 	if found {
-		processGroup, err := pm.swapProcessGroup(modelID)
-		if err != nil {
-			pm.sendErrorResponse(c, http.StatusInternalServerError, fmt.Sprintf("error swapping process group: %s", err.Error()))
+		done, ok := pm.enqueue(c, modelID)
+		if !ok {
 			return
 		}
+		defer done()
+	}
 
-		// issue #69 allow custom model names to be sent to upstream
-		useModelName := pm.config.Models[modelID].UseModelName
-		if useModelName != "" {
-			bodyBytes, err = sjson.SetBytes(bodyBytes, "model", useModelName)
-			if err != nil {
-				pm.sendErrorResponse(c, http.StatusInternalServerError, fmt.Sprintf("error rewriting model name in JSON: %s", err.Error()))
+	// a client may not fill the queue or the upstream by itself
+	pm.identifyClient(c)
+	releaseClient, ok := pm.limitClient(c, modelID)
+	if !ok {
+		return
+	}
+	defer releaseClient()
+
+	if !pm.admitTenant(c, requestedModel) {
+		return
+	}
+
+	// serve identical deterministic requests without waking the upstream,
+	// after the checks above so a hit is admitted and limited like any
+	// other request
+	var cacheKey string
+	if pm.responseCache != nil {
+		cacheModelID := requestedModel
+		if found {
+			cacheModelID = modelID
+		}
+		if key, ok := pm.responseCache.cacheKey(cacheModelID, c.Request.URL.Path, bodyBytes); ok {
+			if entry, hit := pm.responseCache.get(key); hit {
+				pm.proxyLogger.Debugf("<%s> response cache hit for %s", cacheModelID, c.Request.URL.Path)
+				started := time.Now()
+				pm.responseCache.serve(c.Writer, entry)
+				pm.metricsMonitor.addCacheHit(cacheModelID, c.Request, started)
 				return
 			}
+			cacheKey = key
 		}
+	}
 
-		// issue #174 strip parameters from the JSON body
-		stripParams, err := pm.config.Models[modelID].Filters.SanitizedStripParams()
-		if err != nil { // just log it and continue
-			pm.proxyLogger.Errorf("Error sanitizing strip params string: %s, %s", pm.config.Models[modelID].Filters.StripParams, err.Error())
-		} else {
-			for _, param := range stripParams {
-				pm.proxyLogger.Debugf("<%s> stripping param: %s", modelID, param)
-				bodyBytes, err = sjson.DeleteBytes(bodyBytes, param)
-				if err != nil {
-					pm.sendErrorResponse(c, http.StatusInternalServerError, fmt.Sprintf("error deleting parameter %s from request", param))
-					return
-				}
-			}
+	// wait for a free slot before anything is swapped
+	release, ok := pm.scheduleRequest(c, modelID)
+	if !ok {
+		return
+	}
+	defer release()
+
+	// another instance may lease the group, see sharedState
+	owner, ok := pm.groupOwner(c, modelID)
+	if !ok {
+		return
+	}
+
+	// Look for a matching local model first
+	var nextHandler func(modelID string, w http.ResponseWriter, r *http.Request) error
+
+	if owner != "" {
+		requestStarted(c.Request)
+		nextHandler = pm.forwardTo(owner)
+	} else if found && !pm.drainsToPeer(requestedModel) {
+		if pm.rejectUnadmitted(c, modelID) {
+			return
 		}
 
-		// issue #453 set/override parameters in the JSON body
-		setParams, setParamKeys := pm.config.Models[modelID].Filters.SanitizedSetParams()
-		for _, key := range setParamKeys {
-			pm.proxyLogger.Debugf("<%s> setting param: %s", modelID, key)
-			bodyBytes, err = sjson.SetBytes(bodyBytes, key, setParams[key])
-			if err != nil {
-				pm.sendErrorResponse(c, http.StatusInternalServerError, fmt.Sprintf("error setting parameter %s in request", key))
+		processGroup, err := pm.swapProcessGroup(modelID)
+		if err != nil {
+			pm.sendErrorResponse(c, http.StatusInternalServerError, fmt.Sprintf("error swapping process group: %s", err.Error()))
+			return
+		}
+
+		// a fallback applies its own filters to the body as it is here
+		fallbackBody := bodyBytes
+		if bodyBytes, err = pm.upstreamBody(modelID, requestedModel, c.Request.URL.Path, bodyBytes); err != nil {
+			var local *wasmLocalResponse
+			if errors.As(err, &local) {
+				local.write(c.Writer)
 				return
 			}
+			pm.sendErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		if pm.applyKeepAlive(processGroup, modelID, bodyBytes) {
+			defer func() {
+				pm.proxyLogger.Infof("<%s> Unloading model, keep_alive is 0", modelID)
+				go processGroup.StopProcess(modelID, StopWaitForInflightRequest)
+			}()
 		}
 
 		pm.proxyLogger.Debugf("ProxyManager using local Process for model: %s", requestedModel)
 		nextHandler = processGroup.ProxyRequest
+		if len(pm.config.Models[modelID].Fallback) > 0 {
+			nextHandler = pm.fallbackHandler(fallbackBody, c.Request.URL.Path, nextHandler)
+		}
 	} else if pm.peerProxy != nil && pm.peerProxy.HasPeerModel(requestedModel) {
 		pm.proxyLogger.Debugf("ProxyManager using ProxyPeer for model: %s", requestedModel)
 		modelID = requestedModel
@@ -713,6 +1329,10 @@ func (pm *ProxyManager) proxyInferenceHandler(c *gin.Context) {
 		return
 	}
 
+	if bodyBytes, proceed = pm.runRequestMiddleware(c, config.MiddlewarePreUpstream, modelID, bodyBytes); !proceed {
+		return
+	}
+
 	c.Request.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
 
 	// dechunk it as we already have all the body bytes see issue #11
@@ -720,13 +1340,46 @@ func (pm *ProxyManager) proxyInferenceHandler(c *gin.Context) {
 	c.Request.Header.Set("content-length", strconv.Itoa(len(bodyBytes)))
 	c.Request.ContentLength = int64(len(bodyBytes))
 
+	requestStart := time.Now()
+	defer func() {
+		pm.runPostResponseMiddleware(c, modelID, bodyBytes, time.Since(requestStart))
+	}()
+
+	if batcher := pm.batchers[modelID]; batcher != nil && c.Request.URL.Path == "/v1/embeddings" {
+		nextHandler = batcher.wrapHandler(bodyBytes, nextHandler)
+	}
+
+	// validated before caching so only a valid reply is cached
+	if retries := pm.config.StructuredOutput.Retries; retries > 0 {
+		if schema, ok := responseSchema(c.Request.URL.Path, bodyBytes); ok {
+			nextHandler = pm.structuredOutputHandler(bodyBytes, schema, retries, nextHandler)
+		}
+	}
+
+	if found && pm.datasets != nil && pm.config.Models[modelID].CaptureDataset {
+		nextHandler = pm.datasets.wrapHandler(modelID, c.Request.URL.Path, bodyBytes, nextHandler)
+	}
+
+	if found && pm.evals != nil {
+		nextHandler = pm.evals.wrapHandler(modelID, c.Request.URL.Path, bodyBytes, nextHandler)
+	}
+
+	if cacheKey != "" {
+		nextHandler = pm.responseCache.wrapHandler(cacheKey, nextHandler)
+	}
+
 	// issue #366 extract values that downstream handlers may need
 	isStreaming := gjson.GetBytes(bodyBytes, "stream").Bool()
 	ctx := context.WithValue(c.Request.Context(), proxyCtxKey("streaming"), isStreaming)
 	ctx = context.WithValue(ctx, proxyCtxKey("model"), modelID)
+	ctx = context.WithValue(ctx, proxyCtxKey("prefixes"), promptPrefixHashes(bodyBytes))
 	c.Request = c.Request.WithContext(ctx)
+	if found && len(pm.config.Models[modelID].Fallback) > 0 {
+		c.Request = withServedModel(c.Request)
+	}
 
-	if pm.metricsMonitor != nil && c.Request.Method == "POST" {
+	// the instance a request is forwarded to records it
+	if pm.metricsMonitor != nil && c.Request.Method == "POST" && owner == "" {
 		if err := pm.metricsMonitor.wrapHandler(modelID, c.Writer, c.Request, nextHandler); err != nil {
 			pm.sendErrorResponse(c, http.StatusInternalServerError, fmt.Sprintf("error proxying metrics wrapped request: %s", err.Error()))
 			pm.proxyLogger.Errorf("Error Proxying Metrics Wrapped Request model %s", modelID)
@@ -741,6 +1394,72 @@ func (pm *ProxyManager) proxyInferenceHandler(c *gin.Context) {
 	}
 }
 
+// upstreamBody applies what modelID's config changes in a request before it
+// is sent upstream: the model name, the filters and the rewrites
+func (pm *ProxyManager) upstreamBody(modelID, requestedModel, path string, body []byte) ([]byte, error) {
+	modelConfig := pm.config.Models[modelID]
+
+	// issue #69 allow custom model names to be sent to upstream, the
+	// backend knows discovered names as they are
+	var err error
+	useModelName := modelConfig.UpstreamModelName(requestedModel)
+	if useModelName != "" && !pm.isServedModelName(requestedModel) {
+		body, err = sjson.SetBytes(body, "model", useModelName)
+		if err != nil {
+			return nil, fmt.Errorf("error rewriting model name in JSON: %s", err.Error())
+		}
+	}
+
+	// issue #174 strip parameters from the JSON body
+	stripParams, err := modelConfig.Filters.SanitizedStripParams()
+	if err != nil { // just log it and continue
+		pm.proxyLogger.Errorf("Error sanitizing strip params string: %s, %s", modelConfig.Filters.StripParams, err.Error())
+	} else {
+		for _, param := range stripParams {
+			pm.proxyLogger.Debugf("<%s> stripping param: %s", modelID, param)
+			body, err = sjson.DeleteBytes(body, param)
+			if err != nil {
+				return nil, fmt.Errorf("error deleting parameter %s from request", param)
+			}
+		}
+	}
+
+	// issue #453 set/override parameters in the JSON body
+	setParams, setParamKeys := modelConfig.Filters.SanitizedSetParams()
+	for _, key := range setParamKeys {
+		pm.proxyLogger.Debugf("<%s> setting param: %s", modelID, key)
+		body, err = sjson.SetBytes(body, key, setParams[key])
+		if err != nil {
+			return nil, fmt.Errorf("error setting parameter %s in request", key)
+		}
+	}
+
+	// without the usage a stream is recorded with zero tokens
+	if modelConfig.EnsureUsage && usageEndpoints[path] {
+		body = applyRewrites(body, []config.Rewrite{includeUsage})
+	}
+
+	// rewrites generalize the filters for what the backend expects
+	if rewrites := modelConfig.RequestRewrites; len(rewrites) > 0 {
+		body = applyRewrites(body, rewrites)
+	}
+
+	// the script sees the body as it is sent
+	if script := pm.scripts[modelID]; script != nil {
+		if body, err = script.request(body, path); err != nil {
+			return nil, err
+		}
+	}
+
+	// a filter may answer the request itself, see wasmLocalResponse
+	if filters := pm.wasmFilters[modelID]; len(filters) > 0 {
+		if body, err = filters.request(body); err != nil {
+			return nil, err
+		}
+	}
+	return body, nil
+}
+
 func (pm *ProxyManager) proxyOAIPostFormHandler(c *gin.Context) {
 	// Parse multipart form
 	if err := c.Request.ParseMultipartForm(32 << 20); err != nil { // 32MB max memory, larger files go to tmp disk
@@ -750,8 +1469,54 @@ func (pm *ProxyManager) proxyOAIPostFormHandler(c *gin.Context) {
 
 	// Get model parameter from the form
 	requestedModel := c.Request.FormValue("model")
+	if model := headerModel(c.Request); model != "" {
+		requestedModel = model
+		c.Request.MultipartForm.Value["model"] = []string{model}
+	}
 	if requestedModel == "" {
-		pm.sendErrorResponse(c, http.StatusBadRequest, "missing or invalid 'model' parameter in form data")
+		pm.sendErrorResponse(c, http.StatusBadRequest, "missing or invalid 'model' parameter in form data or X-Model header")
+		return
+	}
+
+	modelID, found := pm.realModelName(requestedModel)
+
+	if found && pm.rejectPastDeadline(c, modelID) {
+		return
+	}
+
+	pm.stampRequest(c, modelID, requestedModel)
+
+	// shows in /api/queue until the upstream starts on it
+	if found {
+		done, ok := pm.enqueue(c, modelID)
+		if !ok {
+			return
+		}
+		defer done()
+	}
+
+	// a client may not fill the queue or the upstream by itself
+	pm.identifyClient(c)
+	releaseClient, ok := pm.limitClient(c, modelID)
+	if !ok {
+		return
+	}
+	defer releaseClient()
+
+	if !pm.admitTenant(c, requestedModel) {
+		return
+	}
+
+	// wait for a free slot before anything is swapped
+	release, ok := pm.scheduleRequest(c, modelID)
+	if !ok {
+		return
+	}
+	defer release()
+
+	// another instance may lease the group, see sharedState
+	owner, ok := pm.groupOwner(c, modelID)
+	if !ok {
 		return
 	}
 
@@ -759,15 +1524,23 @@ func (pm *ProxyManager) proxyOAIPostFormHandler(c *gin.Context) {
 	var nextHandler func(modelID string, w http.ResponseWriter, r *http.Request) error
 	var useModelName string
 
-	modelID, found := pm.config.RealModelName(requestedModel)
-	if found {
+	if owner != "" {
+		requestStarted(c.Request)
+		nextHandler = pm.forwardTo(owner)
+	} else if found && !pm.drainsToPeer(requestedModel) {
+		if pm.rejectUnadmitted(c, modelID) {
+			return
+		}
+
 		processGroup, err := pm.swapProcessGroup(modelID)
 		if err != nil {
 			pm.sendErrorResponse(c, http.StatusInternalServerError, fmt.Sprintf("error swapping process group: %s", err.Error()))
 			return
 		}
 
-		useModelName = pm.config.Models[modelID].UseModelName
+		if !pm.isServedModelName(requestedModel) {
+			useModelName = pm.config.Models[modelID].UpstreamModelName(requestedModel)
+		}
 		pm.proxyLogger.Debugf("ProxyManager using local Process for model: %s", requestedModel)
 		nextHandler = processGroup.ProxyRequest
 	} else if pm.peerProxy != nil && pm.peerProxy.HasPeerModel(requestedModel) {
@@ -870,16 +1643,33 @@ func (pm *ProxyManager) proxyOAIPostFormHandler(c *gin.Context) {
 }
 
 func (pm *ProxyManager) proxyGETModelHandler(c *gin.Context) {
-	requestedModel := c.Query("model")
+	requestedModel := queryModel(c.Request)
 	if requestedModel == "" {
-		pm.sendErrorResponse(c, http.StatusBadRequest, "missing required 'model' query parameter")
+		pm.sendErrorResponse(c, http.StatusBadRequest, "missing required 'model' query parameter or X-Model header")
+		return
+	}
+
+	if !pm.admitTenant(c, requestedModel) {
 		return
 	}
 
 	var nextHandler func(modelID string, w http.ResponseWriter, r *http.Request) error
 	var modelID string
 
-	if realModelID, found := pm.config.RealModelName(requestedModel); found {
+	realModelID, found := pm.realModelName(requestedModel)
+	owner, ok := pm.groupOwner(c, realModelID)
+	if !ok {
+		return
+	}
+
+	if owner != "" {
+		modelID = realModelID
+		nextHandler = pm.forwardTo(owner)
+	} else if found && !pm.drainsToPeer(requestedModel) {
+		if pm.rejectUnadmitted(c, realModelID) {
+			return
+		}
+
 		processGroup, err := pm.swapProcessGroup(realModelID)
 		if err != nil {
 			pm.sendErrorResponse(c, http.StatusInternalServerError, fmt.Sprintf("error swapping process group: %s", err.Error()))
@@ -969,6 +1759,8 @@ func (pm *ProxyManager) apiKeyAuth() gin.HandlerFunc {
 			return
 		}
 
+		c.Set(apiKeyContextKey, providedKey)
+
 		// Strip auth headers to prevent leakage to upstream
 		c.Request.Header.Del("Authorization")
 		c.Request.Header.Del("x-api-key")
@@ -984,27 +1776,10 @@ func (pm *ProxyManager) unloadAllModelsHandler(c *gin.Context) {
 
 func (pm *ProxyManager) listRunningProcessesHandler(context *gin.Context) {
 	context.Header("Content-Type", "application/json")
-	runningProcesses := make([]gin.H, 0) // Default to an empty response.
-
-	for _, processGroup := range pm.processGroups {
-		for _, process := range processGroup.processes {
-			if process.CurrentState() == StateReady {
-				runningProcesses = append(runningProcesses, gin.H{
-					"model":       process.ID,
-					"state":       process.state,
-					"cmd":         process.config.Cmd,
-					"proxy":       process.config.Proxy,
-					"ttl":         process.config.UnloadAfter,
-					"name":        process.config.Name,
-					"description": process.config.Description,
-				})
-			}
-		}
-	}
 
 	// Put the results under the `running` key.
 	response := gin.H{
-		"running": runningProcesses,
+		"running": pm.runningModels(),
 	}
 
 	context.JSON(http.StatusOK, response) // Always return 200 OK
@@ -1019,6 +1794,17 @@ func (pm *ProxyManager) findGroupByModelName(modelName string) *ProcessGroup {
 	return nil
 }
 
+// modelState returns the state of a model's process, StateStopped when the
+// model is unknown
+func (pm *ProxyManager) modelState(modelID string) ProcessState {
+	if group := pm.findGroupByModelName(modelID); group != nil {
+		if process, ok := group.GetMember(modelID); ok {
+			return process.CurrentState()
+		}
+	}
+	return StateStopped
+}
+
 func (pm *ProxyManager) SetVersion(buildDate string, commit string, version string) {
 	pm.Lock()
 	defer pm.Unlock()
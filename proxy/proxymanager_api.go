@@ -1,16 +1,16 @@
 package proxy
 
 import (
-	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
 
+	"github.com/gin-contrib/sse"
 	"github.com/gin-gonic/gin"
-	"github.com/napmany/llmsnap/event"
 )
 
 type Model struct {
@@ -21,6 +21,39 @@ type Model struct {
 	Unlisted    bool   `json:"unlisted"`
 	SleepMode   string `json:"sleepMode"`
 	PeerID      string `json:"peerID"`
+
+	// set while the model is quarantined, see config.QuarantineConfig
+	Quarantine *QuarantineInfo `json:"quarantine,omitempty"`
+
+	// set while the model is in the failed state, see config.CrashLoopConfig
+	Failure *CrashLoopInfo `json:"failure,omitempty"`
+
+	// state of each instance, only set for models with instances
+	Instances []ProcessState `json:"instances,omitempty"`
+
+	// requests in flight over all instances and the streamed ones among them
+	InFlight          int `json:"inFlight"`
+	StreamingInFlight int `json:"streamingInFlight"`
+}
+
+// ModelInFlight is sent to UI clients when the requests in flight of a
+// model change
+type ModelInFlight struct {
+	Id                string `json:"id"`
+	InFlight          int    `json:"inFlight"`
+	StreamingInFlight int    `json:"streamingInFlight"`
+}
+
+// modelInFlight counts the requests in flight over all instances of modelID
+func (pm *ProxyManager) modelInFlight(modelID string) ModelInFlight {
+	inFlight := ModelInFlight{Id: modelID}
+	if processGroup := pm.findGroupByModelName(modelID); processGroup != nil {
+		for _, instance := range processGroup.instancesOf(modelID) {
+			inFlight.InFlight += int(instance.inFlightRequestsCount.Load())
+			inFlight.StreamingInFlight += int(instance.inFlightStreamingCount.Load())
+		}
+	}
+	return inFlight
 }
 
 func addApiHandlers(pm *ProxyManager) {
@@ -31,11 +64,35 @@ func addApiHandlers(pm *ProxyManager) {
 		apiGroup.POST("/models/unload", pm.apiUnloadAllModels)
 		apiGroup.POST("/models/unload/*model", pm.apiUnloadSingleModelHandler)
 		apiGroup.POST("/models/sleep/*model", pm.apiSleepSingleModelHandler)
+		apiGroup.POST("/models/enable/*model", pm.apiEnableModelHandler)
+		apiGroup.POST("/models/reset/*model", pm.apiResetModelHandler)
 		apiGroup.GET("/events", pm.apiSendEvents)
+		apiGroup.GET("/logs/*model", pm.apiStreamModelLogs)
 		apiGroup.GET("/metrics", pm.apiGetMetrics)
+		apiGroup.GET("/metrics/export", pm.apiExportMetrics)
+		apiGroup.GET("/requests/:id", pm.apiGetRequest)
+		apiGroup.GET("/queue", pm.apiGetQueue)
 		apiGroup.GET("/version", pm.apiGetVersion)
+		apiGroup.GET("/captures", pm.apiGetCaptures)
 		apiGroup.GET("/captures/:id", pm.apiGetCapture)
+		apiGroup.GET("/gpus", pm.apiGetGPUs)
+		apiGroup.GET("/peers", pm.apiGetPeers)
+		apiGroup.GET("/usage", pm.apiGetUsage)
+		apiGroup.GET("/stats", pm.apiGetStats)
+		apiGroup.GET("/errors", pm.apiGetErrors)
+		apiGroup.GET("/probes", pm.apiGetProbes)
+		apiGroup.GET("/tenants", pm.apiGetTenants)
+		apiGroup.GET("/evals", pm.apiGetEvals)
+		apiGroup.GET("/evals/:name", pm.apiGetEval)
+		apiGroup.GET("/config/snapshot", pm.apiGetConfigSnapshot)
+		apiGroup.POST("/config/reload", pm.apiReloadConfig)
+		apiGroup.GET("/drain", pm.apiGetDrain)
+		apiGroup.POST("/drain", pm.apiStartDrain)
+		apiGroup.DELETE("/drain", pm.apiStopDrain)
 	}
+
+	// MCP server for agents administering llmsnap, same protection as /api
+	pm.ginEngine.Any("/mcp", pm.apiKeyAuth(), pm.mcpHandler)
 }
 
 func (pm *ProxyManager) apiUnloadAllModels(c *gin.Context) {
@@ -69,6 +126,8 @@ func (pm *ProxyManager) getModelStatus() []Model {
 					stateStr = "starting"
 				case StateStopping:
 					stateStr = "stopping"
+				case StateDraining:
+					stateStr = "draining"
 				case StateShutdown:
 					stateStr = "shutdown"
 				case StateStopped:
@@ -79,20 +138,43 @@ func (pm *ProxyManager) getModelStatus() []Model {
 					stateStr = "asleep"
 				case StateWaking:
 					stateStr = "waking"
+				case StateFailed:
+					stateStr = "failed"
 				default:
 					stateStr = "unknown"
 				}
 				state = stateStr
 			}
 		}
-		models = append(models, Model{
+		model := Model{
 			Id:          modelID,
 			Name:        pm.config.Models[modelID].Name,
 			Description: pm.config.Models[modelID].Description,
 			State:       state,
 			Unlisted:    pm.config.Models[modelID].Unlisted,
 			SleepMode:   string(pm.config.Models[modelID].SleepMode),
-		})
+		}
+		if info, found := pm.quarantine.info(modelID); found {
+			model.Quarantine = &info
+		}
+		if processGroup != nil {
+			for _, instance := range processGroup.instancesOf(modelID) {
+				if info, found := instance.crashLoop.info(); found {
+					model.Failure = &info
+					break
+				}
+			}
+		}
+		inFlight := pm.modelInFlight(modelID)
+		model.InFlight, model.StreamingInFlight = inFlight.InFlight, inFlight.StreamingInFlight
+		if processGroup != nil {
+			if instances := processGroup.instancesOf(modelID); len(instances) > 1 {
+				for _, instance := range instances {
+					model.Instances = append(model.Instances, instance.CurrentState())
+				}
+			}
+		}
+		models = append(models, model)
 	}
 
 	// Iterate over the peer models
@@ -112,10 +194,16 @@ func (pm *ProxyManager) getModelStatus() []Model {
 
 type messageType string
 
+// uiEventQueueSize is how many events may queue up for a UI client before
+// they are dropped, so a stalled client never holds up process management
+const uiEventQueueSize = 256
+
 const (
 	msgTypeModelStatus messageType = "modelStatus"
 	msgTypeLogData     messageType = "logData"
 	msgTypeMetrics     messageType = "metrics"
+	msgTypeInFlight    messageType = "inFlight"
+	msgTypeErrors      messageType = "errors"
 )
 
 type messageEnvelope struct {
@@ -123,7 +211,10 @@ type messageEnvelope struct {
 	Data string      `json:"data"`
 }
 
-// sends a stream of different message types that happen on the server
+// sends a stream of different message types that happen on the server.
+// Every message carries an SSE id. A client that reconnects with a
+// Last-Event-ID header, or lastEventId query parameter, receives the
+// messages it missed instead of the full initial state.
 func (pm *ProxyManager) apiSendEvents(c *gin.Context) {
 	c.Header("Content-Type", "text/event-stream")
 	c.Header("Cache-Control", "no-cache")
@@ -132,98 +223,88 @@ func (pm *ProxyManager) apiSendEvents(c *gin.Context) {
 	// prevent nginx from buffering SSE
 	c.Header("X-Accel-Buffering", "no")
 
-	sendBuffer := make(chan messageEnvelope, 25)
-	ctx, cancel := context.WithCancel(c.Request.Context())
-	sendModels := func() {
-		data, err := json.Marshal(pm.getModelStatus())
-		if err == nil {
-			msg := messageEnvelope{Type: msgTypeModelStatus, Data: string(data)}
-			select {
-			case sendBuffer <- msg:
-			case <-ctx.Done():
-				return
-			default:
-			}
-
+	// subscribe before reading the history so nothing is missed in between
+	notify := make(chan struct{}, 1)
+	defer pm.uiEvents.onRecord(func() {
+		select {
+		case notify <- struct{}{}:
+		default:
 		}
-	}
+	})()
 
-	sendLogData := func(source string, data []byte) {
-		data, err := json.Marshal(gin.H{
-			"source": source,
-			"data":   string(data),
-		})
-		if err == nil {
-			select {
-			case sendBuffer <- messageEnvelope{Type: msgTypeLogData, Data: string(data)}:
-			case <-ctx.Done():
-				return
-			default:
-			}
-		}
+	lastID, err := strconv.ParseUint(c.GetHeader("Last-Event-ID"), 10, 64)
+	if err != nil {
+		lastID, err = strconv.ParseUint(c.Query("lastEventId"), 10, 64)
 	}
-
-	sendMetrics := func(metrics []TokenMetrics) {
-		jsonData, err := json.Marshal(metrics)
-		if err == nil {
-			select {
-			case sendBuffer <- messageEnvelope{Type: msgTypeMetrics, Data: string(jsonData)}:
-			case <-ctx.Done():
-				return
-			default:
-			}
-		}
+	if err != nil || !pm.sendUIEventsSince(c, &lastID) {
+		lastID = pm.sendUIEventsInitial(c)
 	}
-
-	/**
-	 * Send updated models list
-	 */
-	defer event.On(func(e ProcessStateChangeEvent) {
-		sendModels()
-	})()
-	defer event.On(func(e ConfigFileChangedEvent) {
-		sendModels()
-	})()
-
-	/**
-	 * Send Log data
-	 */
-	defer pm.proxyLogger.OnLogData(func(data []byte) {
-		sendLogData("proxy", data)
-	})()
-	defer pm.upstreamLogger.OnLogData(func(data []byte) {
-		sendLogData("upstream", data)
-	})()
-
-	/**
-	 * Send Metrics data
-	 */
-	defer event.On(func(e TokenMetricsEvent) {
-		sendMetrics([]TokenMetrics{e.Metrics})
-	})()
-
-	// send initial batch of data
-	sendLogData("proxy", pm.proxyLogger.GetHistory())
-	sendLogData("upstream", pm.upstreamLogger.GetHistory())
-	sendModels()
-	sendMetrics(pm.metricsMonitor.getMetrics())
+	c.Writer.Flush()
 
 	for {
 		select {
 		case <-c.Request.Context().Done():
-			cancel()
 			return
 		case <-pm.shutdownCtx.Done():
-			cancel()
 			return
-		case msg := <-sendBuffer:
-			c.SSEvent("message", msg)
+		case <-notify:
+			if !pm.sendUIEventsSince(c, &lastID) {
+				// fell too far behind, start over
+				lastID = pm.sendUIEventsInitial(c)
+			}
 			c.Writer.Flush()
 		}
 	}
 }
 
+// sendUIEventsSince sends the recorded events after lastID and moves lastID
+// forward. It returns false when they are no longer available.
+func (pm *ProxyManager) sendUIEventsSince(c *gin.Context, lastID *uint64) bool {
+	events, ok := pm.uiEvents.since(*lastID)
+	if !ok {
+		return false
+	}
+	for _, ev := range events {
+		c.Render(-1, sse.Event{Id: strconv.FormatUint(ev.ID, 10), Event: "message", Data: ev.Envelope})
+		*lastID = ev.ID
+	}
+	return true
+}
+
+// sendUIEventsInitial sends the current state: log history, models,
+// metrics and failed requests. It returns the id the client is caught up to.
+func (pm *ProxyManager) sendUIEventsInitial(c *gin.Context) uint64 {
+	lastID := pm.uiEvents.lastID()
+	id := strconv.FormatUint(lastID, 10)
+
+	send := func(msgType messageType, data any) {
+		jsonData, err := json.Marshal(data)
+		if err != nil {
+			return
+		}
+		c.Render(-1, sse.Event{Id: id, Event: "message", Data: messageEnvelope{Type: msgType, Data: string(jsonData)}})
+	}
+
+	send(msgTypeLogData, gin.H{"source": "proxy", "data": string(pm.proxyLogger.GetHistory())})
+	send(msgTypeLogData, gin.H{"source": "upstream", "data": string(pm.upstreamLogger.GetHistory())})
+	send(msgTypeModelStatus, pm.getModelStatus())
+	send(msgTypeMetrics, pm.metricsMonitor.getMetrics())
+	send(msgTypeErrors, pm.metricsMonitor.getErrors())
+	return lastID
+}
+
 func (pm *ProxyManager) apiGetMetrics(c *gin.Context) {
+	// the activity pulled from peers is mixed in by time when asked for
+	if c.Query("peers") == "true" && pm.peerProxy != nil {
+		metrics := withPeerActivity(pm.metricsMonitor.getMetrics(), pm.peerProxy.activity())
+		c.JSON(http.StatusOK, metricsOfClient(metrics, c.Query("client")))
+		return
+	}
+	if client := c.Query("client"); client != "" {
+		c.JSON(http.StatusOK, metricsOfClient(pm.metricsMonitor.getMetrics(), client))
+		return
+	}
+
 	jsonData, err := pm.metricsMonitor.getMetricsJSON()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get metrics"})
@@ -232,9 +313,56 @@ func (pm *ProxyManager) apiGetMetrics(c *gin.Context) {
 	c.Data(http.StatusOK, "application/json", jsonData)
 }
 
+// withPeerActivity mixes the activity of peers into metrics by time. Requests
+// sent to a peer are in both with the same request ID, they are kept once.
+func withPeerActivity(metrics, activity []TokenMetrics) []TokenMetrics {
+	local := make(map[string]bool, len(metrics))
+	for _, m := range metrics {
+		if m.RequestID != "" {
+			local[m.RequestID] = true
+		}
+	}
+	merged := slices.Clone(metrics)
+	for _, m := range activity {
+		if m.RequestID == "" || !local[m.RequestID] {
+			merged = append(merged, m)
+		}
+	}
+	sort.SliceStable(merged, func(i, j int) bool { return merged[i].Timestamp.Before(merged[j].Timestamp) })
+	return merged
+}
+
+// metricsOfClient returns the metrics of requests from client, all of them
+// when client is empty
+func metricsOfClient(metrics []TokenMetrics, client string) []TokenMetrics {
+	if client == "" {
+		return metrics
+	}
+	filtered := make([]TokenMetrics, 0, len(metrics))
+	for _, m := range metrics {
+		if m.Client == client {
+			filtered = append(filtered, m)
+		}
+	}
+	return filtered
+}
+
+// apiGetRequest returns the activity metrics of the request with the ID from
+// its access log line. Requests that failed, or were evicted after
+// metricsMaxInMemory newer ones, have none.
+func (pm *ProxyManager) apiGetRequest(c *gin.Context) {
+	id := c.Param("id")
+	metrics, found := pm.metricsMonitor.getMetricsByRequestID(id)
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("request %s not found in activity", id)})
+		return
+	}
+	c.JSON(http.StatusOK, metrics)
+}
+
 func (pm *ProxyManager) apiUnloadSingleModelHandler(c *gin.Context) {
 	requestedModel := strings.TrimPrefix(c.Param("model"), "/")
-	realModelName, found := pm.config.RealModelName(requestedModel)
+	realModelName, found := pm.realModelName(requestedModel)
 	if !found {
 		pm.sendErrorResponse(c, http.StatusNotFound, "Model not found")
 		return
@@ -256,7 +384,7 @@ func (pm *ProxyManager) apiUnloadSingleModelHandler(c *gin.Context) {
 
 func (pm *ProxyManager) apiSleepSingleModelHandler(c *gin.Context) {
 	requestedModel := strings.TrimPrefix(c.Param("model"), "/")
-	realModelName, found := pm.config.RealModelName(requestedModel)
+	realModelName, found := pm.realModelName(requestedModel)
 	if !found {
 		pm.sendErrorResponse(c, http.StatusNotFound, "Model not found")
 		return
@@ -300,3 +428,28 @@ func (pm *ProxyManager) apiGetCapture(c *gin.Context) {
 
 	c.JSON(http.StatusOK, capture)
 }
+
+// apiGetCaptures writes all stored captures as JSONL, the input of
+// `llmsnap replay`
+func (pm *ProxyManager) apiGetCaptures(c *gin.Context) {
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Status(http.StatusOK)
+	encoder := json.NewEncoder(c.Writer)
+	for _, capture := range pm.metricsMonitor.getCaptures() {
+		if err := encoder.Encode(capture); err != nil {
+			pm.proxyLogger.Errorf("unable to write capture %d: %v", capture.ID, err)
+			return
+		}
+	}
+}
+
+// apiGetGPUs lists the GPUs, their memory and temperature, and the models
+// placed on each
+func (pm *ProxyManager) apiGetGPUs(c *gin.Context) {
+	gpus, err := pm.gpus.inventory()
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gpus)
+}
@@ -1,11 +1,14 @@
 package proxy
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"net/http"
+	"regexp"
 	"strings"
 
+	"github.com/gin-contrib/sse"
 	"github.com/gin-gonic/gin"
 )
 
@@ -105,3 +108,204 @@ func (pm *ProxyManager) getLogger(logMonitorId string) (*LogMonitor, error) {
 		return nil, fmt.Errorf("invalid logger. Use 'proxy', 'upstream' or a model's ID")
 	}
 }
+
+// apiStreamModelLogs sends the stdout and stderr of one upstream process,
+// /api/logs/<model> with the process ID of an instance, like model#2, or a
+// model name. The lines can be filtered:
+//   - level=warn keeps lines with a level of warn or above
+//   - grep=cuda keeps lines matching the case insensitive regular expression
+//
+// The history is sent and the response ends, unless follow=true keeps it open
+// for new lines. Clients that accept text/event-stream get every line as a
+// "log" event, others get the lines as chunked text.
+func (pm *ProxyManager) apiStreamModelLogs(c *gin.Context) {
+	processID := strings.TrimPrefix(c.Param("model"), "/")
+	process, found := pm.findProcess(processID)
+	if !found {
+		pm.sendErrorResponse(c, http.StatusNotFound, "Model not found")
+		return
+	}
+
+	filter := &logLineFilter{level: LevelDebug}
+	if level := c.Query("level"); level != "" {
+		var ok bool
+		if filter.level, ok = parseLogLevel(level); !ok {
+			pm.sendErrorResponse(c, http.StatusBadRequest, "level must be debug, info, warn or error")
+			return
+		}
+	}
+	if err := filter.setGrep(c.Query("grep")); err != nil {
+		pm.sendErrorResponse(c, http.StatusBadRequest, fmt.Sprintf("invalid grep: %v", err))
+		return
+	}
+
+	useSSE := strings.Contains(c.GetHeader("Accept"), "text/event-stream")
+	if useSSE {
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+	} else {
+		c.Header("Content-Type", "text/plain")
+		c.Header("Transfer-Encoding", "chunked")
+	}
+	c.Header("X-Content-Type-Options", "nosniff")
+	// prevent nginx from buffering streamed logs
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	send := func(lines []string) {
+		for _, line := range lines {
+			if useSSE {
+				c.Render(-1, sse.Event{Event: "log", Data: line})
+			} else {
+				c.Writer.WriteString(line + "\n")
+			}
+		}
+		c.Writer.Flush()
+	}
+
+	logger := process.Logger()
+	follow := c.Query("follow") == "true"
+
+	// subscribe before reading the history so nothing is missed in between
+	sendChan := make(chan []byte, 10)
+	if follow {
+		ctx, cancel := context.WithCancel(c.Request.Context())
+		unsubscribe := logger.OnLogData(func(data []byte) {
+			select {
+			case sendChan <- data:
+			case <-ctx.Done():
+			}
+		})
+		defer func() {
+			cancel()
+			unsubscribe()
+		}()
+	}
+
+	if _, skipHistory := c.GetQuery("no-history"); !skipHistory {
+		send(filter.lines(logger.GetHistory()))
+	}
+	if !follow {
+		// the last line may not be complete yet
+		send(filter.flush())
+		return
+	}
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-pm.shutdownCtx.Done():
+			return
+		case data := <-sendChan:
+			send(filter.lines(data))
+		}
+	}
+}
+
+// findProcess returns the process with the ID or the process a model name
+// is served by
+func (pm *ProxyManager) findProcess(id string) (*Process, bool) {
+	for _, processGroup := range pm.processGroups {
+		for _, process := range processGroup.instances() {
+			if process.ID == id {
+				return process, true
+			}
+		}
+	}
+	if realModelName, found := pm.realModelName(id); found {
+		if processGroup := pm.findGroupByModelName(realModelName); processGroup != nil {
+			return processGroup.GetMember(realModelName)
+		}
+	}
+	return nil, false
+}
+
+// logLineFilter splits log data into lines and keeps the lines that pass
+// the filters. A line that is not complete is held until its end arrives.
+type logLineFilter struct {
+	level   LogLevel
+	grep    *regexp.Regexp
+	partial []byte
+}
+
+// setGrep keeps only the lines matching the case insensitive regular
+// expression, "" keeps all lines
+func (f *logLineFilter) setGrep(expr string) error {
+	if expr == "" {
+		f.grep = nil
+		return nil
+	}
+	re, err := regexp.Compile("(?i)" + expr)
+	if err != nil {
+		return err
+	}
+	f.grep = re
+	return nil
+}
+
+func (f *logLineFilter) lines(data []byte) []string {
+	f.partial = append(f.partial, data...)
+	var lines []string
+	for {
+		end := bytes.IndexByte(f.partial, '\n')
+		if end < 0 {
+			break
+		}
+		line := strings.TrimRight(string(f.partial[:end]), "\r")
+		f.partial = f.partial[end+1:]
+		if f.keep(line) {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// flush returns the incomplete line when it passes the filters
+func (f *logLineFilter) flush() []string {
+	line := string(f.partial)
+	f.partial = nil
+	if line == "" || !f.keep(line) {
+		return nil
+	}
+	return []string{line}
+}
+
+func (f *logLineFilter) keep(line string) bool {
+	if f.level > LevelDebug && logLineLevel(line) < f.level {
+		return false
+	}
+	return f.grep == nil || f.grep.MatchString(line)
+}
+
+// logLineLevel guesses the level of an upstream log line from its words,
+// upstreams do not share a log format
+func logLineLevel(line string) LogLevel {
+	lower := strings.ToLower(line)
+	switch {
+	case strings.Contains(lower, "error"), strings.Contains(lower, "fatal"),
+		strings.Contains(lower, "panic"), strings.Contains(lower, "failed"):
+		return LevelError
+	case strings.Contains(lower, "warn"):
+		return LevelWarn
+	case strings.Contains(lower, "debug"):
+		return LevelDebug
+	default:
+		return LevelInfo
+	}
+}
+
+func parseLogLevel(level string) (LogLevel, bool) {
+	switch strings.ToLower(level) {
+	case "debug":
+		return LevelDebug, true
+	case "info":
+		return LevelInfo, true
+	case "warn", "warning":
+		return LevelWarn, true
+	case "error":
+		return LevelError, true
+	default:
+		return LevelInfo, false
+	}
+}
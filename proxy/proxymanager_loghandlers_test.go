@@ -0,0 +1,94 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogLineFilter(t *testing.T) {
+	filter := &logLineFilter{level: LevelWarn}
+	assert.Empty(t, filter.lines([]byte("load_tensors: loading model\nWARN: low mem")))
+	assert.Equal(t, []string{"WARN: low memory", "CUDA error: out of memory"},
+		filter.lines([]byte("ory\r\nCUDA error: out of memory\nready\n")))
+
+	filter = &logLineFilter{level: LevelDebug}
+	assert.NoError(t, filter.setGrep("cuda"))
+	assert.Equal(t, []string{"ggml_cuda_init: found 1 CUDA devices"},
+		filter.lines([]byte("ggml_cuda_init: found 1 CUDA devices\nmain: server is listening\nusing CUDA")))
+	assert.Equal(t, []string{"using CUDA"}, filter.flush())
+	assert.Empty(t, filter.flush())
+
+	assert.Error(t, filter.setGrep("("))
+}
+
+func TestProxyManager_ModelLogs(t *testing.T) {
+	cfg := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]config.ModelConfig{
+			"model1": getTestSimpleResponderConfig("model1"),
+			"model2": getTestSimpleResponderConfig("model2"),
+		},
+		LogLevel: "error",
+	})
+	proxy := New(cfg)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	logger := proxy.processGroups[config.DEFAULT_GROUP_ID].processes["model1"].Logger()
+	logger.Write([]byte("ggml_cuda_init: found 1 CUDA devices\nwarning: cuda graphs disabled\nmain: server is listening\n"))
+	proxy.processGroups[config.DEFAULT_GROUP_ID].processes["model2"].Logger().Write([]byte("model2 cuda line\n"))
+
+	get := func(path string) *TestResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		rec := CreateTestResponseRecorder()
+		proxy.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("history", func(t *testing.T) {
+		rec := get("/api/logs/model1")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "ggml_cuda_init: found 1 CUDA devices\nwarning: cuda graphs disabled\nmain: server is listening\n", rec.Body.String())
+	})
+
+	t.Run("filtered", func(t *testing.T) {
+		assert.Equal(t, "ggml_cuda_init: found 1 CUDA devices\nwarning: cuda graphs disabled\n", get("/api/logs/model1?grep=CUDA").Body.String())
+		assert.Equal(t, "warning: cuda graphs disabled\n", get("/api/logs/model1?level=warn&grep=cuda").Body.String())
+	})
+
+	t.Run("bad requests", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, get("/api/logs/nope").Code)
+		assert.Equal(t, http.StatusBadRequest, get("/api/logs/model1?level=loud").Code)
+		assert.Equal(t, http.StatusBadRequest, get("/api/logs/model1?grep=(").Code)
+	})
+
+	t.Run("follow", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		defer cancel()
+		req := httptest.NewRequest("GET", "/api/logs/model1?follow=true&no-history&grep=cuda", nil).WithContext(ctx)
+		req.Header.Set("Accept", "text/event-stream")
+		rec := CreateTestResponseRecorder()
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			proxy.ServeHTTP(rec, req)
+		}()
+
+		// wait for the stream to subscribe
+		time.Sleep(100 * time.Millisecond)
+		logger.Write([]byte("llama_model_load: "))
+		logger.Write([]byte("offloading to CUDA\nother line\n"))
+		<-done
+
+		assert.Equal(t, "text/event-stream", rec.Header().Get("Content-Type"))
+		assert.Equal(t, "event:log\ndata:llama_model_load: offloading to CUDA\n\n", rec.Body.String())
+		assert.False(t, strings.Contains(rec.Body.String(), "model2"))
+	})
+}
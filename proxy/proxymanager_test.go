@@ -909,6 +909,38 @@ func TestProxyManager_UseModelName(t *testing.T) {
 	})
 }
 
+func TestProxyManager_AliasUseModelName(t *testing.T) {
+	configStr := fmt.Sprintf(`
+logLevel: error
+models:
+  model1:
+    cmd: %s -port ${PORT} -silent -respond model1
+    useModelName: org/base
+    aliases:
+      - plain-alias
+      - name: coder
+        useModelName: org/coder-lora
+`, getSimpleResponderPath())
+
+	conf, err := config.LoadConfigFromReader(strings.NewReader(configStr))
+	assert.NoError(t, err)
+
+	proxy := New(conf)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	for requested, upstream := range map[string]string{
+		"model1":      "org/base",
+		"plain-alias": "org/base",
+		"coder":       "org/coder-lora",
+	} {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model":"`+requested+`"}`))
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, upstream, gjson.Get(gjson.Get(w.Body.String(), "request_body").String(), "model").String(), requested)
+	}
+}
+
 func TestProxyManager_AudioVoicesGETHandler(t *testing.T) {
 	conf := config.AddDefaultGroupToConfig(config.Config{
 		HealthCheckTimeout: 15,
@@ -1204,6 +1236,141 @@ models:
 	assert.Equal(t, StateReady, proxy.processGroups["preloadTestGroup"].processes["model2"].CurrentState())
 }
 
+func TestProxyManager_StartupHooksParallel(t *testing.T) {
+	configStr := strings.Replace(`
+logLevel: error
+hooks:
+  on_startup:
+    parallelism: 4
+    preload:
+      - model1
+      - model2
+      - model3
+      - model4
+groups:
+  together:
+    swap: false
+    exclusive: false
+    members:
+       - model1
+       - model2
+  swapped:
+    swap: true
+    exclusive: false
+    members:
+       - model3
+       - model4
+models:
+  model1:
+    cmd: ${simpleresponderpath} --port ${PORT} --silent --respond model1
+  model2:
+    cmd: ${simpleresponderpath} --port ${PORT} --silent --respond model2
+  model3:
+    cmd: ${simpleresponderpath} --port ${PORT} --silent --respond model3
+  model4:
+    cmd: ${simpleresponderpath} --port ${PORT} --silent --respond model4
+`, "${simpleresponderpath}", simpleResponderPath, -1)
+
+	config, err := config.LoadConfigFromReader(strings.NewReader(configStr))
+	if !assert.NoError(t, err, "Invalid configuration") {
+		return
+	}
+
+	preloadChan := make(chan ModelPreloadedEvent, 4)
+	unsub := event.On(func(e ModelPreloadedEvent) {
+		preloadChan <- e
+	})
+	defer unsub()
+
+	proxy := New(config)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	for i := 0; i < 4; i++ {
+		select {
+		case e := <-preloadChan:
+			assert.True(t, e.Success, "preload of %s failed", e.ModelName)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for models to preload")
+		}
+	}
+
+	// non-swap members load together
+	assert.Equal(t, StateReady, proxy.processGroups["together"].processes["model1"].CurrentState())
+	assert.Equal(t, StateReady, proxy.processGroups["together"].processes["model2"].CurrentState())
+
+	// swap group members are loaded in order, the last one wins
+	assert.Equal(t, StateStopped, proxy.processGroups["swapped"].processes["model3"].CurrentState())
+	assert.Equal(t, StateReady, proxy.processGroups["swapped"].processes["model4"].CurrentState())
+}
+
+func TestProxyManager_StartupHooksParallelExclusive(t *testing.T) {
+	configStr := strings.Replace(`
+logLevel: error
+hooks:
+  on_startup:
+    parallelism: 4
+    preload:
+      - model1
+      - model2
+      - model3
+groups:
+  first:
+    swap: false
+    exclusive: false
+    members:
+       - model1
+  exclusive:
+    swap: false
+    exclusive: true
+    members:
+       - model2
+  last:
+    swap: false
+    exclusive: false
+    members:
+       - model3
+models:
+  model1:
+    cmd: ${simpleresponderpath} --port ${PORT} --silent --respond model1
+  model2:
+    cmd: ${simpleresponderpath} --port ${PORT} --silent --respond model2
+  model3:
+    cmd: ${simpleresponderpath} --port ${PORT} --silent --respond model3
+`, "${simpleresponderpath}", simpleResponderPath, -1)
+
+	config, err := config.LoadConfigFromReader(strings.NewReader(configStr))
+	if !assert.NoError(t, err, "Invalid configuration") {
+		return
+	}
+
+	preloadChan := make(chan ModelPreloadedEvent, 3)
+	unsub := event.On(func(e ModelPreloadedEvent) {
+		preloadChan <- e
+	})
+	defer unsub()
+
+	proxy := New(config)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	var order []string
+	for i := 0; i < 3; i++ {
+		select {
+		case e := <-preloadChan:
+			assert.True(t, e.Success, "preload of %s failed", e.ModelName)
+			order = append(order, e.ModelName)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for models to preload")
+		}
+	}
+
+	// the exclusive group is loaded after model1 and before model3, like
+	// loading them one after the other
+	assert.Equal(t, []string{"model1", "model2", "model3"}, order)
+	assert.Equal(t, StateStopped, proxy.processGroups["first"].processes["model1"].CurrentState())
+	assert.Equal(t, StateReady, proxy.processGroups["exclusive"].processes["model2"].CurrentState())
+	assert.Equal(t, StateReady, proxy.processGroups["last"].processes["model3"].CurrentState())
+}
+
 func TestProxyManager_StreamingEndpointsReturnNoBufferingHeader(t *testing.T) {
 	config := config.AddDefaultGroupToConfig(config.Config{
 		HealthCheckTimeout: 15,
@@ -1223,6 +1390,7 @@ func TestProxyManager_StreamingEndpointsReturnNoBufferingHeader(t *testing.T) {
 		"/logs/stream/proxy",
 		"/logs/stream/upstream",
 		"/logs/stream/author/model",
+		"/api/logs/author/model?follow=true",
 	}
 
 	for _, endpoint := range endpoints {
@@ -1663,3 +1831,38 @@ models:
 		assert.Equal(t, "no", w.Header().Get("X-Accel-Buffering"))
 	})
 }
+
+func TestProxyManager_RequiresCoLoading(t *testing.T) {
+	chat := getTestSimpleResponderConfig("chat")
+	chat.Requires = []string{"embed"}
+	conf := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]config.ModelConfig{
+			"chat":  chat,
+			"embed": getTestSimpleResponderConfig("embed"),
+		},
+		Groups: map[string]config.GroupConfig{
+			"chat":  {Swap: true, Exclusive: true, Members: []string{"chat"}},
+			"embed": {Swap: true, Exclusive: true, Members: []string{"embed"}},
+		},
+		LogLevel: "error",
+	})
+
+	proxy := New(conf)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model":"chat"}`))
+	w := CreateTestResponseRecorder()
+	proxy.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// loaded in the background and not idled by the exclusive chat group
+	embed := proxy.processGroups["embed"].processes["embed"]
+	assert.Eventually(t, func() bool { return embed.CurrentState() == StateReady }, 5*time.Second, 10*time.Millisecond)
+
+	req = httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model":"chat"}`))
+	w = CreateTestResponseRecorder()
+	proxy.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, StateReady, embed.CurrentState())
+}
@@ -0,0 +1,128 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/napmany/llmsnap/event"
+	"github.com/napmany/llmsnap/proxy/config"
+)
+
+// QuarantineInfo is why and since when a model is quarantined
+type QuarantineInfo struct {
+	Since  time.Time `json:"since"`
+	Reason string    `json:"reason"`
+}
+
+// modelQuarantine counts model failures and quarantines models that fail
+// maxFailures times within the window
+type modelQuarantine struct {
+	sync.Mutex
+	config config.QuarantineConfig
+
+	// failure times within the window by model ID
+	failures map[string][]time.Time
+
+	quarantined map[string]QuarantineInfo
+}
+
+func newModelQuarantine(conf config.QuarantineConfig) *modelQuarantine {
+	return &modelQuarantine{
+		config:      conf,
+		failures:    make(map[string][]time.Time),
+		quarantined: make(map[string]QuarantineInfo),
+	}
+}
+
+// recordFailure counts a failure of modelID at now and returns true when it
+// puts the model in quarantine
+func (q *modelQuarantine) recordFailure(modelID string, err error, now time.Time) bool {
+	q.Lock()
+	defer q.Unlock()
+
+	if _, found := q.quarantined[modelID]; found {
+		return false
+	}
+
+	cutoff := now.Add(-q.config.WindowDuration())
+	failures := []time.Time{now}
+	for _, failure := range q.failures[modelID] {
+		if failure.After(cutoff) {
+			failures = append(failures, failure)
+		}
+	}
+	if len(failures) < q.config.MaxFailures {
+		q.failures[modelID] = failures
+		return false
+	}
+
+	delete(q.failures, modelID)
+	q.quarantined[modelID] = QuarantineInfo{
+		Since:  now,
+		Reason: fmt.Sprintf("%d failures within %s, last: %v", len(failures), q.config.WindowDuration(), err),
+	}
+	return true
+}
+
+// info returns the quarantine of modelID, found is false when it is not
+// quarantined
+func (q *modelQuarantine) info(modelID string) (info QuarantineInfo, found bool) {
+	if q == nil {
+		return QuarantineInfo{}, false
+	}
+	q.Lock()
+	defer q.Unlock()
+	info, found = q.quarantined[modelID]
+	return info, found
+}
+
+// release takes modelID out of quarantine and forgets its failures, it
+// returns false when the model was not quarantined
+func (q *modelQuarantine) release(modelID string) bool {
+	q.Lock()
+	defer q.Unlock()
+	delete(q.failures, modelID)
+	if _, found := q.quarantined[modelID]; !found {
+		return false
+	}
+	delete(q.quarantined, modelID)
+	return true
+}
+
+// modelFailed counts a failure of modelID, see config.QuarantineConfig
+func (pm *ProxyManager) modelFailed(modelID string, err error) {
+	if !pm.quarantine.recordFailure(modelID, err, time.Now()) {
+		return
+	}
+	pm.proxyLogger.Warnf("<%s> quarantined after repeated failures, enable it again with POST /api/models/enable/%s", modelID, modelID)
+	event.Emit(QuarantineChangedEvent{ModelName: modelID, Quarantined: true})
+}
+
+// checkQuarantine returns an error when modelID is quarantined
+func (pm *ProxyManager) checkQuarantine(modelID string) error {
+	info, found := pm.quarantine.info(modelID)
+	if !found {
+		return nil
+	}
+	return fmt.Errorf("model %s is quarantined since %s after %s", modelID, info.Since.Format(time.RFC3339), info.Reason)
+}
+
+// apiEnableModelHandler takes a model out of quarantine
+func (pm *ProxyManager) apiEnableModelHandler(c *gin.Context) {
+	requestedModel := strings.TrimPrefix(c.Param("model"), "/")
+	realModelName, found := pm.realModelName(requestedModel)
+	if !found {
+		pm.sendErrorResponse(c, http.StatusNotFound, "Model not found")
+		return
+	}
+
+	if pm.quarantine != nil && pm.quarantine.release(realModelName) {
+		pm.proxyLogger.Infof("<%s> enabled again, no longer quarantined", realModelName)
+		event.Emit(QuarantineChangedEvent{ModelName: realModelName, Quarantined: false})
+	}
+	c.String(http.StatusOK, "OK")
+}
@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestModelQuarantine_RecordFailure(t *testing.T) {
+	q := newModelQuarantine(config.QuarantineConfig{MaxFailures: 3, Window: 60})
+	now := time.Now()
+	crash := errors.New("crashed")
+
+	// failures outside the window are forgotten
+	assert.False(t, q.recordFailure("model1", crash, now.Add(-2*time.Minute)))
+	assert.False(t, q.recordFailure("model1", crash, now.Add(-30*time.Second)))
+	assert.False(t, q.recordFailure("model1", crash, now))
+	_, found := q.info("model1")
+	assert.False(t, found)
+
+	assert.True(t, q.recordFailure("model1", crash, now.Add(time.Second)))
+	info, found := q.info("model1")
+	require.True(t, found)
+	assert.Contains(t, info.Reason, "3 failures within 1m0s, last: crashed")
+
+	// already quarantined
+	assert.False(t, q.recordFailure("model1", crash, now.Add(2*time.Second)))
+
+	assert.True(t, q.release("model1"))
+	assert.False(t, q.release("model1"))
+	_, found = q.info("model1")
+	assert.False(t, found)
+}
+
+func TestProxyManager_Quarantine(t *testing.T) {
+	conf := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]config.ModelConfig{
+			"broken": {
+				Cmd:   "/nonexistent/llmsnap-test-server --port 12345",
+				Proxy: "http://127.0.0.1:12345",
+			},
+		},
+		Quarantine: config.QuarantineConfig{MaxFailures: 2},
+		LogLevel:   "error",
+	})
+
+	proxy := New(conf)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	chat := func() *TestResponseRecorder {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model":"broken"}`))
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		return w
+	}
+
+	for range 2 {
+		w := chat()
+		assert.NotEqual(t, http.StatusOK, w.Code)
+		assert.NotContains(t, w.Body.String(), "quarantined")
+	}
+
+	w := chat()
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), "model broken is quarantined")
+	assert.Empty(t, w.Header().Get("Retry-After"))
+
+	// flagged in /v1/models and the model status
+	req := httptest.NewRequest("GET", "/v1/models", nil)
+	w = CreateTestResponseRecorder()
+	proxy.ServeHTTP(w, req)
+	var list struct {
+		Data []map[string]any `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	require.Len(t, list.Data, 1)
+	assert.Equal(t, true, list.Data[0]["quarantined"])
+	require.NotNil(t, proxy.getModelStatus()[0].Quarantine)
+
+	req = httptest.NewRequest("POST", "/api/models/enable/broken", nil)
+	w = CreateTestResponseRecorder()
+	proxy.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Nil(t, proxy.getModelStatus()[0].Quarantine)
+
+	w = chat()
+	assert.NotContains(t, w.Body.String(), "quarantined")
+}
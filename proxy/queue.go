@@ -0,0 +1,301 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/napmany/llmsnap/proxy/config"
+)
+
+// errQueueTimeout cancels the context of a request that waited longer than
+// its model's queue timeout
+var errQueueTimeout = errors.New("waited too long in the queue")
+
+// ModelQueue is the queue of a model as reported by /api/queue
+type ModelQueue struct {
+	Model   string `json:"model"`
+	Waiting int    `json:"waiting"`
+
+	// number of requests that may wait, 0 when unlimited
+	MaxDepth int `json:"max_depth"`
+
+	// guess of how long a new request waits before the upstream starts on
+	// it, from recent load and inference durations
+	EstimatedWaitMs int64 `json:"estimated_wait_ms"`
+
+	// request of another model that has to finish before this model can be
+	// swapped in, null when no swap is blocked
+	BlockedBy *QueueBlocker `json:"blocked_by"`
+
+	// waiting requests, longest waiting first
+	Requests []QueuedRequest `json:"requests"`
+}
+
+// QueuedRequest is a request waiting for a slot or for its model to load
+type QueuedRequest struct {
+	RequestID string `json:"request_id"`
+	WaitingMs int64  `json:"waiting_ms"`
+}
+
+// QueueBlocker is a running request that keeps a swap from happening
+type QueueBlocker struct {
+	RequestID string `json:"request_id"`
+	Model     string `json:"model"`
+	RunningMs int64  `json:"running_ms"`
+}
+
+// requestQueue tracks requests for local models from when they arrive until
+// they are done. They wait until the upstream starts working on them.
+type requestQueue struct {
+	mu       sync.Mutex
+	requests map[*trackedRequest]struct{}
+
+	// moving average of how long each model works on a request, in
+	// nanoseconds
+	served map[string]*atomic.Int64
+}
+
+type trackedRequest struct {
+	id      string
+	model   string
+	arrived time.Time
+
+	// zero while the request waits
+	started time.Time
+}
+
+func newRequestQueue() *requestQueue {
+	return &requestQueue{
+		requests: make(map[*trackedRequest]struct{}),
+		served:   make(map[string]*atomic.Int64),
+	}
+}
+
+// track adds the request to the queue until the returned func is called. It
+// waits until requestStarted is called with it. Nothing is tracked and ok is
+// false when conf.MaxDepth requests for modelID are already waiting. The
+// context of a request waiting longer than conf's timeout is cancelled with
+// errQueueTimeout.
+func (q *requestQueue) track(c *gin.Context, modelID string, conf config.QueueConfig) (done func(), ok bool) {
+	tracked := &trackedRequest{
+		id:      requestID(c.Request),
+		model:   modelID,
+		arrived: time.Now(),
+	}
+
+	q.mu.Lock()
+	if conf.MaxDepth > 0 && q.waitingFor(modelID) >= conf.MaxDepth {
+		q.mu.Unlock()
+		return nil, false
+	}
+	q.requests[tracked] = struct{}{}
+	q.mu.Unlock()
+
+	ctx := c.Request.Context()
+	var timeout *time.Timer
+	cancel := func(error) {}
+	if conf.Timeout > 0 {
+		ctx, cancel = context.WithCancelCause(ctx)
+		timeout = time.AfterFunc(conf.TimeoutDuration(), func() {
+			q.mu.Lock()
+			defer q.mu.Unlock()
+			if tracked.started.IsZero() {
+				cancel(errQueueTimeout)
+			}
+		})
+	}
+
+	started := func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		if tracked.started.IsZero() {
+			tracked.started = time.Now()
+		}
+	}
+	c.Request = c.Request.WithContext(context.WithValue(ctx, proxyCtxKey("started"), started))
+
+	return func() {
+		if timeout != nil {
+			timeout.Stop()
+		}
+		cancel(nil)
+
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		delete(q.requests, tracked)
+		if tracked.started.IsZero() {
+			return
+		}
+		avg, found := q.served[modelID]
+		if !found {
+			avg = &atomic.Int64{}
+			q.served[modelID] = avg
+		}
+		observeDuration(avg, time.Since(tracked.started))
+	}, true
+}
+
+// waitingFor returns the number of waiting requests for modelID, q.mu must
+// be held
+func (q *requestQueue) waitingFor(modelID string) int {
+	count := 0
+	for tracked := range q.requests {
+		if tracked.model == modelID && tracked.started.IsZero() {
+			count++
+		}
+	}
+	return count
+}
+
+// requestStarted marks a tracked request as no longer waiting
+func requestStarted(r *http.Request) {
+	if started, ok := r.Context().Value(proxyCtxKey("started")).(func()); ok {
+		started()
+	}
+}
+
+// queueTimedOut reports if r waited longer than its model's queue timeout
+func queueTimedOut(r *http.Request) bool {
+	return errors.Is(context.Cause(r.Context()), errQueueTimeout)
+}
+
+// rejectQueueTimeout sends the 503 for a request that waited longer than
+// its model's queue timeout
+func rejectQueueTimeout(w http.ResponseWriter, modelID string) {
+	http.Error(w, fmt.Sprintf("request for %s waited too long in the queue", modelID), http.StatusServiceUnavailable)
+}
+
+// enqueue tracks a request for modelID in the queue until the returned func
+// is called. When the model's queue is full a 429 with Retry-After has been
+// sent and ok is false.
+func (pm *ProxyManager) enqueue(c *gin.Context, modelID string) (done func(), ok bool) {
+	conf := pm.config.Models[modelID].Queue
+	if done, ok = pm.queue.track(c, modelID, conf); ok {
+		return done, true
+	}
+
+	pm.proxyLogger.Infof("<%s> queue is full with %d waiting requests, rejecting", modelID, conf.MaxDepth)
+	retryAfter := pm.estimatedWait(modelID) + pm.queue.servedDuration(modelID)
+	c.Header("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+	pm.sendErrorResponse(c, http.StatusTooManyRequests, fmt.Sprintf("queue for %s is full", modelID))
+	return nil, false
+}
+
+// snapshot returns copies of the waiting and the running requests, each
+// oldest first
+func (q *requestQueue) snapshot() (waiting, running []trackedRequest) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for tracked := range q.requests {
+		if tracked.started.IsZero() {
+			waiting = append(waiting, *tracked)
+		} else {
+			running = append(running, *tracked)
+		}
+	}
+	sort.Slice(waiting, func(i, j int) bool { return waiting[i].arrived.Before(waiting[j].arrived) })
+	sort.Slice(running, func(i, j int) bool { return running[i].started.Before(running[j].started) })
+	return waiting, running
+}
+
+// servedDuration returns the average time modelID works on a request, 0 when
+// it has not served any yet
+func (q *requestQueue) servedDuration(modelID string) time.Duration {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if avg, found := q.served[modelID]; found {
+		return time.Duration(avg.Load())
+	}
+	return 0
+}
+
+// swapBlocks tells if a request for modelID can only be served once a
+// request for other is done, as other has to be stopped to load modelID
+func (pm *ProxyManager) swapBlocks(modelID, other string) bool {
+	if modelID == other {
+		return false
+	}
+	processGroup := pm.findGroupByModelName(modelID)
+	if processGroup == nil {
+		return false
+	}
+	if process, found := processGroup.processes[modelID]; found && process.CurrentState() == StateReady {
+		return false
+	}
+
+	otherGroup := pm.findGroupByModelName(other)
+	if otherGroup == nil {
+		return false
+	}
+	if otherGroup == processGroup {
+		return processGroup.swap
+	}
+	return processGroup.exclusive && !otherGroup.persistent
+}
+
+// queueStatus returns the queue of each model with waiting requests, sorted
+// by model
+func (pm *ProxyManager) queueStatus() []ModelQueue {
+	now := time.Now()
+	waiting, running := pm.queue.snapshot()
+
+	byModel := map[string]int{}
+	queues := []ModelQueue{}
+	for _, tracked := range waiting {
+		index, found := byModel[tracked.model]
+		if !found {
+			index = len(queues)
+			byModel[tracked.model] = index
+			queues = append(queues, ModelQueue{
+				Model:    tracked.model,
+				MaxDepth: pm.config.Models[tracked.model].Queue.MaxDepth,
+				Requests: []QueuedRequest{},
+			})
+		}
+		queue := &queues[index]
+		queue.Waiting++
+		queue.Requests = append(queue.Requests, QueuedRequest{
+			RequestID: tracked.id,
+			WaitingMs: now.Sub(tracked.arrived).Milliseconds(),
+		})
+	}
+
+	for i := range queues {
+		queue := &queues[i]
+		wait := pm.estimatedWait(queue.Model)
+
+		// the oldest running request of a model in the way holds up the swap
+		for _, blocker := range running {
+			if !pm.swapBlocks(queue.Model, blocker.model) {
+				continue
+			}
+			runningFor := now.Sub(blocker.started)
+			queue.BlockedBy = &QueueBlocker{
+				RequestID: blocker.id,
+				Model:     blocker.model,
+				RunningMs: runningFor.Milliseconds(),
+			}
+			wait += max(pm.queue.servedDuration(blocker.model)-runningFor, 0)
+			break
+		}
+		queue.EstimatedWaitMs = wait.Milliseconds()
+	}
+
+	sort.Slice(queues, func(i, j int) bool { return queues[i].Model < queues[j].Model })
+	return queues
+}
+
+// apiGetQueue returns why requests are pending: per model the waiting
+// requests, the queue depth, the estimated wait and the request blocking a
+// swap
+func (pm *ProxyManager) apiGetQueue(c *gin.Context) {
+	c.JSON(http.StatusOK, pm.queueStatus())
+}
@@ -0,0 +1,170 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestQueue_Track(t *testing.T) {
+	queue := newRequestQueue()
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/v1/chat/completions", nil)
+	done, ok := queue.track(c, "model1", config.QueueConfig{})
+	require.True(t, ok)
+
+	waiting, running := queue.snapshot()
+	assert.Len(t, waiting, 1)
+	assert.Empty(t, running)
+
+	requestStarted(c.Request)
+	waiting, running = queue.snapshot()
+	assert.Empty(t, waiting)
+	require.Len(t, running, 1)
+	assert.Equal(t, "model1", running[0].model)
+
+	done()
+	waiting, running = queue.snapshot()
+	assert.Empty(t, waiting)
+	assert.Empty(t, running)
+	assert.Greater(t, queue.servedDuration("model1"), time.Duration(0))
+	assert.Zero(t, queue.servedDuration("model2"))
+}
+
+func TestProxyManager_QueueStatus(t *testing.T) {
+	conf := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]config.ModelConfig{
+			"model1": getTestSimpleResponderConfig("model1"),
+			"model2": getTestSimpleResponderConfig("model2"),
+		},
+		LogLevel: "error",
+	})
+
+	proxy := New(conf)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	getQueue := func() []ModelQueue {
+		req := httptest.NewRequest("GET", "/api/queue", nil)
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		var queues []ModelQueue
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &queues))
+		return queues
+	}
+
+	chat := func(model, query string) {
+		req := httptest.NewRequest("POST", "/v1/chat/completions"+query, bytes.NewBufferString(`{"model":"`+model+`"}`))
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+
+	assert.Empty(t, getQueue())
+
+	// load model1 so the slow request starts right away
+	chat("model1", "")
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		chat("model1", "?wait=1500ms")
+	}()
+	assert.Eventually(t, func() bool {
+		return proxy.processGroups[config.DEFAULT_GROUP_ID].processes["model1"].inFlightRequestsCount.Load() == 1
+	}, 2*time.Second, 10*time.Millisecond)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		chat("model2", "")
+	}()
+
+	var queues []ModelQueue
+	require.Eventually(t, func() bool {
+		queues = getQueue()
+		return len(queues) == 1
+	}, 2*time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, "model2", queues[0].Model)
+	assert.Equal(t, 1, queues[0].Waiting)
+	require.Len(t, queues[0].Requests, 1)
+	assert.Len(t, queues[0].Requests[0].RequestID, 16)
+	require.NotNil(t, queues[0].BlockedBy)
+	assert.Equal(t, "model1", queues[0].BlockedBy.Model)
+	assert.Len(t, queues[0].BlockedBy.RequestID, 16)
+
+	wg.Wait()
+	assert.Empty(t, getQueue())
+}
+
+func TestProxyManager_QueueLimits(t *testing.T) {
+	model2 := getTestSimpleResponderConfig("model2")
+	model2.Queue = config.QueueConfig{MaxDepth: 1, Timeout: 1}
+	conf := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]config.ModelConfig{
+			"model1": getTestSimpleResponderConfig("model1"),
+			"model2": model2,
+		},
+		LogLevel: "error",
+	})
+
+	proxy := New(conf)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	chat := func(model, query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/v1/chat/completions"+query, bytes.NewBufferString(`{"model":"`+model+`"}`))
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		return w.ResponseRecorder
+	}
+
+	// model1 holds the group so requests for model2 have to wait
+	require.Equal(t, http.StatusOK, chat("model1", "").Code)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		assert.Equal(t, http.StatusOK, chat("model1", "?wait=2500ms").Code)
+	}()
+	require.Eventually(t, func() bool {
+		return proxy.processGroups[config.DEFAULT_GROUP_ID].processes["model1"].inFlightRequestsCount.Load() == 1
+	}, 2*time.Second, 10*time.Millisecond)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		start := time.Now()
+		w := chat("model2", "")
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.Contains(t, w.Body.String(), "waited too long in the queue")
+		assert.Less(t, time.Since(start), 2*time.Second)
+	}()
+	require.Eventually(t, func() bool {
+		waiting, _ := proxy.queue.snapshot()
+		return len(waiting) == 1
+	}, 2*time.Second, 10*time.Millisecond)
+
+	// the queue of model2 is full
+	w := chat("model2", "")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+
+	wg.Wait()
+	waiting, running := proxy.queue.snapshot()
+	assert.Empty(t, waiting)
+	assert.Empty(t, running)
+}
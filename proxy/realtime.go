@@ -0,0 +1,119 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// isWebSocketUpgrade reports if r asks to switch the connection to a
+// websocket
+func isWebSocketUpgrade(r *http.Request) bool {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return false
+	}
+	for _, value := range r.Header.Values("Connection") {
+		for token := range strings.SplitSeq(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// loadForSocket loads modelID with a plain request before a socket is
+// forwarded to it, a socket that swaps the group would hold it for as long as
+// the socket is open. When the model does not load the error has been sent
+// and false is returned.
+func (pm *ProxyManager) loadForSocket(c *gin.Context, modelID string, processGroup *ProcessGroup) bool {
+	if pm.modelState(modelID) == StateReady {
+		return true
+	}
+	req, _ := http.NewRequestWithContext(c.Request.Context(), "GET", "/", nil)
+	processGroup.ProxyRequest(modelID, &DiscardWriter{}, req)
+	if state := pm.modelState(modelID); state != StateReady {
+		pm.sendErrorResponse(c, http.StatusBadGateway, fmt.Sprintf("unable to load model %s, state is %s", modelID, state))
+		return false
+	}
+	return true
+}
+
+// proxyRealtimeHandler routes OpenAI Realtime API sockets, opened on
+// /v1/realtime?model=, to the backend of the model. The model is loaded
+// before the upgrade is forwarded so the socket only opens once the backend
+// is ready, and it stays loaded while the socket is open. Sockets do not take
+// a scheduler slot as they can be open for a long time.
+func (pm *ProxyManager) proxyRealtimeHandler(c *gin.Context) {
+	if !isWebSocketUpgrade(c.Request) {
+		pm.sendErrorResponse(c, http.StatusBadRequest, "expected a websocket upgrade")
+		return
+	}
+
+	requestedModel := queryModel(c.Request)
+	if requestedModel == "" {
+		pm.sendErrorResponse(c, http.StatusBadRequest, "missing required 'model' query parameter or X-Model header")
+		return
+	}
+
+	modelID, found := pm.realModelName(requestedModel)
+
+	pm.identifyClient(c)
+	releaseClient, ok := pm.limitClient(c, modelID)
+	if !ok {
+		return
+	}
+	defer releaseClient()
+
+	if !pm.admitTenant(c, requestedModel) {
+		return
+	}
+
+	owner, ok := pm.groupOwner(c, modelID)
+	if !ok {
+		return
+	}
+
+	var nextHandler func(modelID string, w http.ResponseWriter, r *http.Request) error
+	if owner != "" {
+		nextHandler = pm.forwardTo(owner)
+	} else if found {
+		if pm.rejectUnadmitted(c, modelID) {
+			return
+		}
+
+		processGroup, err := pm.swapProcessGroup(modelID)
+		if err != nil {
+			pm.sendErrorResponse(c, http.StatusInternalServerError, fmt.Sprintf("error swapping process group: %s", err.Error()))
+			return
+		}
+
+		if !pm.loadForSocket(c, modelID, processGroup) {
+			return
+		}
+
+		if useModelName := pm.config.Models[modelID].UpstreamModelName(requestedModel); useModelName != "" && !pm.isServedModelName(requestedModel) {
+			query := c.Request.URL.Query()
+			query.Set("model", useModelName)
+			c.Request.URL.RawQuery = query.Encode()
+		}
+
+		pm.proxyLogger.Debugf("<%s> opening realtime socket", modelID)
+		nextHandler = processGroup.ProxyRequest
+	} else if pm.peerProxy != nil && pm.peerProxy.HasPeerModel(requestedModel) {
+		modelID = requestedModel
+		nextHandler = pm.peerProxy.ProxyRequest
+	}
+
+	if nextHandler == nil {
+		pm.sendErrorResponse(c, http.StatusBadRequest, fmt.Sprintf("could not find suitable handler for %s", requestedModel))
+		return
+	}
+
+	if err := nextHandler(modelID, c.Writer, c.Request); err != nil {
+		pm.sendErrorResponse(c, http.StatusInternalServerError, fmt.Sprintf("error proxying request: %s", err.Error()))
+		pm.proxyLogger.Errorf("Error Proxying realtime socket for model %s", modelID)
+	}
+}
@@ -0,0 +1,134 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsWebSocketUpgrade(t *testing.T) {
+	req := httptest.NewRequest("GET", "/v1/realtime", nil)
+	assert.False(t, isWebSocketUpgrade(req))
+
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "keep-alive, Upgrade")
+	assert.True(t, isWebSocketUpgrade(req))
+
+	req.Header.Set("Connection", "keep-alive")
+	assert.False(t, isWebSocketUpgrade(req))
+}
+
+func TestProxyManager_RealtimeSocket(t *testing.T) {
+	// echoes the frames of an upgraded connection
+	upstreamModels := make(chan string, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isWebSocketUpgrade(r) {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		upstreamModels <- r.URL.Query().Get("model")
+		conn, brw, err := http.NewResponseController(w).Hijack()
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer conn.Close()
+		fmt.Fprint(brw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		brw.Flush()
+		io.Copy(conn, brw)
+	}))
+	defer upstream.Close()
+
+	model1 := getTestSimpleResponderConfig("model1")
+	model1.UseModelName = "gpt-realtime"
+	model1.SSEFlush = config.SSEFlushEvent
+	conf := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]config.ModelConfig{
+			"model1": model1,
+		},
+		ResponseHeaders: config.ResponseHeadersConfig{Allow: []string{"X-Nothing"}},
+		LogLevel:        "error",
+	})
+
+	proxy := New(conf)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	// the model still starts simple-responder, its sockets go to upstream
+	target, err := url.Parse(upstream.URL)
+	require.NoError(t, err)
+	process, _ := proxy.findGroupByModelName("model1").GetMember("model1")
+	process.reverseProxy = httputil.NewSingleHostReverseProxy(target)
+	applyResponseHeaders(process.reverseProxy, conf.ResponseHeaders)
+
+	server := httptest.NewServer(proxy)
+	defer server.Close()
+
+	t.Run("plain requests are refused", func(t *testing.T) {
+		resp, err := http.Get(server.URL + "/v1/realtime?model=model1")
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("socket opens once the model is loaded", func(t *testing.T) {
+		conn, err := net.Dial("tcp", server.Listener.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+		fmt.Fprint(conn, "GET /v1/realtime?model=model1 HTTP/1.1\r\nHost: llmsnap\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		reader := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(reader, nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+		assert.Equal(t, "websocket", resp.Header.Get("Upgrade"))
+		assert.Equal(t, StateReady, process.CurrentState())
+		assert.Equal(t, "gpt-realtime", <-upstreamModels)
+
+		_, err = conn.Write([]byte("frame"))
+		require.NoError(t, err)
+		echo := make([]byte, 5)
+		_, err = io.ReadFull(reader, echo)
+		require.NoError(t, err)
+		assert.Equal(t, "frame", string(echo))
+
+		// the socket keeps the model busy
+		assert.Equal(t, int32(1), process.inFlightRequestsCount.Load())
+	})
+
+	t.Run("upstream sockets load the model", func(t *testing.T) {
+		process.StopImmediately()
+		require.Equal(t, StateStopped, process.CurrentState())
+
+		conn, err := net.Dial("tcp", server.Listener.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+		fmt.Fprint(conn, "GET /upstream/model1/socket?model=direct HTTP/1.1\r\nHost: llmsnap\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		reader := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(reader, nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+		assert.Equal(t, StateReady, process.CurrentState())
+		assert.Equal(t, "direct", <-upstreamModels)
+
+		_, err = conn.Write([]byte("frame"))
+		require.NoError(t, err)
+		echo := make([]byte, 5)
+		_, err = io.ReadFull(reader, echo)
+		require.NoError(t, err)
+		assert.Equal(t, "frame", string(echo))
+	})
+}
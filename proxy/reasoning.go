@@ -0,0 +1,204 @@
+package proxy
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+const (
+	thinkOpenTag  = "<think>"
+	thinkCloseTag = "</think>"
+)
+
+// thinkSplitter separates <think> blocks from the rest of a message's content.
+// Text arrives in pieces when streaming so a tag may be split across pieces.
+type thinkSplitter struct {
+	inThink bool
+
+	// the end of the last piece, when it could be the start of a tag
+	pending string
+
+	// drop the whitespace that models put between </think> and the answer
+	trimContent bool
+}
+
+// split returns the content and reasoning parts of the next piece of text
+func (t *thinkSplitter) split(text string) (content, reasoning string) {
+	var contentBuf, reasoningBuf strings.Builder
+	text = t.pending + text
+	t.pending = ""
+
+	for text != "" {
+		tag := thinkOpenTag
+		if t.inThink {
+			tag = thinkCloseTag
+		}
+
+		part := text
+		if idx := strings.Index(text, tag); idx >= 0 {
+			part = text[:idx]
+			text = text[idx+len(tag):]
+		} else {
+			keep := partialTagSuffix(text, tag)
+			part = text[:len(text)-keep]
+			t.pending = text[len(text)-keep:]
+			text = ""
+			tag = ""
+		}
+
+		if t.inThink {
+			reasoningBuf.WriteString(part)
+		} else {
+			t.writeContent(&contentBuf, part)
+		}
+		if tag != "" {
+			t.inThink = !t.inThink
+			if !t.inThink {
+				t.trimContent = true
+			}
+		}
+	}
+
+	return contentBuf.String(), reasoningBuf.String()
+}
+
+// flush returns text held back by split, at the end of a message
+func (t *thinkSplitter) flush() (content, reasoning string) {
+	pending := t.pending
+	t.pending = ""
+	if t.inThink {
+		return "", pending
+	}
+	var contentBuf strings.Builder
+	t.writeContent(&contentBuf, pending)
+	return contentBuf.String(), ""
+}
+
+func (t *thinkSplitter) writeContent(buf *strings.Builder, text string) {
+	if t.trimContent {
+		text = strings.TrimLeft(text, " \t\r\n")
+		if text == "" {
+			return
+		}
+		t.trimContent = false
+	}
+	buf.WriteString(text)
+}
+
+// partialTagSuffix returns the length of the longest end of text that is the
+// start of tag
+func partialTagSuffix(text, tag string) int {
+	for n := min(len(tag)-1, len(text)); n > 0; n-- {
+		if strings.HasSuffix(text, tag[:n]) {
+			return n
+		}
+	}
+	return 0
+}
+
+// setReasoning writes content and reasoning into a message or delta at path
+// according to mode. The content field is only set when it was sent by the
+// upstream or changed.
+func setReasoning(data []byte, path string, mode config.ReasoningMode, content, reasoning string, contentChanged bool) []byte {
+	message := gjson.GetBytes(data, path)
+	if contentChanged || message.Get("content").Type == gjson.String {
+		data, _ = sjson.SetBytes(data, path+".content", content)
+	}
+
+	data, _ = sjson.DeleteBytes(data, path+".reasoning_content")
+	data, _ = sjson.DeleteBytes(data, path+".reasoning")
+
+	if reasoning == "" || mode == config.ReasoningStrip {
+		return data
+	}
+	data, _ = sjson.SetBytes(data, path+"."+string(mode), reasoning)
+	return data
+}
+
+// upstreamReasoning returns the reasoning the upstream already sent in a
+// message or delta, in either field
+func upstreamReasoning(message gjson.Result) string {
+	return message.Get("reasoning_content").String() + message.Get("reasoning").String()
+}
+
+// normalizeReasoning rewrites the messages of a complete chat completion
+// response
+func normalizeReasoning(body []byte, mode config.ReasoningMode) []byte {
+	for i, choice := range gjson.GetBytes(body, "choices").Array() {
+		message := choice.Get("message")
+		if !message.Exists() {
+			continue
+		}
+
+		text := message.Get("content").String()
+		// some chat templates open the think block in the prompt so the
+		// reply only has the closing tag
+		if closeIdx := strings.Index(text, thinkCloseTag); closeIdx >= 0 {
+			if openIdx := strings.Index(text, thinkOpenTag); openIdx < 0 || openIdx > closeIdx {
+				text = thinkOpenTag + text
+			}
+		}
+
+		splitter := &thinkSplitter{}
+		content, reasoning := splitter.split(text)
+		restContent, restReasoning := splitter.flush()
+		content += restContent
+		reasoning = upstreamReasoning(message) + strings.TrimSpace(reasoning+restReasoning)
+
+		path := "choices." + strconv.Itoa(i) + ".message"
+		body = setReasoning(body, path, mode, content, reasoning, content != message.Get("content").String())
+	}
+	return body
+}
+
+// reasoningNormalizer rewrites chat completion responses according to a
+// model's reasoning setting
+type reasoningNormalizer struct {
+	mode config.ReasoningMode
+
+	// state of each choice in an event stream
+	splitters map[int64]*thinkSplitter
+}
+
+func newReasoningNormalizer(mode config.ReasoningMode) *reasoningNormalizer {
+	return &reasoningNormalizer{
+		mode:      mode,
+		splitters: make(map[int64]*thinkSplitter),
+	}
+}
+
+func (rn *reasoningNormalizer) transformBody(body []byte) []byte {
+	return normalizeReasoning(body, rn.mode)
+}
+
+func (rn *reasoningNormalizer) transformEvent(payload []byte) [][]byte {
+	for i, choice := range gjson.GetBytes(payload, "choices").Array() {
+		delta := choice.Get("delta")
+		if !delta.Exists() {
+			continue
+		}
+
+		index := choice.Get("index").Int()
+		splitter, ok := rn.splitters[index]
+		if !ok {
+			splitter = &thinkSplitter{}
+			rn.splitters[index] = splitter
+		}
+
+		content, reasoning := splitter.split(delta.Get("content").String())
+		if finish := choice.Get("finish_reason"); finish.Exists() && finish.Type != gjson.Null {
+			restContent, restReasoning := splitter.flush()
+			content += restContent
+			reasoning += restReasoning
+		}
+		reasoning = upstreamReasoning(delta) + reasoning
+
+		path := "choices." + strconv.Itoa(i) + ".delta"
+		payload = setReasoning(payload, path, rn.mode, content, reasoning, content != delta.Get("content").String())
+	}
+	return [][]byte{payload}
+}
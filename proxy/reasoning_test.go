@@ -0,0 +1,143 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+func TestThinkSplitter(t *testing.T) {
+	t.Run("tags split across pieces", func(t *testing.T) {
+		splitter := &thinkSplitter{}
+		var content, reasoning string
+		for _, piece := range []string{"<th", "ink>let me ", "think</thi", "nk>\n\n", "The answer", " is <4"} {
+			c, r := splitter.split(piece)
+			content += c
+			reasoning += r
+		}
+		c, r := splitter.flush()
+		assert.Equal(t, "The answer is <4", content+c)
+		assert.Equal(t, "let me think", reasoning+r)
+	})
+
+	t.Run("no think block", func(t *testing.T) {
+		splitter := &thinkSplitter{}
+		content, reasoning := splitter.split("hello <b>world</b>")
+		assert.Equal(t, "hello <b>world</b>", content)
+		assert.Equal(t, "", reasoning)
+	})
+}
+
+func TestNormalizeReasoning(t *testing.T) {
+	body := []byte(`{"choices":[{"index":0,"message":{"role":"assistant","content":"<think>\nhmm\n</think>\n\nHi!"}}]}`)
+
+	tests := []struct {
+		name      string
+		mode      config.ReasoningMode
+		body      string
+		content   string
+		reasoning map[string]string
+	}{
+		{"strip", config.ReasoningStrip, string(body), "Hi!", nil},
+		{"reasoning_content", config.ReasoningContent, string(body), "Hi!", map[string]string{"reasoning_content": "hmm"}},
+		{"reasoning", config.ReasoningField, string(body), "Hi!", map[string]string{"reasoning": "hmm"}},
+		{
+			"only closing tag", config.ReasoningContent,
+			`{"choices":[{"message":{"content":"hmm</think>Hi!"}}]}`,
+			"Hi!", map[string]string{"reasoning_content": "hmm"},
+		},
+		{
+			"relocate field", config.ReasoningField,
+			`{"choices":[{"message":{"content":"Hi!","reasoning_content":"hmm"}}]}`,
+			"Hi!", map[string]string{"reasoning": "hmm", "reasoning_content": ""},
+		},
+		{
+			"strip field", config.ReasoningStrip,
+			`{"choices":[{"message":{"content":"Hi!","reasoning":"hmm"}}]}`,
+			"Hi!", map[string]string{"reasoning": ""},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := normalizeReasoning([]byte(tt.body), tt.mode)
+			message := gjson.GetBytes(result, "choices.0.message")
+			assert.Equal(t, tt.content, message.Get("content").String())
+			for field, expected := range tt.reasoning {
+				assert.Equal(t, expected, message.Get(field).String(), field)
+			}
+			if tt.mode == config.ReasoningStrip {
+				assert.False(t, message.Get("reasoning_content").Exists())
+				assert.False(t, message.Get("reasoning").Exists())
+			}
+		})
+	}
+}
+
+func TestReasoningNormalizer_TransformWriter(t *testing.T) {
+	t.Run("event stream", func(t *testing.T) {
+		stream := strings.Join([]string{
+			`data: {"choices":[{"index":0,"delta":{"role":"assistant","content":null}}]}`,
+			`data: {"choices":[{"index":0,"delta":{"content":"<think>"}}]}`,
+			`data: {"choices":[{"index":0,"delta":{"content":"hmm"}}]}`,
+			`data: {"choices":[{"index":0,"delta":{"content":"</think>\n\n"}}]}`,
+			`data: {"choices":[{"index":0,"delta":{"content":"Hi!"}}]}`,
+			`data: {"choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`,
+			`data: [DONE]`,
+			``,
+		}, "\n\n")
+
+		rec := httptest.NewRecorder()
+		rec.Header().Set("Content-Type", "text/event-stream")
+		rw := newTransformWriter(rec, newReasoningNormalizer(config.ReasoningContent))
+
+		// write in odd sized pieces so events are split across writes
+		for i := 0; i < len(stream); i += 7 {
+			rw.Write([]byte(stream[i:min(i+7, len(stream))]))
+		}
+		rw.Close()
+
+		var content, reasoning string
+		for _, line := range strings.Split(rec.Body.String(), "\n") {
+			payload, found := strings.CutPrefix(line, "data: ")
+			if !found || payload == "[DONE]" {
+				continue
+			}
+			delta := gjson.Get(payload, "choices.0.delta")
+			content += delta.Get("content").String()
+			reasoning += delta.Get("reasoning_content").String()
+		}
+		assert.Equal(t, "Hi!", content)
+		assert.Equal(t, "hmm", reasoning)
+		assert.True(t, strings.HasSuffix(rec.Body.String(), "data: [DONE]\n\n"))
+	})
+
+	t.Run("json body", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		rec.Header().Set("Content-Type", "application/json")
+		rec.Header().Set("Content-Length", "100")
+		rw := newTransformWriter(rec, newReasoningNormalizer(config.ReasoningStrip))
+		rw.WriteHeader(http.StatusOK)
+		rw.Write([]byte(`{"choices":[{"message":{"content":"<think>hmm</think>Hi!"}}]}`))
+		rw.Close()
+
+		assert.Equal(t, "", rec.Header().Get("Content-Length"))
+		assert.Equal(t, "Hi!", gjson.Get(rec.Body.String(), "choices.0.message.content").String())
+	})
+
+	t.Run("errors pass through", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		rec.Header().Set("Content-Type", "application/json")
+		rw := newTransformWriter(rec, newReasoningNormalizer(config.ReasoningStrip))
+		rw.WriteHeader(http.StatusBadRequest)
+		rw.Write([]byte(`{"error":"<think>"}`))
+		rw.Close()
+
+		assert.Equal(t, `{"error":"<think>"}`, rec.Body.String())
+	})
+}
@@ -0,0 +1,133 @@
+package proxy
+
+import (
+	"net/http"
+	"reflect"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/napmany/llmsnap/proxy/config"
+)
+
+// Reload returns a ProxyManager for newConfig that takes the place of pm.
+// Processes whose model, group and upstream settings did not change keep
+// running with their in-flight requests. The others are stopped once their
+// in-flight requests are done, before the new ProxyManager is created, so a
+// changed model can start again on its port. pm must not be used after.
+func (pm *ProxyManager) Reload(newConfig config.Config) *ProxyManager {
+	// pm is only locked to plan and to swap, its API keeps answering while
+	// the stopped processes finish their requests
+	pm.Lock()
+	kept, stopped := pm.reloadPlan(newConfig)
+	// models stopped by the reload are not recorded as unloaded, see
+	// recordLoadedModels
+	pm.shuttingDown.Store(true)
+	// requests that come in before the processes are stopped must not
+	// start them again
+	for _, process := range stopped {
+		process.retire()
+	}
+	pm.Unlock()
+
+	pm.proxyLogger.Infof("reloading config, %d processes keep running, %d are stopped", len(kept), len(stopped))
+
+	var wg sync.WaitGroup
+	for _, process := range stopped {
+		wg.Add(1)
+		go func(process *Process) {
+			defer wg.Done()
+			process.Stop()
+			// a stopped process is left as it is by Shutdown, pm must not
+			// start it again
+			process.Shutdown()
+			process.forceState(StateShutdown)
+		}(process)
+	}
+	wg.Wait()
+
+	pm.Lock()
+	defer pm.Unlock()
+	pm.shutdownCancel()
+
+	// the new ProxyManager builds its own filters, responses that still use
+	// the old ones hold them until they are done
+	for _, filters := range pm.wasmFilters {
+		filters.close()
+	}
+
+	// the store of pm writes what it has queued and closes the file before
+	// the next one rewrites it, requests of pm still in flight are stored by
+	// the next one
+	pm.metricsMonitor.stopStore()
+	next := newProxyManager(newConfig, pm, kept...)
+	pm.metricsMonitor.setStore(next.metricsMonitor.store)
+
+	next.SetVersion(pm.buildDate, pm.commit, pm.version)
+	next.reload = pm.reload
+	return next
+}
+
+// reloadPlan splits the processes of pm into the ones newConfig keeps and
+// the ones it stops. A process is kept when newConfig has a process with the
+// same ID and config in the same unchanged group.
+func (pm *ProxyManager) reloadPlan(newConfig config.Config) (kept, stopped []*Process) {
+	// the settings every process of a group is built with
+	sameUpstreams := pm.config.HealthCheckTimeout == newConfig.HealthCheckTimeout &&
+		reflect.DeepEqual(pm.config.Transport, newConfig.Transport) &&
+		reflect.DeepEqual(pm.config.ResponseHeaders, newConfig.ResponseHeaders)
+
+	for groupID, processGroup := range pm.processGroups {
+		groupConfig, found := newConfig.Groups[groupID]
+		sameGroup := sameUpstreams && found && reflect.DeepEqual(groupConfig, pm.config.Groups[groupID])
+
+		for modelID, process := range processGroup.instances() {
+			if sameGroup && keepsProcess(newConfig, modelID, process) {
+				kept = append(kept, process)
+			} else {
+				stopped = append(stopped, process)
+			}
+		}
+	}
+	return kept, stopped
+}
+
+// keepsProcess reports if newConfig has an instance of modelID with the ID
+// and the config of process
+func keepsProcess(newConfig config.Config, modelID string, process *Process) bool {
+	modelConfig, found := newConfig.Models[modelID]
+	if !found {
+		return false
+	}
+	for i, instanceConfig := range modelConfig.InstanceConfigs() {
+		if instanceProcessID(modelID, i) == process.ID {
+			return reflect.DeepEqual(instanceConfig, process.config)
+		}
+	}
+	return false
+}
+
+// SetReloadFunc sets what POST /api/config/reload calls. It reads the config
+// again and puts the ProxyManager from Reload in the place of this one.
+func (pm *ProxyManager) SetReloadFunc(reload func() error) {
+	pm.Lock()
+	defer pm.Unlock()
+	pm.reload = reload
+}
+
+// apiReloadConfig reloads the config file, the models that did not change
+// keep running
+func (pm *ProxyManager) apiReloadConfig(c *gin.Context) {
+	pm.Lock()
+	reload := pm.reload
+	pm.Unlock()
+
+	if reload == nil {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "config reload is not available"})
+		return
+	}
+	if err := reload(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"msg": "ok"})
+}
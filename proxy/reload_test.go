@@ -0,0 +1,166 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+func TestProxyManager_Reload(t *testing.T) {
+	model1 := getTestSimpleResponderConfig("model1")
+	newConfig := func(model2 config.ModelConfig) config.Config {
+		return config.AddDefaultGroupToConfig(config.Config{
+			HealthCheckTimeout: 15,
+			Models: map[string]config.ModelConfig{
+				"model1": model1,
+				"model2": model2,
+			},
+			Groups: map[string]config.GroupConfig{
+				"all": {Swap: false, Members: []string{"model1", "model2"}},
+			},
+			LogLevel: "error",
+		})
+	}
+
+	chat := func(pm *ProxyManager, model string) string {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model":"`+model+`"}`))
+		w := CreateTestResponseRecorder()
+		pm.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		return gjson.Get(w.Body.String(), "responseMessage").String()
+	}
+
+	proxy := New(newConfig(getTestSimpleResponderConfig("model2")))
+	assert.Equal(t, "model1", chat(proxy, "model1"))
+	assert.Equal(t, "model2", chat(proxy, "model2"))
+
+	oldModel1, _ := proxy.findGroupByModelName("model1").GetMember("model1")
+	oldModel2, _ := proxy.findGroupByModelName("model2").GetMember("model2")
+
+	// a request in flight during the reload is served to the end
+	inFlight := make(chan string)
+	go func() {
+		req := httptest.NewRequest("GET", "/upstream/model1/slow-respond?echo=abcd&delay=100ms", nil)
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		body, _ := io.ReadAll(w.Body)
+		inFlight <- string(body)
+	}()
+	require.Eventually(t, func() bool {
+		return oldModel1.inFlightRequestsCount.Load() == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	next := proxy.Reload(newConfig(getTestSimpleResponderConfig("model2-changed")))
+	defer next.StopProcesses(StopImmediately)
+
+	// model1 did not change and keeps running, model2 is started again
+	model1Process, _ := next.findGroupByModelName("model1").GetMember("model1")
+	assert.Same(t, oldModel1, model1Process)
+	assert.Equal(t, StateReady, model1Process.CurrentState())
+	assert.Equal(t, StateShutdown, oldModel2.CurrentState())
+
+	select {
+	case body := <-inFlight:
+		assert.Equal(t, "abcd", body)
+	case <-time.After(5 * time.Second):
+		t.Fatal("in-flight request did not finish")
+	}
+
+	assert.Equal(t, "model1", chat(next, "model1"))
+	assert.Equal(t, "model2-changed", chat(next, "model2"))
+	// the chats before and after the reload and the request in flight
+	assert.Equal(t, int64(3), model1Process.requestsServed.Load())
+}
+
+func TestProxyManager_ReloadHandsOverMetricsStore(t *testing.T) {
+	conf := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]config.ModelConfig{
+			"model1": getTestSimpleResponderConfig("model1"),
+		},
+		MetricsStore: config.MetricsStoreConfig{File: filepath.Join(t.TempDir(), "metrics.jsonl")},
+		LogLevel:     "error",
+	})
+
+	proxy := New(conf)
+	proxy.metricsMonitor.addMetrics(TokenMetrics{Model: "model1", RequestID: "before", Timestamp: time.Now()})
+
+	next := proxy.Reload(conf)
+	defer next.StopProcesses(StopImmediately)
+
+	// the old store wrote its queue before the new one read the file
+	history := next.metricsMonitor.getMetrics()
+	require.Len(t, history, 1)
+	assert.Equal(t, "before", history[0].RequestID)
+
+	// a request of the old ProxyManager that finishes late is stored once
+	proxy.metricsMonitor.addMetrics(TokenMetrics{Model: "model1", RequestID: "late", Timestamp: time.Now()})
+	next.metricsMonitor.addMetrics(TokenMetrics{Model: "model1", RequestID: "after", Timestamp: time.Now()})
+	store := next.metricsMonitor.store
+	next.shutdownCancel()
+	<-store.done
+
+	records, err := store.read()
+	require.NoError(t, err)
+	var ids []string
+	for _, record := range records {
+		ids = append(ids, record.RequestID)
+	}
+	assert.Equal(t, []string{"before", "late", "after"}, ids)
+}
+
+func TestProxyManager_ReloadKeepsMetrics(t *testing.T) {
+	conf := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]config.ModelConfig{
+			"model1": getTestSimpleResponderConfig("model1"),
+		},
+		LogLevel: "error",
+	})
+
+	proxy := New(conf)
+	proxy.metricsMonitor.addMetrics(TokenMetrics{Model: "model1", RequestID: "before", Timestamp: time.Now()})
+
+	next := proxy.Reload(conf)
+	defer next.StopProcesses(StopImmediately)
+
+	// without a metricsStore the activity is handed over in memory
+	next.metricsMonitor.addMetrics(TokenMetrics{Model: "model1", RequestID: "after", Timestamp: time.Now()})
+	history := next.metricsMonitor.getMetrics()
+	require.Len(t, history, 2)
+	assert.Equal(t, "before", history[0].RequestID)
+	assert.Equal(t, "after", history[1].RequestID)
+	assert.Greater(t, history[1].ID, history[0].ID)
+}
+
+func TestProcess_Retire(t *testing.T) {
+	ready := NewProcess("ready", 5, getTestSimpleResponderConfig("ready"), debugLogger, debugLogger)
+	defer ready.Stop()
+	require.NoError(t, ready.start())
+
+	// a ready process drains and refuses new requests
+	ready.retire()
+	assert.Equal(t, StateDraining, ready.CurrentState())
+	req := httptest.NewRequest("GET", "/test", nil)
+	w := httptest.NewRecorder()
+	ready.ProxyRequest(w, req)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	ready.Stop()
+	assert.Equal(t, StateStopped, ready.CurrentState())
+
+	// a stopped process is not started again
+	stopped := NewProcess("stopped", 5, getTestSimpleResponderConfig("stopped"), debugLogger, debugLogger)
+	stopped.retire()
+	assert.Equal(t, StateShutdown, stopped.CurrentState())
+	assert.Error(t, stopped.makeReady())
+	assert.Equal(t, StateShutdown, stopped.CurrentState())
+}
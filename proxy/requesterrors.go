@@ -0,0 +1,114 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/napmany/llmsnap/event"
+)
+
+// maxRequestErrorBody is how much of an error response is kept
+const maxRequestErrorBody = 1024
+
+// RequestError is a request to a model that did not get a 200 response. It
+// has no TokenMetrics, metricsMonitor keeps it apart from them.
+type RequestError struct {
+	ID         int       `json:"id"`
+	Timestamp  time.Time `json:"timestamp"`
+	Model      string    `json:"model"`
+	Path       string    `json:"path"`
+	Status     int       `json:"status"`
+	DurationMs int       `json:"duration_ms"`
+
+	// Error is the start of the response body, or why the request could not
+	// be proxied
+	Error string `json:"error"`
+
+	Client    string `json:"client,omitempty"`
+	Tenant    string `json:"tenant,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// RequestErrorEvent is emitted for each recorded RequestError
+type RequestErrorEvent struct {
+	Error RequestError
+}
+
+func (e RequestErrorEvent) Type() uint32 {
+	return RequestErrorEventID // defined in events.go
+}
+
+// newRequestError describes a failed request to modelID, body is scrubbed
+// and truncated
+func (mp *metricsMonitor) newRequestError(modelID string, request *http.Request, status int, started time.Time, body []byte) RequestError {
+	return RequestError{
+		Timestamp:  time.Now(),
+		Model:      modelID,
+		Path:       request.URL.Path,
+		Status:     status,
+		DurationMs: int(time.Since(started).Milliseconds()),
+		Error:      truncateErrorBody(string(mp.scrubber.scrub(body))),
+		Client:     requestClientName(request),
+		Tenant:     requestTenantName(request),
+		RequestID:  requestID(request),
+	}
+}
+
+// truncateErrorBody cuts body to maxRequestErrorBody bytes without
+// splitting a character
+func truncateErrorBody(body string) string {
+	body = strings.TrimSpace(body)
+	if len(body) <= maxRequestErrorBody {
+		return body
+	}
+	cut := maxRequestErrorBody
+	for cut > 0 && !utf8.RuneStart(body[cut]) {
+		cut--
+	}
+	return body[:cut] + "…"
+}
+
+// addError records a failed request and publishes an event, as many are kept
+// as metrics. Returns the assigned ID.
+func (mp *metricsMonitor) addError(requestError RequestError) int {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	requestError.ID = mp.nextErrorID
+	mp.nextErrorID++
+	mp.errors = append(mp.errors, requestError)
+	if len(mp.errors) > mp.maxMetrics {
+		mp.errors = mp.errors[len(mp.errors)-mp.maxMetrics:]
+	}
+	event.Emit(RequestErrorEvent{Error: requestError})
+	return requestError.ID
+}
+
+// getErrors returns the recorded failed requests, oldest first
+func (mp *metricsMonitor) getErrors() []RequestError {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+	result := make([]RequestError, len(mp.errors))
+	copy(result, mp.errors)
+	return result
+}
+
+// apiGetErrors returns the failed requests, oldest first. ?model= and
+// ?client= limit them to a model and a client.
+func (pm *ProxyManager) apiGetErrors(c *gin.Context) {
+	errors := pm.metricsMonitor.getErrors()
+	model, client := c.Query("model"), c.Query("client")
+	if model != "" || client != "" {
+		filtered := make([]RequestError, 0, len(errors))
+		for _, requestError := range errors {
+			if (model == "" || requestError.Model == model) && (client == "" || requestError.Client == client) {
+				filtered = append(filtered, requestError)
+			}
+		}
+		errors = filtered
+	}
+	c.JSON(http.StatusOK, errors)
+}
@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/napmany/llmsnap/event"
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTruncateErrorBody(t *testing.T) {
+	assert.Equal(t, "bad request", truncateErrorBody(" bad request\n"))
+
+	long := strings.Repeat("a", maxRequestErrorBody-1) + "é"
+	truncated := truncateErrorBody(long)
+	assert.Equal(t, strings.Repeat("a", maxRequestErrorBody-1)+"…", truncated)
+}
+
+func TestMetricsMonitor_AddError(t *testing.T) {
+	mm := newMetricsMonitor(testLogger, 2, 0)
+
+	received := make(chan RequestError, 3)
+	cancel := event.On(func(e RequestErrorEvent) {
+		received <- e.Error
+	})
+	defer cancel()
+
+	for _, model := range []string{"model1", "model2", "model3"} {
+		mm.addError(RequestError{Model: model, Status: 500})
+	}
+
+	errors := mm.getErrors()
+	require.Len(t, errors, 2)
+	assert.Equal(t, "model2", errors[0].Model)
+	assert.Equal(t, 1, errors[0].ID)
+	assert.Equal(t, "model3", errors[1].Model)
+
+	for i := range 3 {
+		select {
+		case e := <-received:
+			assert.Equal(t, i, e.ID)
+		case <-time.After(time.Second):
+			t.Fatal("no RequestErrorEvent")
+		}
+	}
+}
+
+func TestProxyManager_Errors(t *testing.T) {
+	conf := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]config.ModelConfig{
+			"model1": getTestSimpleResponderConfig("model1"),
+			"model2": getTestSimpleResponderConfig("model2"),
+		},
+		LogLevel: "error",
+	})
+	proxy := New(conf)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	w := CreateTestResponseRecorder()
+	proxy.ServeHTTP(w, httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"model":"model1"}`)))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	req := httptest.NewRequest("POST", "/upstream/model1/missing", nil)
+	req.Header.Set(requestIDHeader, "failed-1")
+	w = CreateTestResponseRecorder()
+	proxy.ServeHTTP(w, req)
+	require.Equal(t, http.StatusNotFound, w.Code)
+
+	get := func(query string) []RequestError {
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, httptest.NewRequest("GET", "/api/errors"+query, nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		var errors []RequestError
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &errors))
+		return errors
+	}
+
+	errors := get("")
+	require.Len(t, errors, 1)
+	assert.Equal(t, "model1", errors[0].Model)
+	assert.Equal(t, "/missing", errors[0].Path)
+	assert.Equal(t, http.StatusNotFound, errors[0].Status)
+	assert.Equal(t, "failed-1", errors[0].RequestID)
+	assert.NotEmpty(t, errors[0].Error)
+
+	// the successful request is only in the metrics
+	assert.Len(t, proxy.metricsMonitor.getMetrics(), 1)
+
+	assert.Len(t, get("?model=model1"), 1)
+	assert.Empty(t, get("?model=model2"))
+}
@@ -0,0 +1,52 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+// requestIDHeader carries the request ID to upstreams and peers and back to
+// the client
+const requestIDHeader = "X-Request-ID"
+
+// requestIDEnv names requestIDHeader in the environment of upstream
+// processes, so wrappers and custom servers can log the ID
+const requestIDEnv = "LLMSNAP_REQUEST_ID_HEADER"
+
+// longest request ID taken from a client
+const maxRequestIDLength = 128
+
+// newRequestID returns a random ID that ties the access log line of a
+// request to its activity metrics
+func newRequestID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// requestID returns the ID the access log middleware gave r, empty for
+// requests that are not logged
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(proxyCtxKey("requestID")).(string)
+	return id
+}
+
+// incomingRequestID returns the ID a client or peer sent in requestIDHeader,
+// empty when there is none or it has characters that do not belong in a log
+// line
+func incomingRequestID(r *http.Request) string {
+	id := r.Header.Get(requestIDHeader)
+	if id == "" || len(id) > maxRequestIDLength {
+		return ""
+	}
+	for _, ch := range id {
+		switch {
+		case ch >= 'a' && ch <= 'z', ch >= 'A' && ch <= 'Z', ch >= '0' && ch <= '9':
+		case ch == '-', ch == '_', ch == '.', ch == ':':
+		default:
+			return ""
+		}
+	}
+	return id
+}
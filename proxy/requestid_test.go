@@ -0,0 +1,129 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+func TestNewRequestID(t *testing.T) {
+	id := newRequestID()
+	assert.Len(t, id, 16)
+	assert.NotEqual(t, id, newRequestID())
+}
+
+func TestProxyManager_RequestIDCorrelation(t *testing.T) {
+	conf := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]config.ModelConfig{
+			"model1": getTestSimpleResponderConfig("model1"),
+		},
+		LogLevel:    "info",
+		LogToStdout: config.LogToStdoutNone,
+	})
+
+	proxy := New(conf)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model":"model1"}`))
+	w := CreateTestResponseRecorder()
+	proxy.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	metrics := proxy.metricsMonitor.getMetrics()
+	require.Len(t, metrics, 1)
+	id := metrics[0].RequestID
+	require.Len(t, id, 16)
+
+	// the access log line of the request has the same ID
+	assert.Contains(t, string(proxy.proxyLogger.GetHistory()), fmt.Sprintf("Request [%s] ", id))
+
+	req = httptest.NewRequest("GET", "/api/requests/"+id, nil)
+	w = CreateTestResponseRecorder()
+	proxy.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	var found TokenMetrics
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &found))
+	assert.Equal(t, metrics[0].ID, found.ID)
+	assert.Equal(t, "model1", found.Model)
+
+	req = httptest.NewRequest("GET", "/api/requests/0123456789abcdef", nil)
+	w = CreateTestResponseRecorder()
+	proxy.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), "request 0123456789abcdef not found in activity")
+}
+
+func TestIncomingRequestID(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"", ""},
+		{"trace-1234_abc.def:5", "trace-1234_abc.def:5"},
+		{"has space", ""},
+		{"line\nbreak", ""},
+		{strings.Repeat("a", maxRequestIDLength), strings.Repeat("a", maxRequestIDLength)},
+		{strings.Repeat("a", maxRequestIDLength+1), ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set(requestIDHeader, tt.header)
+		assert.Equal(t, tt.want, incomingRequestID(req), tt.header)
+	}
+}
+
+func TestProxyManager_RequestIDPropagation(t *testing.T) {
+	conf := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]config.ModelConfig{
+			"model1": getTestSimpleResponderConfig("model1"),
+		},
+		LogLevel:    "info",
+		LogToStdout: config.LogToStdoutNone,
+	})
+
+	proxy := New(conf)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	chat := func(id string) *TestResponseRecorder {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model":"model1"}`))
+		if id != "" {
+			req.Header.Set(requestIDHeader, id)
+		}
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		return w
+	}
+
+	// the client's ID is sent upstream, logged, stored and returned once
+	w := chat("client-trace-42")
+	assert.Equal(t, []string{"client-trace-42"}, w.Header().Values(requestIDHeader))
+	assert.Equal(t, "client-trace-42", gjson.Get(w.Body.String(), "h_request_id").String())
+	assert.Contains(t, string(proxy.proxyLogger.GetHistory()), "Request [client-trace-42] ")
+	metrics := proxy.metricsMonitor.getMetrics()
+	require.Len(t, metrics, 1)
+	assert.Equal(t, "client-trace-42", metrics[0].RequestID)
+
+	// without one, or with one that is not usable, llmsnap makes one
+	w = chat("bad id")
+	id := w.Header().Get(requestIDHeader)
+	assert.Len(t, id, 16)
+	assert.Equal(t, id, gjson.Get(w.Body.String(), "h_request_id").String())
+
+	// upstream processes learn the header from their environment
+	req := httptest.NewRequest("GET", "/upstream/model1/env", nil)
+	w = CreateTestResponseRecorder()
+	proxy.ServeHTTP(w, req)
+	assert.Contains(t, w.Body.String(), requestIDEnv+"="+requestIDHeader)
+}
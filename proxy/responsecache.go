@@ -0,0 +1,384 @@
+package proxy
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/napmany/llmsnap/proxy/config"
+)
+
+const (
+	defaultResponseCacheTTL        = 300 * time.Second
+	defaultResponseCacheMaxEntries = 1000
+	defaultResponseCacheMaxSizeMB  = 64
+	defaultResponseCacheMaxDiskMB  = 1024
+)
+
+// cacheAlwaysPaths are endpoints that return the same result for the same input
+var cacheAlwaysPaths = map[string]bool{
+	"/v1/embeddings": true,
+	"/v1/rerank":     true,
+	"/v1/reranking":  true,
+	"/rerank":        true,
+}
+
+// cacheDeterministicPaths are generation endpoints that are only cacheable
+// when the request asks for greedy sampling with temperature 0
+var cacheDeterministicPaths = map[string]bool{
+	"/v1/chat/completions": true,
+	"/v1/completions":      true,
+	"/v1/messages":         true,
+}
+
+// cachedHeaders are the upstream response headers replayed on a cache hit
+var cachedHeaders = []string{"Content-Type", "Content-Encoding"}
+
+type cachedResponse struct {
+	key     string
+	model   string
+	status  int
+	header  http.Header
+	body    []byte
+	expires time.Time
+
+	// the partition whose LRU list holds the entry
+	partition *cachePartition
+}
+
+// cachePartition is an LRU list of cached responses with its own limits.
+// Models with their own maxEntries or maxSizeMB have one, the other models
+// share one.
+type cachePartition struct {
+	maxEntries int
+	maxBytes   int
+	size       int
+	lru        *list.List
+}
+
+// responseCache is an in-memory LRU cache of upstream responses to identical,
+// deterministic requests. Hits are served without waking the upstream. With
+// a dir the responses are kept on disk too and read back after a restart.
+type responseCache struct {
+	sync.Mutex
+
+	enabled bool
+	ttl     time.Duration
+	models  map[string]config.ModelResponseCacheConfig
+
+	entries    map[string]*list.Element
+	shared     *cachePartition
+	partitions map[string]*cachePartition
+
+	// nil without responseCache.dir
+	disk *diskCache
+}
+
+// responseCacheUsed reports if the global setting or a model enables the
+// response cache
+func responseCacheUsed(conf config.Config) bool {
+	if conf.ResponseCache.Enabled {
+		return true
+	}
+	for _, model := range conf.Models {
+		if model.ResponseCache.Enabled != nil && *model.ResponseCache.Enabled {
+			return true
+		}
+	}
+	return false
+}
+
+func newResponseCache(cfg config.ResponseCacheConfig, models map[string]config.ModelConfig, logger *LogMonitor) *responseCache {
+	rc := &responseCache{
+		enabled:    cfg.Enabled,
+		ttl:        defaultResponseCacheTTL,
+		models:     make(map[string]config.ModelResponseCacheConfig),
+		entries:    make(map[string]*list.Element),
+		partitions: make(map[string]*cachePartition),
+	}
+	if cfg.TTL > 0 {
+		rc.ttl = time.Duration(cfg.TTL) * time.Second
+	}
+	maxEntries, maxSizeMB := defaultResponseCacheMaxEntries, defaultResponseCacheMaxSizeMB
+	if cfg.MaxEntries > 0 {
+		maxEntries = cfg.MaxEntries
+	}
+	if cfg.MaxSizeMB > 0 {
+		maxSizeMB = cfg.MaxSizeMB
+	}
+	rc.shared = newCachePartition(maxEntries, maxSizeMB)
+
+	for modelID, model := range models {
+		rc.models[modelID] = model.ResponseCache
+		if model.ResponseCache.OwnLimits() {
+			entries, sizeMB := maxEntries, maxSizeMB
+			if model.ResponseCache.MaxEntries > 0 {
+				entries = model.ResponseCache.MaxEntries
+			}
+			if model.ResponseCache.MaxSizeMB > 0 {
+				sizeMB = model.ResponseCache.MaxSizeMB
+			}
+			rc.partitions[modelID] = newCachePartition(entries, sizeMB)
+		}
+	}
+
+	if cfg.Dir != "" {
+		maxDiskMB := defaultResponseCacheMaxDiskMB
+		if cfg.MaxDiskMB > 0 {
+			maxDiskMB = cfg.MaxDiskMB
+		}
+		disk, err := newDiskCache(cfg.Dir, maxDiskMB*1024*1024, logger)
+		if err != nil {
+			logger.Errorf("responseCache: keeping responses in memory only, unable to use %s: %v", cfg.Dir, err)
+		} else {
+			rc.disk = disk
+		}
+	}
+	return rc
+}
+
+func newCachePartition(maxEntries, maxSizeMB int) *cachePartition {
+	return &cachePartition{maxEntries: maxEntries, maxBytes: maxSizeMB * 1024 * 1024, lru: list.New()}
+}
+
+// enabledFor reports if responses of modelID are cached
+func (rc *responseCache) enabledFor(modelID string) bool {
+	if enabled := rc.models[modelID].Enabled; enabled != nil {
+		return *enabled
+	}
+	return rc.enabled
+}
+
+// ttlFor returns how long responses of modelID are served
+func (rc *responseCache) ttlFor(modelID string) time.Duration {
+	if ttl := rc.models[modelID].TTL; ttl > 0 {
+		return time.Duration(ttl) * time.Second
+	}
+	return rc.ttl
+}
+
+// partitionFor returns the partition that holds responses of modelID
+func (rc *responseCache) partitionFor(modelID string) *cachePartition {
+	if partition, found := rc.partitions[modelID]; found {
+		return partition
+	}
+	return rc.shared
+}
+
+// cacheKey returns the key for a request and whether it can be cached at all.
+// The body is normalized so that key order and whitespace do not matter.
+func (rc *responseCache) cacheKey(modelID string, path string, body []byte) (string, bool) {
+	if !rc.enabledFor(modelID) {
+		return "", false
+	}
+	if !cacheAlwaysPaths[path] && !cacheDeterministicPaths[path] {
+		return "", false
+	}
+
+	var fields map[string]any
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return "", false
+	}
+
+	if stream, ok := fields["stream"].(bool); ok && stream {
+		return "", false
+	}
+
+	if cacheDeterministicPaths[path] {
+		temperature, ok := fields["temperature"].(float64)
+		if !ok || temperature != 0 {
+			return "", false
+		}
+	}
+
+	// aliases of the same model share cache entries
+	delete(fields, "model")
+
+	normalized, err := json.Marshal(fields)
+	if err != nil {
+		return "", false
+	}
+
+	h := sha256.New()
+	h.Write([]byte(modelID))
+	h.Write([]byte{0})
+	h.Write([]byte(path))
+	h.Write([]byte{0})
+	h.Write(normalized)
+	return hex.EncodeToString(h.Sum(nil)), true
+}
+
+// get returns the unexpired response cached under key, from memory or else
+// from disk
+func (rc *responseCache) get(key string) (*cachedResponse, bool) {
+	rc.Lock()
+	elem, found := rc.entries[key]
+	if found {
+		entry := elem.Value.(*cachedResponse)
+		if time.Now().After(entry.expires) {
+			rc.remove(elem)
+			rc.Unlock()
+			rc.disk.remove(key)
+			return nil, false
+		}
+		entry.partition.lru.MoveToFront(elem)
+		rc.Unlock()
+		return entry, true
+	}
+	rc.Unlock()
+
+	entry, found := rc.disk.read(key)
+	if !found {
+		return nil, false
+	}
+	rc.add(entry)
+	return entry, true
+}
+
+// put caches a response of modelID under key
+func (rc *responseCache) put(modelID, key string, status int, header http.Header, body []byte) {
+	partition := rc.partitionFor(modelID)
+	if len(body) > partition.maxBytes {
+		return
+	}
+
+	entry := &cachedResponse{
+		key:     key,
+		model:   modelID,
+		status:  status,
+		header:  make(http.Header),
+		body:    body,
+		expires: time.Now().Add(rc.ttlFor(modelID)),
+	}
+	for _, name := range cachedHeaders {
+		if value := header.Get(name); value != "" {
+			entry.header.Set(name, value)
+		}
+	}
+
+	rc.add(entry)
+	if rc.disk != nil {
+		// written in the background, the client is not kept waiting
+		go rc.disk.write(entry)
+	}
+}
+
+// add puts an entry in memory and evicts the least recently used entries of
+// its partition over the limits
+func (rc *responseCache) add(entry *cachedResponse) {
+	rc.Lock()
+	defer rc.Unlock()
+
+	if elem, found := rc.entries[entry.key]; found {
+		rc.remove(elem)
+	}
+
+	partition := rc.partitionFor(entry.model)
+	entry.partition = partition
+	rc.entries[entry.key] = partition.lru.PushFront(entry)
+	partition.size += len(entry.body)
+
+	for partition.lru.Len() > partition.maxEntries || partition.size > partition.maxBytes {
+		rc.remove(partition.lru.Back())
+	}
+}
+
+// remove deletes an entry from memory, must be called with rc locked
+func (rc *responseCache) remove(elem *list.Element) {
+	entry := elem.Value.(*cachedResponse)
+	entry.partition.lru.Remove(elem)
+	entry.partition.size -= len(entry.body)
+	delete(rc.entries, entry.key)
+}
+
+// serve writes a cached response to the client
+func (rc *responseCache) serve(w http.ResponseWriter, entry *cachedResponse) {
+	for name, values := range entry.header {
+		w.Header()[name] = values
+	}
+	w.Header().Set("X-Cache", "HIT")
+	w.WriteHeader(entry.status)
+	w.Write(entry.body)
+}
+
+// addCacheHit records a request served from the response cache. It used no
+// tokens of the model, so it has none and costs nothing.
+func (mp *metricsMonitor) addCacheHit(modelID string, request *http.Request, started time.Time) {
+	mp.addMetrics(TokenMetrics{
+		Timestamp:       time.Now(),
+		Model:           modelID,
+		PromptPerSecond: -1,
+		TokensPerSecond: -1,
+		DurationMs:      int(time.Since(started).Milliseconds()),
+		Client:          requestClientName(request),
+		Tenant:          requestTenantName(request),
+		RequestID:       requestID(request),
+		CacheHit:        true,
+	})
+}
+
+// wrapHandler stores successful responses produced by next under key
+func (rc *responseCache) wrapHandler(
+	key string,
+	next func(modelID string, w http.ResponseWriter, r *http.Request) error,
+) func(modelID string, w http.ResponseWriter, r *http.Request) error {
+	return func(modelID string, w http.ResponseWriter, r *http.Request) error {
+		recorder := &cacheRecorder{ResponseWriter: w, limit: rc.partitionFor(modelID).maxBytes}
+		if err := next(modelID, recorder, r); err != nil {
+			return err
+		}
+
+		status := recorder.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		contentType := strings.ToLower(w.Header().Get("Content-Type"))
+		if status == http.StatusOK && !recorder.overflow && !strings.Contains(contentType, "text/event-stream") {
+			rc.put(modelID, key, status, w.Header(), bytes.Clone(recorder.body.Bytes()))
+		}
+		return nil
+	}
+}
+
+// cacheRecorder keeps a copy of the response body while writing it through
+type cacheRecorder struct {
+	http.ResponseWriter
+	status   int
+	body     bytes.Buffer
+	limit    int
+	overflow bool
+}
+
+func (c *cacheRecorder) WriteHeader(statusCode int) {
+	if c.status == 0 {
+		c.status = statusCode
+	}
+	c.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (c *cacheRecorder) Write(data []byte) (int, error) {
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	if !c.overflow {
+		if c.body.Len()+len(data) > c.limit {
+			c.overflow = true
+			c.body.Reset()
+		} else {
+			c.body.Write(data)
+		}
+	}
+	return c.ResponseWriter.Write(data)
+}
+
+func (c *cacheRecorder) Flush() {
+	if flusher, ok := c.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
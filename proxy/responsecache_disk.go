@@ -0,0 +1,184 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// diskCache keeps cached responses as files in dir, one per key, so they
+// survive restarts and config reloads. The oldest files are removed when
+// the files grow over maxBytes. A nil diskCache keeps nothing.
+type diskCache struct {
+	mu       sync.Mutex
+	dir      string
+	maxBytes int
+	size     int
+	files    map[string]diskCacheFile
+	logger   *LogMonitor
+}
+
+type diskCacheFile struct {
+	size    int
+	written time.Time
+}
+
+// diskCacheEntry is the content of a file of the disk cache
+type diskCacheEntry struct {
+	Model   string      `json:"model"`
+	Status  int         `json:"status"`
+	Header  http.Header `json:"header"`
+	Body    []byte      `json:"body"`
+	Expires time.Time   `json:"expires"`
+}
+
+// newDiskCache creates dir when needed and indexes the files already in it
+func newDiskCache(dir string, maxBytes int, logger *LogMonitor) (*diskCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	dc := &diskCache{dir: dir, maxBytes: maxBytes, files: make(map[string]diskCacheFile), logger: logger}
+	for _, entry := range entries {
+		key, found := strings.CutSuffix(entry.Name(), ".json")
+		if !found || entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		dc.files[key] = diskCacheFile{size: int(info.Size()), written: info.ModTime()}
+		dc.size += int(info.Size())
+	}
+	dc.mu.Lock()
+	dc.evict()
+	dc.mu.Unlock()
+	return dc, nil
+}
+
+func (dc *diskCache) path(key string) string {
+	return filepath.Join(dc.dir, key+".json")
+}
+
+// read returns the unexpired response stored under key
+func (dc *diskCache) read(key string) (*cachedResponse, bool) {
+	if dc == nil {
+		return nil, false
+	}
+	dc.mu.Lock()
+	_, found := dc.files[key]
+	dc.mu.Unlock()
+	if !found {
+		return nil, false
+	}
+
+	data, err := os.ReadFile(dc.path(key))
+	if err != nil {
+		dc.remove(key)
+		return nil, false
+	}
+	var stored diskCacheEntry
+	if err := json.Unmarshal(data, &stored); err != nil {
+		dc.logger.Warnf("responseCache: removing unreadable %s: %v", dc.path(key), err)
+		dc.remove(key)
+		return nil, false
+	}
+	if time.Now().After(stored.Expires) {
+		dc.remove(key)
+		return nil, false
+	}
+
+	return &cachedResponse{
+		key:     key,
+		model:   stored.Model,
+		status:  stored.Status,
+		header:  stored.Header,
+		body:    stored.Body,
+		expires: stored.Expires,
+	}, true
+}
+
+// write stores entry, replacing the file atomically so a crash never
+// leaves half of it
+func (dc *diskCache) write(entry *cachedResponse) {
+	data, err := json.Marshal(diskCacheEntry{
+		Model:   entry.model,
+		Status:  entry.status,
+		Header:  entry.header,
+		Body:    entry.body,
+		Expires: entry.expires,
+	})
+	if err != nil || len(data) > dc.maxBytes {
+		return
+	}
+
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	tmp := dc.path(entry.key) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		dc.logger.Warnf("responseCache: unable to write %s: %v", tmp, err)
+		return
+	}
+	if err := os.Rename(tmp, dc.path(entry.key)); err != nil {
+		dc.logger.Warnf("responseCache: unable to write %s: %v", dc.path(entry.key), err)
+		os.Remove(tmp)
+		return
+	}
+
+	if previous, found := dc.files[entry.key]; found {
+		dc.size -= previous.size
+	}
+	dc.files[entry.key] = diskCacheFile{size: len(data), written: time.Now()}
+	dc.size += len(data)
+	dc.evict()
+}
+
+// remove deletes the file of key
+func (dc *diskCache) remove(key string) {
+	if dc == nil {
+		return
+	}
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	dc.removeLocked(key)
+}
+
+func (dc *diskCache) removeLocked(key string) {
+	file, found := dc.files[key]
+	if !found {
+		return
+	}
+	os.Remove(dc.path(key))
+	delete(dc.files, key)
+	dc.size -= file.size
+}
+
+// evict removes the oldest files until they fit in maxBytes, must be called
+// with dc locked
+func (dc *diskCache) evict() {
+	if dc.size <= dc.maxBytes {
+		return
+	}
+	keys := make([]string, 0, len(dc.files))
+	for key := range dc.files {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return dc.files[keys[i]].written.Before(dc.files[keys[j]].written) })
+	for _, key := range keys {
+		if dc.size <= dc.maxBytes {
+			return
+		}
+		dc.removeLocked(key)
+	}
+}
@@ -0,0 +1,245 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseCache_CacheKey(t *testing.T) {
+	rc := newResponseCache(config.ResponseCacheConfig{Enabled: true}, nil, testLogger)
+
+	tests := []struct {
+		name      string
+		path      string
+		body      string
+		cacheable bool
+	}{
+		{"embeddings", "/v1/embeddings", `{"model":"m","input":"hello"}`, true},
+		{"temperature zero", "/v1/chat/completions", `{"model":"m","temperature":0,"messages":[]}`, true},
+		{"no temperature", "/v1/chat/completions", `{"model":"m","messages":[]}`, false},
+		{"temperature non-zero", "/v1/chat/completions", `{"model":"m","temperature":0.7}`, false},
+		{"streaming", "/v1/chat/completions", `{"model":"m","temperature":0,"stream":true}`, false},
+		{"unsupported path", "/v1/audio/speech", `{"model":"m","input":"hi"}`, false},
+		{"invalid json", "/v1/embeddings", `{"model":`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ok := rc.cacheKey("m", tt.path, []byte(tt.body))
+			assert.Equal(t, tt.cacheable, ok)
+		})
+	}
+
+	// key order, whitespace and model alias do not matter
+	key1, _ := rc.cacheKey("m", "/v1/embeddings", []byte(`{"model":"alias","input":"hi","encoding_format":"float"}`))
+	key2, _ := rc.cacheKey("m", "/v1/embeddings", []byte(`{ "encoding_format": "float", "input": "hi", "model": "m" }`))
+	assert.Equal(t, key1, key2)
+
+	// different models do not share entries
+	key3, _ := rc.cacheKey("other", "/v1/embeddings", []byte(`{"input":"hi","encoding_format":"float"}`))
+	assert.NotEqual(t, key1, key3)
+}
+
+func TestResponseCache_EvictionAndTTL(t *testing.T) {
+	rc := newResponseCache(config.ResponseCacheConfig{Enabled: true, MaxEntries: 2}, nil, testLogger)
+	header := http.Header{"Content-Type": []string{"application/json"}}
+
+	rc.put("m", "a", http.StatusOK, header, []byte("a"))
+	rc.put("m", "b", http.StatusOK, header, []byte("b"))
+	_, found := rc.get("a") // a is now most recently used
+	assert.True(t, found)
+
+	rc.put("m", "c", http.StatusOK, header, []byte("c"))
+	_, found = rc.get("b")
+	assert.False(t, found, "least recently used entry should be evicted")
+	_, found = rc.get("a")
+	assert.True(t, found)
+	assert.Equal(t, 2, rc.shared.lru.Len())
+	assert.Equal(t, 2, rc.shared.size)
+
+	rc.ttl = time.Millisecond
+	rc.put("m", "d", http.StatusOK, header, []byte("d"))
+	time.Sleep(5 * time.Millisecond)
+	_, found = rc.get("d")
+	assert.False(t, found, "expired entry should not be served")
+}
+
+func TestProxyManager_ResponseCache(t *testing.T) {
+	conf := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]config.ModelConfig{
+			"model1": getTestSimpleResponderConfig("model1"),
+		},
+		LogLevel:      "error",
+		ResponseCache: config.ResponseCacheConfig{Enabled: true},
+	})
+
+	proxy := New(conf)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	reqBody := `{"model":"model1","temperature":0,"prompt":"hello"}`
+	req := httptest.NewRequest("POST", "/v1/completions", bytes.NewBufferString(reqBody))
+	w := CreateTestResponseRecorder()
+	proxy.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("X-Cache"))
+	firstBody := w.Body.String()
+
+	// a cache hit should not start the upstream again
+	proxy.StopProcesses(StopWaitForInflightRequest)
+	process, _ := proxy.processGroups[config.DEFAULT_GROUP_ID].GetMember("model1")
+	assert.Equal(t, StateStopped, process.CurrentState())
+
+	req = httptest.NewRequest("POST", "/v1/completions", bytes.NewBufferString(reqBody))
+	w = CreateTestResponseRecorder()
+	proxy.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "HIT", w.Header().Get("X-Cache"))
+	assert.Equal(t, firstBody, w.Body.String())
+	assert.Equal(t, StateStopped, process.CurrentState())
+
+	// the hit is in the activity, without tokens
+	metrics := proxy.metricsMonitor.getMetrics()
+	require.Len(t, metrics, 2)
+	assert.False(t, metrics[0].CacheHit)
+	assert.True(t, metrics[1].CacheHit)
+	assert.Equal(t, "model1", metrics[1].Model)
+	assert.Zero(t, metrics[1].OutputTokens)
+}
+
+func TestProxyManager_ResponseCacheAdmission(t *testing.T) {
+	conf := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]config.ModelConfig{
+			"model1": getTestSimpleResponderConfig("model1"),
+		},
+		Tenants: map[string]config.TenantConfig{
+			"research": {Organizations: []string{"org-research"}, Models: []string{"model2"}},
+			"support":  {Organizations: []string{"org-support"}, MaxRequests: 1},
+		},
+		LogLevel:      "error",
+		ResponseCache: config.ResponseCacheConfig{Enabled: true},
+	})
+
+	proxy := New(conf)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	reqBody := `{"model":"model1","temperature":0,"prompt":"hello"}`
+	send := func(organization string) *TestResponseRecorder {
+		req := httptest.NewRequest("POST", "/v1/completions", bytes.NewBufferString(reqBody))
+		if organization != "" {
+			req.Header.Set("OpenAI-Organization", organization)
+		}
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		return w
+	}
+
+	// warm the cache without a tenant
+	require.Equal(t, http.StatusOK, send("").Code)
+	w := send("")
+	require.Equal(t, "HIT", w.Header().Get("X-Cache"))
+
+	// a tenant that may not use the model does not get its cached replies
+	w = send("org-research")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Empty(t, w.Header().Get("X-Cache"))
+	assert.Contains(t, w.Body.String(), "model model1 is not available to tenant research")
+
+	// cache hits count against quotas
+	w = send("org-support")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "HIT", w.Header().Get("X-Cache"))
+	w = send("org-support")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+}
+
+func TestResponseCache_ModelOverrides(t *testing.T) {
+	disabled := false
+	enabled := true
+	rc := newResponseCache(config.ResponseCacheConfig{Enabled: true, MaxEntries: 10}, map[string]config.ModelConfig{
+		"off":   {ResponseCache: config.ModelResponseCacheConfig{Enabled: &disabled}},
+		"small": {ResponseCache: config.ModelResponseCacheConfig{MaxEntries: 1, TTL: 60}},
+		"on":    {ResponseCache: config.ModelResponseCacheConfig{Enabled: &enabled}},
+	}, testLogger)
+
+	body := []byte(`{"input":"hi"}`)
+	_, ok := rc.cacheKey("off", "/v1/embeddings", body)
+	assert.False(t, ok)
+	_, ok = rc.cacheKey("small", "/v1/embeddings", body)
+	assert.True(t, ok)
+	assert.Equal(t, 60*time.Second, rc.ttlFor("small"))
+	assert.Equal(t, defaultResponseCacheTTL, rc.ttlFor("other"))
+
+	// the model with its own limits does not evict the others
+	header := http.Header{}
+	rc.put("other", "a", http.StatusOK, header, []byte("a"))
+	rc.put("small", "b", http.StatusOK, header, []byte("b"))
+	rc.put("small", "c", http.StatusOK, header, []byte("c"))
+	_, found := rc.get("a")
+	assert.True(t, found)
+	_, found = rc.get("b")
+	assert.False(t, found)
+	_, found = rc.get("c")
+	assert.True(t, found)
+	assert.Equal(t, 1, rc.partitions["small"].lru.Len())
+	assert.Equal(t, 1, rc.shared.lru.Len())
+
+	// a model can turn the cache on by itself
+	rc = newResponseCache(config.ResponseCacheConfig{}, map[string]config.ModelConfig{
+		"on": {ResponseCache: config.ModelResponseCacheConfig{Enabled: &enabled}},
+	}, testLogger)
+	_, ok = rc.cacheKey("on", "/v1/embeddings", body)
+	assert.True(t, ok)
+	_, ok = rc.cacheKey("other", "/v1/embeddings", body)
+	assert.False(t, ok)
+}
+
+func TestResponseCache_Disk(t *testing.T) {
+	dir := t.TempDir()
+	conf := config.ResponseCacheConfig{Enabled: true, Dir: dir}
+	header := http.Header{"Content-Type": []string{"application/json"}}
+
+	rc := newResponseCache(conf, nil, testLogger)
+	rc.put("m", "a", http.StatusOK, header, []byte(`{"a":1}`))
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(filepath.Join(dir, "a.json"))
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+
+	// a new cache, like after a restart, reads it back
+	rc = newResponseCache(conf, nil, testLogger)
+	entry, found := rc.get("a")
+	require.True(t, found)
+	assert.Equal(t, `{"a":1}`, string(entry.body))
+	assert.Equal(t, "application/json", entry.header.Get("Content-Type"))
+	assert.Equal(t, 1, rc.shared.lru.Len())
+
+	// expired files are removed
+	rc.disk.write(&cachedResponse{key: "b", model: "m", status: http.StatusOK, body: []byte("b"), expires: time.Now().Add(-time.Second)})
+	_, found = rc.get("b")
+	assert.False(t, found)
+	assert.NoFileExists(t, filepath.Join(dir, "b.json"))
+
+	// the oldest files go when they grow over the limit
+	disk, err := newDiskCache(t.TempDir(), 200, testLogger)
+	require.NoError(t, err)
+	for _, key := range []string{"c", "d", "e"} {
+		disk.write(&cachedResponse{key: key, model: "m", status: http.StatusOK, body: []byte(key), expires: time.Now().Add(time.Minute)})
+		time.Sleep(5 * time.Millisecond)
+	}
+	assert.LessOrEqual(t, disk.size, 200)
+	_, found = disk.read("c")
+	assert.False(t, found)
+	_, found = disk.read("e")
+	assert.True(t, found)
+}
@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/napmany/llmsnap/proxy/config"
+)
+
+// responseStamp is what the llmsnap response headers report about a request
+type responseStamp struct {
+	model    string
+	received time.Time
+
+	// set when the request is sent upstream
+	sent time.Time
+}
+
+// stampRequest notes the model and when the request was received for the
+// llmsnap response headers. Peer models have no local model ID and are named
+// as requested.
+func (pm *ProxyManager) stampRequest(c *gin.Context, modelID, requestedModel string) {
+	if len(pm.config.ResponseHeaders.Add) == 0 {
+		return
+	}
+	if modelID == "" {
+		modelID = requestedModel
+	}
+	stamp := &responseStamp{model: modelID, received: time.Now()}
+	c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), proxyCtxKey("stamp"), stamp))
+}
+
+func requestStamp(r *http.Request) *responseStamp {
+	stamp, _ := r.Context().Value(proxyCtxKey("stamp")).(*responseStamp)
+	return stamp
+}
+
+// applyResponseHeaders removes the upstream headers conf does not pass and
+// adds the llmsnap headers it lists to the responses of rp
+func applyResponseHeaders(rp *httputil.ReverseProxy, conf config.ResponseHeadersConfig) {
+	if rp == nil || !conf.Enabled() {
+		return
+	}
+
+	if len(conf.Add) > 0 {
+		originalDirector := rp.Director
+		rp.Director = func(req *http.Request) {
+			originalDirector(req)
+			if stamp := requestStamp(req); stamp != nil {
+				stamp.sent = time.Now()
+			}
+		}
+	}
+
+	originalModifyResponse := rp.ModifyResponse
+	rp.ModifyResponse = func(resp *http.Response) error {
+		// the headers of a protocol switch are needed to open the socket
+		if conf.Filters() && resp.StatusCode != http.StatusSwitchingProtocols {
+			for name := range resp.Header {
+				if !conf.Passes(name) {
+					resp.Header.Del(name)
+				}
+			}
+		}
+
+		if originalModifyResponse != nil {
+			if err := originalModifyResponse(resp); err != nil {
+				return err
+			}
+		}
+
+		stamp := requestStamp(resp.Request)
+		if stamp == nil {
+			return nil
+		}
+		if conf.Adds(config.ModelResponseHeader) {
+			resp.Header.Set(config.ModelResponseHeader, stamp.model)
+		}
+		if conf.Adds(config.QueueTimeResponseHeader) && !stamp.sent.IsZero() {
+			queued := stamp.sent.Sub(stamp.received).Milliseconds()
+			resp.Header.Set(config.QueueTimeResponseHeader, strconv.FormatInt(queued, 10))
+		}
+		return nil
+	}
+}
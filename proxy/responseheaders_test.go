@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyResponseHeaders(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Server", "llama.cpp")
+		w.Header().Set("X-Ratelimit-Remaining", "10")
+		w.Write([]byte(`{}`))
+	}))
+	defer upstream.Close()
+
+	target, err := url.Parse(upstream.URL)
+	require.NoError(t, err)
+	rp := httputil.NewSingleHostReverseProxy(target)
+	applyResponseHeaders(rp, config.ResponseHeadersConfig{
+		Allow: []string{"x-ratelimit-*", "server"},
+		Deny:  []string{"Server"},
+		Add:   []string{config.ModelResponseHeader, config.QueueTimeResponseHeader},
+	})
+
+	stamp := &responseStamp{model: "model1", received: time.Now().Add(-50 * time.Millisecond)}
+	req := httptest.NewRequest("GET", "/", nil)
+	req = req.WithContext(context.WithValue(req.Context(), proxyCtxKey("stamp"), stamp))
+	w := httptest.NewRecorder()
+	rp.ServeHTTP(w, req)
+
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.Equal(t, "10", w.Header().Get("X-Ratelimit-Remaining"))
+	assert.Empty(t, w.Header().Get("Server"))
+	assert.Empty(t, w.Header().Get("Date"))
+	assert.Equal(t, "model1", w.Header().Get(config.ModelResponseHeader))
+	queued, err := strconv.Atoi(w.Header().Get(config.QueueTimeResponseHeader))
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, queued, 50)
+
+	// requests that were not stamped get no llmsnap headers
+	w = httptest.NewRecorder()
+	rp.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Empty(t, w.Header().Get(config.ModelResponseHeader))
+}
+
+func TestProxyManager_ResponseHeaders(t *testing.T) {
+	conf := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]config.ModelConfig{
+			"model1": getTestSimpleResponderConfig("model1"),
+		},
+		ResponseHeaders: config.ResponseHeadersConfig{
+			Deny: []string{"Date"},
+			Add:  []string{config.ModelResponseHeader, config.QueueTimeResponseHeader},
+		},
+		LogLevel: "error",
+	})
+
+	proxy := New(conf)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model":"model1"}`))
+	w := CreateTestResponseRecorder()
+	proxy.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "model1", w.Header().Get(config.ModelResponseHeader))
+	assert.NotEmpty(t, w.Header().Get(config.QueueTimeResponseHeader))
+	assert.Empty(t, w.Header().Get("Date"))
+}
@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/napmany/llmsnap/event"
+)
+
+// file in stateDir listing the models that were loaded
+const loadedModelsFile = "loaded-models.json"
+
+type loadedModelsState struct {
+	Models []string `json:"models"`
+}
+
+// isLoaded reports if a process in this state counts as part of the
+// working set that is restored on startup
+func isLoaded(state ProcessState) bool {
+	switch state {
+	case StateStopped, StateStopping, StateShutdown, StateFailed:
+		return false
+	default:
+		return true
+	}
+}
+
+// loadedModels returns the sorted IDs of the loaded models
+func (pm *ProxyManager) loadedModels() []string {
+	var models []string
+	for _, processGroup := range pm.processGroups {
+		for modelID, process := range processGroup.processes {
+			if isLoaded(process.CurrentState()) {
+				models = append(models, modelID)
+			}
+		}
+	}
+	sort.Strings(models)
+	return models
+}
+
+// recordLoadedModels saves the loaded models to stateDir every time a model
+// is loaded or stopped. Models stopped while shutting down are not recorded
+// so the working set from before the shutdown is restored.
+func (pm *ProxyManager) recordLoadedModels() {
+	cancel := event.On(func(e ProcessStateChangeEvent) {
+		if pm.shuttingDown.Load() || pm.findGroupByModelName(e.ProcessName) == nil {
+			return
+		}
+		if e.NewState != StateReady && e.NewState != StateStopped && e.NewState != StateAsleep {
+			return
+		}
+		pm.saveLoadedModels()
+	})
+
+	go func() {
+		<-pm.shutdownCtx.Done()
+		cancel()
+	}()
+}
+
+func (pm *ProxyManager) saveLoadedModels() {
+	pm.loadedModelsMu.Lock()
+	defer pm.loadedModelsMu.Unlock()
+
+	if err := writeLoadedModels(pm.config.StateDir, pm.loadedModels()); err != nil {
+		pm.proxyLogger.Errorf("unable to save loaded models: %v", err)
+	}
+}
+
+func writeLoadedModels(dir string, models []string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(loadedModelsState{Models: models})
+	if err != nil {
+		return err
+	}
+
+	// write to a temporary file first so a crash never leaves a partial file
+	path := filepath.Join(dir, loadedModelsFile)
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// readLoadedModels returns the models saved in dir, none when nothing was
+// saved yet
+func readLoadedModels(dir string) ([]string, error) {
+	data, err := os.ReadFile(filepath.Join(dir, loadedModelsFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var state loadedModelsState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	return state.Models, nil
+}
+
+// modelsToRestore returns the saved models that are still configured
+func (pm *ProxyManager) modelsToRestore() []string {
+	saved, err := readLoadedModels(pm.config.StateDir)
+	if err != nil {
+		pm.proxyLogger.Warnf("unable to read loaded models: %v", err)
+		return nil
+	}
+
+	var models []string
+	for _, modelID := range saved {
+		if _, found := pm.config.Models[modelID]; found {
+			models = append(models, modelID)
+		} else {
+			pm.proxyLogger.Debugf("not restoring %s, it is no longer configured", modelID)
+		}
+	}
+	return models
+}
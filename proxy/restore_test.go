@@ -0,0 +1,59 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProxyManager_RestoreModels(t *testing.T) {
+	dir := t.TempDir()
+	models, err := readLoadedModels(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, models)
+
+	conf := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]config.ModelConfig{
+			"model1": getTestSimpleResponderConfig("model1"),
+			"model2": getTestSimpleResponderConfig("model2"),
+		},
+		Groups: map[string]config.GroupConfig{
+			"all": {Swap: false, Members: []string{"model1", "model2"}},
+		},
+		Hooks:    config.HooksConfig{OnStartup: config.HookOnStartup{RestoreModels: true}},
+		StateDir: dir,
+		LogLevel: "error",
+	})
+
+	proxy := New(conf)
+	req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model":"model1"}`))
+	w := CreateTestResponseRecorder()
+	proxy.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	assert.Eventually(t, func() bool {
+		models, _ := readLoadedModels(dir)
+		return len(models) == 1 && models[0] == "model1"
+	}, 5*time.Second, 50*time.Millisecond)
+
+	// stopping models during the shutdown does not change the saved models
+	proxy.Shutdown()
+	models, err = readLoadedModels(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"model1"}, models)
+
+	restored := New(conf)
+	defer restored.Shutdown()
+	process1, _ := restored.findGroupByModelName("model1").GetMember("model1")
+	process2, _ := restored.findGroupByModelName("model2").GetMember("model2")
+	assert.Eventually(t, func() bool {
+		return process1.CurrentState() == StateReady
+	}, 5*time.Second, 50*time.Millisecond)
+	assert.Equal(t, StateStopped, process2.CurrentState())
+}
@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// includeUsage asks for the usage in the last event of a streamed completion
+var includeUsage = config.Rewrite{Set: "stream_options.include_usage", Value: true, If: "stream"}
+
+// usageEndpoints report the usage of a stream only when asked
+var usageEndpoints = map[string]bool{
+	"/v1/chat/completions": true,
+	"/v1/completions":      true,
+}
+
+// applyRewrites runs rewrites on a JSON body in order. A rewrite that fails,
+// e.g. on an invalid path, is skipped.
+func applyRewrites(body []byte, rewrites []config.Rewrite) []byte {
+	for _, rewrite := range rewrites {
+		if rewrite.If != "" {
+			if cond := gjson.GetBytes(body, rewrite.If); !cond.Exists() || cond.Type == gjson.False || cond.Type == gjson.Null {
+				continue
+			}
+		}
+
+		var rewritten []byte
+		var err error
+		switch {
+		case rewrite.Set != "":
+			rewritten, err = sjson.SetBytes(body, rewrite.Set, rewrite.Value)
+		case rewrite.Delete != "":
+			rewritten, err = sjson.DeleteBytes(body, rewrite.Delete)
+		case rewrite.Rename != "":
+			value := gjson.GetBytes(body, rewrite.Rename)
+			if !value.Exists() {
+				continue
+			}
+			if rewritten, err = sjson.SetRawBytes(body, rewrite.To, []byte(value.Raw)); err == nil {
+				rewritten, err = sjson.DeleteBytes(rewritten, rewrite.Rename)
+			}
+		}
+		if err == nil {
+			body = rewritten
+		}
+	}
+	return body
+}
+
+// responseRewriter applies a model's responseRewrites
+type responseRewriter struct {
+	rewrites []config.Rewrite
+}
+
+func (rr *responseRewriter) transformBody(body []byte) []byte {
+	if !gjson.ValidBytes(body) {
+		return body
+	}
+	return applyRewrites(body, rr.rewrites)
+}
+
+func (rr *responseRewriter) transformEvent(payload []byte) [][]byte {
+	return [][]byte{applyRewrites(payload, rr.rewrites)}
+}
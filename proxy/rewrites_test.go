@@ -0,0 +1,91 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+func TestApplyRewrites(t *testing.T) {
+	rewrites := []config.Rewrite{
+		{Set: "stream_options.include_usage", Value: true, If: "stream"},
+		{Delete: "mirostat"},
+		{Rename: "max_completion_tokens", To: "max_tokens"},
+		{Rename: "missing", To: "other"},
+	}
+
+	body := applyRewrites([]byte(`{"model":"m","stream":true,"mirostat":2,"max_completion_tokens":10}`), rewrites)
+	assert.JSONEq(t, `{"model":"m","stream":true,"stream_options":{"include_usage":true},"max_tokens":10}`, string(body))
+
+	body = applyRewrites([]byte(`{"model":"m","stream":false}`), rewrites)
+	assert.JSONEq(t, `{"model":"m","stream":false}`, string(body))
+}
+
+func TestProxyManager_Rewrites(t *testing.T) {
+	modelConfig := getTestSimpleResponderConfig("model1")
+	modelConfig.RequestRewrites = []config.Rewrite{
+		{Set: "stream_options.include_usage", Value: true},
+		{Delete: "mirostat"},
+	}
+	modelConfig.ResponseRewrites = []config.Rewrite{
+		{Delete: "timings"},
+		{Set: "system_fingerprint", Value: "llmsnap"},
+	}
+	conf := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]config.ModelConfig{
+			"model1": modelConfig,
+		},
+		LogLevel: "error",
+	})
+
+	proxy := New(conf)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model":"model1","mirostat":2}`))
+	w := CreateTestResponseRecorder()
+	proxy.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	response := gjson.Parse(w.Body.String())
+	assert.JSONEq(t, `{"model":"model1","stream_options":{"include_usage":true}}`, response.Get("request_body").String())
+	assert.False(t, response.Get("timings").Exists())
+	assert.Equal(t, "llmsnap", response.Get("system_fingerprint").String())
+}
+
+func TestProxyManager_EnsureUsage(t *testing.T) {
+	modelConfig := getTestSimpleResponderConfig("model1")
+	modelConfig.EnsureUsage = true
+	conf := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]config.ModelConfig{
+			"model1": modelConfig,
+		},
+		LogLevel: "error",
+	})
+
+	proxy := New(conf)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	tests := []struct {
+		body     string
+		expected string
+	}{
+		{`{"model":"model1","stream":true}`, `{"model":"model1","stream":true,"stream_options":{"include_usage":true}}`},
+		{`{"model":"model1","stream":true,"stream_options":{"include_usage":false}}`, `{"model":"model1","stream":true,"stream_options":{"include_usage":true}}`},
+		{`{"model":"model1","stream":false}`, `{"model":"model1","stream":false}`},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(tt.body))
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, tt.expected, gjson.Get(w.Body.String(), "request_body").String())
+	}
+}
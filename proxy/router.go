@@ -0,0 +1,38 @@
+package proxy
+
+import (
+	"fmt"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// applyRouter sends a request for one of the routers to the model of the
+// first rule that fits the estimated prompt tokens plus the requested
+// max_tokens. The model is set in the body and returned. Requests for other
+// models are returned unchanged.
+func (pm *ProxyManager) applyRouter(body []byte, requestedModel string) ([]byte, string, error) {
+	router, found := pm.config.Routers[requestedModel]
+	if !found {
+		return body, requestedModel, nil
+	}
+
+	tokens := estimateTokens(body)
+	for _, key := range []string{"max_completion_tokens", "max_tokens", "max_output_tokens"} {
+		if maxTokens := gjson.GetBytes(body, key); maxTokens.Exists() {
+			tokens += int(maxTokens.Int())
+			break
+		}
+	}
+
+	modelID, found := router.Pick(tokens)
+	if !found {
+		return nil, "", fmt.Errorf("request of about %d tokens is too large for every model of %s", tokens, requestedModel)
+	}
+	body, err := sjson.SetBytes(body, "model", modelID)
+	if err != nil {
+		return nil, "", err
+	}
+	pm.proxyLogger.Debugf("<%s> routing request of about %d tokens to %s", requestedModel, tokens, modelID)
+	return body, modelID, nil
+}
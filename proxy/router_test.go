@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+func TestEstimateTokens(t *testing.T) {
+	// 4 characters a token and 4 tokens for each message
+	assert.Equal(t, 3+4, estimateTokens([]byte(`{"messages":[{"role":"user","content":"hello world!"}]}`)))
+	assert.Equal(t, 2+8, estimateTokens([]byte(`{"messages":[
+		{"role":"system","content":"brief"},
+		{"role":"user","content":[{"type":"text","text":"hi"},{"type":"image_url","image_url":{"url":"data:image/png;base64,AAAAAAAAAAAAAAAA"}}]}
+	]}`)))
+	assert.Equal(t, 4, estimateTokens([]byte(`{"prompt":"complete this"}`)))
+	assert.Equal(t, 0, estimateTokens([]byte(`{"model":"m","max_tokens":100}`)))
+	assert.Equal(t, 250, estimateTokens([]byte(fmt.Sprintf(`{"input":%q}`, strings.Repeat("x", 1000)))))
+}
+
+func TestProxyManager_Router(t *testing.T) {
+	conf := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]config.ModelConfig{
+			"small": getTestSimpleResponderConfig("small"),
+			"big":   getTestSimpleResponderConfig("big"),
+		},
+		Routers: map[string]config.RouterConfig{
+			"auto": {
+				Name: "Auto",
+				Route: []config.RouteRule{
+					{MaxTokens: 100, Model: "small"},
+					{MaxTokens: 1000, Model: "big"},
+				},
+			},
+		},
+		LogLevel: "error",
+	})
+
+	proxy := New(conf)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	send := func(content string, maxTokens int) *TestResponseRecorder {
+		reqBody := fmt.Sprintf(`{"model":"auto","max_tokens":%d,"messages":[{"role":"user","content":%q}]}`, maxTokens, content)
+		req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(reqBody))
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send("hello", 10)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "small", gjson.Get(w.Body.String(), "responseMessage").String())
+
+	// the requested max_tokens count towards the size
+	w = send("hello", 500)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "big", gjson.Get(w.Body.String(), "responseMessage").String())
+
+	w = send(strings.Repeat("long text ", 1000), 10)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "too large for every model of auto")
+
+	// the router is published next to the models
+	req := httptest.NewRequest("GET", "/v1/models", nil)
+	w = CreateTestResponseRecorder()
+	proxy.ServeHTTP(w, req)
+	assert.Equal(t, "Auto", gjson.Get(w.Body.String(), `data.#(id=="auto").name`).String())
+}
@@ -0,0 +1,159 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RunningModel is a loaded model as reported by /running
+type RunningModel struct {
+	Model       string       `json:"model"`
+	State       ProcessState `json:"state"`
+	Cmd         string       `json:"cmd"`
+	Proxy       string       `json:"proxy"`
+	TTL         int          `json:"ttl"`
+	Name        string       `json:"name"`
+	Description string       `json:"description"`
+
+	// PID of the upstream command, 0 when it is not known
+	PID int `json:"pid,omitempty"`
+
+	// state of the model's draft server, empty without a draft
+	DraftState ProcessState `json:"draft_state,omitempty"`
+
+	// seconds since the model became ready
+	UptimeSeconds int `json:"uptime_seconds"`
+
+	InFlight       int   `json:"in_flight"`
+	InFlightStream int   `json:"in_flight_streaming"`
+	Queued         int   `json:"queued"`
+	RequestsServed int64 `json:"requests_served"`
+
+	// memory of the upstream command, null when it can not be read
+	RSSMiB  *int `json:"rss_mib"`
+	VRAMMiB *int `json:"vram_mib"`
+
+	// seconds until the model is unloaded, null without a ttl
+	TTLRemaining *int `json:"ttl_remaining"`
+}
+
+// pid returns the process ID of the upstream command, 0 when it is not
+// running
+func (p *Process) pid() int {
+	p.cmdMutex.RLock()
+	defer p.cmdMutex.RUnlock()
+	if p.cmd == nil || p.cmd.Process == nil {
+		return 0
+	}
+	return p.cmd.Process.Pid
+}
+
+// ttlRemaining returns the seconds until the unload ttl is reached. It is
+// the full ttl while requests are in flight as the countdown starts after
+// the last one.
+func (p *Process) ttlRemaining(now time.Time) (int, bool) {
+	ttl := p.unloadAfter()
+	if ttl <= 0 {
+		return 0, false
+	}
+	if p.inFlightRequestsCount.Load() > 0 || p.keptLoaded() {
+		return ttl, true
+	}
+	idle := int(now.Sub(p.getLastRequestHandled()).Seconds())
+	return max(ttl-idle, 0), true
+}
+
+// runningModels returns the loaded models sorted by ID
+func (pm *ProxyManager) runningModels() []RunningModel {
+	now := time.Now()
+	running := []RunningModel{}
+	for _, processGroup := range pm.processGroups {
+		for _, process := range processGroup.instances() {
+			state := process.CurrentState()
+			if !isLoaded(state) || state == StateStarting {
+				continue
+			}
+
+			model := RunningModel{
+				Model:          process.ID,
+				State:          state,
+				Cmd:            process.config.Cmd,
+				Proxy:          process.config.Proxy,
+				TTL:            process.config.UnloadAfter,
+				Name:           process.config.Name,
+				Description:    process.config.Description,
+				PID:            process.pid(),
+				InFlight:       int(process.inFlightRequestsCount.Load()),
+				InFlightStream: int(process.inFlightStreamingCount.Load()),
+				RequestsServed: process.requestsServed.Load(),
+			}
+			if process.draft != nil {
+				model.DraftState = process.draft.CurrentState()
+			}
+			if readyAt := process.readyAt.Load(); readyAt > 0 {
+				model.UptimeSeconds = int(now.Sub(time.Unix(0, readyAt)).Seconds())
+			}
+			if pm.scheduler != nil {
+				model.Queued = pm.scheduler.queuedFor(process.ID)
+			}
+			if remaining, ok := process.ttlRemaining(now); ok {
+				model.TTLRemaining = &remaining
+			}
+			if model.PID != 0 {
+				if rss, err := processRSS(model.PID); err == nil {
+					model.RSSMiB = &rss
+				}
+			}
+			running = append(running, model)
+		}
+	}
+	sort.Slice(running, func(i, j int) bool { return running[i].Model < running[j].Model })
+
+	if len(running) > 0 && pm.readGPUProcesses != nil {
+		if used, err := pm.readGPUProcesses(); err == nil {
+			for i := range running {
+				if vram, found := used[running[i].PID]; found && running[i].PID != 0 {
+					running[i].VRAMMiB = &vram
+				}
+			}
+		}
+	}
+	return running
+}
+
+// processRSS returns the resident memory of pid in MiB. It reads /proc and
+// fails on systems without it.
+func processRSS(pid int) (int, error) {
+	file, err := os.Open(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	return parseProcStatusRSS(file)
+}
+
+// parseProcStatusRSS reads the VmRSS line of /proc/<pid>/status
+func parseProcStatusRSS(status io.Reader) (int, error) {
+	scanner := bufio.NewScanner(status)
+	for scanner.Scan() {
+		value, found := strings.CutPrefix(scanner.Text(), "VmRSS:")
+		if !found {
+			continue
+		}
+		kb, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(value), " kB"))
+		if err != nil {
+			return 0, fmt.Errorf("invalid VmRSS %q", value)
+		}
+		return kb / 1024, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("no VmRSS in status")
+}
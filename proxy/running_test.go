@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseNvidiaSMIProcesses(t *testing.T) {
+	used, err := parseNvidiaSMIProcesses("1234, 8000\n1234, 2000\n5678, 512\n")
+	require.NoError(t, err)
+	assert.Equal(t, map[int]int{1234: 10000, 5678: 512}, used)
+
+	_, err = parseNvidiaSMIProcesses("[N/A], 512\n")
+	assert.ErrorContains(t, err, "invalid pid")
+}
+
+func TestParseProcStatusRSS(t *testing.T) {
+	rss, err := parseProcStatusRSS(strings.NewReader("Name:\tllama-server\nVmPeak:\t 9000000 kB\nVmRSS:\t 2097152 kB\n"))
+	require.NoError(t, err)
+	assert.Equal(t, 2048, rss)
+
+	_, err = parseProcStatusRSS(strings.NewReader("Name:\tllama-server\n"))
+	assert.Error(t, err)
+}
+
+func TestProxyManager_RunningModelDetails(t *testing.T) {
+	model1 := getTestSimpleResponderConfig("model1")
+	model1.UnloadAfter = 300
+	conf := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]config.ModelConfig{
+			"model1": model1,
+		},
+		LogLevel: "error",
+	})
+
+	proxy := New(conf)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	for range 2 {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model":"model1"}`))
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	process, _ := proxy.findGroupByModelName("model1").GetMember("model1")
+	proxy.readGPUProcesses = func() (map[int]int, error) {
+		return map[int]int{process.pid(): 4096}, nil
+	}
+
+	req := httptest.NewRequest("GET", "/running", nil)
+	w := CreateTestResponseRecorder()
+	proxy.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var response struct {
+		Running []RunningModel `json:"running"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	require.Len(t, response.Running, 1)
+
+	running := response.Running[0]
+	assert.Equal(t, StateReady, running.State)
+	assert.NotZero(t, running.PID)
+	assert.Equal(t, 0, running.InFlight)
+	assert.Equal(t, 0, running.Queued)
+	assert.Equal(t, int64(2), running.RequestsServed)
+	if assert.NotNil(t, running.TTLRemaining) {
+		assert.InDelta(t, 300, *running.TTLRemaining, 2)
+	}
+	if assert.NotNil(t, running.VRAMMiB) {
+		assert.Equal(t, 4096, *running.VRAMMiB)
+	}
+	if runtime.GOOS == "linux" {
+		assert.NotNil(t, running.RSSMiB)
+	}
+}
@@ -0,0 +1,284 @@
+package proxy
+
+import (
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/napmany/llmsnap/proxy/config"
+)
+
+// interval between schedule checks
+const scheduleCheckInterval = time.Minute
+
+// warmSchedules keeps the models of active schedules loaded
+type warmSchedules struct {
+	sync.RWMutex
+	schedules []config.ScheduleConfig
+
+	// model IDs of each schedule
+	models [][]string
+
+	// schedules active at the last check
+	active []bool
+}
+
+// keepLoaded reports if modelID is in an active schedule
+func (w *warmSchedules) keepLoaded(modelID string) bool {
+	if w == nil {
+		return false
+	}
+	w.RLock()
+	defer w.RUnlock()
+	for i, active := range w.active {
+		if active && slices.Contains(w.models[i], modelID) {
+			return true
+		}
+	}
+	return false
+}
+
+func newWarmSchedules(conf config.Config) *warmSchedules {
+	w := &warmSchedules{
+		schedules: conf.Schedules,
+		models:    make([][]string, len(conf.Schedules)),
+		active:    make([]bool, len(conf.Schedules)),
+	}
+	for i, schedule := range conf.Schedules {
+		models := slices.Clone(schedule.Models)
+		for _, groupID := range schedule.Groups {
+			for _, member := range conf.Groups[groupID].Members {
+				if !slices.Contains(models, member) {
+					models = append(models, member)
+				}
+			}
+		}
+		w.models[i] = models
+	}
+	return w
+}
+
+// cronSchedule is the schedule of a model or of the members of a group
+type cronSchedule struct {
+	// model or group ID, for logs
+	name   string
+	models []string
+	loc    *time.Location
+
+	// nil when not set
+	preload, sleep, unload *config.Cron
+}
+
+// cronSchedules runs the cron schedules of models and groups
+type cronSchedules struct {
+	schedules []cronSchedule
+
+	// the minute checked last, only used by checkCron
+	last time.Time
+}
+
+// newCronSchedules returns the cron schedules of conf, nil when there are
+// none. Minutes up to now are not run.
+func newCronSchedules(conf config.Config, now time.Time) *cronSchedules {
+	c := &cronSchedules{last: now.Truncate(time.Minute)}
+	add := func(name string, schedule config.CronSchedule, models []string) {
+		if !schedule.Enabled() {
+			return
+		}
+		parse := func(expr string) *config.Cron {
+			if expr == "" {
+				return nil
+			}
+			cron, _ := config.ParseCron(expr)
+			return &cron
+		}
+		c.schedules = append(c.schedules, cronSchedule{
+			name:    name,
+			models:  models,
+			loc:     schedule.Location(),
+			preload: parse(schedule.Preload),
+			sleep:   parse(schedule.Sleep),
+			unload:  parse(schedule.Unload),
+		})
+	}
+
+	for _, modelID := range slices.Sorted(maps.Keys(conf.Models)) {
+		add(modelID, conf.Models[modelID].Schedule, []string{modelID})
+	}
+	for _, groupID := range slices.Sorted(maps.Keys(conf.Groups)) {
+		add(groupID, conf.Groups[groupID].Schedule, conf.Groups[groupID].Members)
+	}
+	if len(c.schedules) == 0 {
+		return nil
+	}
+	return c
+}
+
+// watchSchedules checks the schedules every minute until shutdown
+func (pm *ProxyManager) watchSchedules() {
+	ticker := time.NewTicker(scheduleCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-pm.shutdownCtx.Done():
+			return
+		case now := <-ticker.C:
+			pm.checkSchedules(now)
+			pm.checkCron(now)
+		}
+	}
+}
+
+// checkCron runs the cron schedules of the minutes since the last check, at
+// most an hour of them. Unloading and sleeping go before preloading. Models
+// are preloaded one after another, so a member of a swapping group does not
+// swap out the one loaded before it.
+func (pm *ProxyManager) checkCron(now time.Time) {
+	c := pm.cron
+	if c == nil {
+		return
+	}
+
+	minute := now.Truncate(time.Minute)
+	from := c.last.Add(time.Minute)
+	if earliest := minute.Add(-time.Hour); from.Before(earliest) {
+		from = earliest
+	}
+	c.last = minute
+	for at := from; !at.After(minute); at = at.Add(time.Minute) {
+		for _, schedule := range c.schedules {
+			local := at.In(schedule.loc)
+			switch {
+			case schedule.unload != nil && schedule.unload.Matches(local):
+				pm.proxyLogger.Infof("schedule of %s: unloading", schedule.name)
+				for _, modelID := range schedule.models {
+					pm.afterHours(modelID, config.ScheduleUnload)
+				}
+			case schedule.sleep != nil && schedule.sleep.Matches(local):
+				pm.proxyLogger.Infof("schedule of %s: sleeping", schedule.name)
+				for _, modelID := range schedule.models {
+					pm.afterHours(modelID, config.ScheduleSleep)
+				}
+			}
+			if schedule.preload != nil && schedule.preload.Matches(local) {
+				pm.proxyLogger.Infof("schedule of %s: preloading", schedule.name)
+				for _, modelID := range schedule.models {
+					pm.preloadOnSchedule(schedule, modelID)
+				}
+			}
+		}
+	}
+}
+
+// preloadOnSchedule starts or wakes modelID. Running models are swapped out
+// unless they are part of the same schedule.
+func (pm *ProxyManager) preloadOnSchedule(schedule cronSchedule, modelID string) {
+	if holdsVRAM(pm.modelState(modelID)) {
+		return
+	}
+	for _, process := range pm.evictedBySwap(modelID) {
+		if slices.Contains(schedule.models, process.ID) {
+			pm.proxyLogger.Debugf("<%s> not preloading for schedule of %s, it would swap out %s", modelID, schedule.name, process.ID)
+			return
+		}
+	}
+	if err := pm.scheduleAdmission(modelID); err != nil {
+		pm.proxyLogger.Debugf("<%s> not preloading for schedule of %s: %v", modelID, schedule.name, err)
+		return
+	}
+
+	pm.proxyLogger.Infof("<%s> preloading for schedule of %s", modelID, schedule.name)
+	pm.preloadModel(modelID)
+}
+
+// checkSchedules loads the models of the schedules active at now and applies
+// afterHours to the models of schedules that ended
+func (pm *ProxyManager) checkSchedules(now time.Time) {
+	w := pm.schedules
+	if w == nil {
+		return
+	}
+
+	w.Lock()
+	var warm, ended []int
+	for i, schedule := range w.schedules {
+		active := schedule.Active(now)
+		if active {
+			warm = append(warm, i)
+		} else if w.active[i] {
+			ended = append(ended, i)
+		}
+		w.active[i] = active
+	}
+	w.Unlock()
+
+	for _, i := range ended {
+		schedule := w.schedules[i]
+		pm.proxyLogger.Infof("schedule %s ended", schedule.Name)
+		for _, modelID := range w.models[i] {
+			if !w.keepLoaded(modelID) {
+				pm.afterHours(modelID, schedule.AfterHours)
+			}
+		}
+	}
+
+	for _, i := range warm {
+		for _, modelID := range w.models[i] {
+			pm.warmModel(w.schedules[i].Name, modelID)
+		}
+	}
+}
+
+// warmModel starts or wakes modelID unless that would swap out a running model
+func (pm *ProxyManager) warmModel(scheduleName, modelID string) {
+	if holdsVRAM(pm.modelState(modelID)) {
+		return
+	}
+	if len(pm.evictedBySwap(modelID)) > 0 {
+		pm.proxyLogger.Debugf("<%s> not loading for schedule %s, it would swap out running models", modelID, scheduleName)
+		return
+	}
+	if err := pm.scheduleAdmission(modelID); err != nil {
+		pm.proxyLogger.Debugf("<%s> not loading for schedule %s: %v", modelID, scheduleName, err)
+		return
+	}
+
+	pm.proxyLogger.Infof("<%s> loading for schedule %s", modelID, scheduleName)
+	go pm.preloadModel(modelID)
+}
+
+// scheduleAdmission returns why modelID may not be loaded by a schedule, nil
+// when it may
+func (pm *ProxyManager) scheduleAdmission(modelID string) error {
+	if err := pm.checkQuarantine(modelID); err != nil {
+		return err
+	}
+	if err := pm.checkBatteryAdmission(modelID); err != nil {
+		return err
+	}
+	return pm.checkThermalAdmission(modelID)
+}
+
+// afterHours applies a schedule's afterHours action to modelID
+func (pm *ProxyManager) afterHours(modelID string, action config.ScheduleAction) {
+	processGroup := pm.findGroupByModelName(modelID)
+	if processGroup == nil {
+		return
+	}
+	process, ok := processGroup.GetMember(modelID)
+	if !ok || process.CurrentState() != StateReady {
+		return
+	}
+
+	switch action {
+	case config.ScheduleUnload:
+		pm.proxyLogger.Infof("<%s> unloading after schedule", modelID)
+		go processGroup.StopProcess(modelID, StopWaitForInflightRequest)
+	case config.ScheduleSleep:
+		if process.isSleepEnabled() {
+			pm.proxyLogger.Infof("<%s> sleeping after schedule", modelID)
+			go processGroup.SleepProcess(modelID)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProxyManager_Schedules(t *testing.T) {
+	// a window that is not active now, so only the checks below change it
+	now := time.Now().UTC()
+	start := now.Add(2 * time.Hour)
+
+	model1 := getTestSimpleResponderConfig("model1")
+	model1.UnloadAfter = 1
+	conf := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]config.ModelConfig{
+			"model1": model1,
+			"model2": getTestSimpleResponderConfig("model2"),
+		},
+		Groups: map[string]config.GroupConfig{
+			"office": {Swap: false, Members: []string{"model1"}},
+			"other":  {Swap: true, Members: []string{"model2"}},
+		},
+		Schedules: []config.ScheduleConfig{{
+			Name:       "test",
+			Start:      start.Format("15:04"),
+			End:        start.Add(time.Hour).Format("15:04"),
+			Timezone:   "UTC",
+			Groups:     []string{"office"},
+			AfterHours: config.ScheduleUnload,
+		}},
+		LogLevel: "error",
+	})
+
+	proxy := New(conf)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+	process, _ := proxy.findGroupByModelName("model1").GetMember("model1")
+	assert.Equal(t, StateStopped, process.CurrentState())
+
+	proxy.checkSchedules(start.Add(30 * time.Minute))
+	assert.Eventually(t, func() bool {
+		return process.CurrentState() == StateReady
+	}, 5*time.Second, 50*time.Millisecond)
+	assert.True(t, proxy.schedules.keepLoaded("model1"))
+	assert.False(t, proxy.schedules.keepLoaded("model2"))
+
+	// the ttl is paused during the window
+	time.Sleep(2500 * time.Millisecond)
+	assert.Equal(t, StateReady, process.CurrentState())
+
+	proxy.checkSchedules(start.Add(90 * time.Minute))
+	assert.Eventually(t, func() bool {
+		return process.CurrentState() == StateStopped
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestProxyManager_CronSchedule(t *testing.T) {
+	model1 := getTestSimpleResponderConfig("model1")
+	model1.Schedule = config.CronSchedule{Preload: "0 8 * * *", Unload: "0 20 * * *", Timezone: "UTC"}
+	conf := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]config.ModelConfig{
+			"model1": model1,
+			"model2": getTestSimpleResponderConfig("model2"),
+		},
+		LogLevel: "error",
+	})
+
+	proxy := New(conf)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+	process, _ := proxy.findGroupByModelName("model1").GetMember("model1")
+	assert.Equal(t, StateStopped, process.CurrentState())
+
+	day := time.Date(2026, time.October, 12, 0, 0, 0, 0, time.UTC)
+	proxy.cron.last = day.Add(7*time.Hour + 58*time.Minute)
+	proxy.checkCron(day.Add(7*time.Hour + 59*time.Minute))
+	assert.Equal(t, StateStopped, process.CurrentState())
+
+	// a missed minute is still run
+	proxy.checkCron(day.Add(8*time.Hour + 1*time.Minute))
+	assert.Eventually(t, func() bool {
+		return process.CurrentState() == StateReady
+	}, 5*time.Second, 50*time.Millisecond)
+
+	proxy.checkCron(day.Add(20 * time.Hour))
+	assert.Eventually(t, func() bool {
+		return process.CurrentState() == StateStopped
+	}, 5*time.Second, 50*time.Millisecond)
+}
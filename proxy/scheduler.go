@@ -0,0 +1,219 @@
+package proxy
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requestScheduler limits the number of requests proxied at the same time.
+// When all slots are busy requests wait in a queue and are released highest
+// priority first, in arrival order for equal priorities.
+type requestScheduler struct {
+	mu      sync.Mutex
+	slots   int
+	running int
+	queue   schedulerQueue
+	seq     uint64
+
+	// moving average of how long a slot is held, in nanoseconds
+	holdDuration atomic.Int64
+}
+
+type schedulerWaiter struct {
+	model    string
+	priority int
+	seq      uint64
+	ready    chan struct{}
+	index    int
+}
+
+func newRequestScheduler(slots int) *requestScheduler {
+	return &requestScheduler{slots: slots}
+}
+
+// acquire blocks until the request for modelID may proceed or ctx is done
+func (s *requestScheduler) acquire(ctx context.Context, modelID string, priority int) error {
+	s.mu.Lock()
+	if s.running < s.slots && s.queue.Len() == 0 {
+		s.running++
+		s.mu.Unlock()
+		return nil
+	}
+
+	s.seq++
+	w := &schedulerWaiter{
+		model:    modelID,
+		priority: priority,
+		seq:      s.seq,
+		ready:    make(chan struct{}),
+	}
+	heap.Push(&s.queue, w)
+	s.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		if w.index >= 0 {
+			heap.Remove(&s.queue, w.index)
+			s.mu.Unlock()
+			return ctx.Err()
+		}
+		s.mu.Unlock()
+
+		// the slot was handed over while giving up, pass it on
+		s.release()
+		return ctx.Err()
+	}
+}
+
+// release frees a slot, handing it directly to the next waiter
+func (s *requestScheduler) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.queue.Len() > 0 {
+		w := heap.Pop(&s.queue).(*schedulerWaiter)
+		close(w.ready)
+		return
+	}
+	s.running--
+}
+
+// estimatedWait guesses how long a new request waits for a slot from the
+// number of queued requests and the average time a slot is held
+func (s *requestScheduler) estimatedWait() time.Duration {
+	s.mu.Lock()
+	ahead := s.queue.Len()
+	if s.running >= s.slots {
+		ahead++
+	}
+	s.mu.Unlock()
+
+	if ahead == 0 {
+		return 0
+	}
+	hold := time.Duration(s.holdDuration.Load())
+	return hold * time.Duration(ahead) / time.Duration(s.slots)
+}
+
+// queued returns the number of waiting requests
+func (s *requestScheduler) queued() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.queue.Len()
+}
+
+// queuedFor returns the number of waiting requests for modelID
+func (s *requestScheduler) queuedFor(modelID string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	count := 0
+	for _, w := range s.queue {
+		if w.model == modelID {
+			count++
+		}
+	}
+	return count
+}
+
+// schedulerQueue implements heap.Interface
+type schedulerQueue []*schedulerWaiter
+
+func (q schedulerQueue) Len() int { return len(q) }
+
+func (q schedulerQueue) Less(i, j int) bool {
+	if q[i].priority != q[j].priority {
+		return q[i].priority > q[j].priority
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q schedulerQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *schedulerQueue) Push(x any) {
+	w := x.(*schedulerWaiter)
+	w.index = len(*q)
+	*q = append(*q, w)
+}
+
+func (q *schedulerQueue) Pop() any {
+	old := *q
+	n := len(old)
+	w := old[n-1]
+	old[n-1] = nil
+	w.index = -1
+	*q = old[:n-1]
+	return w
+}
+
+// requestPriority resolves the priority of a request. A priority header
+// wins over the API key's priority which wins over the model's priority.
+func (pm *ProxyManager) requestPriority(c *gin.Context, modelID string) int {
+	schedulerConfig := pm.config.Scheduler
+	if schedulerConfig.PriorityHeader != "" {
+		if value := strings.TrimSpace(c.GetHeader(schedulerConfig.PriorityHeader)); value != "" {
+			if priority, err := strconv.Atoi(value); err == nil {
+				return priority
+			}
+		}
+	}
+
+	if apiKey := c.GetString(apiKeyContextKey); apiKey != "" {
+		if priority, found := schedulerConfig.APIKeyPriority[apiKey]; found {
+			return priority
+		}
+	}
+
+	if modelConfig, found := pm.config.Models[modelID]; found {
+		return modelConfig.Priority
+	}
+	return 0
+}
+
+// scheduleRequest waits for the scheduler to let the request through. The
+// returned release func must be called when the request is done. When ok is
+// false a response has already been sent.
+func (pm *ProxyManager) scheduleRequest(c *gin.Context, modelID string) (release func(), ok bool) {
+	if pm.scheduler == nil {
+		return func() {}, true
+	}
+
+	ctx := c.Request.Context()
+	if maxWait := pm.config.Scheduler.MaxWait; maxWait > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(maxWait)*time.Second)
+		defer cancel()
+	}
+
+	priority := pm.requestPriority(c, modelID)
+	if err := pm.scheduler.acquire(ctx, modelID, priority); err != nil {
+		if errors.Is(err, context.DeadlineExceeded) || queueTimedOut(c.Request) {
+			pm.sendErrorResponse(c, http.StatusServiceUnavailable, fmt.Sprintf("request for %s waited too long in the queue", modelID))
+		} else {
+			pm.proxyLogger.Debugf("<%s> client went away while queued", modelID)
+		}
+		return nil, false
+	}
+
+	acquired := time.Now()
+	return func() {
+		observeDuration(&pm.scheduler.holdDuration, time.Since(acquired))
+		pm.scheduler.release()
+	}, true
+}
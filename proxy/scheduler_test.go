@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"context"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestScheduler_PriorityOrder(t *testing.T) {
+	s := newRequestScheduler(1)
+	assert.NoError(t, s.acquire(context.Background(), "model", 0))
+
+	var mu sync.Mutex
+	var order []int
+	var wg sync.WaitGroup
+
+	for i, priority := range []int{1, 10, 5, 10} {
+		wg.Add(1)
+		go func(priority int) {
+			defer wg.Done()
+			assert.NoError(t, s.acquire(context.Background(), "model", priority))
+			mu.Lock()
+			order = append(order, priority)
+			mu.Unlock()
+			s.release()
+		}(priority)
+
+		// make sure arrival order is deterministic
+		assert.Eventually(t, func() bool {
+			return s.queued() == i+1
+		}, time.Second, time.Millisecond)
+	}
+
+	s.release()
+	wg.Wait()
+	assert.Equal(t, []int{10, 10, 5, 1}, order)
+}
+
+func TestRequestScheduler_CancelWhileQueued(t *testing.T) {
+	s := newRequestScheduler(1)
+	assert.NoError(t, s.acquire(context.Background(), "model", 0))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, s.acquire(ctx, "model", 0), context.DeadlineExceeded)
+	assert.Equal(t, 0, s.queued())
+
+	s.release()
+	assert.NoError(t, s.acquire(context.Background(), "model", 0), "slot should be free again")
+}
+
+func TestProxyManager_RequestPriority(t *testing.T) {
+	model1 := getTestSimpleResponderConfig("model1")
+	model1.Priority = 3
+
+	pm := &ProxyManager{
+		config: config.Config{
+			Models: map[string]config.ModelConfig{"model1": model1},
+			Scheduler: config.SchedulerConfig{
+				MaxConcurrent:  1,
+				PriorityHeader: "X-Priority",
+				APIKeyPriority: map[string]int{"sk-batch": -5},
+			},
+		},
+	}
+
+	newContext := func(header string, apiKey string) *gin.Context {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("POST", "/v1/chat/completions", nil)
+		if header != "" {
+			c.Request.Header.Set("X-Priority", header)
+		}
+		if apiKey != "" {
+			c.Set(apiKeyContextKey, apiKey)
+		}
+		return c
+	}
+
+	assert.Equal(t, 3, pm.requestPriority(newContext("", ""), "model1"))
+	assert.Equal(t, -5, pm.requestPriority(newContext("", "sk-batch"), "model1"))
+	assert.Equal(t, 7, pm.requestPriority(newContext("7", "sk-batch"), "model1"))
+	assert.Equal(t, 3, pm.requestPriority(newContext("high", ""), "model1"), "invalid header is ignored")
+	assert.Equal(t, 0, pm.requestPriority(newContext("", ""), "unknown"))
+}
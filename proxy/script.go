@@ -0,0 +1,296 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/tidwall/gjson"
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// luaArrayType names the metatable of tables that are JSON arrays, so an
+// empty array is not sent back as an object
+const luaArrayType = "json.array"
+
+// luaNumberType names the metatable of JSON numbers a Lua number can not
+// hold exactly, like 64 bit seeds and token IDs. They keep their JSON text
+// and tostring() returns it.
+const luaNumberType = "json.number"
+
+// maxExactInt is the largest integer a Lua number holds exactly
+const maxExactInt = 1 << 53
+
+// luaNull is JSON null in scripts, a nil would remove the key
+var luaNull = &lua.LUserData{}
+
+// luaScript runs a model's script, see config.ScriptConfig. A Lua state is
+// not safe for concurrent use so every call takes one from a pool, each
+// state runs the compiled script once when it is created.
+type luaScript struct {
+	proto   *lua.FunctionProto
+	timeout time.Duration
+	states  sync.Pool
+}
+
+func newLuaScript(conf config.ScriptConfig) (*luaScript, error) {
+	code, name, err := conf.Code()
+	if err != nil {
+		return nil, err
+	}
+	chunk, err := parse.Parse(strings.NewReader(code), name)
+	if err != nil {
+		return nil, err
+	}
+	proto, err := lua.Compile(chunk, name)
+	if err != nil {
+		return nil, err
+	}
+	return &luaScript{proto: proto, timeout: conf.TimeoutDuration()}, nil
+}
+
+// newState returns a state with the script loaded. Only the base, string,
+// table and math libraries are opened, scripts can not reach files,
+// processes or load more code.
+func (s *luaScript) newState() (*lua.LState, error) {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	} {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	for _, name := range []string{"dofile", "loadfile", "load", "loadstring", "module", "require"} {
+		L.SetGlobal(name, lua.LNil)
+	}
+
+	arrayMeta := L.NewTypeMetatable(luaArrayType)
+	numberMeta := L.NewTypeMetatable(luaNumberType)
+	L.SetField(numberMeta, "__tostring", L.NewFunction(func(L *lua.LState) int {
+		number, _ := L.CheckUserData(1).Value.(json.Number)
+		L.Push(lua.LString(number))
+		return 1
+	}))
+	L.SetGlobal("null", luaNull)
+	L.SetGlobal("array", L.NewFunction(func(L *lua.LState) int {
+		t := L.CreateTable(L.GetTop(), 0)
+		for i := 1; i <= L.GetTop(); i++ {
+			t.Append(L.Get(i))
+		}
+		L.SetMetatable(t, arrayMeta)
+		L.Push(t)
+		return 1
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	L.SetContext(ctx)
+	defer L.RemoveContext()
+
+	L.Push(L.NewFunctionFromProto(s.proto))
+	if err := L.PCall(0, 0, nil); err != nil {
+		L.Close()
+		return nil, err
+	}
+	return L, nil
+}
+
+// call runs the script function name on the JSON body. A function the
+// script does not define leaves body as it is, drop is true when it
+// returned false.
+func (s *luaScript) call(name string, body []byte, args ...string) (result []byte, drop bool, err error) {
+	L, _ := s.states.Get().(*lua.LState)
+	if L == nil {
+		if L, err = s.newState(); err != nil {
+			return nil, false, err
+		}
+	}
+
+	fn, ok := L.GetGlobal(name).(*lua.LFunction)
+	if !ok {
+		s.states.Put(L)
+		return body, false, nil
+	}
+
+	// numbers are decoded as json.Number so large integers keep every digit
+	var decoded any
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&decoded); err != nil {
+		s.states.Put(L)
+		return nil, false, err
+	}
+	params := []lua.LValue{toLua(L, decoded)}
+	for _, arg := range args {
+		params = append(params, lua.LString(arg))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	L.SetContext(ctx)
+	err = L.CallByParam(lua.P{Fn: fn, NRet: 1, Protect: true}, params...)
+	L.RemoveContext()
+	if err != nil {
+		// a call that was stopped may have left the state half way
+		L.Close()
+		return nil, false, err
+	}
+	defer s.states.Put(L)
+
+	ret := L.Get(-1)
+	L.Pop(1)
+	switch ret {
+	case lua.LFalse:
+		return nil, true, nil
+	case lua.LNil:
+		ret = params[0]
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(fromLua(L, ret)); err != nil {
+		return nil, false, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), false, nil
+}
+
+// request runs on_request on the body of a request to path
+func (s *luaScript) request(body []byte, path string) ([]byte, error) {
+	if !gjson.ValidBytes(body) {
+		return body, nil
+	}
+	result, drop, err := s.call("on_request", body, path)
+	if err != nil {
+		return nil, fmt.Errorf("script on_request failed: %v", err)
+	}
+	if drop {
+		return body, nil
+	}
+	return result, nil
+}
+
+// toLua converts a decoded JSON value into a Lua value
+func toLua(L *lua.LState, value any) lua.LValue {
+	switch v := value.(type) {
+	case nil:
+		return luaNull
+	case bool:
+		return lua.LBool(v)
+	case json.Number:
+		if i, err := v.Int64(); err == nil && i >= -maxExactInt && i <= maxExactInt {
+			return lua.LNumber(i)
+		}
+		if strings.ContainsAny(string(v), ".eE") {
+			if f, err := v.Float64(); err == nil {
+				return lua.LNumber(f)
+			}
+		}
+		number := L.NewUserData()
+		number.Value = v
+		L.SetMetatable(number, L.GetTypeMetatable(luaNumberType))
+		return number
+	case string:
+		return lua.LString(v)
+	case []any:
+		t := L.CreateTable(len(v), 0)
+		for _, item := range v {
+			t.Append(toLua(L, item))
+		}
+		L.SetMetatable(t, L.GetTypeMetatable(luaArrayType))
+		return t
+	case map[string]any:
+		t := L.CreateTable(0, len(v))
+		for key, item := range v {
+			t.RawSetString(key, toLua(L, item))
+		}
+		return t
+	}
+	return lua.LNil
+}
+
+// fromLua converts a Lua value back into a JSON value. Tables made from
+// JSON arrays or by array(), and tables with only the keys 1..n, are
+// arrays, the other tables are objects. Functions and other values that
+// have no JSON form are null.
+func fromLua(L *lua.LState, value lua.LValue) any {
+	switch v := value.(type) {
+	case lua.LBool:
+		return bool(v)
+	case lua.LNumber:
+		return float64(v)
+	case *lua.LUserData:
+		if number, ok := v.Value.(json.Number); ok {
+			return number
+		}
+		return nil
+	case lua.LString:
+		return string(v)
+	case *lua.LTable:
+		keys := 0
+		v.ForEach(func(lua.LValue, lua.LValue) { keys++ })
+		n := v.MaxN()
+		if L.GetMetatable(v) == L.GetTypeMetatable(luaArrayType) || (n > 0 && n == keys) {
+			array := make([]any, 0, n)
+			for i := 1; i <= n; i++ {
+				array = append(array, fromLua(L, v.RawGetInt(i)))
+			}
+			return array
+		}
+		object := make(map[string]any, keys)
+		v.ForEach(func(key, item lua.LValue) {
+			object[key.String()] = fromLua(L, item)
+		})
+		return object
+	}
+	return nil
+}
+
+// scriptTransformer runs the on_response and on_event functions of a
+// model's script on its responses
+type scriptTransformer struct {
+	script *luaScript
+	path   string
+	logger *LogMonitor
+	id     string
+}
+
+func (st *scriptTransformer) transformBody(body []byte) []byte {
+	if !gjson.ValidBytes(body) {
+		return body
+	}
+	result, drop, err := st.script.call("on_response", body, st.path)
+	if err != nil {
+		st.logger.Warnf("<%s> script on_response failed, the response is sent as it is: %v", st.id, err)
+		return body
+	}
+	if drop {
+		return body
+	}
+	return result
+}
+
+func (st *scriptTransformer) transformEvent(payload []byte) [][]byte {
+	result, drop, err := st.script.call("on_event", payload)
+	if err != nil {
+		st.logger.Warnf("<%s> script on_event failed, the event is sent as it is: %v", st.id, err)
+		return [][]byte{payload}
+	}
+	if drop {
+		return nil
+	}
+	return [][]byte{result}
+}
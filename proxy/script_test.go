@@ -0,0 +1,127 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+func TestLuaScript(t *testing.T) {
+	script, err := newLuaScript(config.ScriptConfig{Source: `
+function on_request(body, path)
+  body.mirostat = nil
+  if path == "/v1/chat/completions" then
+    for _, message in ipairs(body.messages) do
+      if message.role == "system" then
+        message.content = "be brief. " .. message.content
+      end
+    end
+  end
+end
+
+function on_response(body)
+  return {text = body.choices[1].text, seed = null, tags = array()}
+end
+
+function on_event(event)
+  if event.choices[1].delta.content == "" then
+    return false
+  end
+end
+`})
+	require.NoError(t, err)
+
+	body, err := script.request([]byte(`{"model":"m","mirostat":2,"stop":[],"messages":[{"role":"system","content":"hi <b>"}]}`), "/v1/chat/completions")
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"model":"m","stop":[],"messages":[{"role":"system","content":"be brief. hi <b>"}]}`, string(body))
+
+	transformer := &scriptTransformer{script: script, path: "/v1/completions", logger: testLogger, id: "m"}
+	assert.JSONEq(t, `{"text":"hello","seed":null,"tags":[]}`, string(transformer.transformBody([]byte(`{"choices":[{"text":"hello"}]}`))))
+	assert.Empty(t, transformer.transformEvent([]byte(`{"choices":[{"delta":{"content":""}}]}`)))
+	assert.Equal(t, [][]byte{[]byte(`{"choices":[{"delta":{"content":"a"}}]}`)}, transformer.transformEvent([]byte(`{"choices":[{"delta":{"content":"a"}}]}`)))
+
+	// integers beyond what a Lua number holds keep all their digits
+	script, err = newLuaScript(config.ScriptConfig{Source: `
+function on_request(body)
+  body.text = tostring(body.seed)
+  body.top_p = body.top_p * 2
+end
+`})
+	require.NoError(t, err)
+	body, err = script.request([]byte(`{"seed":18446744073709551615,"logit_bias":{"9007199254740993":-100},"ids":[9007199254740993,42],"top_p":0.45}`), "/v1/completions")
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"seed":18446744073709551615,"logit_bias":{"9007199254740993":-100},"ids":[9007199254740993,42],"top_p":0.9,"text":"18446744073709551615"}`, string(body))
+	assert.Contains(t, string(body), `"seed":18446744073709551615`)
+	assert.Contains(t, string(body), `[9007199254740993,42]`)
+
+	// a script without the function leaves the body alone
+	script, err = newLuaScript(config.ScriptConfig{Source: `x = 1`})
+	require.NoError(t, err)
+	body, err = script.request([]byte(`{"a":1}`), "/v1/completions")
+	require.NoError(t, err)
+	assert.Equal(t, `{"a":1}`, string(body))
+}
+
+func TestLuaScript_Sandbox(t *testing.T) {
+	script, err := newLuaScript(config.ScriptConfig{Source: `
+function on_request(body)
+  body.os = os == nil and io == nil and require == nil and load == nil
+end
+`})
+	require.NoError(t, err)
+	body, err := script.request([]byte(`{}`), "/v1/completions")
+	require.NoError(t, err)
+	assert.Equal(t, `{"os":true}`, string(body))
+
+	script, err = newLuaScript(config.ScriptConfig{Source: `function on_request() while true do end end`, Timeout: 10})
+	require.NoError(t, err)
+	_, err = script.request([]byte(`{}`), "/v1/completions")
+	assert.Error(t, err)
+
+	// a failing response script sends the response as it is
+	script, err = newLuaScript(config.ScriptConfig{Source: `function on_response() error("boom") end`})
+	require.NoError(t, err)
+	transformer := &scriptTransformer{script: script, logger: testLogger, id: "m"}
+	assert.Equal(t, `{"a":1}`, string(transformer.transformBody([]byte(`{"a":1}`))))
+}
+
+func TestProxyManager_Script(t *testing.T) {
+	modelConfig := getTestSimpleResponderConfig("model1")
+	modelConfig.Script = config.ScriptConfig{Source: `
+function on_request(body)
+  body.mirostat = nil
+  body.top_k = 40
+end
+
+function on_response(body)
+  body.timings = nil
+  body.system_fingerprint = "lua"
+end
+`}
+	conf := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]config.ModelConfig{
+			"model1": modelConfig,
+		},
+		LogLevel: "error",
+	})
+
+	proxy := New(conf)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model":"model1","mirostat":2}`))
+	w := CreateTestResponseRecorder()
+	proxy.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	response := gjson.Parse(w.Body.String())
+	assert.JSONEq(t, `{"model":"model1","top_k":40}`, response.Get("request_body").String())
+	assert.False(t, response.Get("timings").Exists())
+	assert.Equal(t, "lua", response.Get("system_fingerprint").String())
+}
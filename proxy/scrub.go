@@ -0,0 +1,85 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/napmany/llmsnap/proxy/config"
+)
+
+// scrubber replaces personal data and secrets found by the configured
+// detectors. A nil scrubber leaves data unchanged.
+type scrubber struct {
+	rules []config.ScrubRule
+}
+
+// newScrubber returns nil when no detectors are configured
+func newScrubber(conf config.ScrubConfig) *scrubber {
+	if !conf.Enabled() {
+		return nil
+	}
+	// validated when the configuration was loaded
+	rules, _ := conf.Rules()
+	return &scrubber{rules: rules}
+}
+
+// scrubText applies every rule to text
+func (s *scrubber) scrubText(text []byte) []byte {
+	for _, rule := range s.rules {
+		text = rule.Regex.ReplaceAllLiteral(text, []byte(rule.Replacement))
+	}
+	return text
+}
+
+// scrub returns data with all matches replaced. Only the string values of
+// JSON bodies are changed so the result is still valid JSON, other bodies
+// are scrubbed as text.
+func (s *scrubber) scrub(data []byte) []byte {
+	if s == nil || len(data) == 0 {
+		return data
+	}
+	if !json.Valid(data) {
+		return s.scrubText(data)
+	}
+
+	var out bytes.Buffer
+	out.Grow(len(data))
+	for i := 0; i < len(data); {
+		if data[i] != '"' {
+			out.WriteByte(data[i])
+			i++
+			continue
+		}
+
+		// find the end of the string, skipping escaped characters
+		end := i + 1
+		for data[end] != '"' {
+			if data[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		end++
+
+		token := data[i:end]
+		i = end
+
+		var value string
+		if json.Unmarshal(token, &value) != nil {
+			out.Write(token)
+			continue
+		}
+		scrubbed := s.scrubText([]byte(value))
+		if bytes.Equal(scrubbed, []byte(value)) {
+			out.Write(token)
+			continue
+		}
+
+		encoder := json.NewEncoder(&out)
+		encoder.SetEscapeHTML(false)
+		encoder.Encode(string(scrubbed))
+		// Encode appends a newline
+		out.Truncate(out.Len() - 1)
+	}
+	return out.Bytes()
+}
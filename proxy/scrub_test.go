@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+func TestScrubber_Scrub(t *testing.T) {
+	s := newScrubber(config.ScrubConfig{
+		Detectors: []string{"email", "phone", "key"},
+		Patterns:  []config.ScrubPattern{{Name: "employee", Regex: `EMP-\d{6}`}},
+	})
+
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"email", `{"content":"write to jane.doe@example.com"}`, `{"content":"write to [EMAIL]"}`},
+		{"phone", `{"content":"call +1 555-123-4567 or (555) 123 4567"}`, `{"content":"call [PHONE] or [PHONE]"}`},
+		{"key", `{"content":"my key is sk-abcdefghijklmnopqrstuvwx"}`, `{"content":"my key is [KEY]"}`},
+		{"custom pattern", `{"content":"badge EMP-123456"}`, `{"content":"badge [EMPLOYEE]"}`},
+		{"escaped strings", `{"content":"line\n\"a@b.io\"é"}`, `{"content":"line\n\"[EMAIL]\"é"}`},
+		{"numbers are not changed", `{"seed":5551234567,"max_tokens":100}`, `{"seed":5551234567,"max_tokens":100}`},
+		{"nested", `{"messages":[{"role":"user","content":["x@y.org"]}]}`, `{"messages":[{"role":"user","content":["[EMAIL]"]}]}`},
+		{"not JSON", "to: x@y.org\n", "to: [EMAIL]\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := s.scrub([]byte(tt.input))
+			assert.Equal(t, tt.expected, string(result))
+			if json.Valid([]byte(tt.input)) {
+				assert.True(t, json.Valid(result))
+			}
+		})
+	}
+
+	t.Run("nil scrubber", func(t *testing.T) {
+		var s *scrubber
+		assert.Equal(t, "a@b.io", string(s.scrub([]byte("a@b.io"))))
+		assert.Nil(t, newScrubber(config.ScrubConfig{Forward: true}))
+	})
+}
+
+func TestProxyManager_ScrubForward(t *testing.T) {
+	conf := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]config.ModelConfig{
+			"model1": getTestSimpleResponderConfig("model1"),
+		},
+		Scrub:    config.ScrubConfig{Detectors: []string{"email"}, Forward: true},
+		LogLevel: "error",
+	})
+
+	proxy := New(conf)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(
+		`{"model":"model1","messages":[{"role":"user","content":"I am jane@example.com"}]}`))
+	w := CreateTestResponseRecorder()
+	proxy.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	upstreamBody := gjson.Get(w.Body.String(), "request_body").String()
+	assert.Contains(t, upstreamBody, "I am [EMAIL]")
+	assert.NotContains(t, upstreamBody, "jane@example.com")
+}
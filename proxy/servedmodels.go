@@ -0,0 +1,183 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/napmany/llmsnap/event"
+	"github.com/tidwall/gjson"
+)
+
+const servedModelsTimeout = 10 * time.Second
+
+// servedModels maps the model IDs discovered from backends that serve
+// several models, like vLLM or llama-server in router mode, to the model
+// that runs the backend. Names are kept after the model stops so requests
+// for them start it again.
+type servedModels struct {
+	sync.RWMutex
+
+	// served name -> model ID
+	names map[string]string
+
+	// model ID -> served names, sorted
+	byModel map[string][]string
+}
+
+func newServedModels() *servedModels {
+	return &servedModels{
+		names:   make(map[string]string),
+		byModel: make(map[string][]string),
+	}
+}
+
+func (s *servedModels) lookup(name string) (string, bool) {
+	if s == nil {
+		return "", false
+	}
+	s.RLock()
+	defer s.RUnlock()
+	modelID, found := s.names[name]
+	return modelID, found
+}
+
+func (s *servedModels) list(modelID string) []string {
+	if s == nil {
+		return nil
+	}
+	s.RLock()
+	defer s.RUnlock()
+	return slices.Clone(s.byModel[modelID])
+}
+
+// set replaces the names served by modelID. Names served by another model
+// are skipped and returned.
+func (s *servedModels) set(modelID string, names []string) (skipped []string) {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, name := range s.byModel[modelID] {
+		delete(s.names, name)
+	}
+
+	registered := make([]string, 0, len(names))
+	for _, name := range names {
+		if owner, found := s.names[name]; found && owner != modelID {
+			skipped = append(skipped, name)
+			continue
+		}
+		s.names[name] = modelID
+		registered = append(registered, name)
+	}
+	sort.Strings(registered)
+	s.byModel[modelID] = registered
+	return skipped
+}
+
+// realModelName resolves a requested model name to a model ID, including
+// the names discovered from backends with discoverModels
+func (pm *ProxyManager) realModelName(search string) (string, bool) {
+	if modelID, found := pm.config.RealModelName(search); found {
+		return modelID, true
+	}
+	return pm.servedModels.lookup(search)
+}
+
+// isServedModelName reports if search is a discovered name rather than a
+// configured model ID or alias
+func (pm *ProxyManager) isServedModelName(search string) bool {
+	if _, found := pm.config.RealModelName(search); found {
+		return false
+	}
+	_, found := pm.servedModels.lookup(search)
+	return found
+}
+
+// discoverServedModels fetches the served model names every time a model
+// with discoverModels becomes ready
+func (pm *ProxyManager) discoverServedModels() {
+	cancel := event.On(func(e ProcessStateChangeEvent) {
+		if e.NewState != StateReady || !pm.config.Models[e.ProcessName].DiscoverModels {
+			return
+		}
+		group := pm.findGroupByModelName(e.ProcessName)
+		if group == nil {
+			return
+		}
+		process, ok := group.GetMember(e.ProcessName)
+		if !ok || process.CurrentState() != StateReady {
+			return
+		}
+		go pm.refreshServedModels(process)
+	})
+
+	go func() {
+		<-pm.shutdownCtx.Done()
+		cancel()
+	}()
+}
+
+// refreshServedModels registers the model IDs the process lists in /v1/models
+func (pm *ProxyManager) refreshServedModels(process *Process) {
+	names, err := fetchServedModels(pm.shutdownCtx, process)
+	if err != nil {
+		pm.proxyLogger.Warnf("<%s> could not discover served models: %v", process.ID, err)
+		return
+	}
+
+	// configured model IDs and aliases keep their meaning
+	names = slices.DeleteFunc(names, func(name string) bool {
+		_, found := pm.config.RealModelName(name)
+		return found
+	})
+
+	if skipped := pm.servedModels.set(process.ID, names); len(skipped) > 0 {
+		pm.proxyLogger.Warnf("<%s> served models already served by another model: %v", process.ID, skipped)
+	}
+	pm.proxyLogger.Infof("<%s> serves models: %v", process.ID, pm.servedModels.list(process.ID))
+}
+
+func fetchServedModels(ctx context.Context, process *Process) ([]string, error) {
+	modelsURL, err := process.buildFullURL("/v1/models")
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, servedModelsTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, modelsURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	client := http.DefaultClient
+	if proxy := upstreamProxyFunc(process.config.UpstreamProxy); proxy != nil {
+		client = &http.Client{Transport: &http.Transport{Proxy: proxy}}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status code: %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, model := range gjson.GetBytes(body, "data").Array() {
+		if id := model.Get("id").String(); id != "" && !slices.Contains(names, id) {
+			names = append(names, id)
+		}
+	}
+	return names, nil
+}
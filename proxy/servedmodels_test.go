@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+func TestServedModels_Set(t *testing.T) {
+	s := newServedModels()
+
+	assert.Empty(t, s.set("vllm", []string{"b", "a"}))
+	assert.Equal(t, []string{"a", "b"}, s.list("vllm"))
+
+	// names already served by another model are skipped
+	assert.Equal(t, []string{"a"}, s.set("router", []string{"a", "c"}))
+	modelID, found := s.lookup("c")
+	assert.True(t, found)
+	assert.Equal(t, "router", modelID)
+
+	// a new list replaces the old one
+	s.set("vllm", []string{"d"})
+	_, found = s.lookup("a")
+	assert.False(t, found)
+	assert.Equal(t, []string{"d"}, s.list("vllm"))
+
+	var nilServed *servedModels
+	_, found = nilServed.lookup("a")
+	assert.False(t, found)
+}
+
+func TestProxyManager_DiscoverModels(t *testing.T) {
+	var mu sync.Mutex
+	var upstreamModels []string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/models":
+			w.Write([]byte(`{"data":[{"id":"qwen-7b"},{"id":"llama-8b"},{"id":"model1"}]}`))
+		case "/v1/chat/completions":
+			body, _ := io.ReadAll(r.Body)
+			mu.Lock()
+			upstreamModels = append(upstreamModels, gjson.GetBytes(body, "model").String())
+			mu.Unlock()
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"ok"}}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer backend.Close()
+
+	// the process only has to run, requests go to backend
+	router := getTestSimpleResponderConfig("router")
+	router.Proxy = backend.URL
+	router.CheckEndpoint = "none"
+	router.DiscoverModels = true
+	router.UseModelName = "default-model"
+
+	conf := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]config.ModelConfig{
+			"router": router,
+			"model1": getTestSimpleResponderConfig("model1"),
+		},
+		LogLevel: "error",
+	})
+
+	proxy := New(conf)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	chat := func(model string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model":"`+model+`","messages":[]}`))
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		return w.ResponseRecorder
+	}
+
+	// not known until the backend has run once
+	assert.Equal(t, http.StatusBadRequest, chat("qwen-7b").Code)
+
+	assert.Equal(t, http.StatusOK, chat("router").Code)
+	assert.Eventually(t, func() bool {
+		return len(proxy.servedModels.list("router")) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// configured model IDs are not taken over
+	assert.Equal(t, []string{"llama-8b", "qwen-7b"}, proxy.servedModels.list("router"))
+
+	assert.Equal(t, http.StatusOK, chat("qwen-7b").Code)
+	mu.Lock()
+	assert.Equal(t, []string{"default-model", "qwen-7b"}, upstreamModels)
+	mu.Unlock()
+
+	req := httptest.NewRequest("GET", "/v1/models", nil)
+	w := CreateTestResponseRecorder()
+	proxy.ServeHTTP(w, req)
+	ids := gjson.Get(w.Body.String(), "data.#.id").String()
+	assert.Contains(t, ids, `"qwen-7b"`)
+	assert.Contains(t, ids, `"llama-8b"`)
+}
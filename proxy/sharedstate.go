@@ -0,0 +1,293 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/napmany/llmsnap/proxy/config"
+)
+
+// set on requests forwarded to the instance that leases the group, the value
+// is the instance URL of the sender
+const forwardedByHeader = "X-Llmsnap-Forwarded-By"
+
+// leaseStore keeps the group leases shared by the instances
+type leaseStore interface {
+	// claim takes or renews the lease on key for owner and returns the owner
+	// of the lease afterwards
+	claim(key, owner string, ttl time.Duration) (string, error)
+
+	// release drops the lease on key if owner holds it
+	release(key, owner string) error
+}
+
+const redisClaimScript = `local owner = redis.call('GET', KEYS[1])
+if owner == false or owner == ARGV[1] then
+  redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+  return ARGV[1]
+end
+return owner`
+
+const redisReleaseScript = `if redis.call('GET', KEYS[1]) == ARGV[1] then
+  return redis.call('DEL', KEYS[1])
+end
+return 0`
+
+// redisLeaseStore keeps the leases as Redis keys that expire
+type redisLeaseStore struct {
+	client *redisClient
+}
+
+func (s *redisLeaseStore) claim(key, owner string, ttl time.Duration) (string, error) {
+	reply, err := s.client.do("EVAL", redisClaimScript, "1", key, owner, strconv.FormatInt(ttl.Milliseconds(), 10))
+	if err != nil {
+		return "", err
+	}
+	current, ok := reply.(string)
+	if !ok {
+		return "", fmt.Errorf("redis: unexpected reply %v", reply)
+	}
+	return current, nil
+}
+
+func (s *redisLeaseStore) release(key, owner string) error {
+	_, err := s.client.do("EVAL", redisReleaseScript, "1", key, owner)
+	return err
+}
+
+// sharedState tracks the groups this instance leases and forwards requests
+// for groups leased by other instances
+type sharedState struct {
+	store  leaseStore
+	self   string
+	prefix string
+	lease  time.Duration
+
+	mu sync.Mutex
+
+	// group IDs leased by this instance and when a request last claimed them
+	leased map[string]time.Time
+
+	// reverse proxies by instance URL
+	forwarders map[string]*httputil.ReverseProxy
+}
+
+func newSharedState(conf config.SharedStateConfig) (*sharedState, error) {
+	client, err := newRedisClient(conf.Redis)
+	if err != nil {
+		return nil, err
+	}
+	return &sharedState{
+		store:      &redisLeaseStore{client: client},
+		self:       strings.TrimSuffix(conf.InstanceURL, "/"),
+		prefix:     conf.Prefix(),
+		lease:      conf.Lease(),
+		leased:     make(map[string]time.Time),
+		forwarders: make(map[string]*httputil.ReverseProxy),
+	}, nil
+}
+
+func (s *sharedState) key(groupID string) string {
+	return s.prefix + ":group:" + groupID
+}
+
+// claim leases groupID for this instance unless another instance holds it,
+// and returns the instance that holds it
+func (s *sharedState) claim(groupID string) (string, error) {
+	owner, err := s.store.claim(s.key(groupID), s.self, s.lease)
+	if err != nil {
+		return "", err
+	}
+	if owner == s.self {
+		s.mu.Lock()
+		s.leased[groupID] = time.Now()
+		s.mu.Unlock()
+	}
+	return owner, nil
+}
+
+func (s *sharedState) release(groupID string) error {
+	s.mu.Lock()
+	delete(s.leased, groupID)
+	s.mu.Unlock()
+	return s.store.release(s.key(groupID), s.self)
+}
+
+// leasedGroups returns the leased group IDs and when they were last claimed
+func (s *sharedState) leasedGroups() map[string]time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	leased := make(map[string]time.Time, len(s.leased))
+	for groupID, claimed := range s.leased {
+		leased[groupID] = claimed
+	}
+	return leased
+}
+
+// forwarder returns the reverse proxy to the instance at owner
+func (s *sharedState) forwarder(owner string, proxyLogger *LogMonitor) (*httputil.ReverseProxy, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if forwarder, found := s.forwarders[owner]; found {
+		return forwarder, nil
+	}
+
+	target, err := url.Parse(owner)
+	if err != nil {
+		return nil, err
+	}
+	forwarder := httputil.NewSingleHostReverseProxy(target)
+	originalDirector := forwarder.Director
+	forwarder.Director = func(req *http.Request) {
+		originalDirector(req)
+		req.Host = req.URL.Host
+		req.Header.Set(forwardedByHeader, s.self)
+	}
+	forwarder.FlushInterval = -1
+	forwarder.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		proxyLogger.Warnf("forwarding to instance %s failed: %v", owner, err)
+		http.Error(w, fmt.Sprintf("error forwarding to instance %s: %v", owner, err), http.StatusBadGateway)
+	}
+	s.forwarders[owner] = forwarder
+	return forwarder, nil
+}
+
+// groupOwner returns the instance URL of another instance that leases the
+// group of modelID, empty when this instance may run it. When the lease can
+// not be checked or a forwarded request arrives for a group this instance
+// does not lease, an error response is sent and ok is false.
+func (pm *ProxyManager) groupOwner(c *gin.Context, modelID string) (owner string, ok bool) {
+	if pm.sharedState == nil {
+		return "", true
+	}
+	processGroup := pm.findGroupByModelName(modelID)
+	if processGroup == nil {
+		return "", true
+	}
+
+	owner, err := pm.sharedState.claim(processGroup.id)
+	if err != nil {
+		pm.proxyLogger.Errorf("<%s> unable to lease group %s: %v", modelID, processGroup.id, err)
+		c.Header("Retry-After", strconv.Itoa(admissionRetryAfter))
+		pm.sendErrorResponse(c, http.StatusServiceUnavailable, fmt.Sprintf("unable to lease group %s: %v", processGroup.id, err))
+		return "", false
+	}
+	if owner == pm.sharedState.self {
+		return "", true
+	}
+
+	// the lease moved while the request was forwarded, do not bounce it again
+	if c.GetHeader(forwardedByHeader) != "" {
+		c.Header("Retry-After", strconv.Itoa(admissionRetryAfter))
+		pm.sendErrorResponse(c, http.StatusServiceUnavailable, fmt.Sprintf("group %s is leased by instance %s", processGroup.id, owner))
+		return "", false
+	}
+
+	pm.proxyLogger.Debugf("<%s> forwarding to instance %s which leases group %s", modelID, owner, processGroup.id)
+	return owner, true
+}
+
+// forwardTo returns a handler that sends requests to the instance at owner
+func (pm *ProxyManager) forwardTo(owner string) func(modelID string, w http.ResponseWriter, r *http.Request) error {
+	return func(modelID string, w http.ResponseWriter, r *http.Request) error {
+		forwarder, err := pm.sharedState.forwarder(owner, pm.proxyLogger)
+		if err != nil {
+			return err
+		}
+		forwarder.ServeHTTP(w, r)
+		return nil
+	}
+}
+
+// groupLoaded reports if any member of the group is loaded
+func groupLoaded(processGroup *ProcessGroup) bool {
+	for _, process := range processGroup.instances() {
+		if isLoaded(process.CurrentState()) {
+			return true
+		}
+	}
+	return false
+}
+
+// keepLeases renews the leases of loaded groups until shutdown, then
+// releases them all
+func (pm *ProxyManager) keepLeases() {
+	ticker := time.NewTicker(pm.sharedState.lease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-pm.shutdownCtx.Done():
+			for groupID := range pm.sharedState.leasedGroups() {
+				if err := pm.sharedState.release(groupID); err != nil {
+					pm.proxyLogger.Warnf("unable to release the lease on group %s: %v", groupID, err)
+				}
+			}
+			return
+		case <-ticker.C:
+			pm.renewLeases()
+		}
+	}
+}
+
+// renewLeases renews the leases of loaded groups and releases the others. A
+// group that was claimed within the last lease period is kept while its
+// model starts. A group whose lease was lost is stopped so it does not run
+// on two instances.
+func (pm *ProxyManager) renewLeases() {
+	for groupID, claimed := range pm.sharedState.leasedGroups() {
+		processGroup, found := pm.processGroups[groupID]
+		if !found {
+			continue
+		}
+
+		if !groupLoaded(processGroup) {
+			if time.Since(claimed) >= pm.sharedState.lease {
+				pm.proxyLogger.Debugf("releasing the lease on group %s", groupID)
+				if err := pm.sharedState.release(groupID); err != nil {
+					pm.proxyLogger.Warnf("unable to release the lease on group %s: %v", groupID, err)
+				}
+			}
+			continue
+		}
+
+		owner, err := pm.sharedState.store.claim(pm.sharedState.key(groupID), pm.sharedState.self, pm.sharedState.lease)
+		if err != nil {
+			pm.proxyLogger.Warnf("unable to renew the lease on group %s: %v", groupID, err)
+			continue
+		}
+		if owner != pm.sharedState.self {
+			pm.proxyLogger.Warnf("lost the lease on group %s to instance %s, stopping its models", groupID, owner)
+			pm.sharedState.mu.Lock()
+			delete(pm.sharedState.leased, groupID)
+			pm.sharedState.mu.Unlock()
+			go processGroup.StopProcesses(StopImmediately)
+		}
+	}
+}
+
+// leaseGroup leases the group of modelID for a model loaded without a
+// request, it fails when another instance holds the lease
+func (pm *ProxyManager) leaseGroup(modelID string) error {
+	if pm.sharedState == nil {
+		return nil
+	}
+	processGroup := pm.findGroupByModelName(modelID)
+	if processGroup == nil {
+		return nil
+	}
+	owner, err := pm.sharedState.claim(processGroup.id)
+	if err != nil {
+		return fmt.Errorf("unable to lease group %s: %v", processGroup.id, err)
+	}
+	if owner != pm.sharedState.self {
+		return fmt.Errorf("group %s is leased by instance %s", processGroup.id, owner)
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryLeaseStore keeps leases in memory, they do not expire
+type memoryLeaseStore struct {
+	mu     sync.Mutex
+	owners map[string]string
+}
+
+func (s *memoryLeaseStore) claim(key, owner string, ttl time.Duration) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if current, found := s.owners[key]; found {
+		return current, nil
+	}
+	s.owners[key] = owner
+	return owner, nil
+}
+
+func (s *memoryLeaseStore) release(key, owner string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.owners[key] == owner {
+		delete(s.owners, key)
+	}
+	return nil
+}
+
+func newTestSharedState(store leaseStore, self string) *sharedState {
+	return &sharedState{
+		store:      store,
+		self:       self,
+		prefix:     "llmsnap",
+		lease:      30 * time.Second,
+		leased:     make(map[string]time.Time),
+		forwarders: make(map[string]*httputil.ReverseProxy),
+	}
+}
+
+func TestProxyManager_SharedStateForwards(t *testing.T) {
+	conf := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]config.ModelConfig{
+			"model1": getTestSimpleResponderConfig("model1"),
+		},
+		LogLevel: "error",
+	})
+	store := &memoryLeaseStore{owners: make(map[string]string)}
+
+	first := New(conf)
+	defer first.StopProcesses(StopWaitForInflightRequest)
+	firstServer := httptest.NewServer(first)
+	defer firstServer.Close()
+	first.sharedState = newTestSharedState(store, firstServer.URL)
+
+	second := New(conf)
+	defer second.StopProcesses(StopWaitForInflightRequest)
+	second.sharedState = newTestSharedState(store, "http://second.invalid")
+
+	chat := func(pm *ProxyManager, header http.Header) *TestResponseRecorder {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model":"model1"}`))
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		w := CreateTestResponseRecorder()
+		pm.ServeHTTP(w, req)
+		return w
+	}
+
+	w := chat(first, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, firstServer.URL, store.owners["llmsnap:group:"+config.DEFAULT_GROUP_ID])
+
+	// the second instance forwards instead of starting its own copy
+	w = chat(second, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "model1")
+	process, _ := second.findGroupByModelName("model1").GetMember("model1")
+	assert.Equal(t, StateStopped, process.CurrentState())
+
+	// only the instance that served it records the request
+	assert.Len(t, first.metricsMonitor.getMetrics(), 2)
+	assert.Empty(t, second.metricsMonitor.getMetrics())
+
+	// forwarded requests are not bounced again
+	w = chat(second, http.Header{forwardedByHeader: {"http://third.invalid"}})
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "30", w.Header().Get("Retry-After"))
+}
+
+func TestProxyManager_RenewLeases(t *testing.T) {
+	conf := config.AddDefaultGroupToConfig(config.Config{
+		Models: map[string]config.ModelConfig{
+			"model1": getTestSimpleResponderConfig("model1"),
+		},
+		LogLevel: "error",
+	})
+	store := &memoryLeaseStore{owners: make(map[string]string)}
+	key := "llmsnap:group:" + config.DEFAULT_GROUP_ID
+
+	pm := New(conf)
+	defer pm.StopProcesses(StopWaitForInflightRequest)
+	pm.sharedState = newTestSharedState(store, "http://self.invalid")
+
+	// a fresh claim is kept while the model starts
+	require.NoError(t, pm.leaseGroup("model1"))
+	pm.renewLeases()
+	assert.Equal(t, "http://self.invalid", store.owners[key])
+
+	// nothing was loaded for a whole lease period
+	pm.sharedState.leased[config.DEFAULT_GROUP_ID] = time.Now().Add(-time.Minute)
+	pm.renewLeases()
+	assert.Empty(t, store.owners)
+	assert.Empty(t, pm.sharedState.leasedGroups())
+
+	// another instance holds the lease
+	store.owners[key] = "http://other.invalid"
+	assert.ErrorContains(t, pm.leaseGroup("model1"), "leased by instance http://other.invalid")
+}
+
+func TestRedisClient_Commands(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	var mu sync.Mutex
+	var received [][]any
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		replies := []string{"+OK\r\n", "+OK\r\n", "$4\r\nself\r\n", "-ERR boom\r\n", ":1\r\n"}
+		for _, reply := range replies {
+			cmd, err := readRedisReply(reader)
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			received = append(received, cmd.([]any))
+			mu.Unlock()
+			conn.Write([]byte(reply))
+		}
+	}()
+
+	client, err := newRedisClient("redis://:secret@" + listener.Addr().String() + "/2")
+	require.NoError(t, err)
+	defer client.Close()
+
+	store := &redisLeaseStore{client: client}
+	owner, err := store.claim("llmsnap:group:g1", "self", 1500*time.Millisecond)
+	require.NoError(t, err)
+	assert.Equal(t, "self", owner)
+
+	_, err = client.do("GET", "missing")
+	assert.EqualError(t, err, "redis: ERR boom")
+
+	// error replies keep the connection
+	reply, err := client.do("DEL", "key")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), reply)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, received, 5)
+	assert.Equal(t, []any{"AUTH", "secret"}, received[0])
+	assert.Equal(t, []any{"SELECT", "2"}, received[1])
+	assert.Equal(t, []any{"EVAL", redisClaimScript, "1", "llmsnap:group:g1", "self", "1500"}, received[2])
+}
@@ -0,0 +1,91 @@
+package proxy
+
+import (
+	"net/http"
+	"slices"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+)
+
+// configSnapshot is a config fragment with the changes made at runtime
+type configSnapshot struct {
+	Models map[string]modelSnapshot `yaml:"models,omitempty"`
+}
+
+type modelSnapshot struct {
+	Aliases []any `yaml:"aliases"`
+}
+
+// aliasSnapshot is an alias entry written as a mapping
+type aliasSnapshot struct {
+	Name         string         `yaml:"name"`
+	UseModelName string         `yaml:"useModelName,omitempty"`
+	Params       map[string]any `yaml:"params,omitempty"`
+	SystemPrompt string         `yaml:"systemPrompt,omitempty"`
+}
+
+// configSnapshot returns the runtime changes to the config. The model names
+// discovered with discoverModels become aliases that send the same name
+// upstream, after the aliases already configured.
+func (pm *ProxyManager) configSnapshot() configSnapshot {
+	snapshot := configSnapshot{Models: make(map[string]modelSnapshot)}
+
+	modelIDs := make([]string, 0, len(pm.config.Models))
+	for modelID := range pm.config.Models {
+		modelIDs = append(modelIDs, modelID)
+	}
+	sort.Strings(modelIDs)
+
+	for _, modelID := range modelIDs {
+		modelConfig := pm.config.Models[modelID]
+		var discovered []string
+		for _, name := range pm.servedModels.list(modelID) {
+			if !slices.Contains(modelConfig.Aliases, name) {
+				discovered = append(discovered, name)
+			}
+		}
+		if len(discovered) == 0 {
+			continue
+		}
+
+		aliases := make([]any, 0, len(modelConfig.Aliases)+len(discovered))
+		for _, alias := range modelConfig.Aliases {
+			preset, found := modelConfig.AliasPresets[alias]
+			if !found {
+				aliases = append(aliases, alias)
+				continue
+			}
+			aliases = append(aliases, aliasSnapshot{
+				Name:         alias,
+				UseModelName: preset.UseModelName,
+				Params:       preset.Params,
+				SystemPrompt: preset.SystemPrompt,
+			})
+		}
+		for _, name := range discovered {
+			aliases = append(aliases, aliasSnapshot{Name: name, UseModelName: name})
+		}
+		snapshot.Models[modelID] = modelSnapshot{Aliases: aliases}
+	}
+	return snapshot
+}
+
+// apiGetConfigSnapshot writes the runtime changes as YAML that can be merged
+// into the config file
+func (pm *ProxyManager) apiGetConfigSnapshot(c *gin.Context) {
+	snapshot := pm.configSnapshot()
+	if len(snapshot.Models) == 0 {
+		c.Data(http.StatusOK, "application/yaml", []byte("# no runtime changes\n"))
+		return
+	}
+
+	data, err := yaml.Marshal(snapshot)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	header := "# runtime changes, merge into the config file\n"
+	c.Data(http.StatusOK, "application/yaml", append([]byte(header), data...))
+}
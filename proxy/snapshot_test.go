@@ -0,0 +1,56 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProxyManager_APIGetConfigSnapshot(t *testing.T) {
+	conf, err := config.LoadConfigFromReader(strings.NewReader(`
+logLevel: error
+models:
+  router:
+    cmd: path/to/cmd --port ${PORT}
+    discoverModels: true
+    aliases:
+      - fast
+      - name: creative
+        params:
+          temperature: 1.2
+  other:
+    cmd: path/to/cmd --port ${PORT}
+`))
+	assert.NoError(t, err)
+
+	proxy := New(conf)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	getSnapshot := func() string {
+		req := httptest.NewRequest("GET", "/api/config/snapshot", nil)
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/yaml", w.Header().Get("Content-Type"))
+		return w.Body.String()
+	}
+
+	assert.Equal(t, "# no runtime changes\n", getSnapshot())
+
+	proxy.servedModels.set("router", []string{"qwen-7b", "fast"})
+	assert.Equal(t, `# runtime changes, merge into the config file
+models:
+    router:
+        aliases:
+            - fast
+            - name: creative
+              params:
+                temperature: 1.2
+            - name: qwen-7b
+              useModelName: qwen-7b
+`, getSnapshot())
+}
@@ -0,0 +1,68 @@
+package proxy
+
+import (
+	"bytes"
+)
+
+// sseEvent is a server-sent event
+type sseEvent struct {
+	// name is the event field, empty when the event has none
+	name string
+
+	// data is the data lines of the event joined by newlines
+	data []byte
+}
+
+// forEachSSEEvent calls fn with every event of body, a text/event-stream
+// response. Lines are grouped into events by blank lines as browsers do, so
+// event: lines name the data that follows them and id:, retry: and comment
+// lines are skipped. Events without data are not passed to fn.
+func forEachSSEEvent(body []byte, fn func(event sseEvent)) {
+	var event sseEvent
+	hasData := false
+
+	dispatch := func() {
+		if hasData {
+			fn(event)
+		}
+		event = sseEvent{}
+		hasData = false
+	}
+
+	for len(body) > 0 {
+		var line []byte
+		if end := bytes.IndexByte(body, '\n'); end >= 0 {
+			line, body = body[:end], body[end+1:]
+		} else {
+			line, body = body, nil
+		}
+		line = bytes.TrimSuffix(line, []byte("\r"))
+
+		if len(line) == 0 {
+			dispatch()
+			continue
+		}
+		if line[0] == ':' {
+			continue // comment
+		}
+
+		field, value, _ := bytes.Cut(line, []byte(":"))
+		value = bytes.TrimPrefix(value, []byte(" "))
+
+		switch string(field) {
+		case "event":
+			event.name = string(value)
+		case "data":
+			if hasData {
+				event.data = append(append(event.data, '\n'), value...)
+			} else {
+				// clipped so appending more lines does not write into body
+				event.data = value[:len(value):len(value)]
+				hasData = true
+			}
+		}
+	}
+
+	// a stream cut short may not end with a blank line
+	dispatch()
+}
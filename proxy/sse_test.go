@@ -0,0 +1,49 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestForEachSSEEvent(t *testing.T) {
+	body := ": keep-alive comment\n" +
+		"event: message_start\n" +
+		"id: 1\n" +
+		"data: {\"type\":\"message_start\"}\n" +
+		"\n" +
+		"event: ping\n" +
+		"\n" +
+		"data: {\"a\":\n" +
+		"data: 1}\r\n" +
+		"retry: 100\r\n" +
+		"\r\n" +
+		"data:no-space\n" +
+		"\n" +
+		"event: message_stop\n" +
+		"data: {}"
+
+	var names, data []string
+	forEachSSEEvent([]byte(body), func(event sseEvent) {
+		names = append(names, event.name)
+		data = append(data, string(event.data))
+	})
+
+	// the ping event has no data and is skipped, the last event has no
+	// blank line after it
+	assert.Equal(t, []string{"message_start", "", "", "message_stop"}, names)
+	assert.Equal(t, []string{`{"type":"message_start"}`, "{\"a\":\n1}", "no-space", "{}"}, data)
+}
+
+func TestForEachSSEEvent_DoesNotModifyBody(t *testing.T) {
+	body := []byte("data: a\ndata: b\n\ndata: c\n\n")
+	original := string(body)
+
+	var data []string
+	forEachSSEEvent(body, func(event sseEvent) {
+		data = append(data, string(event.data))
+	})
+
+	assert.Equal(t, []string{"a\nb", "c"}, data)
+	assert.Equal(t, original, string(body))
+}
@@ -0,0 +1,157 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/napmany/llmsnap/proxy/config"
+)
+
+const defaultSSEFlushInterval = 100 * time.Millisecond
+
+// sseFlushWriter sits between the reverse proxy and the client and decides
+// when a text/event-stream response is flushed. httputil.ReverseProxy flushes
+// after every read from the upstream which can split events across packets.
+// Responses that are not event streams are passed through untouched.
+type sseFlushWriter struct {
+	http.ResponseWriter
+
+	mode     config.SSEFlushMode
+	interval time.Duration
+
+	mu      sync.Mutex
+	isSSE   bool
+	checked bool
+	pending bool
+	tail    []byte
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newSSEFlushWriter(w http.ResponseWriter, mode config.SSEFlushMode, interval time.Duration) *sseFlushWriter {
+	if interval <= 0 {
+		interval = defaultSSEFlushInterval
+	}
+	return &sseFlushWriter{
+		ResponseWriter: w,
+		mode:           mode,
+		interval:       interval,
+	}
+}
+
+// checkContentType is called before the first byte is sent to the client,
+// with s.mu held
+func (s *sseFlushWriter) checkContentType() {
+	if s.checked {
+		return
+	}
+	s.checked = true
+	s.isSSE = strings.Contains(strings.ToLower(s.ResponseWriter.Header().Get("Content-Type")), "text/event-stream")
+
+	if s.isSSE && s.mode == config.SSEFlushBuffered {
+		s.stop = make(chan struct{})
+		s.done = make(chan struct{})
+		go s.flushLoop(s.stop, s.done)
+	}
+}
+
+func (s *sseFlushWriter) WriteHeader(statusCode int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checkContentType()
+	s.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (s *sseFlushWriter) Write(data []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checkContentType()
+
+	n, err := s.ResponseWriter.Write(data)
+	if n > 0 {
+		s.pending = true
+		// keep the last few bytes to find event boundaries across writes
+		s.tail = append(s.tail, data[:n]...)
+		if len(s.tail) > 4 {
+			s.tail = s.tail[len(s.tail)-4:]
+		}
+	}
+	return n, err
+}
+
+// Flush is called by the reverse proxy after every read from the upstream
+func (s *sseFlushWriter) Flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checkContentType()
+
+	if !s.isSSE {
+		s.flush()
+		return
+	}
+
+	switch s.mode {
+	case config.SSEFlushEvent:
+		if bytes.HasSuffix(s.tail, []byte("\n\n")) || bytes.HasSuffix(s.tail, []byte("\r\n\r\n")) {
+			s.flush()
+		}
+	case config.SSEFlushBuffered:
+		// flushLoop takes care of it
+	default:
+		s.flush()
+	}
+}
+
+// flush sends buffered data to the client, with s.mu held
+func (s *sseFlushWriter) flush() {
+	s.pending = false
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (s *sseFlushWriter) flushLoop(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			if s.pending {
+				s.flush()
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// Close stops the background flusher and sends any remaining data
+func (s *sseFlushWriter) Close() {
+	s.mu.Lock()
+	stop, done := s.stop, s.done
+	s.stop = nil
+	s.mu.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pending {
+		s.flush()
+	}
+}
+
+func (s *sseFlushWriter) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
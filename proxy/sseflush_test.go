@@ -0,0 +1,78 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/stretchr/testify/assert"
+)
+
+type countingFlushRecorder struct {
+	*httptest.ResponseRecorder
+	flushes atomic.Int32
+}
+
+func (c *countingFlushRecorder) Flush() {
+	c.flushes.Add(1)
+	c.ResponseRecorder.Flush()
+}
+
+func newCountingFlushRecorder(contentType string) *countingFlushRecorder {
+	rec := &countingFlushRecorder{ResponseRecorder: httptest.NewRecorder()}
+	rec.Header().Set("Content-Type", contentType)
+	return rec
+}
+
+func TestSSEFlushWriter_Event(t *testing.T) {
+	rec := newCountingFlushRecorder("text/event-stream")
+	fw := newSSEFlushWriter(rec, config.SSEFlushEvent, 0)
+
+	fw.WriteHeader(http.StatusOK)
+	fw.Write([]byte("data: {\"a\":"))
+	fw.Flush()
+	assert.Equal(t, int32(0), rec.flushes.Load(), "partial event should not be flushed")
+
+	fw.Write([]byte("1}\n\n"))
+	fw.Flush()
+	assert.Equal(t, int32(1), rec.flushes.Load())
+
+	fw.Write([]byte("data: [DONE]\r\n\r\n"))
+	fw.Flush()
+	assert.Equal(t, int32(2), rec.flushes.Load())
+
+	fw.Close()
+	assert.Equal(t, "data: {\"a\":1}\n\ndata: [DONE]\r\n\r\n", rec.Body.String())
+}
+
+func TestSSEFlushWriter_Buffered(t *testing.T) {
+	rec := newCountingFlushRecorder("text/event-stream")
+	fw := newSSEFlushWriter(rec, config.SSEFlushBuffered, 20*time.Millisecond)
+
+	for i := 0; i < 10; i++ {
+		fw.Write([]byte("data: x\n\n"))
+		fw.Flush()
+	}
+	assert.Equal(t, int32(0), rec.flushes.Load(), "flushes should wait for the interval")
+
+	assert.Eventually(t, func() bool {
+		return rec.flushes.Load() == 1
+	}, time.Second, 5*time.Millisecond)
+
+	fw.Write([]byte("data: [DONE]\n\n"))
+	fw.Close()
+	assert.Equal(t, int32(2), rec.flushes.Load(), "close should flush remaining data")
+}
+
+func TestSSEFlushWriter_NonEventStreamPassesThrough(t *testing.T) {
+	rec := newCountingFlushRecorder("application/json")
+	fw := newSSEFlushWriter(rec, config.SSEFlushBuffered, time.Hour)
+
+	fw.Write([]byte(`{"a":`))
+	fw.Flush()
+	assert.Equal(t, int32(1), rec.flushes.Load())
+	fw.Close()
+}
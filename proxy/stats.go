@@ -0,0 +1,154 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// the windows of /api/stats by name
+var statsWindows = map[string]time.Duration{
+	"hour": time.Hour,
+	"day":  24 * time.Hour,
+	"week": 7 * 24 * time.Hour,
+}
+
+// ModelStats aggregates the requests of a model in a window
+type ModelStats struct {
+	Model        string `json:"model"`
+	Requests     int    `json:"requests"`
+	Errors       int    `json:"errors"`
+	InputTokens  int    `json:"input_tokens"`
+	OutputTokens int    `json:"output_tokens"`
+	TotalTokens  int    `json:"total_tokens"`
+
+	// TokensPerSecond are percentiles of the generation speeds, requests
+	// without a known speed are left out
+	TokensPerSecond SpeedPercentiles `json:"tokens_per_second"`
+
+	AvgDurationMs int `json:"avg_duration_ms"`
+}
+
+// SpeedPercentiles of a set of speeds in tokens per second
+type SpeedPercentiles struct {
+	P50 float64 `json:"p50"`
+	P95 float64 `json:"p95"`
+}
+
+// StatsReport is the response of /api/stats
+type StatsReport struct {
+	Window string    `json:"window"`
+	Since  time.Time `json:"since"`
+
+	// Oldest is the time of the oldest kept metric. Requests before it were
+	// dropped because of metricsMaxInMemory.
+	Oldest time.Time `json:"oldest,omitzero"`
+
+	// Models with requests or errors in the window, by model ID
+	Models []ModelStats `json:"models"`
+
+	// Total aggregates the requests of every model
+	Total ModelStats `json:"total"`
+}
+
+type statsBucket struct {
+	stats     ModelStats
+	durations int
+	speeds    []float64
+}
+
+func (b *statsBucket) add(metric TokenMetrics) {
+	b.stats.Requests++
+	b.stats.InputTokens += metric.InputTokens
+	b.stats.OutputTokens += metric.OutputTokens
+	b.durations += metric.DurationMs
+	if metric.TokensPerSecond > 0 {
+		b.speeds = append(b.speeds, metric.TokensPerSecond)
+	}
+}
+
+func (b *statsBucket) result() ModelStats {
+	stats := b.stats
+	stats.TotalTokens = stats.InputTokens + stats.OutputTokens
+	if stats.Requests > 0 {
+		stats.AvgDurationMs = b.durations / stats.Requests
+	}
+	stats.TokensPerSecond = speedPercentiles(b.speeds)
+	return stats
+}
+
+// speedPercentiles uses the nearest rank of the sorted speeds
+func speedPercentiles(speeds []float64) SpeedPercentiles {
+	if len(speeds) == 0 {
+		return SpeedPercentiles{}
+	}
+	sorted := slices.Clone(speeds)
+	slices.Sort(sorted)
+	rank := func(p int) float64 {
+		return sorted[max((p*len(sorted)+99)/100-1, 0)]
+	}
+	return SpeedPercentiles{P50: rank(50), P95: rank(95)}
+}
+
+// statsSince aggregates the metrics and errors recorded at or after since
+func (mp *metricsMonitor) statsSince(since time.Time) StatsReport {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	report := StatsReport{Since: since, Models: []ModelStats{}}
+	if len(mp.metrics) > 0 {
+		report.Oldest = mp.metrics[0].Timestamp
+	}
+
+	total := &statsBucket{}
+	buckets := make(map[string]*statsBucket)
+	bucket := func(modelID string) *statsBucket {
+		b, found := buckets[modelID]
+		if !found {
+			b = &statsBucket{stats: ModelStats{Model: modelID}}
+			buckets[modelID] = b
+		}
+		return b
+	}
+
+	for _, metric := range mp.metrics {
+		if metric.Timestamp.Before(since) {
+			continue
+		}
+		bucket(metric.Model).add(metric)
+		total.add(metric)
+	}
+	for _, requestError := range mp.errors {
+		if requestError.Timestamp.Before(since) {
+			continue
+		}
+		bucket(requestError.Model).stats.Errors++
+		total.stats.Errors++
+	}
+
+	for _, b := range buckets {
+		report.Models = append(report.Models, b.result())
+	}
+	sort.Slice(report.Models, func(i, j int) bool { return report.Models[i].Model < report.Models[j].Model })
+	report.Total = total.result()
+	return report
+}
+
+// apiGetStats aggregates the requests per model over ?window=hour, day or
+// week, default day
+func (pm *ProxyManager) apiGetStats(c *gin.Context) {
+	window := c.DefaultQuery("window", "day")
+	duration, found := statsWindows[window]
+	if !found {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid window '%s', must be one of: hour, day, week", window)})
+		return
+	}
+
+	report := pm.metricsMonitor.statsSince(time.Now().Add(-duration))
+	report.Window = window
+	c.JSON(http.StatusOK, report)
+}
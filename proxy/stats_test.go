@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricsMonitor_StatsSince(t *testing.T) {
+	mm := newMetricsMonitor(testLogger, 100, 0)
+	now := time.Now()
+	mm.addMetrics(TokenMetrics{Model: "old", Timestamp: now.Add(-2 * time.Hour), InputTokens: 1000})
+	for i, speed := range []float64{10, 20, 30, 40, -1} {
+		mm.addMetrics(TokenMetrics{Model: "model1", Timestamp: now, InputTokens: 10, OutputTokens: i, TokensPerSecond: speed, DurationMs: 100 * (i + 1)})
+	}
+	mm.addMetrics(TokenMetrics{Model: "model2", Timestamp: now, OutputTokens: 5, TokensPerSecond: 50})
+	mm.addError(RequestError{Model: "model2", Timestamp: now, Status: 500})
+	mm.addError(RequestError{Model: "model3", Timestamp: now, Status: 503})
+
+	report := mm.statsSince(now.Add(-time.Hour))
+	require.Len(t, report.Models, 3)
+
+	model1 := report.Models[0]
+	assert.Equal(t, "model1", model1.Model)
+	assert.Equal(t, 5, model1.Requests)
+	assert.Equal(t, 0, model1.Errors)
+	assert.Equal(t, 50, model1.InputTokens)
+	assert.Equal(t, 10, model1.OutputTokens)
+	assert.Equal(t, 60, model1.TotalTokens)
+	assert.Equal(t, 300, model1.AvgDurationMs)
+	// the unknown speed is left out
+	assert.Equal(t, SpeedPercentiles{P50: 20, P95: 40}, model1.TokensPerSecond)
+
+	assert.Equal(t, ModelStats{Model: "model2", Requests: 1, Errors: 1, OutputTokens: 5, TotalTokens: 5, TokensPerSecond: SpeedPercentiles{P50: 50, P95: 50}}, report.Models[1])
+	assert.Equal(t, ModelStats{Model: "model3", Errors: 1}, report.Models[2])
+
+	assert.Equal(t, 6, report.Total.Requests)
+	assert.Equal(t, 2, report.Total.Errors)
+	assert.Equal(t, 65, report.Total.TotalTokens)
+}
+
+func TestProxyManager_Stats(t *testing.T) {
+	conf := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Models:             map[string]config.ModelConfig{"model1": getTestSimpleResponderConfig("model1")},
+		LogLevel:           "error",
+	})
+	proxy := New(conf)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	w := CreateTestResponseRecorder()
+	proxy.ServeHTTP(w, httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(`{"model":"model1"}`)))
+	require.Equal(t, http.StatusOK, w.Code)
+	w = CreateTestResponseRecorder()
+	proxy.ServeHTTP(w, httptest.NewRequest("POST", "/upstream/model1/missing", nil))
+	require.Equal(t, http.StatusNotFound, w.Code)
+
+	get := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, httptest.NewRequest("GET", "/api/stats"+query, nil))
+		return w
+	}
+
+	var report StatsReport
+	require.NoError(t, json.Unmarshal(get("?window=hour").Body.Bytes(), &report))
+	assert.Equal(t, "hour", report.Window)
+	require.Len(t, report.Models, 1)
+	assert.Equal(t, "model1", report.Models[0].Model)
+	assert.Equal(t, 1, report.Models[0].Requests)
+	assert.Equal(t, 1, report.Models[0].Errors)
+
+	assert.Equal(t, http.StatusOK, get("").Code)
+	assert.Equal(t, http.StatusBadRequest, get("?window=month").Code)
+}
@@ -0,0 +1,130 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+const (
+	// number of retries it took to get a valid reply
+	structuredOutputRetriesHeader = "X-Structured-Output-Retries"
+
+	// set to "invalid" when the reply still does not match after all retries
+	structuredOutputHeader = "X-Structured-Output"
+)
+
+// responseSchema returns the decoded JSON schema of a non-streaming chat
+// completion request with response_format json_schema
+func responseSchema(path string, body []byte) (any, bool) {
+	if path != "/v1/chat/completions" || gjson.GetBytes(body, "stream").Bool() {
+		return nil, false
+	}
+	if gjson.GetBytes(body, "response_format.type").String() != "json_schema" {
+		return nil, false
+	}
+	raw := gjson.GetBytes(body, "response_format.json_schema.schema")
+	if !raw.IsObject() {
+		return nil, false
+	}
+
+	var schema any
+	if err := json.Unmarshal([]byte(raw.Raw), &schema); err != nil {
+		return nil, false
+	}
+	return schema, true
+}
+
+// checkStructuredOutput returns why a chat completion response does not
+// match the schema, or nil when it does
+func checkStructuredOutput(schema any, response []byte) error {
+	content := gjson.GetBytes(response, "choices.0.message.content")
+	if !content.Exists() {
+		return fmt.Errorf("reply has no message content")
+	}
+
+	var value any
+	if err := json.Unmarshal([]byte(content.String()), &value); err != nil {
+		return fmt.Errorf("reply is not valid JSON: %v", err)
+	}
+	return validateJSONSchema(schema, value)
+}
+
+// structuredOutputRetryBody appends the invalid reply and a message that asks
+// the model to try again. The nudge is sent as a user message as many chat
+// templates only accept a system message at the start.
+func structuredOutputRetryBody(body []byte, response []byte, validationErr error) ([]byte, error) {
+	reply := gjson.GetBytes(response, "choices.0.message.content").String()
+	body, err := sjson.SetBytes(body, "messages.-1", map[string]string{"role": "assistant", "content": reply})
+	if err != nil {
+		return nil, err
+	}
+	return sjson.SetBytes(body, "messages.-1", map[string]string{
+		"role": "user",
+		"content": fmt.Sprintf("Your reply does not match the required JSON schema: %v. "+
+			"Reply again with only a JSON value that matches the schema and no other text.", validationErr),
+	})
+}
+
+// structuredOutputHandler wraps next so replies are validated against the
+// requested schema. Invalid replies are re-asked up to retries times, only
+// the last response is sent to the client.
+func (pm *ProxyManager) structuredOutputHandler(
+	body []byte,
+	schema any,
+	retries int,
+	next func(modelID string, w http.ResponseWriter, r *http.Request) error,
+) func(modelID string, w http.ResponseWriter, r *http.Request) error {
+	return func(modelID string, w http.ResponseWriter, r *http.Request) error {
+		for attempt := 0; ; attempt++ {
+			req := r.Clone(r.Context())
+			req.Body = io.NopCloser(bytes.NewReader(body))
+			req.ContentLength = int64(len(body))
+			req.Header.Set("Content-Length", strconv.Itoa(len(body)))
+			// the reply is read here, keep it uncompressed
+			req.Header.Del("Accept-Encoding")
+
+			buffered := &bufferedResponseWriter{header: make(http.Header)}
+			if err := next(modelID, buffered, req); err != nil {
+				return err
+			}
+			if buffered.status == 0 {
+				buffered.status = http.StatusOK
+			}
+
+			var validationErr error
+			if buffered.status == http.StatusOK {
+				validationErr = checkStructuredOutput(schema, buffered.body.Bytes())
+			}
+
+			if buffered.status != http.StatusOK || validationErr == nil || attempt == retries {
+				if validationErr != nil {
+					pm.proxyLogger.Warnf("<%s> reply does not match the JSON schema after %d retries: %v", modelID, attempt, validationErr)
+					buffered.header.Set(structuredOutputHeader, "invalid")
+				}
+				buffered.header.Set(structuredOutputRetriesHeader, strconv.Itoa(attempt))
+				buffered.header.Del("Content-Length")
+
+				for key, values := range buffered.header {
+					w.Header()[key] = values
+				}
+				w.WriteHeader(buffered.status)
+				_, err := w.Write(buffered.body.Bytes())
+				return err
+			}
+
+			pm.proxyLogger.Infof("<%s> reply does not match the JSON schema, retrying (%d/%d): %v", modelID, attempt+1, retries, validationErr)
+			retryBody, err := structuredOutputRetryBody(body, buffered.body.Bytes(), validationErr)
+			if err != nil {
+				return err
+			}
+			body = retryBody
+		}
+	}
+}
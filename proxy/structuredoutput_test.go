@@ -0,0 +1,110 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+func TestResponseSchema(t *testing.T) {
+	body := []byte(`{"model":"m","response_format":{"type":"json_schema","json_schema":{"name":"x","schema":{"type":"object"}}}}`)
+
+	schema, ok := responseSchema("/v1/chat/completions", body)
+	assert.True(t, ok)
+	assert.Equal(t, map[string]any{"type": "object"}, schema)
+
+	_, ok = responseSchema("/v1/completions", body)
+	assert.False(t, ok)
+
+	_, ok = responseSchema("/v1/chat/completions", []byte(`{"stream":true,"response_format":{"type":"json_schema","json_schema":{"schema":{}}}}`))
+	assert.False(t, ok, "streaming replies can not be validated")
+
+	_, ok = responseSchema("/v1/chat/completions", []byte(`{"response_format":{"type":"json_object"}}`))
+	assert.False(t, ok)
+}
+
+func TestProxyManager_StructuredOutputHandler(t *testing.T) {
+	pm := &ProxyManager{proxyLogger: testLogger}
+	schema := map[string]any{
+		"type":     "object",
+		"required": []any{"answer"},
+	}
+	body := []byte(`{"model":"m","messages":[{"role":"user","content":"hi"}]}`)
+
+	// replies with the given contents in order and records the requests
+	upstream := func(replies ...string) (func(string, http.ResponseWriter, *http.Request) error, *[]string) {
+		var requests []string
+		return func(modelID string, w http.ResponseWriter, r *http.Request) error {
+			data, _ := io.ReadAll(r.Body)
+			requests = append(requests, string(data))
+			reply := replies[min(len(requests), len(replies))-1]
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"choices":[{"message":{"role":"assistant","content":%q}}]}`, reply)
+			return nil
+		}, &requests
+	}
+
+	t.Run("valid reply is not retried", func(t *testing.T) {
+		next, requests := upstream(`{"answer":1}`)
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewReader(body))
+
+		assert.NoError(t, pm.structuredOutputHandler(body, schema, 2, next)("m", w, req))
+		assert.Len(t, *requests, 1)
+		assert.Equal(t, "0", w.Header().Get(structuredOutputRetriesHeader))
+		assert.Equal(t, `{"answer":1}`, gjson.Get(w.Body.String(), "choices.0.message.content").String())
+	})
+
+	t.Run("invalid replies are re-asked", func(t *testing.T) {
+		next, requests := upstream("Sure! here you go", `{"other":1}`, `{"answer":2}`)
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewReader(body))
+
+		assert.NoError(t, pm.structuredOutputHandler(body, schema, 2, next)("m", w, req))
+		if assert.Len(t, *requests, 3) {
+			messages := gjson.Get((*requests)[2], "messages").Array()
+			if assert.Len(t, messages, 5) {
+				assert.Equal(t, `{"other":1}`, messages[3].Get("content").String())
+				assert.Equal(t, "user", messages[4].Get("role").String())
+				assert.Contains(t, messages[4].Get("content").String(), `missing required property "answer"`)
+			}
+		}
+		assert.Equal(t, "2", w.Header().Get(structuredOutputRetriesHeader))
+		assert.Equal(t, "", w.Header().Get(structuredOutputHeader))
+		assert.Equal(t, `{"answer":2}`, gjson.Get(w.Body.String(), "choices.0.message.content").String())
+	})
+
+	t.Run("last reply is returned when retries run out", func(t *testing.T) {
+		next, requests := upstream("not json")
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewReader(body))
+
+		assert.NoError(t, pm.structuredOutputHandler(body, schema, 1, next)("m", w, req))
+		assert.Len(t, *requests, 2)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "invalid", w.Header().Get(structuredOutputHeader))
+	})
+
+	t.Run("errors are passed through", func(t *testing.T) {
+		calls := 0
+		next := func(modelID string, w http.ResponseWriter, r *http.Request) error {
+			calls++
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("boom"))
+			return nil
+		}
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewReader(body))
+
+		assert.NoError(t, pm.structuredOutputHandler(body, schema, 2, next)("m", w, req))
+		assert.Equal(t, 1, calls)
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.Equal(t, "boom", w.Body.String())
+	})
+}
@@ -0,0 +1,197 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/napmany/llmsnap/event"
+	"github.com/napmany/llmsnap/proxy/config"
+)
+
+// headers OpenAI clients send to pick an organization and project
+const (
+	organizationHeader = "OpenAI-Organization"
+	projectHeader      = "OpenAI-Project"
+)
+
+// TenantUsage is a tenant's use of its quotas, see /api/tenants
+type TenantUsage struct {
+	Name        string   `json:"name"`
+	Models      []string `json:"models"`
+	Requests    int      `json:"requests"`
+	Tokens      int      `json:"tokens"`
+	MaxRequests int      `json:"max_requests"`
+	MaxTokens   int      `json:"max_tokens"`
+
+	// QuotaWindow is the seconds the quotas are counted in
+	QuotaWindow int `json:"quota_window"`
+}
+
+type tenantUse struct {
+	at       time.Time
+	requests int
+	tokens   int
+}
+
+// tenantQuotas counts the requests and tokens of each tenant
+type tenantQuotas struct {
+	mu   sync.Mutex
+	uses map[string][]tenantUse
+}
+
+func newTenantQuotas() *tenantQuotas {
+	return &tenantQuotas{uses: make(map[string][]tenantUse)}
+}
+
+// usage sums the requests and tokens of name after since and forgets the
+// older ones. The lock must be held.
+func (q *tenantQuotas) usage(name string, since time.Time) (requests, tokens int) {
+	var kept []tenantUse
+	for _, use := range q.uses[name] {
+		if use.at.After(since) {
+			kept = append(kept, use)
+			requests += use.requests
+			tokens += use.tokens
+		}
+	}
+	q.uses[name] = kept
+	return requests, tokens
+}
+
+// admit counts a request of name unless it is over a quota, then it returns
+// how long until the oldest counted use leaves the window
+func (q *tenantQuotas) admit(name string, tenant config.TenantConfig, now time.Time) (retryAfter time.Duration, ok bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	requests, tokens := q.usage(name, now.Add(-tenant.Window()))
+	if (tenant.MaxRequests > 0 && requests >= tenant.MaxRequests) ||
+		(tenant.MaxTokens > 0 && tokens >= tenant.MaxTokens) {
+		oldest := now
+		for _, use := range q.uses[name] {
+			if use.at.Before(oldest) {
+				oldest = use.at
+			}
+		}
+		return oldest.Add(tenant.Window()).Sub(now), false
+	}
+	q.uses[name] = append(q.uses[name], tenantUse{at: now, requests: 1})
+	return 0, true
+}
+
+func (q *tenantQuotas) addTokens(name string, tokens int, now time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.uses[name] = append(q.uses[name], tenantUse{at: now, tokens: tokens})
+}
+
+// requestTenant returns the tenant of a request by its project, then its
+// organization header. It is empty when the request sends neither and an
+// error when it names an unknown one.
+func (pm *ProxyManager) requestTenant(header http.Header) (string, error) {
+	if len(pm.config.Tenants) == 0 {
+		return "", nil
+	}
+	if project := header.Get(projectHeader); project != "" {
+		for name, tenant := range pm.config.Tenants {
+			if slices.Contains(tenant.Projects, project) {
+				return name, nil
+			}
+		}
+		return "", fmt.Errorf("unknown project %s", project)
+	}
+	if organization := header.Get(organizationHeader); organization != "" {
+		for name, tenant := range pm.config.Tenants {
+			if slices.Contains(tenant.Organizations, organization) {
+				return name, nil
+			}
+		}
+		return "", fmt.Errorf("unknown organization %s", organization)
+	}
+	return "", nil
+}
+
+// admitTenant checks that the tenant of the request may use requestedModel
+// and is within its quotas. The tenant is kept in the request context for
+// metrics. When it returns false a response has already been sent.
+func (pm *ProxyManager) admitTenant(c *gin.Context, requestedModel string) bool {
+	name, err := pm.requestTenant(c.Request.Header)
+	if err != nil {
+		pm.sendErrorResponse(c, http.StatusForbidden, err.Error())
+		return false
+	}
+	if name == "" {
+		return true
+	}
+
+	tenant := pm.config.Tenants[name]
+	modelID, _ := pm.realModelName(requestedModel)
+	if !tenant.Allows(modelID) {
+		pm.sendErrorResponse(c, http.StatusForbidden, fmt.Sprintf("model %s is not available to tenant %s", requestedModel, name))
+		return false
+	}
+
+	if retryAfter, ok := pm.tenantQuotas.admit(name, tenant, time.Now()); !ok {
+		pm.proxyLogger.Infof("<%s> tenant %s is over its quota, rejecting", requestedModel, name)
+		c.Header("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+		pm.sendErrorResponse(c, http.StatusTooManyRequests, fmt.Sprintf("tenant %s is over its quota", name))
+		return false
+	}
+
+	c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), proxyCtxKey("tenant"), name))
+	return true
+}
+
+// requestTenantName returns the tenant admitTenant found for r
+func requestTenantName(r *http.Request) string {
+	name, _ := r.Context().Value(proxyCtxKey("tenant")).(string)
+	return name
+}
+
+// countTenantTokens adds the tokens of finished requests to their tenant's
+// quota until shutdown
+func (pm *ProxyManager) countTenantTokens() {
+	cancel := event.On(func(e TokenMetricsEvent) {
+		if e.Metrics.Tenant == "" {
+			return
+		}
+		if _, found := pm.config.Tenants[e.Metrics.Tenant]; found {
+			pm.tenantQuotas.addTokens(e.Metrics.Tenant, e.Metrics.InputTokens+e.Metrics.OutputTokens, e.Metrics.Timestamp)
+		}
+	})
+
+	go func() {
+		<-pm.shutdownCtx.Done()
+		cancel()
+	}()
+}
+
+// apiGetTenants returns each tenant's use of its quotas
+func (pm *ProxyManager) apiGetTenants(c *gin.Context) {
+	now := time.Now()
+	tenants := []TenantUsage{}
+	pm.tenantQuotas.mu.Lock()
+	for name, tenant := range pm.config.Tenants {
+		requests, tokens := pm.tenantQuotas.usage(name, now.Add(-tenant.Window()))
+		tenants = append(tenants, TenantUsage{
+			Name:        name,
+			Models:      append([]string{}, tenant.Models...),
+			Requests:    requests,
+			Tokens:      tokens,
+			MaxRequests: tenant.MaxRequests,
+			MaxTokens:   tenant.MaxTokens,
+			QuotaWindow: int(tenant.Window().Seconds()),
+		})
+	}
+	pm.tenantQuotas.mu.Unlock()
+
+	sort.Slice(tenants, func(i, j int) bool { return tenants[i].Name < tenants[j].Name })
+	c.JSON(http.StatusOK, tenants)
+}
@@ -0,0 +1,134 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTenantQuotas_Admit(t *testing.T) {
+	quotas := newTenantQuotas()
+	tenant := config.TenantConfig{MaxRequests: 2, MaxTokens: 100, QuotaWindow: 60}
+	now := time.Now()
+
+	_, ok := quotas.admit("team", tenant, now.Add(-50*time.Second))
+	assert.True(t, ok)
+	_, ok = quotas.admit("team", tenant, now.Add(-10*time.Second))
+	assert.True(t, ok)
+
+	retryAfter, ok := quotas.admit("team", tenant, now)
+	assert.False(t, ok)
+	assert.Equal(t, 10*time.Second, retryAfter)
+
+	// the first request left the window
+	_, ok = quotas.admit("team", tenant, now.Add(15*time.Second))
+	assert.True(t, ok)
+
+	// over the token quota
+	quotas.addTokens("other", 150, now)
+	_, ok = quotas.admit("other", tenant, now.Add(time.Second))
+	assert.False(t, ok)
+}
+
+func TestProxyManager_Tenants(t *testing.T) {
+	conf := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]config.ModelConfig{
+			"model1": getTestSimpleResponderConfig("model1"),
+			"model2": getTestSimpleResponderConfig("model2"),
+		},
+		Tenants: map[string]config.TenantConfig{
+			"research": {Organizations: []string{"org-research"}, Models: []string{"model1"}, MaxRequests: 2},
+			"support":  {Projects: []string{"proj-support"}},
+		},
+		LogLevel: "error",
+	})
+
+	proxy := New(conf)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	send := func(method, path, body string, header http.Header) *TestResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		for key, values := range header {
+			req.Header[key] = values
+		}
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		return w
+	}
+	research := http.Header{"Openai-Organization": {"org-research"}}
+
+	w := send("POST", "/v1/chat/completions", `{"model":"model2"}`, research)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "model model2 is not available to tenant research")
+
+	// the upstream and GET model endpoints are checked too
+	w = send("GET", "/upstream/model2/health", "", research)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = send("GET", "/v1/audio/voices?model=model2", "", research)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = send("GET", "/upstream/model2/health", "", http.Header{"Openai-Organization": {"org-unknown"}})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	w = send("POST", "/v1/chat/completions", `{"model":"model1"}`, http.Header{"Openai-Organization": {"org-unknown"}})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "unknown organization org-unknown")
+
+	for range 2 {
+		w = send("POST", "/v1/chat/completions", `{"model":"model1"}`, research)
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+	w = send("POST", "/v1/chat/completions", `{"model":"model1"}`, research)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+
+	// requests without a tenant are not limited
+	w = send("POST", "/v1/chat/completions", `{"model":"model1"}`, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	metrics := proxy.metricsMonitor.getMetrics()
+	require.Len(t, metrics, 3)
+	assert.Equal(t, "research", metrics[0].Tenant)
+	assert.Empty(t, metrics[2].Tenant)
+
+	// a tenant lists only its models
+	w = send("GET", "/v1/models", "", research)
+	require.Equal(t, http.StatusOK, w.Code)
+	var models struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &models))
+	require.Len(t, models.Data, 1)
+	assert.Equal(t, "model1", models.Data[0].ID)
+
+	assert.Eventually(t, func() bool {
+		w := send("GET", "/api/tenants", "", nil)
+		var tenants []TenantUsage
+		if json.Unmarshal(w.Body.Bytes(), &tenants) != nil || len(tenants) != 2 {
+			return false
+		}
+		return tenants[0].Name == "research" && tenants[0].Requests == 2 && tenants[0].Tokens == 70
+	}, 5*time.Second, 10*time.Millisecond)
+
+	w = send("GET", "/api/usage?tenant=research", "", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	var report UsageReport
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	for _, usage := range report.Models {
+		if usage.Model == "model1" {
+			assert.Equal(t, 2, usage.Requests)
+		}
+	}
+
+	w = send("GET", "/api/usage?tenant=nobody", "", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
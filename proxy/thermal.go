@@ -0,0 +1,129 @@
+package proxy
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/napmany/llmsnap/proxy/config"
+)
+
+// thermalThrottle tracks whether the GPUs are over the thermal limits
+type thermalThrottle struct {
+	sync.RWMutex
+	config config.ThermalConfig
+
+	// why the GPUs are throttled, empty when they are not
+	reason string
+}
+
+func (t *thermalThrottle) throttled() (string, bool) {
+	if t == nil {
+		return "", false
+	}
+	t.RLock()
+	defer t.RUnlock()
+	return t.reason, t.reason != ""
+}
+
+// update applies a reading and reports if the throttled state changed.
+// Throttling starts when a limit is exceeded and ends once all readings are
+// at or below the resume thresholds.
+func (t *thermalThrottle) update(gpus []GPUInfo) (reason string, changed bool) {
+	hottest := 0
+	power := 0.0
+	for _, gpu := range gpus {
+		hottest = max(hottest, gpu.Temperature)
+		power += gpu.PowerDraw
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	if t.reason == "" {
+		switch {
+		case t.config.MaxTemperature > 0 && hottest > t.config.MaxTemperature:
+			t.reason = fmt.Sprintf("GPU temperature %d°C is above %d°C", hottest, t.config.MaxTemperature)
+		case t.config.MaxPowerDraw > 0 && power > t.config.MaxPowerDraw:
+			t.reason = fmt.Sprintf("GPU power draw %.0fW is above %.0fW", power, t.config.MaxPowerDraw)
+		default:
+			return "", false
+		}
+		return t.reason, true
+	}
+
+	if t.config.MaxTemperature > 0 && hottest > t.config.ResumeTemperatureC() {
+		return t.reason, false
+	}
+	if t.config.MaxPowerDraw > 0 && power > t.config.ResumePowerDrawW() {
+		return t.reason, false
+	}
+	t.reason = ""
+	return "", true
+}
+
+// watchThermal reads the GPUs every thermal.checkInterval until shutdown
+func (pm *ProxyManager) watchThermal() {
+	ticker := time.NewTicker(pm.thermal.config.Interval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-pm.shutdownCtx.Done():
+			return
+		case <-ticker.C:
+			pm.checkThermal()
+		}
+	}
+}
+
+// checkThermal takes a GPU reading and starts or ends throttling. When
+// throttling starts with the sleep action, ready models that support sleep
+// are put to sleep.
+func (pm *ProxyManager) checkThermal() {
+	if pm.thermal == nil || pm.readGPUs == nil {
+		return
+	}
+	gpus, err := pm.readGPUs()
+	if err != nil {
+		pm.proxyLogger.Debugf("skipping thermal check, no GPU readings: %v", err)
+		return
+	}
+
+	reason, changed := pm.thermal.update(gpus)
+	if !changed {
+		return
+	}
+	if reason == "" {
+		pm.proxyLogger.Infof("GPU readings recovered, models can be loaded again")
+		return
+	}
+
+	pm.proxyLogger.Warnf("throttling models: %s", reason)
+	if pm.thermal.config.Action == config.ThermalRefuse {
+		return
+	}
+
+	for _, processGroup := range pm.processGroups {
+		for _, process := range processGroup.instances() {
+			if process.isSleepEnabled() && process.CurrentState() == StateReady {
+				go process.Sleep()
+			}
+		}
+	}
+}
+
+// checkThermalAdmission returns an error when the GPUs are throttled and
+// modelID would have to be loaded or woken
+func (pm *ProxyManager) checkThermalAdmission(modelID string) error {
+	reason, throttled := pm.thermal.throttled()
+	if !throttled {
+		return nil
+	}
+
+	if processGroup := pm.findGroupByModelName(modelID); processGroup != nil {
+		if process, ok := processGroup.GetMember(modelID); ok && holdsVRAM(process.CurrentState()) {
+			return nil
+		}
+	}
+	return fmt.Errorf("model %s can not be loaded while throttled: %s, try again later", modelID, reason)
+}
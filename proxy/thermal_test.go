@@ -0,0 +1,109 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThermalThrottle_Update(t *testing.T) {
+	throttle := &thermalThrottle{config: config.ThermalConfig{MaxTemperature: 80, MaxPowerDraw: 500}}
+
+	reason, changed := throttle.update([]GPUInfo{{Temperature: 70, PowerDraw: 200}, {Temperature: 60, PowerDraw: 250}})
+	assert.False(t, changed)
+	assert.Empty(t, reason)
+
+	reason, changed = throttle.update([]GPUInfo{{Temperature: 70, PowerDraw: 300}, {Temperature: 60, PowerDraw: 250}})
+	assert.True(t, changed)
+	assert.Equal(t, "GPU power draw 550W is above 500W", reason)
+
+	// power has to fall to 90% of the limit
+	_, changed = throttle.update([]GPUInfo{{Temperature: 70, PowerDraw: 460}})
+	assert.False(t, changed)
+	_, throttled := throttle.throttled()
+	assert.True(t, throttled)
+
+	reason, changed = throttle.update([]GPUInfo{{Temperature: 70, PowerDraw: 400}})
+	assert.True(t, changed)
+	assert.Empty(t, reason)
+
+	reason, changed = throttle.update([]GPUInfo{{Temperature: 85}})
+	assert.True(t, changed)
+	assert.Equal(t, "GPU temperature 85°C is above 80°C", reason)
+
+	// temperature has to fall 5°C below the limit
+	_, changed = throttle.update([]GPUInfo{{Temperature: 78}})
+	assert.False(t, changed)
+	_, changed = throttle.update([]GPUInfo{{Temperature: 75}})
+	assert.True(t, changed)
+}
+
+func TestProxyManager_ThermalThrottling(t *testing.T) {
+	sleepCfg := getTestSimpleResponderConfig("sleepmodel")
+	sleepCfg.SleepMode = config.SleepModeEnable
+	sleepCfg.SleepEndpoints = []config.HTTPEndpoint{
+		{Endpoint: "/sleep", Method: "POST", Timeout: 5},
+	}
+	sleepCfg.WakeEndpoints = []config.HTTPEndpoint{
+		{Endpoint: "/wake_up", Method: "POST", Timeout: 5},
+	}
+
+	conf := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]config.ModelConfig{
+			"sleepmodel":  sleepCfg,
+			"normalmodel": getTestSimpleResponderConfig("normalmodel"),
+		},
+		Groups: map[string]config.GroupConfig{
+			"all": {Swap: false, Members: []string{"sleepmodel", "normalmodel"}},
+		},
+		Thermal:  config.ThermalConfig{MaxTemperature: 80, CheckInterval: 3600},
+		LogLevel: "error",
+	})
+
+	proxy := New(conf)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	temperature := 40
+	proxy.readGPUs = func() ([]GPUInfo, error) {
+		return []GPUInfo{{Index: 0, Temperature: temperature}}, nil
+	}
+
+	doRequest := func(model string) *TestResponseRecorder {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model":"`+model+`"}`))
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, doRequest("sleepmodel").Code)
+
+	temperature = 90
+	proxy.checkThermal()
+	sleepProcess, _ := proxy.findGroupByModelName("sleepmodel").GetMember("sleepmodel")
+	assert.Eventually(t, func() bool {
+		return sleepProcess.CurrentState() == StateAsleep
+	}, 5*time.Second, 50*time.Millisecond)
+
+	for _, model := range []string{"sleepmodel", "normalmodel"} {
+		w := doRequest(model)
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.Equal(t, "30", w.Header().Get("Retry-After"))
+		assert.Contains(t, w.Body.String(), "GPU temperature 90°C is above 80°C")
+	}
+
+	// still above the resume temperature
+	temperature = 78
+	proxy.checkThermal()
+	assert.Equal(t, http.StatusServiceUnavailable, doRequest("normalmodel").Code)
+
+	temperature = 70
+	proxy.checkThermal()
+	assert.Equal(t, http.StatusOK, doRequest("normalmodel").Code)
+	assert.Equal(t, http.StatusOK, doRequest("sleepmodel").Code)
+}
@@ -0,0 +1,53 @@
+package proxy
+
+import (
+	"unicode/utf8"
+
+	"github.com/tidwall/gjson"
+)
+
+// charsPerToken is about what the common BPE tokenizers average on English
+// text and code
+const charsPerToken = 4
+
+// tokensPerMessage covers the role and the template tokens around a message
+const tokensPerMessage = 4
+
+// estimateTokens guesses the tokens in the prompt of an inference request
+// without a tokenizer: the text of the messages, system prompt, prompt,
+// input, instructions and tools at charsPerToken characters a token. Images
+// and other binary parts are not counted.
+func estimateTokens(body []byte) int {
+	chars, messages := 0, 0
+	request := gjson.ParseBytes(body)
+	for _, key := range []string{"messages", "system", "prompt", "input", "instructions", "tools", "suffix"} {
+		field := request.Get(key)
+		if key == "messages" || (key == "input" && field.IsArray()) {
+			messages += len(field.Array())
+		}
+		chars += countText(field)
+	}
+	return (chars+charsPerToken-1)/charsPerToken + messages*tokensPerMessage
+}
+
+// countText returns the characters of the strings in value, skipping the
+// ones that are no text like roles, IDs, URLs and base64 data
+func countText(value gjson.Result) int {
+	switch {
+	case value.Type == gjson.String:
+		return utf8.RuneCountInString(value.Str)
+	case value.IsArray() || value.IsObject():
+		chars := 0
+		value.ForEach(func(key, item gjson.Result) bool {
+			switch key.Str {
+			case "role", "type", "id", "tool_call_id", "url", "data", "image_url", "media_type", "source":
+				return true
+			}
+			chars += countText(item)
+			return true
+		})
+		return chars
+	default:
+		return 0
+	}
+}
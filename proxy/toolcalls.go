@@ -0,0 +1,244 @@
+package proxy
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// repairJSON fixes the mistakes local models make when writing tool call
+// arguments: code fences, text before or after the JSON, unescaped quotes
+// and control characters in strings, trailing commas and unclosed brackets.
+// ok is false when the result is still not valid JSON.
+func repairJSON(s string) (repaired string, ok bool) {
+	if json.Valid([]byte(s)) {
+		return s, true
+	}
+
+	start := strings.IndexAny(s, "{[")
+	if start < 0 {
+		return s, false
+	}
+
+	out := make([]byte, 0, len(s)+8)
+	var stack []byte
+	inString := false
+
+scan:
+	for i := start; i < len(s); i++ {
+		c := s[i]
+		if inString {
+			switch c {
+			case '\\':
+				out = append(out, c)
+				if i+1 < len(s) {
+					i++
+					out = append(out, s[i])
+				}
+			case '"':
+				// a quote that is not followed by JSON syntax is part of
+				// the string
+				if next := nextNonSpace(s, i+1); next == 0 || strings.IndexByte(",:}]", next) >= 0 {
+					inString = false
+					out = append(out, c)
+				} else {
+					out = append(out, '\\', '"')
+				}
+			case '\n':
+				out = append(out, '\\', 'n')
+			case '\r':
+				out = append(out, '\\', 'r')
+			case '\t':
+				out = append(out, '\\', 't')
+			default:
+				out = append(out, c)
+			}
+			continue
+		}
+
+		switch c {
+		case '"':
+			inString = true
+		case '{', '[':
+			stack = append(stack, c)
+		case '}', ']':
+			out = trimTrailingComma(out)
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+			out = append(out, c)
+			if len(stack) == 0 {
+				// anything after the value is trailing text
+				break scan
+			}
+			continue
+		}
+		out = append(out, c)
+	}
+
+	// close what a truncated reply left open
+	if inString {
+		out = append(out, '"')
+	}
+	for i := len(stack) - 1; i >= 0; i-- {
+		out = trimTrailingComma(out)
+		if stack[i] == '{' {
+			out = append(out, '}')
+		} else {
+			out = append(out, ']')
+		}
+	}
+
+	if !json.Valid(out) {
+		return s, false
+	}
+	return string(out), true
+}
+
+// nextNonSpace returns the first byte from i on that is not whitespace, or 0
+func nextNonSpace(s string, i int) byte {
+	for ; i < len(s); i++ {
+		switch s[i] {
+		case ' ', '\t', '\r', '\n':
+		default:
+			return s[i]
+		}
+	}
+	return 0
+}
+
+func trimTrailingComma(out []byte) []byte {
+	end := len(out)
+	for end > 0 && strings.IndexByte(" \t\r\n", out[end-1]) >= 0 {
+		end--
+	}
+	if end > 0 && out[end-1] == ',' {
+		return out[:end-1]
+	}
+	return out
+}
+
+// toolCallRepairer repairs the arguments of tool calls in chat completion
+// responses. When streaming, argument fragments are held back and the
+// repaired arguments are sent in one piece before the choice finishes.
+type toolCallRepairer struct {
+	modelID string
+	logger  *LogMonitor
+
+	// arguments of each choice's tool calls, by tool call index
+	arguments map[int64]map[int64]*strings.Builder
+}
+
+func newToolCallRepairer(modelID string, logger *LogMonitor) *toolCallRepairer {
+	return &toolCallRepairer{
+		modelID:   modelID,
+		logger:    logger,
+		arguments: make(map[int64]map[int64]*strings.Builder),
+	}
+}
+
+// repair returns the repaired arguments, logging what happened
+func (tr *toolCallRepairer) repair(arguments string) string {
+	if arguments == "" {
+		return arguments
+	}
+	repaired, ok := repairJSON(arguments)
+	if !ok {
+		tr.logger.Warnf("<%s> could not repair tool call arguments: %s", tr.modelID, arguments)
+		return arguments
+	}
+	if repaired != arguments {
+		tr.logger.Infof("<%s> repaired tool call arguments: %s", tr.modelID, arguments)
+	}
+	return repaired
+}
+
+func (tr *toolCallRepairer) transformBody(body []byte) []byte {
+	for i, choice := range gjson.GetBytes(body, "choices").Array() {
+		for j, call := range choice.Get("message.tool_calls").Array() {
+			arguments := call.Get("function.arguments")
+			if arguments.Type != gjson.String {
+				continue
+			}
+			if repaired := tr.repair(arguments.String()); repaired != arguments.String() {
+				path := "choices." + strconv.Itoa(i) + ".message.tool_calls." + strconv.Itoa(j) + ".function.arguments"
+				body, _ = sjson.SetBytes(body, path, repaired)
+			}
+		}
+	}
+	return body
+}
+
+func (tr *toolCallRepairer) transformEvent(payload []byte) [][]byte {
+	var finished [][]byte
+	for i, choice := range gjson.GetBytes(payload, "choices").Array() {
+		choiceIndex := choice.Get("index").Int()
+		calls := choice.Get("delta.tool_calls").Array()
+		for j, call := range calls {
+			arguments := call.Get("function.arguments")
+			if arguments.Type != gjson.String {
+				continue
+			}
+			if tr.arguments[choiceIndex] == nil {
+				tr.arguments[choiceIndex] = make(map[int64]*strings.Builder)
+			}
+			callIndex := call.Get("index").Int()
+			if tr.arguments[choiceIndex][callIndex] == nil {
+				tr.arguments[choiceIndex][callIndex] = &strings.Builder{}
+			}
+			tr.arguments[choiceIndex][callIndex].WriteString(arguments.String())
+
+			path := "choices." + strconv.Itoa(i) + ".delta.tool_calls." + strconv.Itoa(j) + ".function.arguments"
+			payload, _ = sjson.SetBytes(payload, path, "")
+		}
+
+		if finish := choice.Get("finish_reason"); finish.Exists() && finish.Type != gjson.Null {
+			if event := tr.finishChoice(payload, choiceIndex); event != nil {
+				finished = append(finished, event)
+			}
+		}
+	}
+
+	return append(finished, payload)
+}
+
+// finishChoice returns an event with the repaired arguments of a choice's
+// tool calls, built from the event that finishes the choice
+func (tr *toolCallRepairer) finishChoice(payload []byte, choiceIndex int64) []byte {
+	held := tr.arguments[choiceIndex]
+	if len(held) == 0 {
+		return nil
+	}
+	delete(tr.arguments, choiceIndex)
+
+	callIndexes := make([]int64, 0, len(held))
+	for index := range held {
+		callIndexes = append(callIndexes, index)
+	}
+	sort.Slice(callIndexes, func(i, j int) bool { return callIndexes[i] < callIndexes[j] })
+
+	calls := make([]map[string]any, 0, len(held))
+	for _, index := range callIndexes {
+		calls = append(calls, map[string]any{
+			"index":    index,
+			"function": map[string]string{"arguments": tr.repair(held[index].String())},
+		})
+	}
+
+	event, err := sjson.SetBytes(payload, "choices", []map[string]any{{
+		"index":         choiceIndex,
+		"delta":         map[string]any{"tool_calls": calls},
+		"finish_reason": nil,
+	}})
+	if err != nil {
+		return nil
+	}
+	// usage belongs to the last event only
+	event, _ = sjson.DeleteBytes(event, "usage")
+	event, _ = sjson.DeleteBytes(event, "timings")
+	return event
+}
@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+func TestRepairJSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+		ok       bool
+	}{
+		{"valid", `{"a":1}`, `{"a":1}`, true},
+		{"trailing text", `{"city":"Paris"} I hope this helps`, `{"city":"Paris"}`, true},
+		{"code fence", "```json\n{\"city\":\"Paris\"}\n```", `{"city":"Paris"}`, true},
+		{"unescaped quotes", `{"text":"she said "hi" to me"}`, `{"text":"she said \"hi\" to me"}`, true},
+		{"raw newline", "{\"code\":\"a\nb\"}", `{"code":"a\nb"}`, true},
+		{"trailing comma", `{"a":[1,2,],}`, `{"a":[1,2]}`, true},
+		{"truncated", `{"a":{"b":"c`, `{"a":{"b":"c"}}`, true},
+		{"not json", `call get_weather for Paris`, `call get_weather for Paris`, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repaired, ok := repairJSON(tt.input)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.expected, repaired)
+		})
+	}
+}
+
+func TestToolCallRepairer(t *testing.T) {
+	t.Run("json body", func(t *testing.T) {
+		body := `{"choices":[{"message":{"tool_calls":[` +
+			`{"id":"1","function":{"name":"a","arguments":"{\"x\":1} done"}},` +
+			`{"id":"2","function":{"name":"b","arguments":"{\"y\":2}"}}]}}]}`
+
+		result := newToolCallRepairer("m", testLogger).transformBody([]byte(body))
+		assert.Equal(t, `{"x":1}`, gjson.GetBytes(result, "choices.0.message.tool_calls.0.function.arguments").String())
+		assert.Equal(t, `{"y":2}`, gjson.GetBytes(result, "choices.0.message.tool_calls.1.function.arguments").String())
+	})
+
+	t.Run("event stream", func(t *testing.T) {
+		stream := strings.Join([]string{
+			`data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"1","type":"function","function":{"name":"get_weather","arguments":""}}]}}]}`,
+			`data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"city\": "}}]}}]}`,
+			`data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"Paris\",}"}}]}}]}`,
+			`data: {"choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}],"usage":{"completion_tokens":9}}`,
+			`data: [DONE]`,
+			``,
+		}, "\n\n")
+
+		rec := httptest.NewRecorder()
+		rec.Header().Set("Content-Type", "text/event-stream")
+		tw := newTransformWriter(rec, newToolCallRepairer("m", testLogger))
+		tw.Write([]byte(stream))
+		tw.Close()
+
+		var arguments string
+		var events []string
+		for _, line := range strings.Split(rec.Body.String(), "\n") {
+			payload, found := strings.CutPrefix(line, "data: ")
+			if !found || payload == "[DONE]" {
+				continue
+			}
+			events = append(events, payload)
+			arguments += gjson.Get(payload, "choices.0.delta.tool_calls.0.function.arguments").String()
+		}
+
+		assert.Equal(t, `{"city": "Paris"}`, arguments)
+		if assert.Len(t, events, 5) {
+			assert.Equal(t, "get_weather", gjson.Get(events[0], "choices.0.delta.tool_calls.0.function.name").String())
+			assert.False(t, gjson.Get(events[3], "usage").Exists())
+			assert.Equal(t, "tool_calls", gjson.Get(events[4], "choices.0.finish_reason").String())
+			assert.Equal(t, int64(9), gjson.Get(events[4], "usage.completion_tokens").Int())
+		}
+	})
+}
@@ -0,0 +1,144 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// responseTransformer rewrites chat completion responses
+type responseTransformer interface {
+	// transformBody rewrites a complete JSON response
+	transformBody(body []byte) []byte
+
+	// transformEvent rewrites the JSON payload of one event of a stream. It
+	// may return more or fewer payloads than it was given.
+	transformEvent(payload []byte) [][]byte
+}
+
+// transformWriter applies a responseTransformer to responses written by the
+// reverse proxy. JSON responses are buffered and rewritten when the response
+// is complete, event streams are rewritten one event at a time. Compressed
+// or unsuccessful responses are passed through untouched.
+type transformWriter struct {
+	http.ResponseWriter
+	transformer responseTransformer
+
+	checked     bool
+	passthrough bool
+	isSSE       bool
+
+	// the whole JSON body, or the unfinished line of an event stream
+	buf bytes.Buffer
+}
+
+func newTransformWriter(w http.ResponseWriter, transformer responseTransformer) *transformWriter {
+	return &transformWriter{
+		ResponseWriter: w,
+		transformer:    transformer,
+	}
+}
+
+func (tw *transformWriter) WriteHeader(statusCode int) {
+	if !tw.checked {
+		tw.checked = true
+		header := tw.ResponseWriter.Header()
+		contentType := strings.ToLower(header.Get("Content-Type"))
+		tw.isSSE = strings.Contains(contentType, "text/event-stream")
+		tw.passthrough = statusCode != http.StatusOK || header.Get("Content-Encoding") != "" ||
+			(!tw.isSSE && !strings.Contains(contentType, "application/json"))
+		if !tw.passthrough {
+			header.Del("Content-Length")
+		}
+	}
+	tw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (tw *transformWriter) Write(data []byte) (int, error) {
+	if !tw.checked {
+		tw.WriteHeader(http.StatusOK)
+	}
+	if tw.passthrough {
+		return tw.ResponseWriter.Write(data)
+	}
+
+	tw.buf.Write(data)
+	if !tw.isSSE {
+		return len(data), nil
+	}
+
+	var out bytes.Buffer
+	for {
+		line, err := tw.buf.ReadBytes('\n')
+		if err != nil {
+			// keep the unfinished line for the next write
+			rest := bytes.Clone(line)
+			tw.buf.Reset()
+			tw.buf.Write(rest)
+			break
+		}
+		out.Write(tw.transformEventLine(line))
+	}
+	if out.Len() > 0 {
+		if _, err := tw.ResponseWriter.Write(out.Bytes()); err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+// transformEventLine rewrites a data: line of a chat completion stream
+func (tw *transformWriter) transformEventLine(line []byte) []byte {
+	trimmed := bytes.TrimRight(line, "\r\n")
+	payload, found := bytes.CutPrefix(trimmed, []byte("data:"))
+	if !found {
+		return line
+	}
+	payload = bytes.TrimLeft(payload, " ")
+	if !gjson.ValidBytes(payload) || !gjson.GetBytes(payload, "choices").IsArray() {
+		return line
+	}
+
+	// extra payloads become events of their own, the last one ends with the
+	// original line ending
+	var result []byte
+	payloads := tw.transformer.transformEvent(payload)
+	for i, p := range payloads {
+		result = append(result, "data: "...)
+		result = append(result, p...)
+		if i < len(payloads)-1 {
+			result = append(result, "\n\n"...)
+		}
+	}
+	if len(payloads) == 0 {
+		return nil
+	}
+	return append(result, line[len(trimmed):]...)
+}
+
+func (tw *transformWriter) Flush() {
+	if tw.passthrough || tw.isSSE {
+		if flusher, ok := tw.ResponseWriter.(http.Flusher); ok {
+			flusher.Flush()
+		}
+	}
+}
+
+// Close writes the buffered JSON body, or the unfinished end of a stream
+func (tw *transformWriter) Close() {
+	if tw.passthrough || tw.buf.Len() == 0 {
+		return
+	}
+	if tw.isSSE {
+		tw.ResponseWriter.Write(tw.buf.Bytes())
+	} else {
+		tw.ResponseWriter.Write(tw.transformer.transformBody(tw.buf.Bytes()))
+	}
+	tw.buf.Reset()
+}
+
+func (tw *transformWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
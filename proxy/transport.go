@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/napmany/llmsnap/proxy/config"
+	"golang.org/x/net/http/httpproxy"
+)
+
+// newUpstreamTransport creates the http.Transport used by reverse proxies to
+// reach upstream servers. It starts from http.DefaultTransport and applies any
+// non-zero settings from the configuration.
+func newUpstreamTransport(tc config.TransportConfig) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	if tc.DialTimeout > 0 {
+		dialer.Timeout = time.Duration(tc.DialTimeout) * time.Second
+	}
+	if tc.KeepAlive > 0 {
+		dialer.KeepAlive = time.Duration(tc.KeepAlive) * time.Second
+	}
+	transport.DialContext = dialer.DialContext
+
+	if tc.MaxIdleConns > 0 {
+		transport.MaxIdleConns = tc.MaxIdleConns
+	}
+	if tc.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = tc.MaxIdleConnsPerHost
+	}
+	if tc.IdleConnTimeout > 0 {
+		transport.IdleConnTimeout = time.Duration(tc.IdleConnTimeout) * time.Second
+	}
+	if tc.ResponseHeaderTimeout > 0 {
+		transport.ResponseHeaderTimeout = time.Duration(tc.ResponseHeaderTimeout) * time.Second
+	}
+
+	return transport
+}
+
+// upstreamProxyFunc returns the Transport.Proxy func of a model's
+// upstreamProxy, nil when it is not set
+func upstreamProxyFunc(conf config.UpstreamProxyConfig) func(*http.Request) (*url.URL, error) {
+	if !conf.Enabled() {
+		return nil
+	}
+	proxyURL := (&httpproxy.Config{
+		HTTPProxy:  conf.URL,
+		HTTPSProxy: conf.URL,
+		NoProxy:    conf.NoProxy,
+	}).ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return proxyURL(req.URL)
+	}
+}
+
+// withUpstreamProxy returns transport when the model has no upstreamProxy,
+// otherwise a copy that connects through the proxy
+func withUpstreamProxy(transport *http.Transport, conf config.UpstreamProxyConfig) *http.Transport {
+	proxy := upstreamProxyFunc(conf)
+	if proxy == nil {
+		return transport
+	}
+	transport = transport.Clone()
+	transport.Proxy = proxy
+	return transport
+}
@@ -0,0 +1,102 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransport_Defaults(t *testing.T) {
+	transport := newUpstreamTransport(config.TransportConfig{})
+	defaultTransport := http.DefaultTransport.(*http.Transport)
+
+	assert.Equal(t, defaultTransport.MaxIdleConns, transport.MaxIdleConns)
+	assert.Equal(t, defaultTransport.MaxIdleConnsPerHost, transport.MaxIdleConnsPerHost)
+	assert.Equal(t, defaultTransport.IdleConnTimeout, transport.IdleConnTimeout)
+	assert.Equal(t, time.Duration(0), transport.ResponseHeaderTimeout)
+	assert.NotNil(t, transport.DialContext)
+}
+
+func TestTransport_Overrides(t *testing.T) {
+	transport := newUpstreamTransport(config.TransportConfig{
+		DialTimeout:           5,
+		KeepAlive:             10,
+		MaxIdleConns:          500,
+		MaxIdleConnsPerHost:   200,
+		IdleConnTimeout:       30,
+		ResponseHeaderTimeout: 300,
+	})
+
+	assert.Equal(t, 500, transport.MaxIdleConns)
+	assert.Equal(t, 200, transport.MaxIdleConnsPerHost)
+	assert.Equal(t, 30*time.Second, transport.IdleConnTimeout)
+	assert.Equal(t, 300*time.Second, transport.ResponseHeaderTimeout)
+}
+
+func TestProcessGroup_SharesUpstreamTransport(t *testing.T) {
+	cfg := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Transport:          config.TransportConfig{MaxIdleConnsPerHost: 64},
+		Models: map[string]config.ModelConfig{
+			"model1": getTestSimpleResponderConfig("model1"),
+			"model2": getTestSimpleResponderConfig("model2"),
+		},
+	})
+
+	pg := NewProcessGroup(config.DEFAULT_GROUP_ID, cfg, testLogger, testLogger)
+	t1, ok := pg.processes["model1"].reverseProxy.Transport.(*http.Transport)
+	if assert.True(t, ok) {
+		assert.Equal(t, 64, t1.MaxIdleConnsPerHost)
+	}
+	assert.Same(t, pg.processes["model1"].reverseProxy.Transport, pg.processes["model2"].reverseProxy.Transport)
+}
+
+func TestTransport_UpstreamProxy(t *testing.T) {
+	assert.Nil(t, upstreamProxyFunc(config.UpstreamProxyConfig{}))
+
+	proxy := upstreamProxyFunc(config.UpstreamProxyConfig{
+		URL:     "socks5://127.0.0.1:1080",
+		NoProxy: "10.0.0.0/8,.internal",
+	})
+	proxyURL := func(target string) string {
+		u, err := proxy(httptest.NewRequest("POST", target, nil))
+		assert.NoError(t, err)
+		if u == nil {
+			return ""
+		}
+		return u.String()
+	}
+
+	assert.Equal(t, "socks5://127.0.0.1:1080", proxyURL("http://gpu-box.example.com:8080/v1/chat/completions"))
+	assert.Equal(t, "socks5://127.0.0.1:1080", proxyURL("https://api.example.com/v1/models"))
+	assert.Equal(t, "", proxyURL("http://10.1.2.3:8080/health"))
+	assert.Equal(t, "", proxyURL("http://llm.internal:8080/health"))
+	assert.Equal(t, "", proxyURL("http://127.0.0.1:8080/health"))
+}
+
+func TestProcessGroup_UpstreamProxyTransport(t *testing.T) {
+	model2 := getTestSimpleResponderConfig("model2")
+	model2.UpstreamProxy = config.UpstreamProxyConfig{URL: "http://proxy.corp:3128"}
+	cfg := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Transport:          config.TransportConfig{MaxIdleConnsPerHost: 64},
+		Models: map[string]config.ModelConfig{
+			"model1": getTestSimpleResponderConfig("model1"),
+			"model2": model2,
+		},
+	})
+
+	pg := NewProcessGroup(config.DEFAULT_GROUP_ID, cfg, testLogger, testLogger)
+	t1 := pg.processes["model1"].reverseProxy.Transport.(*http.Transport)
+	t2 := pg.processes["model2"].reverseProxy.Transport.(*http.Transport)
+	assert.NotSame(t, t1, t2)
+	assert.Equal(t, 64, t2.MaxIdleConnsPerHost)
+
+	u, err := t2.Proxy(httptest.NewRequest("GET", "http://gpu-box:8080/v1/models", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, "http://proxy.corp:3128", u.String())
+}
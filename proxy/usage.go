@@ -0,0 +1,172 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ModelUsage summarizes the requests a model served
+type ModelUsage struct {
+	Model        string    `json:"model"`
+	Requests     int       `json:"requests"`
+	InputTokens  int       `json:"input_tokens"`
+	OutputTokens int       `json:"output_tokens"`
+	LastUsed     time.Time `json:"last_used,omitzero"`
+
+	// Cost from the model's pricing, 0 without pricing
+	Cost float64 `json:"cost"`
+
+	// TTL is the model's ttl in seconds, 0 never unloads
+	TTL   int          `json:"ttl"`
+	State ProcessState `json:"state"`
+}
+
+// UsageReport is the response of /api/usage
+type UsageReport struct {
+	// Since is the start of the window, zero for all kept metrics
+	Since time.Time `json:"since,omitzero"`
+
+	// Tenant limits the report to the requests of one tenant
+	Tenant string `json:"tenant,omitempty"`
+
+	// Oldest is the time of the oldest kept metric. Requests before it were
+	// dropped because of metricsMaxInMemory.
+	Oldest time.Time `json:"oldest,omitzero"`
+
+	Models []ModelUsage `json:"models"`
+
+	// Days sums the requests of each day, oldest first
+	Days []DailyUsage `json:"days"`
+}
+
+// DailyUsage sums the requests of a day in the server's time zone
+type DailyUsage struct {
+	Date     string  `json:"date"`
+	Requests int     `json:"requests"`
+	Cost     float64 `json:"cost"`
+
+	// CumulativeCost is the cost of this day and the days before it
+	CumulativeCost float64 `json:"cumulative_cost"`
+}
+
+// usageSince sums the metrics recorded at or after since by model and by day,
+// only those of tenant unless it is empty
+func (mp *metricsMonitor) usageSince(since time.Time, tenant string) (map[string]ModelUsage, []DailyUsage, time.Time) {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+
+	var oldest time.Time
+	if len(mp.metrics) > 0 {
+		oldest = mp.metrics[0].Timestamp
+	}
+
+	usage := make(map[string]ModelUsage)
+	days := []DailyUsage{}
+	for _, metric := range mp.metrics {
+		if metric.Timestamp.Before(since) || (tenant != "" && metric.Tenant != tenant) {
+			continue
+		}
+		u := usage[metric.Model]
+		u.Model = metric.Model
+		u.Requests++
+		u.InputTokens += metric.InputTokens
+		u.OutputTokens += metric.OutputTokens
+		u.Cost += metric.Cost
+		if metric.Timestamp.After(u.LastUsed) {
+			u.LastUsed = metric.Timestamp
+		}
+		usage[metric.Model] = u
+
+		// metrics are kept in the order they were recorded
+		date := metric.Timestamp.Local().Format(time.DateOnly)
+		if len(days) == 0 || days[len(days)-1].Date != date {
+			days = append(days, DailyUsage{Date: date})
+		}
+		days[len(days)-1].Requests++
+		days[len(days)-1].Cost += metric.Cost
+	}
+
+	var cumulative float64
+	for i := range days {
+		cumulative += days[i].Cost
+		days[i].CumulativeCost = cumulative
+	}
+	return usage, days, oldest
+}
+
+// usageReport lists every configured model with its usage in the window,
+// sorted by requests, tokens or last_used, most first
+func (pm *ProxyManager) usageReport(window time.Duration, sortBy, tenant string) (UsageReport, error) {
+	report := UsageReport{Tenant: tenant}
+	if window > 0 {
+		report.Since = time.Now().Add(-window)
+	}
+
+	usage, days, oldest := pm.metricsMonitor.usageSince(report.Since, tenant)
+	report.Oldest = oldest
+	report.Days = days
+
+	for modelID, modelConfig := range pm.config.Models {
+		u := usage[modelID]
+		u.Model = modelID
+		u.TTL = modelConfig.UnloadAfter
+		u.State = pm.modelState(modelID)
+		report.Models = append(report.Models, u)
+	}
+
+	var less func(a, b ModelUsage) bool
+	switch sortBy {
+	case "", "requests":
+		less = func(a, b ModelUsage) bool { return a.Requests > b.Requests }
+	case "tokens":
+		less = func(a, b ModelUsage) bool {
+			return a.InputTokens+a.OutputTokens > b.InputTokens+b.OutputTokens
+		}
+	case "last_used":
+		less = func(a, b ModelUsage) bool { return a.LastUsed.After(b.LastUsed) }
+	default:
+		return UsageReport{}, fmt.Errorf("invalid sort '%s', must be one of: requests, tokens, last_used", sortBy)
+	}
+
+	sort.Slice(report.Models, func(i, j int) bool {
+		a, b := report.Models[i], report.Models[j]
+		if less(a, b) {
+			return true
+		}
+		if less(b, a) {
+			return false
+		}
+		return a.Model < b.Model
+	})
+	return report, nil
+}
+
+// apiGetUsage summarizes requests and tokens per model over ?window=, a
+// duration like 24h, sorted by ?sort=, for one ?tenant= or all requests
+func (pm *ProxyManager) apiGetUsage(c *gin.Context) {
+	var window time.Duration
+	if value := c.Query("window"); value != "" {
+		var err error
+		if window, err = time.ParseDuration(value); err != nil || window < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid window '%s', expected a duration like 24h", value)})
+			return
+		}
+	}
+
+	tenant := c.Query("tenant")
+	if _, found := pm.config.Tenants[tenant]; tenant != "" && !found {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown tenant '%s'", tenant)})
+		return
+	}
+
+	report, err := pm.usageReport(window, c.Query("sort"), tenant)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,120 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProxyManager_APIGetUsage(t *testing.T) {
+	model1 := getTestSimpleResponderConfig("model1")
+	model1.UnloadAfter = 300
+	conf := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]config.ModelConfig{
+			"model1": model1,
+			"model2": getTestSimpleResponderConfig("model2"),
+			"unused": getTestSimpleResponderConfig("unused"),
+		},
+		LogLevel: "error",
+	})
+
+	proxy := New(conf)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	now := time.Now()
+	proxy.metricsMonitor.addMetrics(TokenMetrics{Timestamp: now.Add(-48 * time.Hour), Model: "model2", InputTokens: 1000, OutputTokens: 1000})
+	proxy.metricsMonitor.addMetrics(TokenMetrics{Timestamp: now.Add(-2 * time.Hour), Model: "model1", InputTokens: 10, OutputTokens: 5})
+	proxy.metricsMonitor.addMetrics(TokenMetrics{Timestamp: now.Add(-time.Hour), Model: "model1", InputTokens: 20, OutputTokens: 5})
+	proxy.metricsMonitor.addMetrics(TokenMetrics{Timestamp: now.Add(-30 * time.Minute), Model: "model2", InputTokens: 100, OutputTokens: 50})
+
+	getUsage := func(query string) (int, UsageReport) {
+		req := httptest.NewRequest("GET", "/api/usage"+query, nil)
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		var report UsageReport
+		json.Unmarshal(w.Body.Bytes(), &report)
+		return w.Code, report
+	}
+
+	code, report := getUsage("?window=24h")
+	assert.Equal(t, http.StatusOK, code)
+	assert.WithinDuration(t, now.Add(-48*time.Hour), report.Oldest, time.Second)
+	if assert.Len(t, report.Models, 3) {
+		assert.Equal(t, "model1", report.Models[0].Model)
+		assert.Equal(t, 2, report.Models[0].Requests)
+		assert.Equal(t, 30, report.Models[0].InputTokens)
+		assert.Equal(t, 10, report.Models[0].OutputTokens)
+		assert.Equal(t, 300, report.Models[0].TTL)
+		assert.Equal(t, StateStopped, report.Models[0].State)
+		assert.WithinDuration(t, now.Add(-time.Hour), report.Models[0].LastUsed, time.Second)
+
+		assert.Equal(t, "model2", report.Models[1].Model)
+		assert.Equal(t, 1, report.Models[1].Requests)
+
+		assert.Equal(t, "unused", report.Models[2].Model)
+		assert.Equal(t, 0, report.Models[2].Requests)
+		assert.True(t, report.Models[2].LastUsed.IsZero())
+	}
+
+	_, report = getUsage("?sort=tokens")
+	assert.Equal(t, []string{"model2", "model1", "unused"}, []string{report.Models[0].Model, report.Models[1].Model, report.Models[2].Model})
+	assert.Equal(t, 2150, report.Models[0].InputTokens+report.Models[0].OutputTokens)
+
+	_, report = getUsage("?window=24h&sort=last_used")
+	assert.Equal(t, []string{"model2", "model1", "unused"}, []string{report.Models[0].Model, report.Models[1].Model, report.Models[2].Model})
+
+	code, _ = getUsage("?window=yesterday")
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = getUsage("?sort=name")
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestProxyManager_UsageCost(t *testing.T) {
+	model1 := getTestSimpleResponderConfig("model1")
+	model1.Pricing = config.PricingConfig{InputPer1M: 0.1, OutputPer1M: 0.4}
+	conf := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]config.ModelConfig{
+			"model1": model1,
+			"model2": getTestSimpleResponderConfig("model2"),
+		},
+		LogLevel: "error",
+	})
+
+	proxy := New(conf)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	now := time.Now()
+	yesterday := now.AddDate(0, 0, -1)
+	proxy.metricsMonitor.addMetrics(TokenMetrics{Timestamp: yesterday, Model: "model1", InputTokens: 1_000_000, OutputTokens: 500_000})
+	proxy.metricsMonitor.addMetrics(TokenMetrics{Timestamp: now, Model: "model1", InputTokens: 2_000_000})
+	proxy.metricsMonitor.addMetrics(TokenMetrics{Timestamp: now, Model: "model2", InputTokens: 1_000_000})
+
+	metrics := proxy.metricsMonitor.getMetrics()
+	assert.InDelta(t, 0.3, metrics[0].Cost, 1e-9)
+	assert.InDelta(t, 0.2, metrics[1].Cost, 1e-9)
+	assert.Zero(t, metrics[2].Cost)
+
+	report, err := proxy.usageReport(0, "", "")
+	assert.NoError(t, err)
+	if assert.Len(t, report.Models, 2) {
+		assert.Equal(t, "model1", report.Models[0].Model)
+		assert.InDelta(t, 0.5, report.Models[0].Cost, 1e-9)
+		assert.Zero(t, report.Models[1].Cost)
+	}
+	if assert.Len(t, report.Days, 2) {
+		assert.Equal(t, yesterday.Format(time.DateOnly), report.Days[0].Date)
+		assert.Equal(t, 1, report.Days[0].Requests)
+		assert.InDelta(t, 0.3, report.Days[0].Cost, 1e-9)
+		assert.Equal(t, now.Format(time.DateOnly), report.Days[1].Date)
+		assert.Equal(t, 2, report.Days[1].Requests)
+		assert.InDelta(t, 0.2, report.Days[1].Cost, 1e-9)
+		assert.InDelta(t, 0.5, report.Days[1].CumulativeCost, 1e-9)
+	}
+}
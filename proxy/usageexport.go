@@ -0,0 +1,221 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/napmany/llmsnap/event"
+	"github.com/napmany/llmsnap/proxy/config"
+)
+
+// UsageExportReport is the anonymized usage of one interval, written by the
+// usageExport exporter
+type UsageExportReport struct {
+	Instance string             `json:"instance,omitempty"`
+	Start    time.Time          `json:"start"`
+	End      time.Time          `json:"end"`
+	Models   []ModelUsageExport `json:"models"`
+}
+
+// ModelUsageExport sums the requests of a model in a report
+type ModelUsageExport struct {
+	// Model is the model ID or its hash with hashModels
+	Model        string `json:"model"`
+	Requests     int    `json:"requests"`
+	InputTokens  int    `json:"input_tokens"`
+	OutputTokens int    `json:"output_tokens"`
+	CachedTokens int    `json:"cache_tokens"`
+
+	// DurationMs are percentiles of the request durations
+	DurationMs LatencyPercentiles `json:"duration_ms"`
+}
+
+// LatencyPercentiles of a set of durations in milliseconds
+type LatencyPercentiles struct {
+	P50 int `json:"p50"`
+	P90 int `json:"p90"`
+	P99 int `json:"p99"`
+	Max int `json:"max"`
+}
+
+type usageExportBucket struct {
+	requests     int
+	inputTokens  int
+	outputTokens int
+	cachedTokens int
+	durations    []int
+}
+
+// usageExporter sums completed requests by model and writes a report every
+// interval
+type usageExporter struct {
+	conf   config.UsageExportConfig
+	client *http.Client
+	logger *LogMonitor
+
+	mu      sync.Mutex
+	start   time.Time
+	buckets map[string]*usageExportBucket
+}
+
+func newUsageExporter(conf config.UsageExportConfig, logger *LogMonitor) *usageExporter {
+	return &usageExporter{
+		conf:    conf,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		logger:  logger,
+		start:   time.Now(),
+		buckets: make(map[string]*usageExportBucket),
+	}
+}
+
+// record adds a completed request to the current interval
+func (e *usageExporter) record(metrics TokenMetrics) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	bucket, found := e.buckets[metrics.Model]
+	if !found {
+		bucket = &usageExportBucket{}
+		e.buckets[metrics.Model] = bucket
+	}
+	bucket.requests++
+	bucket.inputTokens += metrics.InputTokens
+	bucket.outputTokens += metrics.OutputTokens
+	bucket.cachedTokens += max(metrics.CachedTokens, 0)
+	bucket.durations = append(bucket.durations, metrics.DurationMs)
+}
+
+// flush returns the report of the current interval and starts the next one
+func (e *usageExporter) flush(now time.Time) UsageExportReport {
+	e.mu.Lock()
+	buckets, start := e.buckets, e.start
+	e.buckets, e.start = make(map[string]*usageExportBucket), now
+	e.mu.Unlock()
+
+	report := UsageExportReport{
+		Instance: e.conf.Instance,
+		Start:    start,
+		End:      now,
+		Models:   []ModelUsageExport{},
+	}
+	for modelID, bucket := range buckets {
+		report.Models = append(report.Models, ModelUsageExport{
+			Model:        e.modelName(modelID),
+			Requests:     bucket.requests,
+			InputTokens:  bucket.inputTokens,
+			OutputTokens: bucket.outputTokens,
+			CachedTokens: bucket.cachedTokens,
+			DurationMs:   latencyPercentiles(bucket.durations),
+		})
+	}
+	sort.Slice(report.Models, func(i, j int) bool { return report.Models[i].Model < report.Models[j].Model })
+	return report
+}
+
+// modelName returns the name of modelID in reports
+func (e *usageExporter) modelName(modelID string) string {
+	if !e.conf.HashModels {
+		return modelID
+	}
+	sum := sha256.Sum256([]byte(e.conf.Salt + modelID))
+	return hex.EncodeToString(sum[:8])
+}
+
+// latencyPercentiles uses the nearest rank of the sorted durations
+func latencyPercentiles(durations []int) LatencyPercentiles {
+	if len(durations) == 0 {
+		return LatencyPercentiles{}
+	}
+	sorted := slices.Clone(durations)
+	slices.Sort(sorted)
+	rank := func(p int) int {
+		return sorted[max((p*len(sorted)+99)/100-1, 0)]
+	}
+	return LatencyPercentiles{P50: rank(50), P90: rank(90), P99: rank(99), Max: sorted[len(sorted)-1]}
+}
+
+// run records completed requests and exports a report every interval until
+// ctx is done, the requests since the last report are exported then
+func (e *usageExporter) run(ctx context.Context) {
+	cancel := event.On(func(ev TokenMetricsEvent) {
+		e.record(ev.Metrics)
+	})
+	defer cancel()
+
+	ticker := time.NewTicker(e.conf.IntervalDuration())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			exportCtx, cancelExport := context.WithTimeout(context.Background(), 10*time.Second)
+			e.export(exportCtx, e.flush(time.Now()))
+			cancelExport()
+			return
+		case now := <-ticker.C:
+			e.export(ctx, e.flush(now))
+		}
+	}
+}
+
+// export writes report to the configured file and URL
+func (e *usageExporter) export(ctx context.Context, report UsageExportReport) {
+	line, err := json.Marshal(report)
+	if err != nil {
+		e.logger.Warnf("usage export failed: %v", err)
+		return
+	}
+
+	if e.conf.File != "" {
+		if err := appendLine(e.conf.File, line); err != nil {
+			e.logger.Warnf("usage export to %s failed: %v", e.conf.File, err)
+		}
+	}
+	if e.conf.URL != "" {
+		if err := e.post(ctx, line); err != nil {
+			e.logger.Warnf("usage export to %s failed: %v", e.conf.URL, err)
+		}
+	}
+}
+
+func appendLine(path string, line []byte) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
+
+func (e *usageExporter) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", e.conf.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range e.conf.Headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLatencyPercentiles(t *testing.T) {
+	assert.Equal(t, LatencyPercentiles{}, latencyPercentiles(nil))
+	assert.Equal(t, LatencyPercentiles{P50: 7, P90: 7, P99: 7, Max: 7}, latencyPercentiles([]int{7}))
+
+	durations := make([]int, 0, 100)
+	for i := 100; i >= 1; i-- {
+		durations = append(durations, i*10)
+	}
+	assert.Equal(t, LatencyPercentiles{P50: 500, P90: 900, P99: 990, Max: 1000}, latencyPercentiles(durations))
+	assert.Equal(t, 1000, durations[0], "durations are not sorted in place")
+}
+
+func TestUsageExporter_Flush(t *testing.T) {
+	exporter := newUsageExporter(config.UsageExportConfig{File: "usage.jsonl", Instance: "gpu-01"}, testLogger)
+	start := exporter.start
+
+	exporter.record(TokenMetrics{Model: "llama", InputTokens: 10, OutputTokens: 5, CachedTokens: 4, DurationMs: 100, Client: "alice", Tenant: "research"})
+	exporter.record(TokenMetrics{Model: "llama", InputTokens: 20, OutputTokens: 15, CachedTokens: -1, DurationMs: 300})
+	exporter.record(TokenMetrics{Model: "embed", InputTokens: 8, CachedTokens: -1, DurationMs: 20})
+
+	end := start.Add(time.Hour)
+	report := exporter.flush(end)
+	assert.Equal(t, "gpu-01", report.Instance)
+	assert.Equal(t, start, report.Start)
+	assert.Equal(t, end, report.End)
+	assert.Equal(t, []ModelUsageExport{
+		{Model: "embed", Requests: 1, InputTokens: 8, DurationMs: LatencyPercentiles{P50: 20, P90: 20, P99: 20, Max: 20}},
+		{Model: "llama", Requests: 2, InputTokens: 30, OutputTokens: 20, CachedTokens: 4, DurationMs: LatencyPercentiles{P50: 100, P90: 300, P99: 300, Max: 300}},
+	}, report.Models)
+
+	// clients and tenants are never exported
+	line, err := json.Marshal(report)
+	require.NoError(t, err)
+	assert.NotContains(t, string(line), "alice")
+	assert.NotContains(t, string(line), "research")
+
+	// the next interval starts empty
+	report = exporter.flush(end.Add(time.Hour))
+	assert.Equal(t, end, report.Start)
+	assert.Empty(t, report.Models)
+}
+
+func TestUsageExporter_HashModels(t *testing.T) {
+	plain := newUsageExporter(config.UsageExportConfig{HashModels: true}, testLogger)
+	salted := newUsageExporter(config.UsageExportConfig{HashModels: true, Salt: "s3cret"}, testLogger)
+
+	assert.Len(t, plain.modelName("llama"), 16)
+	assert.NotEqual(t, "llama", plain.modelName("llama"))
+	assert.Equal(t, plain.modelName("llama"), plain.modelName("llama"))
+	assert.NotEqual(t, plain.modelName("llama"), plain.modelName("qwen"))
+	assert.NotEqual(t, plain.modelName("llama"), salted.modelName("llama"))
+}
+
+func TestUsageExporter_Export(t *testing.T) {
+	var posted []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		posted = append(posted, string(body))
+	}))
+	defer server.Close()
+
+	file := filepath.Join(t.TempDir(), "usage.jsonl")
+	exporter := newUsageExporter(config.UsageExportConfig{
+		File:    file,
+		URL:     server.URL,
+		Headers: map[string]string{"Authorization": "Bearer token"},
+	}, testLogger)
+
+	exporter.record(TokenMetrics{Model: "llama", InputTokens: 10, DurationMs: 100})
+	exporter.export(context.Background(), exporter.flush(time.Now()))
+	exporter.export(context.Background(), exporter.flush(time.Now()))
+
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 2)
+
+	var report UsageExportReport
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &report))
+	require.Len(t, report.Models, 1)
+	assert.Equal(t, 10, report.Models[0].InputTokens)
+
+	require.Len(t, posted, 2)
+	assert.Equal(t, lines[0], posted[0])
+}
@@ -0,0 +1,596 @@
+package proxy
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/tidwall/gjson"
+)
+
+// the parts of the proxy-wasm ABI llmsnap uses
+const (
+	wasmBufferRequestBody         = 0
+	wasmBufferResponseBody        = 1
+	wasmBufferPluginConfiguration = 7
+
+	wasmStatusOK            = 0
+	wasmStatusNotFound      = 1
+	wasmStatusBadArgument   = 2
+	wasmStatusUnimplemented = 12
+
+	wasmActionPause = 1
+
+	// the root context every instance is configured with, the contexts of
+	// calls count up from it
+	wasmRootContextID = 1
+)
+
+// wasmMemoryLimitPages caps the memory of an instance at 128 MiB
+const wasmMemoryLimitPages = 2048
+
+// wasmIdleInstances is how many instances a filter keeps between calls,
+// more concurrent calls start instances that are closed when they are done
+const wasmIdleInstances = 4
+
+var errWasmFilterClosed = errors.New("the filter is closed")
+
+// wasmFilter runs a model's WASM filter, see config.WasmFilterConfig. An
+// instance of the module runs one call at a time so calls take an idle
+// one, each instance is started and configured once when it is created.
+// Filters only reach llmsnap through the proxy-wasm calls, they get WASI
+// without files, environment or arguments.
+//
+// Users of the filter hold it with acquire, close frees the runtime and
+// every instance once the last user released it.
+type wasmFilter struct {
+	file    string
+	conf    []byte
+	timeout time.Duration
+	logger  *LogMonitor
+
+	// compiled in the background, see load
+	loadOnce sync.Once
+	loadErr  error
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+
+	idle      chan *wasmInstance
+	contextID atomic.Uint32
+
+	mu     sync.Mutex
+	users  int
+	closed bool
+}
+
+func newWasmFilter(conf config.WasmFilterConfig, logger *LogMonitor) (*wasmFilter, error) {
+	pluginConf, err := conf.Configuration()
+	if err != nil {
+		return nil, err
+	}
+	f := &wasmFilter{
+		file:    conf.File,
+		conf:    pluginConf,
+		timeout: conf.TimeoutDuration(),
+		logger:  logger,
+		idle:    make(chan *wasmInstance, wasmIdleInstances),
+	}
+	f.contextID.Store(wasmRootContextID)
+	return f, nil
+}
+
+// load compiles the module once, compiling takes a while for large modules
+// so it is started in the background when the config is loaded
+func (f *wasmFilter) load() error {
+	f.loadOnce.Do(func() {
+		f.loadErr = f.compile(context.Background())
+		if f.loadErr != nil {
+			f.logger.Errorf("wasm filter %s: %v", f.file, f.loadErr)
+		}
+	})
+	return f.loadErr
+}
+
+// acquire holds the filter open until release, it is false when the filter
+// was closed
+func (f *wasmFilter) acquire() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return false
+	}
+	f.users++
+	return true
+}
+
+func (f *wasmFilter) release() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.users--
+	if f.closed && f.users == 0 {
+		f.free()
+	}
+}
+
+// close frees the filter now or when its last user releases it
+func (f *wasmFilter) close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return
+	}
+	f.closed = true
+	if f.users == 0 {
+		f.free()
+	}
+}
+
+// free closes the runtime, which closes the instances made in it. A load
+// that has not run yet never will.
+func (f *wasmFilter) free() {
+	f.loadOnce.Do(func() { f.loadErr = errWasmFilterClosed })
+	if f.runtime != nil {
+		f.runtime.Close(context.Background())
+	}
+	for {
+		select {
+		case <-f.idle:
+		default:
+			return
+		}
+	}
+}
+
+func (f *wasmFilter) compile(ctx context.Context) error {
+	wasm, err := os.ReadFile(f.file)
+	if err != nil {
+		return err
+	}
+
+	f.runtime = wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(wasmMemoryLimitPages).
+		WithCloseOnContextDone(true))
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, f.runtime); err != nil {
+		return err
+	}
+	if f.compiled, err = f.runtime.CompileModule(ctx, wasm); err != nil {
+		return err
+	}
+
+	// the calls llmsnap does not implement tell the filter so
+	env := f.runtime.NewHostModuleBuilder("env")
+	hostFunctions := map[string]api.GoModuleFunc{
+		"proxy_log":                 f.proxyLog,
+		"proxy_get_buffer_bytes":    f.proxyGetBufferBytes,
+		"proxy_set_buffer_bytes":    f.proxySetBufferBytes,
+		"proxy_send_local_response": f.proxySendLocalResponse,
+	}
+	for _, imported := range f.compiled.ImportedFunctions() {
+		module, name, _ := imported.Import()
+		if module != "env" {
+			continue
+		}
+		fn := hostFunctions[name]
+		if fn == nil {
+			fn = unimplementedWasmCall(len(imported.ResultTypes()))
+		}
+		env.NewFunctionBuilder().
+			WithGoModuleFunction(fn, imported.ParamTypes(), imported.ResultTypes()).
+			Export(name)
+	}
+	_, err = env.Instantiate(ctx)
+	return err
+}
+
+func unimplementedWasmCall(results int) api.GoModuleFunc {
+	return func(ctx context.Context, mod api.Module, stack []uint64) {
+		if results > 0 {
+			stack[0] = wasmStatusUnimplemented
+		}
+	}
+}
+
+// wasmCall is what the host functions work on during a call of the filter
+type wasmCall struct {
+	buffers map[uint32][]byte
+	local   *wasmLocalResponse
+}
+
+type wasmCallKey struct{}
+
+func currentWasmCall(ctx context.Context) *wasmCall {
+	call, _ := ctx.Value(wasmCallKey{}).(*wasmCall)
+	return call
+}
+
+// wasmLocalResponse is the answer of a filter that sent its own response to
+// a request, it is not sent upstream
+type wasmLocalResponse struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+func (l *wasmLocalResponse) Error() string {
+	return fmt.Sprintf("a wasm filter answered the request with status %d", l.status)
+}
+
+func (l *wasmLocalResponse) write(w http.ResponseWriter) {
+	for key, values := range l.header {
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+	if w.Header().Get("Content-Type") == "" && gjson.ValidBytes(l.body) {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.WriteHeader(l.status)
+	w.Write(l.body)
+}
+
+// wasmInstance is a started and configured instance of the module
+type wasmInstance struct {
+	module api.Module
+}
+
+func (f *wasmFilter) newInstance() (*wasmInstance, error) {
+	if err := f.load(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), f.timeout)
+	defer cancel()
+	ctx = context.WithValue(ctx, wasmCallKey{}, &wasmCall{buffers: map[uint32][]byte{wasmBufferPluginConfiguration: f.conf}})
+
+	module, err := f.runtime.InstantiateModule(ctx, f.compiled, wazero.NewModuleConfig().
+		WithName("").
+		WithStartFunctions("_initialize", "_start"))
+	if err != nil {
+		return nil, err
+	}
+	instance := &wasmInstance{module: module}
+
+	if _, err := instance.call(ctx, "proxy_on_context_create", wasmRootContextID, 0); err != nil {
+		module.Close(context.Background())
+		return nil, err
+	}
+	if ok, err := instance.call(ctx, "proxy_on_vm_start", wasmRootContextID, 0); err != nil || (ok != nil && *ok == 0) {
+		module.Close(context.Background())
+		return nil, errors.Join(errors.New("the filter failed to start"), err)
+	}
+	if ok, err := instance.call(ctx, "proxy_on_configure", wasmRootContextID, uint64(len(f.conf))); err != nil || (ok != nil && *ok == 0) {
+		module.Close(context.Background())
+		return nil, errors.Join(errors.New("the filter rejected its configuration"), err)
+	}
+	return instance, nil
+}
+
+// call runs an export of the module when it has it, the result is nil for
+// exports that are missing or return nothing
+func (i *wasmInstance) call(ctx context.Context, name string, params ...uint64) (*uint64, error) {
+	fn := i.module.ExportedFunction(name)
+	if fn == nil {
+		return nil, nil
+	}
+	results, err := fn.Call(ctx, params...)
+	if err != nil || len(results) == 0 {
+		return nil, err
+	}
+	return &results[0], nil
+}
+
+// filter passes body to the filter in a new context. It returns the body
+// the filter left in the buffer, pause is true when the filter asked to
+// wait for more of the body, which llmsnap does not send. The caller holds
+// the filter, see acquire.
+func (f *wasmFilter) filter(bufferType uint32, body []byte, endOfStream bool) (result []byte, pause bool, local *wasmLocalResponse, err error) {
+	var instance *wasmInstance
+	select {
+	case instance = <-f.idle:
+	default:
+		if instance, err = f.newInstance(); err != nil {
+			return nil, false, nil, err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), f.timeout)
+	defer cancel()
+	call := &wasmCall{buffers: map[uint32][]byte{bufferType: body}}
+	ctx = context.WithValue(ctx, wasmCallKey{}, call)
+
+	name := "proxy_on_request_body"
+	if bufferType == wasmBufferResponseBody {
+		name = "proxy_on_response_body"
+	}
+	id := uint64(f.contextID.Add(1))
+	end := uint64(0)
+	if endOfStream {
+		end = 1
+	}
+
+	action, err := instance.call(ctx, "proxy_on_context_create", id, wasmRootContextID)
+	if err == nil {
+		action, err = instance.call(ctx, name, id, uint64(len(body)), end)
+	}
+	if err == nil {
+		_, err = instance.call(ctx, "proxy_on_done", id)
+	}
+	if err == nil {
+		_, err = instance.call(ctx, "proxy_on_delete", id)
+	}
+	if err != nil {
+		// a call that failed or ran out of time may have left the instance
+		// half way
+		instance.module.Close(context.Background())
+		return nil, false, nil, err
+	}
+	select {
+	case f.idle <- instance:
+	default:
+		instance.module.Close(context.Background())
+	}
+
+	return call.buffers[bufferType], action != nil && *action == wasmActionPause, call.local, nil
+}
+
+// readWasmMemory reads size bytes at ptr of the module's memory
+func readWasmMemory(mod api.Module, ptr, size uint32) ([]byte, bool) {
+	if size == 0 {
+		return nil, true
+	}
+	data, ok := mod.Memory().Read(ptr, size)
+	if !ok {
+		return nil, false
+	}
+	return append([]byte(nil), data...), true
+}
+
+// proxy_log(level, message_data, message_size) -> status
+func (f *wasmFilter) proxyLog(ctx context.Context, mod api.Module, stack []uint64) {
+	message, ok := readWasmMemory(mod, api.DecodeU32(stack[1]), api.DecodeU32(stack[2]))
+	if !ok {
+		stack[0] = wasmStatusBadArgument
+		return
+	}
+	switch level := api.DecodeU32(stack[0]); {
+	case level <= 1:
+		f.logger.Debugf("wasm filter %s: %s", f.file, message)
+	case level == 2:
+		f.logger.Infof("wasm filter %s: %s", f.file, message)
+	case level == 3:
+		f.logger.Warnf("wasm filter %s: %s", f.file, message)
+	default:
+		f.logger.Errorf("wasm filter %s: %s", f.file, message)
+	}
+	stack[0] = wasmStatusOK
+}
+
+// proxy_get_buffer_bytes(buffer_type, start, max_size, return_buffer_data,
+// return_buffer_size) -> status, the bytes are copied into memory the
+// module allocates with proxy_on_memory_allocate or malloc
+func (f *wasmFilter) proxyGetBufferBytes(ctx context.Context, mod api.Module, stack []uint64) {
+	call := currentWasmCall(ctx)
+	if call == nil {
+		stack[0] = wasmStatusNotFound
+		return
+	}
+	buffer, found := call.buffers[api.DecodeU32(stack[0])]
+	if !found {
+		stack[0] = wasmStatusNotFound
+		return
+	}
+	start, maxSize := api.DecodeU32(stack[1]), api.DecodeU32(stack[2])
+	if int(start) > len(buffer) {
+		stack[0] = wasmStatusBadArgument
+		return
+	}
+	data := buffer[start:]
+	if uint32(len(data)) > maxSize {
+		data = data[:maxSize]
+	}
+
+	ptr := uint32(0)
+	if len(data) > 0 {
+		allocate := mod.ExportedFunction("proxy_on_memory_allocate")
+		if allocate == nil {
+			allocate = mod.ExportedFunction("malloc")
+		}
+		if allocate == nil {
+			stack[0] = wasmStatusUnimplemented
+			return
+		}
+		results, err := allocate.Call(ctx, uint64(len(data)))
+		if err != nil || len(results) == 0 {
+			stack[0] = wasmStatusBadArgument
+			return
+		}
+		ptr = api.DecodeU32(results[0])
+		if !mod.Memory().Write(ptr, data) {
+			stack[0] = wasmStatusBadArgument
+			return
+		}
+	}
+
+	memory := mod.Memory()
+	if !memory.WriteUint32Le(api.DecodeU32(stack[3]), ptr) || !memory.WriteUint32Le(api.DecodeU32(stack[4]), uint32(len(data))) {
+		stack[0] = wasmStatusBadArgument
+		return
+	}
+	stack[0] = wasmStatusOK
+}
+
+// proxy_set_buffer_bytes(buffer_type, start, size, buffer_data,
+// buffer_size) -> status, replaces size bytes at start with the data
+func (f *wasmFilter) proxySetBufferBytes(ctx context.Context, mod api.Module, stack []uint64) {
+	call := currentWasmCall(ctx)
+	if call == nil {
+		stack[0] = wasmStatusNotFound
+		return
+	}
+	bufferType := api.DecodeU32(stack[0])
+	buffer, found := call.buffers[bufferType]
+	if !found || bufferType == wasmBufferPluginConfiguration {
+		stack[0] = wasmStatusNotFound
+		return
+	}
+	data, ok := readWasmMemory(mod, api.DecodeU32(stack[3]), api.DecodeU32(stack[4]))
+	if !ok {
+		stack[0] = wasmStatusBadArgument
+		return
+	}
+
+	start, size := int(api.DecodeU32(stack[1])), int(api.DecodeU32(stack[2]))
+	if start > len(buffer) {
+		stack[0] = wasmStatusBadArgument
+		return
+	}
+	end := min(start+size, len(buffer))
+	replaced := make([]byte, 0, start+len(data)+len(buffer)-end)
+	replaced = append(replaced, buffer[:start]...)
+	replaced = append(replaced, data...)
+	replaced = append(replaced, buffer[end:]...)
+	call.buffers[bufferType] = replaced
+	stack[0] = wasmStatusOK
+}
+
+// proxy_send_local_response(status_code, status_code_details_data,
+// status_code_details_size, body_data, body_size, headers_data,
+// headers_size, grpc_status) -> status
+func (f *wasmFilter) proxySendLocalResponse(ctx context.Context, mod api.Module, stack []uint64) {
+	call := currentWasmCall(ctx)
+	if call == nil || call.buffers[wasmBufferRequestBody] == nil {
+		// the response is already on its way
+		stack[0] = wasmStatusBadArgument
+		return
+	}
+	body, ok := readWasmMemory(mod, api.DecodeU32(stack[3]), api.DecodeU32(stack[4]))
+	if !ok {
+		stack[0] = wasmStatusBadArgument
+		return
+	}
+	headers, ok := readWasmMemory(mod, api.DecodeU32(stack[5]), api.DecodeU32(stack[6]))
+	if !ok {
+		stack[0] = wasmStatusBadArgument
+		return
+	}
+	header, ok := decodeWasmHeaders(headers)
+	if !ok {
+		stack[0] = wasmStatusBadArgument
+		return
+	}
+
+	status := int(api.DecodeU32(stack[0]))
+	if status < 100 || status > 599 {
+		stack[0] = wasmStatusBadArgument
+		return
+	}
+	call.local = &wasmLocalResponse{status: status, header: header, body: body}
+	stack[0] = wasmStatusOK
+}
+
+// decodeWasmHeaders decodes a proxy-wasm header map: the number of pairs,
+// the sizes of each name and value, then each name and value ending with a
+// zero byte
+func decodeWasmHeaders(data []byte) (http.Header, bool) {
+	header := make(http.Header)
+	if len(data) == 0 {
+		return header, true
+	}
+	if len(data) < 4 {
+		return nil, false
+	}
+	pairs := int(binary.LittleEndian.Uint32(data))
+	sizes := data[4:]
+	if pairs < 0 || len(sizes) < pairs*8 {
+		return nil, false
+	}
+	rest := sizes[pairs*8:]
+	for i := 0; i < pairs; i++ {
+		nameSize := int(binary.LittleEndian.Uint32(sizes[i*8:]))
+		valueSize := int(binary.LittleEndian.Uint32(sizes[i*8+4:]))
+		if len(rest) < nameSize+valueSize+2 {
+			return nil, false
+		}
+		name := string(rest[:nameSize])
+		value := string(rest[nameSize+1 : nameSize+1+valueSize])
+		rest = rest[nameSize+valueSize+2:]
+		header.Add(name, value)
+	}
+	return header, true
+}
+
+// wasmFilterChain runs a model's filters, requests pass them in order
+type wasmFilterChain []*wasmFilter
+
+// close closes the filters, see wasmFilter.close
+func (chain wasmFilterChain) close() {
+	for _, f := range chain {
+		f.close()
+	}
+}
+
+// request passes the request body through the filters, a filter that sends
+// its own response returns it as a *wasmLocalResponse error
+func (chain wasmFilterChain) request(body []byte) ([]byte, error) {
+	if !gjson.ValidBytes(body) {
+		return body, nil
+	}
+	for _, f := range chain {
+		if !f.acquire() {
+			return nil, fmt.Errorf("wasm filter %s failed: %v", f.file, errWasmFilterClosed)
+		}
+		result, _, local, err := f.filter(wasmBufferRequestBody, body, true)
+		f.release()
+		if err != nil {
+			return nil, fmt.Errorf("wasm filter %s failed: %v", f.file, err)
+		}
+		if local != nil {
+			return nil, local
+		}
+		body = result
+	}
+	return body, nil
+}
+
+// wasmResponseFilter passes responses through a filter, its user holds the
+// filter for the whole response
+type wasmResponseFilter struct {
+	filter *wasmFilter
+	id     string
+}
+
+func (rf *wasmResponseFilter) transformBody(body []byte) []byte {
+	if !gjson.ValidBytes(body) {
+		return body
+	}
+	result, _, _, err := rf.filter.filter(wasmBufferResponseBody, body, true)
+	if err != nil {
+		rf.filter.logger.Warnf("<%s> wasm filter %s failed, the response is sent as it is: %v", rf.id, rf.filter.file, err)
+		return body
+	}
+	return result
+}
+
+// transformEvent passes each event as a body of its own, a filter that
+// pauses on an event drops it
+func (rf *wasmResponseFilter) transformEvent(payload []byte) [][]byte {
+	result, pause, _, err := rf.filter.filter(wasmBufferResponseBody, payload, false)
+	if err != nil {
+		rf.filter.logger.Warnf("<%s> wasm filter %s failed, the event is sent as it is: %v", rf.id, rf.filter.file, err)
+		return [][]byte{payload}
+	}
+	if pause {
+		return nil
+	}
+	return [][]byte{result}
+}
@@ -0,0 +1,191 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+var (
+	wasmFilterOnce sync.Once
+	wasmFilterPath string
+	wasmFilterErr  error
+)
+
+// getWasmFilterPath builds cmd/wasm-filter once for the tests
+func getWasmFilterPath(t *testing.T) string {
+	t.Helper()
+	wasmFilterOnce.Do(func() {
+		dir, err := os.MkdirTemp("", "wasm-filter")
+		if err != nil {
+			wasmFilterErr = err
+			return
+		}
+		wasmFilterPath = filepath.Join(dir, "filter.wasm")
+		cmd := exec.Command("go", "build", "-buildmode=c-shared", "-o", wasmFilterPath, "../cmd/wasm-filter")
+		cmd.Env = append(os.Environ(), "GOOS=wasip1", "GOARCH=wasm")
+		if output, err := cmd.CombinedOutput(); err != nil {
+			wasmFilterErr = err
+			t.Logf("%s", output)
+		}
+	})
+	if wasmFilterErr != nil {
+		t.Skipf("unable to build the wasm filter: %v", wasmFilterErr)
+	}
+	return wasmFilterPath
+}
+
+func TestWasmFilter(t *testing.T) {
+	file := getWasmFilterPath(t)
+	filter, err := newWasmFilter(config.WasmFilterConfig{
+		File:    file,
+		Config:  map[string]any{"strip": []string{"mirostat"}, "maxTokens": 100, "fingerprint": "wasm"},
+		Timeout: 5000,
+	}, testLogger)
+	require.NoError(t, err)
+	chain := wasmFilterChain{filter}
+
+	body, err := chain.request([]byte(`{"model":"m","mirostat":2,"max_tokens":10}`))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"model":"m","max_tokens":10}`, string(body))
+
+	// the filter answers requests it rejects
+	_, err = chain.request([]byte(`{"model":"m","max_tokens":1000}`))
+	local, ok := err.(*wasmLocalResponse)
+	require.True(t, ok, "expected a local response, got %v", err)
+	w := httptest.NewRecorder()
+	local.write(w)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"error":"max_tokens must be at most 100"}`, w.Body.String())
+
+	rf := &wasmResponseFilter{filter: filter, id: "m"}
+	assert.JSONEq(t, `{"id":"1","system_fingerprint":"wasm"}`, string(rf.transformBody([]byte(`{"id":"1"}`))))
+	events := rf.transformEvent([]byte(`{"choices":[]}`))
+	require.Len(t, events, 1)
+	assert.JSONEq(t, `{"choices":[],"system_fingerprint":"wasm"}`, string(events[0]))
+
+	// calls run concurrently on instances of their own, only a few are kept
+	var wg sync.WaitGroup
+	for i := 0; i < 2*wasmIdleInstances; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			body, err := chain.request([]byte(`{"mirostat":1}`))
+			assert.NoError(t, err)
+			assert.Equal(t, `{}`, string(body))
+		}()
+	}
+	wg.Wait()
+	assert.LessOrEqual(t, len(filter.idle), wasmIdleInstances)
+
+	// a closed filter waits for its users
+	require.True(t, filter.acquire())
+	chain.close()
+	assert.False(t, filter.acquire())
+	assert.JSONEq(t, `{"id":"2","system_fingerprint":"wasm"}`, string(rf.transformBody([]byte(`{"id":"2"}`))))
+	filter.release()
+	assert.Zero(t, len(filter.idle))
+	_, err = chain.request([]byte(`{"model":"m"}`))
+	assert.ErrorContains(t, err, "the filter is closed")
+}
+
+func TestDecodeWasmHeaders(t *testing.T) {
+	data := []byte{
+		2, 0, 0, 0,
+		1, 0, 0, 0, 2, 0, 0, 0,
+		2, 0, 0, 0, 0, 0, 0, 0,
+	}
+	data = append(data, "a\x00bc\x00xy\x00\x00"...)
+	header, ok := decodeWasmHeaders(data)
+	assert.True(t, ok)
+	assert.Equal(t, http.Header{"A": {"bc"}, "Xy": {""}}, header)
+
+	_, ok = decodeWasmHeaders(data[:len(data)-2])
+	assert.False(t, ok)
+	_, ok = decodeWasmHeaders([]byte{1, 0})
+	assert.False(t, ok)
+}
+
+func TestProxyManager_WasmFilters(t *testing.T) {
+	file := getWasmFilterPath(t)
+	modelConfig := getTestSimpleResponderConfig("model1")
+	modelConfig.WasmFilters = []config.WasmFilterConfig{
+		{File: file, Config: map[string]any{"strip": []string{"mirostat"}, "maxTokens": 100}, Timeout: 5000},
+		{File: file, Config: `{"fingerprint":"wasm"}`, Timeout: 5000},
+	}
+	conf := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]config.ModelConfig{
+			"model1": modelConfig,
+		},
+		LogLevel: "error",
+	})
+
+	proxy := New(conf)
+
+	req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model":"model1","mirostat":2}`))
+	w := CreateTestResponseRecorder()
+	proxy.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	response := gjson.Parse(w.Body.String())
+	assert.JSONEq(t, `{"model":"model1"}`, response.Get("request_body").String())
+	assert.Equal(t, "wasm", response.Get("system_fingerprint").String())
+
+	req = httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model":"model1","max_tokens":1000}`))
+	w = CreateTestResponseRecorder()
+	proxy.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "max_tokens must be at most 100")
+
+	// shutting down frees the runtimes
+	proxy.Shutdown()
+	for _, filter := range proxy.wasmFilters["model1"] {
+		assert.False(t, filter.acquire())
+	}
+}
+
+func TestProxyManager_WasmFiltersReload(t *testing.T) {
+	file := getWasmFilterPath(t)
+	modelConfig := getTestSimpleResponderConfig("model1")
+	modelConfig.WasmFilters = []config.WasmFilterConfig{
+		{File: file, Config: `{"fingerprint":"wasm"}`, Timeout: 5000},
+	}
+	conf := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		Models: map[string]config.ModelConfig{
+			"model1": modelConfig,
+		},
+		LogLevel: "error",
+	})
+
+	chat := func(pm *ProxyManager) string {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model":"model1"}`))
+		w := CreateTestResponseRecorder()
+		pm.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		return gjson.Get(w.Body.String(), "system_fingerprint").String()
+	}
+
+	proxy := New(conf)
+	assert.Equal(t, "wasm", chat(proxy))
+	old := proxy.wasmFilters["model1"][0]
+
+	// the kept process uses the filters of the new ProxyManager and the old
+	// runtime is freed
+	next := proxy.Reload(conf)
+	defer next.StopProcesses(StopWaitForInflightRequest)
+	assert.False(t, old.acquire())
+	assert.Equal(t, "wasm", chat(next))
+}
@@ -0,0 +1,119 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/napmany/llmsnap/proxy/config"
+)
+
+// completed requests waiting to be posted, more are dropped
+const webhookQueueSize = 1000
+
+// WebhookPayload is the JSON posted to a model's webhook for every completed
+// request
+type WebhookPayload struct {
+	Event   string          `json:"event"`
+	Metrics TokenMetrics    `json:"metrics"`
+	Capture *ReqRespCapture `json:"capture,omitempty"`
+}
+
+type webhookJob struct {
+	webhook config.WebhookConfig
+	payload WebhookPayload
+}
+
+// webhookTee posts completed requests to the webhooks of their models in the
+// background so clients never wait for it
+type webhookTee struct {
+	webhooks map[string]config.WebhookConfig
+	queue    chan webhookJob
+	client   *http.Client
+	logger   *LogMonitor
+}
+
+// newWebhookTee returns nil when no model has a webhook
+func newWebhookTee(models map[string]config.ModelConfig, logger *LogMonitor) *webhookTee {
+	webhooks := make(map[string]config.WebhookConfig)
+	for modelID, modelConfig := range models {
+		if modelConfig.Webhook.Enabled() {
+			webhooks[modelID] = modelConfig.Webhook
+		}
+	}
+	if len(webhooks) == 0 {
+		return nil
+	}
+	return &webhookTee{
+		webhooks: webhooks,
+		queue:    make(chan webhookJob, webhookQueueSize),
+		client:   &http.Client{},
+		logger:   logger,
+	}
+}
+
+// send queues metrics for the model's webhook. capture is only included when
+// the webhook asks for bodies.
+func (t *webhookTee) send(metrics TokenMetrics, capture *ReqRespCapture) {
+	if t == nil {
+		return
+	}
+	webhook, found := t.webhooks[metrics.Model]
+	if !found {
+		return
+	}
+
+	payload := WebhookPayload{Event: "request.completed", Metrics: metrics}
+	if webhook.Bodies {
+		payload.Capture = capture
+	}
+	select {
+	case t.queue <- webhookJob{webhook: webhook, payload: payload}:
+	default:
+		t.logger.Warnf("<%s> webhook queue is full, dropping request %d", metrics.Model, metrics.ID)
+	}
+}
+
+// run posts queued requests until ctx is done
+func (t *webhookTee) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-t.queue:
+			if err := t.post(ctx, job); err != nil {
+				t.logger.Warnf("<%s> webhook post failed: %v", job.payload.Metrics.Model, err)
+			}
+		}
+	}
+}
+
+func (t *webhookTee) post(ctx context.Context, job webhookJob) error {
+	body, err := json.Marshal(job.payload)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, job.webhook.TimeoutDuration())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", job.webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range job.webhook.Headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
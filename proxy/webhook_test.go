@@ -0,0 +1,91 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProxyManager_Webhook(t *testing.T) {
+	var mu sync.Mutex
+	var payloads []WebhookPayload
+	var auth []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var payload WebhookPayload
+		if json.Unmarshal(body, &payload) == nil {
+			mu.Lock()
+			payloads = append(payloads, payload)
+			auth = append(auth, r.Header.Get("Authorization"))
+			mu.Unlock()
+		}
+	}))
+	defer server.Close()
+
+	model1 := getTestSimpleResponderConfig("model1")
+	model1.Webhook = config.WebhookConfig{
+		URL:     server.URL,
+		Bodies:  true,
+		Headers: map[string]string{"Authorization": "Bearer pipeline"},
+	}
+	conf := config.AddDefaultGroupToConfig(config.Config{
+		HealthCheckTimeout: 15,
+		CaptureBuffer:      5,
+		Models: map[string]config.ModelConfig{
+			"model1": model1,
+			"model2": getTestSimpleResponderConfig("model2"),
+		},
+		LogLevel: "error",
+	})
+
+	proxy := New(conf)
+	defer proxy.StopProcesses(StopWaitForInflightRequest)
+
+	for _, model := range []string{"model2", "model1"} {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", bytes.NewBufferString(`{"model":"`+model+`"}`))
+		w := CreateTestResponseRecorder()
+		proxy.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(payloads) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	payload := payloads[0]
+	assert.Equal(t, "request.completed", payload.Event)
+	assert.Equal(t, "model1", payload.Metrics.Model)
+	assert.Equal(t, 25, payload.Metrics.InputTokens)
+	require.NotNil(t, payload.Capture)
+	assert.Equal(t, payload.Metrics.ID, payload.Capture.ID)
+	assert.Contains(t, string(payload.Capture.ReqBody), `"model":"model1"`)
+	assert.Equal(t, "Bearer pipeline", auth[0])
+}
+
+func TestWebhookTee_SummaryOnly(t *testing.T) {
+	tee := newWebhookTee(map[string]config.ModelConfig{
+		"model1": {Webhook: config.WebhookConfig{URL: "http://127.0.0.1:1"}},
+	}, testLogger)
+	require.NotNil(t, tee)
+
+	tee.send(TokenMetrics{Model: "model1"}, &ReqRespCapture{ReqBody: []byte("secret")})
+	tee.send(TokenMetrics{Model: "model2"}, nil)
+	require.Len(t, tee.queue, 1)
+	job := <-tee.queue
+	assert.Nil(t, job.payload.Capture)
+
+	assert.Nil(t, newWebhookTee(map[string]config.ModelConfig{"model1": {}}, testLogger))
+}
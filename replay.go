@@ -0,0 +1,408 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+type replayOptions struct {
+	url    string
+	model  string
+	apiKey string
+	speed  float64
+}
+
+// replayRecord is a request to replay and what it returned when captured
+type replayRecord struct {
+	line     int
+	path     string
+	body     []byte
+	baseline string
+
+	// zero when the record does not have them
+	start    time.Time
+	duration time.Duration
+}
+
+type replayResult struct {
+	record     replayRecord
+	err        error
+	status     int
+	latency    time.Duration
+	similarity float64
+}
+
+// runReplay implements `llmsnap replay`. It sends captured requests through
+// a running llmsnap again and compares latency and outputs with the
+// recording, e.g. to check a new llama-server build or quant.
+func runReplay(args []string, out io.Writer) error {
+	opts := replayOptions{}
+	var speed string
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	fs.SetOutput(out)
+	fs.Usage = func() {
+		fmt.Fprintln(out, "usage: llmsnap replay [flags] capture.jsonl")
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&opts.url, "url", "http://localhost:8080", "base URL of llmsnap")
+	fs.StringVar(&opts.model, "model", "", "send every request to this model instead of the recorded one")
+	fs.StringVar(&opts.apiKey, "api-key", "", "API key sent as a Bearer token")
+	fs.StringVar(&speed, "speed", "1x", "replay speed relative to the recording, e.g. 2x. 0 sends requests one after another")
+
+	// the capture file may come before the flags
+	var files []string
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	for fs.NArg() > 0 {
+		files = append(files, fs.Arg(0))
+		if err := fs.Parse(fs.Args()[1:]); err != nil {
+			return err
+		}
+	}
+	if len(files) != 1 {
+		return errors.New("one capture file is required")
+	}
+
+	var err error
+	if opts.speed, err = parseReplaySpeed(speed); err != nil {
+		return err
+	}
+	opts.url = strings.TrimSuffix(opts.url, "/")
+
+	file, err := os.Open(files[0])
+	if err != nil {
+		return err
+	}
+	records, err := readReplayRecords(file, opts.model)
+	file.Close()
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		return fmt.Errorf("no requests in %s", files[0])
+	}
+
+	client := &http.Client{}
+	results := make([]replayResult, len(records))
+
+	if paced := opts.speed > 0 && !slices.ContainsFunc(records, func(r replayRecord) bool { return r.start.IsZero() }); paced {
+		// keep the recorded gaps between requests, scaled by speed
+		first := records[0].start
+		begin := time.Now()
+		var wg sync.WaitGroup
+		for i, record := range records {
+			offset := time.Duration(float64(record.start.Sub(first)) / opts.speed)
+			time.Sleep(time.Until(begin.Add(offset)))
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				results[i] = replayRequest(client, opts, record)
+			}()
+		}
+		wg.Wait()
+	} else {
+		for i, record := range records {
+			results[i] = replayRequest(client, opts, record)
+		}
+	}
+
+	writeReplayReport(out, results)
+	return nil
+}
+
+// parseReplaySpeed parses speeds like 2x, 0.5x or 3
+func parseReplaySpeed(s string) (float64, error) {
+	speed, err := strconv.ParseFloat(strings.TrimSuffix(strings.ToLower(strings.TrimSpace(s)), "x"), 64)
+	if err != nil || speed < 0 {
+		return 0, fmt.Errorf("invalid --speed %q, use a value like 2x", s)
+	}
+	return speed, nil
+}
+
+// readReplayRecords reads requests from the JSONL written by GET
+// /api/captures or from a dataset file. model replaces the recorded model
+// when it is set.
+func readReplayRecords(r io.Reader, model string) ([]replayRecord, error) {
+	var records []replayRecord
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		record, err := parseReplayRecord(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+		record.line = lineNum
+
+		if model != "" {
+			if record.body, err = sjson.SetBytes(record.body, "model", model); err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNum, err)
+			}
+		} else if !gjson.GetBytes(record.body, "model").Exists() {
+			return nil, fmt.Errorf("line %d: request has no model, use --model", lineNum)
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+func parseReplayRecord(line []byte) (replayRecord, error) {
+	if !gjson.ValidBytes(line) {
+		return replayRecord{}, errors.New("invalid JSON")
+	}
+	parsed := gjson.ParseBytes(line)
+
+	switch {
+	// a capture, see proxy.CaptureExport
+	case parsed.Get("req_body").Exists():
+		var capture struct {
+			ReqPath    string    `json:"req_path"`
+			ReqBody    []byte    `json:"req_body"`
+			RespBody   []byte    `json:"resp_body"`
+			Timestamp  time.Time `json:"timestamp"`
+			DurationMs int       `json:"duration_ms"`
+		}
+		if err := json.Unmarshal(line, &capture); err != nil {
+			return replayRecord{}, err
+		}
+		record := replayRecord{
+			path:     capture.ReqPath,
+			body:     capture.ReqBody,
+			baseline: replayOutput(capture.RespBody),
+			duration: time.Duration(capture.DurationMs) * time.Millisecond,
+		}
+		if !capture.Timestamp.IsZero() {
+			// metrics are timestamped when the response finished
+			record.start = capture.Timestamp.Add(-record.duration)
+		}
+		return record, nil
+
+	// a chat record of a dataset, the last message is the reply
+	case parsed.Get("messages").IsArray():
+		messages := parsed.Get("messages").Array()
+		if len(messages) < 2 || messages[len(messages)-1].Get("role").String() != "assistant" {
+			return replayRecord{}, errors.New("dataset record does not end with an assistant message")
+		}
+		reply := messages[len(messages)-1]
+		history := make([]json.RawMessage, 0, len(messages)-1)
+		for _, message := range messages[:len(messages)-1] {
+			history = append(history, json.RawMessage(message.Raw))
+		}
+		request := map[string]any{"messages": history}
+		if tools := parsed.Get("tools"); tools.IsArray() {
+			request["tools"] = json.RawMessage(tools.Raw)
+		}
+		body, err := json.Marshal(request)
+		if err != nil {
+			return replayRecord{}, err
+		}
+		return replayRecord{
+			path:     "/v1/chat/completions",
+			body:     body,
+			baseline: reply.Get("content").String() + toolCallsText(reply.Get("tool_calls")),
+		}, nil
+
+	// a completion record of a dataset
+	case parsed.Get("prompt").Exists():
+		body, err := json.Marshal(map[string]string{"prompt": parsed.Get("prompt").String()})
+		if err != nil {
+			return replayRecord{}, err
+		}
+		return replayRecord{
+			path:     "/v1/completions",
+			body:     body,
+			baseline: parsed.Get("completion").String(),
+		}, nil
+	}
+
+	return replayRecord{}, errors.New("not a capture or dataset record")
+}
+
+// replayRequest sends a recorded request and compares the output
+func replayRequest(client *http.Client, opts replayOptions, record replayRecord) replayResult {
+	result := replayResult{record: record}
+
+	req, err := http.NewRequest(http.MethodPost, opts.url+record.path, bytes.NewReader(record.body))
+	if err != nil {
+		result.err = err
+		return result
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if opts.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+opts.apiKey)
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		result.err = err
+		return result
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	result.latency = time.Since(start)
+	result.status = resp.StatusCode
+	if err != nil {
+		result.err = err
+		return result
+	}
+	if resp.StatusCode != http.StatusOK {
+		result.err = fmt.Errorf("status %d: %s", resp.StatusCode, strings.TrimSpace(string(body[:min(len(body), 512)])))
+		return result
+	}
+
+	result.similarity = outputSimilarity(record.baseline, replayOutput(body))
+	return result
+}
+
+// replayOutput returns the generated text of a response, streamed or not.
+// Tool calls are included so replies that call tools can be compared too.
+func replayOutput(body []byte) string {
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') {
+		choice := gjson.GetBytes(trimmed, "choices.0")
+		return choice.Get("message.content").String() + choice.Get("text").String() +
+			toolCallsText(choice.Get("message.tool_calls"))
+	}
+
+	var text, calls strings.Builder
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(body)+1)
+	for scanner.Scan() {
+		data, found := bytes.CutPrefix(scanner.Bytes(), []byte("data:"))
+		if !found {
+			continue
+		}
+		choice := gjson.GetBytes(bytes.TrimSpace(data), "choices.0")
+		text.WriteString(choice.Get("delta.content").String())
+		text.WriteString(choice.Get("text").String())
+		for _, call := range choice.Get("delta.tool_calls").Array() {
+			calls.WriteString(call.Get("function.name").String())
+			calls.WriteString(call.Get("function.arguments").String())
+		}
+	}
+	if calls.Len() > 0 {
+		return text.String() + "\n" + calls.String()
+	}
+	return text.String()
+}
+
+func toolCallsText(calls gjson.Result) string {
+	var sb strings.Builder
+	for _, call := range calls.Array() {
+		sb.WriteString(call.Get("function.name").String())
+		sb.WriteString(call.Get("function.arguments").String())
+	}
+	if sb.Len() == 0 {
+		return ""
+	}
+	return "\n" + sb.String()
+}
+
+// outputSimilarity compares two outputs word by word, 1 when they are the
+// same and 0 when they have nothing in common
+func outputSimilarity(a, b string) float64 {
+	if a == b {
+		return 1
+	}
+	wordsA, wordsB := strings.Fields(a), strings.Fields(b)
+	if len(wordsA)+len(wordsB) == 0 {
+		return 1
+	}
+
+	// longest common subsequence of words
+	prev := make([]int, len(wordsB)+1)
+	curr := make([]int, len(wordsB)+1)
+	for i := range wordsA {
+		for j := range wordsB {
+			if wordsA[i] == wordsB[j] {
+				curr[j+1] = prev[j] + 1
+			} else {
+				curr[j+1] = max(prev[j+1], curr[j])
+			}
+		}
+		prev, curr = curr, prev
+	}
+	return 2 * float64(prev[len(wordsB)]) / float64(len(wordsA)+len(wordsB))
+}
+
+func writeReplayReport(out io.Writer, results []replayResult) {
+	var latencies, baselines []time.Duration
+	errorCount, identical := 0, 0
+	similarity := 0.0
+
+	for _, result := range results {
+		prefix := fmt.Sprintf("line %d %s:", result.record.line, result.record.path)
+		if result.err != nil {
+			errorCount++
+			fmt.Fprintf(out, "%s error: %v\n", prefix, result.err)
+			continue
+		}
+
+		latency := result.latency.Round(time.Millisecond).String()
+		if baseline := result.record.duration; baseline > 0 {
+			baselines = append(baselines, baseline)
+			change := 100 * (result.latency.Seconds() - baseline.Seconds()) / baseline.Seconds()
+			latency += fmt.Sprintf(" (baseline %v, %+.1f%%)", baseline.Round(time.Millisecond), change)
+		}
+		fmt.Fprintf(out, "%s %s, output similarity %.2f\n", prefix, latency, result.similarity)
+
+		latencies = append(latencies, result.latency)
+		similarity += result.similarity
+		if result.similarity == 1 {
+			identical++
+		}
+	}
+
+	slices.Sort(latencies)
+	slices.Sort(baselines)
+
+	fmt.Fprintln(out)
+	fmt.Fprintf(out, "requests:       %d (%d errors)\n", len(results), errorCount)
+	fmt.Fprintf(out, "latency:        p50 %v  p90 %v  p99 %v\n",
+		percentile(latencies, 50).Round(time.Millisecond),
+		percentile(latencies, 90).Round(time.Millisecond),
+		percentile(latencies, 99).Round(time.Millisecond))
+	if len(baselines) > 0 {
+		fmt.Fprintf(out, "baseline:       p50 %v  p90 %v  p99 %v\n",
+			percentile(baselines, 50).Round(time.Millisecond),
+			percentile(baselines, 90).Round(time.Millisecond),
+			percentile(baselines, 99).Round(time.Millisecond))
+	}
+	if compared := len(results) - errorCount; compared > 0 {
+		fmt.Fprintf(out, "outputs:        %d of %d identical, mean similarity %.2f\n",
+			identical, compared, similarity/float64(compared))
+	}
+}
+
+func replayMain(args []string) {
+	if err := runReplay(args, os.Stdout); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Printf("Error: %v\n", err)
+		}
+		os.Exit(1)
+	}
+}
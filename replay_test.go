@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/gjson"
+)
+
+func TestReplay_ParseSpeed(t *testing.T) {
+	for input, want := range map[string]float64{"2x": 2, "0.5X": 0.5, "3": 3, "0": 0} {
+		speed, err := parseReplaySpeed(input)
+		assert.NoError(t, err, input)
+		assert.Equal(t, want, speed, input)
+	}
+	_, err := parseReplaySpeed("fast")
+	assert.Error(t, err)
+	_, err = parseReplaySpeed("-1x")
+	assert.Error(t, err)
+}
+
+func TestReplay_OutputSimilarity(t *testing.T) {
+	assert.Equal(t, 1.0, outputSimilarity("the cat sat", "the cat sat"))
+	assert.Equal(t, 0.0, outputSimilarity("the cat sat", "a dog ran"))
+	assert.InDelta(t, 2.0/3, outputSimilarity("the cat sat down", "the cat stood down here"), 0.001)
+	assert.Equal(t, 1.0, outputSimilarity("", ""))
+}
+
+func TestReplay_Output(t *testing.T) {
+	assert.Equal(t, "hello", replayOutput([]byte(`{"choices":[{"message":{"content":"hello"}}]}`)))
+	assert.Equal(t, "2", replayOutput([]byte(`{"choices":[{"text":"2"}]}`)))
+
+	stream := "data: {\"choices\":[{\"delta\":{\"content\":\"hel\"}}]}\n\n" +
+		"data: {\"choices\":[{\"delta\":{\"content\":\"lo\"}}]}\n\n" +
+		"data: [DONE]\n\n"
+	assert.Equal(t, "hello", replayOutput([]byte(stream)))
+
+	withTools := `{"choices":[{"message":{"content":"","tool_calls":[{"function":{"name":"get_weather","arguments":"{}"}}]}}]}`
+	assert.Equal(t, "\nget_weather{}", replayOutput([]byte(withTools)))
+}
+
+func TestReplay_ReadRecords(t *testing.T) {
+	capture, _ := json.Marshal(map[string]any{
+		"id":          3,
+		"req_path":    "/v1/chat/completions",
+		"req_body":    []byte(`{"model":"llama","messages":[{"role":"user","content":"hi"}]}`),
+		"resp_body":   []byte(`{"choices":[{"message":{"content":"hello"}}]}`),
+		"timestamp":   "2026-01-02T10:00:05Z",
+		"duration_ms": 5000,
+	})
+	input := string(capture) + "\n\n" +
+		`{"messages":[{"role":"user","content":"hi"},{"role":"assistant","content":"hey"}]}` + "\n" +
+		`{"prompt":"1+1=","completion":"2"}` + "\n"
+
+	records, err := readReplayRecords(strings.NewReader(input), "other")
+	assert.NoError(t, err)
+	if assert.Len(t, records, 3) {
+		assert.Equal(t, "/v1/chat/completions", records[0].path)
+		assert.Equal(t, "other", gjson.GetBytes(records[0].body, "model").String())
+		assert.Equal(t, "hello", records[0].baseline)
+		assert.Equal(t, 5*time.Second, records[0].duration)
+		assert.Equal(t, time.Date(2026, 1, 2, 10, 0, 0, 0, time.UTC), records[0].start.UTC())
+
+		assert.Equal(t, 3, records[1].line)
+		assert.JSONEq(t, `{"model":"other","messages":[{"role":"user","content":"hi"}]}`, string(records[1].body))
+		assert.Equal(t, "hey", records[1].baseline)
+
+		assert.Equal(t, "/v1/completions", records[2].path)
+		assert.Equal(t, "2", records[2].baseline)
+	}
+
+	_, err = readReplayRecords(strings.NewReader(`{"prompt":"x"}`), "")
+	assert.ErrorContains(t, err, "line 1: request has no model, use --model")
+
+	_, err = readReplayRecords(strings.NewReader(`{"input":"x"}`), "m")
+	assert.ErrorContains(t, err, "line 1: not a capture or dataset record")
+}
+
+func TestReplay_Run(t *testing.T) {
+	var mu sync.Mutex
+	var models []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		models = append(models, gjson.GetBytes(body, "model").String())
+		mu.Unlock()
+
+		switch r.URL.Path {
+		case "/v1/chat/completions":
+			fmt.Fprint(w, `{"choices":[{"message":{"content":"hello there"}}]}`)
+		default:
+			http.Error(w, "boom", http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	lines := []string{
+		`{"messages":[{"role":"user","content":"hi"},{"role":"assistant","content":"hello there"}]}`,
+		`{"messages":[{"role":"user","content":"hi"},{"role":"assistant","content":"hello world"}]}`,
+		`{"prompt":"1+1=","completion":"2"}`,
+	}
+	path := filepath.Join(t.TempDir(), "capture.jsonl")
+	assert.NoError(t, os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0o644))
+
+	out := &bytes.Buffer{}
+	err := runReplay([]string{path, "--url", srv.URL, "--model", "llama-q8", "--speed", "2x"}, out)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"llama-q8", "llama-q8", "llama-q8"}, models)
+	assert.Contains(t, out.String(), "line 3 /v1/completions: error: status 500: boom")
+	assert.Contains(t, out.String(), "requests:       3 (1 errors)")
+	assert.Contains(t, out.String(), "outputs:        1 of 2 identical, mean similarity 0.75")
+	assert.NotContains(t, out.String(), "baseline:")
+}
+
+func TestReplay_RequiresFile(t *testing.T) {
+	err := runReplay([]string{"--model", "m"}, &bytes.Buffer{})
+	assert.ErrorContains(t, err, "one capture file is required")
+}
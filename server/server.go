@@ -0,0 +1,227 @@
+// Package server embeds llmsnap in other Go programs. A Server is the
+// http.Handler the llmsnap binary serves: it starts, swaps and stops the
+// models of a config on demand and can be reloaded with a new config while
+// it serves requests.
+//
+//	srv, err := server.NewFromFile("config.yaml")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	defer srv.Shutdown()
+//	log.Fatal(srv.ListenAndServe(ctx, ":8080"))
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/napmany/llmsnap/event"
+	"github.com/napmany/llmsnap/proxy"
+	"github.com/napmany/llmsnap/proxy/config"
+)
+
+// Server serves the API, the UI and the models of a config
+type Server struct {
+	mu sync.RWMutex
+	pm *proxy.ProxyManager
+
+	// serializes reloads
+	reloadMu sync.Mutex
+
+	// ports of portRange that reloads keep
+	ports *config.PortAllocator
+
+	configPath string
+	chaos      bool
+	listenAddr string
+	useTLS     bool
+	advertise  bool
+	buildDate  string
+	commit     string
+	version    string
+}
+
+// Option configures a Server
+type Option func(*Server)
+
+// WithConfigFile sets the config file ReloadFile and POST
+// /api/config/reload read. NewFromFile sets it.
+func WithConfigFile(path string) Option {
+	return func(s *Server) {
+		s.configPath = path
+	}
+}
+
+// WithVersion sets the version /api/version reports
+func WithVersion(buildDate, commit, version string) Option {
+	return func(s *Server) {
+		s.buildDate, s.commit, s.version = buildDate, commit, version
+	}
+}
+
+// WithChaos injects the faults of the chaos config, for testing clients
+func WithChaos(active bool) Option {
+	return func(s *Server) {
+		s.chaos = active
+	}
+}
+
+// WithMDNS advertises the server on the local network when the config
+// enables mdns. listenAddr is the address it listens on.
+func WithMDNS(listenAddr string, useTLS bool) Option {
+	return func(s *Server) {
+		s.listenAddr, s.useTLS, s.advertise = listenAddr, useTLS, true
+	}
+}
+
+// New returns a Server for conf. Models are loaded by the first request for
+// them, or on start with hooks.on_startup.preload.
+func New(conf config.Config, opts ...Option) *Server {
+	s := &Server{ports: config.NewPortAllocator()}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	conf.Chaos.Active = s.chaos
+	s.ports.Keep(conf)
+	s.pm = proxy.New(conf)
+	s.pm.SetVersion(s.buildDate, s.commit, s.version)
+	s.setup(s.pm)
+	return s
+}
+
+// NewFromFile returns a Server for the config file at path
+func NewFromFile(path string, opts ...Option) (*Server, error) {
+	conf, err := config.LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	return New(conf, append([]Option{WithConfigFile(path)}, opts...)...), nil
+}
+
+// setup prepares a ProxyManager before it serves requests
+func (s *Server) setup(pm *proxy.ProxyManager) {
+	if s.configPath != "" {
+		pm.SetReloadFunc(s.ReloadFile)
+	}
+	if s.advertise {
+		pm.AdvertiseMDNS(s.listenAddr, s.useTLS)
+	}
+}
+
+// ProxyManager returns the ProxyManager serving requests now. It is replaced
+// by a reload.
+func (s *Server) ProxyManager() *proxy.ProxyManager {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.pm
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.ProxyManager().ServeHTTP(w, r)
+}
+
+// Reload switches to conf. Models that did not change keep running with
+// their requests in flight, the others are stopped.
+func (s *Server) Reload(conf config.Config) {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	conf.Chaos.Active = s.chaos
+	s.ports.Keep(conf)
+	next := s.ProxyManager().Reload(conf)
+	s.setup(next)
+
+	s.mu.Lock()
+	s.pm = next
+	s.mu.Unlock()
+
+	// wait a few seconds and tell any UI to reload
+	time.AfterFunc(3*time.Second, func() {
+		event.Emit(proxy.ConfigFileChangedEvent{
+			ReloadingState: proxy.ReloadingStateEnd,
+		})
+	})
+}
+
+// ReloadFile reads the config file again and reloads it. The server keeps
+// its config when the file is not valid.
+func (s *Server) ReloadFile() error {
+	if s.configPath == "" {
+		return errors.New("no config file to reload")
+	}
+	conf, err := config.LoadConfigWithPorts(s.configPath, s.ports)
+	if err != nil {
+		return err
+	}
+	s.Reload(conf)
+	return nil
+}
+
+// Models returns every model of the config with its state
+func (s *Server) Models() []proxy.Model {
+	return s.ProxyManager().Models()
+}
+
+// RunningModels returns the loaded models
+func (s *Server) RunningModels() []proxy.RunningModel {
+	return s.ProxyManager().RunningModels()
+}
+
+// Metrics returns the recorded requests, oldest first
+func (s *Server) Metrics() []proxy.TokenMetrics {
+	return s.ProxyManager().Metrics()
+}
+
+// LoadModel loads model, a model ID or alias, and returns once it is ready
+func (s *Server) LoadModel(model string) error {
+	return s.ProxyManager().LoadModel(model)
+}
+
+// UnloadModel stops model, a model ID or alias
+func (s *Server) UnloadModel(model string) error {
+	return s.ProxyManager().UnloadModel(model)
+}
+
+// SleepModel puts model, a model ID or alias, to sleep
+func (s *Server) SleepModel(model string) error {
+	return s.ProxyManager().SleepModel(model)
+}
+
+// UnloadAll stops every model
+func (s *Server) UnloadAll() {
+	s.ProxyManager().StopProcesses(proxy.StopImmediately)
+}
+
+// Shutdown stops every model, the Server must not be used after
+func (s *Server) Shutdown() {
+	s.ProxyManager().Shutdown()
+}
+
+// ListenAndServe serves on addr until ctx is done, then it stops the models
+// and gives the requests in flight 5 seconds to complete
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	srv := &http.Server{Addr: addr, Handler: s}
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	s.Shutdown()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/napmany/llmsnap/proxy"
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func simpleResponderPath() string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join("..", "build", "simple-responder.exe")
+	}
+	return filepath.Join("..", "build", fmt.Sprintf("simple-responder_%s_%s", runtime.GOOS, runtime.GOARCH))
+}
+
+func writeConfig(t *testing.T, path, respond string) {
+	t.Helper()
+	content := fmt.Sprintf(`
+logLevel: error
+startPort: 13600
+models:
+  model1:
+    cmd: %s --port ${PORT} --silent --respond %s
+    aliases: [m1]
+`, filepath.ToSlash(simpleResponderPath()), respond)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+}
+
+func TestServer_Embedded(t *testing.T) {
+	if _, err := os.Stat(simpleResponderPath()); err != nil {
+		t.Skip("simple-responder not built")
+	}
+
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, configPath, "first")
+
+	srv, err := NewFromFile(configPath, WithVersion("today", "abcd", "1.0"))
+	require.NoError(t, err)
+	defer srv.Shutdown()
+
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	chat := func() string {
+		resp, err := http.Post(ts.URL+"/v1/chat/completions", "application/json", strings.NewReader(`{"model":"model1"}`))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(body)
+	}
+
+	require.Len(t, srv.Models(), 1)
+	assert.Equal(t, "stopped", srv.Models()[0].State)
+	assert.Error(t, srv.LoadModel("nope"))
+
+	require.NoError(t, srv.LoadModel("m1"))
+	assert.Equal(t, "ready", srv.Models()[0].State)
+	require.Len(t, srv.RunningModels(), 1)
+	assert.Equal(t, proxy.StateReady, srv.RunningModels()[0].State)
+	assert.Contains(t, chat(), "first")
+
+	// the changed model is restarted by the reload
+	writeConfig(t, configPath, "second")
+	before := srv.ProxyManager()
+	require.NoError(t, srv.ReloadFile())
+	assert.NotSame(t, before, srv.ProxyManager())
+	assert.Contains(t, chat(), "second")
+
+	require.NoError(t, srv.UnloadModel("model1"))
+	assert.Empty(t, srv.RunningModels())
+
+	require.NoError(t, os.WriteFile(configPath, []byte("models: ["), 0o644))
+	assert.Error(t, srv.ReloadFile())
+	assert.Len(t, srv.Models(), 1)
+}
+
+func TestServer_ReloadFileWithoutConfigFile(t *testing.T) {
+	srv := New(config.AddDefaultGroupToConfig(config.Config{LogLevel: "error"}))
+	defer srv.Shutdown()
+	assert.EqualError(t, srv.ReloadFile(), "no config file to reload")
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// the first file descriptor systemd passes with socket activation
+const sdListenFdsStart = 3
+
+// systemdListeners returns the sockets systemd passed with socket activation,
+// nil when llmsnap was not started by a .socket unit. The LISTEN_* variables
+// are removed so the upstream processes do not take the sockets for theirs.
+func systemdListeners() ([]net.Listener, error) {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return nil, nil
+	}
+
+	listeners := make([]net.Listener, 0, count)
+	for fd := sdListenFdsStart; fd < sdListenFdsStart+count; fd++ {
+		file := os.NewFile(uintptr(fd), fmt.Sprintf("systemd-socket-%d", fd))
+		// FileListener dups the descriptor, the original is closed so
+		// upstream processes do not inherit it
+		listener, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("systemd socket %d: %w", fd, err)
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
+
+// sdNotify sends state to systemd, like READY=1. It does nothing when
+// llmsnap does not run as a Type=notify service.
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// sdReloading returns the state sent before a reload, Type=notify-reload
+// services need the time of the reload with it
+func sdReloading() string {
+	if usec := monotonicUsec(); usec > 0 {
+		return fmt.Sprintf("RELOADING=1\nMONOTONIC_USEC=%d", usec)
+	}
+	return "RELOADING=1"
+}
+
+// sdWatchdogInterval returns how often systemd expects WATCHDOG=1, 0 when the
+// service has no WatchdogSec
+func sdWatchdogInterval() time.Duration {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// sdWatchdog pings systemd at half the watchdog interval until stop is
+// closed
+func sdWatchdog(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := sdNotify("WATCHDOG=1"); err != nil {
+				fmt.Printf("Warning, systemd watchdog notification failed: %v\n", err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
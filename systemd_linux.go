@@ -0,0 +1,15 @@
+//go:build linux
+
+package main
+
+import "golang.org/x/sys/unix"
+
+// monotonicUsec returns CLOCK_MONOTONIC in microseconds, 0 when it can not
+// be read
+func monotonicUsec() int64 {
+	var ts unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts); err != nil {
+		return 0
+	}
+	return ts.Nano() / 1000
+}
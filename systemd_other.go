@@ -0,0 +1,8 @@
+//go:build !linux
+
+package main
+
+// monotonicUsec returns 0, systemd only runs on linux
+func monotonicUsec() int64 {
+	return 0
+}
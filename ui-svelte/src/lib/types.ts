@@ -1,6 +1,6 @@
 export type ConnectionState = "connected" | "connecting" | "disconnected";
 
-export type ModelStatus = "ready" | "starting" | "stopping" | "stopped" | "shutdown" | "sleepPending" | "asleep" | "waking" | "unknown";
+export type ModelStatus = "ready" | "starting" | "draining" | "stopping" | "stopped" | "shutdown" | "sleepPending" | "asleep" | "waking" | "failed" | "unknown";
 
 export interface Model {
   id: string;
@@ -10,6 +10,27 @@ export interface Model {
   unlisted: boolean;
   peerID: string;
   sleepMode: string;
+  quarantine?: Quarantine;
+  failure?: Failure;
+  instances?: ModelStatus[];
+  inFlight?: number;
+  streamingInFlight?: number;
+}
+
+export interface ModelInFlight {
+  id: string;
+  inFlight: number;
+  streamingInFlight: number;
+}
+
+export interface Quarantine {
+  since: string;
+  reason: string;
+}
+
+export interface Failure {
+  since: string;
+  reason: string;
 }
 
 export interface Metrics {
@@ -23,6 +44,49 @@ export interface Metrics {
   tokens_per_second: number;
   duration_ms: number;
   has_capture: boolean;
+  guardrail?: string;
+  client?: string;
+  tenant?: string;
+  embedding_inputs?: number;
+  embedding_dimensions?: number;
+  request_id?: string;
+  cost?: number;
+  ttft_ms?: number;
+  cache_hit?: boolean;
+}
+
+export interface RequestError {
+  id: number;
+  timestamp: string;
+  model: string;
+  path: string;
+  status: number;
+  duration_ms: number;
+  error: string;
+  client?: string;
+  tenant?: string;
+  request_id?: string;
+}
+
+export type StatsWindow = "hour" | "day" | "week";
+
+export interface ModelStats {
+  model: string;
+  requests: number;
+  errors: number;
+  input_tokens: number;
+  output_tokens: number;
+  total_tokens: number;
+  tokens_per_second: { p50: number; p95: number };
+  avg_duration_ms: number;
+}
+
+export interface StatsReport {
+  window: StatsWindow;
+  since: string;
+  oldest?: string;
+  models: ModelStats[];
+  total: ModelStats;
 }
 
 export interface ReqRespCapture {
@@ -40,7 +104,7 @@ export interface LogData {
 }
 
 export interface APIEventEnvelope {
-  type: "modelStatus" | "logData" | "metrics";
+  type: "modelStatus" | "logData" | "metrics" | "inFlight" | "errors";
   data: string;
 }
 
@@ -1,5 +1,16 @@
 import { writable } from "svelte/store";
-import type { Model, Metrics, VersionInfo, LogData, APIEventEnvelope, ReqRespCapture } from "../lib/types";
+import type {
+  Model,
+  ModelInFlight,
+  Metrics,
+  RequestError,
+  VersionInfo,
+  LogData,
+  APIEventEnvelope,
+  ReqRespCapture,
+  StatsReport,
+  StatsWindow,
+} from "../lib/types";
 import { connectionState } from "./theme";
 
 const LOG_LENGTH_LIMIT = 1024 * 100; /* 100KB of log data */
@@ -9,6 +20,7 @@ export const models = writable<Model[]>([]);
 export const proxyLogs = writable<string>("");
 export const upstreamLogs = writable<string>("");
 export const metrics = writable<Metrics[]>([]);
+export const requestErrors = writable<RequestError[]>([]);
 export const versionInfo = writable<VersionInfo>({
   build_date: "unknown",
   commit: "unknown",
@@ -29,6 +41,7 @@ export function enableAPIEvents(enabled: boolean): void {
     apiEventSource?.close();
     apiEventSource = null;
     metrics.set([]);
+    requestErrors.set([]);
     return;
   }
 
@@ -46,6 +59,7 @@ export function enableAPIEvents(enabled: boolean): void {
       proxyLogs.set("");
       upstreamLogs.set("");
       metrics.set([]);
+      requestErrors.set([]);
       models.set([]);
       retryCount = 0;
       connectionState.set("connected");
@@ -83,6 +97,24 @@ export function enableAPIEvents(enabled: boolean): void {
             metrics.update((prevMetrics) => [...newMetrics, ...prevMetrics]);
             break;
           }
+
+          case "errors": {
+            const newErrors = JSON.parse(message.data) as RequestError[];
+            requestErrors.update((prevErrors) => [...newErrors, ...prevErrors]);
+            break;
+          }
+
+          case "inFlight": {
+            const inFlight = JSON.parse(message.data) as ModelInFlight;
+            models.update((prevModels) =>
+              prevModels.map((model) =>
+                model.id === inFlight.id
+                  ? { ...model, inFlight: inFlight.inFlight, streamingInFlight: inFlight.streamingInFlight }
+                  : model
+              )
+            );
+            break;
+          }
         }
       } catch (err) {
         console.error(e.data, err);
@@ -173,6 +205,34 @@ export async function sleepModel(model: string): Promise<void> {
   }
 }
 
+export async function enableModel(model: string): Promise<void> {
+  try {
+    const response = await fetch(`/api/models/enable/${model}`, {
+      method: "POST",
+    });
+    if (!response.ok) {
+      throw new Error(`Failed to enable model: ${response.status}`);
+    }
+  } catch (error) {
+    console.error("Failed to enable model", model, error);
+    throw error;
+  }
+}
+
+export async function resetModel(model: string): Promise<void> {
+  try {
+    const response = await fetch(`/api/models/reset/${model}`, {
+      method: "POST",
+    });
+    if (!response.ok) {
+      throw new Error(`Failed to reset model: ${response.status}`);
+    }
+  } catch (error) {
+    console.error("Failed to reset model", model, error);
+    throw error;
+  }
+}
+
 export async function loadModel(model: string): Promise<void> {
   try {
     const response = await fetch(`/upstream/${model}/`, {
@@ -202,3 +262,16 @@ export async function getCapture(id: number): Promise<ReqRespCapture | null> {
     return null;
   }
 }
+
+export async function getStats(window: StatsWindow): Promise<StatsReport | null> {
+  try {
+    const response = await fetch(`/api/stats?window=${window}`);
+    if (!response.ok) {
+      throw new Error(`Failed to fetch stats: ${response.status}`);
+    }
+    return await response.json();
+  } catch (error) {
+    console.error("Failed to fetch stats:", error);
+    return null;
+  }
+}
@@ -0,0 +1,431 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/napmany/llmsnap/proxy/config"
+	"gopkg.in/yaml.v3"
+)
+
+// runValidate implements `llmsnap validate`. It loads the config like
+// llmsnap does, which checks the YAML, macros, duplicate aliases and group
+// members, then looks for mistakes that load fine but break at runtime and
+// prints the effective config with all macros expanded and its secrets
+// redacted.
+func runValidate(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	fs.SetOutput(out)
+	fs.Usage = func() {
+		fmt.Fprintln(out, "usage: llmsnap validate [flags]")
+		fs.PrintDefaults()
+	}
+	configPath := fs.String("config", "config.yaml", "config file name")
+	dryRun := fs.Bool("dry-run", false, "also show the processes llmsnap would launch")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected argument %s, use --config", fs.Arg(0))
+	}
+
+	conf, err := config.LoadConfig(*configPath)
+	if err != nil {
+		return fmt.Errorf("%s: %w", *configPath, err)
+	}
+
+	effective, err := effectiveConfigYAML(conf)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "# effective config of %s\n", *configPath)
+	out.Write(effective)
+
+	if *dryRun {
+		fmt.Fprintln(out)
+		writeDryRun(out, conf)
+	}
+
+	fmt.Fprintln(out)
+	problems := configProblems(conf)
+	if len(problems) == 0 {
+		fmt.Fprintf(out, "%s is valid\n", *configPath)
+		return nil
+	}
+	for _, problem := range problems {
+		fmt.Fprintf(out, "problem: %s\n", problem)
+	}
+	if len(problems) == 1 {
+		return fmt.Errorf("1 problem found in %s", *configPath)
+	}
+	return fmt.Errorf("%d problems found in %s", len(problems), *configPath)
+}
+
+// effectiveConfigYAML returns conf as YAML without the settings left at
+// their zero value and with its secrets redacted, see redactSecretsYAML
+func effectiveConfigYAML(conf config.Config) ([]byte, error) {
+	var root yaml.Node
+	if err := root.Encode(conf); err != nil {
+		return nil, err
+	}
+
+	// aliases are not marshalled, they are read with their presets
+	if models := mappingValue(&root, "models"); models != nil {
+		for modelID, modelConfig := range conf.Models {
+			modelNode := mappingValue(models, modelID)
+			if modelNode == nil || len(modelConfig.Aliases) == 0 {
+				continue
+			}
+			var aliases yaml.Node
+			if err := aliases.Encode(modelConfig.Aliases); err != nil {
+				return nil, err
+			}
+			modelNode.Content = append(modelNode.Content,
+				&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "aliases"}, &aliases)
+		}
+	}
+
+	redactSecretsYAML(&root)
+	pruneZeroYAML(&root)
+	return yaml.Marshal(&root)
+}
+
+// redacted takes the place of secrets in the effective config
+const redacted = "<redacted>"
+
+// redactSecretsYAML replaces the API keys, the password of the Redis URL and
+// the values of the env variables of models in the encoded config root, so
+// the output of validate can be shared
+func redactSecretsYAML(root *yaml.Node) {
+	redactScalars(mappingValue(root, "apiKeys"))
+	if peers := mappingValue(root, "peers"); peers != nil && peers.Kind == yaml.MappingNode {
+		for i := 1; i < len(peers.Content); i += 2 {
+			redactScalars(mappingValue(peers.Content[i], "apiKey"))
+		}
+	}
+	if clients := mappingValue(root, "clients"); clients != nil {
+		for _, rule := range clients.Content {
+			redactScalars(mappingValue(rule, "apiKey"))
+		}
+	}
+	redactKeys(mappingValue(mappingValue(root, "clientLimits"), "apiKeys"))
+	redactKeys(mappingValue(mappingValue(root, "scheduler"), "apiKeyPriority"))
+	if redis := mappingValue(mappingValue(root, "sharedState"), "redis"); redis != nil {
+		if u, err := url.Parse(redis.Value); err == nil && u.Scheme != "" {
+			redis.Value = u.Redacted()
+		}
+	}
+
+	if models := mappingValue(root, "models"); models != nil && models.Kind == yaml.MappingNode {
+		for i := 1; i < len(models.Content); i += 2 {
+			model := models.Content[i]
+			redactEnv(mappingValue(model, "env"))
+			redactEnv(mappingValue(mappingValue(model, "draft"), "env"))
+		}
+	}
+}
+
+// redactScalars replaces node when it is a scalar, or the scalars of a
+// sequence
+func redactScalars(node *yaml.Node) {
+	if node == nil {
+		return
+	}
+	switch node.Kind {
+	case yaml.ScalarNode:
+		if node.Value != "" {
+			node.Value = redacted
+		}
+	case yaml.SequenceNode:
+		for _, item := range node.Content {
+			redactScalars(item)
+		}
+	}
+}
+
+// redactKeys numbers the keys of a mapping keyed by secrets, they must stay
+// unique
+func redactKeys(node *yaml.Node) {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i < len(node.Content); i += 2 {
+		node.Content[i].Value = fmt.Sprintf("<redacted %d>", i/2+1)
+	}
+}
+
+// redactEnv keeps the names of a sequence of NAME=value items
+func redactEnv(node *yaml.Node) {
+	if node == nil || node.Kind != yaml.SequenceNode {
+		return
+	}
+	for _, item := range node.Content {
+		if name, _, found := strings.Cut(item.Value, "="); found {
+			item.Value = name + "=" + redacted
+		}
+	}
+}
+
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// pruneZeroYAML drops the mapping entries of node that are zero or empty and
+// reports if node itself is
+func pruneZeroYAML(node *yaml.Node) bool {
+	switch node.Kind {
+	case yaml.MappingNode:
+		content := node.Content[:0]
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if !pruneZeroYAML(node.Content[i+1]) {
+				content = append(content, node.Content[i], node.Content[i+1])
+			}
+		}
+		node.Content = content
+		return len(content) == 0
+	case yaml.SequenceNode:
+		// items are kept, their position may matter
+		for _, item := range node.Content {
+			pruneZeroYAML(item)
+		}
+		return len(node.Content) == 0
+	case yaml.ScalarNode:
+		switch node.Tag {
+		case "!!null":
+			return true
+		case "!!str":
+			return node.Value == ""
+		case "!!int", "!!float":
+			return node.Value == "0"
+		case "!!bool":
+			return node.Value == "false"
+		}
+	}
+	return false
+}
+
+// launchedProcess is a process llmsnap may start for a model
+type launchedProcess struct {
+	id     string
+	group  string
+	model  string
+	config config.ModelConfig
+}
+
+// launchedProcesses returns the processes of the members of every group in
+// the order llmsnap creates them, a model's draft after the model
+func launchedProcesses(conf config.Config) []launchedProcess {
+	var processes []launchedProcess
+	for _, groupID := range sortedKeys(conf.Groups) {
+		for _, member := range conf.Groups[groupID].Members {
+			modelConfig, modelID, found := conf.FindConfig(member)
+			if !found {
+				continue
+			}
+			for i, instanceConfig := range modelConfig.InstanceConfigs() {
+				id := modelID
+				if i > 0 {
+					id = fmt.Sprintf("%s#%d", modelID, i+1)
+				}
+				processes = append(processes, launchedProcess{id: id, group: groupID, model: modelID, config: instanceConfig})
+				if instanceConfig.Draft.Enabled() {
+					processes = append(processes, launchedProcess{id: id + "/draft", group: groupID, model: modelID, config: instanceConfig.DraftModel()})
+				}
+			}
+		}
+	}
+	return processes
+}
+
+// configProblems returns what is wrong with a config that LoadConfig accepts
+func configProblems(conf config.Config) []string {
+	var problems []string
+
+	// group membership
+	for _, groupID := range sortedKeys(conf.Groups) {
+		groupConfig := conf.Groups[groupID]
+		if len(groupConfig.Members) == 0 && groupID != config.DEFAULT_GROUP_ID {
+			problems = append(problems, fmt.Sprintf("group %s has no members", groupID))
+		}
+		for _, member := range groupConfig.Members {
+			realModelID, found := conf.RealModelName(member)
+			switch {
+			case !found:
+				problems = append(problems, fmt.Sprintf("group %s: member %s is not a configured model", groupID, member))
+			case realModelID != member:
+				problems = append(problems, fmt.Sprintf("group %s: member %s is an alias of %s, the model is also put in the %s group", groupID, member, realModelID, config.DEFAULT_GROUP_ID))
+			}
+		}
+	}
+
+	for _, modelID := range sortedKeys(conf.Models) {
+		modelConfig := conf.Models[modelID]
+
+		// an alias with the ID of a model is never used
+		for _, alias := range modelConfig.Aliases {
+			if _, found := conf.Models[alias]; found {
+				problems = append(problems, fmt.Sprintf("model %s: alias %s is the ID of a model, requests for it go to that model", modelID, alias))
+			}
+		}
+
+		// sleep and wake
+		if modelConfig.SleepMode != config.SleepModeEnable {
+			if len(modelConfig.SleepEndpoints) > 0 || len(modelConfig.WakeEndpoints) > 0 {
+				problems = append(problems, fmt.Sprintf("model %s: sleepEndpoints and wakeEndpoints are not used without sleepMode: enable", modelID))
+			}
+			if modelConfig.Schedule.Sleep != "" {
+				problems = append(problems, fmt.Sprintf("model %s: schedule.sleep does nothing without sleepMode: enable", modelID))
+			}
+		}
+	}
+
+	// port collisions between processes that can run at the same time
+	processes := launchedProcesses(conf)
+	for i, a := range processes {
+		addrA := localAddr(a.config)
+		if addrA == "" {
+			continue
+		}
+		for _, b := range processes[i+1:] {
+			if localAddr(b.config) != addrA {
+				continue
+			}
+			if swappedApart(conf, a, b) {
+				continue
+			}
+			problems = append(problems, fmt.Sprintf("port collision: %s and %s both use %s", a.id, b.id, addrA))
+		}
+	}
+
+	return problems
+}
+
+// swappedApart reports if a and b are never running at the same time: they
+// are different members of a swap group that neither requires the other and
+// that do not fit in the group's weight budget together
+func swappedApart(conf config.Config, a, b launchedProcess) bool {
+	groupConfig := conf.Groups[a.group]
+	if a.group != b.group || a.model == b.model || !groupConfig.Swap {
+		return false
+	}
+	if slices.Contains(conf.Requirements(a.model), b.model) || slices.Contains(conf.Requirements(b.model), a.model) {
+		return false
+	}
+	if groupConfig.WeightBudget > 0 {
+		return groupConfig.MemberWeight(conf.Models[a.model])+groupConfig.MemberWeight(conf.Models[b.model]) > groupConfig.WeightBudget
+	}
+	return true
+}
+
+// localAddr returns the host:port the process of modelConfig listens on, ""
+// when llmsnap does not start it or it is not on this host
+func localAddr(modelConfig config.ModelConfig) string {
+	if strings.TrimSpace(modelConfig.Cmd) == "" {
+		return ""
+	}
+	u, err := url.Parse(modelConfig.Proxy)
+	if err != nil {
+		return ""
+	}
+	switch u.Hostname() {
+	case "localhost", "127.0.0.1", "::1", "0.0.0.0", "":
+	default:
+		return ""
+	}
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return "localhost:" + port
+}
+
+// writeDryRun shows the processes llmsnap would launch, per group, and which
+// of them are loaded on startup
+func writeDryRun(out io.Writer, conf config.Config) {
+	fmt.Fprintln(out, "# dry run")
+	if preload := conf.Hooks.OnStartup.Preload; len(preload) > 0 {
+		fmt.Fprintf(out, "on startup: loads %s\n", strings.Join(preload, ", "))
+	} else {
+		fmt.Fprintln(out, "on startup: nothing is loaded, models start on their first request")
+	}
+
+	processes := launchedProcesses(conf)
+	for _, groupID := range sortedKeys(conf.Groups) {
+		groupConfig := conf.Groups[groupID]
+		if len(groupConfig.Members) == 0 {
+			continue
+		}
+
+		behavior := []string{"members run together"}
+		if groupConfig.Swap && groupConfig.WeightBudget > 0 {
+			behavior = []string{fmt.Sprintf("members run together within a weight budget of %d", groupConfig.WeightBudget)}
+		} else if groupConfig.Swap {
+			behavior = []string{"one member runs at a time"}
+		}
+		if groupConfig.Exclusive {
+			behavior = append(behavior, "unloads the other groups when a member starts")
+		}
+		if groupConfig.Persistent {
+			behavior = append(behavior, "is not unloaded by other groups")
+		}
+		fmt.Fprintf(out, "\ngroup %s: %s\n", groupID, strings.Join(behavior, ", "))
+
+		for _, process := range processes {
+			if process.group != groupID {
+				continue
+			}
+			command := "(no cmd)"
+			if args, err := process.config.SanitizedCommand(); err == nil && len(args) > 0 {
+				command = strings.Join(args, " ")
+			}
+			notes := ""
+			if process.config.SleepMode == config.SleepModeEnable && groupConfig.OnEvict != config.EvictStop {
+				notes += ", sleeps instead of stopping"
+			}
+			if process.config.UnloadAfter > 0 {
+				notes += fmt.Sprintf(", unloaded after %ds idle", process.config.UnloadAfter)
+			}
+			if slices.Contains(conf.Hooks.OnStartup.Preload, process.model) {
+				notes += ", loaded on startup"
+			}
+			fmt.Fprintf(out, "  %s -> %s%s\n    %s\n", process.id, process.config.Proxy, notes, command)
+		}
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func validateMain(args []string) {
+	if err := runValidate(args, os.Stdout); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Printf("Error: %v\n", err)
+		}
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/napmany/llmsnap/proxy/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeValidateConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestValidate_Problems(t *testing.T) {
+	conf, err := config.LoadConfigFromReader(strings.NewReader(`
+models:
+  a:
+    cmd: server --port ${PORT}
+    aliases: [x]
+    sleepEndpoints:
+      - endpoint: /sleep
+  b:
+    cmd: server --port 9000
+    proxy: http://localhost:9000
+  c:
+    cmd: server --port 9000
+    proxy: http://127.0.0.1:9000
+    aliases: [b]
+  d:
+    cmd: server --port 9000
+    proxy: http://localhost:9000
+  remote:
+    cmd: ssh gpu-box server --port 9000
+    proxy: http://gpu-box:9000
+  e:
+    cmd: server --port 9100
+    proxy: http://localhost:9100
+    weight: 1
+  f:
+    cmd: server --port 9100
+    proxy: http://localhost:9100
+    weight: 1
+  g:
+    cmd: server --port 9200
+    proxy: http://localhost:9200
+    weight: 2
+  h:
+    cmd: server --port 9200
+    proxy: http://localhost:9200
+    weight: 1
+groups:
+  swapped:
+    swap: true
+    members: [b, c, remote]
+  together:
+    swap: false
+    members: [d, x, nope]
+  fits:
+    swap: true
+    weightBudget: 2
+    members: [e, f]
+  full:
+    swap: true
+    weightBudget: 2
+    members: [g, h]
+`))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, []string{
+		"group together: member x is an alias of a, the model is also put in the (default) group",
+		"group together: member nope is not a configured model",
+		"model a: sleepEndpoints and wakeEndpoints are not used without sleepMode: enable",
+		"model c: alias b is the ID of a model, requests for it go to that model",
+		"port collision: a and a both use localhost:5800",
+		"port collision: e and f both use localhost:9100",
+		"port collision: b and d both use localhost:9000",
+		"port collision: c and d both use localhost:9000",
+	}, configProblems(conf))
+}
+
+func TestValidate_Run(t *testing.T) {
+	path := writeValidateConfig(t, `
+macros:
+  ctx: "--ctx-size 4096"
+hooks:
+  on_startup:
+    preload: [model1]
+models:
+  model1:
+    cmd: server --port ${PORT} ${ctx}
+    aliases: [m1]
+  model2:
+    cmd: server --port ${PORT}
+    ttl: 60
+`)
+
+	var out bytes.Buffer
+	assert.NoError(t, runValidate([]string{"--config", path, "--dry-run"}, &out))
+	output := out.String()
+
+	// the effective config has the macros expanded and the aliases
+	assert.Contains(t, output, "cmd: server --port 5800 --ctx-size 4096")
+	assert.Contains(t, output, "aliases:\n            - m1")
+	assert.NotContains(t, output, "unlisted: false")
+
+	assert.Contains(t, output, "on startup: loads model1")
+	assert.Contains(t, output, "group (default): one member runs at a time")
+	assert.Contains(t, output, "model1 -> http://localhost:5800, loaded on startup\n    server --port 5800 --ctx-size 4096")
+	assert.Contains(t, output, "model2 -> http://localhost:5801, unloaded after 60s idle")
+	assert.Contains(t, output, "is valid")
+
+	t.Run("secrets are redacted", func(t *testing.T) {
+		path := writeValidateConfig(t, `
+apiKeys: [sk-top-secret]
+clientLimits:
+  apiKeys:
+    sk-limited: 2
+peers:
+  remote:
+    proxy: http://gpu-box:8080
+    apiKey: sk-peer-secret
+    models: [llama]
+models:
+  model1:
+    cmd: server --port ${PORT}
+    env: [HF_TOKEN=hf-secret, CUDA_VISIBLE_DEVICES=0]
+`)
+		var out bytes.Buffer
+		assert.NoError(t, runValidate([]string{"--config", path}, &out))
+		output := out.String()
+		for _, secret := range []string{"sk-top-secret", "sk-limited", "sk-peer-secret", "hf-secret"} {
+			assert.NotContains(t, output, secret)
+		}
+		assert.Contains(t, output, "HF_TOKEN=<redacted>")
+		assert.Contains(t, output, "CUDA_VISIBLE_DEVICES=<redacted>")
+		assert.Contains(t, output, "<redacted 1>: 2")
+	})
+
+	t.Run("problems fail", func(t *testing.T) {
+		path := writeValidateConfig(t, `
+models:
+  model1:
+    cmd: server --port ${PORT}
+groups:
+  g:
+    members: [model1, missing]
+`)
+		var out bytes.Buffer
+		err := runValidate([]string{"--config", path}, &out)
+		assert.ErrorContains(t, err, "1 problem found")
+		assert.Contains(t, out.String(), "problem: group g: member missing is not a configured model")
+	})
+
+	t.Run("load errors", func(t *testing.T) {
+		path := writeValidateConfig(t, "models:\n  model1:\n    cmd: server ${unknown}\n")
+		err := runValidate([]string{"--config", path}, &bytes.Buffer{})
+		assert.ErrorContains(t, err, path)
+	})
+}